@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/pennsieve/rehydration-service/service/ecs"
+	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/service/queue"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/awsconfig"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/lambdautils"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// awsConfigFactory so that one could set the AWS config in a test using dynamodb-local before calling DispatcherHandler.
+var awsConfigFactory = awsconfig.NewFactory()
+var logger = logging.Default
+
+// ecsHandlerFactory and queueFactory can be replaced in tests so that neither ECS nor SQS is required.
+var ecsHandlerFactory = ecs.NewHandler
+var queueFactory = queue.NewSQSQueue
+
+// dispatcher is the queue.Dispatcher that starts queued rehydrations when there is room for them.
+//
+// Tests of the DispatcherHandler can set this value before calling the function if they require it to use mocks for one
+// of queue.Dispatcher's dependencies.
+var dispatcher *queue.Dispatcher
+
+// DispatcherHandler runs on a schedule to start queued rehydrations as running ones finish, and to start again the
+// rehydrations whose tasks exited to wait for archived files to be restored. The service Lambda also dispatches after
+// queueing a request, so this is only needed to pick up capacity freed by finished rehydrations and finished restores.
+func DispatcherHandler(ctx context.Context, lambdaRequest events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if err := initializeDispatcher(ctx); err != nil {
+		logger.Error("error initializing dispatcher", slog.Any("error", err))
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+
+	if _, err := dispatcher.Dispatch(ctx); err != nil {
+		logger.Error("error dispatching queued rehydrations", slog.Any("error", err))
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+
+	return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusNoContent}, nil
+}
+
+// initializeDispatcher if the package var dispatcher is nil, creates a new queue.Dispatcher and sets
+// dispatcher to that value.
+//
+// If dispatcher is not nil, immediately returns. Allows tests to set dispatcher created with mocks.
+func initializeDispatcher(ctx context.Context) error {
+	if dispatcher != nil {
+		return nil
+	}
+	queueConfig, err := queue.ConfigFromEnvironment()
+	if err != nil {
+		return err
+	}
+	if queueConfig == nil {
+		return fmt.Errorf("%s is not set; rehydrations are not being queued", queue.MaxConcurrentKey)
+	}
+	taskConfig, err := models.TaskConfigFromEnvironment()
+	if err != nil {
+		return err
+	}
+	taskConfig.ResumeRestores = true
+	awsConfig, err := awsConfigFactory.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting AWS config: %w", err)
+	}
+	auditStore, err := audit.StoreFromLookup(os.LookupEnv, *awsConfig, logger)
+	if err != nil {
+		return err
+	}
+	idempotencyStore := idempotency.NewStore(dynamodb.NewFromConfig(*awsConfig), logger, taskConfig.IdempotencyTableName)
+	dispatcher = queue.NewDispatcher(queueFactory(*awsConfig, queueConfig),
+		idempotencyStore,
+		ecsHandlerFactory(*awsConfig, taskConfig),
+		queueConfig.MaxConcurrent,
+		logger)
+	dispatcher.SetAudit(audit.NewRecorder(auditStore, audit.DispatcherSource, logger))
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/pennsieve/rehydration-service/service/ecs"
+	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/service/queue"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/notification"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"testing"
+)
+
+var testIdempotencyTableName = "test-rehydration-idempotency-table"
+var testEnvVars = test.NewEnvironmentVariables().
+	With(queue.MaxConcurrentKey, "2").
+	With(queue.URLKey(queue.High), "test-high-queue-url").
+	With(queue.URLKey(queue.Normal), "test-normal-queue-url").
+	With(queue.URLKey(queue.Low), "test-low-queue-url").
+	With("TASK_DEF_ARN", "test-ecs-task-definition-arn").
+	With("SUBNET_IDS", "test-subnet-1, test-subnet-2").
+	With("CLUSTER_ARN", "test-cluster-arn").
+	With("SECURITY_GROUP", "test-sg").
+	With("TASK_DEF_CONTAINER_NAME", "test-rehydrate-fargate-container").
+	With(idempotency.TableNameKey, testIdempotencyTableName).
+	With(tracking.TableNameKey, "test-rehydration-tracking-table").
+	With(notification.PennsieveDomainKey, "pennsieve.example.com")
+
+func TestDispatcherHandler(t *testing.T) {
+	testEnvVars.Setenv(t)
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	awsConfigFactory.Set(&awsConfig)
+	defer awsConfigFactory.Set(nil)
+
+	rehydrationQueue := queue.NewMemoryQueue()
+	originalQueueFactory := queueFactory
+	queueFactory = func(_ aws.Config, _ *queue.Config) queue.Queue { return rehydrationQueue }
+	defer func() { queueFactory = originalQueueFactory }()
+
+	ecsHandler := &fakeECSHandler{}
+	originalECSHandlerFactory := ecsHandlerFactory
+	ecsHandlerFactory = func(_ aws.Config, _ *models.ECSTaskConfig) ecs.Handler { return ecsHandler }
+	defer func() { ecsHandlerFactory = originalECSHandlerFactory }()
+	defer func() { dispatcher = nil }()
+
+	inProgress := idempotency.NewRecord("10/1/", idempotency.InProgress).WithFargateTaskARN("task-10")
+	lowQueued := idempotency.NewRecord("11/1/", idempotency.Queued)
+	highQueued := idempotency.NewRecord("12/1/", idempotency.Queued)
+	_, err := rehydrationQueue.Enqueue(ctx, queue.Message{Dataset: sharedmodels.Dataset{ID: 11, VersionID: 1}, Priority: queue.Low})
+	require.NoError(t, err)
+	_, err = rehydrationQueue.Enqueue(ctx, queue.Message{Dataset: sharedmodels.Dataset{ID: 12, VersionID: 1}, Priority: queue.High})
+	require.NoError(t, err)
+
+	dyDBFixture := test.NewDynamoDBFixture(t, awsConfig, test.IdempotencyCreateTableInput(testIdempotencyTableName)).
+		WithItems(test.ItemersToPutItemInputs(t, testIdempotencyTableName, inProgress, lowQueued, highQueued)...)
+	defer dyDBFixture.Teardown()
+
+	// One rehydration is already in progress, so there is only room for the high priority one
+	resp, err := DispatcherHandler(ctx, events.APIGatewayV2HTTPRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, []int{12}, ecsHandler.started)
+	assert.Equal(t, 1, rehydrationQueue.Len())
+
+	expectedStatuses := map[string]idempotency.Status{
+		inProgress.ID: idempotency.InProgress,
+		lowQueued.ID:  idempotency.Queued,
+		highQueued.ID: idempotency.InProgress,
+	}
+	for _, item := range dyDBFixture.Scan(ctx, testIdempotencyTableName) {
+		actual, err := idempotency.FromItem(item)
+		require.NoError(t, err)
+		assert.Equal(t, expectedStatuses[actual.ID], actual.Status, actual.ID)
+		if actual.ID == highQueued.ID {
+			assert.Equal(t, "task-12", actual.FargateTaskARN)
+		}
+	}
+
+	// No room now
+	resp, err = DispatcherHandler(ctx, events.APIGatewayV2HTTPRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, []int{12}, ecsHandler.started)
+	assert.Equal(t, 1, rehydrationQueue.Len())
+}
+
+func TestDispatcherHandler_NotQueueing(t *testing.T) {
+	test.NewEnvironmentVariables().With("TASK_DEF_ARN", "test-ecs-task-definition-arn").Setenv(t)
+
+	resp, err := DispatcherHandler(context.Background(), events.APIGatewayV2HTTPRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Contains(t, resp.Body, queue.MaxConcurrentKey)
+	assert.Nil(t, dispatcher)
+}
+
+type fakeECSHandler struct {
+	started []int
+}
+
+func (h *fakeECSHandler) Handle(_ context.Context, dataset sharedmodels.Dataset, _ sharedmodels.User, _ *sharedmodels.Destination, _ string, _ *slog.Logger) (string, error) {
+	h.started = append(h.started, dataset.ID)
+	return fmt.Sprintf("task-%d", dataset.ID), nil
+}
+
+func (h *fakeECSHandler) Status(_ context.Context, _ string) (*ecs.JobStatus, error) {
+	return nil, fmt.Errorf("not implemented")
+}
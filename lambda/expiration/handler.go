@@ -7,14 +7,19 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/audit"
 	"github.com/pennsieve/rehydration-service/shared/awsconfig"
+	"github.com/pennsieve/rehydration-service/shared/deleteretry"
 	"github.com/pennsieve/rehydration-service/shared/expiration"
 	"github.com/pennsieve/rehydration-service/shared/idempotency"
 	"github.com/pennsieve/rehydration-service/shared/lambdautils"
 	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
+	"github.com/pennsieve/rehydration-service/shared/regions"
 	"github.com/pennsieve/rehydration-service/shared/s3cleaner"
 	"log/slog"
 	"net/http"
+	"os"
 )
 
 // awsConfigFactory so that one could set the AWS config in a test using MinIO and dynamodb-local before calling ExpirationHandler.
@@ -58,12 +63,57 @@ func initializeHandler(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	idempotencyStore := idempotency.NewStore(dynamodb.NewFromConfig(*awsConfig), logger, idempotencyTable)
-	s3Cleaner, err := s3cleaner.NewCleaner(s3.NewFromConfig(*awsConfig), s3cleaner.MaxCleanBatch)
+	dyDBClient := dynamodb.NewFromConfig(*awsConfig)
+	idempotencyStore := idempotency.NewStore(dyDBClient, logger, idempotencyTable)
+	var retryStore deleteretry.Store
+	if retryTable := os.Getenv(deleteretry.TableNameKey); len(retryTable) > 0 {
+		retryStore = deleteretry.NewStore(dyDBClient, logger, retryTable)
+	}
+	buckets, err := regions.FromLookup(os.LookupEnv)
 	if err != nil {
-		return fmt.Errorf("error creating S3 cleaner: %w", err)
+		return err
 	}
+	defaultBucket, err := shared.NonEmptyFromEnvVar(shared.RehydrationBucketKey)
+	if err != nil {
+		return err
+	}
+	maxObjects, err := s3cleaner.MaxObjectsFromLookup(os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	auditStore, err := audit.StoreFromLookup(os.LookupEnv, *awsConfig, logger)
+	if err != nil {
+		return err
+	}
+	// only rehydration locations in our own buckets are ever deleted, whatever a record's location says
+	guard := s3cleaner.NewGuard(buckets.All(defaultBucket), "", maxObjects, logger)
+	// rehydrations may be in any of the configured regions, so each one is cleaned with a client for its bucket's region
+	s3Cleaner := s3cleaner.NewRegionalCleaner(buckets, func(region string) (s3cleaner.Cleaner, error) {
+		cleaner, err := s3cleaner.NewCleaner(s3.NewFromConfig(*awsConfig, func(o *s3.Options) {
+			o.Region = region
+		}), s3cleaner.MaxCleanBatch)
+		if err != nil {
+			return nil, err
+		}
+		cleaner.SetProgress(logCleanProgress)
+		cleaner.SetGuard(guard)
+		return cleaner, nil
+	})
 
-	handler = expiration.NewHandler(idempotencyStore, s3Cleaner, logger)
+	handler = expiration.NewHandler(idempotencyStore, retryStore, s3Cleaner, logger,
+		metrics.Default.With(metrics.Dimensions{metrics.ComponentDimension: "expiration"}),
+		audit.NewRecorder(auditStore, audit.ExpirationSource, logger))
 	return nil
 }
+
+// logCleanProgress logs how far the deletion of an expired rehydration has got, so that a clean of a very large one
+// that runs out of time shows where it stopped
+func logCleanProgress(progress s3cleaner.CleanProgress) {
+	logger.Debug("cleaning rehydration location",
+		slog.String("bucket", progress.Bucket),
+		slog.String("prefix", progress.KeyPrefix),
+		slog.Bool("listing", progress.Listing),
+		slog.Int("listed", progress.Listed),
+		slog.Int("deleted", progress.Deleted),
+		slog.Int("failed", progress.Failed))
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
+	"github.com/pennsieve/rehydration-service/shared"
 	"github.com/pennsieve/rehydration-service/shared/idempotency"
 	"github.com/pennsieve/rehydration-service/shared/test"
 	"github.com/stretchr/testify/assert"
@@ -17,12 +18,16 @@ import (
 )
 
 var testIdempotencyTableName = "test-rehydration-idempotency-table"
-var testEnvVars = test.NewEnvironmentVariables().With(idempotency.TableNameKey, testIdempotencyTableName)
+var testRehydrationBucket = "rehydration-test-bucket"
+var testEnvVars = test.NewEnvironmentVariables().
+	With(idempotency.TableNameKey, testIdempotencyTableName).
+	With(shared.AWSRegionKey, "us-east-1").
+	With(shared.RehydrationBucketKey, testRehydrationBucket)
 
 func TestExpirationHandler(t *testing.T) {
 	testEnvVars.Setenv(t)
 
-	bucket := "rehydration-test-bucket"
+	bucket := testRehydrationBucket
 	prefixToExpire := "43/1/"
 	prefixToKeep := "43/11/"
 	ctx := context.Background()
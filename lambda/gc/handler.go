@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/awsconfig"
+	"github.com/pennsieve/rehydration-service/shared/gc"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/lambdautils"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
+	"github.com/pennsieve/rehydration-service/shared/regions"
+	"github.com/pennsieve/rehydration-service/shared/s3cleaner"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// awsConfigFactory so that one could set the AWS config in a test using MinIO and dynamodb-local before calling GCHandler.
+var awsConfigFactory = awsconfig.NewFactory()
+var logger = logging.Default
+
+// GCHandler removes orphaned rehydration files and stale multipart uploads from the default rehydration bucket and
+// from the rehydration bucket of each configured region
+func GCHandler(ctx context.Context, lambdaRequest events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if err := collect(ctx); err != nil {
+		logger.Error("error running garbage collection", slog.Any("error", err))
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusNoContent}, nil
+}
+
+func collect(ctx context.Context) error {
+	awsConfig, err := awsConfigFactory.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting AWS config: %w", err)
+	}
+	idempotencyTable, err := shared.NonEmptyFromEnvVar(idempotency.TableNameKey)
+	if err != nil {
+		return err
+	}
+	defaultBucket, err := shared.NonEmptyFromEnvVar(shared.RehydrationBucketKey)
+	if err != nil {
+		return err
+	}
+	buckets, err := regions.FromLookup(os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	gracePeriod, err := gc.GracePeriodFromLookup(os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	s3Client := func(region string) *s3.Client {
+		return s3.NewFromConfig(*awsConfig, func(o *s3.Options) {
+			o.Region = region
+		})
+	}
+	maxObjects, err := s3cleaner.MaxObjectsFromLookup(os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	guard := s3cleaner.NewGuard(buckets.All(defaultBucket), "", maxObjects, logger)
+	cleaner := s3cleaner.NewRegionalCleaner(buckets, func(region string) (s3cleaner.Cleaner, error) {
+		cleaner, err := s3cleaner.NewCleaner(s3Client(region), s3cleaner.MaxCleanBatch)
+		if err != nil {
+			return nil, err
+		}
+		cleaner.SetGuard(guard)
+		return cleaner, nil
+	})
+	idempotencyStore := idempotency.NewStore(dynamodb.NewFromConfig(*awsConfig), logger, idempotencyTable)
+	collector := gc.NewCollector(idempotencyStore, cleaner, gracePeriod, logger, metrics.Default.With(metrics.Dimensions{metrics.ComponentDimension: "gc"}))
+
+	var errs []error
+	for bucket, region := range bucketRegions(defaultBucket, buckets) {
+		if _, err := collector.Collect(ctx, s3Client(region), bucket); err != nil {
+			errs = append(errs, fmt.Errorf("error collecting garbage from %s: %w", bucket, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// bucketRegions returns the region of each rehydration bucket
+func bucketRegions(defaultBucket string, buckets *regions.Buckets) map[string]string {
+	bucketRegions := map[string]string{defaultBucket: buckets.DefaultRegion}
+	for _, region := range buckets.Regions() {
+		bucket, _ := buckets.Bucket(region)
+		bucketRegions[bucket] = region
+	}
+	return bucketRegions
+}
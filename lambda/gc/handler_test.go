@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/regions"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+var testIdempotencyTableName = "test-rehydration-idempotency-table"
+var testBucket = "rehydration-gc-test-bucket"
+var testEnvVars = test.NewEnvironmentVariables().
+	With(idempotency.TableNameKey, testIdempotencyTableName).
+	With(shared.AWSRegionKey, "us-east-1").
+	With(shared.RehydrationBucketKey, testBucket)
+
+func TestGCHandler(t *testing.T) {
+	testEnvVars.Setenv(t)
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().WithDynamoDB().Config(ctx, false)
+	awsConfigFactory.Set(&awsConfig)
+	defer awsConfigFactory.Set(nil)
+
+	recordedPrefix := "43/1/"
+	// no record, but too recent to be collected, since a rehydration may have just been written
+	recentOrphanPrefix := "43/2/"
+	putObjectInputs := append(test.GeneratePutObjectInputs(testBucket, recordedPrefix, 5),
+		test.GeneratePutObjectInputs(testBucket, recentOrphanPrefix, 5)...)
+	s3Fixture, _ := test.NewS3Fixture(t, s3.NewFromConfig(awsConfig), &s3.CreateBucketInput{
+		Bucket: aws.String(testBucket),
+	}).WithObjects(putObjectInputs...)
+	defer s3Fixture.Teardown()
+
+	record := idempotency.NewRecord(idempotency.RecordID(43, 1), idempotency.Completed).
+		WithRehydrationLocation(fmt.Sprintf("s3://%s/%s", testBucket, recordedPrefix))
+	dyDBFixture := test.NewDynamoDBFixture(t, awsConfig, test.IdempotencyCreateTableInput(testIdempotencyTableName)).
+		WithItems(test.ItemersToPutItemInputs(t, testIdempotencyTableName, record)...)
+	defer dyDBFixture.Teardown()
+
+	resp, err := GCHandler(ctx, events.APIGatewayV2HTTPRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	for _, kept := range putObjectInputs {
+		assert.True(t, s3Fixture.ObjectExists(testBucket, aws.ToString(kept.Key)))
+	}
+}
+
+func TestBucketRegions(t *testing.T) {
+	buckets := regions.NewBuckets("us-east-1", map[string]string{"eu-west-1": "rehydration-eu"})
+	assert.Equal(t, map[string]string{
+		"rehydration-default": "us-east-1",
+		"rehydration-eu":      "eu-west-1",
+	}, bucketRegions("rehydration-default", buckets))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/lambdautils"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// DatasetIDParameter and VersionIDParameter, or UserEmailParameter, make a request an audit query instead of a
+// usage report
+const DatasetIDParameter = "datasetId"
+const VersionIDParameter = "versionId"
+const UserEmailParameter = "userEmail"
+
+type AuditOutput struct {
+	Events []audit.Event `json:"events"`
+}
+
+// auditQuery is the dataset version or user email whose audit events were asked for
+type auditQuery struct {
+	dataset   *models.Dataset
+	userEmail string
+}
+
+func isAuditQuery(lambdaRequest events.APIGatewayV2HTTPRequest) bool {
+	for _, parameter := range []string{DatasetIDParameter, VersionIDParameter, UserEmailParameter} {
+		if _, ok := lambdaRequest.QueryStringParameters[parameter]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func auditQueryFromRequest(lambdaRequest events.APIGatewayV2HTTPRequest) (*auditQuery, error) {
+	parameters := lambdaRequest.QueryStringParameters
+	datasetID, hasDatasetID := parameters[DatasetIDParameter]
+	versionID, hasVersionID := parameters[VersionIDParameter]
+	userEmail, hasUserEmail := parameters[UserEmailParameter]
+	if hasUserEmail {
+		if hasDatasetID || hasVersionID {
+			return nil, fmt.Errorf("%s cannot be combined with %s or %s", UserEmailParameter, DatasetIDParameter, VersionIDParameter)
+		}
+		if len(userEmail) == 0 {
+			return nil, fmt.Errorf("empty %s", UserEmailParameter)
+		}
+		return &auditQuery{userEmail: userEmail}, nil
+	}
+	if !hasDatasetID || !hasVersionID {
+		return nil, fmt.Errorf("both %s and %s are required", DatasetIDParameter, VersionIDParameter)
+	}
+	dataset := &models.Dataset{}
+	var err error
+	if dataset.ID, err = strconv.Atoi(datasetID); err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", DatasetIDParameter, datasetID, err)
+	}
+	if dataset.VersionID, err = strconv.Atoi(versionID); err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", VersionIDParameter, versionID, err)
+	}
+	return &auditQuery{dataset: dataset}, nil
+}
+
+// AuditHandler responds with the audit events of a dataset version or of a user, oldest first
+func AuditHandler(ctx context.Context, lambdaRequest events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	query, err := auditQueryFromRequest(lambdaRequest)
+	if err != nil {
+		logger.Error("invalid audit query", slog.Any("error", err))
+		return lambdautils.ErrorResponse(http.StatusBadRequest, err, lambdaRequest)
+	}
+	eventList, err := queryAudit(ctx, query)
+	if err != nil {
+		logger.Error("error querying audit events", slog.Any("error", err))
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	respBody, err := json.Marshal(AuditOutput{Events: eventList})
+	if err != nil {
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(respBody),
+	}, nil
+}
+
+func queryAudit(ctx context.Context, query *auditQuery) ([]audit.Event, error) {
+	awsConfig, err := awsConfigFactory.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting AWS config: %w", err)
+	}
+	store, err := audit.StoreFromLookup(os.LookupEnv, *awsConfig, logger)
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, errors.New("rehydrations are not being audited")
+	}
+	var eventList []audit.Event
+	if query.dataset != nil {
+		eventList, err = store.QueryByDatasetVersion(ctx, *query.dataset)
+	} else {
+		eventList, err = store.QueryByUserEmail(ctx, query.userEmail)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// so that the response has an empty list rather than null
+	if eventList == nil {
+		eventList = []audit.Event{}
+	}
+	return eventList, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+var testAuditBucket = "test-rehydration-audit-bucket"
+
+func TestReportHandler_Audit(t *testing.T) {
+	test.NewEnvironmentVariables().With(audit.BucketKey, testAuditBucket).Setenv(t)
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	awsConfigFactory.Set(&awsConfig)
+	defer awsConfigFactory.Set(nil)
+
+	s3Client := s3.NewFromConfig(awsConfig)
+	s3Fixture := test.NewS3Fixture(t, s3Client, &s3.CreateBucketInput{Bucket: aws.String(testAuditBucket)})
+	defer s3Fixture.Teardown()
+
+	user := models.User{Name: "First User", Email: "first@example.com"}
+	dataset := models.Dataset{ID: 1234, VersionID: 5}
+	recordID := idempotency.RecordID(dataset.ID, dataset.VersionID)
+	recorder := audit.NewRecorder(audit.NewS3Store(s3Client, logging.Default, testAuditBucket), audit.ServiceSource, logging.Default)
+	// recorded separately so that the events have different times
+	recorder.Record(ctx, audit.IdempotencyEvent(recordID, "", idempotency.InProgress).WithUser(user))
+	recorder.Record(ctx, audit.IdempotencyEvent(idempotency.RecordID(dataset.ID, 6), "", idempotency.Queued).WithUser(user))
+
+	for name, tst := range map[string]struct {
+		parameters        map[string]string
+		expectedSubjectID []string
+	}{
+		"dataset version": {
+			parameters:        map[string]string{DatasetIDParameter: strconv.Itoa(dataset.ID), VersionIDParameter: strconv.Itoa(dataset.VersionID)},
+			expectedSubjectID: []string{recordID},
+		},
+		"user email": {
+			parameters:        map[string]string{UserEmailParameter: user.Email},
+			expectedSubjectID: []string{recordID, idempotency.RecordID(dataset.ID, 6)},
+		},
+		"no events": {
+			parameters: map[string]string{UserEmailParameter: "nobody@example.com"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			response, err := ReportHandler(ctx, events.APIGatewayV2HTTPRequest{QueryStringParameters: tst.parameters})
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, response.StatusCode, response.Body)
+
+			var out AuditOutput
+			require.NoError(t, json.Unmarshal([]byte(response.Body), &out))
+			require.NotNil(t, out.Events)
+			var subjectIDs []string
+			for _, event := range out.Events {
+				subjectIDs = append(subjectIDs, event.SubjectID)
+				assert.Equal(t, audit.ServiceSource, event.Source)
+				assert.Equal(t, user.Email, event.UserEmail)
+			}
+			assert.Equal(t, tst.expectedSubjectID, subjectIDs)
+		})
+	}
+}
+
+func TestReportHandler_BadAuditQuery(t *testing.T) {
+	for name, parameters := range map[string]map[string]string{
+		"missing version":  {DatasetIDParameter: "1234"},
+		"missing dataset":  {VersionIDParameter: "5"},
+		"bad dataset":      {DatasetIDParameter: "abc", VersionIDParameter: "5"},
+		"bad version":      {DatasetIDParameter: "1234", VersionIDParameter: "v5"},
+		"empty user email": {UserEmailParameter: ""},
+		"user and dataset": {UserEmailParameter: "first@example.com", DatasetIDParameter: "1234", VersionIDParameter: "5"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			response, err := ReportHandler(context.Background(), events.APIGatewayV2HTTPRequest{QueryStringParameters: parameters})
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+		})
+	}
+}
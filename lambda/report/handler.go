@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/awsconfig"
+	"github.com/pennsieve/rehydration-service/shared/lambdautils"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const BucketKey = "REPORT_BUCKET"
+const RequestPricePer1000Key = "REPORT_REQUEST_PRICE_PER_1000"
+const TaskPricePerHourKey = "REPORT_TASK_PRICE_PER_HOUR"
+
+// DefaultRequestPricePer1000 is the S3 Standard price of PUT, COPY, POST, and LIST requests in us-east-1
+const DefaultRequestPricePer1000 = 0.005
+
+// DefaultTaskPricePerHour is the Fargate price in us-east-1 of a 2 vCPU, 4 GB arm64 task
+const DefaultTaskPricePerHour = 0.07904
+
+// MonthParameter is an optional query string parameter with the month to report on, for example 2026-09. Defaults to
+// the previous month so that the scheduled run reports on the month that just ended.
+const MonthParameter = "month"
+
+// awsConfigFactory so that one could set the AWS config in a test using MinIO and dynamodb-local before calling ReportHandler.
+var awsConfigFactory = awsconfig.NewFactory()
+var logger = logging.Default
+
+// now can be replaced in tests
+var now = time.Now
+
+type ReportOutput struct {
+	UserReport    string `json:"userReport"`
+	DatasetReport string `json:"datasetReport"`
+}
+
+// ReportHandler writes CSV usage reports, one per user and one per dataset version, for the requests made in a month to
+// the bucket named by BucketKey. Requests with audit query parameters are handled by AuditHandler instead.
+func ReportHandler(ctx context.Context, lambdaRequest events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if isAuditQuery(lambdaRequest) {
+		return AuditHandler(ctx, lambdaRequest)
+	}
+	month, err := reportMonth(lambdaRequest)
+	if err != nil {
+		logger.Error("invalid report month", slog.Any("error", err))
+		return lambdautils.ErrorResponse(http.StatusBadRequest, err, lambdaRequest)
+	}
+	out, err := writeReport(ctx, month)
+	if err != nil {
+		logger.Error("error writing usage report", slog.String("month", month.Format(MonthFormat)), slog.Any("error", err))
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	respBody, err := json.Marshal(out)
+	if err != nil {
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(respBody),
+	}, nil
+}
+
+func reportMonth(lambdaRequest events.APIGatewayV2HTTPRequest) (time.Time, error) {
+	if month, ok := lambdaRequest.QueryStringParameters[MonthParameter]; ok {
+		return time.Parse(MonthFormat, month)
+	}
+	current := now().UTC()
+	return time.Date(current.Year(), current.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0), nil
+}
+
+func writeReport(ctx context.Context, month time.Time) (*ReportOutput, error) {
+	bucket, err := shared.NonEmptyFromEnvVar(BucketKey)
+	if err != nil {
+		return nil, err
+	}
+	trackingTable, err := shared.NonEmptyFromEnvVar(tracking.TableNameKey)
+	if err != nil {
+		return nil, err
+	}
+	prices, err := pricesFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	awsConfig, err := awsConfigFactory.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting AWS config: %w", err)
+	}
+
+	store := tracking.NewStore(dynamodb.NewFromConfig(*awsConfig), logger, trackingTable)
+	entries, err := store.ScanUsage(ctx, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+	report := NewReport(month, entries, prices)
+	logger.Info("created usage report",
+		slog.String("month", month.Format(MonthFormat)),
+		slog.Int("users", len(report.ByUser)),
+		slog.Int("datasetVersions", len(report.ByDataset)))
+
+	s3Client := s3.NewFromConfig(*awsConfig)
+	prefix := fmt.Sprintf("usage/%s/", month.Format(MonthFormat))
+	out := &ReportOutput{}
+	if out.UserReport, err = putCSV(ctx, s3Client, bucket, prefix+"users.csv", report.WriteUserCSV); err != nil {
+		return nil, err
+	}
+	if out.DatasetReport, err = putCSV(ctx, s3Client, bucket, prefix+"datasets.csv", report.WriteDatasetCSV); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func putCSV(ctx context.Context, s3Client *s3.Client, bucket, key string, write func(w io.Writer) error) (string, error) {
+	var buffer bytes.Buffer
+	if err := write(&buffer); err != nil {
+		return "", fmt.Errorf("error writing %s: %w", key, err)
+	}
+	if _, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        &buffer,
+		ContentType: aws.String("text/csv"),
+	}); err != nil {
+		return "", fmt.Errorf("error putting s3://%s/%s: %w", bucket, key, err)
+	}
+	return fmt.Sprintf("s3://%s/%s", bucket, key), nil
+}
+
+func pricesFromEnvironment() (Prices, error) {
+	prices := Prices{RequestPricePer1000: DefaultRequestPricePer1000, TaskPricePerHour: DefaultTaskPricePerHour}
+	for key, price := range map[string]*float64{
+		RequestPricePer1000Key: &prices.RequestPricePer1000,
+		TaskPricePerHourKey:    &prices.TaskPricePerHour,
+	} {
+		if value, set := os.LookupEnv(key); set {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return Prices{}, fmt.Errorf("error converting value %s of %s to float: %w", value, key, err)
+			}
+			*price = parsed
+		}
+	}
+	return prices, nil
+}
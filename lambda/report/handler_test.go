@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var testTrackingTableName = "test-rehydration-tracking-table"
+var testReportBucket = "test-rehydration-report-bucket"
+var testEnvVars = test.NewEnvironmentVariables().
+	With(tracking.TableNameKey, testTrackingTableName).
+	With(BucketKey, testReportBucket)
+
+func TestReportHandler(t *testing.T) {
+	testEnvVars.Setenv(t)
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().WithDynamoDB().Config(ctx, false)
+	awsConfigFactory.Set(&awsConfig)
+	defer awsConfigFactory.Set(nil)
+	// the scheduled run reports on the previous month
+	now = func() time.Time { return testMonth.AddDate(0, 1, 0).Add(6 * time.Hour) }
+	defer func() { now = time.Now }()
+
+	s3Client := s3.NewFromConfig(awsConfig)
+	s3Fixture := test.NewS3Fixture(t, s3Client, &s3.CreateBucketInput{Bucket: aws.String(testReportBucket)})
+	defer s3Fixture.Teardown()
+
+	user := models.User{Name: "First User", Email: "first@example.com"}
+	dataset := models.Dataset{ID: 1234, VersionID: 5}
+	usage := &accounting.Usage{RunID: "run-1", BytesCopied: 2048, ObjectsCopied: 2, CopyObjectRequests: 2, WallTimeMillis: 1000}
+	inMonth := newUsageEntry(dataset, user, testMonth.Add(time.Hour), usage)
+	previousMonth := newUsageEntry(dataset, user, testMonth.Add(-time.Hour), usage)
+	dyDB := test.NewDynamoDBFixture(t, awsConfig, test.TrackingCreateTableInput(testTrackingTableName)).
+		WithItems(test.ItemersToPutItemInputs(t, testTrackingTableName, &inMonth, &previousMonth)...)
+	defer dyDB.Teardown()
+
+	response, err := ReportHandler(ctx, events.APIGatewayV2HTTPRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	var out ReportOutput
+	require.NoError(t, json.Unmarshal([]byte(response.Body), &out))
+	assert.Equal(t, fmt.Sprintf("s3://%s/usage/2026-09/users.csv", testReportBucket), out.UserReport)
+	assert.Equal(t, fmt.Sprintf("s3://%s/usage/2026-09/datasets.csv", testReportBucket), out.DatasetReport)
+
+	for key, expectedKeyColumns := range map[string][]string{
+		"usage/2026-09/users.csv":    {user.Email},
+		"usage/2026-09/datasets.csv": {"1234", "5"},
+	} {
+		getOut, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(testReportBucket), Key: aws.String(key)})
+		require.NoError(t, err)
+		assert.Equal(t, "text/csv", aws.ToString(getOut.ContentType))
+		records, err := csv.NewReader(getOut.Body).ReadAll()
+		require.NoError(t, getOut.Body.Close())
+		require.NoError(t, err)
+		// header plus only the entry from the reported month, which has 1 request
+		require.Len(t, records, 2, key)
+		assert.Equal(t, append([]string{"2026-09"}, expectedKeyColumns...), records[1][:len(expectedKeyColumns)+1])
+		assert.Equal(t, []string{"1", "1", "2048", "2"}, records[1][len(expectedKeyColumns)+1:len(expectedKeyColumns)+5])
+	}
+}
+
+func TestReportHandler_BadMonth(t *testing.T) {
+	response, err := ReportHandler(context.Background(), events.APIGatewayV2HTTPRequest{
+		QueryStringParameters: map[string]string{MonthParameter: "September"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestReportMonth(t *testing.T) {
+	now = func() time.Time { return time.Date(2027, time.January, 1, 6, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	month, err := reportMonth(events.APIGatewayV2HTTPRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, time.December, 1, 0, 0, 0, 0, time.UTC), month)
+
+	month, err = reportMonth(events.APIGatewayV2HTTPRequest{QueryStringParameters: map[string]string{MonthParameter: "2026-03"}})
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC), month)
+}
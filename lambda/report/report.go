@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const MonthFormat = "2006-01"
+
+// Prices are used to estimate what the rehydrations in a report cost
+type Prices struct {
+	// RequestPricePer1000 is charged for every S3 request except deletes, which are free
+	RequestPricePer1000 float64
+	// TaskPricePerHour is charged for the wall time of each rehydration task run
+	TaskPricePerHour float64
+}
+
+func (p Prices) cost(usage accounting.Usage) float64 {
+	billedRequests := usage.CopyObjectRequests + usage.UploadPartCopyRequests + usage.ListRequests + usage.OtherRequests
+	return float64(billedRequests)/1000*p.RequestPricePer1000 + usage.WallTime().Hours()*p.TaskPricePerHour
+}
+
+// Row is one user's or dataset version's line in a Report
+type Row struct {
+	Key string
+	// Requests is the number of tracking entries. More than one request can be handled by a single run.
+	Requests int
+	// Rehydrations is the number of task runs counted in Usage
+	Rehydrations     int
+	Usage            accounting.Usage
+	EstimatedCostUSD float64
+}
+
+// Report has the usage of the rehydration task runs for requests made in a month.
+//
+// Each run is counted once in ByUser, for the user who made the earliest request it handled, since later requests
+// for the same dataset version did not cost anything extra. It is also counted once in ByDataset.
+type Report struct {
+	Month     time.Time
+	ByUser    []Row
+	ByDataset []Row
+}
+
+type run struct {
+	usage       accounting.Usage
+	dataset     string
+	firstUser   string
+	firstDate   time.Time
+	entryCounts map[string]int
+}
+
+// NewReport only uses entries with a Usage.
+func NewReport(month time.Time, entries []tracking.Entry, prices Prices) *Report {
+	runs := map[string]*run{}
+	for _, entry := range entries {
+		if entry.Usage == nil {
+			continue
+		}
+		r, seen := runs[entry.Usage.RunID]
+		if !seen {
+			r = &run{usage: *entry.Usage, dataset: entry.DatasetVersion, entryCounts: map[string]int{}}
+			runs[entry.Usage.RunID] = r
+		}
+		if len(r.firstUser) == 0 || entry.RequestDate.Before(r.firstDate) {
+			r.firstUser, r.firstDate = entry.UserEmail, entry.RequestDate
+		}
+		r.entryCounts[entry.UserEmail]++
+	}
+
+	byUser, byDataset := map[string]*Row{}, map[string]*Row{}
+	rowFor := func(rows map[string]*Row, key string) *Row {
+		row, ok := rows[key]
+		if !ok {
+			row = &Row{Key: key}
+			rows[key] = row
+		}
+		return row
+	}
+	for _, r := range runs {
+		runUsage := r.usage
+		runUsage.RunID = ""
+		for email, count := range r.entryCounts {
+			rowFor(byUser, email).Requests += count
+			rowFor(byDataset, r.dataset).Requests += count
+		}
+		for _, row := range []*Row{rowFor(byUser, r.firstUser), rowFor(byDataset, r.dataset)} {
+			row.Rehydrations++
+			row.Usage.Add(runUsage)
+			row.EstimatedCostUSD += prices.cost(runUsage)
+		}
+	}
+	return &Report{Month: month, ByUser: sortedRows(byUser), ByDataset: sortedRows(byDataset)}
+}
+
+func sortedRows(rows map[string]*Row) []Row {
+	var sorted []Row
+	for _, row := range rows {
+		sorted = append(sorted, *row)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
+
+var usageHeader = []string{"requests", "rehydrations", "bytesCopied", "objectsCopied", "copyObjectRequests",
+	"uploadPartCopyRequests", "listRequests", "deleteRequests", "otherRequests", "wallTimeSeconds", "estimatedCostUSD"}
+
+func usageColumns(row Row) []string {
+	return []string{
+		strconv.Itoa(row.Requests),
+		strconv.Itoa(row.Rehydrations),
+		strconv.FormatInt(row.Usage.BytesCopied, 10),
+		strconv.FormatInt(row.Usage.ObjectsCopied, 10),
+		strconv.FormatInt(row.Usage.CopyObjectRequests, 10),
+		strconv.FormatInt(row.Usage.UploadPartCopyRequests, 10),
+		strconv.FormatInt(row.Usage.ListRequests, 10),
+		strconv.FormatInt(row.Usage.DeleteRequests, 10),
+		strconv.FormatInt(row.Usage.OtherRequests, 10),
+		strconv.FormatFloat(row.Usage.WallTime().Seconds(), 'f', 3, 64),
+		strconv.FormatFloat(row.EstimatedCostUSD, 'f', 4, 64),
+	}
+}
+
+func (r *Report) WriteUserCSV(w io.Writer) error {
+	header := append([]string{"month", "userEmail"}, usageHeader...)
+	return r.writeCSV(w, header, r.ByUser, func(row Row) ([]string, error) {
+		return []string{row.Key}, nil
+	})
+}
+
+func (r *Report) WriteDatasetCSV(w io.Writer) error {
+	header := append([]string{"month", "datasetId", "datasetVersionId"}, usageHeader...)
+	return r.writeCSV(w, header, r.ByDataset, func(row Row) ([]string, error) {
+		var datasetID, versionID int
+		if _, err := fmt.Sscanf(row.Key, "%d/%d/", &datasetID, &versionID); err != nil {
+			return nil, fmt.Errorf("unexpected dataset version %q: %w", row.Key, err)
+		}
+		return []string{strconv.Itoa(datasetID), strconv.Itoa(versionID)}, nil
+	})
+}
+
+func (r *Report) writeCSV(w io.Writer, header []string, rows []Row, keyColumns func(Row) ([]string, error)) error {
+	csvWriter := csv.NewWriter(w)
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+	month := r.Month.Format(MonthFormat)
+	for _, row := range rows {
+		key, err := keyColumns(row)
+		if err != nil {
+			return err
+		}
+		record := append([]string{month}, key...)
+		if err := csvWriter.Write(append(record, usageColumns(row)...)); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var testMonth = time.Date(2026, time.September, 1, 0, 0, 0, 0, time.UTC)
+
+func newUsageEntry(dataset models.Dataset, user models.User, requestDate time.Time, usage *accounting.Usage) tracking.Entry {
+	entry := test.NewTestEntry(dataset, user)
+	entry.RequestDate = requestDate
+	entry.Usage = usage
+	return *entry
+}
+
+func TestNewReport(t *testing.T) {
+	first := models.User{Name: "First User", Email: "first@example.com"}
+	second := models.User{Name: "Second User", Email: "second@example.com"}
+	dataset := models.Dataset{ID: 1234, VersionID: 5}
+	otherDataset := models.Dataset{ID: 99, VersionID: 1}
+
+	sharedRun := accounting.Usage{RunID: "run-1", BytesCopied: 1000, ObjectsCopied: 10, CopyObjectRequests: 10, ListRequests: 1, WallTimeMillis: 60_000}
+	secondRun := accounting.Usage{RunID: "run-2", BytesCopied: 500, ObjectsCopied: 5, UploadPartCopyRequests: 20, OtherRequests: 10, DeleteRequests: 3, WallTimeMillis: 30_000}
+	entries := []tracking.Entry{
+		// second asked after first, while the run for first was in progress, so first pays for the run
+		newUsageEntry(dataset, second, testMonth.Add(2*time.Hour), &sharedRun),
+		newUsageEntry(dataset, first, testMonth.Add(time.Hour), &sharedRun),
+		newUsageEntry(otherDataset, second, testMonth.Add(3*time.Hour), &secondRun),
+		newUsageEntry(otherDataset, first, testMonth.Add(4*time.Hour), nil),
+	}
+	prices := Prices{RequestPricePer1000: 1, TaskPricePerHour: 60}
+	report := NewReport(testMonth, entries, prices)
+
+	sharedRunUsage := sharedRun
+	sharedRunUsage.RunID = ""
+	secondRunUsage := secondRun
+	secondRunUsage.RunID = ""
+	sharedRunCost := 11.0/1000 + 1
+	secondRunCost := 30.0/1000 + 0.5
+
+	assert.Equal(t, []Row{
+		{Key: first.Email, Requests: 1, Rehydrations: 1, Usage: sharedRunUsage, EstimatedCostUSD: sharedRunCost},
+		{Key: second.Email, Requests: 2, Rehydrations: 1, Usage: secondRunUsage, EstimatedCostUSD: secondRunCost},
+	}, report.ByUser)
+	assert.Equal(t, []Row{
+		{Key: dataset.DatasetVersion(), Requests: 2, Rehydrations: 1, Usage: sharedRunUsage, EstimatedCostUSD: sharedRunCost},
+		{Key: otherDataset.DatasetVersion(), Requests: 1, Rehydrations: 1, Usage: secondRunUsage, EstimatedCostUSD: secondRunCost},
+	}, report.ByDataset)
+}
+
+func TestReport_CSV(t *testing.T) {
+	user := models.User{Name: "First User", Email: "first@example.com"}
+	dataset := models.Dataset{ID: 1234, VersionID: 5}
+	usage := accounting.Usage{RunID: "run-1", BytesCopied: 2048, ObjectsCopied: 2, CopyObjectRequests: 2, ListRequests: 1, DeleteRequests: 1, OtherRequests: 3, WallTimeMillis: 1500}
+	report := NewReport(testMonth, []tracking.Entry{newUsageEntry(dataset, user, testMonth, &usage)}, Prices{RequestPricePer1000: 1000})
+
+	var users bytes.Buffer
+	require.NoError(t, report.WriteUserCSV(&users))
+	userRecords, err := csv.NewReader(&users).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"month", "userEmail", "requests", "rehydrations", "bytesCopied", "objectsCopied", "copyObjectRequests", "uploadPartCopyRequests", "listRequests", "deleteRequests", "otherRequests", "wallTimeSeconds", "estimatedCostUSD"},
+		{"2026-09", user.Email, "1", "1", "2048", "2", "2", "0", "1", "1", "3", "1.500", "6.0000"},
+	}, userRecords)
+
+	var datasets bytes.Buffer
+	require.NoError(t, report.WriteDatasetCSV(&datasets))
+	datasetRecords, err := csv.NewReader(&datasets).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"month", "datasetId", "datasetVersionId", "requests", "rehydrations", "bytesCopied", "objectsCopied", "copyObjectRequests", "uploadPartCopyRequests", "listRequests", "deleteRequests", "otherRequests", "wallTimeSeconds", "estimatedCostUSD"},
+		{"2026-09", "1234", "5", "1", "1", "2048", "2", "2", "0", "1", "1", "3", "1.500", "6.0000"},
+	}, datasetRecords)
+}
@@ -0,0 +1,76 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+	"github.com/pennsieve/rehydration-service/service/request"
+	"github.com/pennsieve/rehydration-service/shared/discover"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"net/http"
+)
+
+// Checker decides if a dataset version can be rehydrated for the caller before any rehydration is started.
+type Checker interface {
+	// Check returns a *NotFoundError if the version does not exist or is not published, and a *ForbiddenError if the
+	// principal cannot access it.
+	// authorization is the Authorization header of the caller's request, used to check embargo access.
+	Check(ctx context.Context, dataset sharedmodels.Dataset, principal *request.Principal, authorization string) error
+}
+
+type NotFoundError struct {
+	message string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.message
+}
+
+type ForbiddenError struct {
+	message string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.message
+}
+
+type discoverChecker struct {
+	client *pennsieve.Client
+}
+
+func NewChecker(pennsieveHost string) Checker {
+	return &discoverChecker{client: pennsieve.NewClient(pennsieve.APIParams{ApiHost: pennsieveHost})}
+}
+
+// Check lets service accounts rehydrate embargoed versions since they are trusted to have checked access themselves.
+func (c *discoverChecker) Check(ctx context.Context, dataset sharedmodels.Dataset, principal *request.Principal, authorization string) error {
+	if principal.ServiceAccount {
+		// the caller's token is not a user's token, so Discover can't use it
+		authorization = ""
+	}
+	version, err := discover.GetDatasetVersion(ctx, c.client, dataset, authorization)
+	if err != nil {
+		var statusError *discover.StatusError
+		if errors.As(err, &statusError) {
+			switch statusError.StatusCode {
+			case http.StatusNotFound:
+				return &NotFoundError{fmt.Sprintf("dataset %d version %d not found", dataset.ID, dataset.VersionID)}
+			case http.StatusForbidden, http.StatusUnauthorized:
+				return &ForbiddenError{fmt.Sprintf("dataset %d version %d is not accessible", dataset.ID, dataset.VersionID)}
+			}
+		}
+		return err
+	}
+	switch version.Status {
+	case discover.PublishSucceeded:
+		return nil
+	case discover.EmbargoSucceeded:
+		if principal.ServiceAccount || version.EmbargoAccess == discover.EmbargoAccessGranted {
+			return nil
+		}
+		return &ForbiddenError{fmt.Sprintf("dataset %d version %d is embargoed and %s has not been granted access", dataset.ID, dataset.VersionID, principal)}
+	default:
+		return &NotFoundError{fmt.Sprintf("dataset %d version %d is not published: status %s", dataset.ID, dataset.VersionID, version.Status)}
+	}
+}
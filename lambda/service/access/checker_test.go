@@ -0,0 +1,64 @@
+package access
+
+import (
+	"context"
+	"github.com/pennsieve/rehydration-service/service/request"
+	"github.com/pennsieve/rehydration-service/shared/discover"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/test/discovertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestChecker_Check(t *testing.T) {
+	published := sharedmodels.Dataset{ID: 1, VersionID: 1}
+	embargoedGranted := sharedmodels.Dataset{ID: 2, VersionID: 1}
+	embargoed := sharedmodels.Dataset{ID: 3, VersionID: 1}
+	unpublished := sharedmodels.Dataset{ID: 4, VersionID: 1}
+	missing := sharedmodels.Dataset{ID: 5, VersionID: 1}
+	forbidden := sharedmodels.Dataset{ID: 6, VersionID: 1}
+
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetVersionHandlerBuilder(published, "", ""),
+		discovertest.GetDatasetVersionHandlerBuilder(embargoedGranted, discover.EmbargoSucceeded, discover.EmbargoAccessGranted),
+		discovertest.GetDatasetVersionHandlerBuilder(embargoed, discover.EmbargoSucceeded, ""),
+		discovertest.GetDatasetVersionHandlerBuilder(unpublished, "PUBLISH_FAILED", ""),
+		test.NewHandlerFuncBuilder(discovertest.GetDatasetVersionPath(missing)).
+			WithStatusCode(http.StatusNotFound).
+			WithModel(discovertest.ErrorResponse("not found", http.StatusNotFound)),
+		test.NewHandlerFuncBuilder(discovertest.GetDatasetVersionPath(forbidden)).
+			WithStatusCode(http.StatusForbidden).
+			WithModel(discovertest.ErrorResponse("forbidden", http.StatusForbidden)),
+	)
+	defer mockDiscover.Teardown()
+
+	checker := NewChecker(mockDiscover.Server.URL)
+	user := &request.Principal{ID: "abc", Email: "last@example.com"}
+	serviceAccount := &request.Principal{ID: "arn:aws:iam::123456789012:role/internal", ServiceAccount: true}
+
+	for name, params := range map[string]struct {
+		dataset       sharedmodels.Dataset
+		principal     *request.Principal
+		expectedError error
+	}{
+		"published":                       {published, user, nil},
+		"embargoed with access":           {embargoedGranted, user, nil},
+		"embargoed without access":        {embargoed, user, &ForbiddenError{}},
+		"embargoed for a service account": {embargoed, serviceAccount, nil},
+		"unpublished":                     {unpublished, user, &NotFoundError{}},
+		"missing":                         {missing, user, &NotFoundError{}},
+		"forbidden":                       {forbidden, serviceAccount, &ForbiddenError{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := checker.Check(context.Background(), params.dataset, params.principal, "Bearer test-token")
+			if params.expectedError == nil {
+				require.NoError(t, err)
+			} else {
+				assert.IsType(t, params.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	batchtypes "github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/pennsieve/rehydration-service/service/models"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+)
+
+// batchHandler submits the rehydration container as an AWS Batch job. The job definition should use the same image
+// and environment as the ECS task definition.
+type batchHandler struct {
+	taskConfig  *models.ECSTaskConfig
+	batchConfig *models.BatchConfig
+	client      *batch.Client
+}
+
+func newBatchHandler(client *batch.Client, taskConfig *models.ECSTaskConfig, batchConfig *models.BatchConfig) Handler {
+	return &batchHandler{
+		taskConfig:  taskConfig,
+		batchConfig: batchConfig,
+		client:      client,
+	}
+}
+
+func (h *batchHandler) Handle(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, destination *sharedmodels.Destination, requestID string, logger *slog.Logger) (string, error) {
+	logger.Info("Submitting new Rehydrate Batch job.")
+	runTaskIn := h.taskConfig.RunTaskInput(ctx, dataset, user, destination, requestID)
+	var env []batchtypes.KeyValuePair
+	for _, kv := range overrideEnvironment(runTaskIn) {
+		env = append(env, batchtypes.KeyValuePair{Name: kv.Name, Value: kv.Value})
+	}
+	out, err := h.client.SubmitJob(ctx, &batch.SubmitJobInput{
+		JobName:            aws.String(fmt.Sprintf("rehydrate-%d-%d", dataset.ID, dataset.VersionID)),
+		JobQueue:           aws.String(h.batchConfig.JobQueue),
+		JobDefinition:      aws.String(h.batchConfig.JobDefinition),
+		ContainerOverrides: &batchtypes.ContainerOverrides{Environment: env},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error submitting Batch job: %w", err)
+	}
+	jobID := sharedmodels.QualifiedJobID(sharedmodels.BatchBackend, aws.ToString(out.JobId))
+	logger.Info("batch job submitted", slog.String("jobID", jobID), slog.String("jobName", aws.ToString(out.JobName)))
+	return jobID, nil
+}
+
+func (h *batchHandler) Status(ctx context.Context, jobID string) (*JobStatus, error) {
+	backend, batchJobID := sharedmodels.ParseJobID(jobID)
+	if backend != sharedmodels.BatchBackend {
+		return nil, fmt.Errorf("job %s was not started on Batch", jobID)
+	}
+	out, err := h.client.DescribeJobs(ctx, &batch.DescribeJobsInput{Jobs: []string{batchJobID}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing Batch job %s: %w", batchJobID, err)
+	}
+	if len(out.Jobs) == 0 {
+		return nil, fmt.Errorf("batch job %s not found", batchJobID)
+	}
+	job := out.Jobs[0]
+	return &JobStatus{
+		JobID:     jobID,
+		Backend:   sharedmodels.BatchBackend,
+		Status:    string(job.Status),
+		Finished:  job.Status == batchtypes.JobStatusSucceeded || job.Status == batchtypes.JobStatusFailed,
+		Succeeded: job.Status == batchtypes.JobStatusSucceeded,
+		Reason:    aws.ToString(job.StatusReason),
+	}, nil
+}
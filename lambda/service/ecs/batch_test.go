@@ -0,0 +1,109 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestBatchHandler_Handle(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	taskConfig := newTestTaskConfig()
+	batchConfig := &models.BatchConfig{JobQueue: "test-queue", JobDefinition: "test-job-def", ThresholdBytes: 1}
+	expectedBatchJobID := "4c8c5a52-7d4c-4b1a-9a67-0d6a3c2e3b10"
+
+	var submitted map[string]any
+	mockBatch := test.NewHTTPMuxTestFixture(t,
+		test.NewHandlerFuncBuilder("/v1/submitjob").WithMethod(http.MethodPost).WithSelectorFunc(func(r *http.Request) (int, any) {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&submitted))
+			return http.StatusOK, map[string]string{"jobId": expectedBatchJobID, "jobName": "rehydrate-5065-2", "jobArn": "arn:aws:batch:test"}
+		}))
+	defer mockBatch.Teardown()
+
+	handler := newBatchHandler(newTestBatchClient(mockBatch.Server.URL), taskConfig, batchConfig)
+	jobID, err := handler.Handle(context.Background(), dataset, user, nil, "", logging.Default)
+	require.NoError(t, err)
+	assert.Equal(t, sharedmodels.QualifiedJobID(sharedmodels.BatchBackend, expectedBatchJobID), jobID)
+
+	assert.Equal(t, batchConfig.JobQueue, submitted["jobQueue"])
+	assert.Equal(t, batchConfig.JobDefinition, submitted["jobDefinition"])
+	assert.Equal(t, "rehydrate-5065-2", submitted["jobName"])
+	overrides := submitted["containerOverrides"].(map[string]any)
+	assert.Contains(t, overrides["environment"], map[string]any{"name": sharedmodels.ECSTaskDatasetIDKey, "value": "5065"})
+	assert.Contains(t, overrides["environment"], map[string]any{"name": sharedmodels.ECSTaskUserEmailKey, "value": user.Email})
+}
+
+func TestBatchHandler_Status(t *testing.T) {
+	batchJobID := "4c8c5a52-7d4c-4b1a-9a67-0d6a3c2e3b10"
+	for status, expected := range map[string]struct {
+		finished  bool
+		succeeded bool
+	}{
+		"RUNNABLE":  {false, false},
+		"RUNNING":   {false, false},
+		"SUCCEEDED": {true, true},
+		"FAILED":    {true, false},
+	} {
+		t.Run(status, func(t *testing.T) {
+			mockBatch := test.NewHTTPMuxTestFixture(t,
+				test.NewHandlerFuncBuilder("/v1/describejobs").WithMethod(http.MethodPost).WithModel(map[string]any{
+					"jobs": []map[string]any{{
+						"jobId":        batchJobID,
+						"jobName":      "rehydrate-5065-2",
+						"status":       status,
+						"statusReason": "some reason",
+					}}}))
+			defer mockBatch.Teardown()
+
+			handler := newBatchHandler(newTestBatchClient(mockBatch.Server.URL), newTestTaskConfig(), &models.BatchConfig{})
+			jobID := sharedmodels.QualifiedJobID(sharedmodels.BatchBackend, batchJobID)
+			jobStatus, err := handler.Status(context.Background(), jobID)
+			require.NoError(t, err)
+			assert.Equal(t, jobID, jobStatus.JobID)
+			assert.Equal(t, sharedmodels.BatchBackend, jobStatus.Backend)
+			assert.Equal(t, status, jobStatus.Status)
+			assert.Equal(t, expected.finished, jobStatus.Finished)
+			assert.Equal(t, expected.succeeded, jobStatus.Succeeded)
+			assert.Equal(t, "some reason", jobStatus.Reason)
+		})
+	}
+}
+
+func TestBatchHandler_Status_WrongBackend(t *testing.T) {
+	handler := newBatchHandler(nil, newTestTaskConfig(), &models.BatchConfig{})
+	_, err := handler.Status(context.Background(), "arn:aws:ecs:us-east-1:123456789012:task/cluster/abc")
+	assert.ErrorContains(t, err, "not started on Batch")
+}
+
+func newTestBatchClient(url string) *batch.Client {
+	return batch.NewFromConfig(aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-key", "test-secret", ""),
+	}, func(options *batch.Options) {
+		options.BaseEndpoint = aws.String(url)
+	})
+}
+
+func newTestTaskConfig() *models.ECSTaskConfig {
+	return &models.ECSTaskConfig{
+		TaskDefinitionARN:    "test-ecs-task-definition-arn",
+		SubnetIDS:            []string{"test-subnet-1"},
+		Cluster:              "test-cluster-arn",
+		SecurityGroup:        "test-sg",
+		TaskDefContainerName: "test-rehydrate-fargate-container",
+		IdempotencyTableName: "TestRehydrationIdempotency",
+		TrackingTableName:    "TestRehydrationTracking",
+		PennsieveDomain:      "pennsieve.example.com",
+	}
+}
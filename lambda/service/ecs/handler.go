@@ -9,33 +9,96 @@ import (
 	"github.com/pennsieve/rehydration-service/service/models"
 	"github.com/pennsieve/rehydration-service/service/runner"
 	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"log/slog"
 	"strings"
 )
 
 type Handler interface {
-	Handle(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, logger *slog.Logger) (string, error)
+	// Handle starts a rehydration task and returns its job ID. The job ID is an ECS task ARN or, for other backends,
+	// an ID created by sharedmodels.QualifiedJobID. destination is nil if the task should use the default rehydration
+	// bucket.
+	Handle(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, destination *sharedmodels.Destination, requestID string, logger *slog.Logger) (string, error)
+	// Status looks up the current state of a job started by Handle
+	Status(ctx context.Context, jobID string) (*JobStatus, error)
 }
+
+// JobStatus is a backend independent view of a rehydration task's state
+type JobStatus struct {
+	JobID   string               `json:"jobId"`
+	Backend sharedmodels.Backend `json:"backend"`
+	// Status is the backend's own status value, for example RUNNING for ECS or RUNNABLE for Batch
+	Status    string `json:"status"`
+	Finished  bool   `json:"finished"`
+	Succeeded bool   `json:"succeeded"`
+	Reason    string `json:"reason,omitempty"`
+}
+
 type handler struct {
 	taskConfig *models.ECSTaskConfig
-	client     *ecs.Client
+	newRunner  func(input *ecs.RunTaskInput) runner.Runner
 }
 
+// NewHandler returns a Handler that starts tasks on ECS, or with a Docker Engine if taskConfig.Docker is set.
+// If taskConfig.Backends is set, the returned Handler will send large datasets to AWS Batch or Kubernetes instead.
 func NewHandler(awsConfig aws.Config, taskConfig *models.ECSTaskConfig) Handler {
+	taskHandler := newTaskHandler(awsConfig, taskConfig)
+	if taskConfig.Backends == nil {
+		return taskHandler
+	}
+	return newSelectingHandler(awsConfig, taskConfig, taskHandler)
+}
+
+func newTaskHandler(awsConfig aws.Config, taskConfig *models.ECSTaskConfig) Handler {
+	if taskConfig.Docker != nil {
+		return &handler{
+			taskConfig: taskConfig,
+			newRunner:  dockerRunnerFactory(taskConfig.Docker),
+		}
+	}
 	client := ecs.NewFromConfig(awsConfig)
 	return &handler{
 		taskConfig: taskConfig,
-		client:     client,
+		newRunner: func(input *ecs.RunTaskInput) runner.Runner {
+			return runner.NewECSTaskRunner(client, input)
+		},
 	}
 }
 
-func (h *handler) Handle(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, logger *slog.Logger) (string, error) {
+func dockerRunnerFactory(dockerConfig *runner.DockerConfig) func(input *ecs.RunTaskInput) runner.Runner {
+	client, err := runner.NewDockerClient(dockerConfig.Host)
+	return func(input *ecs.RunTaskInput) runner.Runner {
+		if err != nil {
+			return errRunner{err}
+		}
+		return runner.NewDockerTaskRunner(client, dockerConfig, input)
+	}
+}
+
+// errRunner lets a bad Docker configuration surface as an error from Handle rather than a panic in NewHandler
+type errRunner struct {
+	err error
+}
+
+func (r errRunner) Run(_ context.Context) (*ecs.RunTaskOutput, error) {
+	return nil, r.err
+}
+
+func (r errRunner) Describe(_ context.Context, _ string) (*types.Task, error) {
+	return nil, r.err
+}
+
+func (h *handler) Handle(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, destination *sharedmodels.Destination, requestID string, logger *slog.Logger) (string, error) {
 	logger.Info("Initiating new Rehydrate Fargate Task.")
 
-	runTaskIn := h.taskConfig.RunTaskInput(dataset, user)
+	// the task's spans are children of this one
+	runCtx, span := tracing.Start(ctx, "ecs.RunTask", attribute.String("ecs.cluster", h.taskConfig.Cluster))
+	runTaskIn := h.taskConfig.RunTaskInput(runCtx, dataset, user, destination, requestID)
 
-	runner := runner.NewECSTaskRunner(h.client, runTaskIn)
-	out, err := runner.Run(ctx)
+	taskRunner := h.newRunner(runTaskIn)
+	out, err := taskRunner.Run(runCtx)
+	tracing.End(span, err)
 	if err != nil {
 		return "", fmt.Errorf("error starting Fargate task: %w", err)
 	}
@@ -74,6 +137,46 @@ func (h *handler) Handle(ctx context.Context, dataset sharedmodels.Dataset, user
 	return taskARN, taskFailure
 }
 
+func (h *handler) Status(ctx context.Context, jobID string) (*JobStatus, error) {
+	backend, taskARN := sharedmodels.ParseJobID(jobID)
+	if backend != sharedmodels.ECSBackend {
+		return nil, fmt.Errorf("job %s was not started on ECS", jobID)
+	}
+	taskRunner := h.newRunner(&ecs.RunTaskInput{Cluster: aws.String(h.taskConfig.Cluster)})
+	task, err := taskRunner.Describe(ctx, taskARN)
+	if err != nil {
+		return nil, err
+	}
+	status := &JobStatus{
+		JobID:    jobID,
+		Backend:  sharedmodels.ECSBackend,
+		Status:   aws.ToString(task.LastStatus),
+		Finished: aws.ToString(task.LastStatus) == "STOPPED",
+		Reason:   aws.ToString(task.StoppedReason),
+	}
+	if status.Finished {
+		status.Succeeded = len(task.Containers) > 0
+		for _, container := range task.Containers {
+			if container.ExitCode == nil || *container.ExitCode != 0 {
+				status.Succeeded = false
+			}
+		}
+	}
+	return status, nil
+}
+
+// overrideEnvironment returns the environment variables from input's container overrides
+func overrideEnvironment(input *ecs.RunTaskInput) []types.KeyValuePair {
+	var env []types.KeyValuePair
+	if input.Overrides == nil {
+		return env
+	}
+	for _, override := range input.Overrides.ContainerOverrides {
+		env = append(env, override.Environment...)
+	}
+	return env
+}
+
 // taskLogGroup returns a view of a types.Task as a slog.Group for structured logging
 func taskLogGroup(task types.Task) slog.Attr {
 	return slog.Group("task",
@@ -0,0 +1,167 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/pennsieve/rehydration-service/service/runner"
+	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"testing"
+)
+
+func TestHandler_Handle_PropagatesTraceContext(t *testing.T) {
+	spanRecorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	taskARN := "arn:aws:ecs:us-east-1:000000000000:task/test-cluster-arn/1234"
+	var input *ecs.RunTaskInput
+	handler := &handler{
+		taskConfig: newTestTaskConfig(),
+		newRunner: func(runTaskIn *ecs.RunTaskInput) runner.Runner {
+			input = runTaskIn
+			return &fakeRunner{output: &ecs.RunTaskOutput{Tasks: []types.Task{{TaskArn: aws.String(taskARN)}}}}
+		},
+	}
+
+	ctx, parent := tracing.Start(context.Background(), "test")
+	jobID, err := handler.Handle(ctx, dataset, user, nil, "request-1234", logging.Default)
+	parent.End()
+	require.NoError(t, err)
+	assert.Equal(t, taskARN, jobID)
+
+	spans := spanRecorder.Ended()
+	require.Len(t, spans, 2)
+	runTaskSpan := spans[0]
+	assert.Equal(t, "ecs.RunTask", runTaskSpan.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), runTaskSpan.Parent().SpanID())
+
+	env := map[string]string{}
+	for _, kv := range overrideEnvironment(input) {
+		env[aws.ToString(kv.Name)] = aws.ToString(kv.Value)
+	}
+	expectedTraceParent := fmt.Sprintf("00-%s-%s-01", runTaskSpan.SpanContext().TraceID(), runTaskSpan.SpanContext().SpanID())
+	assert.Equal(t, expectedTraceParent, env[tracing.TraceParentKey])
+	assert.Equal(t, "5065", env[sharedmodels.ECSTaskDatasetIDKey])
+	assert.Equal(t, "request-1234", env[sharedmodels.ECSTaskRequestIDKey])
+}
+
+func TestHandler_Handle_TracingDisabled(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	var input *ecs.RunTaskInput
+	handler := &handler{
+		taskConfig: newTestTaskConfig(),
+		newRunner: func(runTaskIn *ecs.RunTaskInput) runner.Runner {
+			input = runTaskIn
+			return &fakeRunner{output: &ecs.RunTaskOutput{Tasks: []types.Task{{TaskArn: aws.String("test-task-arn")}}}}
+		},
+	}
+	_, err := handler.Handle(context.Background(), sharedmodels.Dataset{ID: 1, VersionID: 1}, sharedmodels.User{}, nil, "", logging.Default)
+	require.NoError(t, err)
+	for _, kv := range overrideEnvironment(input) {
+		assert.NotEqual(t, tracing.TraceParentKey, aws.ToString(kv.Name))
+		// no request ID was given
+		assert.NotEqual(t, sharedmodels.ECSTaskRequestIDKey, aws.ToString(kv.Name))
+		// there is no dispatcher to resume the task
+		assert.NotEqual(t, sharedmodels.ECSTaskResumeRestoresKey, aws.ToString(kv.Name))
+	}
+}
+
+func TestHandler_Handle_ResumeRestores(t *testing.T) {
+	var input *ecs.RunTaskInput
+	taskConfig := newTestTaskConfig()
+	taskConfig.ResumeRestores = true
+	handler := &handler{
+		taskConfig: taskConfig,
+		newRunner: func(runTaskIn *ecs.RunTaskInput) runner.Runner {
+			input = runTaskIn
+			return &fakeRunner{output: &ecs.RunTaskOutput{Tasks: []types.Task{{TaskArn: aws.String("test-task-arn")}}}}
+		},
+	}
+	_, err := handler.Handle(context.Background(), sharedmodels.Dataset{ID: 1, VersionID: 1}, sharedmodels.User{}, nil, "", logging.Default)
+	require.NoError(t, err)
+
+	env := map[string]string{}
+	for _, kv := range overrideEnvironment(input) {
+		env[aws.ToString(kv.Name)] = aws.ToString(kv.Value)
+	}
+	assert.Equal(t, "true", env[sharedmodels.ECSTaskResumeRestoresKey])
+}
+
+func TestHandler_Handle_Destination(t *testing.T) {
+	var input *ecs.RunTaskInput
+	handler := &handler{
+		taskConfig: newTestTaskConfig(),
+		newRunner: func(runTaskIn *ecs.RunTaskInput) runner.Runner {
+			input = runTaskIn
+			return &fakeRunner{output: &ecs.RunTaskOutput{Tasks: []types.Task{{TaskArn: aws.String("test-task-arn")}}}}
+		},
+	}
+	destination := &sharedmodels.Destination{Region: "eu-west-1", Bucket: "rehydration-eu"}
+	_, err := handler.Handle(context.Background(), sharedmodels.Dataset{ID: 1, VersionID: 1}, sharedmodels.User{}, destination, "", logging.Default)
+	require.NoError(t, err)
+
+	env := map[string]string{}
+	for _, kv := range overrideEnvironment(input) {
+		env[aws.ToString(kv.Name)] = aws.ToString(kv.Value)
+	}
+	assert.Equal(t, "rehydration-eu", env[shared.RehydrationBucketKey])
+	assert.Equal(t, "eu-west-1", env[sharedmodels.ECSTaskRehydrationRegionKey])
+}
+
+func TestHandler_Handle_ExternalDestination(t *testing.T) {
+	var input *ecs.RunTaskInput
+	handler := &handler{
+		taskConfig: newTestTaskConfig(),
+		newRunner: func(runTaskIn *ecs.RunTaskInput) runner.Runner {
+			input = runTaskIn
+			return &fakeRunner{output: &ecs.RunTaskOutput{Tasks: []types.Task{{TaskArn: aws.String("test-task-arn")}}}}
+		},
+	}
+	destination := &sharedmodels.Destination{
+		Bucket:  "requester-bucket",
+		Prefix:  "deliveries/",
+		RoleARN: "arn:aws:iam::123456789012:role/rehydration-writer",
+	}
+	_, err := handler.Handle(context.Background(), sharedmodels.Dataset{ID: 1, VersionID: 1}, sharedmodels.User{}, destination, "", logging.Default)
+	require.NoError(t, err)
+
+	env := map[string]string{}
+	for _, kv := range overrideEnvironment(input) {
+		env[aws.ToString(kv.Name)] = aws.ToString(kv.Value)
+	}
+	assert.Equal(t, "requester-bucket", env[shared.RehydrationBucketKey])
+	assert.Equal(t, "deliveries/", env[sharedmodels.ECSTaskRehydrationPrefixKey])
+	assert.Equal(t, destination.RoleARN, env[sharedmodels.ECSTaskRehydrationRoleARNKey])
+	// no region was given, so the task uses its own
+	assert.NotContains(t, env, sharedmodels.ECSTaskRehydrationRegionKey)
+}
+
+type fakeRunner struct {
+	output *ecs.RunTaskOutput
+}
+
+func (r *fakeRunner) Run(_ context.Context) (*ecs.RunTaskOutput, error) {
+	return r.output, nil
+}
+
+func (r *fakeRunner) Describe(_ context.Context, _ string) (*types.Task, error) {
+	return nil, nil
+}
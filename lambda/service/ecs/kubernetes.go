@@ -0,0 +1,279 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/pennsieve/rehydration-service/service/models"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const eksTokenPrefix = "k8s-aws-v1."
+const eksClusterIDHeader = "x-k8s-aws-id"
+
+// tokenSource returns a bearer token for the Kubernetes API server
+type tokenSource func(ctx context.Context) (string, error)
+
+// kubernetesHandler creates a Kubernetes Job that runs the rehydration container
+type kubernetesHandler struct {
+	taskConfig       *models.ECSTaskConfig
+	kubernetesConfig *models.KubernetesConfig
+	httpClient       *http.Client
+	token            tokenSource
+	// configErr is set if the handler could not be configured. It is returned from Handle and Status.
+	configErr error
+}
+
+func newKubernetesHandler(awsConfig aws.Config, taskConfig *models.ECSTaskConfig, kubernetesConfig *models.KubernetesConfig) Handler {
+	handler := &kubernetesHandler{
+		taskConfig:       taskConfig,
+		kubernetesConfig: kubernetesConfig,
+		token:            eksTokenSource(sts.NewFromConfig(awsConfig), kubernetesConfig.ClusterName),
+	}
+	handler.httpClient, handler.configErr = kubernetesHTTPClient(kubernetesConfig.CAData)
+	return handler
+}
+
+func kubernetesHTTPClient(caData string) (*http.Client, error) {
+	if len(caData) == 0 {
+		return http.DefaultClient, nil
+	}
+	caPEM, err := base64.StdEncoding.DecodeString(caData)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding Kubernetes CA data: %w", err)
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in Kubernetes CA data")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: certPool, MinVersion: tls.VersionTLS12}
+	return &http.Client{Transport: transport}, nil
+}
+
+// eksTokenSource returns bearer tokens for an EKS cluster in the same format as `aws eks get-token`: a presigned
+// STS GetCallerIdentity URL that includes the cluster name as a signed header.
+func eksTokenSource(client *sts.Client, clusterName string) tokenSource {
+	presignClient := sts.NewPresignClient(client)
+	return func(ctx context.Context) (string, error) {
+		presigned, err := presignClient.PresignGetCallerIdentity(ctx, &sts.GetCallerIdentityInput{}, func(options *sts.PresignOptions) {
+			options.ClientOptions = append(options.ClientOptions, func(stsOptions *sts.Options) {
+				stsOptions.APIOptions = append(stsOptions.APIOptions,
+					smithyhttp.AddHeaderValue(eksClusterIDHeader, clusterName),
+					smithyhttp.AddHeaderValue("X-Amz-Expires", "60"))
+			})
+		})
+		if err != nil {
+			return "", fmt.Errorf("error presigning EKS token request: %w", err)
+		}
+		return eksTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(presigned.URL)), nil
+	}
+}
+
+type kubernetesEnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type kubernetesContainer struct {
+	Name  string             `json:"name"`
+	Image string             `json:"image"`
+	Env   []kubernetesEnvVar `json:"env"`
+}
+
+type kubernetesPodSpec struct {
+	RestartPolicy      string                `json:"restartPolicy"`
+	ServiceAccountName string                `json:"serviceAccountName,omitempty"`
+	Containers         []kubernetesContainer `json:"containers"`
+}
+
+type kubernetesObjectMeta struct {
+	Name         string            `json:"name,omitempty"`
+	GenerateName string            `json:"generateName,omitempty"`
+	Namespace    string            `json:"namespace,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+type kubernetesJobSpec struct {
+	BackoffLimit            int32 `json:"backoffLimit"`
+	TTLSecondsAfterFinished int32 `json:"ttlSecondsAfterFinished"`
+	Template                struct {
+		Metadata kubernetesObjectMeta `json:"metadata"`
+		Spec     kubernetesPodSpec    `json:"spec"`
+	} `json:"template"`
+}
+
+type kubernetesJobCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type kubernetesJobStatus struct {
+	Active     int32                    `json:"active"`
+	Succeeded  int32                    `json:"succeeded"`
+	Failed     int32                    `json:"failed"`
+	Conditions []kubernetesJobCondition `json:"conditions"`
+}
+
+type kubernetesJob struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Metadata   kubernetesObjectMeta `json:"metadata"`
+	Spec       kubernetesJobSpec    `json:"spec"`
+	Status     *kubernetesJobStatus `json:"status,omitempty"`
+}
+
+func (h *kubernetesHandler) Handle(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, destination *sharedmodels.Destination, requestID string, logger *slog.Logger) (string, error) {
+	if h.configErr != nil {
+		return "", h.configErr
+	}
+	logger.Info("Creating new Rehydrate Kubernetes job.")
+	runTaskIn := h.taskConfig.RunTaskInput(ctx, dataset, user, destination, requestID)
+	// sorted so that the job spec is the same for the same config
+	names := make([]string, 0, len(h.kubernetesConfig.Env))
+	for name := range h.kubernetesConfig.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var env []kubernetesEnvVar
+	for _, name := range names {
+		env = append(env, kubernetesEnvVar{Name: name, Value: h.kubernetesConfig.Env[name]})
+	}
+	for _, kv := range overrideEnvironment(runTaskIn) {
+		env = append(env, kubernetesEnvVar{Name: aws.ToString(kv.Name), Value: aws.ToString(kv.Value)})
+	}
+	labels := map[string]string{
+		"app.kubernetes.io/name":     "rehydrate",
+		"pennsieve.org/dataset-id":   fmt.Sprint(dataset.ID),
+		"pennsieve.org/version-id":   fmt.Sprint(dataset.VersionID),
+		"app.kubernetes.io/instance": fmt.Sprintf("rehydrate-%d-%d", dataset.ID, dataset.VersionID),
+	}
+	job := kubernetesJob{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Metadata: kubernetesObjectMeta{
+			GenerateName: fmt.Sprintf("rehydrate-%d-%d-", dataset.ID, dataset.VersionID),
+			Namespace:    h.kubernetesConfig.Namespace,
+			Labels:       labels,
+		},
+		Spec: kubernetesJobSpec{
+			// idempotency is handled by the service, so don't let Kubernetes retry
+			BackoffLimit:            0,
+			TTLSecondsAfterFinished: 24 * 60 * 60,
+		},
+	}
+	job.Spec.Template.Metadata = kubernetesObjectMeta{Labels: labels}
+	job.Spec.Template.Spec = kubernetesPodSpec{
+		RestartPolicy:      "Never",
+		ServiceAccountName: h.kubernetesConfig.ServiceAccount,
+		Containers: []kubernetesContainer{{
+			Name:  h.taskConfig.TaskDefContainerName,
+			Image: h.kubernetesConfig.Image,
+			Env:   env,
+		}},
+	}
+	var created kubernetesJob
+	if err := h.do(ctx, http.MethodPost, h.jobsPath(), job, &created); err != nil {
+		return "", fmt.Errorf("error creating Kubernetes job: %w", err)
+	}
+	jobID := sharedmodels.QualifiedJobID(sharedmodels.KubernetesBackend,
+		fmt.Sprintf("%s/%s", created.Metadata.Namespace, created.Metadata.Name))
+	logger.Info("kubernetes job created", slog.String("jobID", jobID))
+	return jobID, nil
+}
+
+func (h *kubernetesHandler) Status(ctx context.Context, jobID string) (*JobStatus, error) {
+	if h.configErr != nil {
+		return nil, h.configErr
+	}
+	backend, id := sharedmodels.ParseJobID(jobID)
+	if backend != sharedmodels.KubernetesBackend {
+		return nil, fmt.Errorf("job %s was not started on Kubernetes", jobID)
+	}
+	namespace, name, found := strings.Cut(id, "/")
+	if !found {
+		return nil, fmt.Errorf("kubernetes job ID %s is not in namespace/name form", id)
+	}
+	var job kubernetesJob
+	path := fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs/%s", namespace, name)
+	if err := h.do(ctx, http.MethodGet, path, nil, &job); err != nil {
+		return nil, fmt.Errorf("error getting Kubernetes job %s: %w", id, err)
+	}
+	status := &JobStatus{JobID: jobID, Backend: sharedmodels.KubernetesBackend, Status: "Pending"}
+	if job.Status == nil {
+		return status, nil
+	}
+	if job.Status.Active > 0 {
+		status.Status = "Active"
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != "True" {
+			continue
+		}
+		switch condition.Type {
+		case "Complete":
+			status.Status, status.Finished, status.Succeeded = condition.Type, true, true
+		case "Failed":
+			status.Status, status.Finished, status.Reason = condition.Type, true, condition.Message
+		}
+	}
+	return status, nil
+}
+
+func (h *kubernetesHandler) jobsPath() string {
+	return fmt.Sprintf("/apis/batch/v1/namespaces/%s/jobs", h.kubernetesConfig.Namespace)
+}
+
+func (h *kubernetesHandler) do(ctx context.Context, method, path string, requestBody any, responseBody any) error {
+	var body io.Reader
+	if requestBody != nil {
+		bodyBytes, err := json.Marshal(requestBody)
+		if err != nil {
+			return fmt.Errorf("error marshalling request body: %w", err)
+		}
+		body = bytes.NewReader(bodyBytes)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(h.kubernetesConfig.APIServer, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	token, err := h.token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if requestBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Kubernetes errors are a Status object with a message field
+		var status struct {
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&status); err == nil && len(status.Message) > 0 {
+			return fmt.Errorf("kubernetes API returned status %d: %s", resp.StatusCode, status.Message)
+		}
+		return fmt.Errorf("kubernetes API returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(responseBody)
+}
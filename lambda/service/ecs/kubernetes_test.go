@@ -0,0 +1,156 @@
+package ecs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testToken = "test-bearer-token"
+
+func TestKubernetesHandler_Handle(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	taskConfig := newTestTaskConfig()
+
+	var created kubernetesJob
+	mockAPIServer := test.NewHTTPMuxTestFixture(t,
+		test.NewHandlerFuncBuilder("/apis/batch/v1/namespaces/rehydration/jobs").WithMethod(http.MethodPost).WithSelectorFunc(func(r *http.Request) (int, any) {
+			assert.Equal(t, "Bearer "+testToken, r.Header.Get("Authorization"))
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			response := created
+			response.Metadata.Name = created.Metadata.GenerateName + "x7k2p"
+			return http.StatusCreated, response
+		}))
+	defer mockAPIServer.Teardown()
+
+	handler := newTestKubernetesHandler(mockAPIServer.Server.URL, taskConfig)
+	jobID, err := handler.Handle(context.Background(), dataset, user, nil, "", logging.Default)
+	require.NoError(t, err)
+	assert.Equal(t, sharedmodels.QualifiedJobID(sharedmodels.KubernetesBackend, "rehydration/rehydrate-5065-2-x7k2p"), jobID)
+
+	assert.Equal(t, "batch/v1", created.APIVersion)
+	assert.Equal(t, "Job", created.Kind)
+	assert.Equal(t, int32(0), created.Spec.BackoffLimit)
+	podSpec := created.Spec.Template.Spec
+	assert.Equal(t, "Never", podSpec.RestartPolicy)
+	assert.Equal(t, "rehydrate", podSpec.ServiceAccountName)
+	require.Len(t, podSpec.Containers, 1)
+	container := podSpec.Containers[0]
+	assert.Equal(t, taskConfig.TaskDefContainerName, container.Name)
+	assert.Equal(t, "pennsieve/rehydrate:test", container.Image)
+	// configured variables come first, in name order
+	require.GreaterOrEqual(t, len(container.Env), 3)
+	assert.Equal(t, []kubernetesEnvVar{
+		{Name: "ENV", Value: "test"},
+		{Name: "REHYDRATION_BUCKET", Value: "test-bucket"},
+		{Name: "REHYDRATION_TTL_DAYS", Value: "14"},
+	}, container.Env[:3])
+	assert.Contains(t, container.Env, kubernetesEnvVar{Name: sharedmodels.ECSTaskDatasetIDKey, Value: "5065"})
+	assert.Contains(t, container.Env, kubernetesEnvVar{Name: sharedmodels.ECSTaskUserNameKey, Value: user.Name})
+}
+
+func TestKubernetesHandler_Handle_Error(t *testing.T) {
+	mockAPIServer := test.NewHTTPMuxTestFixture(t,
+		test.NewHandlerFuncBuilder("/apis/batch/v1/namespaces/rehydration/jobs").
+			WithMethod(http.MethodPost).
+			WithStatusCode(http.StatusForbidden).
+			WithModel(`{"kind": "Status", "message": "jobs.batch is forbidden"}`))
+	defer mockAPIServer.Teardown()
+
+	handler := newTestKubernetesHandler(mockAPIServer.Server.URL, newTestTaskConfig())
+	_, err := handler.Handle(context.Background(), sharedmodels.Dataset{ID: 1, VersionID: 1}, sharedmodels.User{}, nil, "", logging.Default)
+	assert.ErrorContains(t, err, "jobs.batch is forbidden")
+}
+
+func TestKubernetesHandler_Status(t *testing.T) {
+	for name, params := range map[string]struct {
+		status            *kubernetesJobStatus
+		expectedStatus    string
+		expectedFinished  bool
+		expectedSucceeded bool
+		expectedReason    string
+	}{
+		"no status": {expectedStatus: "Pending"},
+		"active":    {status: &kubernetesJobStatus{Active: 1}, expectedStatus: "Active"},
+		"complete": {status: &kubernetesJobStatus{Succeeded: 1, Conditions: []kubernetesJobCondition{{Type: "Complete", Status: "True"}}},
+			expectedStatus: "Complete", expectedFinished: true, expectedSucceeded: true},
+		"failed": {status: &kubernetesJobStatus{Failed: 1, Conditions: []kubernetesJobCondition{{Type: "Failed", Status: "True", Message: "BackoffLimitExceeded"}}},
+			expectedStatus: "Failed", expectedFinished: true, expectedReason: "BackoffLimitExceeded"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockAPIServer := test.NewHTTPMuxTestFixture(t,
+				test.NewHandlerFuncBuilder("/apis/batch/v1/namespaces/rehydration/jobs/rehydrate-5065-2-x7k2p").
+					WithModel(kubernetesJob{Status: params.status}))
+			defer mockAPIServer.Teardown()
+
+			handler := newTestKubernetesHandler(mockAPIServer.Server.URL, newTestTaskConfig())
+			jobID := sharedmodels.QualifiedJobID(sharedmodels.KubernetesBackend, "rehydration/rehydrate-5065-2-x7k2p")
+			jobStatus, err := handler.Status(context.Background(), jobID)
+			require.NoError(t, err)
+			assert.Equal(t, jobID, jobStatus.JobID)
+			assert.Equal(t, sharedmodels.KubernetesBackend, jobStatus.Backend)
+			assert.Equal(t, params.expectedStatus, jobStatus.Status)
+			assert.Equal(t, params.expectedFinished, jobStatus.Finished)
+			assert.Equal(t, params.expectedSucceeded, jobStatus.Succeeded)
+			assert.Equal(t, params.expectedReason, jobStatus.Reason)
+		})
+	}
+}
+
+func TestKubernetesHandler_BadCAData(t *testing.T) {
+	kubernetesConfig := &models.KubernetesConfig{APIServer: "https://example.com", CAData: "not base64!", ClusterName: "test"}
+	handler := newKubernetesHandler(aws.Config{Region: "us-east-1"}, newTestTaskConfig(), kubernetesConfig)
+	_, err := handler.Handle(context.Background(), sharedmodels.Dataset{ID: 1, VersionID: 1}, sharedmodels.User{}, nil, "", logging.Default)
+	assert.ErrorContains(t, err, "CA data")
+}
+
+func TestEKSTokenSource(t *testing.T) {
+	stsClient := sts.NewFromConfig(aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-key", "test-secret", ""),
+	})
+	token, err := eksTokenSource(stsClient, "test-cluster")(context.Background())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token, eksTokenPrefix))
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, eksTokenPrefix))
+	require.NoError(t, err)
+	presignedURL, err := url.Parse(string(decoded))
+	require.NoError(t, err)
+	query := presignedURL.Query()
+	assert.Equal(t, "GetCallerIdentity", query.Get("Action"))
+	assert.Contains(t, query.Get("X-Amz-SignedHeaders"), eksClusterIDHeader)
+	assert.NotEmpty(t, query.Get("X-Amz-Signature"))
+}
+
+func newTestKubernetesHandler(apiServer string, taskConfig *models.ECSTaskConfig) *kubernetesHandler {
+	return &kubernetesHandler{
+		taskConfig: taskConfig,
+		kubernetesConfig: &models.KubernetesConfig{
+			APIServer:      apiServer,
+			ClusterName:    "test-cluster",
+			Namespace:      "rehydration",
+			Image:          "pennsieve/rehydrate:test",
+			ServiceAccount: "rehydrate",
+			Env:            map[string]string{"REHYDRATION_TTL_DAYS": "14", "REHYDRATION_BUCKET": "test-bucket", "ENV": "test"},
+		},
+		httpClient: http.DefaultClient,
+		token: func(_ context.Context) (string, error) {
+			return testToken, nil
+		},
+	}
+}
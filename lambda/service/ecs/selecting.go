@@ -0,0 +1,76 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/shared/discover"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+)
+
+// datasetSizeFunc returns the total size in bytes of a dataset version
+type datasetSizeFunc func(ctx context.Context, dataset sharedmodels.Dataset) (int64, error)
+
+// selectingHandler picks a backend for each dataset based on its size and delegates to that backend's Handler
+type selectingHandler struct {
+	backendConfig *models.BackendConfig
+	datasetSize   datasetSizeFunc
+	handlers      map[sharedmodels.Backend]Handler
+}
+
+func newSelectingHandler(awsConfig aws.Config, taskConfig *models.ECSTaskConfig, taskHandler Handler) Handler {
+	backendConfig := taskConfig.Backends
+	handlers := map[sharedmodels.Backend]Handler{sharedmodels.ECSBackend: taskHandler}
+	if backendConfig.Batch != nil {
+		handlers[sharedmodels.BatchBackend] = newBatchHandler(batch.NewFromConfig(awsConfig), taskConfig, backendConfig.Batch)
+	}
+	if backendConfig.Kubernetes != nil {
+		handlers[sharedmodels.KubernetesBackend] = newKubernetesHandler(awsConfig, taskConfig, backendConfig.Kubernetes)
+	}
+	pennsieveClient := pennsieve.NewClient(pennsieve.APIParams{ApiHost: backendConfig.PennsieveHost})
+	return &selectingHandler{
+		backendConfig: backendConfig,
+		datasetSize: func(ctx context.Context, dataset sharedmodels.Dataset) (int64, error) {
+			return discover.DatasetSize(ctx, pennsieveClient, dataset)
+		},
+		handlers: handlers,
+	}
+}
+
+// Handle uses ECS if the dataset size cannot be determined, since that is what would have been used before other
+// backends were available.
+func (h *selectingHandler) Handle(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, destination *sharedmodels.Destination, requestID string, logger *slog.Logger) (string, error) {
+	backend := sharedmodels.ECSBackend
+	if size, err := h.datasetSize(ctx, dataset); err != nil {
+		logger.Warn("unable to get dataset size; defaulting to ECS", slog.Any("error", err))
+	} else {
+		backend = h.backendConfig.BackendFor(size)
+		logger.Info("selected rehydration backend", slog.String("backend", string(backend)), slog.Int64("datasetSize", size))
+	}
+	handler, err := h.handler(backend)
+	if err != nil {
+		return "", err
+	}
+	return handler.Handle(ctx, dataset, user, destination, requestID, logger)
+}
+
+func (h *selectingHandler) Status(ctx context.Context, jobID string) (*JobStatus, error) {
+	backend, _ := sharedmodels.ParseJobID(jobID)
+	handler, err := h.handler(backend)
+	if err != nil {
+		return nil, err
+	}
+	return handler.Status(ctx, jobID)
+}
+
+func (h *selectingHandler) handler(backend sharedmodels.Backend) (Handler, error) {
+	handler, ok := h.handlers[backend]
+	if !ok {
+		return nil, fmt.Errorf("no handler configured for backend %s", backend)
+	}
+	return handler, nil
+}
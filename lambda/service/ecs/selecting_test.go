@@ -0,0 +1,82 @@
+package ecs
+
+import (
+	"context"
+	"errors"
+	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"testing"
+)
+
+func TestSelectingHandler_Handle(t *testing.T) {
+	backendConfig := &models.BackendConfig{
+		Batch:      &models.BatchConfig{ThresholdBytes: 1000},
+		Kubernetes: &models.KubernetesConfig{ThresholdBytes: 5000},
+	}
+	for name, params := range map[string]struct {
+		size            int64
+		sizeErr         error
+		expectedBackend sharedmodels.Backend
+	}{
+		"small":            {size: 999, expectedBackend: sharedmodels.ECSBackend},
+		"batch threshold":  {size: 1000, expectedBackend: sharedmodels.BatchBackend},
+		"large":            {size: 4999, expectedBackend: sharedmodels.BatchBackend},
+		"k8s threshold":    {size: 5000, expectedBackend: sharedmodels.KubernetesBackend},
+		"size lookup fail": {sizeErr: errors.New("discover error"), expectedBackend: sharedmodels.ECSBackend},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handlers := map[sharedmodels.Backend]Handler{
+				sharedmodels.ECSBackend:        &fakeHandler{backend: sharedmodels.ECSBackend},
+				sharedmodels.BatchBackend:      &fakeHandler{backend: sharedmodels.BatchBackend},
+				sharedmodels.KubernetesBackend: &fakeHandler{backend: sharedmodels.KubernetesBackend},
+			}
+			handler := &selectingHandler{
+				backendConfig: backendConfig,
+				datasetSize: func(_ context.Context, _ sharedmodels.Dataset) (int64, error) {
+					return params.size, params.sizeErr
+				},
+				handlers: handlers,
+			}
+			jobID, err := handler.Handle(context.Background(), sharedmodels.Dataset{ID: 1, VersionID: 1}, sharedmodels.User{}, nil, "", logging.Default)
+			require.NoError(t, err)
+			assert.Equal(t, sharedmodels.QualifiedJobID(params.expectedBackend, "job"), jobID)
+			for backend, h := range handlers {
+				assert.Equal(t, backend == params.expectedBackend, h.(*fakeHandler).handled, backend)
+			}
+
+			jobStatus, err := handler.Status(context.Background(), jobID)
+			require.NoError(t, err)
+			assert.Equal(t, params.expectedBackend, jobStatus.Backend)
+		})
+	}
+}
+
+func TestSelectingHandler_Handle_NotConfigured(t *testing.T) {
+	handler := &selectingHandler{
+		backendConfig: &models.BackendConfig{Batch: &models.BatchConfig{ThresholdBytes: 10}},
+		datasetSize: func(_ context.Context, _ sharedmodels.Dataset) (int64, error) {
+			return 100, nil
+		},
+		handlers: map[sharedmodels.Backend]Handler{sharedmodels.ECSBackend: &fakeHandler{backend: sharedmodels.ECSBackend}},
+	}
+	_, err := handler.Handle(context.Background(), sharedmodels.Dataset{ID: 1, VersionID: 1}, sharedmodels.User{}, nil, "", logging.Default)
+	assert.ErrorContains(t, err, "no handler configured for backend batch")
+}
+
+type fakeHandler struct {
+	backend sharedmodels.Backend
+	handled bool
+}
+
+func (f *fakeHandler) Handle(_ context.Context, _ sharedmodels.Dataset, _ sharedmodels.User, _ *sharedmodels.Destination, _ string, _ *slog.Logger) (string, error) {
+	f.handled = true
+	return sharedmodels.QualifiedJobID(f.backend, "job"), nil
+}
+
+func (f *fakeHandler) Status(_ context.Context, jobID string) (*JobStatus, error) {
+	return &JobStatus{JobID: jobID, Backend: f.backend}, nil
+}
@@ -0,0 +1,59 @@
+package estimate
+
+import (
+	"fmt"
+	"github.com/pennsieve/rehydration-service/shared"
+	"os"
+	"strconv"
+)
+
+// CopyBytesPerSecondKey is the total rate at which the rehydration task is expected to copy file contents
+const CopyBytesPerSecondKey = "ESTIMATE_COPY_BYTES_PER_SECOND"
+
+// CopyFilesPerSecondKey is the number of files the rehydration task is expected to start copying each second,
+// independent of their size
+const CopyFilesPerSecondKey = "ESTIMATE_COPY_FILES_PER_SECOND"
+
+// StoragePricePerGBMonthKey is the price in USD of storing one GiB for a 30-day month in the rehydration bucket
+const StoragePricePerGBMonthKey = "ESTIMATE_STORAGE_PRICE_PER_GB_MONTH"
+
+const DefaultCopyBytesPerSecond = int64(100 * 1024 * 1024)
+const DefaultCopyFilesPerSecond = 20
+
+// DefaultStoragePricePerGBMonth is the S3 Standard price for the first 50 TB in us-east-1
+const DefaultStoragePricePerGBMonth = 0.023
+
+type Config struct {
+	CopyBytesPerSecond     int64
+	CopyFilesPerSecond     int
+	StoragePricePerGBMonth float64
+}
+
+// ConfigFromEnvironment uses the default for any setting that is not set.
+func ConfigFromEnvironment() (*Config, error) {
+	config := &Config{
+		CopyBytesPerSecond:     DefaultCopyBytesPerSecond,
+		CopyFilesPerSecond:     DefaultCopyFilesPerSecond,
+		StoragePricePerGBMonth: DefaultStoragePricePerGBMonth,
+	}
+	var err error
+	if _, set := os.LookupEnv(CopyBytesPerSecondKey); set {
+		if config.CopyBytesPerSecond, err = shared.Int64FromEnvVar(CopyBytesPerSecondKey); err != nil {
+			return nil, err
+		}
+	}
+	if _, set := os.LookupEnv(CopyFilesPerSecondKey); set {
+		if config.CopyFilesPerSecond, err = shared.IntFromEnvVar(CopyFilesPerSecondKey); err != nil {
+			return nil, err
+		}
+	}
+	if value, set := os.LookupEnv(StoragePricePerGBMonthKey); set {
+		if config.StoragePricePerGBMonth, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("error converting value %s of %s to float: %w", value, StoragePricePerGBMonthKey, err)
+		}
+	}
+	if config.CopyBytesPerSecond <= 0 || config.CopyFilesPerSecond <= 0 {
+		return nil, fmt.Errorf("%s and %s must be positive", CopyBytesPerSecondKey, CopyFilesPerSecondKey)
+	}
+	return config, nil
+}
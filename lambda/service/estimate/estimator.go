@@ -0,0 +1,102 @@
+package estimate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/discover"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"math"
+)
+
+const bytesPerGB = 1024 * 1024 * 1024
+const daysPerMonth = 30
+
+// Estimate is the response body of an estimate request
+type Estimate struct {
+	sharedmodels.Dataset
+	TotalBytes int64 `json:"totalBytes"`
+	FileCount  int   `json:"fileCount"`
+	// MultipartFileCount is the number of files at or above sharedmodels.ThresholdSize
+	MultipartFileCount   int   `json:"multipartFileCount"`
+	EstimatedCopySeconds int64 `json:"estimatedCopySeconds"`
+	RehydrationTTLDays   int   `json:"rehydrationTTLDays"`
+	// EstimatedStorageCostUSD is the cost of keeping the rehydrated copy for RehydrationTTLDays
+	EstimatedStorageCostUSD float64 `json:"estimatedStorageCostUSD"`
+	// Rehydrated is true if a completed rehydration of the version is available now, in which case no copy is needed
+	Rehydrated bool `json:"rehydrated"`
+	// Status is the status of the version's idempotency record, if there is one
+	Status              idempotency.Status `json:"status,omitempty"`
+	RehydrationLocation string             `json:"rehydrationLocation,omitempty"`
+}
+
+func (e *Estimate) String() (string, error) {
+	bytes, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// datasetFilesFunc returns the files in a dataset version
+type datasetFilesFunc func(ctx context.Context, dataset sharedmodels.Dataset) ([]discover.DatasetFile, error)
+
+// Estimator estimates the size, copy duration, and storage cost of rehydrating a dataset version without starting
+// a rehydration.
+type Estimator struct {
+	config           *Config
+	idempotencyStore idempotency.Store
+	datasetFiles     datasetFilesFunc
+}
+
+func NewEstimator(config *Config, pennsieveHost string, idempotencyStore idempotency.Store) *Estimator {
+	pennsieveClient := pennsieve.NewClient(pennsieve.APIParams{ApiHost: pennsieveHost})
+	return &Estimator{
+		config:           config,
+		idempotencyStore: idempotencyStore,
+		datasetFiles: func(ctx context.Context, dataset sharedmodels.Dataset) ([]discover.DatasetFile, error) {
+			metadata, err := pennsieveClient.Discover.GetDatasetMetadataByVersion(ctx, int32(dataset.ID), int32(dataset.VersionID))
+			if err != nil {
+				return nil, fmt.Errorf("error getting metadata for dataset %d version %d: %w", dataset.ID, dataset.VersionID, err)
+			}
+			return metadata.Files, nil
+		},
+	}
+}
+
+// Estimate assumes that the dataset version will be kept for rehydrationTTLDays after it is rehydrated.
+func (e *Estimator) Estimate(ctx context.Context, dataset sharedmodels.Dataset, rehydrationTTLDays int) (*Estimate, error) {
+	files, err := e.datasetFiles(ctx, dataset)
+	if err != nil {
+		return nil, err
+	}
+	estimate := &Estimate{Dataset: dataset, FileCount: len(files), RehydrationTTLDays: rehydrationTTLDays}
+	for _, file := range files {
+		estimate.TotalBytes += file.Size
+		if file.Size >= sharedmodels.ThresholdSize {
+			estimate.MultipartFileCount++
+		}
+	}
+
+	copySeconds := float64(estimate.TotalBytes)/float64(e.config.CopyBytesPerSecond) +
+		float64(estimate.FileCount)/float64(e.config.CopyFilesPerSecond)
+	estimate.EstimatedCopySeconds = int64(math.Ceil(copySeconds))
+
+	gb := float64(estimate.TotalBytes) / bytesPerGB
+	estimate.EstimatedStorageCostUSD = gb * e.config.StoragePricePerGBMonth * float64(rehydrationTTLDays) / daysPerMonth
+
+	record, err := e.idempotencyStore.GetRecord(ctx, idempotency.RecordID(dataset.ID, dataset.VersionID))
+	if err != nil {
+		return nil, err
+	}
+	if record != nil {
+		estimate.Status = record.Status
+		estimate.Rehydrated = record.Status == idempotency.Completed
+		if estimate.Rehydrated {
+			estimate.RehydrationLocation = record.RehydrationLocation
+		}
+	}
+	return estimate, nil
+}
@@ -0,0 +1,87 @@
+package estimate
+
+import (
+	"context"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/discover"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/test/discovertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+var testConfig = &Config{
+	CopyBytesPerSecond:     100 * 1024 * 1024,
+	CopyFilesPerSecond:     10,
+	StoragePricePerGBMonth: 0.03,
+}
+
+func TestEstimator_Estimate(t *testing.T) {
+	ctx := context.Background()
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+	files := []discover.DatasetFile{
+		{Name: "small.txt", Path: "files/small.txt", Size: 1024},
+		{Name: "threshold.dat", Path: "files/threshold.dat", Size: sharedmodels.ThresholdSize},
+		{Name: "large.dat", Path: "files/large.dat", Size: 2*bytesPerGB - sharedmodels.ThresholdSize - 1024},
+	}
+	mockDiscover := discovertest.NewServerFixture(t, nil, discovertest.GetDatasetMetadataByVersionHandlerBuilder(dataset, files))
+	defer mockDiscover.Teardown()
+
+	idempotencyStore := &fakeIdempotencyStore{records: map[string]*idempotency.Record{}}
+	estimator := NewEstimator(testConfig, mockDiscover.Server.URL, idempotencyStore)
+
+	estimate, err := estimator.Estimate(ctx, dataset, 15)
+	require.NoError(t, err)
+	assert.Equal(t, dataset, estimate.Dataset)
+	assert.Equal(t, int64(2*bytesPerGB), estimate.TotalBytes)
+	assert.Equal(t, 3, estimate.FileCount)
+	assert.Equal(t, 1+1, estimate.MultipartFileCount)
+	// 2048 MiB at 100 MiB/s plus 3 files at 10 per second
+	assert.Equal(t, int64(21), estimate.EstimatedCopySeconds)
+	assert.Equal(t, 15, estimate.RehydrationTTLDays)
+	// 2 GiB for half a month
+	assert.InDelta(t, 0.03, estimate.EstimatedStorageCostUSD, 1e-9)
+	assert.False(t, estimate.Rehydrated)
+	assert.Empty(t, estimate.Status)
+
+	recordID := idempotency.RecordID(dataset.ID, dataset.VersionID)
+	idempotencyStore.records[recordID] = idempotency.NewRecord(recordID, idempotency.InProgress)
+	estimate, err = estimator.Estimate(ctx, dataset, 15)
+	require.NoError(t, err)
+	assert.False(t, estimate.Rehydrated)
+	assert.Equal(t, idempotency.InProgress, estimate.Status)
+
+	location := "s3://test-bucket/5065/2/"
+	idempotencyStore.records[recordID] = idempotency.NewRecord(recordID, idempotency.Completed).WithRehydrationLocation(location)
+	estimate, err = estimator.Estimate(ctx, dataset, 15)
+	require.NoError(t, err)
+	assert.True(t, estimate.Rehydrated)
+	assert.Equal(t, idempotency.Completed, estimate.Status)
+	assert.Equal(t, location, estimate.RehydrationLocation)
+}
+
+func TestEstimator_Estimate_DiscoverError(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		test.NewHandlerFuncBuilder(discovertest.GetDatasetMetadataByVersionPath(dataset)).
+			WithStatusCode(http.StatusInternalServerError).
+			WithModel(discovertest.ErrorResponse("unavailable", http.StatusInternalServerError)))
+	defer mockDiscover.Teardown()
+
+	estimator := NewEstimator(testConfig, mockDiscover.Server.URL, &fakeIdempotencyStore{records: map[string]*idempotency.Record{}})
+	_, err := estimator.Estimate(context.Background(), dataset, 15)
+	assert.Error(t, err)
+}
+
+// fakeIdempotencyStore implements only the idempotency.Store methods used by Estimator
+type fakeIdempotencyStore struct {
+	idempotency.Store
+	records map[string]*idempotency.Record
+}
+
+func (s *fakeIdempotencyStore) GetRecord(_ context.Context, recordID string) (*idempotency.Record, error) {
+	return s.records[recordID], nil
+}
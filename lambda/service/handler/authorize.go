@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pennsieve/rehydration-service/service/access"
+	"github.com/pennsieve/rehydration-service/service/request"
+	"github.com/pennsieve/rehydration-service/shared/lambdautils"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+	"net/http"
+)
+
+// requestErrorResponse is the response to an error returned while creating a request from lambdaRequest
+func requestErrorResponse(err error, lambdaRequest events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	var badRequest *request.BadRequestError
+	if errors.As(err, &badRequest) {
+		return lambdautils.ErrorResponse(http.StatusBadRequest, err, lambdaRequest)
+	}
+	var unauthorized *request.UnauthorizedError
+	if errors.As(err, &unauthorized) {
+		return lambdautils.ErrorResponse(http.StatusUnauthorized, err, lambdaRequest)
+	}
+	var forbidden *request.ForbiddenError
+	if errors.As(err, &forbidden) {
+		return lambdautils.ErrorResponse(http.StatusForbidden, err, lambdaRequest)
+	}
+	return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+}
+
+// authorize checks with Discover that principal can access dataset. It returns nil if so, and otherwise the response to
+// send instead of handling the request: 404 if the version does not exist, 403 if it cannot be accessed, and 500 if
+// the check failed.
+func authorize(ctx context.Context, lambdaRequest events.APIGatewayV2HTTPRequest, pennsieveHost string, principal *request.Principal, dataset sharedmodels.Dataset, logger *slog.Logger) *events.APIGatewayV2HTTPResponse {
+	accessChecker := AccessCheckerFactory(pennsieveHost)
+	// API Gateway lower-cases header names
+	err := accessChecker.Check(ctx, dataset, principal, lambdaRequest.Headers["authorization"])
+	if err == nil {
+		return nil
+	}
+	statusCode := http.StatusInternalServerError
+	var notFound *access.NotFoundError
+	var forbidden *access.ForbiddenError
+	switch {
+	case errors.As(err, &notFound):
+		statusCode = http.StatusNotFound
+	case errors.As(err, &forbidden):
+		statusCode = http.StatusForbidden
+	}
+	if statusCode == http.StatusInternalServerError {
+		logger.Error("error checking dataset access", "error", err)
+	} else {
+		logger.Info("rejecting request", slog.Any("reason", err))
+	}
+	response, _ := lambdautils.ErrorResponse(statusCode, err, lambdaRequest)
+	return &response
+}
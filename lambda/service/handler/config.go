@@ -1,13 +1,23 @@
 package handler
 
 import (
+	"github.com/pennsieve/rehydration-service/service/request"
 	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/discover"
 	"github.com/pennsieve/rehydration-service/shared/expiration"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/regions"
+	"os"
 )
 
 type RehydrationServiceHandlerConfig struct {
 	AWSRegion          string
 	RehydrationTTLDays int
+	Auth               *request.AuthConfig
+	// PennsieveHost is used to check that requested dataset versions exist and are accessible
+	PennsieveHost string
+	// Buckets are the regions other than AWSRegion that requests can choose as a destination
+	Buckets *regions.Buckets
 }
 
 func RehydrationServiceHandlerConfigFromEnvironment() (*RehydrationServiceHandlerConfig, error) {
@@ -19,5 +29,23 @@ func RehydrationServiceHandlerConfigFromEnvironment() (*RehydrationServiceHandle
 	if err != nil {
 		return nil, err
 	}
-	return &RehydrationServiceHandlerConfig{AWSRegion: awsRegion, RehydrationTTLDays: rehydrationTTLDays}, nil
+	env, err := shared.NonEmptyFromEnvVar(sharedmodels.ECSTaskEnvKey)
+	if err != nil {
+		return nil, err
+	}
+	buckets, err := regions.FromLookup(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	authConfig, err := request.AuthConfigFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	return &RehydrationServiceHandlerConfig{
+		AWSRegion:          awsRegion,
+		RehydrationTTLDays: rehydrationTTLDays,
+		Auth:               authConfig,
+		PennsieveHost:      discover.APIHost(env),
+		Buckets:            buckets,
+	}, nil
 }
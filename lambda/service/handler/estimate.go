@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/pennsieve/rehydration-service/service/estimate"
+	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/service/request"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/lambdautils"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// EstimateRouteSuffix ends the route keys of estimate requests, for example "POST /discover/rehydrate/estimate".
+const EstimateRouteSuffix = "/estimate"
+
+func isEstimateRequest(lambdaRequest events.APIGatewayV2HTTPRequest) bool {
+	return strings.HasSuffix(lambdaRequest.RouteKey, EstimateRouteSuffix)
+}
+
+// EstimateHandler responds with the size, estimated copy duration, and storage cost of rehydrating a dataset version,
+// and whether it is already rehydrated. Nothing is started or recorded.
+func EstimateHandler(ctx context.Context, lambdaRequest events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	handlerConfig, err := RehydrationServiceHandlerConfigFromEnvironment()
+	if err != nil {
+		logger.Error("error getting Rehydration service configuration from environment variables", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	taskConfig, err := models.TaskConfigFromEnvironment()
+	if err != nil {
+		logger.Error("error getting ECS task configuration from environment variables", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	estimateConfig, err := estimate.ConfigFromEnvironment()
+	if err != nil {
+		logger.Error("error getting estimate configuration from environment variables", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+
+	awsConfig, err := AWSConfigFactory.Get(ctx)
+	if err != nil {
+		logger.Error("error getting AWS config", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+
+	estimateRequest, err := request.NewEstimateRequest(lambdaRequest, handlerConfig.Auth)
+	if err != nil {
+		logger.Error("error creating EstimateRequest", "error", err)
+		return requestErrorResponse(err, lambdaRequest)
+	}
+
+	if response := authorize(ctx, lambdaRequest, handlerConfig.PennsieveHost, estimateRequest.Principal, estimateRequest.Dataset, estimateRequest.Logger); response != nil {
+		return *response, nil
+	}
+
+	dyDBClient := dynamodb.NewFromConfig(*awsConfig)
+	idempotencyStore := idempotency.NewStore(dyDBClient, estimateRequest.Logger, taskConfig.IdempotencyTableName)
+	estimator := EstimatorFactory(estimateConfig, handlerConfig.PennsieveHost, idempotencyStore)
+
+	out, err := estimator.Estimate(ctx, estimateRequest.Dataset, handlerConfig.RehydrationTTLDays)
+	if err != nil {
+		estimateRequest.Logger.Error("error estimating rehydration", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	estimateRequest.Logger.Info("estimate complete",
+		slog.Int64("totalBytes", out.TotalBytes),
+		slog.Int("fileCount", out.FileCount),
+		slog.Bool("rehydrated", out.Rehydrated))
+
+	respBody, err := out.String()
+	if err != nil {
+		estimateRequest.Logger.Error("unable to marshall successful response", slog.Any("error", err))
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       respBody,
+	}, nil
+}
@@ -6,23 +6,91 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/pennsieve/rehydration-service/service/access"
 	"github.com/pennsieve/rehydration-service/service/ecs"
+	"github.com/pennsieve/rehydration-service/service/estimate"
 	"github.com/pennsieve/rehydration-service/service/idempotency"
 	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/service/queue"
+	"github.com/pennsieve/rehydration-service/service/quota"
 	"github.com/pennsieve/rehydration-service/service/request"
+	"github.com/pennsieve/rehydration-service/shared/audit"
 	"github.com/pennsieve/rehydration-service/shared/awsconfig"
+	sharedidempotency "github.com/pennsieve/rehydration-service/shared/idempotency"
 	"github.com/pennsieve/rehydration-service/shared/lambdautils"
 	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
 	"github.com/pennsieve/rehydration-service/shared/notification"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
 	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"go.opentelemetry.io/otel/attribute"
 	"log/slog"
+	"math"
 	"net/http"
+	"os"
+	"strconv"
 )
 
 var logger = logging.Default
+var serviceMetrics = metrics.Default.With(metrics.Dimensions{metrics.ComponentDimension: "service"})
 var AWSConfigFactory = awsconfig.NewFactory()
 
+// ECSHandlerFactory creates the ecs.Handler used to start rehydration tasks. Can be replaced, for example by
+// local.Runner's NewHandler, so that tasks are run somewhere other than ECS.
+var ECSHandlerFactory = ecs.NewHandler
+
+// AccessCheckerFactory creates the access.Checker used to check that requested dataset versions can be rehydrated. Can
+// be replaced so that tests do not need Discover.
+var AccessCheckerFactory = access.NewChecker
+
+// EstimatorFactory creates the estimate.Estimator used for estimate requests. Can be replaced so that tests do not need
+// Discover.
+var EstimatorFactory = estimate.NewEstimator
+
+// QueueFactory creates the queue.Queue used if rehydrations are queued. Can be replaced, for example by one returning a
+// queue.MemoryQueue, so that SQS is not required.
+var QueueFactory = queue.NewSQSQueue
+
+// RehydrationServiceHandler handles rehydration, estimate, and progress requests and emits a Requests metric with the outcome
+// of each one. Each request is the root span of a trace that continues in the rehydration task, if one is started.
 func RehydrationServiceHandler(ctx context.Context, lambdaRequest events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	operation, handle := "Rehydrate", handleRehydrationRequest
+	switch {
+	case isEstimateRequest(lambdaRequest):
+		operation, handle = "Estimate", EstimateHandler
+	case isProgressRequest(lambdaRequest):
+		operation, handle = "Progress", ProgressHandler
+	}
+	ctx, span := tracing.Start(ctx, "service."+operation, attribute.String("request.id", lambdaRequest.RequestContext.RequestID))
+	response, err := handle(ctx, lambdaRequest)
+	outcome := requestOutcome(response.StatusCode, err)
+	span.SetAttributes(attribute.Int("http.status_code", response.StatusCode), attribute.String("outcome", outcome))
+	tracing.End(span, err)
+	if flushErr := tracing.Flush(ctx); flushErr != nil {
+		logger.Warn("error exporting spans", slog.Any("error", flushErr))
+	}
+	serviceMetrics.Put("Requests", metrics.Count, 1, metrics.Dimensions{
+		"Operation": operation,
+		"Outcome":   outcome,
+	})
+	return response, err
+}
+
+// requestOutcome groups responses into the values of the Outcome dimension of the Requests metric
+func requestOutcome(statusCode int, err error) string {
+	switch {
+	case err != nil || statusCode >= http.StatusInternalServerError:
+		return "Error"
+	case statusCode == http.StatusTooManyRequests:
+		return "QuotaExceeded"
+	case statusCode >= http.StatusBadRequest:
+		return "Rejected"
+	default:
+		return "Accepted"
+	}
+}
+
+func handleRehydrationRequest(ctx context.Context, lambdaRequest events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
 	handlerConfig, err := RehydrationServiceHandlerConfigFromEnvironment()
 	if err != nil {
 		logger.Error("error getting Rehydration service configuration from environment variables", "error", err)
@@ -33,46 +101,104 @@ func RehydrationServiceHandler(ctx context.Context, lambdaRequest events.APIGate
 		logger.Error("error getting ECS task configuration from environment variables", "error", err)
 		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
 	}
+	queueConfig, err := queue.ConfigFromEnvironment()
+	if err != nil {
+		logger.Error("error getting queue configuration from environment variables", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	// only the dispatcher starts tasks again after they exit to wait for restores, so without it they wait themselves
+	taskConfig.ResumeRestores = queueConfig != nil
+	quotaConfig, err := quota.ConfigFromEnvironment()
+	if err != nil {
+		logger.Error("error getting quota configuration from environment variables", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
 
 	awsConfig, err := AWSConfigFactory.Get(ctx)
 	if err != nil {
 		logger.Error("error getting AWS config", "error", err)
 		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
 	}
+	auditStore, err := audit.StoreFromLookup(os.LookupEnv, *awsConfig, logger)
+	if err != nil {
+		logger.Error("error getting audit configuration from environment variables", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
 
-	ecsHandler := ecs.NewHandler(*awsConfig, taskConfig)
+	ecsHandler := ECSHandlerFactory(*awsConfig, taskConfig)
 
-	rehydrationRequest, err := request.NewRehydrationRequest(lambdaRequest, handlerConfig.RehydrationTTLDays)
+	rehydrationRequest, err := request.NewRehydrationRequest(lambdaRequest, handlerConfig.RehydrationTTLDays, handlerConfig.Auth, handlerConfig.Buckets)
 	if err != nil {
 		logger.Error("error creating RehydrationRequest", "error", err)
-		var badRequest *request.BadRequestError
-		if errors.As(err, &badRequest) {
-			return lambdautils.ErrorResponse(http.StatusBadRequest, err, lambdaRequest)
-		}
-		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+		return requestErrorResponse(err, lambdaRequest)
 	}
+	rehydrationRequest.Audit = audit.NewRecorder(auditStore, audit.ServiceSource, rehydrationRequest.Logger)
 
 	dyDBClient := dynamodb.NewFromConfig(*awsConfig)
 	sesClient := ses.NewFromConfig(*awsConfig)
 
 	trackingStore := tracking.NewStore(dyDBClient, rehydrationRequest.Logger, taskConfig.TrackingTableName)
 
-	emailer, err := notification.NewEmailer(sesClient, taskConfig.PennsieveDomain, handlerConfig.AWSRegion)
+	if response := authorize(ctx, lambdaRequest, handlerConfig.PennsieveHost, rehydrationRequest.Principal, rehydrationRequest.Dataset, rehydrationRequest.Logger); response != nil {
+		if response.StatusCode == http.StatusInternalServerError {
+			rehydrationRequest.WriteNewUnknownRequest(ctx, trackingStore)
+		}
+		return *response, nil
+	}
+
+	emailRegion := handlerConfig.AWSRegion
+	if region := rehydrationRequest.DestinationRegion(); len(region) > 0 {
+		emailRegion = region
+	}
+	emailer, err := notification.NewEmailer(sesClient, taskConfig.PennsieveDomain, emailRegion)
 	if err != nil {
 		rehydrationRequest.Logger.Error("error creating emailer", "error", err)
 		rehydrationRequest.WriteNewUnknownRequest(ctx, trackingStore)
 		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
 	}
 
+	var quotaChecker *quota.Checker
+	var usage *quota.Usage
+	if quotaConfig != nil {
+		store := sharedidempotency.NewStore(dyDBClient, rehydrationRequest.Logger, taskConfig.IdempotencyTableName)
+		quotaChecker = quota.NewChecker(quotaConfig, dyDBClient, store, rehydrationRequest.Logger)
+		if usage, err = quotaChecker.Check(ctx, rehydrationRequest.Dataset, rehydrationRequest.User, rehydrationRequest.Destination); err != nil {
+			var exceededError *quota.ExceededError
+			if errors.As(err, &exceededError) {
+				rehydrationRequest.Logger.Info("rejecting request", slog.Any("reason", err), slog.Duration("retryAfter", exceededError.RetryAfter))
+				return quotaExceededResponse(exceededError, lambdaRequest)
+			}
+			rehydrationRequest.Logger.Error("error checking quotas", "error", err)
+			rehydrationRequest.WriteNewUnknownRequest(ctx, trackingStore)
+			return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+		}
+	}
+
 	idempotencyConfig := idempotency.Config{
 		Client:           dyDBClient,
 		IdempotencyTable: taskConfig.IdempotencyTableName,
 	}
+	var rehydrationQueue queue.Queue
+	if queueConfig != nil {
+		rehydrationQueue = QueueFactory(*awsConfig, queueConfig)
+		idempotencyConfig.Queue = rehydrationQueue
+	}
 
 	handler := idempotency.NewHandler(idempotencyConfig, rehydrationRequest, ecsHandler)
 
 	out, err := handler.Handle(ctx)
 	if err != nil {
+		if quotaChecker != nil {
+			// a request that was not handled should not count against the user
+			if err := quotaChecker.Release(ctx, usage); err != nil {
+				rehydrationRequest.Logger.Warn("error releasing quota usage", slog.Any("error", err))
+			}
+		}
+		var regionConflictError idempotency.RegionConflictError
+		if errors.As(err, &regionConflictError) {
+			rehydrationRequest.Logger.Info("rejecting request", slog.Any("reason", err))
+			return lambdautils.ErrorResponse(http.StatusConflict, err, lambdaRequest)
+		}
 		rehydrationRequest.Logger.Error("error handling RehydrationRequest", "error", err)
 		var expiredError idempotency.ExpiredError
 		if errors.As(err, &expiredError) {
@@ -88,9 +214,21 @@ func RehydrationServiceHandler(ctx context.Context, lambdaRequest events.APIGate
 	}
 
 	completionLogAttrs := []any{slog.String("fargateTaskARN", out.TaskARN)}
-	if len(out.RehydrationLocation) != 0 {
+	if len(out.RehydrationLocation) != 0 && len(out.MissingFiles) != 0 {
+		// an already completed, non-expired rehydration that is missing some files
+		emailSentDate := rehydrationRequest.SendPartialEmail(ctx, emailer, out.RehydrationLocation, out.MissingFiles)
+		if emailSentDate == nil {
+			serviceMetrics.Put("EmailSendFailures", metrics.Count, 1, metrics.Dimensions{"RehydrationStatus": string(tracking.Partial)})
+		}
+		rehydrationRequest.WriteNewPartialRequest(ctx, trackingStore, out.TaskARN, emailSentDate)
+		completionLogAttrs = append(completionLogAttrs, slog.String("rehydrationLocation", out.RehydrationLocation),
+			slog.Int("missingFileCount", len(out.MissingFiles)))
+	} else if len(out.RehydrationLocation) != 0 {
 		// this will only be true if this request is for an already completed, non-expired rehydration
 		emailSentDate := rehydrationRequest.SendCompletedEmail(ctx, emailer, out.RehydrationLocation)
+		if emailSentDate == nil {
+			serviceMetrics.Put("EmailSendFailures", metrics.Count, 1, metrics.Dimensions{"RehydrationStatus": string(tracking.Completed)})
+		}
 		rehydrationRequest.WriteNewCompletedRequest(ctx, trackingStore, out.TaskARN, emailSentDate)
 		completionLogAttrs = append(completionLogAttrs, slog.String("rehydrationLocation", out.RehydrationLocation))
 	} else {
@@ -98,6 +236,17 @@ func RehydrationServiceHandler(ctx context.Context, lambdaRequest events.APIGate
 	}
 	rehydrationRequest.Logger.Info("request complete", completionLogAttrs...)
 
+	if out.Status == sharedidempotency.Queued {
+		store := sharedidempotency.NewStore(dyDBClient, rehydrationRequest.Logger, taskConfig.IdempotencyTableName)
+		dispatcher := queue.NewDispatcher(rehydrationQueue, store, ecsHandler, queueConfig.MaxConcurrent, rehydrationRequest.Logger)
+		dispatcher.SetAudit(rehydrationRequest.Audit)
+		// Start queued rehydrations now if there is room instead of waiting for the next scheduled dispatch.
+		// Errors are only logged since the request itself has been queued successfully.
+		if _, err := dispatcher.Dispatch(ctx); err != nil {
+			rehydrationRequest.Logger.Warn("error dispatching queued rehydrations", slog.Any("error", err))
+		}
+	}
+
 	respBody, err := out.String()
 	if err != nil {
 		rehydrationRequest.Logger.Error("unable to marshall successful response", slog.Any("error", err))
@@ -109,3 +258,12 @@ func RehydrationServiceHandler(ctx context.Context, lambdaRequest events.APIGate
 		Body:       respBody,
 	}, nil
 }
+
+// quotaExceededResponse is a 429 with a Retry-After header in seconds, unless retrying will never succeed.
+func quotaExceededResponse(exceededError *quota.ExceededError, lambdaRequest events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	response, err := lambdautils.ErrorResponse(http.StatusTooManyRequests, exceededError, lambdaRequest)
+	if exceededError.RetryAfter > 0 {
+		response.Headers["Retry-After"] = strconv.Itoa(int(math.Ceil(exceededError.RetryAfter.Seconds())))
+	}
+	return response, err
+}
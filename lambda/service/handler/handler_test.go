@@ -3,19 +3,29 @@ package handler_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/google/uuid"
+	"github.com/pennsieve/rehydration-service/service/access"
+	"github.com/pennsieve/rehydration-service/service/ecs"
+	"github.com/pennsieve/rehydration-service/service/estimate"
 	"github.com/pennsieve/rehydration-service/service/handler"
 	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/service/quota"
+	"github.com/pennsieve/rehydration-service/service/quota/quotatest"
+	rehydrationrequest "github.com/pennsieve/rehydration-service/service/request"
 	"github.com/pennsieve/rehydration-service/shared"
 	"github.com/pennsieve/rehydration-service/shared/expiration"
 	sharedidempotency "github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
 	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
 	"github.com/pennsieve/rehydration-service/shared/notification"
 	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/test/discovertest"
+	"github.com/pennsieve/rehydration-service/shared/test/metricstest"
 	"github.com/pennsieve/rehydration-service/shared/tracking"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -36,7 +46,11 @@ var rehydrationServiceHandlerEnv = test.NewEnvironmentVariables().
 	With(tracking.TableNameKey, "TestRehydrationTracking").
 	With(notification.PennsieveDomainKey, "pennsieve.example.com").
 	With(shared.AWSRegionKey, "test-1").
-	With(expiration.RehydrationTTLDays, "14")
+	With(expiration.RehydrationTTLDays, "14").
+	With(rehydrationrequest.ServiceAccountARNsKey, testServiceAccountARN).
+	With(sharedmodels.ECSTaskEnvKey, "test")
+
+var testServiceAccountARN = "arn:aws:sts::123456789012:assumed-role/test-service-account"
 
 func TestRehydrationServiceHandler(t *testing.T) {
 	rehydrationServiceHandlerEnv.Setenv(t)
@@ -50,7 +64,7 @@ func TestRehydrationServiceHandler(t *testing.T) {
 	fixture := NewFixtureBuilder(t).withECSRequestAssertionFunc(request).withExpectedTaskARN(expectedTaskARN).withIdempotencyTable().withTrackingTable().build()
 	defer fixture.teardown()
 
-	lambdaRequest := newLambdaRequest(requestToBody(t, request))
+	lambdaRequest := newLambdaRequest(requestToBody(t, request), request.User)
 	ctx := context.Background()
 	expectedStatusCode := 202
 	beforeRequest := time.Now()
@@ -115,7 +129,7 @@ func TestRehydrationServiceHandler_InProgress(t *testing.T) {
 		Dataset: dataset,
 		User:    user,
 	}
-	lambdaRequest := newLambdaRequest(requestToBody(t, request))
+	lambdaRequest := newLambdaRequest(requestToBody(t, request), request.User)
 	ctx := context.Background()
 	expectedStatusCode := 202
 	beforeRequest := time.Now()
@@ -177,7 +191,7 @@ func TestRehydrationServiceHandler_Expired(t *testing.T) {
 		Dataset: dataset,
 		User:    user,
 	}
-	lambdaRequest := newLambdaRequest(requestToBody(t, request))
+	lambdaRequest := newLambdaRequest(requestToBody(t, request), request.User)
 	ctx := context.Background()
 
 	expectedStatusCode := 500
@@ -239,7 +253,7 @@ func TestRehydrationServiceHandler_Completed(t *testing.T) {
 		build()
 	defer fixture.teardown()
 
-	lambdaRequest := newLambdaRequest(requestToBody(t, request))
+	lambdaRequest := newLambdaRequest(requestToBody(t, request), request.User)
 	ctx := context.Background()
 
 	expectedStatusCode := 202
@@ -299,7 +313,7 @@ func TestRehydrationServiceHandler_ECSError(t *testing.T) {
 		Dataset: dataset,
 		User:    user,
 	}
-	lambdaRequest := newLambdaRequest(requestToBody(t, request))
+	lambdaRequest := newLambdaRequest(requestToBody(t, request), request.User)
 	ctx := context.Background()
 
 	beforeRequest := time.Now()
@@ -352,15 +366,235 @@ func TestRehydrationServiceHandler_BadRequests(t *testing.T) {
 	} {
 		t.Run(name, func(t *testing.T) {
 			ctx := context.Background()
-			request := newLambdaRequest(params.body)
+			request := newServiceAccountLambdaRequest(params.body)
+			metricsSink := metricstest.UseDefault(t)
 
 			response, err := handler.RehydrationServiceHandler(ctx, request)
 			require.NoError(t, err)
 			assert.Equal(t, http.StatusBadRequest, response.StatusCode,
 				"expected status code %v, got %v", http.StatusBadRequest, response.StatusCode)
 			assert.Contains(t, response.Body, params.expectedResponsePart)
+			assert.Equal(t, []float64{1}, metricsSink.Values("Requests", metrics.Dimensions{
+				metrics.ComponentDimension: "service",
+				"Operation":                "Rehydrate",
+				"Outcome":                  "Rejected",
+			}))
+		})
+	}
+}
+
+func TestRehydrationServiceHandler_QuotaExceeded(t *testing.T) {
+	rehydrationServiceHandlerEnv.Setenv(t)
+	test.NewEnvironmentVariables().
+		With(quota.TableNameKey, "TestRehydrationQuota").
+		With(quota.UserMaxRequestsKey, "1").
+		Setenv(t)
+
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	otherUser := sharedmodels.User{Name: "Other User", Email: "other@example.com"}
+	windowStart := time.Now().Truncate(24 * time.Hour)
+	previousRequests := quota.NewCounter(user.Email, quota.RequestsQuota, windowStart, 24*time.Hour, 1)
+
+	fixture := NewFixtureBuilder(t).
+		withExpectedTaskARN("arn:aws:ecs:test-task-arn").
+		withIdempotencyTable().
+		withTrackingTable().
+		withQuotaTable(*previousRequests).
+		build()
+	defer fixture.teardown()
+	ctx := context.Background()
+	metricsSink := metricstest.UseDefault(t)
+
+	// under quota
+	response, err := handler.RehydrationServiceHandler(ctx, newLambdaRequest(requestToBody(t, models.Request{Dataset: dataset, User: otherUser}), otherUser))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, response.StatusCode)
+	assert.Len(t, fixture.dyDB.Scan(ctx, fixture.quotaTable), 2)
+
+	// over quota
+	response, err = handler.RehydrationServiceHandler(ctx, newLambdaRequest(requestToBody(t, models.Request{Dataset: dataset, User: user}), user))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+	assert.Contains(t, response.Body, "quota exceeded")
+	retryAfter, err := strconv.Atoi(response.Headers["Retry-After"])
+	require.NoError(t, err)
+	assert.InDelta(t, time.Until(windowStart.Add(24*time.Hour)).Seconds(), retryAfter, 60)
+
+	// rejected requests are not recorded anywhere
+	assert.Len(t, fixture.dyDB.Scan(ctx, fixture.quotaTable), 2)
+	assert.Len(t, fixture.dyDB.Scan(ctx, fixture.trackingTable), 1)
+
+	assert.Equal(t, []float64{1}, metricsSink.Values("Requests", metrics.Dimensions{"Outcome": "Accepted"}))
+	assert.Equal(t, []float64{1}, metricsSink.Values("Requests", metrics.Dimensions{"Outcome": "QuotaExceeded"}))
+}
+
+func TestRehydrationServiceHandler_Unauthorized(t *testing.T) {
+	rehydrationServiceHandlerEnv.Setenv(t)
+
+	fixture := NewFixtureBuilder(t).build()
+	defer fixture.teardown()
+
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	body := requestToBody(t, models.Request{Dataset: sharedmodels.Dataset{ID: 3879, VersionID: 4}, User: user})
+	for name, params := range map[string]struct {
+		request            events.APIGatewayV2HTTPRequest
+		expectedStatusCode int
+	}{
+		"no authorizer claims": {newAuthorizedLambdaRequest(body, &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{}), http.StatusUnauthorized},
+		"untrusted IAM caller": {newAuthorizedLambdaRequest(body, &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+			IAM: &events.APIGatewayV2HTTPRequestContextAuthorizerIAMDescription{UserARN: "arn:aws:iam::123456789012:user/someone"},
+		}), http.StatusUnauthorized},
+		"different user": {newLambdaRequest(body, sharedmodels.User{Name: "Someone Else", Email: "someone@example.com"}), http.StatusForbidden},
+	} {
+		t.Run(name, func(t *testing.T) {
+			response, err := handler.RehydrationServiceHandler(context.Background(), params.request)
+			require.NoError(t, err)
+			assert.Equal(t, params.expectedStatusCode, response.StatusCode)
+		})
+	}
+}
+
+func TestRehydrationServiceHandler_NotAccessible(t *testing.T) {
+	rehydrationServiceHandlerEnv.Setenv(t)
+
+	fixture := NewFixtureBuilder(t).withTrackingTable().build()
+	defer fixture.teardown()
+
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	for name, params := range map[string]struct {
+		checkError         error
+		expectedStatusCode int
+	}{
+		"not found":  {&access.NotFoundError{}, http.StatusNotFound},
+		"forbidden":  {&access.ForbiddenError{}, http.StatusForbidden},
+		"unexpected": {errors.New("discover unavailable"), http.StatusInternalServerError},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handler.AccessCheckerFactory = func(_ string) access.Checker { return &fakeAccessChecker{err: params.checkError} }
+			request := models.Request{Dataset: sharedmodels.Dataset{ID: 3879, VersionID: 4}, User: user}
+			response, err := handler.RehydrationServiceHandler(context.Background(), newLambdaRequest(requestToBody(t, request), user))
+			require.NoError(t, err)
+			assert.Equal(t, params.expectedStatusCode, response.StatusCode)
 		})
 	}
+	// only the unexpected error is tracked
+	assert.Len(t, fixture.dyDB.Scan(context.Background(), fixture.trackingTable), 1)
+}
+
+func TestRehydrationServiceHandler_Estimate(t *testing.T) {
+	rehydrationServiceHandlerEnv.Setenv(t)
+
+	dataset := sharedmodels.Dataset{ID: 3879, VersionID: 4}
+	testFiles := discovertest.NewTestDatasetFiles(dataset, 5)
+	mockDiscover := discovertest.NewServerFixture(t, nil, discovertest.GetDatasetMetadataByVersionHandlerBuilder(dataset, testFiles.DatasetFiles()))
+	defer mockDiscover.Teardown()
+
+	recordID := sharedidempotency.RecordID(dataset.ID, dataset.VersionID)
+	location := "s3://test-rehydration-bucket/3879/4/"
+	completed := sharedidempotency.NewRecord(recordID, sharedidempotency.Completed).WithRehydrationLocation(location)
+	fixture := NewFixtureBuilder(t).withIdempotencyTable(*completed).withTrackingTable().build()
+	defer fixture.teardown()
+	handler.EstimatorFactory = func(config *estimate.Config, _ string, idempotencyStore sharedidempotency.Store) *estimate.Estimator {
+		return estimate.NewEstimator(config, mockDiscover.Server.URL, idempotencyStore)
+	}
+
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	lambdaRequest := newLambdaRequest(requestToBody(t, models.Request{Dataset: dataset}), user)
+	lambdaRequest.RouteKey = "POST /discover/rehydrate" + handler.EstimateRouteSuffix
+	response, err := handler.RehydrationServiceHandler(context.Background(), lambdaRequest)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode, response.Body)
+
+	var out estimate.Estimate
+	require.NoError(t, json.Unmarshal([]byte(response.Body), &out))
+	assert.Equal(t, dataset, out.Dataset)
+	assert.Equal(t, 5, out.FileCount)
+	assert.Zero(t, out.MultipartFileCount)
+	assert.Equal(t, 14, out.RehydrationTTLDays)
+	assert.True(t, out.Rehydrated)
+	assert.Equal(t, location, out.RehydrationLocation)
+
+	// estimates are not tracked
+	assert.Empty(t, fixture.dyDB.Scan(context.Background(), fixture.trackingTable))
+}
+
+func TestRehydrationServiceHandler_Progress(t *testing.T) {
+	rehydrationServiceHandlerEnv.Setenv(t)
+
+	inProgressDataset := sharedmodels.Dataset{ID: 3879, VersionID: 4}
+	inProgress := sharedidempotency.NewRecord(sharedidempotency.RecordID(inProgressDataset.ID, inProgressDataset.VersionID), sharedidempotency.InProgress)
+	inProgress.Progress = &sharedidempotency.Progress{FilesTotal: 10, FilesDone: 5, BytesTotal: 2000, BytesDone: 1500}
+	inProgress.FargateTaskARN = "arn:aws:ecs:us-east-1:000000000000:task/test-cluster/in-progress"
+	fixture := NewFixtureBuilder(t).withIdempotencyTable(*inProgress).withTrackingTable().build()
+	defer fixture.teardown()
+
+	jobStatus := &ecs.JobStatus{JobID: inProgress.FargateTaskARN, Backend: sharedmodels.ECSBackend, Status: "RUNNING"}
+	originalECSHandlerFactory := handler.ECSHandlerFactory
+	handler.ECSHandlerFactory = func(_ aws.Config, _ *models.ECSTaskConfig) ecs.Handler {
+		return &fakeECSHandler{statuses: map[string]*ecs.JobStatus{jobStatus.JobID: jobStatus}}
+	}
+	defer func() { handler.ECSHandlerFactory = originalECSHandlerFactory }()
+
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	for name, params := range map[string]struct {
+		dataset                 sharedmodels.Dataset
+		expectedStatusCode      int
+		expectedPercentComplete float64
+	}{
+		"in progress":     {inProgressDataset, http.StatusOK, 75},
+		"not rehydrated":  {sharedmodels.Dataset{ID: 3879, VersionID: 5}, http.StatusNotFound, 0},
+		"missing dataset": {sharedmodels.Dataset{}, http.StatusBadRequest, 0},
+	} {
+		t.Run(name, func(t *testing.T) {
+			lambdaRequest := newLambdaRequest("", user)
+			lambdaRequest.RouteKey = "GET /discover/rehydrate" + handler.ProgressRouteSuffix
+			lambdaRequest.QueryStringParameters = map[string]string{}
+			if params.dataset.ID != 0 {
+				lambdaRequest.QueryStringParameters[rehydrationrequest.DatasetIDParameter] = strconv.Itoa(params.dataset.ID)
+				lambdaRequest.QueryStringParameters[rehydrationrequest.DatasetVersionIDParameter] = strconv.Itoa(params.dataset.VersionID)
+			}
+			response, err := handler.RehydrationServiceHandler(context.Background(), lambdaRequest)
+			require.NoError(t, err)
+			require.Equal(t, params.expectedStatusCode, response.StatusCode, response.Body)
+			if params.expectedStatusCode != http.StatusOK {
+				return
+			}
+			var out handler.ProgressResponse
+			require.NoError(t, json.Unmarshal([]byte(response.Body), &out))
+			assert.Equal(t, params.dataset, out.Dataset)
+			assert.Equal(t, sharedidempotency.InProgress, out.Status)
+			assert.Equal(t, inProgress.Progress, out.Progress)
+			require.NotNil(t, out.PercentComplete)
+			assert.Equal(t, params.expectedPercentComplete, *out.PercentComplete)
+			assert.Equal(t, jobStatus, out.Job)
+		})
+	}
+
+	// progress requests are not tracked
+	assert.Empty(t, fixture.dyDB.Scan(context.Background(), fixture.trackingTable))
+}
+
+// fakeECSHandler reports the status of the jobs in statuses
+type fakeECSHandler struct {
+	ecs.Handler
+	statuses map[string]*ecs.JobStatus
+}
+
+func (h *fakeECSHandler) Status(_ context.Context, jobID string) (*ecs.JobStatus, error) {
+	if status, ok := h.statuses[jobID]; ok {
+		return status, nil
+	}
+	return nil, fmt.Errorf("no job %s", jobID)
+}
+
+// fakeAccessChecker returns err from every Check
+type fakeAccessChecker struct {
+	err error
+}
+
+func (c *fakeAccessChecker) Check(_ context.Context, _ sharedmodels.Dataset, _ *rehydrationrequest.Principal, _ string) error {
+	return c.err
 }
 
 func requestToBody(t *testing.T, request models.Request) string {
@@ -369,14 +603,34 @@ func requestToBody(t *testing.T, request models.Request) string {
 	return string(bytes)
 }
 
-func newLambdaRequest(body string) events.APIGatewayV2HTTPRequest {
+// newLambdaRequest returns a request authorized by a JWT with claims for the given user
+func newLambdaRequest(body string, user sharedmodels.User) events.APIGatewayV2HTTPRequest {
+	return newAuthorizedLambdaRequest(body, &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{
+			Claims: map[string]string{
+				rehydrationrequest.SubjectClaim: uuid.NewSHA1(uuid.NameSpaceURL, []byte(user.Email)).String(),
+				rehydrationrequest.EmailClaim:   user.Email,
+				rehydrationrequest.NameClaim:    user.Name,
+			},
+		},
+	})
+}
+
+// newServiceAccountLambdaRequest returns a request from testServiceAccountARN, so the user in the body is used as is
+func newServiceAccountLambdaRequest(body string) events.APIGatewayV2HTTPRequest {
+	return newAuthorizedLambdaRequest(body, &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		IAM: &events.APIGatewayV2HTTPRequestContextAuthorizerIAMDescription{
+			UserARN: testServiceAccountARN + "/test-session",
+		},
+	})
+}
+
+func newAuthorizedLambdaRequest(body string, authorizer *events.APIGatewayV2HTTPRequestContextAuthorizerDescription) events.APIGatewayV2HTTPRequest {
 	requestContext := events.APIGatewayV2HTTPRequestContext{
 		HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
 			Method: "POST",
 		},
-		Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
-			Lambda: make(map[string]interface{}),
-		},
+		Authorizer: authorizer,
 	}
 
 	return events.APIGatewayV2HTTPRequest{
@@ -409,6 +663,7 @@ type Fixture struct {
 	dyDB             *test.DynamoDBFixture
 	idempotencyTable string
 	trackingTable    string
+	quotaTable       string
 }
 
 func (f *Fixture) teardown() {
@@ -418,6 +673,8 @@ func (f *Fixture) teardown() {
 		f.dyDB.Teardown()
 	}
 	handler.AWSConfigFactory.Set(nil)
+	handler.AccessCheckerFactory = access.NewChecker
+	handler.EstimatorFactory = estimate.NewEstimator
 }
 
 type FixtureBuilder struct {
@@ -430,6 +687,7 @@ type FixtureBuilder struct {
 	putItemInputs           []*dynamodb.PutItemInput
 	idempotencyTableName    string
 	trackingTableName       string
+	quotaTableName          string
 }
 
 func NewFixtureBuilder(t *testing.T) *FixtureBuilder {
@@ -534,6 +792,20 @@ func (b *FixtureBuilder) withTrackingTable(entries ...tracking.Entry) *FixtureBu
 	return b
 }
 
+func (b *FixtureBuilder) withQuotaTable(counters ...quota.Counter) *FixtureBuilder {
+	table, ok := os.LookupEnv(quota.TableNameKey)
+	if !ok || len(table) == 0 {
+		assert.FailNow(b.testingT, "quota table name missing from environment variables or empty", "env var name: %s", quota.TableNameKey)
+	}
+	b.quotaTableName = table
+	b.createTableInputs = append(b.createTableInputs, quotatest.CreateTableInput(table))
+	for i := range counters {
+		counter := &counters[i]
+		b.putItemInputs = append(b.putItemInputs, test.ItemersToPutItemInputs(b.testingT, b.quotaTableName, counter)...)
+	}
+	return b
+}
+
 func (b *FixtureBuilder) withLoggedAWSRequests() *FixtureBuilder {
 	b.logAWSRequests = true
 	return b
@@ -549,6 +821,7 @@ func (b *FixtureBuilder) build() *Fixture {
 		WithSES(mockSES.Server.URL).
 		Config(context.Background(), b.logAWSRequests)
 	handler.AWSConfigFactory.Set(&awsConfig)
+	handler.AccessCheckerFactory = func(_ string) access.Checker { return &fakeAccessChecker{} }
 
 	dyDB := test.NewDynamoDBFixture(b.testingT, awsConfig, b.createTableInputs...).WithItems(b.putItemInputs...)
 
@@ -558,5 +831,6 @@ func (b *FixtureBuilder) build() *Fixture {
 		dyDB:             dyDB,
 		idempotencyTable: b.idempotencyTableName,
 		trackingTable:    b.trackingTableName,
+		quotaTable:       b.quotaTableName,
 	}
 }
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/pennsieve/rehydration-service/service/ecs"
+	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/service/request"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/lambdautils"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// ProgressRouteSuffix ends the route keys of progress requests, for example "GET /discover/rehydrate/progress".
+const ProgressRouteSuffix = "/progress"
+
+func isProgressRequest(lambdaRequest events.APIGatewayV2HTTPRequest) bool {
+	return strings.HasSuffix(lambdaRequest.RouteKey, ProgressRouteSuffix)
+}
+
+// ProgressResponse is the current state of a dataset version's rehydration
+type ProgressResponse struct {
+	Dataset             sharedmodels.Dataset `json:"dataset"`
+	Status              idempotency.Status   `json:"status"`
+	RehydrationLocation string               `json:"rehydrationLocation,omitempty"`
+	// Progress is nil until the rehydration task has started copying
+	Progress        *idempotency.Progress `json:"progress,omitempty"`
+	PercentComplete *float64              `json:"percentComplete,omitempty"`
+	// Job is the state of the rehydration task while the rehydration is in progress. Nil if it could not be found.
+	Job *ecs.JobStatus `json:"job,omitempty"`
+}
+
+// ProgressHandler responds with the status of a dataset version's rehydration and, while it is in progress, how far
+// along the rehydration task is. Responds with 404 if there is no current rehydration of the dataset version.
+func ProgressHandler(ctx context.Context, lambdaRequest events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	handlerConfig, err := RehydrationServiceHandlerConfigFromEnvironment()
+	if err != nil {
+		logger.Error("error getting Rehydration service configuration from environment variables", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	taskConfig, err := models.TaskConfigFromEnvironment()
+	if err != nil {
+		logger.Error("error getting ECS task configuration from environment variables", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+
+	awsConfig, err := AWSConfigFactory.Get(ctx)
+	if err != nil {
+		logger.Error("error getting AWS config", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+
+	progressRequest, err := request.NewProgressRequest(lambdaRequest, handlerConfig.Auth)
+	if err != nil {
+		logger.Error("error creating ProgressRequest", "error", err)
+		return requestErrorResponse(err, lambdaRequest)
+	}
+
+	if response := authorize(ctx, lambdaRequest, handlerConfig.PennsieveHost, progressRequest.Principal, progressRequest.Dataset, progressRequest.Logger); response != nil {
+		return *response, nil
+	}
+
+	dyDBClient := dynamodb.NewFromConfig(*awsConfig)
+	idempotencyStore := idempotency.NewStore(dyDBClient, progressRequest.Logger, taskConfig.IdempotencyTableName)
+	recordID := idempotency.RecordID(progressRequest.Dataset.ID, progressRequest.Dataset.VersionID)
+	record, err := idempotencyStore.GetRecord(ctx, recordID)
+	if err != nil {
+		progressRequest.Logger.Error("error getting idempotency record", "error", err)
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	if record == nil {
+		return lambdautils.ErrorResponse(http.StatusNotFound, fmt.Errorf("dataset version %s is not rehydrated or being rehydrated", recordID), lambdaRequest)
+	}
+
+	out := ProgressResponse{
+		Dataset:             progressRequest.Dataset,
+		Status:              record.Status,
+		RehydrationLocation: record.RehydrationLocation,
+		Progress:            record.Progress,
+	}
+	if record.Progress != nil {
+		percentComplete := record.Progress.PercentComplete()
+		out.PercentComplete = &percentComplete
+	}
+	if record.Status == idempotency.InProgress && len(record.FargateTaskARN) > 0 {
+		out.Job = jobStatus(ctx, ECSHandlerFactory(*awsConfig, taskConfig), record.FargateTaskARN, progressRequest.Logger)
+	}
+	respBody, err := json.Marshal(out)
+	if err != nil {
+		progressRequest.Logger.Error("unable to marshall successful response", slog.Any("error", err))
+		return lambdautils.ErrorResponse(http.StatusInternalServerError, err, lambdaRequest)
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(respBody),
+	}, nil
+}
+
+// jobStatus looks up the job that is running a rehydration. Returns nil if it cannot be found, since the rest of the
+// progress response is still useful without it.
+func jobStatus(ctx context.Context, ecsHandler ecs.Handler, jobID string, logger *slog.Logger) *ecs.JobStatus {
+	status, err := ecsHandler.Status(ctx, jobID)
+	if err != nil {
+		logger.Warn("error getting status of rehydration job", slog.String("jobID", jobID), slog.Any("error", err))
+		return nil
+	}
+	return status
+}
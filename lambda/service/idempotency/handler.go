@@ -7,9 +7,13 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/pennsieve/rehydration-service/service/ecs"
+	"github.com/pennsieve/rehydration-service/service/queue"
 	"github.com/pennsieve/rehydration-service/service/request"
+	"github.com/pennsieve/rehydration-service/shared/audit"
 	"github.com/pennsieve/rehydration-service/shared/expiration"
 	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"log/slog"
 	"time"
 )
@@ -20,11 +24,14 @@ type Config struct {
 	Client             *dynamodb.Client
 	IdempotencyTable   string
 	RehydrationTTLDays int
+	// Queue is nil if new rehydrations should be started immediately instead of queued
+	Queue queue.Queue
 }
 type Handler struct {
 	store      idempotency.Store
 	request    *request.RehydrationRequest
 	ecsHandler ecs.Handler
+	queue      queue.Queue
 }
 
 func NewHandler(config Config, req *request.RehydrationRequest, ecsHandler ecs.Handler) *Handler {
@@ -33,12 +40,21 @@ func NewHandler(config Config, req *request.RehydrationRequest, ecsHandler ecs.H
 		store:      store,
 		request:    req,
 		ecsHandler: ecsHandler,
+		queue:      config.Queue,
 	}
 }
 
 type Response struct {
-	RehydrationLocation string `json:"rehydrationLocation"`
-	TaskARN             string `json:"taskARN"`
+	Status              idempotency.Status `json:"status"`
+	RehydrationLocation string             `json:"rehydrationLocation"`
+	TaskARN             string             `json:"taskARN"`
+	// QueuePosition is the number of queued rehydrations ahead of this one when it was queued. Only set
+	// in the response to the request that queued the rehydration.
+	QueuePosition *int `json:"queuePosition,omitempty"`
+	// Progress is the latest progress saved by the rehydration task. Only set for rehydrations already in progress.
+	Progress *idempotency.Progress `json:"progress,omitempty"`
+	// MissingFiles are the files a completed rehydration could not copy. Only set for partial rehydrations.
+	MissingFiles []string `json:"missingFiles,omitempty"`
 }
 
 func (r *Response) String() (string, error) {
@@ -52,6 +68,15 @@ func (r *Response) String() (string, error) {
 func (h *Handler) Handle(ctx context.Context) (response *Response, err error) {
 	datasetID := h.request.Dataset.ID
 	datasetVersionID := h.request.Dataset.VersionID
+	ctx, span := tracing.Start(ctx, "idempotency.Handle",
+		attribute.Int("dataset.id", datasetID),
+		attribute.Int("dataset.version_id", datasetVersionID))
+	defer func() {
+		if response != nil {
+			span.SetAttributes(attribute.String("rehydration.status", string(response.Status)))
+		}
+		tracing.End(span, err)
+	}()
 	for retry, i := true, 0; retry; i++ {
 		h.request.Logger.Info("idempotency Handler", slog.Int("attempt", i))
 		response, err = h.processIdempotency(ctx, datasetID, datasetVersionID)
@@ -76,7 +101,8 @@ func isRetryableError(err error) bool {
 
 func (h *Handler) processIdempotency(ctx context.Context, datasetID, datasetVersionID int) (*Response, error) {
 	// try to create a new idempotency record; error if one exists
-	if err := h.store.SaveInProgress(ctx, datasetID, datasetVersionID); err != nil {
+	status, err := h.saveRecord(ctx, datasetID, datasetVersionID)
+	if err != nil {
 		// If a record exists, respond with an existing rehydration location if we can, otherwise an error
 		var recordAlreadyExistsError *idempotency.RecordAlreadyExistsError
 		if errors.As(err, &recordAlreadyExistsError) {
@@ -89,17 +115,56 @@ func (h *Handler) processIdempotency(ctx context.Context, datasetID, datasetVers
 		// no record exists; we got some other error
 		return nil, err
 	}
+	h.request.Audit.Record(ctx, audit.IdempotencyEvent(h.recordID(), "", status).
+		WithUser(h.request.User).
+		WithDetail(audit.DestinationDetail, h.externalDestination()))
+	if h.queue != nil {
+		return h.enqueueRehydration(ctx)
+	}
 	// we were able to create a new record, so start the rehydration task and respond with taskARN
 	return h.startRehydrationTask(ctx)
 
 }
 
+// recordID is the ID of the idempotency record for the requested dataset version and destination. Rehydrations into
+// external buckets have records of their own.
+func (h *Handler) recordID() string {
+	return idempotency.DestinationRecordID(h.request.Dataset.ID, h.request.Dataset.VersionID, h.request.Destination)
+}
+
+// externalDestination is the location of the requested external destination, or an empty string if there is none
+func (h *Handler) externalDestination() string {
+	if !h.request.Destination.External() {
+		return ""
+	}
+	return h.request.Destination.Location()
+}
+
+// saveRecord creates a QUEUED record if there is a queue, otherwise an IN_PROGRESS one, and returns the status it
+// saved. The record's region and external destination are set if the request chose a destination other than the
+// default bucket.
+func (h *Handler) saveRecord(ctx context.Context, datasetID, datasetVersionID int) (idempotency.Status, error) {
+	status := idempotency.InProgress
+	if h.queue != nil {
+		status = idempotency.Queued
+	}
+	if destination := h.request.Destination; destination != nil {
+		record := idempotency.NewRecord(h.recordID(), status).
+			WithRegion(destination.Region).
+			WithExternalDestination(h.externalDestination())
+		return status, h.store.PutRecord(ctx, *record)
+	}
+	if status == idempotency.Queued {
+		return status, h.store.SaveQueued(ctx, datasetID, datasetVersionID)
+	}
+	return status, h.store.SaveInProgress(ctx, datasetID, datasetVersionID)
+}
+
 func (h *Handler) getIdempotencyRecord(ctx context.Context, datasetID, datasetVersionID int, alreadyExistsError *idempotency.RecordAlreadyExistsError) (*idempotency.Record, error) {
 	if alreadyExistsError != nil && alreadyExistsError.Existing != nil {
 		return alreadyExistsError.Existing, nil
 	}
-	recordID := idempotency.RecordID(datasetID, datasetVersionID)
-	record, err := h.store.GetRecord(ctx, recordID)
+	record, err := h.store.GetRecord(ctx, h.recordID())
 	if err != nil {
 		return nil, err
 	}
@@ -111,39 +176,83 @@ func (h *Handler) getIdempotencyRecord(ctx context.Context, datasetID, datasetVe
 }
 
 func (h *Handler) handleForStatus(ctx context.Context, record *idempotency.Record) (*Response, error) {
+	if record.Status != idempotency.Expired && record.Region != h.request.DestinationRegion() {
+		// there is only one rehydration of a dataset version at a time, so it can't also be sent to the requested region
+		existingRegion := record.Region
+		if len(existingRegion) == 0 {
+			existingRegion = "the default region"
+		}
+		return nil, RegionConflictError{fmt.Sprintf("a rehydration of %s already exists in %s", record.ID, existingRegion)}
+	}
 	switch record.Status {
 	case idempotency.Expired:
 		return nil, ExpiredError{fmt.Sprintf("rehydration expiration in progress for %s", record.ID)}
+	case idempotency.Queued:
+		// Same as InProgress, except there is no task yet
+		return &Response{Status: idempotency.Queued}, nil
 	case idempotency.InProgress:
 		// Treat this as normal and not an error. Tracking entry will be written and user notified when rehydration complete
-		return &Response{TaskARN: record.FargateTaskARN}, nil
+		return &Response{Status: idempotency.InProgress, TaskARN: record.FargateTaskARN, Progress: record.Progress}, nil
+	case idempotency.Restoring:
+		// Same as InProgress, except the task has exited until archived files are restored and the dispatcher starts it again
+		return &Response{Status: idempotency.Restoring, Progress: record.Progress}, nil
 	case idempotency.Completed:
 		if err := h.setExpirationDate(ctx, record); err != nil {
 			return nil, err
 		}
 		return &Response{
+			Status:              idempotency.Completed,
 			RehydrationLocation: record.RehydrationLocation,
-			TaskARN:             record.FargateTaskARN}, nil
+			TaskARN:             record.FargateTaskARN,
+			MissingFiles:        record.MissingFiles}, nil
 	default:
 		return nil, fmt.Errorf("unexpected status for %s: %s", record.ID, record.Status)
 	}
 }
 
 func (h *Handler) startRehydrationTask(ctx context.Context) (*Response, error) {
-	recordID := idempotency.RecordID(h.request.Dataset.ID, h.request.Dataset.VersionID)
-	taskARN, err := h.ecsHandler.Handle(ctx, h.request.Dataset, h.request.User, h.request.Logger)
+	recordID := h.recordID()
+	taskARN, err := h.ecsHandler.Handle(ctx, h.request.Dataset, h.request.User, h.request.Destination, h.request.RequestID(), h.request.Logger)
 	if err != nil {
 		deleteErr := h.store.DeleteRecord(ctx, recordID)
 		if deleteErr != nil {
 			return nil, fmt.Errorf("error starting rehydration task: %w, in addition, there was an error when deleting the idempotency record: %w", err, deleteErr)
 		}
+		h.request.Audit.Record(ctx, audit.IdempotencyDeletedEvent(recordID, idempotency.InProgress).
+			WithUser(h.request.User).
+			WithDetail(audit.ReasonDetail, "rehydration task could not be started"))
 		return nil, err
 	}
 	if err := h.store.SetTaskARN(ctx, recordID, taskARN); err != nil {
 		// seems wrong to fail the request because of this, but I'm not sure
 		h.request.Logger.Error("error setting taskARN of rehydration", slog.String("taskARN", taskARN), slog.Any("error", err))
 	}
-	return &Response{TaskARN: taskARN}, nil
+	return &Response{Status: idempotency.InProgress, TaskARN: taskARN}, nil
+}
+
+func (h *Handler) enqueueRehydration(ctx context.Context) (*Response, error) {
+	recordID := h.recordID()
+	message := queue.Message{
+		Dataset:     h.request.Dataset,
+		User:        h.request.User,
+		Priority:    h.request.Priority,
+		EnqueuedAt:  time.Now(),
+		Destination: h.request.Destination,
+		RequestID:   h.request.RequestID(),
+	}
+	position, err := h.queue.Enqueue(ctx, message)
+	if err != nil {
+		deleteErr := h.store.DeleteRecord(ctx, recordID)
+		if deleteErr != nil {
+			return nil, fmt.Errorf("error queueing rehydration: %w, in addition, there was an error when deleting the idempotency record: %w", err, deleteErr)
+		}
+		h.request.Audit.Record(ctx, audit.IdempotencyDeletedEvent(recordID, idempotency.Queued).
+			WithUser(h.request.User).
+			WithDetail(audit.ReasonDetail, "rehydration could not be queued"))
+		return nil, err
+	}
+	h.request.Logger.Info("queued rehydration", slog.Int("queuePosition", position))
+	return &Response{Status: idempotency.Queued, QueuePosition: &position}, nil
 }
 
 func (h *Handler) setExpirationDate(ctx context.Context, record *idempotency.Record) error {
@@ -182,3 +291,13 @@ type ExpiredError struct {
 func (e ExpiredError) Error() string {
 	return e.message
 }
+
+// RegionConflictError is returned if a rehydration of the requested dataset version already exists in a region other
+// than the requested one
+type RegionConflictError struct {
+	message string
+}
+
+func (e RegionConflictError) Error() string {
+	return e.message
+}
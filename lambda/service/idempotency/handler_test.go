@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/pennsieve/rehydration-service/service/ecs"
+	"github.com/pennsieve/rehydration-service/service/queue"
 	"github.com/pennsieve/rehydration-service/service/request"
 	"github.com/pennsieve/rehydration-service/shared/idempotency"
 	"github.com/pennsieve/rehydration-service/shared/logging"
@@ -64,6 +66,8 @@ func TestHandler_Handle(t *testing.T) {
 	require.NotNil(t, resp)
 	require.Empty(t, resp.RehydrationLocation)
 	require.Equal(t, expectedTaskARN, resp.TaskARN)
+	require.Equal(t, idempotency.InProgress, resp.Status)
+	require.Nil(t, resp.QueuePosition)
 	test.assertMockAssertions(t)
 }
 
@@ -154,6 +158,190 @@ func TestHandler_Handle_RetryMultiple(t *testing.T) {
 	}
 }
 
+func TestHandler_Handle_Queued(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 4321, VersionID: 3}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	test := newHandlerTest(dataset, user)
+	rehydrationQueue := queue.NewMemoryQueue()
+	_, err := rehydrationQueue.Enqueue(context.Background(), queue.Message{Dataset: sharedmodels.Dataset{ID: 1, VersionID: 1}, Priority: queue.High})
+	require.NoError(t, err)
+	test.handler.queue = rehydrationQueue
+	test.handler.request.Priority = queue.Normal
+
+	test.store.OnSaveQueuedSucceed(dataset.ID, dataset.VersionID).Once()
+
+	resp, err := test.handler.Handle(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, idempotency.Queued, resp.Status)
+	require.Empty(t, resp.TaskARN)
+	require.NotNil(t, resp.QueuePosition)
+	require.Equal(t, 1, *resp.QueuePosition)
+	require.Equal(t, 2, rehydrationQueue.Len())
+	test.assertMockAssertions(t)
+}
+
+func TestHandler_Handle_AlreadyQueued(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 4321, VersionID: 3}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	test := newHandlerTest(dataset, user)
+	rehydrationQueue := queue.NewMemoryQueue()
+	test.handler.queue = rehydrationQueue
+
+	recordID := idempotency.RecordID(dataset.ID, dataset.VersionID)
+	alreadyExistsError := &idempotency.RecordAlreadyExistsError{Existing: idempotency.NewRecord(recordID, idempotency.Queued)}
+	test.store.OnSaveQueuedError(dataset.ID, dataset.VersionID, alreadyExistsError).Once()
+
+	resp, err := test.handler.Handle(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, idempotency.Queued, resp.Status)
+	require.Nil(t, resp.QueuePosition)
+	require.Zero(t, rehydrationQueue.Len())
+	test.assertMockAssertions(t)
+}
+
+func TestHandler_Handle_AlreadyInProgress(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 4321, VersionID: 3}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	test := newHandlerTest(dataset, user)
+
+	recordID := idempotency.RecordID(dataset.ID, dataset.VersionID)
+	taskARN := "arn:aws:ecs:test:test:test"
+	progress := &idempotency.Progress{FilesTotal: 10, FilesDone: 6, BytesTotal: 1000, BytesDone: 600}
+	existing := idempotency.NewRecord(recordID, idempotency.InProgress).WithFargateTaskARN(taskARN)
+	existing.Progress = progress
+	test.store.OnSaveInProgressError(dataset.ID, dataset.VersionID, &idempotency.RecordAlreadyExistsError{Existing: existing}).Once()
+
+	resp, err := test.handler.Handle(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, idempotency.InProgress, resp.Status)
+	require.Equal(t, taskARN, resp.TaskARN)
+	require.Equal(t, progress, resp.Progress)
+	test.assertMockAssertions(t)
+}
+
+func TestHandler_Handle_AlreadyRestoring(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 4321, VersionID: 3}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	test := newHandlerTest(dataset, user)
+
+	recordID := idempotency.RecordID(dataset.ID, dataset.VersionID)
+	progress := &idempotency.Progress{Phase: idempotency.RestoringPhase, FilesTotal: 10}
+	existing := idempotency.NewRecord(recordID, idempotency.Restoring)
+	existing.Progress = progress
+	test.store.OnSaveInProgressError(dataset.ID, dataset.VersionID, &idempotency.RecordAlreadyExistsError{Existing: existing}).Once()
+
+	resp, err := test.handler.Handle(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, idempotency.Restoring, resp.Status)
+	require.Empty(t, resp.TaskARN)
+	require.Equal(t, progress, resp.Progress)
+	test.assertMockAssertions(t)
+}
+
+func TestHandler_Handle_AlreadyCompletedPartial(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 4321, VersionID: 3}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	test := newHandlerTest(dataset, user)
+
+	recordID := idempotency.RecordID(dataset.ID, dataset.VersionID)
+	taskARN := "arn:aws:ecs:test:test:test"
+	location := "s3://rehydration-bucket/4321/3/"
+	missingFiles := []string{"files/missing.txt"}
+	existing := idempotency.NewRecord(recordID, idempotency.Completed).
+		WithFargateTaskARN(taskARN).
+		WithRehydrationLocation(location).
+		WithMissingFiles(missingFiles)
+	test.store.OnSaveInProgressError(dataset.ID, dataset.VersionID, &idempotency.RecordAlreadyExistsError{Existing: existing}).Once()
+	test.store.On("SetExpirationDate", mock.Anything, recordID, mock.Anything).Return(nil).Once()
+
+	resp, err := test.handler.Handle(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, idempotency.Completed, resp.Status)
+	require.Equal(t, location, resp.RehydrationLocation)
+	require.Equal(t, missingFiles, resp.MissingFiles)
+	test.assertMockAssertions(t)
+}
+
+func TestHandler_Handle_Region(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 4321, VersionID: 3}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	test := newHandlerTest(dataset, user)
+	destination := &sharedmodels.Destination{Region: "eu-west-1", Bucket: "rehydration-eu"}
+	test.handler.request.Destination = destination
+
+	recordID := idempotency.RecordID(dataset.ID, dataset.VersionID)
+	expectedTaskARN := "arn:aws:ecs:test:test:test"
+	test.store.OnPutRecordSucceed(*idempotency.NewRecord(recordID, idempotency.InProgress).WithRegion(destination.Region)).Once()
+	test.ecs.On("Handle", mock.Anything, dataset, user, destination, mock.Anything, mock.Anything).Return(expectedTaskARN, nil).Once()
+	test.store.OnSetTaskARNSucceed(recordID, expectedTaskARN).Once()
+
+	resp, err := test.handler.Handle(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, idempotency.InProgress, resp.Status)
+	require.Equal(t, expectedTaskARN, resp.TaskARN)
+	test.assertMockAssertions(t)
+}
+
+func TestHandler_Handle_ExternalDestination(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 4321, VersionID: 3}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	test := newHandlerTest(dataset, user)
+	destination := &sharedmodels.Destination{
+		Bucket:  "requester-bucket",
+		Prefix:  "deliveries/",
+		RoleARN: "arn:aws:iam::123456789012:role/rehydration-writer",
+	}
+	test.handler.request.Destination = destination
+
+	// the record is separate from the one for our own bucket, so both rehydrations can exist at once
+	recordID := idempotency.DestinationRecordID(dataset.ID, dataset.VersionID, destination)
+	require.NotEqual(t, idempotency.RecordID(dataset.ID, dataset.VersionID), recordID)
+	expectedTaskARN := "arn:aws:ecs:test:test:test"
+	test.store.OnPutRecordSucceed(*idempotency.NewRecord(recordID, idempotency.InProgress).
+		WithExternalDestination("s3://requester-bucket/deliveries/")).Once()
+	test.ecs.On("Handle", mock.Anything, dataset, user, destination, mock.Anything, mock.Anything).Return(expectedTaskARN, nil).Once()
+	test.store.OnSetTaskARNSucceed(recordID, expectedTaskARN).Once()
+
+	resp, err := test.handler.Handle(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, idempotency.InProgress, resp.Status)
+	require.Equal(t, expectedTaskARN, resp.TaskARN)
+	test.assertMockAssertions(t)
+}
+
+func TestHandler_Handle_RegionConflict(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 4321, VersionID: 3}
+	user := sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+	recordID := idempotency.RecordID(dataset.ID, dataset.VersionID)
+
+	t.Run("existing in other region", func(t *testing.T) {
+		test := newHandlerTest(dataset, user)
+		existing := idempotency.NewRecord(recordID, idempotency.InProgress).WithRegion("eu-west-1")
+		test.store.OnSaveInProgressError(dataset.ID, dataset.VersionID, &idempotency.RecordAlreadyExistsError{Existing: existing}).Once()
+
+		_, err := test.handler.Handle(context.Background())
+		var conflictError RegionConflictError
+		require.ErrorAs(t, err, &conflictError)
+		require.Contains(t, err.Error(), "eu-west-1")
+		test.assertMockAssertions(t)
+	})
+
+	t.Run("existing in default region", func(t *testing.T) {
+		test := newHandlerTest(dataset, user)
+		destination := &sharedmodels.Destination{Region: "eu-west-1", Bucket: "rehydration-eu"}
+		test.handler.request.Destination = destination
+		existing := idempotency.NewRecord(recordID, idempotency.Completed).WithRehydrationLocation("s3://rehydration-bucket/4321/3/")
+		test.store.OnPutRecordError(*idempotency.NewRecord(recordID, idempotency.InProgress).WithRegion(destination.Region),
+			&idempotency.RecordAlreadyExistsError{Existing: existing}).Once()
+
+		_, err := test.handler.Handle(context.Background())
+		var conflictError RegionConflictError
+		require.ErrorAs(t, err, &conflictError)
+		require.Contains(t, err.Error(), "default region")
+		test.assertMockAssertions(t)
+	})
+}
+
 type MockStore struct {
 	mock.Mock
 }
@@ -171,6 +359,19 @@ func (m *MockStore) OnSaveInProgressError(datasetID, datasetVersionID int, err e
 	return m.On("SaveInProgress", mock.Anything, datasetID, datasetVersionID).Return(err)
 }
 
+func (m *MockStore) SaveQueued(ctx context.Context, datasetID, datasetVersionID int) error {
+	args := m.Called(ctx, datasetID, datasetVersionID)
+	return args.Error(0)
+}
+
+func (m *MockStore) OnSaveQueuedSucceed(datasetID, datasetVersionID int) *mock.Call {
+	return m.On("SaveQueued", mock.Anything, datasetID, datasetVersionID).Return(nil)
+}
+
+func (m *MockStore) OnSaveQueuedError(datasetID, datasetVersionID int, err error) *mock.Call {
+	return m.On("SaveQueued", mock.Anything, datasetID, datasetVersionID).Return(err)
+}
+
 func (m *MockStore) GetRecord(ctx context.Context, recordID string) (*idempotency.Record, error) {
 	args := m.Called(ctx, recordID)
 	return args.Get(0).(*idempotency.Record), args.Error(1)
@@ -223,6 +424,31 @@ func (m *MockStore) OnSetTaskARNError(recordID string, taskARN string, err error
 	return m.On("SetTaskARN", mock.Anything, recordID, taskARN).Return(err)
 }
 
+func (m *MockStore) SetProgress(ctx context.Context, recordID string, progress idempotency.Progress) error {
+	args := m.Called(ctx, recordID, progress)
+	return args.Error(0)
+}
+
+func (m *MockStore) UpdateStatus(ctx context.Context, recordID string, expected idempotency.Status, status idempotency.Status) error {
+	args := m.Called(ctx, recordID, expected, status)
+	return args.Error(0)
+}
+
+func (m *MockStore) CountByStatus(ctx context.Context, status idempotency.Status) (int, error) {
+	args := m.Called(ctx, status)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStore) QueryByStatus(ctx context.Context, status idempotency.Status) ([]idempotency.Record, error) {
+	args := m.Called(ctx, status)
+	return args.Get(0).([]idempotency.Record), args.Error(1)
+}
+
+func (m *MockStore) SaveRestoring(ctx context.Context, recordID string, resume idempotency.Resume) error {
+	args := m.Called(ctx, recordID, resume)
+	return args.Error(0)
+}
+
 func (m *MockStore) DeleteRecord(ctx context.Context, recordID string) error {
 	args := m.Called(ctx, recordID)
 	return args.Error(0)
@@ -264,19 +490,29 @@ func (m *MockStore) ExpireByIndex(ctx context.Context, index idempotency.Expirat
 	return args.Get(0).(*idempotency.Record), args.Error(1)
 }
 
+func (m *MockStore) Reference(ctx context.Context, recordID string, until time.Time) error {
+	args := m.Called(ctx, recordID, until)
+	return args.Error(0)
+}
+
 type MockECSHandler struct {
 	mock.Mock
 }
 
-func (m *MockECSHandler) Handle(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, logger *slog.Logger) (string, error) {
-	args := m.Called(ctx, dataset, user, logger)
+func (m *MockECSHandler) Handle(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, destination *sharedmodels.Destination, requestID string, logger *slog.Logger) (string, error) {
+	args := m.Called(ctx, dataset, user, destination, requestID, logger)
 	return args.String(0), args.Error(1)
 }
 
+func (m *MockECSHandler) Status(ctx context.Context, jobID string) (*ecs.JobStatus, error) {
+	args := m.Called(ctx, jobID)
+	return args.Get(0).(*ecs.JobStatus), args.Error(1)
+}
+
 func (m *MockECSHandler) OnHandleReturn(dataset sharedmodels.Dataset, user sharedmodels.User, ret string) *mock.Call {
-	return m.On("Handle", mock.Anything, dataset, user, mock.Anything).Return(ret, nil)
+	return m.On("Handle", mock.Anything, dataset, user, mock.Anything, mock.Anything, mock.Anything).Return(ret, nil)
 }
 
 func (m *MockECSHandler) OnHandleError(dataset sharedmodels.Dataset, user sharedmodels.User, err error) *mock.Call {
-	return m.On("Handle", mock.Anything, dataset, user, mock.Anything).Return("", err)
+	return m.On("Handle", mock.Anything, dataset, user, mock.Anything, mock.Anything, mock.Anything).Return("", err)
 }
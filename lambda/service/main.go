@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/pennsieve/rehydration-service/service/handler"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	"log/slog"
 )
 
 func main() {
+	// spans are flushed at the end of each request, so there is no shutdown
+	if _, err := tracing.Init(context.Background(), "rehydration-service"); err != nil {
+		logging.Default.Warn("error initializing tracing; spans will not be exported", slog.Any("error", err))
+	}
 	lambda.Start(handler.RehydrationServiceHandler)
 }
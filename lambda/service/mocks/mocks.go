@@ -3,6 +3,7 @@ package mocks
 import (
 	"context"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 
 	"github.com/pennsieve/rehydration-service/service/runner"
 )
@@ -14,6 +15,10 @@ func (r *MockECSTaskRunner) Run(ctx context.Context) (*ecs.RunTaskOutput, error)
 	return nil, nil
 }
 
+func (r *MockECSTaskRunner) Describe(ctx context.Context, taskARN string) (*types.Task, error) {
+	return nil, nil
+}
+
 func NewMockECSTaskRunner() runner.Runner {
 	return &MockECSTaskRunner{}
 }
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/discover"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"os"
+	"strings"
+)
+
+const BatchJobQueueKey = "BATCH_JOB_QUEUE"
+const BatchJobDefinitionKey = "BATCH_JOB_DEFINITION"
+const BatchThresholdBytesKey = "BATCH_THRESHOLD_BYTES"
+
+const KubernetesAPIServerKey = "K8S_API_SERVER"
+const KubernetesCAData = "K8S_CA_DATA"
+const KubernetesClusterNameKey = "K8S_CLUSTER_NAME"
+const KubernetesNamespaceKey = "K8S_NAMESPACE"
+const KubernetesJobImageKey = "K8S_JOB_IMAGE"
+const KubernetesServiceAccountKey = "K8S_SERVICE_ACCOUNT"
+const KubernetesJobEnvKeysKey = "K8S_JOB_ENV_KEYS"
+const KubernetesThresholdBytesKey = "K8S_THRESHOLD_BYTES"
+
+// BackendConfig is non-nil if the service should choose between ECS and other backends based on dataset size.
+type BackendConfig struct {
+	// PennsieveHost is used to look up dataset sizes in Discover
+	PennsieveHost string
+	// Batch is nil if AWS Batch is not configured
+	Batch *BatchConfig
+	// Kubernetes is nil if Kubernetes is not configured
+	Kubernetes *KubernetesConfig
+}
+
+type BatchConfig struct {
+	JobQueue      string
+	JobDefinition string
+	// ThresholdBytes is the dataset size at or above which Batch will be used
+	ThresholdBytes int64
+}
+
+type KubernetesConfig struct {
+	// APIServer is the cluster's API server endpoint, for example https://ABCDEF.gr7.us-east-1.eks.amazonaws.com
+	APIServer string
+	// CAData is the base64 encoded certificate authority data for the API server. Optional.
+	CAData string
+	// ClusterName is the EKS cluster name used to create the bearer token
+	ClusterName    string
+	Namespace      string
+	Image          string
+	ServiceAccount string
+	// Env is passed to the Job's container in addition to the RunTaskInput container overrides.
+	// It stands in for the environment variables set by the ECS task definition.
+	Env map[string]string
+	// ThresholdBytes is the dataset size at or above which Kubernetes will be used
+	ThresholdBytes int64
+}
+
+// BackendFor returns the backend that should be used for a dataset of the given size. Kubernetes is preferred over
+// Batch if the size is over both thresholds.
+func (c *BackendConfig) BackendFor(datasetSize int64) sharedmodels.Backend {
+	if c.Kubernetes != nil && datasetSize >= c.Kubernetes.ThresholdBytes {
+		return sharedmodels.KubernetesBackend
+	}
+	if c.Batch != nil && datasetSize >= c.Batch.ThresholdBytes {
+		return sharedmodels.BatchBackend
+	}
+	return sharedmodels.ECSBackend
+}
+
+// BackendConfigFromEnvironment returns nil if neither Batch nor Kubernetes is configured.
+func BackendConfigFromEnvironment() (*BackendConfig, error) {
+	batchConfig, err := batchConfigFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	kubernetesConfig, err := kubernetesConfigFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	if batchConfig == nil && kubernetesConfig == nil {
+		return nil, nil
+	}
+	env, err := shared.NonEmptyFromEnvVar(sharedmodels.ECSTaskEnvKey)
+	if err != nil {
+		return nil, err
+	}
+	return &BackendConfig{
+		PennsieveHost: discover.APIHost(env),
+		Batch:         batchConfig,
+		Kubernetes:    kubernetesConfig,
+	}, nil
+}
+
+func batchConfigFromEnvironment() (*BatchConfig, error) {
+	if _, set := os.LookupEnv(BatchJobQueueKey); !set {
+		return nil, nil
+	}
+	jobQueue, err := shared.NonEmptyFromEnvVar(BatchJobQueueKey)
+	if err != nil {
+		return nil, err
+	}
+	jobDefinition, err := shared.NonEmptyFromEnvVar(BatchJobDefinitionKey)
+	if err != nil {
+		return nil, err
+	}
+	threshold, err := shared.Int64FromEnvVar(BatchThresholdBytesKey)
+	if err != nil {
+		return nil, err
+	}
+	return &BatchConfig{
+		JobQueue:       jobQueue,
+		JobDefinition:  jobDefinition,
+		ThresholdBytes: threshold,
+	}, nil
+}
+
+func kubernetesConfigFromEnvironment() (*KubernetesConfig, error) {
+	if _, set := os.LookupEnv(KubernetesAPIServerKey); !set {
+		return nil, nil
+	}
+	apiServer, err := shared.NonEmptyFromEnvVar(KubernetesAPIServerKey)
+	if err != nil {
+		return nil, err
+	}
+	clusterName, err := shared.NonEmptyFromEnvVar(KubernetesClusterNameKey)
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := shared.NonEmptyFromEnvVar(KubernetesNamespaceKey)
+	if err != nil {
+		return nil, err
+	}
+	image, err := shared.NonEmptyFromEnvVar(KubernetesJobImageKey)
+	if err != nil {
+		return nil, err
+	}
+	threshold, err := shared.Int64FromEnvVar(KubernetesThresholdBytesKey)
+	if err != nil {
+		return nil, err
+	}
+	env := map[string]string{}
+	if keysStr := os.Getenv(KubernetesJobEnvKeysKey); len(keysStr) > 0 {
+		for _, key := range strings.Split(keysStr, ",") {
+			key = strings.TrimSpace(key)
+			value, err := shared.NonEmptyFromEnvVar(key)
+			if err != nil {
+				return nil, err
+			}
+			env[key] = value
+		}
+	}
+	return &KubernetesConfig{
+		APIServer:      apiServer,
+		CAData:         os.Getenv(KubernetesCAData),
+		ClusterName:    clusterName,
+		Namespace:      namespace,
+		Image:          image,
+		ServiceAccount: os.Getenv(KubernetesServiceAccountKey),
+		Env:            env,
+		ThresholdBytes: threshold,
+	}, nil
+}
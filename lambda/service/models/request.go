@@ -5,4 +5,23 @@ import "github.com/pennsieve/rehydration-service/shared/models"
 type Request struct {
 	models.Dataset
 	models.User
+	// Priority is only used if requests are queued. One of "high", "normal", or "low". Defaults to "normal". Only
+	// service accounts can use "high".
+	Priority string `json:"priority,omitempty"`
+	// Region is the AWS region to rehydrate the dataset into. Must be one of the configured regions. Defaults to the
+	// service's own region.
+	Region string `json:"region,omitempty"`
+	// Destination is set if the dataset should be rehydrated into a bucket owned by the requester instead of ours.
+	// Cannot be combined with Region.
+	Destination *ExternalDestination `json:"destination,omitempty"`
+}
+
+// ExternalDestination is a requester-owned bucket. The rehydration task assumes RoleARN to write to it.
+type ExternalDestination struct {
+	Bucket string `json:"bucket"`
+	// Prefix is prepended to the keys of the rehydrated files. Optional.
+	Prefix  string `json:"prefix,omitempty"`
+	RoleARN string `json:"roleArn"`
+	// Region is the region of Bucket. Defaults to the service's own region.
+	Region string `json:"region,omitempty"`
 }
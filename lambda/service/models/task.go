@@ -1,14 +1,18 @@
 package models
 
 import (
+	"context"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/pennsieve/rehydration-service/service/runner"
 	"github.com/pennsieve/rehydration-service/shared"
 	"github.com/pennsieve/rehydration-service/shared/idempotency"
 	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
 	"github.com/pennsieve/rehydration-service/shared/notification"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
 	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -22,6 +26,13 @@ type ECSTaskConfig struct {
 	IdempotencyTableName string
 	TrackingTableName    string
 	PennsieveDomain      string
+	// Docker is non-nil if the task should be run with a Docker Engine instead of ECS
+	Docker *runner.DockerConfig
+	// Backends is non-nil if large datasets should be sent to AWS Batch or Kubernetes instead
+	Backends *BackendConfig
+	// ResumeRestores is true if a dispatcher will start tasks again that exit to wait for restores. It is not read from
+	// the environment, since it depends on whether rehydrations are queued.
+	ResumeRestores bool
 }
 
 func TaskConfigFromEnvironment() (*ECSTaskConfig, error) {
@@ -59,6 +70,16 @@ func TaskConfigFromEnvironment() (*ECSTaskConfig, error) {
 		return nil, err
 	}
 
+	dockerConfig, err := runner.DockerConfigFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	backendConfig, err := BackendConfigFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
 	return &ECSTaskConfig{
 		TaskDefinitionARN:    taskDefinitionArn,
 		SubnetIDS:            subNetIds,
@@ -68,13 +89,19 @@ func TaskConfigFromEnvironment() (*ECSTaskConfig, error) {
 		IdempotencyTableName: idempotencyTable,
 		TrackingTableName:    trackingTable,
 		PennsieveDomain:      pennsieveDomain,
+		Docker:               dockerConfig,
+		Backends:             backendConfig,
 	}, nil
 }
 
-func (t *ECSTaskConfig) RunTaskInput(dataset sharedmodels.Dataset, user sharedmodels.User) *ecs.RunTaskInput {
+// RunTaskInput returns the input to start a rehydration task for dataset and user. If ctx has a span, its trace
+// context is passed to the task in the tracing.TraceParentKey and tracing.TraceStateKey environment variables.
+// destination is nil if the task should use its default rehydration bucket. requestID is the ID of the request that
+// started the task, or empty if it is not known.
+func (t *ECSTaskConfig) RunTaskInput(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, destination *sharedmodels.Destination, requestID string) *ecs.RunTaskInput {
 	datasetID := strconv.Itoa(dataset.ID)
 	datasetVersionID := strconv.Itoa(dataset.VersionID)
-	return &ecs.RunTaskInput{
+	input := &ecs.RunTaskInput{
 		TaskDefinition: aws.String(t.TaskDefinitionARN),
 		Cluster:        aws.String(t.Cluster),
 		NetworkConfiguration: &types.NetworkConfiguration{
@@ -123,4 +150,53 @@ func (t *ECSTaskConfig) RunTaskInput(dataset sharedmodels.Dataset, user sharedmo
 		},
 		LaunchType: types.LaunchTypeFargate,
 	}
+	override := &input.Overrides.ContainerOverrides[0]
+	if len(requestID) > 0 {
+		override.Environment = append(override.Environment, types.KeyValuePair{
+			Name:  aws.String(sharedmodels.ECSTaskRequestIDKey),
+			Value: aws.String(requestID),
+		})
+	}
+	if destination != nil {
+		override.Environment = append(override.Environment, types.KeyValuePair{
+			Name:  aws.String(shared.RehydrationBucketKey),
+			Value: aws.String(destination.Bucket),
+		})
+		if len(destination.Region) > 0 {
+			override.Environment = append(override.Environment, types.KeyValuePair{
+				Name:  aws.String(sharedmodels.ECSTaskRehydrationRegionKey),
+				Value: aws.String(destination.Region),
+			})
+		}
+		if destination.External() {
+			override.Environment = append(override.Environment,
+				types.KeyValuePair{
+					Name:  aws.String(sharedmodels.ECSTaskRehydrationPrefixKey),
+					Value: aws.String(destination.Prefix),
+				},
+				types.KeyValuePair{
+					Name:  aws.String(sharedmodels.ECSTaskRehydrationRoleARNKey),
+					Value: aws.String(destination.RoleARN),
+				})
+		}
+	}
+	if t.ResumeRestores {
+		override.Environment = append(override.Environment, types.KeyValuePair{
+			Name:  aws.String(sharedmodels.ECSTaskResumeRestoresKey),
+			Value: aws.String(strconv.FormatBool(true)),
+		})
+	}
+	traceEnv := tracing.Environment(ctx)
+	traceKeys := make([]string, 0, len(traceEnv))
+	for key := range traceEnv {
+		traceKeys = append(traceKeys, key)
+	}
+	sort.Strings(traceKeys)
+	for _, key := range traceKeys {
+		override.Environment = append(override.Environment, types.KeyValuePair{
+			Name:  aws.String(key),
+			Value: aws.String(traceEnv[key]),
+		})
+	}
+	return input
 }
@@ -0,0 +1,43 @@
+package queue
+
+import (
+	"github.com/pennsieve/rehydration-service/shared"
+	"os"
+	"strings"
+)
+
+const MaxConcurrentKey = "REHYDRATION_MAX_CONCURRENT"
+
+// QueueURLKeyPrefix is followed by the upper-cased priority, for example REHYDRATION_QUEUE_URL_HIGH
+const QueueURLKeyPrefix = "REHYDRATION_QUEUE_URL_"
+
+type Config struct {
+	// MaxConcurrent is the maximum number of rehydrations that can be in progress at once
+	MaxConcurrent int
+	// QueueURLs has an SQS queue URL for each of Priorities
+	QueueURLs map[Priority]string
+}
+
+func URLKey(priority Priority) string {
+	return QueueURLKeyPrefix + strings.ToUpper(string(priority))
+}
+
+// ConfigFromEnvironment returns nil if MaxConcurrentKey is not set, meaning that requests should not be queued.
+func ConfigFromEnvironment() (*Config, error) {
+	if _, set := os.LookupEnv(MaxConcurrentKey); !set {
+		return nil, nil
+	}
+	maxConcurrent, err := shared.IntFromEnvVar(MaxConcurrentKey)
+	if err != nil {
+		return nil, err
+	}
+	queueURLs := map[Priority]string{}
+	for _, priority := range Priorities {
+		queueURL, err := shared.NonEmptyFromEnvVar(URLKey(priority))
+		if err != nil {
+			return nil, err
+		}
+		queueURLs[priority] = queueURL
+	}
+	return &Config{MaxConcurrent: maxConcurrent, QueueURLs: queueURLs}, nil
+}
@@ -0,0 +1,239 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/rehydration-service/service/ecs"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+	"time"
+)
+
+// errNoCapacity is returned by start when claiming a rehydration put the number in progress over the maximum
+var errNoCapacity = errors.New("no capacity to start queued rehydration")
+
+// Dispatcher starts queued rehydrations while keeping the number in progress at or below a maximum.
+type Dispatcher struct {
+	queue         Queue
+	store         idempotency.Store
+	ecsHandler    ecs.Handler
+	maxConcurrent int
+	logger        *slog.Logger
+	audit         *audit.Recorder
+}
+
+func NewDispatcher(queue Queue, store idempotency.Store, ecsHandler ecs.Handler, maxConcurrent int, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		queue:         queue,
+		store:         store,
+		ecsHandler:    ecsHandler,
+		maxConcurrent: maxConcurrent,
+		logger:        logger,
+	}
+}
+
+// SetAudit sets the Recorder of the status changes of the rehydrations d starts. They are not audited if it is nil.
+func (d *Dispatcher) SetAudit(recorder *audit.Recorder) {
+	d.audit = recorder
+}
+
+// Dispatch starts as many queued rehydrations as there is room for and returns the number started. Rehydrations whose
+// tasks exited to wait for archived files to be restored are started again first, once the restores should be done.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	inProgress, err := d.store.CountByStatus(ctx, idempotency.InProgress)
+	if err != nil {
+		return 0, err
+	}
+	available := d.maxConcurrent - inProgress
+	if available <= 0 {
+		d.logger.Info("no capacity to start queued rehydrations",
+			slog.Int("inProgress", inProgress),
+			slog.Int("maxConcurrent", d.maxConcurrent))
+		return 0, nil
+	}
+	resumed, full, err := d.resume(ctx, available)
+	var errs []error
+	if err != nil {
+		// resumes are tried again by the next Dispatch, so they should not hold up the queue
+		errs = append(errs, err)
+	}
+	if full || resumed >= available {
+		return resumed, errors.Join(errs...)
+	}
+	received, err := d.queue.Receive(ctx, available-resumed)
+	if err != nil {
+		return resumed, errors.Join(append(errs, err)...)
+	}
+	var started int
+	for i, r := range received {
+		ok, err := d.start(ctx, r)
+		if errors.Is(err, errNoCapacity) {
+			// other dispatches claimed the room first, so leave the rest for a later Dispatch
+			for _, unstarted := range received[i+1:] {
+				errs = append(errs, d.queue.Release(ctx, unstarted))
+			}
+			d.logger.Info("no capacity left to start queued rehydrations",
+				slog.Int("unstarted", len(received)-i))
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			started++
+		}
+	}
+	d.logger.Info("dispatched queued rehydrations",
+		slog.Int("received", len(received)),
+		slog.Int("started", started),
+		slog.Int("inProgress", inProgress+resumed+started))
+	return resumed + started, errors.Join(errs...)
+}
+
+// resume starts again up to available rehydrations that are waiting for restores that should now be done, and returns
+// the number started. full is true if the claims of concurrent dispatches used up the room first.
+func (d *Dispatcher) resume(ctx context.Context, available int) (started int, full bool, err error) {
+	restoring, err := d.store.QueryByStatus(ctx, idempotency.Restoring)
+	if err != nil {
+		return 0, false, fmt.Errorf("error finding rehydrations waiting for restores: %w", err)
+	}
+	now := time.Now()
+	var errs []error
+	for _, record := range restoring {
+		if started >= available {
+			break
+		}
+		if !record.Resumable(now) {
+			continue
+		}
+		ok, err := d.startResumed(ctx, record.ID, *record.Resume)
+		if errors.Is(err, errNoCapacity) {
+			d.logger.Info("no capacity left to resume rehydrations waiting for restores")
+			full = true
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			started++
+		}
+	}
+	if started > 0 {
+		d.logger.Info("resumed rehydrations waiting for restores", slog.Int("started", started))
+	}
+	return started, full, errors.Join(errs...)
+}
+
+// startResumed is start for a rehydration waiting for restores. It returns false with no error if the rehydration has
+// already been started again by another dispatch.
+func (d *Dispatcher) startResumed(ctx context.Context, recordID string, resume idempotency.Resume) (bool, error) {
+	dataset, user := resume.Dataset, resume.User
+	logger := d.logger.With(slog.Group("dataset", slog.Int("id", dataset.ID), slog.Int("versionId", dataset.VersionID)),
+		slog.Group("user", slog.String("name", user.Name), slog.String("email", user.Email)))
+
+	if err := d.store.UpdateStatus(ctx, recordID, idempotency.Restoring, idempotency.InProgress); err != nil {
+		var recordDoesNotExist *idempotency.RecordDoesNotExistsError
+		var conditionFailedError *idempotency.ConditionFailedError
+		if errors.As(err, &recordDoesNotExist) || errors.As(err, &conditionFailedError) {
+			logger.Info("rehydration is no longer waiting for restores", slog.String("recordID", recordID))
+			return false, nil
+		}
+		return false, err
+	}
+	d.audit.Record(ctx, audit.IdempotencyEvent(recordID, idempotency.Restoring, idempotency.InProgress).
+		WithUser(user).
+		WithDetail(audit.ReasonDetail, "archived files should be restored"))
+
+	inProgress, err := d.store.CountByStatus(ctx, idempotency.InProgress)
+	if err != nil || inProgress > d.maxConcurrent {
+		reason := "no capacity to start rehydration task"
+		if err != nil {
+			reason = "rehydrations in progress could not be counted"
+		}
+		revertErr := d.unclaim(ctx, recordID, user, idempotency.Restoring, reason)
+		if err != nil {
+			return false, fmt.Errorf("error counting rehydrations in progress: %w", errors.Join(err, revertErr))
+		}
+		return false, errors.Join(errNoCapacity, revertErr)
+	}
+
+	taskARN, err := d.ecsHandler.Handle(ctx, dataset, user, resume.Destination, resume.RequestID, logger)
+	if err != nil {
+		revertErr := d.unclaim(ctx, recordID, user, idempotency.Restoring, "rehydration task could not be started")
+		return false, fmt.Errorf("error resuming rehydration %s: %w", recordID, errors.Join(err, revertErr))
+	}
+	if err := d.store.SetTaskARN(ctx, recordID, taskARN); err != nil {
+		logger.Error("error setting taskARN of rehydration", slog.String("taskARN", taskARN), slog.Any("error", err))
+	}
+	logger.Info("resumed rehydration waiting for restores",
+		slog.String("taskARN", taskARN),
+		slog.Time("resumeAt", resume.ResumeAt))
+	return true, nil
+}
+
+// start returns false with no error if the message no longer corresponds to a queued rehydration and was dropped.
+// The rehydration is claimed by marking it in progress before its task is started, and the number in progress is
+// counted again after the claim so that rehydrations claimed by concurrent dispatches, whose tasks may not have
+// started yet, are included. If that puts the number over the maximum, the claim is undone and errNoCapacity returned.
+// The count comes from an eventually consistent index, so it can briefly miss very recent claims; dispatches are
+// not run concurrently, which keeps that from mattering in practice.
+func (d *Dispatcher) start(ctx context.Context, received *Received) (bool, error) {
+	dataset, user := received.Dataset, received.User
+	recordID := idempotency.DestinationRecordID(dataset.ID, dataset.VersionID, received.Destination)
+	logger := d.logger.With(slog.Group("dataset", slog.Int("id", dataset.ID), slog.Int("versionId", dataset.VersionID)),
+		slog.Group("user", slog.String("name", user.Name), slog.String("email", user.Email)),
+		slog.String("priority", string(received.Priority)))
+
+	if err := d.store.UpdateStatus(ctx, recordID, idempotency.Queued, idempotency.InProgress); err != nil {
+		var recordDoesNotExist *idempotency.RecordDoesNotExistsError
+		var conditionFailedError *idempotency.ConditionFailedError
+		if errors.As(err, &recordDoesNotExist) || errors.As(err, &conditionFailedError) {
+			logger.Warn("dropping message for rehydration that is no longer queued", slog.Any("error", err))
+			return false, d.queue.Delete(ctx, received)
+		}
+		return false, errors.Join(err, d.queue.Release(ctx, received))
+	}
+	d.audit.Record(ctx, audit.IdempotencyEvent(recordID, idempotency.Queued, idempotency.InProgress).WithUser(user))
+
+	inProgress, err := d.store.CountByStatus(ctx, idempotency.InProgress)
+	if err != nil || inProgress > d.maxConcurrent {
+		reason := "no capacity to start rehydration task"
+		if err != nil {
+			reason = "rehydrations in progress could not be counted"
+		}
+		revertErr := d.unclaim(ctx, recordID, user, idempotency.Queued, reason)
+		releaseErr := d.queue.Release(ctx, received)
+		if err != nil {
+			return false, fmt.Errorf("error counting rehydrations in progress: %w", errors.Join(err, revertErr, releaseErr))
+		}
+		return false, errors.Join(errNoCapacity, revertErr, releaseErr)
+	}
+
+	taskARN, err := d.ecsHandler.Handle(ctx, dataset, user, received.Destination, received.RequestID, logger)
+	if err != nil {
+		// put everything back so that a later Dispatch can try again
+		revertErr := d.unclaim(ctx, recordID, user, idempotency.Queued, "rehydration task could not be started")
+		releaseErr := d.queue.Release(ctx, received)
+		return false, fmt.Errorf("error starting queued rehydration %s: %w", recordID, errors.Join(err, revertErr, releaseErr))
+	}
+	if err := d.store.SetTaskARN(ctx, recordID, taskARN); err != nil {
+		logger.Error("error setting taskARN of rehydration", slog.String("taskARN", taskARN), slog.Any("error", err))
+	}
+	logger.Info("started queued rehydration",
+		slog.String("taskARN", taskARN),
+		slog.Time("enqueuedAt", received.EnqueuedAt))
+	return true, d.queue.Delete(ctx, received)
+}
+
+// unclaim puts a rehydration claimed by start or startResumed back in the status it was claimed from
+func (d *Dispatcher) unclaim(ctx context.Context, recordID string, user sharedmodels.User, status idempotency.Status, reason string) error {
+	if err := d.store.UpdateStatus(ctx, recordID, idempotency.InProgress, status); err != nil {
+		return err
+	}
+	d.audit.Record(ctx, audit.IdempotencyEvent(recordID, idempotency.InProgress, status).
+		WithUser(user).
+		WithDetail(audit.ReasonDetail, reason))
+	return nil
+}
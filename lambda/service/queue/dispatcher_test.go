@@ -0,0 +1,337 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/rehydration-service/service/ecs"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestDispatcher_Dispatch(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	store.records["1/1/"] = idempotency.InProgress
+	rehydrationQueue := NewMemoryQueue()
+	for _, dataset := range []sharedmodels.Dataset{{ID: 2, VersionID: 1}, {ID: 3, VersionID: 1}, {ID: 4, VersionID: 1}} {
+		store.records[idempotency.RecordID(dataset.ID, dataset.VersionID)] = idempotency.Queued
+		_, err := rehydrationQueue.Enqueue(ctx, Message{Dataset: dataset})
+		require.NoError(t, err)
+	}
+	ecsHandler := &fakeECSHandler{}
+
+	auditStore := &fakeAuditStore{}
+	dispatcher := NewDispatcher(rehydrationQueue, store, ecsHandler, 3, logging.Default)
+	dispatcher.SetAudit(audit.NewRecorder(auditStore, audit.DispatcherSource, logging.Default))
+	started, err := dispatcher.Dispatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, started)
+	if assert.Len(t, auditStore.events, 2) {
+		for i, recordID := range []string{"2/1/", "3/1/"} {
+			assert.Equal(t, recordID, auditStore.events[i].SubjectID)
+			assert.Equal(t, string(idempotency.Queued), auditStore.events[i].PreviousStatus)
+			assert.Equal(t, string(idempotency.InProgress), auditStore.events[i].Status)
+			assert.Equal(t, audit.DispatcherSource, auditStore.events[i].Source)
+		}
+	}
+	assert.Equal(t, []int{2, 3}, ecsHandler.started)
+	assert.Equal(t, idempotency.InProgress, store.records["2/1/"])
+	assert.Equal(t, "task-2", store.taskARNs["2/1/"])
+	assert.Equal(t, idempotency.InProgress, store.records["3/1/"])
+	assert.Equal(t, idempotency.Queued, store.records["4/1/"])
+	assert.Equal(t, 1, rehydrationQueue.Len())
+
+	// at capacity
+	started, err = dispatcher.Dispatch(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, started)
+	assert.Equal(t, 1, rehydrationQueue.Len())
+}
+
+func TestDispatcher_Dispatch_StartError(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	store.records["2/1/"] = idempotency.Queued
+	rehydrationQueue := NewMemoryQueue()
+	_, err := rehydrationQueue.Enqueue(ctx, Message{Dataset: sharedmodels.Dataset{ID: 2, VersionID: 1}})
+	require.NoError(t, err)
+	ecsHandler := &fakeECSHandler{err: errors.New("no capacity")}
+
+	auditStore := &fakeAuditStore{}
+	dispatcher := NewDispatcher(rehydrationQueue, store, ecsHandler, 1, logging.Default)
+	dispatcher.SetAudit(audit.NewRecorder(auditStore, audit.DispatcherSource, logging.Default))
+	started, err := dispatcher.Dispatch(ctx)
+	assert.ErrorContains(t, err, "no capacity")
+	assert.Zero(t, started)
+
+	// everything is put back for the next Dispatch
+	assert.Equal(t, idempotency.Queued, store.records["2/1/"])
+	assert.Equal(t, 1, rehydrationQueue.Len())
+	if assert.Len(t, auditStore.events, 2) {
+		assert.Equal(t, string(idempotency.InProgress), auditStore.events[1].PreviousStatus)
+		assert.Equal(t, string(idempotency.Queued), auditStore.events[1].Status)
+		assert.NotEmpty(t, auditStore.events[1].Detail[audit.ReasonDetail])
+	}
+}
+
+func TestDispatcher_Dispatch_ConcurrentClaim(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	store.records["1/1/"] = idempotency.InProgress
+	// another dispatch claims 5/1/ after this one has counted the rehydrations in progress, before its task starts
+	store.records["5/1/"] = idempotency.Queued
+	store.concurrentClaims = []string{"5/1/"}
+	rehydrationQueue := NewMemoryQueue()
+	for _, dataset := range []sharedmodels.Dataset{{ID: 2, VersionID: 1}, {ID: 3, VersionID: 1}} {
+		store.records[idempotency.RecordID(dataset.ID, dataset.VersionID)] = idempotency.Queued
+		_, err := rehydrationQueue.Enqueue(ctx, Message{Dataset: dataset})
+		require.NoError(t, err)
+	}
+	ecsHandler := &fakeECSHandler{}
+
+	dispatcher := NewDispatcher(rehydrationQueue, store, ecsHandler, 3, logging.Default)
+	started, err := dispatcher.Dispatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, started)
+	assert.Equal(t, []int{2}, ecsHandler.started)
+	assert.Equal(t, idempotency.InProgress, store.records["2/1/"])
+	assert.Equal(t, idempotency.Queued, store.records["3/1/"])
+	assert.Equal(t, 1, rehydrationQueue.Len())
+}
+
+func TestDispatcher_Dispatch_NotQueued(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	// 2/1/ has no record and 3/1/ has already been started
+	store.records["3/1/"] = idempotency.Completed
+	rehydrationQueue := NewMemoryQueue()
+	for _, dataset := range []sharedmodels.Dataset{{ID: 2, VersionID: 1}, {ID: 3, VersionID: 1}} {
+		_, err := rehydrationQueue.Enqueue(ctx, Message{Dataset: dataset})
+		require.NoError(t, err)
+	}
+	ecsHandler := &fakeECSHandler{}
+
+	dispatcher := NewDispatcher(rehydrationQueue, store, ecsHandler, 5, logging.Default)
+	started, err := dispatcher.Dispatch(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, started)
+	assert.Empty(t, ecsHandler.started)
+	assert.Zero(t, rehydrationQueue.Len())
+}
+
+func TestDispatcher_Dispatch_Destination(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	store.records["2/1/"] = idempotency.Queued
+	rehydrationQueue := NewMemoryQueue()
+	destination := &sharedmodels.Destination{Region: "eu-west-1", Bucket: "rehydration-eu"}
+	_, err := rehydrationQueue.Enqueue(ctx, Message{Dataset: sharedmodels.Dataset{ID: 2, VersionID: 1}, Destination: destination, RequestID: "request-2"})
+	require.NoError(t, err)
+	ecsHandler := &fakeECSHandler{}
+
+	dispatcher := NewDispatcher(rehydrationQueue, store, ecsHandler, 1, logging.Default)
+	started, err := dispatcher.Dispatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, started)
+	assert.Equal(t, []*sharedmodels.Destination{destination}, ecsHandler.destinations)
+	assert.Equal(t, []string{"request-2"}, ecsHandler.requestIDs)
+}
+
+func TestDispatcher_Dispatch_Resume(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	store.records["1/1/"] = idempotency.InProgress
+	destination := &sharedmodels.Destination{Region: "eu-west-1", Bucket: "rehydration-eu"}
+	// 2/1/ should be restored by now, 3/1/ not yet
+	store.restoring("2/1/", idempotency.Resume{
+		Dataset:     sharedmodels.Dataset{ID: 2, VersionID: 1},
+		Destination: destination,
+		RequestID:   "request-2",
+		ResumeAt:    time.Now().Add(-time.Minute),
+	})
+	store.restoring("3/1/", idempotency.Resume{Dataset: sharedmodels.Dataset{ID: 3, VersionID: 1}, ResumeAt: time.Now().Add(time.Hour)})
+	store.records["4/1/"] = idempotency.Queued
+	rehydrationQueue := NewMemoryQueue()
+	_, err := rehydrationQueue.Enqueue(ctx, Message{Dataset: sharedmodels.Dataset{ID: 4, VersionID: 1}})
+	require.NoError(t, err)
+	ecsHandler := &fakeECSHandler{}
+
+	auditStore := &fakeAuditStore{}
+	dispatcher := NewDispatcher(rehydrationQueue, store, ecsHandler, 3, logging.Default)
+	dispatcher.SetAudit(audit.NewRecorder(auditStore, audit.DispatcherSource, logging.Default))
+	started, err := dispatcher.Dispatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, started)
+	// the rehydration waiting for restores goes before the queued one
+	assert.Equal(t, []int{2, 4}, ecsHandler.started)
+	assert.Equal(t, []*sharedmodels.Destination{destination, nil}, ecsHandler.destinations)
+	assert.Equal(t, []string{"request-2", ""}, ecsHandler.requestIDs)
+	assert.Equal(t, idempotency.InProgress, store.records["2/1/"])
+	assert.Equal(t, "task-2", store.taskARNs["2/1/"])
+	assert.Equal(t, idempotency.Restoring, store.records["3/1/"])
+	assert.Zero(t, rehydrationQueue.Len())
+	if assert.Len(t, auditStore.events, 2) {
+		assert.Equal(t, "2/1/", auditStore.events[0].SubjectID)
+		assert.Equal(t, string(idempotency.Restoring), auditStore.events[0].PreviousStatus)
+		assert.Equal(t, string(idempotency.InProgress), auditStore.events[0].Status)
+	}
+}
+
+func TestDispatcher_Dispatch_ResumeAtCapacity(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	store.records["1/1/"] = idempotency.InProgress
+	for _, datasetID := range []int{2, 3} {
+		store.restoring(idempotency.RecordID(datasetID, 1), idempotency.Resume{
+			Dataset:  sharedmodels.Dataset{ID: datasetID, VersionID: 1},
+			ResumeAt: time.Now().Add(-time.Minute),
+		})
+	}
+	store.records["4/1/"] = idempotency.Queued
+	rehydrationQueue := NewMemoryQueue()
+	_, err := rehydrationQueue.Enqueue(ctx, Message{Dataset: sharedmodels.Dataset{ID: 4, VersionID: 1}})
+	require.NoError(t, err)
+	ecsHandler := &fakeECSHandler{}
+
+	dispatcher := NewDispatcher(rehydrationQueue, store, ecsHandler, 2, logging.Default)
+	started, err := dispatcher.Dispatch(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, started)
+	assert.Len(t, ecsHandler.started, 1)
+	assert.Equal(t, 2, store.count(idempotency.InProgress))
+	assert.Equal(t, 1, store.count(idempotency.Restoring))
+	assert.Equal(t, 1, rehydrationQueue.Len())
+}
+
+func TestDispatcher_Dispatch_ResumeStartError(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore()
+	store.restoring("2/1/", idempotency.Resume{Dataset: sharedmodels.Dataset{ID: 2, VersionID: 1}, ResumeAt: time.Now().Add(-time.Minute)})
+	ecsHandler := &fakeECSHandler{err: errors.New("no capacity")}
+
+	auditStore := &fakeAuditStore{}
+	dispatcher := NewDispatcher(NewMemoryQueue(), store, ecsHandler, 1, logging.Default)
+	dispatcher.SetAudit(audit.NewRecorder(auditStore, audit.DispatcherSource, logging.Default))
+	started, err := dispatcher.Dispatch(ctx)
+	assert.ErrorContains(t, err, "no capacity")
+	assert.Zero(t, started)
+
+	// left waiting for the next Dispatch to try again
+	assert.Equal(t, idempotency.Restoring, store.records["2/1/"])
+	if assert.Len(t, auditStore.events, 2) {
+		assert.Equal(t, string(idempotency.InProgress), auditStore.events[1].PreviousStatus)
+		assert.Equal(t, string(idempotency.Restoring), auditStore.events[1].Status)
+		assert.NotEmpty(t, auditStore.events[1].Detail[audit.ReasonDetail])
+	}
+}
+
+// fakeStore implements only the idempotency.Store methods used by Dispatcher
+type fakeStore struct {
+	idempotency.Store
+	records  map[string]idempotency.Status
+	taskARNs map[string]string
+	resumes  map[string]idempotency.Resume
+	// concurrentClaims are marked in progress along with the first record UpdateStatus marks in progress
+	concurrentClaims []string
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{records: map[string]idempotency.Status{}, taskARNs: map[string]string{}, resumes: map[string]idempotency.Resume{}}
+}
+
+// restoring adds a record waiting for restores
+func (s *fakeStore) restoring(recordID string, resume idempotency.Resume) {
+	s.records[recordID] = idempotency.Restoring
+	s.resumes[recordID] = resume
+}
+
+func (s *fakeStore) count(status idempotency.Status) int {
+	var count int
+	for _, s := range s.records {
+		if s == status {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *fakeStore) CountByStatus(_ context.Context, status idempotency.Status) (int, error) {
+	return s.count(status), nil
+}
+
+func (s *fakeStore) QueryByStatus(_ context.Context, status idempotency.Status) ([]idempotency.Record, error) {
+	var records []idempotency.Record
+	for recordID, recordStatus := range s.records {
+		if recordStatus == status {
+			record := idempotency.NewRecord(recordID, status)
+			if resume, ok := s.resumes[recordID]; ok {
+				record.Resume = &resume
+			}
+			records = append(records, *record)
+		}
+	}
+	return records, nil
+}
+
+func (s *fakeStore) UpdateStatus(_ context.Context, recordID string, expected idempotency.Status, status idempotency.Status) error {
+	actual, ok := s.records[recordID]
+	if !ok {
+		return &idempotency.RecordDoesNotExistsError{RecordID: recordID}
+	}
+	if actual != expected {
+		return &idempotency.ConditionFailedError{}
+	}
+	s.records[recordID] = status
+	if status == idempotency.InProgress {
+		for _, claimed := range s.concurrentClaims {
+			s.records[claimed] = idempotency.InProgress
+		}
+		s.concurrentClaims = nil
+	}
+	return nil
+}
+
+func (s *fakeStore) SetTaskARN(_ context.Context, recordID string, taskARN string) error {
+	s.taskARNs[recordID] = taskARN
+	return nil
+}
+
+// fakeAuditStore only keeps appended events
+type fakeAuditStore struct {
+	audit.Store
+	events []audit.Event
+}
+
+func (s *fakeAuditStore) Append(_ context.Context, events []audit.Event) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
+type fakeECSHandler struct {
+	err          error
+	started      []int
+	destinations []*sharedmodels.Destination
+	requestIDs   []string
+}
+
+func (h *fakeECSHandler) Handle(_ context.Context, dataset sharedmodels.Dataset, _ sharedmodels.User, destination *sharedmodels.Destination, requestID string, _ *slog.Logger) (string, error) {
+	if h.err != nil {
+		return "", h.err
+	}
+	h.started = append(h.started, dataset.ID)
+	h.destinations = append(h.destinations, destination)
+	h.requestIDs = append(h.requestIDs, requestID)
+	return fmt.Sprintf("task-%d", dataset.ID), nil
+}
+
+func (h *fakeECSHandler) Status(_ context.Context, _ string) (*ecs.JobStatus, error) {
+	return nil, errors.New("not implemented")
+}
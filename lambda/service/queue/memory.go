@@ -0,0 +1,102 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type memoryEntry struct {
+	seq     int
+	message Message
+}
+
+func (e memoryEntry) before(other memoryEntry) bool {
+	if e.message.Priority.rank() != other.message.Priority.rank() {
+		return e.message.Priority.rank() < other.message.Priority.rank()
+	}
+	return e.seq < other.seq
+}
+
+// MemoryQueue is a Queue for running the service locally or in tests. Messages with the same priority are received
+// in the order they were enqueued.
+type MemoryQueue struct {
+	mu       sync.Mutex
+	nextSeq  int
+	pending  []memoryEntry
+	inFlight map[string]memoryEntry
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{inFlight: map[string]memoryEntry{}}
+}
+
+func (q *MemoryQueue) Enqueue(_ context.Context, message Message) (int, error) {
+	priority, err := PriorityFromString(string(message.Priority))
+	if err != nil {
+		return 0, err
+	}
+	message.Priority = priority
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry := memoryEntry{seq: q.nextSeq, message: message}
+	q.nextSeq++
+	return q.insert(entry), nil
+}
+
+func (q *MemoryQueue) Receive(_ context.Context, maxMessages int) ([]*Received, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	count := min(maxMessages, len(q.pending))
+	var received []*Received
+	for _, entry := range q.pending[:count] {
+		receipt := strconv.Itoa(entry.seq)
+		q.inFlight[receipt] = entry
+		received = append(received, &Received{Message: entry.message, receipt: receipt})
+	}
+	q.pending = q.pending[count:]
+	return received, nil
+}
+
+func (q *MemoryQueue) Delete(_ context.Context, received *Received) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.inFlight[received.receipt]; !ok {
+		return fmt.Errorf("message with receipt %s is not in flight", received.receipt)
+	}
+	delete(q.inFlight, received.receipt)
+	return nil
+}
+
+// Release puts the message back in the position it had before it was received.
+func (q *MemoryQueue) Release(_ context.Context, received *Received) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry, ok := q.inFlight[received.receipt]
+	if !ok {
+		return fmt.Errorf("message with receipt %s is not in flight", received.receipt)
+	}
+	delete(q.inFlight, received.receipt)
+	q.insert(entry)
+	return nil
+}
+
+// Len returns the number of messages waiting to be received.
+func (q *MemoryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// insert must be called with q.mu held. Returns the index of the new entry.
+func (q *MemoryQueue) insert(entry memoryEntry) int {
+	i := sort.Search(len(q.pending), func(i int) bool {
+		return entry.before(q.pending[i])
+	})
+	q.pending = append(q.pending, memoryEntry{})
+	copy(q.pending[i+1:], q.pending[i:])
+	q.pending[i] = entry
+	return i
+}
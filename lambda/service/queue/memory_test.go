@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"context"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue()
+
+	for i, params := range []struct {
+		priority         Priority
+		expectedPosition int
+	}{
+		{Normal, 0},
+		{Low, 1},
+		{High, 0},
+		{"", 2},
+		{High, 1},
+	} {
+		position, err := q.Enqueue(ctx, Message{Dataset: sharedmodels.Dataset{ID: i + 1, VersionID: 1}, Priority: params.priority})
+		require.NoError(t, err)
+		assert.Equal(t, params.expectedPosition, position, "message %d", i)
+	}
+	require.Equal(t, 5, q.Len())
+
+	_, err := q.Enqueue(ctx, Message{Priority: "urgent"})
+	assert.ErrorContains(t, err, "urgent")
+
+	received, err := q.Receive(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 5, 1}, datasetIDs(received))
+	assert.Equal(t, Normal, received[2].Priority)
+	assert.Equal(t, 2, q.Len())
+
+	// released messages go back to their original position
+	require.NoError(t, q.Release(ctx, received[1]))
+	require.NoError(t, q.Delete(ctx, received[0]))
+	require.NoError(t, q.Release(ctx, received[2]))
+	assert.Error(t, q.Delete(ctx, received[0]))
+
+	received, err = q.Receive(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{5, 1, 4, 2}, datasetIDs(received))
+	assert.Zero(t, q.Len())
+}
+
+func TestPriorityFromString(t *testing.T) {
+	for s, expected := range map[string]Priority{"": Normal, "normal": Normal, "HIGH": High, "Low": Low} {
+		actual, err := PriorityFromString(s)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+	_, err := PriorityFromString("urgent")
+	assert.ErrorContains(t, err, "urgent")
+}
+
+func datasetIDs(received []*Received) []int {
+	var ids []int
+	for _, r := range received {
+		ids = append(ids, r.Dataset.ID)
+	}
+	return ids
+}
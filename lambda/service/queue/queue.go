@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"strings"
+	"time"
+)
+
+type Priority string
+
+const (
+	High   Priority = "high"
+	Normal Priority = "normal"
+	Low    Priority = "low"
+)
+
+// Priorities lists the priorities in the order their messages are received
+var Priorities = []Priority{High, Normal, Low}
+
+// PriorityFromString returns Normal for an empty string
+func PriorityFromString(s string) (Priority, error) {
+	switch strings.ToLower(s) {
+	case "", string(Normal):
+		return Normal, nil
+	case string(High):
+		return High, nil
+	case string(Low):
+		return Low, nil
+	default:
+		return "", fmt.Errorf("unknown priority: [%s]", s)
+	}
+}
+
+// rank is lower for messages that should be received first
+func (p Priority) rank() int {
+	for i, priority := range Priorities {
+		if p == priority {
+			return i
+		}
+	}
+	return len(Priorities)
+}
+
+type Message struct {
+	Dataset    sharedmodels.Dataset `json:"dataset"`
+	User       sharedmodels.User    `json:"user"`
+	Priority   Priority             `json:"priority"`
+	EnqueuedAt time.Time            `json:"enqueuedAt"`
+	// Destination is nil if the dataset should be rehydrated into the default region
+	Destination *sharedmodels.Destination `json:"destination,omitempty"`
+	// RequestID is the ID of the request that queued the rehydration. Empty for messages queued before it was added.
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// Received is a Message returned by Queue.Receive. It must be passed to either Queue.Delete or Queue.Release once the
+// receiver is done with it.
+type Received struct {
+	Message
+	receipt string
+}
+
+type Queue interface {
+	// Enqueue adds message to the queue and returns the number of messages that will be received before it.
+	Enqueue(ctx context.Context, message Message) (int, error)
+	// Receive returns up to maxMessages messages, highest priority first. A received message is not returned by
+	// Receive again unless it is released.
+	Receive(ctx context.Context, maxMessages int) ([]*Received, error)
+	// Delete removes a received message from the queue.
+	Delete(ctx context.Context, received *Received) error
+	// Release returns a received message to the queue so that it can be received again.
+	Release(ctx context.Context, received *Received) error
+}
@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"strconv"
+)
+
+// maxReceiveBatch is the most messages SQS will return from one ReceiveMessage call
+const maxReceiveBatch = 10
+
+// SQSQueue uses one SQS queue per priority since SQS has no notion of message priority.
+type SQSQueue struct {
+	client    *sqs.Client
+	queueURLs map[Priority]string
+}
+
+func NewSQSQueue(awsConfig aws.Config, config *Config) Queue {
+	return &SQSQueue{
+		client:    sqs.NewFromConfig(awsConfig),
+		queueURLs: config.QueueURLs,
+	}
+}
+
+// Enqueue returns an approximate position since SQS only gives approximate message counts.
+func (q *SQSQueue) Enqueue(ctx context.Context, message Message) (int, error) {
+	priority, err := PriorityFromString(string(message.Priority))
+	if err != nil {
+		return 0, err
+	}
+	message.Priority = priority
+	queueURL, err := q.queueURL(message.Priority)
+	if err != nil {
+		return 0, err
+	}
+	position, err := q.countAhead(ctx, message.Priority)
+	if err != nil {
+		return 0, err
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return 0, fmt.Errorf("error marshalling queue message: %w", err)
+	}
+	if _, err := q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String(string(body)),
+	}); err != nil {
+		return 0, fmt.Errorf("error sending message to %s: %w", queueURL, err)
+	}
+	return position, nil
+}
+
+func (q *SQSQueue) countAhead(ctx context.Context, priority Priority) (int, error) {
+	var count int
+	for _, p := range Priorities {
+		if p.rank() > priority.rank() {
+			break
+		}
+		queueURL := q.queueURLs[p]
+		out, err := q.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+			QueueUrl:       aws.String(queueURL),
+			AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameApproximateNumberOfMessages},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("error getting attributes of %s: %w", queueURL, err)
+		}
+		n, err := strconv.Atoi(out.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)])
+		if err != nil {
+			return 0, fmt.Errorf("error reading message count of %s: %w", queueURL, err)
+		}
+		count += n
+	}
+	return count, nil
+}
+
+func (q *SQSQueue) Receive(ctx context.Context, maxMessages int) ([]*Received, error) {
+	var received []*Received
+	for _, priority := range Priorities {
+		queueURL := q.queueURLs[priority]
+		// keep reading from this queue until it is empty before moving to the next priority
+		for remaining := maxMessages - len(received); remaining > 0; remaining = maxMessages - len(received) {
+			out, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+				QueueUrl:            aws.String(queueURL),
+				MaxNumberOfMessages: int32(min(remaining, maxReceiveBatch)),
+			})
+			if err != nil {
+				return received, fmt.Errorf("error receiving messages from %s: %w", queueURL, err)
+			}
+			if len(out.Messages) == 0 {
+				break
+			}
+			for _, sqsMessage := range out.Messages {
+				var message Message
+				if err := json.Unmarshal([]byte(aws.ToString(sqsMessage.Body)), &message); err != nil {
+					return received, fmt.Errorf("error unmarshalling message %s from %s: %w", aws.ToString(sqsMessage.MessageId), queueURL, err)
+				}
+				received = append(received, &Received{Message: message, receipt: aws.ToString(sqsMessage.ReceiptHandle)})
+			}
+		}
+	}
+	return received, nil
+}
+
+func (q *SQSQueue) Delete(ctx context.Context, received *Received) error {
+	queueURL, err := q.queueURL(received.Priority)
+	if err != nil {
+		return err
+	}
+	if _, err := q.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(queueURL),
+		ReceiptHandle: aws.String(received.receipt),
+	}); err != nil {
+		return fmt.Errorf("error deleting message from %s: %w", queueURL, err)
+	}
+	return nil
+}
+
+// Release sets the message's visibility timeout to zero so that it can be received immediately
+func (q *SQSQueue) Release(ctx context.Context, received *Received) error {
+	queueURL, err := q.queueURL(received.Priority)
+	if err != nil {
+		return err
+	}
+	if _, err := q.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(queueURL),
+		ReceiptHandle:     aws.String(received.receipt),
+		VisibilityTimeout: 0,
+	}); err != nil {
+		return fmt.Errorf("error releasing message to %s: %w", queueURL, err)
+	}
+	return nil
+}
+
+func (q *SQSQueue) queueURL(priority Priority) (string, error) {
+	queueURL, ok := q.queueURLs[priority]
+	if !ok {
+		return "", fmt.Errorf("no queue configured for priority %s", priority)
+	}
+	return queueURL, nil
+}
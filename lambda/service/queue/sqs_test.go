@@ -0,0 +1,121 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+var testQueueURLs = map[Priority]string{
+	High:   "https://sqs.us-east-1.amazonaws.com/123456789012/rehydration-high",
+	Normal: "https://sqs.us-east-1.amazonaws.com/123456789012/rehydration-normal",
+	Low:    "https://sqs.us-east-1.amazonaws.com/123456789012/rehydration-low",
+}
+
+// mockSQS keeps one slice of message bodies per queue URL and handles just enough of the SQS JSON protocol for SQSQueue.
+type mockSQS struct {
+	t        *testing.T
+	messages map[string][]string
+	deleted  []string
+	released []string
+}
+
+func newMockSQS(t *testing.T) *mockSQS {
+	return &mockSQS{t: t, messages: map[string][]string{}}
+}
+
+func (m *mockSQS) handle(r *http.Request) (int, any) {
+	var in map[string]any
+	require.NoError(m.t, json.NewDecoder(r.Body).Decode(&in))
+	queueURL := in["QueueUrl"].(string)
+	switch action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS."); action {
+	case "GetQueueAttributes":
+		return http.StatusOK, map[string]any{"Attributes": map[string]string{
+			"ApproximateNumberOfMessages": fmt.Sprint(len(m.messages[queueURL]))}}
+	case "SendMessage":
+		m.messages[queueURL] = append(m.messages[queueURL], in["MessageBody"].(string))
+		return http.StatusOK, map[string]string{"MessageId": "id"}
+	case "ReceiveMessage":
+		count := min(int(in["MaxNumberOfMessages"].(float64)), len(m.messages[queueURL]))
+		var messages []map[string]string
+		for i, body := range m.messages[queueURL][:count] {
+			messages = append(messages, map[string]string{
+				"MessageId":     fmt.Sprint(i),
+				"ReceiptHandle": fmt.Sprintf("%s#%s", queueURL, body),
+				"Body":          body,
+			})
+		}
+		m.messages[queueURL] = m.messages[queueURL][count:]
+		return http.StatusOK, map[string]any{"Messages": messages}
+	case "DeleteMessage":
+		m.deleted = append(m.deleted, in["ReceiptHandle"].(string))
+		return http.StatusOK, map[string]any{}
+	case "ChangeMessageVisibility":
+		m.released = append(m.released, in["ReceiptHandle"].(string))
+		return http.StatusOK, map[string]any{}
+	default:
+		require.FailNow(m.t, "unexpected SQS action", action)
+		return 0, nil
+	}
+}
+
+func TestSQSQueue(t *testing.T) {
+	ctx := context.Background()
+	mock := newMockSQS(t)
+	mockServer := test.NewHTTPMuxTestFixture(t, test.NewHandlerFuncBuilder("/sqs/").WithMethod(http.MethodPost).WithSelectorFunc(mock.handle))
+	defer mockServer.Teardown()
+
+	q := &SQSQueue{client: newTestSQSClient(mockServer.Server.URL + "/sqs/"), queueURLs: testQueueURLs}
+
+	for i, params := range []struct {
+		priority         Priority
+		expectedPosition int
+	}{
+		{Low, 0},
+		{Normal, 0},
+		{High, 0},
+		{"", 2},
+		{Low, 4},
+	} {
+		position, err := q.Enqueue(ctx, Message{Dataset: sharedmodels.Dataset{ID: i + 1, VersionID: 1}, Priority: params.priority})
+		require.NoError(t, err)
+		assert.Equal(t, params.expectedPosition, position, "message %d", i)
+	}
+	assert.Len(t, mock.messages[testQueueURLs[Low]], 2)
+	assert.Len(t, mock.messages[testQueueURLs[Normal]], 2)
+	assert.Len(t, mock.messages[testQueueURLs[High]], 1)
+
+	received, err := q.Receive(ctx, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 2, 4, 1}, datasetIDs(received))
+
+	require.NoError(t, q.Delete(ctx, received[0]))
+	require.NoError(t, q.Release(ctx, received[3]))
+	assert.Equal(t, []string{received[0].receipt}, mock.deleted)
+	assert.True(t, strings.HasPrefix(mock.deleted[0], testQueueURLs[High]))
+	assert.Equal(t, []string{received[3].receipt}, mock.released)
+	assert.True(t, strings.HasPrefix(mock.released[0], testQueueURLs[Low]))
+
+	_, err = q.Enqueue(ctx, Message{Priority: "urgent"})
+	assert.ErrorContains(t, err, "urgent")
+}
+
+func newTestSQSClient(url string) *sqs.Client {
+	return sqs.NewFromConfig(aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("test-key", "test-secret", ""),
+	}, func(options *sqs.Options) {
+		options.BaseEndpoint = aws.String(url)
+		options.DisableMessageChecksumValidation = true
+	})
+}
@@ -0,0 +1,185 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+	"github.com/pennsieve/rehydration-service/shared/discover"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+	"time"
+)
+
+// ExceededError is returned by Checker.Check if a request should be rejected.
+type ExceededError struct {
+	Reason string
+	// RetryAfter is how long until the request would be accepted. Zero if it will never be accepted.
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded: %s", e.Reason)
+}
+
+// datasetSizeFunc returns the total size in bytes of a dataset version
+type datasetSizeFunc func(ctx context.Context, dataset sharedmodels.Dataset) (int64, error)
+
+// Checker decides whether a rehydration request is within the configured quotas and counts accepted requests against
+// them. Per-user quotas are counted over a rolling window that ends at the time of the request. The window is made of
+// BucketsPerWindow counters, so usage stops counting up to a bucket's length, one hour for a 24-hour window, before a
+// full window has passed.
+type Checker struct {
+	config           *Config
+	store            Store
+	idempotencyStore idempotency.Store
+	datasetSize      datasetSizeFunc
+	now              func() time.Time
+	logger           *slog.Logger
+}
+
+func NewChecker(config *Config, dyDBClient *dynamodb.Client, idempotencyStore idempotency.Store, logger *slog.Logger) *Checker {
+	pennsieveClient := pennsieve.NewClient(pennsieve.APIParams{ApiHost: config.PennsieveHost})
+	return &Checker{
+		config:           config,
+		store:            NewStore(dyDBClient, logger, config.TableName),
+		idempotencyStore: idempotencyStore,
+		datasetSize: func(ctx context.Context, dataset sharedmodels.Dataset) (int64, error) {
+			return discover.DatasetSize(ctx, pennsieveClient, dataset)
+		},
+		now:    time.Now,
+		logger: logger,
+	}
+}
+
+// Check returns an *ExceededError if the request should be rejected. Otherwise, the request is counted against the
+// user's quotas and the returned Usage should be passed to Release if the request cannot be handled after all.
+//
+// Requests for a dataset version that already has an idempotency record for destination do not start a new rehydration,
+// so they only count against the user's request limit. destination is nil for our own buckets.
+func (c *Checker) Check(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, destination *sharedmodels.Destination) (*Usage, error) {
+	usage := &Usage{}
+	if c.config.IsExempt(user.Email) {
+		c.logger.Info("user is exempt from quotas", slog.String("email", user.Email))
+		return usage, nil
+	}
+
+	existing, err := c.idempotencyStore.GetRecord(ctx, idempotency.DestinationRecordID(dataset.ID, dataset.VersionID, destination))
+	if err != nil {
+		return nil, err
+	}
+	newRehydration := existing == nil
+
+	if newRehydration && c.config.MaxActive > 0 {
+		if err := c.checkActive(ctx); err != nil {
+			return nil, err
+		}
+	}
+	now := c.now()
+	if c.config.MaxRequests > 0 {
+		if err := c.add(ctx, usage, user.Email, RequestsQuota, c.config.RequestsWindow, 1, int64(c.config.MaxRequests), now); err != nil {
+			return nil, err
+		}
+	}
+	if newRehydration && c.config.MaxBytes > 0 {
+		size, err := c.datasetSize(ctx, dataset)
+		if err != nil {
+			// without the size there is no way to know that the request is within the byte quota
+			return nil, errors.Join(fmt.Errorf("error getting size of dataset %d version %d: %w", dataset.ID, dataset.VersionID, err),
+				c.Release(ctx, usage))
+		}
+		if size > c.config.MaxBytes {
+			exceededError := &ExceededError{Reason: fmt.Sprintf("dataset size %d bytes is larger than the limit of %d bytes", size, c.config.MaxBytes)}
+			return nil, errors.Join(exceededError, c.Release(ctx, usage))
+		}
+		if err := c.add(ctx, usage, user.Email, BytesQuota, c.config.BytesWindow, size, c.config.MaxBytes, now); err != nil {
+			// give back the request counted above
+			return nil, errors.Join(err, c.Release(ctx, usage))
+		}
+	}
+	return usage, nil
+}
+
+// Release takes the counts added by Check back off the user's counters
+func (c *Checker) Release(ctx context.Context, usage *Usage) error {
+	var errs []error
+	for _, added := range usage.Added {
+		added.Count = -added.Count
+		if _, err := c.store.Add(ctx, added, -1); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	usage.Added = nil
+	return errors.Join(errs...)
+}
+
+// add adds count to the user's counter for quota in the current bucket and to usage, or returns an *ExceededError if
+// that would put the total of the window ending now over limit. Earlier buckets only change when usage is released,
+// so the room they leave is passed to the store as the limit of the current bucket's conditional add.
+func (c *Checker) add(ctx context.Context, usage *Usage, userEmail string, quota string, window time.Duration, count int64, limit int64, now time.Time) error {
+	bucket := window / BucketsPerWindow
+	bucketStart := now.Truncate(bucket)
+	counters, err := c.store.Counters(ctx, userEmail, quota, bucketStart.Add(bucket-window), bucketStart)
+	if err != nil {
+		return err
+	}
+	counter := NewCounter(userEmail, quota, bucketStart, window, count)
+	var previous int64
+	for _, earlier := range counters {
+		if earlier.ExpiresAt < counter.ExpiresAt {
+			previous += earlier.Count
+		}
+	}
+	added, err := c.store.Add(ctx, *counter, max(limit-previous, 0))
+	if err != nil {
+		return err
+	}
+	if !added {
+		return &ExceededError{
+			Reason:     fmt.Sprintf("limit of %d %s in %s reached", limit, quota, window),
+			RetryAfter: retryAfter(counters, count, limit, bucketStart.Add(bucket), now),
+		}
+	}
+	usage.Added = append(usage.Added, *counter)
+	return nil
+}
+
+// retryAfter returns how long until enough of counters, oldest first, have left the window for count to fit under
+// limit. It is zero if count will never fit.
+func retryAfter(counters []Counter, count int64, limit int64, bucketEnd time.Time, now time.Time) time.Duration {
+	if count > limit {
+		return 0
+	}
+	var used int64
+	for _, counter := range counters {
+		used += counter.Count
+	}
+	for _, counter := range counters {
+		used -= counter.Count
+		if used+count <= limit {
+			return time.Unix(counter.ExpiresAt, 0).Sub(now)
+		}
+	}
+	// the current bucket was added to after counters were read, so there is nothing to go on until it ends
+	return bucketEnd.Sub(now)
+}
+
+func (c *Checker) checkActive(ctx context.Context) error {
+	var active int
+	for _, status := range []idempotency.Status{idempotency.Queued, idempotency.InProgress, idempotency.Restoring} {
+		count, err := c.idempotencyStore.CountByStatus(ctx, status)
+		if err != nil {
+			return err
+		}
+		active += count
+	}
+	if active >= c.config.MaxActive {
+		return &ExceededError{
+			Reason:     fmt.Sprintf("%d rehydrations are already queued, in progress, or waiting for restores", active),
+			RetryAfter: c.config.ActiveRetryAfter,
+		}
+	}
+	return nil
+}
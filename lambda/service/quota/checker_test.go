@@ -0,0 +1,265 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+var testUser = sharedmodels.User{Name: "First Last", Email: "last@example.com"}
+var testDataset = sharedmodels.Dataset{ID: 5065, VersionID: 2}
+
+func TestChecker_Check_Requests(t *testing.T) {
+	ctx := context.Background()
+	checker := newTestChecker(&Config{MaxRequests: 2, RequestsWindow: 24 * time.Hour})
+	store := checker.store.(*fakeStore)
+
+	// a request from a day ago has left the rolling window, but one from 23 hours ago has not
+	bucketStart := testNow.Truncate(time.Hour)
+	store.add(NewCounter(testUser.Email, RequestsQuota, bucketStart.Add(-24*time.Hour), 24*time.Hour, 2))
+	store.add(NewCounter(testUser.Email, RequestsQuota, bucketStart.Add(-23*time.Hour), 24*time.Hour, 1))
+
+	usage, err := checker.Check(ctx, testDataset, testUser, nil)
+	require.NoError(t, err)
+	expectedCounter := NewCounter(testUser.Email, RequestsQuota, bucketStart, 24*time.Hour, 1)
+	assert.Equal(t, []Counter{*expectedCounter}, usage.Added)
+	assert.Equal(t, bucketStart.Add(24*time.Hour).Unix(), expectedCounter.ExpiresAt)
+	assert.Equal(t, int64(1), store.count(expectedCounter))
+
+	// differently capitalized email addresses share the same counters
+	_, err = checker.Check(ctx, testDataset, sharedmodels.User{Name: testUser.Name, Email: "Last@Example.com"}, nil)
+	var exceededError *ExceededError
+	require.ErrorAs(t, err, &exceededError)
+	// the request from 23 hours ago leaves the window in an hour
+	assert.Equal(t, time.Hour, exceededError.RetryAfter)
+
+	// other users are not affected
+	_, err = checker.Check(ctx, testDataset, sharedmodels.User{Name: "Other", Email: "other@example.com"}, nil)
+	assert.NoError(t, err)
+
+	// released usage makes room again
+	require.NoError(t, checker.Release(ctx, usage))
+	assert.Zero(t, store.count(expectedCounter))
+	_, err = checker.Check(ctx, testDataset, testUser, nil)
+	assert.NoError(t, err)
+
+	// an hour later the oldest request no longer counts
+	checker.now = func() time.Time { return testNow.Add(time.Hour) }
+	_, err = checker.Check(ctx, testDataset, testUser, nil)
+	assert.NoError(t, err)
+}
+
+func TestChecker_Check_Bytes(t *testing.T) {
+	ctx := context.Background()
+	checker := newTestChecker(&Config{MaxBytes: 100, BytesWindow: 24 * time.Hour})
+	store := checker.store.(*fakeStore)
+	earlier := NewCounter(testUser.Email, BytesQuota, testNow.Truncate(24*time.Hour), 24*time.Hour, 70)
+	store.add(earlier)
+
+	checker.datasetSize = constantSize(30)
+	usage, err := checker.Check(ctx, testDataset, testUser, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []Counter{*NewCounter(testUser.Email, BytesQuota, testNow.Truncate(time.Hour), 24*time.Hour, 30)}, usage.Added)
+	require.NoError(t, checker.Release(ctx, usage))
+
+	// 70 + 60 is over until the earlier 70 leaves the window
+	checker.datasetSize = constantSize(60)
+	_, err = checker.Check(ctx, testDataset, testUser, nil)
+	var exceededError *ExceededError
+	require.ErrorAs(t, err, &exceededError)
+	assert.Equal(t, 12*time.Hour, exceededError.RetryAfter)
+
+	// never fits
+	checker.datasetSize = constantSize(101)
+	_, err = checker.Check(ctx, testDataset, testUser, nil)
+	require.ErrorAs(t, err, &exceededError)
+	assert.Zero(t, exceededError.RetryAfter)
+
+	// an existing rehydration does not use any more bytes
+	checker.idempotencyStore.(*fakeIdempotencyStore).records[idempotency.RecordID(testDataset.ID, testDataset.VersionID)] = idempotency.Completed
+	usage, err = checker.Check(ctx, testDataset, testUser, nil)
+	require.NoError(t, err)
+	assert.Empty(t, usage.Added)
+
+	// unknown sizes are rejected
+	checker.idempotencyStore = newFakeIdempotencyStore()
+	checker.datasetSize = func(_ context.Context, _ sharedmodels.Dataset) (int64, error) {
+		return 0, errors.New("discover unavailable")
+	}
+	_, err = checker.Check(ctx, testDataset, testUser, nil)
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &exceededError))
+	assert.Equal(t, int64(70), store.count(earlier))
+}
+
+func TestChecker_Check_UnknownSizeReleasesRequest(t *testing.T) {
+	ctx := context.Background()
+	checker := newTestChecker(&Config{MaxRequests: 5, RequestsWindow: time.Hour, MaxBytes: 100, BytesWindow: 24 * time.Hour})
+	store := checker.store.(*fakeStore)
+	checker.datasetSize = func(_ context.Context, _ sharedmodels.Dataset) (int64, error) {
+		return 0, errors.New("discover unavailable")
+	}
+
+	_, err := checker.Check(ctx, testDataset, testUser, nil)
+	require.Error(t, err)
+	assert.Zero(t, store.count(NewCounter(testUser.Email, RequestsQuota, testNow.Truncate(time.Hour), time.Hour, 0)))
+}
+
+func TestChecker_Check_RequestAndBytes(t *testing.T) {
+	ctx := context.Background()
+	checker := newTestChecker(&Config{MaxRequests: 5, RequestsWindow: time.Hour, MaxBytes: 100, BytesWindow: 24 * time.Hour})
+	store := checker.store.(*fakeStore)
+	checker.datasetSize = constantSize(101)
+
+	_, err := checker.Check(ctx, testDataset, testUser, nil)
+	var exceededError *ExceededError
+	require.ErrorAs(t, err, &exceededError)
+
+	// the request is not counted if the byte quota rejects it
+	assert.Zero(t, store.count(NewCounter(testUser.Email, RequestsQuota, testNow.Truncate(time.Hour), time.Hour, 0)))
+}
+
+func TestChecker_Check_Active(t *testing.T) {
+	ctx := context.Background()
+	checker := newTestChecker(&Config{MaxActive: 2, ActiveRetryAfter: 15 * time.Minute})
+	idempotencyStore := checker.idempotencyStore.(*fakeIdempotencyStore)
+	idempotencyStore.records["1/1/"] = idempotency.InProgress
+	idempotencyStore.records["2/1/"] = idempotency.Completed
+
+	_, err := checker.Check(ctx, testDataset, testUser, nil)
+	require.NoError(t, err)
+
+	idempotencyStore.records["3/1/"] = idempotency.Queued
+	_, err = checker.Check(ctx, testDataset, testUser, nil)
+	var exceededError *ExceededError
+	require.ErrorAs(t, err, &exceededError)
+	assert.Equal(t, 15*time.Minute, exceededError.RetryAfter)
+
+	// requests for rehydrations that already exist are still accepted
+	_, err = checker.Check(ctx, sharedmodels.Dataset{ID: 1, VersionID: 1}, testUser, nil)
+	assert.NoError(t, err)
+
+	// but an external destination gets a rehydration of its own
+	external := &sharedmodels.Destination{Bucket: "requester-bucket", RoleARN: "arn:aws:iam::123456789012:role/rehydration"}
+	_, err = checker.Check(ctx, sharedmodels.Dataset{ID: 1, VersionID: 1}, testUser, external)
+	assert.ErrorAs(t, err, &exceededError)
+}
+
+func TestChecker_Check_Exempt(t *testing.T) {
+	ctx := context.Background()
+	checker := newTestChecker(&Config{MaxRequests: 1, RequestsWindow: time.Hour, Exempt: []string{"@example.com"}})
+	checker.store.(*fakeStore).add(NewCounter(testUser.Email, RequestsQuota, testNow, time.Hour, 1))
+
+	usage, err := checker.Check(ctx, testDataset, testUser, nil)
+	require.NoError(t, err)
+	assert.Empty(t, usage.Added)
+}
+
+func TestConfig_IsExempt(t *testing.T) {
+	config := &Config{Exempt: []string{"admin@example.com", "@pennsieve.org"}}
+	assert.True(t, config.IsExempt("admin@example.com"))
+	assert.True(t, config.IsExempt("Admin@Example.com"))
+	assert.True(t, config.IsExempt("anyone@pennsieve.org"))
+	assert.False(t, config.IsExempt("user@example.com"))
+	assert.False(t, config.IsExempt("user@notpennsieve.org.example.com"))
+}
+
+func newTestChecker(config *Config) *Checker {
+	return &Checker{
+		config:           config,
+		store:            &fakeStore{counters: map[string]*Counter{}},
+		idempotencyStore: newFakeIdempotencyStore(),
+		datasetSize:      constantSize(0),
+		now:              func() time.Time { return testNow },
+		logger:           logging.Default,
+	}
+}
+
+func constantSize(size int64) datasetSizeFunc {
+	return func(_ context.Context, _ sharedmodels.Dataset) (int64, error) {
+		return size, nil
+	}
+}
+
+type fakeStore struct {
+	counters map[string]*Counter
+}
+
+func (s *fakeStore) key(counter *Counter) string {
+	return counter.UserEmail + "/" + counter.Window
+}
+
+func (s *fakeStore) add(counter *Counter) {
+	key := s.key(counter)
+	if stored, ok := s.counters[key]; ok {
+		stored.Count += counter.Count
+		return
+	}
+	added := *counter
+	s.counters[key] = &added
+}
+
+func (s *fakeStore) count(counter *Counter) int64 {
+	if stored, ok := s.counters[s.key(counter)]; ok {
+		return stored.Count
+	}
+	return 0
+}
+
+func (s *fakeStore) Add(_ context.Context, counter Counter, limit int64) (bool, error) {
+	if limit >= 0 && s.count(&counter)+counter.Count > limit {
+		return false, nil
+	}
+	s.add(&counter)
+	return true, nil
+}
+
+func (s *fakeStore) Counters(_ context.Context, userEmail string, quota string, from time.Time, to time.Time) ([]Counter, error) {
+	var counters []Counter
+	for _, stored := range s.counters {
+		if stored.UserEmail == strings.ToLower(userEmail) && stored.Window >= windowKey(quota, from) && stored.Window <= windowKey(quota, to) {
+			counters = append(counters, *stored)
+		}
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		return counters[i].Window < counters[j].Window
+	})
+	return counters, nil
+}
+
+// fakeIdempotencyStore implements only the idempotency.Store methods used by Checker
+type fakeIdempotencyStore struct {
+	idempotency.Store
+	records map[string]idempotency.Status
+}
+
+func newFakeIdempotencyStore() *fakeIdempotencyStore {
+	return &fakeIdempotencyStore{records: map[string]idempotency.Status{}}
+}
+
+func (s *fakeIdempotencyStore) GetRecord(_ context.Context, recordID string) (*idempotency.Record, error) {
+	status, ok := s.records[recordID]
+	if !ok {
+		return nil, nil
+	}
+	return idempotency.NewRecord(recordID, status), nil
+}
+
+func (s *fakeIdempotencyStore) CountByStatus(_ context.Context, status idempotency.Status) (int, error) {
+	var count int
+	for _, s := range s.records {
+		if s == status {
+			count++
+		}
+	}
+	return count, nil
+}
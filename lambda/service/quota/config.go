@@ -0,0 +1,125 @@
+package quota
+
+import (
+	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/discover"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"os"
+	"strings"
+	"time"
+)
+
+const UserMaxRequestsKey = "QUOTA_USER_MAX_REQUESTS"
+const UserRequestsWindowHoursKey = "QUOTA_USER_REQUESTS_WINDOW_HOURS"
+const UserMaxBytesKey = "QUOTA_USER_MAX_BYTES"
+const UserBytesWindowHoursKey = "QUOTA_USER_BYTES_WINDOW_HOURS"
+const MaxActiveKey = "QUOTA_MAX_ACTIVE_REHYDRATIONS"
+const ActiveRetryAfterMinutesKey = "QUOTA_ACTIVE_RETRY_AFTER_MINUTES"
+
+// ExemptKey is a comma separated list of email addresses that are not subject to quotas. An entry starting with
+// '@', for example @pennsieve.org, exempts every address in that domain.
+const ExemptKey = "QUOTA_EXEMPT_EMAILS"
+
+const DefaultWindowHours = 24
+const DefaultActiveRetryAfterMinutes = 15
+
+type Config struct {
+	TableName string
+	// MaxRequests is the number of requests a user can make in RequestsWindow. Zero means no limit.
+	MaxRequests    int
+	RequestsWindow time.Duration
+	// MaxBytes is the total size of the new rehydrations a user can start in BytesWindow. Zero means no limit.
+	MaxBytes    int64
+	BytesWindow time.Duration
+	// MaxActive is the number of queued or in progress rehydrations at or above which no new ones will be
+	// accepted from anyone. Zero means no limit.
+	MaxActive int
+	// ActiveRetryAfter is suggested to clients rejected because of MaxActive, since there is no way to know when a
+	// running rehydration will finish.
+	ActiveRetryAfter time.Duration
+	// Exempt has lower-cased email addresses and '@' prefixed domains that are not subject to quotas
+	Exempt []string
+	// PennsieveHost is used to look up dataset sizes in Discover if MaxBytes is set
+	PennsieveHost string
+}
+
+// ConfigFromEnvironment returns nil if none of UserMaxRequestsKey, UserMaxBytesKey, or MaxActiveKey is set, meaning that
+// no quotas should be enforced.
+func ConfigFromEnvironment() (*Config, error) {
+	config := &Config{}
+	var enabled bool
+	var err error
+	if _, set := os.LookupEnv(UserMaxRequestsKey); set {
+		if config.MaxRequests, err = shared.IntFromEnvVar(UserMaxRequestsKey); err != nil {
+			return nil, err
+		}
+		if config.RequestsWindow, err = hoursFromEnvVar(UserRequestsWindowHoursKey, DefaultWindowHours); err != nil {
+			return nil, err
+		}
+		enabled = true
+	}
+	if _, set := os.LookupEnv(UserMaxBytesKey); set {
+		if config.MaxBytes, err = shared.Int64FromEnvVar(UserMaxBytesKey); err != nil {
+			return nil, err
+		}
+		if config.BytesWindow, err = hoursFromEnvVar(UserBytesWindowHoursKey, DefaultWindowHours); err != nil {
+			return nil, err
+		}
+		env, err := shared.NonEmptyFromEnvVar(sharedmodels.ECSTaskEnvKey)
+		if err != nil {
+			return nil, err
+		}
+		config.PennsieveHost = discover.APIHost(env)
+		enabled = true
+	}
+	if _, set := os.LookupEnv(MaxActiveKey); set {
+		if config.MaxActive, err = shared.IntFromEnvVar(MaxActiveKey); err != nil {
+			return nil, err
+		}
+		retryAfterMinutes := DefaultActiveRetryAfterMinutes
+		if _, set := os.LookupEnv(ActiveRetryAfterMinutesKey); set {
+			if retryAfterMinutes, err = shared.IntFromEnvVar(ActiveRetryAfterMinutesKey); err != nil {
+				return nil, err
+			}
+		}
+		config.ActiveRetryAfter = time.Duration(retryAfterMinutes) * time.Minute
+		enabled = true
+	}
+	if !enabled {
+		return nil, nil
+	}
+	if config.TableName, err = shared.NonEmptyFromEnvVar(TableNameKey); err != nil {
+		return nil, err
+	}
+	for _, exempt := range strings.Split(os.Getenv(ExemptKey), ",") {
+		if exempt = strings.ToLower(strings.TrimSpace(exempt)); len(exempt) > 0 {
+			config.Exempt = append(config.Exempt, exempt)
+		}
+	}
+	return config, nil
+}
+
+// IsExempt returns true if the given email address or its domain is in Exempt
+func (c *Config) IsExempt(email string) bool {
+	email = strings.ToLower(email)
+	for _, exempt := range c.Exempt {
+		if strings.HasPrefix(exempt, "@") && strings.HasSuffix(email, exempt) {
+			return true
+		}
+		if email == exempt {
+			return true
+		}
+	}
+	return false
+}
+
+func hoursFromEnvVar(key string, defaultHours int) (time.Duration, error) {
+	hours := defaultHours
+	if _, set := os.LookupEnv(key); set {
+		var err error
+		if hours, err = shared.IntFromEnvVar(key); err != nil {
+			return 0, err
+		}
+	}
+	return time.Duration(hours) * time.Hour, nil
+}
@@ -0,0 +1,105 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const TableNameKey = "QUOTA_DYNAMODB_TABLE_NAME"
+
+type DyDBStore struct {
+	client *dynamodb.Client
+	table  string
+	logger *slog.Logger
+}
+
+func NewStore(client *dynamodb.Client, logger *slog.Logger, tableName string) Store {
+	return &DyDBStore{
+		client: client,
+		table:  tableName,
+		logger: logger,
+	}
+}
+
+// Add is a single conditional UpdateItem, so concurrent requests cannot all see room under the limit and all be
+// counted.
+func (s *DyDBStore) Add(ctx context.Context, counter Counter, limit int64) (bool, error) {
+	if limit >= 0 && counter.Count > limit {
+		return false, nil
+	}
+	countName := expression.Name(CountAttrName)
+	builder := expression.NewBuilder().WithUpdate(
+		expression.Add(countName, expression.Value(counter.Count)).
+			Set(expression.Name(ExpiresAtAttrName), expression.Value(counter.ExpiresAt)))
+	if limit >= 0 {
+		builder = builder.WithCondition(expression.Or(
+			expression.AttributeNotExists(countName),
+			countName.LessThanEqual(expression.Value(limit-counter.Count))))
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return false, fmt.Errorf("error building Add expression: %w", err)
+	}
+	in := &dynamodb.UpdateItemInput{
+		Key:                       counter.Key(),
+		TableName:                 aws.String(s.table),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+	}
+	if _, err := s.client.UpdateItem(ctx, in); err != nil {
+		var conditionFailedError *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailedError) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error adding %d to %s counter %s in %s: %w", counter.Count, counter.UserEmail, counter.Window, s.table, err)
+	}
+	return true, nil
+}
+
+// Counters queries on the table's key. Window sort keys compare in time order because Unix seconds have the same
+// number of digits until the year 2286.
+func (s *DyDBStore) Counters(ctx context.Context, userEmail string, quota string, from time.Time, to time.Time) ([]Counter, error) {
+	keyConditionBuilder := expression.KeyAnd(
+		expression.Key(UserEmailAttrName).Equal(expression.Value(strings.ToLower(userEmail))),
+		expression.Key(WindowAttrName).Between(expression.Value(windowKey(quota, from)), expression.Value(windowKey(quota, to))))
+	queryExpression, err := expression.NewBuilder().WithKeyCondition(keyConditionBuilder).Build()
+	if err != nil {
+		return nil, fmt.Errorf("error building Counters expression: %w", err)
+	}
+	queryIn := &dynamodb.QueryInput{
+		TableName:                 aws.String(s.table),
+		ExpressionAttributeNames:  queryExpression.Names(),
+		ExpressionAttributeValues: queryExpression.Values(),
+		KeyConditionExpression:    queryExpression.KeyCondition(),
+		ConsistentRead:            aws.Bool(true),
+	}
+	var counters []Counter
+	var errs []error
+	var lastEvaluatedKey map[string]types.AttributeValue
+	for runQuery := true; runQuery; runQuery = len(lastEvaluatedKey) != 0 {
+		queryIn.ExclusiveStartKey = lastEvaluatedKey
+		queryOut, err := s.client.Query(ctx, queryIn)
+		if err != nil {
+			return nil, fmt.Errorf("error querying %s counters of %s in %s: %w", quota, userEmail, s.table, err)
+		}
+		lastEvaluatedKey = queryOut.LastEvaluatedKey
+		for _, item := range queryOut.Items {
+			if counter, err := FromItem(item); err == nil {
+				counters = append(counters, *counter)
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return counters, errors.Join(errs...)
+}
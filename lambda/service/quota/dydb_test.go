@@ -0,0 +1,90 @@
+package quota_test
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/pennsieve/rehydration-service/service/quota"
+	"github.com/pennsieve/rehydration-service/service/quota/quotatest"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testTableName = "test-rehydration-quota-table"
+
+func TestDyDBStore_Add(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	store := quota.NewStore(dynamodb.NewFromConfig(awsConfig), logging.Default, testTableName)
+
+	dyDB := test.NewDynamoDBFixture(t, awsConfig, quotatest.CreateTableInput(testTableName))
+	defer dyDB.Teardown()
+
+	windowStart := time.Now().Truncate(time.Hour)
+	counter := quota.NewCounter("Last@Example.com", quota.RequestsQuota, windowStart, time.Hour, 1)
+	assert.Equal(t, "last@example.com", counter.UserEmail)
+
+	// concurrent requests cannot go over the limit
+	var wg sync.WaitGroup
+	added := make([]bool, 5)
+	for i := range added {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			added[i], err = store.Add(ctx, *counter, 3)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	var addedCount int
+	for _, a := range added {
+		if a {
+			addedCount++
+		}
+	}
+	assert.Equal(t, 3, addedCount)
+
+	// no limit
+	counter.Count = -1
+	ok, err := store.Add(ctx, *counter, -1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	items := dyDB.Scan(ctx, testTableName)
+	require.Len(t, items, 1)
+	stored, err := quota.FromItem(items[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stored.Count)
+	assert.Equal(t, windowStart.Add(time.Hour).Unix(), stored.ExpiresAt)
+}
+
+func TestDyDBStore_Counters(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	store := quota.NewStore(dynamodb.NewFromConfig(awsConfig), logging.Default, testTableName)
+
+	dyDB := test.NewDynamoDBFixture(t, awsConfig, quotatest.CreateTableInput(testTableName))
+	defer dyDB.Teardown()
+
+	now := time.Now().Truncate(time.Hour)
+	email := "last@example.com"
+	tooOld := quota.NewCounter(email, quota.RequestsQuota, now.Add(-24*time.Hour), 24*time.Hour, 5)
+	oldest := quota.NewCounter(email, quota.RequestsQuota, now.Add(-23*time.Hour), 24*time.Hour, 1)
+	current := quota.NewCounter(email, quota.RequestsQuota, now, 24*time.Hour, 2)
+	otherQuota := quota.NewCounter(email, quota.BytesQuota, now, 24*time.Hour, 100)
+	otherUser := quota.NewCounter("other@example.com", quota.RequestsQuota, now, 24*time.Hour, 3)
+	for _, counter := range []*quota.Counter{current, tooOld, oldest, otherQuota, otherUser} {
+		ok, err := store.Add(ctx, *counter, -1)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	counters, err := store.Counters(ctx, "Last@Example.com", quota.RequestsQuota, now.Add(-23*time.Hour), now)
+	require.NoError(t, err)
+	assert.Equal(t, []quota.Counter{*oldest, *current}, counters)
+}
@@ -0,0 +1,15 @@
+package quota
+
+import (
+	"context"
+	"time"
+)
+
+type Store interface {
+	// Add adds counter.Count to the stored count of the counter, starting from zero if there is none yet, unless the
+	// result would be larger than limit. In that case it returns false and leaves the stored count unchanged.
+	// A negative limit means there is no limit.
+	Add(ctx context.Context, counter Counter, limit int64) (bool, error)
+	// Counters returns userEmail's counters for quota whose windows start from from to to, inclusive, oldest first
+	Counters(ctx context.Context, userEmail string, quota string, from time.Time, to time.Time) ([]Counter, error)
+}
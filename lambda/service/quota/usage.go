@@ -0,0 +1,69 @@
+package quota
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pennsieve/rehydration-service/shared/dydbutils"
+	"strings"
+	"time"
+)
+
+// UserEmailAttrName and other attribute name constants below should match the values in the dynamodbav struct tags in
+// Counter.
+const UserEmailAttrName = "userEmail"
+const WindowAttrName = "window"
+const CountAttrName = "count"
+const ExpiresAtAttrName = "expiresAt"
+
+// RequestsQuota and BytesQuota are the per-user quotas that are counted
+const RequestsQuota = "requests"
+const BytesQuota = "bytes"
+
+// BucketsPerWindow is the number of counters a rolling quota window is split into. A request is counted in the bucket
+// it falls in, and the bucket counts toward the quota until a whole window has passed since the bucket started.
+const BucketsPerWindow = 24
+
+// Counter is how much of one of a user's quotas has been used in one bucket of a rolling window.
+type Counter struct {
+	// UserEmail is lower-cased so that differently capitalized addresses share the same counters
+	UserEmail string `dynamodbav:"userEmail"`
+	// Window is the table's sort key. It is the quota name and the start of the bucket in Unix seconds, for example
+	// requests#1714521600.
+	Window string `dynamodbav:"window"`
+	Count  int64  `dynamodbav:"count"`
+	// ExpiresAt is when the bucket stops counting toward the quota, a window after it started, as a Unix timestamp.
+	// It is used as the table's TTL attribute, so that DynamoDB removes counters that no longer count.
+	ExpiresAt int64 `dynamodbav:"expiresAt"`
+}
+
+func NewCounter(userEmail string, quota string, bucketStart time.Time, window time.Duration, count int64) *Counter {
+	return &Counter{
+		UserEmail: strings.ToLower(userEmail),
+		Window:    windowKey(quota, bucketStart),
+		Count:     count,
+		ExpiresAt: bucketStart.Add(window).Unix(),
+	}
+}
+
+func windowKey(quota string, bucketStart time.Time) string {
+	return fmt.Sprintf("%s#%d", quota, bucketStart.Unix())
+}
+
+func (c *Counter) Key() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		UserEmailAttrName: dydbutils.StringAttributeValue(c.UserEmail),
+		WindowAttrName:    dydbutils.StringAttributeValue(c.Window),
+	}
+}
+
+func (c *Counter) Item() (map[string]types.AttributeValue, error) {
+	return dydbutils.ItemImpl(c)
+}
+
+var FromItem = dydbutils.FromItem[Counter]
+
+// Usage is what Checker.Check added to a user's counters for an accepted request
+type Usage struct {
+	// Added has the counters that were added to, with the amount added as their Count
+	Added []Counter
+}
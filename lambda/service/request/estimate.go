@@ -0,0 +1,41 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+)
+
+// EstimateRequest asks for the size and cost of rehydrating a dataset version without starting a rehydration.
+type EstimateRequest struct {
+	Dataset   sharedmodels.Dataset
+	Principal *Principal
+	Logger    *slog.Logger
+}
+
+// NewEstimateRequest takes the same body as a rehydration request, but only the dataset is used.
+func NewEstimateRequest(lambdaRequest events.APIGatewayV2HTTPRequest, authConfig *AuthConfig) (*EstimateRequest, error) {
+	logging.Default.Info("handling estimate request", slog.String("body", lambdaRequest.Body))
+	var dataset sharedmodels.Dataset
+	if err := json.Unmarshal([]byte(lambdaRequest.Body), &dataset); err != nil {
+		return nil, &BadRequestError{fmt.Sprintf("error unmarshalling request body [%s]: %v", lambdaRequest.Body, err)}
+	}
+	principal, err := PrincipalFromRequest(lambdaRequest, authConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDataset(dataset); err != nil {
+		return nil, err
+	}
+	requestLogger := logging.Default.With(slog.String("awsRequestID", lambdaRequest.RequestContext.RequestID),
+		slog.Group("dataset", slog.Int("id", dataset.ID), slog.Int("versionId", dataset.VersionID)),
+		slog.String("principal", principal.String()))
+	return &EstimateRequest{
+		Dataset:   dataset,
+		Principal: principal,
+		Logger:    requestLogger,
+	}, nil
+}
@@ -0,0 +1,196 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ServiceAccountARNsKey is a comma separated list of IAM ARNs of trusted internal callers. An entry also matches any
+// ARN that continues with '/', so that an assumed role ARN without a session name matches every session.
+const ServiceAccountARNsKey = "SERVICE_ACCOUNT_ARNS"
+
+// ServiceAccountClientIDsKey is a comma separated list of Cognito app client IDs of trusted internal callers using
+// client credentials tokens.
+const ServiceAccountClientIDsKey = "SERVICE_ACCOUNT_CLIENT_IDS"
+
+// ServiceAccountScopesKey is a comma separated list of OAuth scopes that make any token granted one of them a trusted
+// internal caller.
+const ServiceAccountScopesKey = "SERVICE_ACCOUNT_SCOPES"
+
+// ExternalDestinationRolesKey is a JSON object from principal to the role ARNs that principal may ask the task to assume
+// to write to a requester-owned bucket. Users are keyed by email address and service accounts by an entry of
+// ServiceAccountARNsKey or ServiceAccountClientIDsKey, for example
+// {"last@example.com": ["arn:aws:iam::123456789012:role/pennsieve-rehydration"]}.
+const ExternalDestinationRolesKey = "EXTERNAL_DESTINATION_ROLES"
+
+// Claim names looked up in JWT authorizer claims and Lambda authorizer context
+const (
+	SubjectClaim       = "sub"
+	EmailClaim         = "email"
+	EmailVerifiedClaim = "email_verified"
+	NameClaim          = "name"
+	GivenNameClaim     = "given_name"
+	FamilyNameClaim    = "family_name"
+	ClientIDClaim      = "client_id"
+	ScopeClaim         = "scope"
+)
+
+type AuthConfig struct {
+	ServiceAccountARNs      []string
+	ServiceAccountClientIDs []string
+	ServiceAccountScopes    []string
+	// ExternalDestinationRoles has the role ARNs each principal may use for a requester-owned bucket
+	ExternalDestinationRoles map[string][]string
+}
+
+func AuthConfigFromEnvironment() (*AuthConfig, error) {
+	config := &AuthConfig{
+		ServiceAccountARNs:      listFromEnvVar(ServiceAccountARNsKey),
+		ServiceAccountClientIDs: listFromEnvVar(ServiceAccountClientIDsKey),
+		ServiceAccountScopes:    listFromEnvVar(ServiceAccountScopesKey),
+	}
+	if roles := os.Getenv(ExternalDestinationRolesKey); len(roles) > 0 {
+		if err := json.Unmarshal([]byte(roles), &config.ExternalDestinationRoles); err != nil {
+			return nil, fmt.Errorf("error unmarshalling %s value [%s]: %w", ExternalDestinationRolesKey, roles, err)
+		}
+	}
+	return config, nil
+}
+
+// mayAssumeRole returns true if principal has roleARN in ExternalDestinationRoles
+func (c *AuthConfig) mayAssumeRole(principal *Principal, roleARN string) bool {
+	for key, roleARNs := range c.ExternalDestinationRoles {
+		var matches bool
+		if principal.ServiceAccount {
+			matches = principal.ID == key || strings.HasPrefix(principal.ID, key+"/")
+		} else {
+			matches = strings.EqualFold(principal.Email, key)
+		}
+		if matches && slices.Contains(roleARNs, roleARN) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *AuthConfig) isServiceAccountARN(arn string) bool {
+	for _, trusted := range c.ServiceAccountARNs {
+		if arn == trusted || strings.HasPrefix(arn, trusted+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isServiceAccountToken is true if the token's client ID, or one of its space separated scopes, is trusted
+func (c *AuthConfig) isServiceAccountToken(clientID string, scope string) bool {
+	if len(clientID) > 0 && slices.Contains(c.ServiceAccountClientIDs, clientID) {
+		return true
+	}
+	for _, s := range strings.Fields(scope) {
+		if slices.Contains(c.ServiceAccountScopes, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal is the authenticated caller of the service.
+type Principal struct {
+	// ID is the Cognito subject for users, and the IAM ARN or app client ID for service accounts
+	ID string
+	// Email and Name are empty for service accounts, which make requests on behalf of the user in the request body.
+	Email string
+	Name  string
+	// ServiceAccount is true for trusted internal callers
+	ServiceAccount bool
+}
+
+func (p *Principal) String() string {
+	if p.ServiceAccount {
+		return fmt.Sprintf("service:%s", p.ID)
+	}
+	return fmt.Sprintf("user:%s", p.ID)
+}
+
+type UnauthorizedError struct {
+	message string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.message
+}
+
+type ForbiddenError struct {
+	message string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.message
+}
+
+// PrincipalFromRequest returns the caller identified by the API Gateway authorizer, or an *UnauthorizedError if there
+// is none.
+func PrincipalFromRequest(lambdaRequest events.APIGatewayV2HTTPRequest, config *AuthConfig) (*Principal, error) {
+	authorizer := lambdaRequest.RequestContext.Authorizer
+	if authorizer == nil {
+		return nil, &UnauthorizedError{"request was not authorized"}
+	}
+	if authorizer.IAM != nil && len(authorizer.IAM.UserARN) > 0 {
+		if config.isServiceAccountARN(authorizer.IAM.UserARN) {
+			return &Principal{ID: authorizer.IAM.UserARN, ServiceAccount: true}, nil
+		}
+		return nil, &UnauthorizedError{fmt.Sprintf("IAM caller %s is not a service account", authorizer.IAM.UserARN)}
+	}
+	if authorizer.JWT != nil && len(authorizer.JWT.Claims) > 0 {
+		return principalFromClaims(authorizer.JWT.Claims, config)
+	}
+	if len(authorizer.Lambda) > 0 {
+		claims := map[string]string{}
+		for k, v := range authorizer.Lambda {
+			if s, isString := v.(string); isString {
+				claims[k] = s
+			}
+		}
+		return principalFromClaims(claims, config)
+	}
+	return nil, &UnauthorizedError{"request was not authorized"}
+}
+
+// principalFromClaims only treats tokens whose client ID or scopes are in config as service accounts. Any other token,
+// whether or not it has a client ID, has to identify a user.
+func principalFromClaims(claims map[string]string, config *AuthConfig) (*Principal, error) {
+	if clientID := claims[ClientIDClaim]; config.isServiceAccountToken(clientID, claims[ScopeClaim]) {
+		id := clientID
+		if len(id) == 0 {
+			id = claims[SubjectClaim]
+		}
+		return &Principal{ID: id, ServiceAccount: true}, nil
+	}
+	subject, email := claims[SubjectClaim], claims[EmailClaim]
+	if len(subject) == 0 || len(email) == 0 {
+		return nil, &UnauthorizedError{fmt.Sprintf("authorizer claims are missing %q or %q", SubjectClaim, EmailClaim)}
+	}
+	if strings.EqualFold(claims[EmailVerifiedClaim], "false") {
+		return nil, &ForbiddenError{fmt.Sprintf("email address %s has not been verified", email)}
+	}
+	name := claims[NameClaim]
+	if len(name) == 0 {
+		name = strings.TrimSpace(claims[GivenNameClaim] + " " + claims[FamilyNameClaim])
+	}
+	return &Principal{ID: subject, Email: email, Name: name}, nil
+}
+
+func listFromEnvVar(key string) []string {
+	var list []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			list = append(list, value)
+		}
+	}
+	return list
+}
@@ -0,0 +1,153 @@
+package request
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/service/queue"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/regions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var testAuthConfig = &AuthConfig{
+	ServiceAccountARNs:      []string{"arn:aws:sts::123456789012:assumed-role/discover-service"},
+	ServiceAccountClientIDs: []string{"internal-client"},
+	ServiceAccountScopes:    []string{"rehydration/service"},
+	ExternalDestinationRoles: map[string][]string{
+		"last@example.com": {"arn:aws:iam::123456789012:role/rehydration-writer"},
+		"arn:aws:sts::123456789012:assumed-role/discover-service": {"arn:aws:iam::210987654321:role/discover-writer"},
+	},
+}
+
+var testBuckets = regions.NewBuckets("us-east-1", map[string]string{"eu-west-1": "rehydration-eu"})
+
+func TestPrincipalFromRequest(t *testing.T) {
+	for name, params := range map[string]struct {
+		authorizer        *events.APIGatewayV2HTTPRequestContextAuthorizerDescription
+		expectedPrincipal *Principal
+		expectedError     error
+	}{
+		"no authorizer": {nil, nil, &UnauthorizedError{}},
+		"empty lambda authorizer": {
+			&events.APIGatewayV2HTTPRequestContextAuthorizerDescription{Lambda: map[string]any{}},
+			nil,
+			&UnauthorizedError{},
+		},
+		"jwt user": {
+			jwtAuthorizer(map[string]string{"sub": "abc", "email": "last@example.com", "given_name": "First", "family_name": "Last"}),
+			&Principal{ID: "abc", Email: "last@example.com", Name: "First Last"},
+			nil,
+		},
+		"jwt unverified email": {
+			jwtAuthorizer(map[string]string{"sub": "abc", "email": "last@example.com", "email_verified": "false"}),
+			nil,
+			&ForbiddenError{},
+		},
+		"jwt missing email": {
+			jwtAuthorizer(map[string]string{"sub": "abc"}),
+			nil,
+			&UnauthorizedError{},
+		},
+		"jwt service account": {
+			jwtAuthorizer(map[string]string{"sub": "internal-client", "client_id": "internal-client"}),
+			&Principal{ID: "internal-client", ServiceAccount: true},
+			nil,
+		},
+		"jwt service account scope": {
+			jwtAuthorizer(map[string]string{"sub": "scoped-client", "client_id": "scoped-client", "scope": "rehydration/read rehydration/service"}),
+			&Principal{ID: "scoped-client", ServiceAccount: true},
+			nil,
+		},
+		"jwt trusted client with email": {
+			jwtAuthorizer(map[string]string{"sub": "abc", "client_id": "internal-client", "email": "last@example.com"}),
+			&Principal{ID: "internal-client", ServiceAccount: true},
+			nil,
+		},
+		"jwt untrusted client": {
+			jwtAuthorizer(map[string]string{"sub": "other-client", "client_id": "other-client", "scope": "rehydration/read"}),
+			nil,
+			&UnauthorizedError{},
+		},
+		"jwt user access token": {
+			jwtAuthorizer(map[string]string{"sub": "abc", "client_id": "web-client", "email": "last@example.com"}),
+			&Principal{ID: "abc", Email: "last@example.com"},
+			nil,
+		},
+		"lambda user": {
+			&events.APIGatewayV2HTTPRequestContextAuthorizerDescription{Lambda: map[string]any{"sub": "abc", "email": "last@example.com", "name": "First Last", "other": 3}},
+			&Principal{ID: "abc", Email: "last@example.com", Name: "First Last"},
+			nil,
+		},
+		"iam service account": {
+			iamAuthorizer("arn:aws:sts::123456789012:assumed-role/discover-service/session-1"),
+			&Principal{ID: "arn:aws:sts::123456789012:assumed-role/discover-service/session-1", ServiceAccount: true},
+			nil,
+		},
+		"iam untrusted": {
+			iamAuthorizer("arn:aws:sts::123456789012:assumed-role/discover-service-other/session-1"),
+			nil,
+			&UnauthorizedError{},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			lambdaRequest := events.APIGatewayV2HTTPRequest{RequestContext: events.APIGatewayV2HTTPRequestContext{Authorizer: params.authorizer}}
+			principal, err := PrincipalFromRequest(lambdaRequest, testAuthConfig)
+			if params.expectedError == nil {
+				require.NoError(t, err)
+				assert.Equal(t, params.expectedPrincipal, principal)
+			} else {
+				assert.IsType(t, params.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestNewRehydrationRequest_Principal(t *testing.T) {
+	claims := map[string]string{"sub": "abc", "email": "last@example.com", "name": "First Last"}
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+
+	// the user can be left out of the body
+	rehydrationRequest, err := NewRehydrationRequest(newTestLambdaRequest(t, models.Request{Dataset: dataset}, jwtAuthorizer(claims)), 14, testAuthConfig, testBuckets)
+	require.NoError(t, err)
+	assert.Equal(t, sharedmodels.User{Name: "First Last", Email: "last@example.com"}, rehydrationRequest.User)
+	assert.Equal(t, "user:abc", rehydrationRequest.trackingEntry.Principal)
+
+	// but has to match if present
+	_, err = NewRehydrationRequest(newTestLambdaRequest(t, models.Request{Dataset: dataset, User: sharedmodels.User{Name: "Someone", Email: "someone@example.com"}}, jwtAuthorizer(claims)), 14, testAuthConfig, testBuckets)
+	assert.IsType(t, &ForbiddenError{}, err)
+
+	// users cannot jump the queue
+	_, err = NewRehydrationRequest(newTestLambdaRequest(t, models.Request{Dataset: dataset, Priority: "High"}, jwtAuthorizer(claims)), 14, testAuthConfig, testBuckets)
+	assert.IsType(t, &ForbiddenError{}, err)
+	rehydrationRequest, err = NewRehydrationRequest(newTestLambdaRequest(t, models.Request{Dataset: dataset, Priority: "low"}, jwtAuthorizer(claims)), 14, testAuthConfig, testBuckets)
+	require.NoError(t, err)
+	assert.Equal(t, queue.Low, rehydrationRequest.Priority)
+
+	// service accounts can request for anyone, at any priority
+	user := sharedmodels.User{Name: "Someone", Email: "someone@example.com"}
+	rehydrationRequest, err = NewRehydrationRequest(newTestLambdaRequest(t, models.Request{Dataset: dataset, User: user, Priority: "high"}, iamAuthorizer("arn:aws:sts::123456789012:assumed-role/discover-service/session-1")), 14, testAuthConfig, testBuckets)
+	require.NoError(t, err)
+	assert.Equal(t, user, rehydrationRequest.User)
+	assert.Equal(t, queue.High, rehydrationRequest.Priority)
+	assert.Equal(t, "service:arn:aws:sts::123456789012:assumed-role/discover-service/session-1", rehydrationRequest.trackingEntry.Principal)
+}
+
+func jwtAuthorizer(claims map[string]string) *events.APIGatewayV2HTTPRequestContextAuthorizerDescription {
+	return &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{Claims: claims}}
+}
+
+func iamAuthorizer(userARN string) *events.APIGatewayV2HTTPRequestContextAuthorizerDescription {
+	return &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{IAM: &events.APIGatewayV2HTTPRequestContextAuthorizerIAMDescription{UserARN: userARN}}
+}
+
+func newTestLambdaRequest(t *testing.T, request models.Request, authorizer *events.APIGatewayV2HTTPRequestContextAuthorizerDescription) events.APIGatewayV2HTTPRequest {
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+	return events.APIGatewayV2HTTPRequest{
+		Body:           string(body),
+		RequestContext: events.APIGatewayV2HTTPRequestContext{Authorizer: authorizer},
+	}
+}
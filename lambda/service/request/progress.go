@@ -0,0 +1,63 @@
+package request
+
+import (
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+	"strconv"
+)
+
+// DatasetIDParameter and DatasetVersionIDParameter are the query parameters of a progress request
+const DatasetIDParameter = "datasetId"
+const DatasetVersionIDParameter = "datasetVersionId"
+
+// ProgressRequest asks how far along the rehydration of a dataset version is.
+type ProgressRequest struct {
+	Dataset   sharedmodels.Dataset
+	Principal *Principal
+	Logger    *slog.Logger
+}
+
+// NewProgressRequest takes the dataset version from the DatasetIDParameter and DatasetVersionIDParameter query
+// parameters since progress requests have no body.
+func NewProgressRequest(lambdaRequest events.APIGatewayV2HTTPRequest, authConfig *AuthConfig) (*ProgressRequest, error) {
+	logging.Default.Info("handling progress request", slog.Any("queryStringParameters", lambdaRequest.QueryStringParameters))
+	var dataset sharedmodels.Dataset
+	var err error
+	if dataset.ID, err = intParameter(lambdaRequest, DatasetIDParameter); err != nil {
+		return nil, err
+	}
+	if dataset.VersionID, err = intParameter(lambdaRequest, DatasetVersionIDParameter); err != nil {
+		return nil, err
+	}
+	principal, err := PrincipalFromRequest(lambdaRequest, authConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDataset(dataset); err != nil {
+		return nil, err
+	}
+	requestLogger := logging.Default.With(slog.String("awsRequestID", lambdaRequest.RequestContext.RequestID),
+		slog.Group("dataset", slog.Int("id", dataset.ID), slog.Int("versionId", dataset.VersionID)),
+		slog.String("principal", principal.String()))
+	return &ProgressRequest{
+		Dataset:   dataset,
+		Principal: principal,
+		Logger:    requestLogger,
+	}, nil
+}
+
+// intParameter returns 0 if the parameter is missing so that validateDataset reports it
+func intParameter(lambdaRequest events.APIGatewayV2HTTPRequest, name string) (int, error) {
+	value, ok := lambdaRequest.QueryStringParameters[name]
+	if !ok {
+		return 0, nil
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &BadRequestError{fmt.Sprintf("query parameter %q value [%s] is not an integer", name, value)}
+	}
+	return intValue, nil
+}
@@ -0,0 +1,38 @@
+package request
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewProgressRequest(t *testing.T) {
+	authorizer := jwtAuthorizer(map[string]string{"sub": "abc", "email": "last@example.com", "given_name": "First", "family_name": "Last"})
+	for name, params := range map[string]struct {
+		parameters      map[string]string
+		expectedDataset sharedmodels.Dataset
+		expectedError   error
+	}{
+		"valid":              {map[string]string{DatasetIDParameter: "5065", DatasetVersionIDParameter: "2"}, sharedmodels.Dataset{ID: 5065, VersionID: 2}, nil},
+		"missing version":    {map[string]string{DatasetIDParameter: "5065"}, sharedmodels.Dataset{}, &BadRequestError{}},
+		"missing parameters": {nil, sharedmodels.Dataset{}, &BadRequestError{}},
+		"not an integer":     {map[string]string{DatasetIDParameter: "5065", DatasetVersionIDParameter: "latest"}, sharedmodels.Dataset{}, &BadRequestError{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			lambdaRequest := events.APIGatewayV2HTTPRequest{
+				QueryStringParameters: params.parameters,
+				RequestContext:        events.APIGatewayV2HTTPRequestContext{Authorizer: authorizer},
+			}
+			progressRequest, err := NewProgressRequest(lambdaRequest, testAuthConfig)
+			if params.expectedError != nil {
+				assert.IsType(t, params.expectedError, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, params.expectedDataset, progressRequest.Dataset)
+			assert.Equal(t, "abc", progressRequest.Principal.ID)
+		})
+	}
+}
@@ -8,20 +8,36 @@ import (
 	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/google/uuid"
 	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/service/queue"
+	"github.com/pennsieve/rehydration-service/shared/audit"
 	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/manifest"
 	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
 	"github.com/pennsieve/rehydration-service/shared/notification"
+	"github.com/pennsieve/rehydration-service/shared/regions"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
 	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"go.opentelemetry.io/otel/attribute"
 	"log/slog"
 	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type RehydrationRequest struct {
-	Dataset             sharedmodels.Dataset
-	User                sharedmodels.User
-	Logger              *slog.Logger
-	RehydrationTTLDays  int
+	Dataset  sharedmodels.Dataset
+	User     sharedmodels.User
+	Priority queue.Priority
+	// Destination is nil if the dataset should be rehydrated into the default region
+	Destination        *sharedmodels.Destination
+	Principal          *Principal
+	Logger             *slog.Logger
+	RehydrationTTLDays int
+	// Audit records the request's changes to idempotency records and tracking entries. May be nil if rehydrations
+	// are not audited.
+	Audit               *audit.Recorder
 	lambdaRequest       events.APIGatewayV2HTTPRequest
 	lambdaLogStreamName string
 	awsRequestID        string
@@ -37,13 +53,20 @@ func (e *BadRequestError) Error() string {
 	return e.message
 }
 
-func validateRequest(request models.Request) *BadRequestError {
-	if request.Dataset.ID == 0 {
+func validateDataset(dataset sharedmodels.Dataset) *BadRequestError {
+	if dataset.ID == 0 {
 		return &BadRequestError{`missing "datasetId"`}
 	}
-	if request.Dataset.VersionID == 0 {
+	if dataset.VersionID == 0 {
 		return &BadRequestError{`missing "datasetVersionId"`}
 	}
+	return nil
+}
+
+func validateRequest(request models.Request) *BadRequestError {
+	if err := validateDataset(request.Dataset); err != nil {
+		return err
+	}
 	if len(request.User.Name) == 0 {
 		return &BadRequestError{`missing User "name"`}
 	}
@@ -53,10 +76,79 @@ func validateRequest(request models.Request) *BadRequestError {
 	if _, err := mail.ParseAddress(request.User.Email); err != nil {
 		return &BadRequestError{message: fmt.Sprintf("invalid email address: %s: %v", request.User.Email, err)}
 	}
+	if _, err := queue.PriorityFromString(request.Priority); err != nil {
+		return &BadRequestError{message: fmt.Sprintf("invalid priority: %v", err)}
+	}
+	return nil
+}
+
+// roleARNPattern matches IAM role ARNs in any partition
+var roleARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/.+$`)
+
+// externalDestinationFromRequest validates the requester-owned bucket in request.Destination
+func externalDestinationFromRequest(request models.Request) (*sharedmodels.Destination, *BadRequestError) {
+	external := request.Destination
+	if len(request.Region) > 0 {
+		return nil, &BadRequestError{`"region" cannot be combined with "destination": set the bucket's region in "destination"`}
+	}
+	if len(external.Bucket) == 0 {
+		return nil, &BadRequestError{`missing destination "bucket"`}
+	}
+	if !roleARNPattern.MatchString(external.RoleARN) {
+		return nil, &BadRequestError{fmt.Sprintf("invalid destination roleArn: %q is not an IAM role ARN", external.RoleARN)}
+	}
+	prefix := strings.TrimPrefix(external.Prefix, "/")
+	if len(prefix) > 0 && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &sharedmodels.Destination{
+		Region:  external.Region,
+		Bucket:  external.Bucket,
+		Prefix:  prefix,
+		RoleARN: external.RoleARN,
+	}, nil
+}
+
+// destinationFromRequest returns the Destination for the requested region or external bucket, or nil if the request
+// is for the default region
+func destinationFromRequest(request models.Request, buckets *regions.Buckets) (*sharedmodels.Destination, *BadRequestError) {
+	if request.Destination != nil {
+		return externalDestinationFromRequest(request)
+	}
+	if len(request.Region) == 0 || request.Region == buckets.DefaultRegion {
+		return nil, nil
+	}
+	bucket, ok := buckets.Bucket(request.Region)
+	if !ok {
+		available := append([]string{buckets.DefaultRegion}, buckets.Regions()...)
+		return nil, &BadRequestError{message: fmt.Sprintf("unsupported region %s: must be one of %s", request.Region, strings.Join(available, ", "))}
+	}
+	return &sharedmodels.Destination{Region: request.Region, Bucket: bucket}, nil
+}
+
+// applyPrincipal makes sure that users can only make requests for themselves. The user in the body is optional for them,
+// and if present, its email must match the authenticated one. Service accounts make requests on behalf of the user in
+// the body. Only service accounts can ask for high priority.
+func applyPrincipal(request *models.Request, principal *Principal) error {
+	if principal.ServiceAccount {
+		return nil
+	}
+	if priority, err := queue.PriorityFromString(request.Priority); err == nil && priority == queue.High {
+		return &ForbiddenError{fmt.Sprintf("priority %s can only be requested by service accounts", request.Priority)}
+	}
+	if len(request.User.Email) > 0 && !strings.EqualFold(request.User.Email, principal.Email) {
+		return &ForbiddenError{fmt.Sprintf("request user email %s does not match authenticated email %s", request.User.Email, principal.Email)}
+	}
+	request.User.Email = principal.Email
+	if len(principal.Name) > 0 {
+		request.User.Name = principal.Name
+	}
 	return nil
 }
 
-func NewRehydrationRequest(lambdaRequest events.APIGatewayV2HTTPRequest, rehydrationTTLDays int) (*RehydrationRequest, error) {
+// NewRehydrationRequest validates the request in lambdaRequest. buckets are the regions the request may choose as a
+// destination.
+func NewRehydrationRequest(lambdaRequest events.APIGatewayV2HTTPRequest, rehydrationTTLDays int, authConfig *AuthConfig, buckets *regions.Buckets) (*RehydrationRequest, error) {
 	requestID := uuid.NewString()
 	awsRequestID := lambdaRequest.RequestContext.RequestID
 	lambdaLogStreamName := lambdacontext.LogStreamName
@@ -66,15 +158,38 @@ func NewRehydrationRequest(lambdaRequest events.APIGatewayV2HTTPRequest, rehydra
 	if err := json.Unmarshal([]byte(lambdaRequest.Body), &request); err != nil {
 		return nil, &BadRequestError{fmt.Sprintf("error unmarshalling request body [%s]: %v", lambdaRequest.Body, err)}
 	}
+	principal, err := PrincipalFromRequest(lambdaRequest, authConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPrincipal(&request, principal); err != nil {
+		return nil, err
+	}
 	if err := validateRequest(request); err != nil {
 		return nil, err
 	}
+	destination, badRequest := destinationFromRequest(request, buckets)
+	if badRequest != nil {
+		return nil, badRequest
+	}
+	if destination.External() && !authConfig.mayAssumeRole(principal, destination.RoleARN) {
+		return nil, &ForbiddenError{fmt.Sprintf("%s may not use destination role %s", principal, destination.RoleARN)}
+	}
 	dataset, user := request.Dataset, request.User
+	// already validated
+	priority, _ := queue.PriorityFromString(request.Priority)
 
 	requestLogger := logging.Default.With(slog.String("awsRequestID", awsRequestID),
 		slog.String("requestID", requestID),
 		slog.Group("dataset", slog.Int("id", dataset.ID), slog.Int("versionId", dataset.VersionID)),
-		slog.Group("user", slog.String("name", user.Name), slog.String("email", user.Email)))
+		slog.Group("user", slog.String("name", user.Name), slog.String("email", user.Email)),
+		slog.String("principal", principal.String()))
+	if destination.External() {
+		requestLogger = requestLogger.With(slog.String("externalDestination", destination.Location()))
+	}
+	if destination != nil && len(destination.Region) > 0 {
+		requestLogger = requestLogger.With(slog.String("destinationRegion", destination.Region))
+	}
 
 	trackingEntry := &tracking.Entry{
 		DatasetVersionIndex: tracking.DatasetVersionIndex{
@@ -86,11 +201,18 @@ func NewRehydrationRequest(lambdaRequest events.APIGatewayV2HTTPRequest, rehydra
 		LambdaLogStream: lambdaLogStreamName,
 		AWSRequestID:    awsRequestID,
 		RequestDate:     time.Now(),
+		Principal:       principal.String(),
+	}
+	if destination.External() {
+		trackingEntry.Destination = destination.Location()
 	}
 
 	return &RehydrationRequest{
 		Dataset:             dataset,
 		User:                user,
+		Priority:            priority,
+		Destination:         destination,
+		Principal:           principal,
 		Logger:              requestLogger,
 		lambdaRequest:       lambdaRequest,
 		lambdaLogStreamName: lambdaLogStreamName,
@@ -101,6 +223,19 @@ func NewRehydrationRequest(lambdaRequest events.APIGatewayV2HTTPRequest, rehydra
 	}, nil
 }
 
+// RequestID is the ID of the request's tracking entry
+func (r *RehydrationRequest) RequestID() string {
+	return r.requestID
+}
+
+// DestinationRegion returns the region of Destination, or an empty string if the request is for the default region
+func (r *RehydrationRequest) DestinationRegion() string {
+	if r.Destination == nil {
+		return ""
+	}
+	return r.Destination.Region
+}
+
 func (r *RehydrationRequest) WriteNewUnknownRequest(ctx context.Context, trackingStore tracking.Store) {
 	r.writeTrackingEntryWithStatus(ctx, trackingStore, tracking.Unknown)
 }
@@ -116,18 +251,36 @@ func (r *RehydrationRequest) WriteNewCompletedRequest(ctx context.Context, track
 	r.writeTrackingEntryWithStatus(ctx, trackingStore, tracking.Completed)
 }
 
+func (r *RehydrationRequest) WriteNewPartialRequest(ctx context.Context, trackingStore tracking.Store, fargateTaskARN string, emailSentDate *time.Time) {
+	r.trackingEntry.FargateTaskARN = fargateTaskARN
+	r.trackingEntry.EmailSentDate = emailSentDate
+	r.writeTrackingEntryWithStatus(ctx, trackingStore, tracking.Partial)
+}
+
 func (r *RehydrationRequest) WriteNewExpiredRequest(ctx context.Context, trackingStore tracking.Store) {
 	r.writeTrackingEntryWithStatus(ctx, trackingStore, tracking.Expired)
 }
 
 func (r *RehydrationRequest) writeTrackingEntryWithStatus(ctx context.Context, trackingStore tracking.Store, status tracking.RehydrationStatus) {
 	r.trackingEntry.RehydrationStatus = status
-	if err := trackingStore.PutEntry(ctx, r.trackingEntry); err != nil {
+	ctx, span := tracing.Start(ctx, "tracking.PutEntry", attribute.String("rehydration.status", string(status)))
+	err := trackingStore.PutEntry(ctx, r.trackingEntry)
+	tracing.End(span, err)
+	if err != nil {
 		// don't want to fail request if we can't write to tracking table
 		r.Logger.Warn("error writing rehydration status to tracking table",
 			slog.Any("rehydrationStatus", status),
 			slog.Any("error", err))
+		return
 	}
+	event := audit.TrackingEvent(r.trackingEntry.DatasetVersionIndex, "", status).
+		WithDetail(audit.TaskARNDetail, r.trackingEntry.FargateTaskARN).
+		WithDetail(audit.DestinationDetail, r.trackingEntry.Destination)
+	if status == tracking.Completed || status == tracking.Partial {
+		// the requester is emailed right away about an existing rehydration
+		event = event.WithDetail(audit.EmailSentDetail, strconv.FormatBool(r.trackingEntry.EmailSentDate != nil))
+	}
+	r.Audit.Record(ctx, event)
 }
 
 func (r *RehydrationRequest) SendCompletedEmail(ctx context.Context, emailer notification.Emailer, rehydrationLocation string) *time.Time {
@@ -143,3 +296,18 @@ func (r *RehydrationRequest) SendCompletedEmail(ctx context.Context, emailer not
 		slog.Time("time", emailSent))
 	return &emailSent
 }
+
+func (r *RehydrationRequest) SendPartialEmail(ctx context.Context, emailer notification.Emailer, rehydrationLocation string, missingFiles []string) *time.Time {
+	if err := emailer.SendRehydrationPartial(ctx, r.Dataset, r.User, rehydrationLocation, missingFiles, manifest.Location(rehydrationLocation)); err != nil {
+		// don't want to fail request if we can't email user
+		r.Logger.Warn("error sending rehydration partial email",
+			slog.Any("rehydrationLocation", rehydrationLocation),
+			slog.Any("error", err))
+		return nil
+	}
+	emailSent := time.Now()
+	r.Logger.Info("sent rehydration partial email",
+		slog.Time("time", emailSent),
+		slog.Int("missingFileCount", len(missingFiles)))
+	return &emailSent
+}
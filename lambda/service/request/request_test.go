@@ -0,0 +1,168 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewRehydrationRequest_Region(t *testing.T) {
+	claims := map[string]string{"sub": "abc", "email": "last@example.com", "name": "First Last"}
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+
+	for name, params := range map[string]struct {
+		region              string
+		expectedDestination *sharedmodels.Destination
+	}{
+		"no region":      {"", nil},
+		"default region": {"us-east-1", nil},
+		"other region":   {"eu-west-1", &sharedmodels.Destination{Region: "eu-west-1", Bucket: "rehydration-eu"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rehydrationRequest, err := NewRehydrationRequest(newTestLambdaRequest(t, models.Request{Dataset: dataset, Region: params.region}, jwtAuthorizer(claims)), 14, testAuthConfig, testBuckets)
+			require.NoError(t, err)
+			assert.Equal(t, params.expectedDestination, rehydrationRequest.Destination)
+		})
+	}
+
+	_, err := NewRehydrationRequest(newTestLambdaRequest(t, models.Request{Dataset: dataset, Region: "ap-southeast-2"}, jwtAuthorizer(claims)), 14, testAuthConfig, testBuckets)
+	var badRequest *BadRequestError
+	require.ErrorAs(t, err, &badRequest)
+	assert.Contains(t, err.Error(), "ap-southeast-2")
+	assert.Contains(t, err.Error(), "eu-west-1")
+}
+
+func TestNewRehydrationRequest_ExternalDestination(t *testing.T) {
+	claims := map[string]string{"sub": "abc", "email": "last@example.com", "name": "First Last"}
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+	roleARN := "arn:aws:iam::123456789012:role/rehydration-writer"
+
+	for name, params := range map[string]struct {
+		prefix         string
+		expectedPrefix string
+	}{
+		"no prefix":              {"", ""},
+		"prefix":                 {"deliveries/", "deliveries/"},
+		"prefix without slashes": {"/deliveries/2024", "deliveries/2024/"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			request := models.Request{Dataset: dataset, Destination: &models.ExternalDestination{
+				Bucket:  "requester-bucket",
+				Prefix:  params.prefix,
+				RoleARN: roleARN,
+				Region:  "eu-central-1",
+			}}
+			rehydrationRequest, err := NewRehydrationRequest(newTestLambdaRequest(t, request, jwtAuthorizer(claims)), 14, testAuthConfig, testBuckets)
+			require.NoError(t, err)
+			expected := &sharedmodels.Destination{Region: "eu-central-1", Bucket: "requester-bucket", Prefix: params.expectedPrefix, RoleARN: roleARN}
+			assert.Equal(t, expected, rehydrationRequest.Destination)
+			assert.Equal(t, expected.Location(), rehydrationRequest.trackingEntry.Destination)
+		})
+	}
+
+	for name, params := range map[string]struct {
+		request       models.Request
+		expectedError string
+	}{
+		"missing bucket": {
+			models.Request{Dataset: dataset, Destination: &models.ExternalDestination{RoleARN: roleARN}},
+			`missing destination "bucket"`},
+		"invalid role": {
+			models.Request{Dataset: dataset, Destination: &models.ExternalDestination{Bucket: "requester-bucket", RoleARN: "arn:aws:iam::123456789012:user/someone"}},
+			"invalid destination roleArn"},
+		"with region": {
+			models.Request{Dataset: dataset, Region: "eu-west-1", Destination: &models.ExternalDestination{Bucket: "requester-bucket", RoleARN: roleARN}},
+			`"region" cannot be combined with "destination"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewRehydrationRequest(newTestLambdaRequest(t, params.request, jwtAuthorizer(claims)), 14, testAuthConfig, testBuckets)
+			var badRequest *BadRequestError
+			require.ErrorAs(t, err, &badRequest)
+			assert.Contains(t, err.Error(), params.expectedError)
+		})
+	}
+}
+
+func TestNewRehydrationRequest_ExternalDestinationRole(t *testing.T) {
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+	user := sharedmodels.User{Name: "Someone", Email: "someone@example.com"}
+	serviceAccount := iamAuthorizer("arn:aws:sts::123456789012:assumed-role/discover-service/session-1")
+	for name, params := range map[string]struct {
+		authorizer *events.APIGatewayV2HTTPRequestContextAuthorizerDescription
+		roleARN    string
+		allowed    bool
+	}{
+		"user's role":                  {jwtAuthorizer(map[string]string{"sub": "abc", "email": "Last@Example.com", "name": "First Last"}), "arn:aws:iam::123456789012:role/rehydration-writer", true},
+		"another principal's role":     {jwtAuthorizer(map[string]string{"sub": "abc", "email": "last@example.com", "name": "First Last"}), "arn:aws:iam::210987654321:role/discover-writer", false},
+		"user without roles":           {jwtAuthorizer(map[string]string{"sub": "def", "email": "someone@example.com", "name": "First Last"}), "arn:aws:iam::123456789012:role/rehydration-writer", false},
+		"service account's role":       {serviceAccount, "arn:aws:iam::210987654321:role/discover-writer", true},
+		"service account, user's role": {serviceAccount, "arn:aws:iam::123456789012:role/rehydration-writer", false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			request := models.Request{Dataset: dataset, User: user, Destination: &models.ExternalDestination{Bucket: "requester-bucket", RoleARN: params.roleARN}}
+			if params.authorizer.JWT != nil {
+				request.User = sharedmodels.User{}
+			}
+			_, err := NewRehydrationRequest(newTestLambdaRequest(t, request, params.authorizer), 14, testAuthConfig, testBuckets)
+			if params.allowed {
+				assert.NoError(t, err)
+			} else {
+				var forbidden *ForbiddenError
+				assert.ErrorAs(t, err, &forbidden)
+			}
+		})
+	}
+}
+
+// fakeTrackingStore implements only tracking.Store.PutEntry
+type fakeTrackingStore struct {
+	tracking.Store
+	err error
+}
+
+func (s *fakeTrackingStore) PutEntry(_ context.Context, _ *tracking.Entry) error {
+	return s.err
+}
+
+type fakeAuditStore struct {
+	audit.Store
+	events []audit.Event
+}
+
+func (s *fakeAuditStore) Append(_ context.Context, events []audit.Event) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func TestRehydrationRequest_Audit(t *testing.T) {
+	ctx := context.Background()
+	claims := map[string]string{"sub": "abc", "email": "last@example.com", "name": "First Last"}
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+	rehydrationRequest, err := NewRehydrationRequest(newTestLambdaRequest(t, models.Request{Dataset: dataset}, jwtAuthorizer(claims)), 14, testAuthConfig, testBuckets)
+	require.NoError(t, err)
+	auditStore := &fakeAuditStore{}
+	rehydrationRequest.Audit = audit.NewRecorder(auditStore, audit.ServiceSource, rehydrationRequest.Logger)
+
+	sentDate := time.Now()
+	rehydrationRequest.WriteNewCompletedRequest(ctx, &fakeTrackingStore{}, "task-arn", &sentDate)
+	// not audited since the entry was not written
+	rehydrationRequest.WriteNewUnknownRequest(ctx, &fakeTrackingStore{err: errors.New("throttled")})
+
+	require.Len(t, auditStore.events, 1)
+	event := auditStore.events[0]
+	assert.Equal(t, audit.TrackingSubject, event.Subject)
+	assert.Equal(t, rehydrationRequest.RequestID(), event.SubjectID)
+	assert.Equal(t, dataset.DatasetVersion(), event.DatasetVersion)
+	assert.Equal(t, string(tracking.Completed), event.Status)
+	assert.Empty(t, event.PreviousStatus)
+	assert.Equal(t, "last@example.com", event.UserEmail)
+	assert.Equal(t, map[string]string{audit.TaskARNDetail: "task-arn", audit.EmailSentDetail: "true"}, event.Detail)
+}
@@ -0,0 +1,340 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/google/uuid"
+	"github.com/pennsieve/rehydration-service/shared"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const DockerHostKey = "DOCKER_HOST"
+const DockerTaskImageKey = "DOCKER_TASK_IMAGE"
+const DockerTaskNetworkKey = "DOCKER_TASK_NETWORK"
+const DockerTaskEnvKeysKey = "DOCKER_TASK_ENV_KEYS"
+
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// dockerTaskARNPrefix is used to build synthetic task ARNs for containers so that the rest of the service can treat
+// them like ECS tasks. The container ID is the last component of the ARN.
+const dockerTaskARNPrefix = "arn:aws:ecs:local:000000000000:task/docker/"
+
+// DockerConfig holds the settings needed to run the rehydration container with a Docker Engine instead of ECS.
+type DockerConfig struct {
+	// Host is in the same format as the docker CLI's DOCKER_HOST: unix:///path/to/socket or tcp://host:port
+	Host string
+	// Image is the rehydration image to run, for example pennsieve/rehydrate:latest
+	Image string
+	// Network is optional. If set the container is attached to this Docker network.
+	Network string
+	// Env is in KEY=VALUE form and is passed to the container in addition to the RunTaskInput container overrides.
+	// It stands in for the environment variables set by the ECS task definition.
+	Env []string
+}
+
+// DockerConfigFromEnvironment returns nil if DOCKER_TASK_IMAGE is not set, meaning that ECS should be used.
+// DOCKER_TASK_ENV_KEYS is an optional comma separated list of environment variables whose values will be copied
+// to the container.
+func DockerConfigFromEnvironment() (*DockerConfig, error) {
+	image, set := os.LookupEnv(DockerTaskImageKey)
+	if !set {
+		return nil, nil
+	}
+	if len(image) == 0 {
+		return nil, fmt.Errorf("empty value set for environment variable %s", DockerTaskImageKey)
+	}
+	host := os.Getenv(DockerHostKey)
+	if len(host) == 0 {
+		host = defaultDockerHost
+	}
+	var env []string
+	if keysStr := os.Getenv(DockerTaskEnvKeysKey); len(keysStr) > 0 {
+		for _, key := range strings.Split(keysStr, ",") {
+			key = strings.TrimSpace(key)
+			value, err := shared.NonEmptyFromEnvVar(key)
+			if err != nil {
+				return nil, err
+			}
+			env = append(env, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	return &DockerConfig{
+		Host:    host,
+		Image:   image,
+		Network: os.Getenv(DockerTaskNetworkKey),
+		Env:     env,
+	}, nil
+}
+
+// DockerClient is a minimal client for the parts of the Docker Engine API needed to run the rehydration container.
+type DockerClient struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+func NewDockerClient(host string) (*DockerClient, error) {
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing docker host %s: %w", host, err)
+	}
+	switch hostURL.Scheme {
+	case "unix":
+		socketPath := hostURL.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		}
+		// host part is ignored when dialing the socket
+		return &DockerClient{httpClient: &http.Client{Transport: transport}, baseURL: "http://docker"}, nil
+	case "tcp", "http":
+		return &DockerClient{httpClient: http.DefaultClient, baseURL: fmt.Sprintf("http://%s", hostURL.Host)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme %q in %s", hostURL.Scheme, host)
+	}
+}
+
+type DockerCreateContainerRequest struct {
+	Image      string            `json:"Image"`
+	Env        []string          `json:"Env"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+	HostConfig DockerHostConfig  `json:"HostConfig"`
+}
+
+type DockerHostConfig struct {
+	NetworkMode string `json:"NetworkMode,omitempty"`
+}
+
+type DockerCreateContainerResponse struct {
+	ID       string   `json:"Id"`
+	Warnings []string `json:"Warnings"`
+}
+
+type DockerContainerState struct {
+	Status     string `json:"Status"`
+	Running    bool   `json:"Running"`
+	ExitCode   int32  `json:"ExitCode"`
+	Error      string `json:"Error"`
+	StartedAt  string `json:"StartedAt"`
+	FinishedAt string `json:"FinishedAt"`
+}
+
+type DockerInspectContainerResponse struct {
+	ID    string               `json:"Id"`
+	Name  string               `json:"Name"`
+	State DockerContainerState `json:"State"`
+}
+
+type dockerErrorResponse struct {
+	Message string `json:"message"`
+}
+
+func (c *DockerClient) CreateContainer(ctx context.Context, name string, request DockerCreateContainerRequest) (*DockerCreateContainerResponse, error) {
+	path := fmt.Sprintf("/containers/create?name=%s", url.QueryEscape(name))
+	var response DockerCreateContainerResponse
+	if err := c.do(ctx, http.MethodPost, path, request, &response); err != nil {
+		return nil, fmt.Errorf("error creating container %s: %w", name, err)
+	}
+	return &response, nil
+}
+
+func (c *DockerClient) StartContainer(ctx context.Context, containerID string) error {
+	path := fmt.Sprintf("/containers/%s/start", url.PathEscape(containerID))
+	if err := c.do(ctx, http.MethodPost, path, nil, nil); err != nil {
+		return fmt.Errorf("error starting container %s: %w", containerID, err)
+	}
+	return nil
+}
+
+// RemoveContainer removes the container even if it is running
+func (c *DockerClient) RemoveContainer(ctx context.Context, containerID string) error {
+	path := fmt.Sprintf("/containers/%s?force=true", url.PathEscape(containerID))
+	if err := c.do(ctx, http.MethodDelete, path, nil, nil); err != nil {
+		return fmt.Errorf("error removing container %s: %w", containerID, err)
+	}
+	return nil
+}
+
+func (c *DockerClient) InspectContainer(ctx context.Context, containerID string) (*DockerInspectContainerResponse, error) {
+	path := fmt.Sprintf("/containers/%s/json", url.PathEscape(containerID))
+	var response DockerInspectContainerResponse
+	if err := c.do(ctx, http.MethodGet, path, nil, &response); err != nil {
+		return nil, fmt.Errorf("error inspecting container %s: %w", containerID, err)
+	}
+	return &response, nil
+}
+
+func (c *DockerClient) do(ctx context.Context, method, path string, requestBody any, responseBody any) error {
+	var body io.Reader
+	if requestBody != nil {
+		bodyBytes, err := json.Marshal(requestBody)
+		if err != nil {
+			return fmt.Errorf("error marshalling request body: %w", err)
+		}
+		body = bytes.NewReader(bodyBytes)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if requestBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errResp dockerErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && len(errResp.Message) > 0 {
+			return fmt.Errorf("docker engine returned status %d: %s", resp.StatusCode, errResp.Message)
+		}
+		return fmt.Errorf("docker engine returned status %d", resp.StatusCode)
+	}
+	if responseBody != nil {
+		if err := json.NewDecoder(resp.Body).Decode(responseBody); err != nil {
+			return fmt.Errorf("error decoding response body: %w", err)
+		}
+	}
+	return nil
+}
+
+// DockerTaskRunner runs the rehydration container with a Docker Engine. The container gets the same environment
+// overrides that ECS would have gotten from Input.
+type DockerTaskRunner struct {
+	Client *DockerClient
+	Config *DockerConfig
+	Input  *ecs.RunTaskInput
+}
+
+func NewDockerTaskRunner(client *DockerClient, config *DockerConfig, input *ecs.RunTaskInput) Runner {
+	return &DockerTaskRunner{Client: client, Config: config, Input: input}
+}
+
+// Run creates and starts a container. Like ECS RunTask, errors starting the container are reported as a
+// types.Failure in the output rather than as an error, so that the caller can handle both runners in the same way.
+// A container that cannot be started is removed.
+func (r *DockerTaskRunner) Run(ctx context.Context) (*ecs.RunTaskOutput, error) {
+	env := append([]string{}, r.Config.Env...)
+	if r.Input.Overrides != nil {
+		for _, override := range r.Input.Overrides.ContainerOverrides {
+			for _, kv := range override.Environment {
+				env = append(env, fmt.Sprintf("%s=%s", aws.ToString(kv.Name), aws.ToString(kv.Value)))
+			}
+		}
+	}
+	createRequest := DockerCreateContainerRequest{
+		Image: r.Config.Image,
+		Env:   env,
+		Labels: map[string]string{
+			"org.pennsieve.rehydration.task-definition": aws.ToString(r.Input.TaskDefinition),
+		},
+		HostConfig: DockerHostConfig{NetworkMode: r.Config.Network},
+	}
+	name := fmt.Sprintf("rehydrate-%s", uuid.NewString())
+	created, err := r.Client.CreateContainer(ctx, name, createRequest)
+	if err != nil {
+		return nil, err
+	}
+	taskARN := DockerTaskARN(created.ID)
+	if err := r.Client.StartContainer(ctx, created.ID); err != nil {
+		if removeErr := r.Client.RemoveContainer(ctx, created.ID); removeErr != nil {
+			err = errors.Join(err, removeErr)
+		}
+		return &ecs.RunTaskOutput{Failures: []types.Failure{{
+			Arn:    aws.String(taskARN),
+			Reason: aws.String("CONTAINER_START_FAILED"),
+			Detail: aws.String(err.Error()),
+		}}}, nil
+	}
+	return &ecs.RunTaskOutput{Tasks: []types.Task{{
+		TaskArn:       aws.String(taskARN),
+		LastStatus:    aws.String("PENDING"),
+		DesiredStatus: aws.String("RUNNING"),
+	}}}, nil
+}
+
+// Describe looks up the container for the given task ARN and reports its state as a types.Task.
+func (r *DockerTaskRunner) Describe(ctx context.Context, taskARN string) (*types.Task, error) {
+	containerID, err := DockerContainerID(taskARN)
+	if err != nil {
+		return nil, err
+	}
+	inspected, err := r.Client.InspectContainer(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	lastStatus := dockerStatusToECSStatus(inspected.State.Status)
+	task := &types.Task{
+		TaskArn:       aws.String(taskARN),
+		LastStatus:    aws.String(lastStatus),
+		DesiredStatus: aws.String("RUNNING"),
+		Containers: []types.Container{{
+			Name:       aws.String(strings.TrimPrefix(inspected.Name, "/")),
+			LastStatus: aws.String(lastStatus),
+		}},
+	}
+	if startedAt, ok := parseDockerTime(inspected.State.StartedAt); ok {
+		task.StartedAt = startedAt
+	}
+	if lastStatus == "STOPPED" {
+		task.DesiredStatus = aws.String("STOPPED")
+		task.Containers[0].ExitCode = aws.Int32(inspected.State.ExitCode)
+		if len(inspected.State.Error) > 0 {
+			task.StoppedReason = aws.String(inspected.State.Error)
+		}
+		if stoppedAt, ok := parseDockerTime(inspected.State.FinishedAt); ok {
+			task.StoppedAt = stoppedAt
+		}
+	}
+	return task, nil
+}
+
+// DockerTaskARN returns the synthetic task ARN used for the container with the given ID
+func DockerTaskARN(containerID string) string {
+	return dockerTaskARNPrefix + containerID
+}
+
+// DockerContainerID returns the container ID from a task ARN created by DockerTaskARN
+func DockerContainerID(taskARN string) (string, error) {
+	containerID, found := strings.CutPrefix(taskARN, dockerTaskARNPrefix)
+	if !found || len(containerID) == 0 {
+		return "", fmt.Errorf("%s is not a docker task ARN", taskARN)
+	}
+	return containerID, nil
+}
+
+func dockerStatusToECSStatus(dockerStatus string) string {
+	switch dockerStatus {
+	case "created":
+		return "PENDING"
+	case "running", "paused", "restarting":
+		return "RUNNING"
+	default:
+		// exited, removing, dead
+		return "STOPPED"
+	}
+}
+
+// parseDockerTime returns false for Docker's zero time which it uses for containers that have not started or finished
+func parseDockerTime(dockerTime string) (*time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, dockerTime)
+	if err != nil || t.Year() <= 1 {
+		return nil, false
+	}
+	return &t, true
+}
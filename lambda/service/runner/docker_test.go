@@ -0,0 +1,238 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDockerTaskRunner_Run(t *testing.T) {
+	containerID := "abc123"
+	var createRequest DockerCreateContainerRequest
+	var createdName string
+	mockDocker := test.NewHTTPMuxTestFixture(t,
+		test.NewHandlerFuncBuilder("/containers/create").WithMethod(http.MethodPost).WithSelectorFunc(func(r *http.Request) (int, any) {
+			createdName = r.URL.Query().Get("name")
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&createRequest))
+			return http.StatusCreated, DockerCreateContainerResponse{ID: containerID}
+		}),
+		test.NewHandlerFuncBuilder(fmt.Sprintf("/containers/%s/start", containerID)).WithMethod(http.MethodPost).WithStatusCode(http.StatusNoContent).WithModel(""),
+	)
+	defer mockDocker.Teardown()
+
+	dockerConfig := &DockerConfig{
+		Host:    strings.Replace(mockDocker.Server.URL, "http://", "tcp://", 1),
+		Image:   "pennsieve/rehydrate:test",
+		Network: "test-network",
+		Env:     []string{"ENV=test", "REHYDRATION_BUCKET=test-bucket"},
+	}
+	client, err := NewDockerClient(dockerConfig.Host)
+	require.NoError(t, err)
+
+	input := newTestRunTaskInput()
+	out, err := NewDockerTaskRunner(client, dockerConfig, input).Run(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, out.Failures)
+	require.Len(t, out.Tasks, 1)
+	assert.Equal(t, DockerTaskARN(containerID), aws.ToString(out.Tasks[0].TaskArn))
+
+	assert.True(t, strings.HasPrefix(createdName, "rehydrate-"))
+	assert.Equal(t, dockerConfig.Image, createRequest.Image)
+	assert.Equal(t, dockerConfig.Network, createRequest.HostConfig.NetworkMode)
+	assert.ElementsMatch(t, []string{
+		"ENV=test",
+		"REHYDRATION_BUCKET=test-bucket",
+		"DATASET_ID=1234",
+		"DATASET_VERSION_ID=3",
+	}, createRequest.Env)
+}
+
+func TestDockerTaskRunner_Run_Errors(t *testing.T) {
+	containerID := "abc123"
+	for name, params := range map[string]struct {
+		startStatus     int
+		removeStatus    int
+		createStatus    int
+		expectError     bool
+		expectedFailure bool
+	}{
+		"create fails":          {createStatus: http.StatusNotFound, expectError: true},
+		"start fails":           {createStatus: http.StatusCreated, startStatus: http.StatusInternalServerError, removeStatus: http.StatusNoContent, expectedFailure: true},
+		"start and remove fail": {createStatus: http.StatusCreated, startStatus: http.StatusInternalServerError, removeStatus: http.StatusConflict, expectedFailure: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var createModel any = DockerCreateContainerResponse{ID: containerID}
+			if params.createStatus != http.StatusCreated {
+				createModel = `{"message": "No such image: pennsieve/rehydrate:test"}`
+			}
+			builders := []*test.HandlerFuncBuilder{
+				test.NewHandlerFuncBuilder("/containers/create").WithMethod(http.MethodPost).WithStatusCode(params.createStatus).WithModel(createModel),
+			}
+			if params.startStatus != 0 {
+				builders = append(builders, test.NewHandlerFuncBuilder(fmt.Sprintf("/containers/%s/start", containerID)).
+					WithMethod(http.MethodPost).
+					WithStatusCode(params.startStatus).
+					WithModel(`{"message": "driver failed"}`))
+			}
+			removed := false
+			if params.removeStatus != 0 {
+				builders = append(builders, test.NewHandlerFuncBuilder(fmt.Sprintf("/containers/%s", containerID)).
+					WithMethod(http.MethodDelete).
+					WithSelectorFunc(func(r *http.Request) (int, any) {
+						assert.Equal(t, "true", r.URL.Query().Get("force"))
+						removed = true
+						if params.removeStatus == http.StatusNoContent {
+							return params.removeStatus, ""
+						}
+						return params.removeStatus, `{"message": "removal already in progress"}`
+					}))
+			}
+			mockDocker := test.NewHTTPMuxTestFixture(t, builders...)
+			defer mockDocker.Teardown()
+
+			dockerConfig := &DockerConfig{Host: mockDocker.Server.URL, Image: "pennsieve/rehydrate:test"}
+			client, err := NewDockerClient(dockerConfig.Host)
+			require.NoError(t, err)
+			out, err := NewDockerTaskRunner(client, dockerConfig, newTestRunTaskInput()).Run(context.Background())
+			if params.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "No such image")
+				return
+			}
+			require.NoError(t, err)
+			assert.Empty(t, out.Tasks)
+			require.Len(t, out.Failures, 1)
+			assert.Equal(t, DockerTaskARN(containerID), aws.ToString(out.Failures[0].Arn))
+			assert.Contains(t, aws.ToString(out.Failures[0].Detail), "driver failed")
+			// the container that could not be started is not left behind
+			assert.True(t, removed)
+			if params.removeStatus != http.StatusNoContent {
+				assert.Contains(t, aws.ToString(out.Failures[0].Detail), "removal already in progress")
+			}
+		})
+	}
+}
+
+func TestDockerTaskRunner_Describe(t *testing.T) {
+	containerID := "abc123"
+	for name, params := range map[string]struct {
+		state              DockerContainerState
+		expectedLastStatus string
+		expectedExitCode   *int32
+	}{
+		"created": {state: DockerContainerState{Status: "created", StartedAt: "0001-01-01T00:00:00Z"}, expectedLastStatus: "PENDING"},
+		"running": {state: DockerContainerState{Status: "running", Running: true, StartedAt: "2024-03-01T10:00:00.123456789Z"}, expectedLastStatus: "RUNNING"},
+		"exited": {state: DockerContainerState{
+			Status:     "exited",
+			ExitCode:   1,
+			StartedAt:  "2024-03-01T10:00:00.123456789Z",
+			FinishedAt: "2024-03-01T11:00:00.123456789Z"},
+			expectedLastStatus: "STOPPED",
+			expectedExitCode:   aws.Int32(1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockDocker := test.NewHTTPMuxTestFixture(t,
+				test.NewHandlerFuncBuilder(fmt.Sprintf("/containers/%s/json", containerID)).
+					WithModel(DockerInspectContainerResponse{ID: containerID, Name: "/rehydrate-test", State: params.state}),
+			)
+			defer mockDocker.Teardown()
+			client, err := NewDockerClient(mockDocker.Server.URL)
+			require.NoError(t, err)
+			dockerRunner := NewDockerTaskRunner(client, &DockerConfig{}, newTestRunTaskInput())
+
+			task, err := dockerRunner.Describe(context.Background(), DockerTaskARN(containerID))
+			require.NoError(t, err)
+			assert.Equal(t, DockerTaskARN(containerID), aws.ToString(task.TaskArn))
+			assert.Equal(t, params.expectedLastStatus, aws.ToString(task.LastStatus))
+			require.Len(t, task.Containers, 1)
+			assert.Equal(t, "rehydrate-test", aws.ToString(task.Containers[0].Name))
+			assert.Equal(t, params.expectedExitCode, task.Containers[0].ExitCode)
+			if params.state.Running || params.expectedExitCode != nil {
+				assert.NotNil(t, task.StartedAt)
+			} else {
+				assert.Nil(t, task.StartedAt)
+			}
+			if params.expectedExitCode != nil {
+				assert.NotNil(t, task.StoppedAt)
+			}
+		})
+	}
+}
+
+func TestDockerTaskRunner_Describe_NotDockerARN(t *testing.T) {
+	dockerRunner := NewDockerTaskRunner(nil, &DockerConfig{}, newTestRunTaskInput())
+	_, err := dockerRunner.Describe(context.Background(), "arn:aws:ecs:us-east-1:123456789012:task/cluster/abc")
+	assert.ErrorContains(t, err, "not a docker task ARN")
+}
+
+func TestNewDockerClient(t *testing.T) {
+	for host, expectedErr := range map[string]string{
+		"unix:///var/run/docker.sock": "",
+		"tcp://localhost:2375":        "",
+		"npipe:////./pipe/docker":     "unsupported",
+	} {
+		t.Run(host, func(t *testing.T) {
+			_, err := NewDockerClient(host)
+			if len(expectedErr) == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, expectedErr)
+			}
+		})
+	}
+}
+
+func TestDockerConfigFromEnvironment(t *testing.T) {
+	t.Run("not set", func(t *testing.T) {
+		config, err := DockerConfigFromEnvironment()
+		require.NoError(t, err)
+		assert.Nil(t, config)
+	})
+	t.Run("set", func(t *testing.T) {
+		test.NewEnvironmentVariables().
+			With(DockerTaskImageKey, "pennsieve/rehydrate:test").
+			With(DockerTaskEnvKeysKey, "ENV, REHYDRATION_BUCKET").
+			With("ENV", "test").
+			With("REHYDRATION_BUCKET", "test-bucket").
+			Setenv(t)
+		config, err := DockerConfigFromEnvironment()
+		require.NoError(t, err)
+		require.NotNil(t, config)
+		assert.Equal(t, defaultDockerHost, config.Host)
+		assert.Equal(t, "pennsieve/rehydrate:test", config.Image)
+		assert.Empty(t, config.Network)
+		assert.Equal(t, []string{"ENV=test", "REHYDRATION_BUCKET=test-bucket"}, config.Env)
+	})
+	t.Run("missing passthrough value", func(t *testing.T) {
+		test.NewEnvironmentVariables().
+			With(DockerTaskImageKey, "pennsieve/rehydrate:test").
+			With(DockerTaskEnvKeysKey, "NOT_SET_FOR_THIS_TEST").
+			Setenv(t)
+		_, err := DockerConfigFromEnvironment()
+		assert.ErrorContains(t, err, "NOT_SET_FOR_THIS_TEST")
+	})
+}
+
+func newTestRunTaskInput() *ecs.RunTaskInput {
+	return &ecs.RunTaskInput{
+		TaskDefinition: aws.String("test-task-definition"),
+		Overrides: &types.TaskOverride{
+			ContainerOverrides: []types.ContainerOverride{{
+				Name: aws.String("test-container"),
+				Environment: []types.KeyValuePair{
+					{Name: aws.String("DATASET_ID"), Value: aws.String("1234")},
+					{Name: aws.String("DATASET_VERSION_ID"), Value: aws.String("3")},
+				},
+			}},
+		},
+	}
+}
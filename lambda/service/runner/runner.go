@@ -3,8 +3,11 @@ package runner
 import (
 	"context"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 )
 
 type Runner interface {
 	Run(context.Context) (*ecs.RunTaskOutput, error)
+	// Describe returns the current state of a task previously started by Run
+	Describe(ctx context.Context, taskARN string) (*types.Task, error)
 }
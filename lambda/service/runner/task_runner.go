@@ -2,8 +2,11 @@ package runner
 
 import (
 	"context"
-
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"strings"
 )
 
 type ECSTaskRunner struct {
@@ -18,3 +21,23 @@ func NewECSTaskRunner(client *ecs.Client, input *ecs.RunTaskInput) Runner {
 func (r *ECSTaskRunner) Run(ctx context.Context) (*ecs.RunTaskOutput, error) {
 	return r.Client.RunTask(ctx, r.Input)
 }
+
+func (r *ECSTaskRunner) Describe(ctx context.Context, taskARN string) (*types.Task, error) {
+	out, err := r.Client.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+		Cluster: r.Input.Cluster,
+		Tasks:   []string{taskARN},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing task %s: %w", taskARN, err)
+	}
+	for _, task := range out.Tasks {
+		if aws.ToString(task.TaskArn) == taskARN {
+			return &task, nil
+		}
+	}
+	var failMsgs []string
+	for _, fail := range out.Failures {
+		failMsgs = append(failMsgs, fmt.Sprintf("[reason: %s, detail: %s]", aws.ToString(fail.Reason), aws.ToString(fail.Detail)))
+	}
+	return nil, fmt.Errorf("task %s not found: %s", taskARN, strings.Join(failMsgs, ", "))
+}
@@ -0,0 +1,191 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/google/uuid"
+	"github.com/pennsieve/rehydration-service/fargate/config"
+	"github.com/pennsieve/rehydration-service/fargate/task"
+	"github.com/pennsieve/rehydration-service/service/ecs"
+	"github.com/pennsieve/rehydration-service/service/models"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	"log/slog"
+	"sync"
+)
+
+// Runner runs rehydration tasks in-process instead of on ECS. Its NewHandler method can be used as a
+// replacement for handler.ECSHandlerFactory in the service Lambda so that a request can be followed all the way
+// through the copy, idempotency finalization, and notification steps without deploying anything.
+type Runner struct {
+	awsConfig     aws.Config
+	taskEnv       map[string]string
+	thresholdSize int64
+	configurers   []func(*config.Config)
+	mu            sync.Mutex
+	queued        []queuedTask
+	statuses      map[string]*ecs.JobStatus
+}
+
+type queuedTask struct {
+	taskARN string
+	env     map[string]string
+	logger  *slog.Logger
+}
+
+// NewRunner returns a Runner whose tasks will use the given aws.Config. taskEnv should contain the environment variables
+// that the ECS task definition would provide (ENV, REGION, REHYDRATION_BUCKET, REHYDRATION_TTL_DAYS). The values
+// from the RunTaskInput container overrides are added to these when a task is started.
+func NewRunner(awsConfig aws.Config, taskEnv map[string]string) *Runner {
+	return &Runner{
+		awsConfig:     awsConfig,
+		taskEnv:       taskEnv,
+		thresholdSize: task.ThresholdSize,
+		statuses:      map[string]*ecs.JobStatus{},
+	}
+}
+
+// WithThresholdSize sets the file size above which a multipart copy will be used
+func (r *Runner) WithThresholdSize(thresholdSize int64) *Runner {
+	r.thresholdSize = thresholdSize
+	return r
+}
+
+// WithPennsieveHost overrides the Pennsieve API host that would otherwise be derived from the ENV variable.
+// Useful for pointing tasks at a mock Discover server.
+func (r *Runner) WithPennsieveHost(pennsieveHost string) *Runner {
+	return r.WithConfigurer(func(c *config.Config) {
+		c.Env.PennsieveHost = pennsieveHost
+	})
+}
+
+// WithConfigurer adds a function that will be called with each task's config.Config before the task runs.
+// Can be used to set mock implementations with the config.Config Set* methods.
+func (r *Runner) WithConfigurer(configurer func(*config.Config)) *Runner {
+	r.configurers = append(r.configurers, configurer)
+	return r
+}
+
+// NewHandler has the same signature as ecs.NewHandler. The returned ecs.Handler queues each task and returns a fake
+// task ARN. The aws.Config argument is ignored in favor of the one given to NewRunner.
+func (r *Runner) NewHandler(_ aws.Config, taskConfig *models.ECSTaskConfig) ecs.Handler {
+	return &handler{runner: r, taskConfig: taskConfig}
+}
+
+// RunQueued runs all tasks queued since the last call, each in its own goroutine, and blocks until they finish.
+// Tasks are queued rather than started by the handler because the service Lambda only writes the task ARN to the
+// idempotency and tracking tables after the handler returns. On ECS, task start-up time hides this.
+func (r *Runner) RunQueued(ctx context.Context) error {
+	r.mu.Lock()
+	queued := r.queued
+	r.queued = nil
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(queued))
+	for i, q := range queued {
+		wg.Add(1)
+		go func(i int, q queuedTask) {
+			defer wg.Done()
+			q.logger.Info("starting local rehydration task", slog.String("taskARN", q.taskARN))
+			r.setRunning(q.taskARN)
+			err := r.run(ctx, q.env)
+			r.setStopped(q.taskARN, err)
+			if err != nil {
+				errs[i] = fmt.Errorf("error running local task %s: %w", q.taskARN, err)
+			}
+		}(i, q)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (r *Runner) enqueue(q queuedTask) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queued = append(r.queued, q)
+	r.statuses[q.taskARN] = &ecs.JobStatus{JobID: q.taskARN, Backend: sharedmodels.ECSBackend, Status: "PENDING"}
+}
+
+func (r *Runner) setRunning(taskARN string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses[taskARN] = &ecs.JobStatus{JobID: taskARN, Backend: sharedmodels.ECSBackend, Status: "RUNNING"}
+}
+
+func (r *Runner) setStopped(taskARN string, runErr error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobStatus := &ecs.JobStatus{
+		JobID:     taskARN,
+		Backend:   sharedmodels.ECSBackend,
+		Status:    "STOPPED",
+		Finished:  true,
+		Succeeded: runErr == nil,
+	}
+	if runErr != nil {
+		jobStatus.Reason = runErr.Error()
+	}
+	r.statuses[taskARN] = jobStatus
+}
+
+func (r *Runner) status(taskARN string) (*ecs.JobStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobStatus, ok := r.statuses[taskARN]
+	if !ok {
+		return nil, fmt.Errorf("no local task %s", taskARN)
+	}
+	statusCopy := *jobStatus
+	return &statusCopy, nil
+}
+
+func (r *Runner) run(ctx context.Context, env map[string]string) error {
+	lookup := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	// continue the trace of the request that queued the task, as the container would
+	ctx = tracing.ContextFromLookup(ctx, lookup)
+	configEnv, err := config.LookupEnvFrom(lookup)
+	if err != nil {
+		return fmt.Errorf("error getting taskConfig environment variables: %w", err)
+	}
+	taskConfig := config.NewConfig(r.awsConfig, configEnv)
+	for _, configurer := range r.configurers {
+		configurer(taskConfig)
+	}
+	taskHandler, err := task.NewTaskHandler(taskConfig, r.thresholdSize)
+	if err != nil {
+		return fmt.Errorf("error creating TaskHandler: %w", err)
+	}
+	return task.RehydrationTaskHandler(ctx, taskHandler)
+}
+
+type handler struct {
+	runner     *Runner
+	taskConfig *models.ECSTaskConfig
+}
+
+func (h *handler) Handle(ctx context.Context, dataset sharedmodels.Dataset, user sharedmodels.User, destination *sharedmodels.Destination, requestID string, logger *slog.Logger) (string, error) {
+	runTaskIn := h.taskConfig.RunTaskInput(ctx, dataset, user, destination, requestID)
+	env := make(map[string]string, len(h.runner.taskEnv))
+	for k, v := range h.runner.taskEnv {
+		env[k] = v
+	}
+	for _, override := range runTaskIn.Overrides.ContainerOverrides {
+		for _, kv := range override.Environment {
+			env[aws.ToString(kv.Name)] = aws.ToString(kv.Value)
+		}
+	}
+	taskARN := fmt.Sprintf("arn:aws:ecs:local:000000000000:task/%s/%s", aws.ToString(runTaskIn.Cluster), uuid.NewString())
+	logger.Info("queued local rehydration task", slog.String("taskARN", taskARN))
+	h.runner.enqueue(queuedTask{taskARN: taskARN, env: env, logger: logger})
+	return taskARN, nil
+}
+
+func (h *handler) Status(_ context.Context, jobID string) (*ecs.JobStatus, error) {
+	return h.runner.status(jobID)
+}
@@ -0,0 +1,226 @@
+package local_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/pennsieve/rehydration-service/fargate/utils"
+	"github.com/pennsieve/rehydration-service/local"
+	"github.com/pennsieve/rehydration-service/service/access"
+	"github.com/pennsieve/rehydration-service/service/handler"
+	"github.com/pennsieve/rehydration-service/service/models"
+	"github.com/pennsieve/rehydration-service/service/request"
+	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/expiration"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	sharedmodels "github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/notification"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/test/discovertest"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	idempotencyTable  = "TestRehydrationIdempotency"
+	trackingTable     = "TestRehydrationTracking"
+	rehydrationBucket = "test-rehydration-bucket"
+	publishBucket     = "discover-bucket"
+)
+
+var serviceEnv = test.NewEnvironmentVariables().
+	With("TASK_DEF_ARN", "test-ecs-task-definition-arn").
+	With("SUBNET_IDS", "test-subnet-1, test-subnet-2").
+	With("CLUSTER_ARN", "test-cluster-arn").
+	With("SECURITY_GROUP", "test-sg").
+	With("TASK_DEF_CONTAINER_NAME", "test-rehydrate-fargate-container").
+	With(idempotency.TableNameKey, idempotencyTable).
+	With(tracking.TableNameKey, trackingTable).
+	With(notification.PennsieveDomainKey, "pennsieve.example.com").
+	With(shared.AWSRegionKey, "test-1").
+	With(expiration.RehydrationTTLDays, "14").
+	With(sharedmodels.ECSTaskEnvKey, "TEST")
+
+// taskEnv holds the values that the task definition would supply to the Fargate container
+var taskEnv = map[string]string{
+	sharedmodels.ECSTaskEnvKey:    "TEST",
+	shared.AWSRegionKey:           "test-1",
+	shared.RehydrationBucketKey:   rehydrationBucket,
+	expiration.RehydrationTTLDays: "14",
+}
+
+func TestRunner(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+	serviceEnv.Setenv(t)
+	ctx := context.Background()
+
+	dataset := sharedmodels.Dataset{ID: 5065, VersionID: 2}
+	users := []sharedmodels.User{
+		{Name: "First Last", Email: "last@example.com"},
+		{Name: "Guy Sur", Email: "sur@example.com"},
+	}
+	expectedRehydrationLocation := utils.RehydrationLocation(rehydrationBucket, dataset.ID, dataset.VersionID)
+
+	mockSES := newMockSES(t)
+	defer mockSES.Teardown()
+
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().WithMinIO().WithSES(mockSES.Server.URL).Config(ctx, false)
+	handler.AWSConfigFactory.Set(&awsConfig)
+	defer handler.AWSConfigFactory.Set(nil)
+
+	testDatasetFiles := discovertest.NewTestDatasetFiles(dataset, 20)
+	s3Fixture, putObjectOutputs := test.NewS3Fixture(t, s3.NewFromConfig(awsConfig),
+		&s3.CreateBucketInput{Bucket: aws.String(publishBucket)},
+		&s3.CreateBucketInput{Bucket: aws.String(rehydrationBucket)},
+	).WithVersioning(publishBucket).WithObjects(testDatasetFiles.PutObjectInputs(publishBucket)...)
+	defer s3Fixture.Teardown()
+	for location, putOutput := range putObjectOutputs {
+		testDatasetFiles.SetS3VersionID(t, location, aws.ToString(putOutput.VersionId))
+	}
+
+	dyDB := test.NewDynamoDBFixture(t, awsConfig,
+		test.IdempotencyCreateTableInput(idempotencyTable),
+		test.TrackingCreateTableInput(trackingTable))
+	defer dyDB.Teardown()
+
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetMetadataByVersionHandlerBuilder(dataset, testDatasetFiles.DatasetFiles()),
+		discovertest.GetDatasetFileByVersionHandlerBuilder(dataset, publishBucket, testDatasetFiles.ByPath),
+		discovertest.GetDatasetVersionHandlerBuilder(dataset, "", ""),
+	)
+	defer mockDiscover.Teardown()
+
+	originalAccessCheckerFactory := handler.AccessCheckerFactory
+	handler.AccessCheckerFactory = func(_ string) access.Checker { return access.NewChecker(mockDiscover.Server.URL) }
+	defer func() { handler.AccessCheckerFactory = originalAccessCheckerFactory }()
+
+	runner := local.NewRunner(awsConfig, taskEnv).WithPennsieveHost(mockDiscover.Server.URL)
+	originalECSHandlerFactory := handler.ECSHandlerFactory
+	handler.ECSHandlerFactory = runner.NewHandler
+	defer func() { handler.ECSHandlerFactory = originalECSHandlerFactory }()
+
+	var taskARN string
+	for _, user := range users {
+		response, err := handler.RehydrationServiceHandler(ctx, newLambdaRequest(t, models.Request{Dataset: dataset, User: user}))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusAccepted, response.StatusCode, response.Body)
+		var body map[string]string
+		require.NoError(t, json.Unmarshal([]byte(response.Body), &body))
+		if len(taskARN) == 0 {
+			taskARN = body["taskARN"]
+		}
+		// second request should be handled by idempotency and not start a new task
+		assert.Equal(t, taskARN, body["taskARN"])
+	}
+	require.NotEmpty(t, taskARN)
+
+	ecsHandler := runner.NewHandler(awsConfig, nil)
+	queuedStatus, err := ecsHandler.Status(ctx, taskARN)
+	require.NoError(t, err)
+	assert.False(t, queuedStatus.Finished)
+
+	beforeTask := time.Now()
+	require.NoError(t, runner.RunQueued(ctx))
+	afterTask := time.Now()
+
+	finishedStatus, err := ecsHandler.Status(ctx, taskARN)
+	require.NoError(t, err)
+	assert.True(t, finishedStatus.Finished)
+	assert.True(t, finishedStatus.Succeeded)
+
+	for _, datasetFile := range testDatasetFiles.Files {
+		expectedKey := utils.DestinationKey(dataset.ID, dataset.VersionID, datasetFile.Path)
+		s3Fixture.AssertObjectExists(rehydrationBucket, expectedKey, datasetFile.Size)
+	}
+
+	idempotencyItems := dyDB.Scan(ctx, idempotencyTable)
+	require.Len(t, idempotencyItems, 1)
+	record, err := idempotency.FromItem(idempotencyItems[0])
+	require.NoError(t, err)
+	assert.Equal(t, idempotency.Completed, record.Status)
+	assert.Equal(t, taskARN, record.FargateTaskARN)
+	assert.Equal(t, expectedRehydrationLocation, record.RehydrationLocation)
+	assert.NotNil(t, record.ExpirationDate)
+
+	trackingItems := dyDB.Scan(ctx, trackingTable)
+	require.Len(t, trackingItems, len(users))
+	for _, item := range trackingItems {
+		entry, err := tracking.FromItem(item)
+		require.NoError(t, err)
+		assert.Equal(t, dataset.DatasetVersion(), entry.DatasetVersion)
+		assert.Equal(t, tracking.Completed, entry.RehydrationStatus)
+		assert.Equal(t, taskARN, entry.FargateTaskARN)
+		if assert.NotNil(t, entry.EmailSentDate) {
+			assert.False(t, beforeTask.After(*entry.EmailSentDate))
+			assert.False(t, afterTask.Before(*entry.EmailSentDate))
+		}
+	}
+
+	// one completion email per user
+	assert.ElementsMatch(t, []string{users[0].Email, users[1].Email}, mockSES.recipients())
+
+	// nothing left to run
+	assert.NoError(t, runner.RunQueued(ctx))
+}
+
+func newLambdaRequest(t *testing.T, rehydrationRequest models.Request) events.APIGatewayV2HTTPRequest {
+	body, err := json.Marshal(rehydrationRequest)
+	require.NoError(t, err)
+	return events.APIGatewayV2HTTPRequest{
+		RouteKey: "POST /discover/rehydrate",
+		Body:     string(body),
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			RequestID: uuid.NewString(),
+			HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+				Method: "POST",
+			},
+			Authorizer: &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+				Lambda: map[string]interface{}{
+					request.SubjectClaim: uuid.NewString(),
+					request.EmailClaim:   rehydrationRequest.User.Email,
+					request.NameClaim:    rehydrationRequest.User.Name,
+				},
+			},
+		},
+	}
+}
+
+// mockSES is a minimal SES endpoint that records the recipient of each SendEmail request
+type mockSES struct {
+	test.HTTPTestFixture
+	mu     sync.Mutex
+	sentTo []string
+}
+
+func newMockSES(t *testing.T) *mockSES {
+	m := &mockSES{}
+	response := &test.HTTPTestResponse{Body: fmt.Sprintf(
+		`<SendEmailResponse xmlns="http://ses.amazonaws.com/doc/2010-12-01/"><SendEmailResult><MessageId>%s</MessageId></SendEmailResult></SendEmailResponse>`,
+		uuid.NewString())}
+	m.HTTPTestFixture = test.NewHTTPTestFixture(t, func(t require.TestingT, request *http.Request) bool {
+		if err := request.ParseForm(); !assert.NoError(t, err) {
+			return false
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.sentTo = append(m.sentTo, request.PostForm.Get("Destination.ToAddresses.member.1"))
+		return assert.Equal(t, "SendEmail", request.PostForm.Get("Action"))
+	}, response)
+	return m
+}
+
+func (m *mockSES) recipients() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.sentTo...)
+}
@@ -3,23 +3,33 @@ package config
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
 	"github.com/pennsieve/rehydration-service/fargate/objects"
 	"github.com/pennsieve/rehydration-service/fargate/utils"
 	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
+	"github.com/pennsieve/rehydration-service/shared/audit"
 	"github.com/pennsieve/rehydration-service/shared/awsclient"
+	"github.com/pennsieve/rehydration-service/shared/deleteretry"
 	"github.com/pennsieve/rehydration-service/shared/expiration"
 	"github.com/pennsieve/rehydration-service/shared/idempotency"
 	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/manifest"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
 	"github.com/pennsieve/rehydration-service/shared/models"
 	"github.com/pennsieve/rehydration-service/shared/notification"
 	"github.com/pennsieve/rehydration-service/shared/s3cleaner"
 	"github.com/pennsieve/rehydration-service/shared/tracking"
 	"log/slog"
+	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -28,27 +38,79 @@ type Config struct {
 	pennsieveClient    *pennsieve.Client
 	idempotencyStore   idempotency.Store
 	objectProcessor    objects.Processor
+	destinationProber  objects.Prober
+	restorer           objects.Restorer
 	trackingStore      tracking.Store
+	deleteRetryStore   deleteretry.Store
+	auditStore         audit.Store
 	emailer            notification.Emailer
 	cleaner            s3cleaner.Cleaner
+	manifestWriter     manifest.Writer
+	manifestReader     manifest.Reader
 	s3ClientSupplier   *awsclient.Supplier[s3.Client, s3.Options]
 	dyDBClientSupplier *awsclient.Supplier[dynamodb.Client, dynamodb.Options]
 	sesClientSupplier  *awsclient.Supplier[ses.Client, ses.Options]
+	requestCounter     *accounting.RequestCounter
+	metrics            *metrics.Recorder
+	// sourceS3ClientSupplier reads the published datasets, which are in the task's own region, with the task's own
+	// credentials, unlike s3ClientSupplier
+	sourceS3ClientSupplier *awsclient.Supplier[s3.Client, s3.Options]
+	// auditS3ClientSupplier is for the task's own account and region, unlike s3ClientSupplier
+	auditS3ClientSupplier *awsclient.Supplier[s3.Client, s3.Options]
 }
 
 func NewConfig(awsConfig aws.Config, env *Env) *Config {
 	logger := logging.Default.With(
 		slog.Group("dataset", slog.Int("id", env.Dataset.ID), slog.Int("versionId", env.Dataset.VersionID)),
 		slog.Group("user", slog.String("name", env.User.Name), slog.String("email", env.User.Email)))
+	requestCounter := accounting.NewRequestCounter()
 	return &Config{
-		Env:                env,
-		Logger:             logger,
-		s3ClientSupplier:   awsclient.NewSupplier(s3.NewFromConfig, awsConfig),
+		Env:    env,
+		Logger: logger,
+		s3ClientSupplier: awsclient.NewSupplier(s3.NewFromConfig, awsConfig, func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, requestCounter.AddToStack)
+			// copies are sent to the destination bucket's region, which may not be the task's
+			if len(env.RehydrationRegion) > 0 {
+				o.Region = env.RehydrationRegion
+			}
+			// an external destination is written with the requester's role, not the task's. Sources are never read with
+			// it, see sourceS3ClientSupplier.
+			if len(env.RehydrationRoleARN) > 0 {
+				o.Credentials = assumeRoleCredentials(awsConfig, env)
+			}
+		}),
+		sourceS3ClientSupplier: awsclient.NewSupplier(s3.NewFromConfig, awsConfig, func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, requestCounter.AddToStack)
+		}),
 		dyDBClientSupplier: awsclient.NewSupplier(dynamodb.NewFromConfig, awsConfig),
 		sesClientSupplier:  awsclient.NewSupplier(ses.NewFromConfig, awsConfig),
+		requestCounter:     requestCounter,
+		metrics:            metrics.Default.With(metrics.Dimensions{metrics.ComponentDimension: "task"}),
+		// the audit bucket is in the task's own account and region, whatever the destination
+		auditS3ClientSupplier: awsclient.NewSupplier(s3.NewFromConfig, awsConfig),
 	}
 }
 
+// assumeRoleCredentials returns credentials for env.RehydrationRoleARN. They are refreshed as needed, so long copies
+// outlive the first set.
+func assumeRoleCredentials(awsConfig aws.Config, env *Env) aws.CredentialsProvider {
+	sessionName := fmt.Sprintf("rehydration-%d-%d", env.Dataset.ID, env.Dataset.VersionID)
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsConfig), env.RehydrationRoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+	})
+	return aws.NewCredentialsCache(provider)
+}
+
+// RequestCounter counts the requests made by the S3 clients used for reading sources, copying, and cleaning
+func (c *Config) RequestCounter() *accounting.RequestCounter {
+	return c.requestCounter
+}
+
+// Metrics is the Recorder for the task's metrics. Values it has not emitted yet must be flushed before the task exits.
+func (c *Config) Metrics() *metrics.Recorder {
+	return c.metrics
+}
+
 func (c *Config) PennsieveClient() *pennsieve.Client {
 	if c.pennsieveClient == nil {
 		c.pennsieveClient = pennsieve.NewClient(pennsieve.APIParams{ApiHost: c.Env.PennsieveHost})
@@ -82,10 +144,40 @@ func (c *Config) SetTrackingStore(store tracking.Store) {
 	c.trackingStore = store
 }
 
+// DeleteRetryStore returns nil if no delete retry table is configured
+func (c *Config) DeleteRetryStore() deleteretry.Store {
+	if c.deleteRetryStore == nil && len(c.Env.DeleteRetryTable) > 0 {
+		c.deleteRetryStore = deleteretry.NewStore(c.dyDBClientSupplier.Get(), c.Logger, c.Env.DeleteRetryTable)
+	}
+	return c.deleteRetryStore
+}
+
+// SetDeleteRetryStore is for use in tests that would like to override the real store with a mock implementation
+func (c *Config) SetDeleteRetryStore(store deleteretry.Store) {
+	c.deleteRetryStore = store
+}
+
+// AuditStore returns nil if rehydrations are not audited
+func (c *Config) AuditStore() audit.Store {
+	if c.auditStore == nil {
+		switch location := c.Env.Audit; {
+		case len(location.Table) > 0:
+			c.auditStore = audit.NewStore(c.dyDBClientSupplier.Get(), c.Logger, location.Table)
+		case len(location.Bucket) > 0:
+			c.auditStore = audit.NewS3Store(c.auditS3ClientSupplier.Get(), c.Logger, location.Bucket)
+		}
+	}
+	return c.auditStore
+}
+
+// SetAuditStore is for use in tests that would like to override the real store with a mock implementation
+func (c *Config) SetAuditStore(store audit.Store) {
+	c.auditStore = store
+}
+
 func (c *Config) ObjectProcessor(thresholdSize int64) objects.Processor {
 	if c.objectProcessor == nil {
-		s3Client := c.s3ClientSupplier.Get()
-		c.objectProcessor = objects.NewRehydrator(s3Client, thresholdSize, c.Logger)
+		c.objectProcessor = objects.NewRehydrator(c.s3ClientSupplier.Get(), c.sourceS3ClientSupplier.Get(), thresholdSize, c.CopyOptions(), c.Env.Destination().External(), c.Logger, c.metrics)
 	}
 	return c.objectProcessor
 }
@@ -95,9 +187,52 @@ func (c *Config) SetObjectProcessor(objectProcessor objects.Processor) {
 	c.objectProcessor = objectProcessor
 }
 
+// CopyOptions are the options set on copied objects
+func (c *Config) CopyOptions() utils.CopyOptions {
+	policy := c.Env.StoragePolicy
+	options := utils.CopyOptions{StorageClass: policy.StorageClass}
+	destination := c.Env.Destination()
+	if destination.External() {
+		// the requester's account should own the copies, whoever's credentials made them
+		options.ACL = s3types.ObjectCannedACLBucketOwnerFullControl
+	}
+	// an error means that nothing should be copied, which DatasetRehydrator checks before copying
+	options.KMSKeyID, _ = c.Env.KMSKeyID()
+	if policy.TagObjects {
+		options.Tags = c.Env.objectTags(time.Now())
+	}
+	return options
+}
+
+// DestinationProber returns nil unless the rehydration has an external destination whose write access needs checking
+func (c *Config) DestinationProber() objects.Prober {
+	if c.destinationProber == nil && c.Env.Destination().External() {
+		c.destinationProber = objects.NewS3Prober(c.s3ClientSupplier.Get(), c.CopyOptions().ACL)
+	}
+	return c.destinationProber
+}
+
+// SetDestinationProber is for use in tests that would like to override the real prober with a mock implementation
+func (c *Config) SetDestinationProber(prober objects.Prober) {
+	c.destinationProber = prober
+}
+
+func (c *Config) Restorer() objects.Restorer {
+	if c.restorer == nil {
+		policy := c.Env.RestorePolicy
+		c.restorer = objects.NewS3Restorer(c.sourceS3ClientSupplier.Get(), policy.Tier, policy.Days)
+	}
+	return c.restorer
+}
+
+// SetRestorer is for use in tests that would like to override the real restorer with a mock implementation
+func (c *Config) SetRestorer(restorer objects.Restorer) {
+	c.restorer = restorer
+}
+
 func (c *Config) Emailer() (notification.Emailer, error) {
 	if c.emailer == nil {
-		emailer, err := notification.NewEmailer(c.sesClientSupplier.Get(), c.Env.PennsieveDomain, c.Env.AWSRegion)
+		emailer, err := notification.NewEmailer(c.sesClientSupplier.Get(), c.Env.PennsieveDomain, c.Env.DestinationRegion())
 		if err != nil {
 			return nil, err
 		}
@@ -117,6 +252,8 @@ func (c *Config) Cleaner() (s3cleaner.Cleaner, error) {
 		if err != nil {
 			return nil, err
 		}
+		// the task only ever cleans its own rehydration location
+		cleaner.SetGuard(s3cleaner.NewGuard([]string{c.Env.RehydrationBucket}, c.Env.RehydrationPrefix, c.Env.CleanerMaxObjects, c.Logger))
 		c.cleaner = cleaner
 	}
 	return c.cleaner, nil
@@ -127,54 +264,185 @@ func (c *Config) SetCleaner(cleaner s3cleaner.Cleaner) {
 	c.cleaner = cleaner
 }
 
+func (c *Config) ManifestWriter() manifest.Writer {
+	if c.manifestWriter == nil {
+		c.manifestWriter = manifest.NewS3Writer(c.s3ClientSupplier.Get())
+	}
+	return c.manifestWriter
+}
+
+// SetManifestWriter is for use in tests that would like to override the real manifest writer with a mock implementation
+func (c *Config) SetManifestWriter(writer manifest.Writer) {
+	c.manifestWriter = writer
+}
+
+func (c *Config) ManifestReader() manifest.Reader {
+	if c.manifestReader == nil {
+		c.manifestReader = manifest.NewS3Reader(c.s3ClientSupplier.Get())
+	}
+	return c.manifestReader
+}
+
+// SetManifestReader is for use in tests that would like to override the real manifest reader with a mock implementation
+func (c *Config) SetManifestReader(reader manifest.Reader) {
+	c.manifestReader = reader
+}
+
 type Env struct {
-	Dataset            *models.Dataset
-	User               *models.User
-	TaskEnv            string
-	PennsieveHost      string
-	IdempotencyTable   string
-	TrackingTable      string
-	PennsieveDomain    string
-	AWSRegion          string
-	RehydrationBucket  string
+	Dataset          *models.Dataset
+	User             *models.User
+	TaskEnv          string
+	PennsieveHost    string
+	IdempotencyTable string
+	TrackingTable    string
+	// DeleteRetryTable is where objects that could not be deleted after a failure are saved. Empty if not configured.
+	DeleteRetryTable string
+	// Audit is where lifecycle events are recorded. Empty if rehydrations are not audited.
+	Audit             audit.Location
+	PennsieveDomain   string
+	AWSRegion         string
+	RehydrationBucket string
+	// RehydrationRegion is the region of RehydrationBucket if the request chose one other than AWSRegion. Empty otherwise.
+	RehydrationRegion string
+	// RehydrationPrefix is prepended to the keys of copied files. Empty unless the request has an external destination.
+	RehydrationPrefix string
+	// RehydrationRoleARN is the role assumed to write to RehydrationBucket if it is an external bucket. Empty otherwise.
+	RehydrationRoleARN string
 	RehydrationTTLDays int
+	// RequestID is the ID of the request that started the task. Empty if the service did not say.
+	RequestID     string
+	FailurePolicy FailurePolicy
+	RestorePolicy RestorePolicy
+	StoragePolicy StoragePolicy
+	DedupPolicy   DedupPolicy
+	// CleanerMaxObjects is the most objects the task will delete from its rehydration location. 0 means no limit.
+	CleanerMaxObjects int
+}
+
+// Destination returns where the request asked for the dataset to be rehydrated, or nil if it did not choose a
+// destination and RehydrationBucket is the default one
+func (e *Env) Destination() *models.Destination {
+	if len(e.RehydrationRegion) == 0 && len(e.RehydrationRoleARN) == 0 {
+		return nil
+	}
+	return &models.Destination{
+		Region:  e.RehydrationRegion,
+		Bucket:  e.RehydrationBucket,
+		Prefix:  e.RehydrationPrefix,
+		RoleARN: e.RehydrationRoleARN,
+	}
+}
+
+// RecordID is the ID of the idempotency record of this rehydration
+func (e *Env) RecordID() string {
+	return idempotency.DestinationRecordID(e.Dataset.ID, e.Dataset.VersionID, e.Destination())
+}
+
+// objectTags are the tags of files copied by a task started at now
+func (e *Env) objectTags(now time.Time) map[string]string {
+	tags := map[string]string{
+		DatasetIDTag:        strconv.Itoa(e.Dataset.ID),
+		DatasetVersionIDTag: strconv.Itoa(e.Dataset.VersionID),
+		ExpirationDateTag:   expiration.DateFrom(now, e.RehydrationTTLDays).UTC().Format(ExpirationDateTagLayout),
+	}
+	if len(e.RequestID) > 0 {
+		tags[RequestIDTag] = e.RequestID
+	}
+	return tags
+}
+
+// KMSKeyID returns the KMS key to encrypt copies into RehydrationBucket with, or an empty string for the bucket's
+// default encryption. It is an error if a key is required but there is none for the bucket's region.
+func (e *Env) KMSKeyID() (string, error) {
+	if len(e.RehydrationRoleARN) > 0 {
+		// an external bucket's encryption is up to the requester
+		return "", nil
+	}
+	return e.StoragePolicy.KMSKeyIDFor(e.RehydrationRegion)
+}
+
+// DestinationRegion returns the region of RehydrationBucket
+func (e *Env) DestinationRegion() string {
+	if len(e.RehydrationRegion) > 0 {
+		return e.RehydrationRegion
+	}
+	return e.AWSRegion
 }
 
 func LookupEnv() (*Env, error) {
-	env, err := shared.NonEmptyFromEnvVar(models.ECSTaskEnvKey)
+	return LookupEnvFrom(os.LookupEnv)
+}
+
+// LookupEnvFrom builds an Env from the values returned by lookup. Used to run the task in-process where
+// the os environment is shared.
+func LookupEnvFrom(lookup shared.LookupFunc) (*Env, error) {
+	env, err := shared.NonEmptyFromLookup(lookup, models.ECSTaskEnvKey)
 	if err != nil {
 		return nil, err
 	}
 	pennsieveHost := utils.GetApiHost(env)
-	idempotencyTable, err := shared.NonEmptyFromEnvVar(idempotency.TableNameKey)
+	idempotencyTable, err := shared.NonEmptyFromLookup(lookup, idempotency.TableNameKey)
+	if err != nil {
+		return nil, err
+	}
+	trackingTable, err := shared.NonEmptyFromLookup(lookup, tracking.TableNameKey)
+	if err != nil {
+		return nil, err
+	}
+	// optional, objects that cannot be deleted after a failure are only logged without it
+	deleteRetryTable, _ := lookup(deleteretry.TableNameKey)
+	auditLocation, err := audit.LocationFromLookup(lookup)
+	if err != nil {
+		return nil, err
+	}
+	pennsieveDomain, err := shared.NonEmptyFromLookup(lookup, notification.PennsieveDomainKey)
+	if err != nil {
+		return nil, err
+	}
+	awsRegion, err := shared.NonEmptyFromLookup(lookup, shared.AWSRegionKey)
+	if err != nil {
+		return nil, err
+	}
+	rehydrationBucket, err := shared.NonEmptyFromLookup(lookup, shared.RehydrationBucketKey)
+	if err != nil {
+		return nil, err
+	}
+	// optional, only set for requests that chose a destination outside the task's region
+	rehydrationRegion, _ := lookup(models.ECSTaskRehydrationRegionKey)
+	// optional, only set for requests with an external destination
+	rehydrationPrefix, _ := lookup(models.ECSTaskRehydrationPrefixKey)
+	rehydrationRoleARN, _ := lookup(models.ECSTaskRehydrationRoleARNKey)
+	// optional, older service versions and local runs do not set it
+	requestID, _ := lookup(models.ECSTaskRequestIDKey)
+	rehydrationTTLDays, err := shared.IntFromLookup(lookup, expiration.RehydrationTTLDays)
 	if err != nil {
 		return nil, err
 	}
-	trackingTable, err := shared.NonEmptyFromEnvVar(tracking.TableNameKey)
+	failurePolicy, err := failurePolicyFromLookup(lookup)
 	if err != nil {
 		return nil, err
 	}
-	pennsieveDomain, err := shared.NonEmptyFromEnvVar(notification.PennsieveDomainKey)
+	restorePolicy, err := restorePolicyFromLookup(lookup)
 	if err != nil {
 		return nil, err
 	}
-	awsRegion, err := shared.NonEmptyFromEnvVar(shared.AWSRegionKey)
+	storagePolicy, err := storagePolicyFromLookup(lookup)
 	if err != nil {
 		return nil, err
 	}
-	rehydrationBucket, err := shared.NonEmptyFromEnvVar(shared.RehydrationBucketKey)
+	dedupPolicy, err := dedupPolicyFromLookup(lookup)
 	if err != nil {
 		return nil, err
 	}
-	rehydrationTTLDays, err := shared.IntFromEnvVar(expiration.RehydrationTTLDays)
+	cleanerMaxObjects, err := s3cleaner.MaxObjectsFromLookup(lookup)
 	if err != nil {
 		return nil, err
 	}
-	dataset, err := datasetFromEnv()
+	dataset, err := datasetFromEnv(lookup)
 	if err != nil {
 		return nil, err
 	}
-	user, err := userFromEnv()
+	user, err := userFromEnv(lookup)
 	if err != nil {
 		return nil, err
 	}
@@ -185,15 +453,26 @@ func LookupEnv() (*Env, error) {
 		PennsieveHost:      pennsieveHost,
 		IdempotencyTable:   idempotencyTable,
 		TrackingTable:      trackingTable,
+		DeleteRetryTable:   deleteRetryTable,
+		Audit:              auditLocation,
 		PennsieveDomain:    pennsieveDomain,
 		AWSRegion:          awsRegion,
 		RehydrationBucket:  rehydrationBucket,
+		RehydrationRegion:  rehydrationRegion,
+		RehydrationPrefix:  rehydrationPrefix,
+		RehydrationRoleARN: rehydrationRoleARN,
 		RehydrationTTLDays: rehydrationTTLDays,
+		RequestID:          requestID,
+		FailurePolicy:      failurePolicy,
+		RestorePolicy:      restorePolicy,
+		StoragePolicy:      storagePolicy,
+		DedupPolicy:        dedupPolicy,
+		CleanerMaxObjects:  cleanerMaxObjects,
 	}, nil
 }
 
-func datasetFromEnv() (*models.Dataset, error) {
-	datasetIdString, err := shared.NonEmptyFromEnvVar(models.ECSTaskDatasetIDKey)
+func datasetFromEnv(lookup shared.LookupFunc) (*models.Dataset, error) {
+	datasetIdString, err := shared.NonEmptyFromLookup(lookup, models.ECSTaskDatasetIDKey)
 	if err != nil {
 		return nil, err
 	}
@@ -202,7 +481,7 @@ func datasetFromEnv() (*models.Dataset, error) {
 		return nil, fmt.Errorf("error converting env var %s value [%s] to int: %w",
 			models.ECSTaskDatasetIDKey, datasetIdString, err)
 	}
-	datasetVersionIdString, err := shared.NonEmptyFromEnvVar(models.ECSTaskDatasetVersionIDKey)
+	datasetVersionIdString, err := shared.NonEmptyFromLookup(lookup, models.ECSTaskDatasetVersionIDKey)
 	if err != nil {
 		return nil, err
 	}
@@ -217,12 +496,12 @@ func datasetFromEnv() (*models.Dataset, error) {
 	}, nil
 }
 
-func userFromEnv() (*models.User, error) {
-	userName, err := shared.NonEmptyFromEnvVar(models.ECSTaskUserNameKey)
+func userFromEnv(lookup shared.LookupFunc) (*models.User, error) {
+	userName, err := shared.NonEmptyFromLookup(lookup, models.ECSTaskUserNameKey)
 	if err != nil {
 		return nil, err
 	}
-	userEmail, err := shared.NonEmptyFromEnvVar(models.ECSTaskUserEmailKey)
+	userEmail, err := shared.NonEmptyFromLookup(lookup, models.ECSTaskUserEmailKey)
 	if err != nil {
 		return nil, err
 	}
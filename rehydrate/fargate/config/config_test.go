@@ -0,0 +1,61 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/pennsieve/rehydration-service/fargate/objects"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Restorer_SourceClient(t *testing.T) {
+	taskCredentials := credentials.NewStaticCredentialsProvider("task-key", "task-secret", "")
+	awsConfig := aws.Config{Region: "us-east-1", Credentials: taskCredentials}
+	env := &Env{
+		Dataset:            &models.Dataset{ID: 5065, VersionID: 2},
+		User:               &models.User{Name: "First Last", Email: "last@example.com"},
+		RehydrationBucket:  "my-lab-bucket",
+		RehydrationRegion:  "eu-west-1",
+		RehydrationRoleARN: "arn:aws:iam::123456789012:role/pennsieve-rehydration",
+	}
+	config := NewConfig(awsConfig, env)
+
+	// sources are in the task's region and read with the task's credentials, whatever the destination
+	restorer, ok := config.Restorer().(*objects.S3Restorer)
+	require.True(t, ok)
+	options := restorer.S3.Options()
+	assert.Equal(t, "us-east-1", options.Region)
+	assert.Equal(t, taskCredentials, options.Credentials)
+
+	destinationOptions := config.s3ClientSupplier.Get().Options()
+	assert.Equal(t, "eu-west-1", destinationOptions.Region)
+	assert.NotEqual(t, taskCredentials, destinationOptions.Credentials)
+}
+
+func TestConfig_ObjectProcessor_ExternalDestination(t *testing.T) {
+	taskCredentials := credentials.NewStaticCredentialsProvider("task-key", "task-secret", "")
+	awsConfig := aws.Config{Region: "us-east-1", Credentials: taskCredentials}
+	env := &Env{
+		Dataset:            &models.Dataset{ID: 5065, VersionID: 2},
+		User:               &models.User{Name: "First Last", Email: "last@example.com"},
+		RehydrationBucket:  "my-lab-bucket",
+		RehydrationRoleARN: "arn:aws:iam::123456789012:role/pennsieve-rehydration",
+	}
+	config := NewConfig(awsConfig, env)
+
+	// only writes use the requester's role, sources are read with the task's credentials
+	rehydrator, ok := config.ObjectProcessor(100).(*objects.Rehydrator)
+	require.True(t, ok)
+	assert.True(t, rehydrator.Stream)
+	assert.Equal(t, taskCredentials, rehydrator.SourceS3.Options().Credentials)
+	assert.NotEqual(t, taskCredentials, rehydrator.S3.Options().Credentials)
+
+	// copies within Pennsieve's buckets stay server-side
+	config = NewConfig(awsConfig, &Env{Dataset: env.Dataset, User: env.User, RehydrationBucket: "rehydration-bucket"})
+	rehydrator, ok = config.ObjectProcessor(100).(*objects.Rehydrator)
+	require.True(t, ok)
+	assert.False(t, rehydrator.Stream)
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"github.com/pennsieve/rehydration-service/shared"
+	"strconv"
+	"time"
+)
+
+// DedupAcrossVersionsKey turns on copying files that are unchanged since another version of the dataset from that
+// version's rehydration in the default rehydration bucket, instead of from the source objects
+const DedupAcrossVersionsKey = "DEDUP_ACROSS_VERSIONS"
+
+// DedupLeaseHoursKey is how long a rehydration that files are copied from is kept from expiring, so that it is not
+// deleted while the task is copying from it
+const DedupLeaseHoursKey = "DEDUP_LEASE_HOURS"
+
+const DefaultDedupLease = 24 * time.Hour
+
+// DedupPolicy decides whether the task copies unchanged files from other versions' rehydrations. The zero value turns
+// deduplication off.
+type DedupPolicy struct {
+	Enabled bool
+	Lease   time.Duration
+}
+
+// dedupPolicyFromLookup uses the default for any setting lookup does not find
+func dedupPolicyFromLookup(lookup shared.LookupFunc) (DedupPolicy, error) {
+	policy := DedupPolicy{Lease: DefaultDedupLease}
+	if enabled, set := lookup(DedupAcrossVersionsKey); set && len(enabled) > 0 {
+		value, err := strconv.ParseBool(enabled)
+		if err != nil {
+			return DedupPolicy{}, fmt.Errorf("error converting env var %s value [%s] to bool: %w", DedupAcrossVersionsKey, enabled, err)
+		}
+		policy.Enabled = value
+	}
+	if _, set := lookup(DedupLeaseHoursKey); set {
+		hours, err := shared.IntFromLookup(lookup, DedupLeaseHoursKey)
+		if err != nil {
+			return DedupPolicy{}, err
+		}
+		if hours < 1 {
+			return DedupPolicy{}, fmt.Errorf("%s must be positive", DedupLeaseHoursKey)
+		}
+		policy.Lease = time.Duration(hours) * time.Hour
+	}
+	return policy, nil
+}
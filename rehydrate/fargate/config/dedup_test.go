@@ -0,0 +1,28 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupPolicyFromLookup(t *testing.T) {
+	policy, err := dedupPolicyFromLookup(mapLookup(map[string]string{}))
+	require.NoError(t, err)
+	assert.Equal(t, DedupPolicy{Lease: DefaultDedupLease}, policy)
+
+	policy, err = dedupPolicyFromLookup(mapLookup(map[string]string{
+		DedupAcrossVersionsKey: "true",
+		DedupLeaseHoursKey:     "6",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, DedupPolicy{Enabled: true, Lease: 6 * time.Hour}, policy)
+
+	_, err = dedupPolicyFromLookup(mapLookup(map[string]string{DedupAcrossVersionsKey: "maybe"}))
+	assert.ErrorContains(t, err, DedupAcrossVersionsKey)
+
+	_, err = dedupPolicyFromLookup(mapLookup(map[string]string{DedupLeaseHoursKey: "0"}))
+	assert.ErrorContains(t, err, DedupLeaseHoursKey)
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"github.com/pennsieve/rehydration-service/shared"
+	"time"
+)
+
+// RetryAttemptsKey is the number of extra passes the task makes over files that failed to copy
+const RetryAttemptsKey = "FAILURE_RETRY_ATTEMPTS"
+
+// RetryBackoffSecondsKey is the wait before the first retry pass. The wait doubles before each later pass.
+const RetryBackoffSecondsKey = "FAILURE_RETRY_BACKOFF_SECONDS"
+
+// PartialMaxMissingFilesKey is the most files that may still be missing after all retries for the rehydration to be
+// kept as PARTIAL instead of being failed and cleaned up. Zero, the default, means partial rehydrations are not allowed.
+const PartialMaxMissingFilesKey = "PARTIAL_MAX_MISSING_FILES"
+
+const DefaultRetryAttempts = 2
+const DefaultRetryBackoff = 5 * time.Second
+const DefaultPartialMaxMissingFiles = 0
+
+// FailurePolicy decides what the task does with files that fail to copy. The zero value makes no retries and fails
+// the whole rehydration if any file fails.
+type FailurePolicy struct {
+	RetryAttempts int
+	RetryBackoff  time.Duration
+	// MaxMissingFiles is the most files that may be missing from a PARTIAL rehydration
+	MaxMissingFiles int
+}
+
+// Backoff returns how long to wait before the given retry pass, starting from 1
+func (p FailurePolicy) Backoff(attempt int) time.Duration {
+	return p.RetryBackoff * time.Duration(1<<(attempt-1))
+}
+
+// AllowsPartial returns true if a rehydration missing missingCount files should be kept as PARTIAL
+func (p FailurePolicy) AllowsPartial(missingCount int) bool {
+	return missingCount > 0 && missingCount <= p.MaxMissingFiles
+}
+
+// failurePolicyFromLookup uses the default for any setting lookup does not find
+func failurePolicyFromLookup(lookup shared.LookupFunc) (FailurePolicy, error) {
+	policy := FailurePolicy{
+		RetryAttempts:   DefaultRetryAttempts,
+		RetryBackoff:    DefaultRetryBackoff,
+		MaxMissingFiles: DefaultPartialMaxMissingFiles,
+	}
+	var err error
+	if _, set := lookup(RetryAttemptsKey); set {
+		if policy.RetryAttempts, err = shared.IntFromLookup(lookup, RetryAttemptsKey); err != nil {
+			return FailurePolicy{}, err
+		}
+	}
+	if _, set := lookup(RetryBackoffSecondsKey); set {
+		backoffSeconds, err := shared.IntFromLookup(lookup, RetryBackoffSecondsKey)
+		if err != nil {
+			return FailurePolicy{}, err
+		}
+		policy.RetryBackoff = time.Duration(backoffSeconds) * time.Second
+	}
+	if _, set := lookup(PartialMaxMissingFilesKey); set {
+		if policy.MaxMissingFiles, err = shared.IntFromLookup(lookup, PartialMaxMissingFilesKey); err != nil {
+			return FailurePolicy{}, err
+		}
+	}
+	if policy.RetryAttempts < 0 || policy.RetryBackoff < 0 || policy.MaxMissingFiles < 0 {
+		return FailurePolicy{}, fmt.Errorf("%s, %s, and %s must not be negative",
+			RetryAttemptsKey, RetryBackoffSecondsKey, PartialMaxMissingFilesKey)
+	}
+	return policy, nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"strconv"
+	"time"
+)
+
+// RestoreTierKey is the retrieval tier used to restore archived source objects: Expedited, Standard, or Bulk
+const RestoreTierKey = "RESTORE_TIER"
+
+// RestoreDaysKey is how many days restored copies of archived source objects are kept
+const RestoreDaysKey = "RESTORE_DAYS"
+
+// RestorePollMinutesKey is how long after finding restores unfinished the task checks them again
+const RestorePollMinutesKey = "RESTORE_POLL_MINUTES"
+
+// RestoreMaxWaitHoursKey is the longest the task waits for restores, from when it first requested them, before copying
+// anyway. Files that are still archived then fail to copy.
+const RestoreMaxWaitHoursKey = "RESTORE_MAX_WAIT_HOURS"
+
+const DefaultRestoreTier = s3types.TierStandard
+const DefaultRestoreDays = 3
+const DefaultRestorePollInterval = 15 * time.Minute
+
+// DefaultRestoreMaxWait is long enough for a Bulk restore from Deep Archive
+const DefaultRestoreMaxWait = 60 * time.Hour
+
+// RestorePolicy decides how the task restores source objects that have been archived
+type RestorePolicy struct {
+	Tier         s3types.Tier
+	Days         int32
+	PollInterval time.Duration
+	MaxWait      time.Duration
+	// Resumable is true if the task can exit while restores run and rely on the dispatcher to start it again. If false,
+	// the task keeps running and checks the restores every PollInterval.
+	Resumable bool
+}
+
+// restorePolicyFromLookup uses the default for any setting lookup does not find
+func restorePolicyFromLookup(lookup shared.LookupFunc) (RestorePolicy, error) {
+	policy := RestorePolicy{
+		Tier:         DefaultRestoreTier,
+		Days:         DefaultRestoreDays,
+		PollInterval: DefaultRestorePollInterval,
+		MaxWait:      DefaultRestoreMaxWait,
+	}
+	if tier, set := lookup(RestoreTierKey); set {
+		policy.Tier = s3types.Tier(tier)
+		if !validTier(policy.Tier) {
+			return RestorePolicy{}, fmt.Errorf("invalid %s %q: must be one of %v", RestoreTierKey, tier, policy.Tier.Values())
+		}
+	}
+	if _, set := lookup(RestoreDaysKey); set {
+		days, err := shared.IntFromLookup(lookup, RestoreDaysKey)
+		if err != nil {
+			return RestorePolicy{}, err
+		}
+		policy.Days = int32(days)
+	}
+	if _, set := lookup(RestorePollMinutesKey); set {
+		minutes, err := shared.IntFromLookup(lookup, RestorePollMinutesKey)
+		if err != nil {
+			return RestorePolicy{}, err
+		}
+		policy.PollInterval = time.Duration(minutes) * time.Minute
+	}
+	if _, set := lookup(RestoreMaxWaitHoursKey); set {
+		hours, err := shared.IntFromLookup(lookup, RestoreMaxWaitHoursKey)
+		if err != nil {
+			return RestorePolicy{}, err
+		}
+		policy.MaxWait = time.Duration(hours) * time.Hour
+	}
+	if resumable, set := lookup(models.ECSTaskResumeRestoresKey); set {
+		var err error
+		if policy.Resumable, err = strconv.ParseBool(resumable); err != nil {
+			return RestorePolicy{}, fmt.Errorf("invalid %s %q: %w", models.ECSTaskResumeRestoresKey, resumable, err)
+		}
+	}
+	if policy.Days < 1 || policy.PollInterval <= 0 || policy.MaxWait < 0 {
+		return RestorePolicy{}, fmt.Errorf("%s and %s must be positive and %s must not be negative",
+			RestoreDaysKey, RestorePollMinutesKey, RestoreMaxWaitHoursKey)
+	}
+	return policy, nil
+}
+
+func validTier(tier s3types.Tier) bool {
+	for _, valid := range tier.Values() {
+		if tier == valid {
+			return true
+		}
+	}
+	return false
+}
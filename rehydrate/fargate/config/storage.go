@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pennsieve/rehydration-service/shared"
+	"strconv"
+	"strings"
+)
+
+// StorageClassKey is the storage class of copied files, for example INTELLIGENT_TIERING. Defaults to the bucket's
+// default.
+const StorageClassKey = "DESTINATION_STORAGE_CLASS"
+
+// KMSKeyIDKey is the KMS key used to encrypt copied files with SSE-KMS. Defaults to the bucket's default encryption.
+// Only used for the default rehydration bucket, since a key belongs to one region and account.
+const KMSKeyIDKey = "DESTINATION_KMS_KEY_ID"
+
+// RegionKMSKeyIDsKey lists the KMS keys used for the rehydration buckets in other regions as comma-separated
+// region=key pairs, like REHYDRATION_REGION_BUCKETS. Optional, but if KMSKeyIDKey is set, a region without a key here
+// cannot be rehydrated into.
+const RegionKMSKeyIDsKey = "DESTINATION_REGION_KMS_KEY_IDS"
+
+// TagObjectsKey turns on tagging copied files with their dataset, request, and expiration date, so that S3 lifecycle
+// rules can find them
+const TagObjectsKey = "DESTINATION_TAG_OBJECTS"
+
+// Tags set on copied files if StoragePolicy.TagObjects is true
+const (
+	DatasetIDTag        = "rehydration:dataset-id"
+	DatasetVersionIDTag = "rehydration:dataset-version-id"
+	RequestIDTag        = "rehydration:request-id"
+	// ExpirationDateTag is the date, YYYY-MM-DD in UTC, that the rehydration was due to expire when the task ran.
+	// A later request for the same rehydration can extend it without changing the tag.
+	ExpirationDateTag = "rehydration:expiration-date"
+)
+
+// ExpirationDateTagLayout is the layout of ExpirationDateTag's values
+const ExpirationDateTagLayout = "2006-01-02"
+
+// StoragePolicy decides how copied files are stored
+type StoragePolicy struct {
+	StorageClass s3types.StorageClass
+	KMSKeyID     string
+	// RegionKMSKeyIDs has the KMS key for each region other than the default one that has a key
+	RegionKMSKeyIDs map[string]string
+	TagObjects      bool
+}
+
+// KMSKeyIDFor returns the KMS key for the rehydration bucket in region, which is empty for the default bucket, or an
+// empty string for the bucket's default encryption. It is an error for a region to have no key when the default
+// bucket has one, since the copies would not be encrypted as required.
+func (p StoragePolicy) KMSKeyIDFor(region string) (string, error) {
+	if len(region) == 0 {
+		return p.KMSKeyID, nil
+	}
+	if keyID, ok := p.RegionKMSKeyIDs[region]; ok {
+		return keyID, nil
+	}
+	if len(p.KMSKeyID) > 0 {
+		return "", fmt.Errorf("%s is set but %s has no key for region %s", KMSKeyIDKey, RegionKMSKeyIDsKey, region)
+	}
+	return "", nil
+}
+
+// storagePolicyFromLookup leaves the bucket's defaults in place for any setting lookup does not find
+func storagePolicyFromLookup(lookup shared.LookupFunc) (StoragePolicy, error) {
+	var policy StoragePolicy
+	if storageClass, set := lookup(StorageClassKey); set && len(storageClass) > 0 {
+		policy.StorageClass = s3types.StorageClass(storageClass)
+		if !validStorageClass(policy.StorageClass) {
+			return StoragePolicy{}, fmt.Errorf("invalid %s %q: must be one of %v", StorageClassKey, storageClass, policy.StorageClass.Values())
+		}
+	}
+	policy.KMSKeyID, _ = lookup(KMSKeyIDKey)
+	if regionKeyIDs, set := lookup(RegionKMSKeyIDsKey); set && len(strings.TrimSpace(regionKeyIDs)) > 0 {
+		policy.RegionKMSKeyIDs = map[string]string{}
+		for _, pair := range strings.Split(regionKeyIDs, ",") {
+			region, keyID, found := strings.Cut(strings.TrimSpace(pair), "=")
+			region, keyID = strings.TrimSpace(region), strings.TrimSpace(keyID)
+			if !found || len(region) == 0 || len(keyID) == 0 {
+				return StoragePolicy{}, fmt.Errorf("invalid entry %q in %s: expected region=key", pair, RegionKMSKeyIDsKey)
+			}
+			policy.RegionKMSKeyIDs[region] = keyID
+		}
+	}
+	if tagObjects, set := lookup(TagObjectsKey); set && len(tagObjects) > 0 {
+		value, err := strconv.ParseBool(tagObjects)
+		if err != nil {
+			return StoragePolicy{}, fmt.Errorf("error converting env var %s value [%s] to bool: %w", TagObjectsKey, tagObjects, err)
+		}
+		policy.TagObjects = value
+	}
+	return policy, nil
+}
+
+func validStorageClass(storageClass s3types.StorageClass) bool {
+	for _, valid := range storageClass.Values() {
+		if storageClass == valid {
+			return true
+		}
+	}
+	return false
+}
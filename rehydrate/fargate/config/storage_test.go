@@ -0,0 +1,126 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoragePolicyFromLookup(t *testing.T) {
+	policy, err := storagePolicyFromLookup(mapLookup(map[string]string{}))
+	require.NoError(t, err)
+	assert.Equal(t, StoragePolicy{}, policy)
+
+	policy, err = storagePolicyFromLookup(mapLookup(map[string]string{
+		StorageClassKey: "INTELLIGENT_TIERING",
+		KMSKeyIDKey:     "alias/rehydration",
+		TagObjectsKey:   "true",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, StoragePolicy{StorageClass: s3types.StorageClassIntelligentTiering, KMSKeyID: "alias/rehydration", TagObjects: true}, policy)
+
+	_, err = storagePolicyFromLookup(mapLookup(map[string]string{StorageClassKey: "COLD"}))
+	assert.ErrorContains(t, err, StorageClassKey)
+
+	_, err = storagePolicyFromLookup(mapLookup(map[string]string{TagObjectsKey: "sometimes"}))
+	assert.ErrorContains(t, err, TagObjectsKey)
+
+	policy, err = storagePolicyFromLookup(mapLookup(map[string]string{
+		RegionKMSKeyIDsKey: "eu-west-1=arn:aws:kms:eu-west-1:123456789012:key/eu, ap-southeast-2=alias/rehydration-ap",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"eu-west-1":      "arn:aws:kms:eu-west-1:123456789012:key/eu",
+		"ap-southeast-2": "alias/rehydration-ap",
+	}, policy.RegionKMSKeyIDs)
+
+	_, err = storagePolicyFromLookup(mapLookup(map[string]string{RegionKMSKeyIDsKey: "eu-west-1"}))
+	assert.ErrorContains(t, err, RegionKMSKeyIDsKey)
+}
+
+func TestConfig_CopyOptions(t *testing.T) {
+	policy := StoragePolicy{StorageClass: s3types.StorageClassIntelligentTiering, KMSKeyID: "alias/rehydration", TagObjects: true}
+	newEnv := func() *Env {
+		return &Env{
+			Dataset:            &models.Dataset{ID: 5065, VersionID: 2},
+			User:               &models.User{Name: "First Last", Email: "last@example.com"},
+			RehydrationBucket:  "rehydration-bucket",
+			RehydrationTTLDays: 14,
+			RequestID:          "request-1234",
+			StoragePolicy:      policy,
+		}
+	}
+
+	options := (&Config{Env: newEnv()}).CopyOptions()
+	assert.Equal(t, s3types.StorageClassIntelligentTiering, options.StorageClass)
+	assert.Equal(t, "alias/rehydration", options.KMSKeyID)
+	assert.Empty(t, options.ACL)
+	assert.Equal(t, "5065", options.Tags[DatasetIDTag])
+	assert.Equal(t, "2", options.Tags[DatasetVersionIDTag])
+	assert.Equal(t, "request-1234", options.Tags[RequestIDTag])
+	assert.Contains(t, options.Tags, ExpirationDateTag)
+
+	// our key cannot encrypt objects in someone else's bucket
+	externalEnv := newEnv()
+	externalEnv.RehydrationBucket = "requester-bucket"
+	externalEnv.RehydrationRoleARN = "arn:aws:iam::123456789012:role/rehydration-writer"
+	options = (&Config{Env: externalEnv}).CopyOptions()
+	assert.Equal(t, s3types.StorageClassIntelligentTiering, options.StorageClass)
+	assert.Empty(t, options.KMSKeyID)
+	assert.Equal(t, s3types.ObjectCannedACLBucketOwnerFullControl, options.ACL)
+	assert.NotEmpty(t, options.Tags)
+
+	// a bucket in another region is encrypted with that region's key
+	regionEnv := newEnv()
+	regionEnv.RehydrationBucket = "rehydration-eu"
+	regionEnv.RehydrationRegion = "eu-west-1"
+	regionEnv.StoragePolicy.RegionKMSKeyIDs = map[string]string{"eu-west-1": "alias/rehydration-eu"}
+	options = (&Config{Env: regionEnv}).CopyOptions()
+	assert.Equal(t, "alias/rehydration-eu", options.KMSKeyID)
+}
+
+func TestEnv_KMSKeyID(t *testing.T) {
+	env := &Env{
+		RehydrationBucket: "rehydration-eu",
+		RehydrationRegion: "eu-west-1",
+		StoragePolicy:     StoragePolicy{KMSKeyID: "alias/rehydration"},
+	}
+	// copies into the region cannot be encrypted as required
+	_, err := env.KMSKeyID()
+	assert.ErrorContains(t, err, "eu-west-1")
+
+	// but with no key at all, every bucket uses its default encryption
+	env.StoragePolicy.KMSKeyID = ""
+	keyID, err := env.KMSKeyID()
+	require.NoError(t, err)
+	assert.Empty(t, keyID)
+
+	// and the requester decides how an external bucket is encrypted
+	env.StoragePolicy.KMSKeyID = "alias/rehydration"
+	env.RehydrationRegion = ""
+	env.RehydrationRoleARN = "arn:aws:iam::123456789012:role/rehydration-writer"
+	keyID, err = env.KMSKeyID()
+	require.NoError(t, err)
+	assert.Empty(t, keyID)
+}
+
+func TestEnv_ObjectTags(t *testing.T) {
+	env := &Env{Dataset: &models.Dataset{ID: 5065, VersionID: 2}, RehydrationTTLDays: 14}
+	now := time.Date(2024, time.March, 25, 23, 30, 0, 0, time.UTC)
+	assert.Equal(t, map[string]string{
+		DatasetIDTag:        "5065",
+		DatasetVersionIDTag: "2",
+		ExpirationDateTag:   "2024-04-08",
+	}, env.objectTags(now))
+}
+
+func mapLookup(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
@@ -0,0 +1,49 @@
+package objects
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"strings"
+)
+
+// ProbeKeyName is the name of the object written by S3Prober. It is deleted once written.
+const ProbeKeyName = ".rehydration-probe"
+
+// Prober checks that a rehydration can write to its destination before any files are copied
+type Prober interface {
+	// Probe returns an error if an object cannot be written to bucket under keyPrefix
+	Probe(ctx context.Context, bucket string, keyPrefix string) error
+}
+
+type S3Prober struct {
+	S3  *s3.Client
+	ACL types.ObjectCannedACL
+}
+
+// NewS3Prober returns a Prober that writes, and then deletes, an empty object with the given ACL. acl should be the
+// one used for copies so that a bucket that enforces one rejects the probe in the same way it would reject copies.
+func NewS3Prober(s3Client *s3.Client, acl types.ObjectCannedACL) *S3Prober {
+	return &S3Prober{S3: s3Client, ACL: acl}
+}
+
+func (p *S3Prober) Probe(ctx context.Context, bucket string, keyPrefix string) error {
+	key := keyPrefix + ProbeKeyName
+	if _, err := p.S3.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(""),
+		ACL:    p.ACL,
+	}); err != nil {
+		return fmt.Errorf("error writing probe object s3://%s/%s: %w", bucket, key, err)
+	}
+	if _, err := p.S3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return fmt.Errorf("error deleting probe object s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
@@ -17,6 +17,11 @@ type Source interface {
 	GetPath() string
 	// GetCopySource returns a string to be used as the CopySource in AWS CopyObject or PartUploadCopy requests.
 	GetCopySource() string
+	// GetBucket, GetKey, and GetVersionID identify the source object for requests other than copies, for example
+	// HeadObject or RestoreObject. The key is not escaped.
+	GetBucket() string
+	GetKey() string
+	GetVersionID() string
 }
 
 // SourceLogGroup transforms a Source into a slog.Attr Group to be used for structured logging.
@@ -7,24 +7,38 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/pennsieve/rehydration-service/fargate/utils"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
 	"log/slog"
 )
 
 // rehydration processor - implements object processor
 type Rehydrator struct {
-	S3            *s3.Client
+	// S3 is a client for the destination's region, which copies are sent to
+	S3 *s3.Client
+	// SourceS3 is a client for the source's region, used to read source objects
+	SourceS3      *s3.Client
 	ThresholdSize int64
-	logger        *slog.Logger
+	Options       utils.CopyOptions
+	// Stream is true if S3 cannot read the sources in SourceS3's buckets, as when it writes to an external
+	// destination with the requester's role. Those sources are then read with SourceS3 and written with S3.
+	Stream  bool
+	logger  *slog.Logger
+	metrics *metrics.Recorder
 }
 
-func NewRehydrator(s3 *s3.Client, thresholdSize int64, logger *slog.Logger) Processor {
-	return &Rehydrator{s3, thresholdSize, logger}
+// NewRehydrator creates a Rehydrator. recorder may be nil if no metrics are wanted.
+func NewRehydrator(s3 *s3.Client, sourceS3 *s3.Client, thresholdSize int64, options utils.CopyOptions, stream bool, logger *slog.Logger, recorder *metrics.Recorder) Processor {
+	return &Rehydrator{s3, sourceS3, thresholdSize, options, stream, logger, recorder}
 }
 
 func (r *Rehydrator) Copy(ctx context.Context, src Source, dest Destination) error {
 	// file is less than 100MB ? simple copy : multiPart copy
 	copyLogger := r.logger.With(SourceLogGroup(src), DestinationLogGroup(dest))
 
+	if sourceS3 := r.sourceClient(src, dest); r.Stream && sourceS3 != r.S3 {
+		return r.stream(ctx, sourceS3, src, dest, copyLogger)
+	}
+
 	if src.GetSize() < r.ThresholdSize {
 		copyLogger.Info("simple copy")
 		params := s3.CopyObjectInput{
@@ -33,6 +47,7 @@ func (r *Rehydrator) Copy(ctx context.Context, src Source, dest Destination) err
 			Key:          aws.String(dest.GetKey()),
 			RequestPayer: types.RequestPayerRequester,
 		}
+		r.Options.ApplyToCopy(&params)
 
 		_, err := r.S3.CopyObject(ctx, &params)
 		if err != nil {
@@ -40,7 +55,13 @@ func (r *Rehydrator) Copy(ctx context.Context, src Source, dest Destination) err
 		}
 	} else {
 		copyLogger.Info("multipart copy")
-		err := utils.MultiPartCopy(ctx, r.S3, src.GetSize(), src.GetCopySource(), dest.GetBucket(), dest.GetKey(), copyLogger)
+		source := utils.VersionedSource{
+			Bucket:     src.GetBucket(),
+			Key:        src.GetKey(),
+			VersionID:  src.GetVersionID(),
+			CopySource: src.GetCopySource(),
+		}
+		err := utils.MultiPartCopy(ctx, r.S3, r.sourceClient(src, dest), src.GetSize(), source, dest.GetBucket(), dest.GetKey(), r.Options, copyLogger, r.metrics)
 		if err != nil {
 			return fmt.Errorf("error processing multipart copy for %s: %w", src.GetName(), err)
 		}
@@ -48,3 +69,34 @@ func (r *Rehydrator) Copy(ctx context.Context, src Source, dest Destination) err
 
 	return nil
 }
+
+// sourceClient returns the client that src is read with. Sources are in the publish buckets, except for files copied
+// from another rehydration in the destination bucket, which are in the destination's region.
+func (r *Rehydrator) sourceClient(src Source, dest Destination) *s3.Client {
+	if src.GetBucket() == dest.GetBucket() {
+		return r.S3
+	}
+	return r.SourceS3
+}
+
+// stream copies src by reading it with sourceS3 and writing it with r.S3
+func (r *Rehydrator) stream(ctx context.Context, sourceS3 *s3.Client, src Source, dest Destination, logger *slog.Logger) error {
+	source := utils.VersionedSource{
+		Bucket:     src.GetBucket(),
+		Key:        src.GetKey(),
+		VersionID:  src.GetVersionID(),
+		CopySource: src.GetCopySource(),
+	}
+	if src.GetSize() < r.ThresholdSize {
+		logger.Info("simple stream")
+		if err := utils.StreamCopy(ctx, r.S3, sourceS3, source, dest.GetBucket(), dest.GetKey(), r.Options); err != nil {
+			return fmt.Errorf("error processing simple stream for %s: %w", src.GetName(), err)
+		}
+		return nil
+	}
+	logger.Info("multipart stream")
+	if err := utils.MultiPartStream(ctx, r.S3, sourceS3, src.GetSize(), source, dest.GetBucket(), dest.GetKey(), r.Options, logger, r.metrics); err != nil {
+		return fmt.Errorf("error processing multipart stream for %s: %w", src.GetName(), err)
+	}
+	return nil
+}
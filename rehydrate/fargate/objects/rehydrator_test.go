@@ -0,0 +1,135 @@
+package objects
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/fargate/utils"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRehydrator_Copy_SameHeaders(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	sourceBucket := "test-headers-source-bucket"
+	sourceKey := "13/files/data.csv"
+	targetBucket := "test-headers-target-bucket"
+	content := strings.Repeat("a,b,c\n", 1024)
+
+	s3Fixture, putObjectOuts := test.NewS3Fixture(t,
+		s3.NewFromConfig(awsConfig),
+		&s3.CreateBucketInput{Bucket: aws.String(sourceBucket)},
+		&s3.CreateBucketInput{Bucket: aws.String(targetBucket)}).
+		WithVersioning(sourceBucket).
+		WithObjects(&s3.PutObjectInput{
+			Bucket:             aws.String(sourceBucket),
+			Key:                aws.String(sourceKey),
+			Body:               strings.NewReader(content),
+			ContentLength:      aws.Int64(int64(len(content))),
+			ContentType:        aws.String("text/csv"),
+			ContentDisposition: aws.String(`attachment; filename="data.csv"`),
+			ContentLanguage:    aws.String("en"),
+			CacheControl:       aws.String("max-age=3600"),
+			Metadata:           map[string]string{"origin": "pennsieve"},
+		})
+	defer s3Fixture.Teardown()
+	putObjectOut := putObjectOuts[test.S3Location{Bucket: sourceBucket, Key: sourceKey}]
+	require.NotNil(t, putObjectOut)
+
+	source := testSource{
+		bucket:    sourceBucket,
+		key:       sourceKey,
+		versionID: aws.ToString(putObjectOut.VersionId),
+		size:      int64(len(content)),
+	}
+	simpleKey := "13/2/simple/data.csv"
+	multipartKey := "13/2/multipart/data.csv"
+	simple := NewRehydrator(s3Fixture.Client, s3Fixture.Client, source.size+1, utils.CopyOptions{}, false, logging.Default, nil)
+	require.NoError(t, simple.Copy(ctx, source, testDestination{targetBucket, simpleKey}))
+	multipart := NewRehydrator(s3Fixture.Client, s3Fixture.Client, 1, utils.CopyOptions{}, false, logging.Default, nil)
+	require.NoError(t, multipart.Copy(ctx, source, testDestination{targetBucket, multipartKey}))
+	streamKey := "13/2/stream/data.csv"
+	stream := NewRehydrator(s3Fixture.Client, s3Fixture.Client, source.size+1, utils.CopyOptions{}, true, logging.Default, nil)
+	require.NoError(t, stream.Copy(ctx, source, testDestination{targetBucket, streamKey}))
+
+	simpleHead, err := s3Fixture.Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(targetBucket), Key: aws.String(simpleKey)})
+	require.NoError(t, err)
+	multipartHead, err := s3Fixture.Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(targetBucket), Key: aws.String(multipartKey)})
+	require.NoError(t, err)
+	streamHead, err := s3Fixture.Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(targetBucket), Key: aws.String(streamKey)})
+	require.NoError(t, err)
+
+	assert.Equal(t, "text/csv", aws.ToString(multipartHead.ContentType))
+	assert.Equal(t, map[string]string{"origin": "pennsieve"}, multipartHead.Metadata)
+	assert.Equal(t, simpleHead.ContentType, multipartHead.ContentType)
+	assert.Equal(t, simpleHead.ContentDisposition, multipartHead.ContentDisposition)
+	assert.Equal(t, simpleHead.ContentLanguage, multipartHead.ContentLanguage)
+	assert.Equal(t, simpleHead.CacheControl, multipartHead.CacheControl)
+	assert.Equal(t, simpleHead.Metadata, multipartHead.Metadata)
+	assert.Equal(t, aws.ToInt64(simpleHead.ContentLength), aws.ToInt64(multipartHead.ContentLength))
+	assert.Equal(t, simpleHead.ContentType, streamHead.ContentType)
+	assert.Equal(t, simpleHead.ContentDisposition, streamHead.ContentDisposition)
+	assert.Equal(t, simpleHead.Metadata, streamHead.Metadata)
+	assert.Equal(t, aws.ToInt64(simpleHead.ContentLength), aws.ToInt64(streamHead.ContentLength))
+}
+
+func TestRehydrator_sourceClient(t *testing.T) {
+	destinationClient, sourceClient := s3.New(s3.Options{Region: "eu-west-1"}), s3.New(s3.Options{Region: "us-east-1"})
+	rehydrator := &Rehydrator{S3: destinationClient, SourceS3: sourceClient}
+
+	assert.Same(t, sourceClient, rehydrator.sourceClient(testSource{bucket: "publish-bucket"}, testDestination{"rehydration-eu", "13/2/data.csv"}))
+	// a copy made by another rehydration into the destination bucket
+	assert.Same(t, destinationClient, rehydrator.sourceClient(testSource{bucket: "rehydration-eu"}, testDestination{"rehydration-eu", "13/2/data.csv"}))
+}
+
+type testSource struct {
+	bucket, key, versionID string
+	size                   int64
+}
+
+func (s testSource) GetSize() int64 {
+	return s.size
+}
+
+func (s testSource) GetName() string {
+	return s.key
+}
+
+func (s testSource) GetPath() string {
+	return s.key
+}
+
+func (s testSource) GetCopySource() string {
+	return fmt.Sprintf("%s/%s?versionId=%s", s.bucket, s.key, s.versionID)
+}
+
+func (s testSource) GetBucket() string {
+	return s.bucket
+}
+
+func (s testSource) GetKey() string {
+	return s.key
+}
+
+func (s testSource) GetVersionID() string {
+	return s.versionID
+}
+
+type testDestination struct {
+	bucket, key string
+}
+
+func (d testDestination) GetBucket() string {
+	return d.bucket
+}
+
+func (d testDestination) GetKey() string {
+	return d.key
+}
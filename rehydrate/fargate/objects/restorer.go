@@ -0,0 +1,182 @@
+package objects
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"strings"
+	"time"
+)
+
+// ArchiveStatus is whether a source object has to be restored before it can be copied
+type ArchiveStatus struct {
+	// StorageClass is the object's storage class or, if it was archived by S3 Intelligent-Tiering, its access tier
+	StorageClass string
+	// Archived is true if the object is in an archive storage class or access tier, whether it has been restored or not
+	Archived bool
+	// RestoreRequested is true if a restore has been requested, whether it has finished or not
+	RestoreRequested bool
+	// Restored is true if a restored copy of the archived object is available
+	Restored bool
+	// intelligentTiering is true if the object was archived by S3 Intelligent-Tiering instead of its storage class
+	intelligentTiering bool
+}
+
+// Copyable returns true if the object can be copied now
+func (s ArchiveStatus) Copyable() bool {
+	return !s.Archived || s.Restored
+}
+
+// deep returns true if the object is in one of the slowest archives
+func (s ArchiveStatus) deep() bool {
+	return s.StorageClass == string(types.StorageClassDeepArchive) ||
+		s.StorageClass == string(types.ArchiveStatusDeepArchiveAccess)
+}
+
+// Tier returns the tier a restore of the object will use if tier is requested. Expedited restores are not available
+// for Deep Archive or Intelligent-Tiering, so those use the Standard tier instead.
+func (s ArchiveStatus) Tier(tier types.Tier) types.Tier {
+	if tier == types.TierExpedited && (s.deep() || s.intelligentTiering) {
+		return types.TierStandard
+	}
+	return tier
+}
+
+// ExpectedRestoreTime is the time S3 typically takes to restore the object at tier
+func (s ArchiveStatus) ExpectedRestoreTime(tier types.Tier) time.Duration {
+	switch s.Tier(tier) {
+	case types.TierExpedited:
+		return 5 * time.Minute
+	case types.TierBulk:
+		if s.deep() {
+			return 48 * time.Hour
+		}
+		return 12 * time.Hour
+	default:
+		if s.deep() {
+			return 12 * time.Hour
+		}
+		return 5 * time.Hour
+	}
+}
+
+// ObjectVersion identifies a version of a source object
+type ObjectVersion struct {
+	Bucket    string
+	Key       string
+	VersionID string
+}
+
+// VersionOf returns the ObjectVersion of src
+func VersionOf(src Source) ObjectVersion {
+	return ObjectVersion{Bucket: src.GetBucket(), Key: src.GetKey(), VersionID: src.GetVersionID()}
+}
+
+// MayBeArchived returns false if an object listed with storageClass is certainly not archived. Intelligent-Tiering
+// objects may have been moved to an archive access tier, which only HeadObject reports.
+func MayBeArchived(storageClass string) bool {
+	switch types.StorageClass(storageClass) {
+	case types.StorageClassGlacier, types.StorageClassDeepArchive, types.StorageClassIntelligentTiering:
+		return true
+	default:
+		return false
+	}
+}
+
+// Restorer finds source objects that have been archived and restores them so that they can be copied
+type Restorer interface {
+	// StorageClasses lists the storage class of every version under prefix in bucket, so that Status is only needed
+	// for the versions that MayBeArchived
+	StorageClasses(ctx context.Context, bucket string, prefix string) (map[ObjectVersion]string, error)
+	Status(ctx context.Context, src Source) (ArchiveStatus, error)
+	// Restore requests a restore of an archived object. Succeeds if a restore is already in progress.
+	Restore(ctx context.Context, src Source, status ArchiveStatus) error
+}
+
+type S3Restorer struct {
+	S3   *s3.Client
+	Tier types.Tier
+	// Days is how long a restored copy is kept. The task only needs it long enough to copy it.
+	Days int32
+}
+
+func NewS3Restorer(s3Client *s3.Client, tier types.Tier, days int32) *S3Restorer {
+	return &S3Restorer{S3: s3Client, Tier: tier, Days: days}
+}
+
+func (r *S3Restorer) StorageClasses(ctx context.Context, bucket string, prefix string) (map[ObjectVersion]string, error) {
+	storageClasses := map[ObjectVersion]string{}
+	paginator := s3.NewListObjectVersionsPaginator(r.S3, &s3.ListObjectVersionsInput{
+		Bucket:       aws.String(bucket),
+		Prefix:       aws.String(prefix),
+		RequestPayer: types.RequestPayerRequester,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing storage classes under %s/%s: %w", bucket, prefix, err)
+		}
+		for _, version := range page.Versions {
+			key := ObjectVersion{Bucket: bucket, Key: aws.ToString(version.Key), VersionID: aws.ToString(version.VersionId)}
+			storageClasses[key] = string(version.StorageClass)
+		}
+	}
+	return storageClasses, nil
+}
+
+func (r *S3Restorer) Status(ctx context.Context, src Source) (ArchiveStatus, error) {
+	out, err := r.S3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(src.GetBucket()),
+		Key:          aws.String(src.GetKey()),
+		VersionId:    aws.String(src.GetVersionID()),
+		RequestPayer: types.RequestPayerRequester,
+	})
+	if err != nil {
+		return ArchiveStatus{}, fmt.Errorf("error getting storage class of %s: %w", src.GetCopySource(), err)
+	}
+	status := ArchiveStatus{StorageClass: string(out.StorageClass)}
+	switch {
+	case len(out.ArchiveStatus) > 0:
+		status.StorageClass = string(out.ArchiveStatus)
+		status.Archived = true
+		status.intelligentTiering = true
+	case out.StorageClass == types.StorageClassGlacier || out.StorageClass == types.StorageClassDeepArchive:
+		status.Archived = true
+	default:
+		// includes Glacier Instant Retrieval, which can be copied without a restore
+		return status, nil
+	}
+	// Restore is absent until a restore is requested, then `ongoing-request="true"` until it finishes
+	if restore := aws.ToString(out.Restore); len(restore) > 0 {
+		status.RestoreRequested = true
+		status.Restored = !strings.Contains(restore, `ongoing-request="true"`)
+	}
+	return status, nil
+}
+
+func (r *S3Restorer) Restore(ctx context.Context, src Source, status ArchiveStatus) error {
+	request := &types.RestoreRequest{GlacierJobParameters: &types.GlacierJobParameters{Tier: status.Tier(r.Tier)}}
+	if !status.intelligentTiering {
+		// Intelligent-Tiering moves restored objects back to a frequent access tier instead of keeping a copy
+		request.Days = aws.Int32(r.Days)
+	}
+	_, err := r.S3.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         aws.String(src.GetBucket()),
+		Key:            aws.String(src.GetKey()),
+		VersionId:      aws.String(src.GetVersionID()),
+		RequestPayer:   types.RequestPayerRequester,
+		RestoreRequest: request,
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+			return nil
+		}
+		return fmt.Errorf("error restoring %s: %w", src.GetCopySource(), err)
+	}
+	return nil
+}
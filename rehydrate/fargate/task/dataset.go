@@ -0,0 +1,299 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+	"github.com/pennsieve/rehydration-service/fargate/config"
+	"github.com/pennsieve/rehydration-service/fargate/objects"
+	"github.com/pennsieve/rehydration-service/fargate/utils"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/manifest"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+type DatasetRehydrator struct {
+	dataset           *models.Dataset
+	user              *models.User
+	pennsieveClient   *pennsieve.Client
+	processor         objects.Processor
+	logger            *slog.Logger
+	rehydrationBucket string
+	// keyPrefix is prepended to the keys of everything written to rehydrationBucket. Empty unless the destination is external.
+	keyPrefix string
+	// externalDestination is the location of an external destination. Empty if rehydrationBucket is one of ours.
+	externalDestination string
+	// prober checks write access to rehydrationBucket. May be nil if there is no need to check.
+	prober objects.Prober
+	// encryptionErr is non-nil if copies into rehydrationBucket cannot be encrypted as configured, so nothing is copied
+	encryptionErr      error
+	recordID           string
+	rehydrationTTLDays int
+	thresholdSize      int64
+	metrics            *metrics.Recorder
+	// progressStore is where progress is saved. May be nil if progress should not be saved.
+	progressStore    idempotency.Store
+	progressInterval time.Duration
+	failurePolicy    config.FailurePolicy
+	// restorer is nil if sources should be copied without checking whether they are archived
+	restorer      objects.Restorer
+	restorePolicy config.RestorePolicy
+	// onRestoring is called once the task knows that it has to wait for fileCount archived files. May be nil.
+	onRestoring func(ctx context.Context, fileCount int, expectedReadyAt time.Time)
+	// resumed is the Resume that the task was started again with after waiting for restores. Nil on the first run.
+	resumed *idempotency.Resume
+	// destination is nil if the request did not choose a destination
+	destination *models.Destination
+	// requestID is the ID of the request that started the task. Empty if the service did not say.
+	requestID string
+	// region is the region of rehydrationBucket if the request chose one other than the default. Empty otherwise.
+	region      string
+	dedupPolicy config.DedupPolicy
+	// manifestReader reads the manifests of other rehydrated versions of the dataset. May be nil if files should not be
+	// deduplicated.
+	manifestReader manifest.Reader
+}
+
+func NewDatasetRehydrator(config *config.Config, thresholdSize int64) *DatasetRehydrator {
+	var externalDestination string
+	if destination := config.Env.Destination(); destination.External() {
+		externalDestination = destination.Location()
+	}
+	_, encryptionErr := config.Env.KMSKeyID()
+	return &DatasetRehydrator{
+		dataset:             config.Env.Dataset,
+		user:                config.Env.User,
+		pennsieveClient:     config.PennsieveClient(),
+		processor:           config.ObjectProcessor(thresholdSize),
+		logger:              config.Logger,
+		rehydrationBucket:   config.Env.RehydrationBucket,
+		keyPrefix:           config.Env.RehydrationPrefix,
+		externalDestination: externalDestination,
+		prober:              config.DestinationProber(),
+		encryptionErr:       encryptionErr,
+		recordID:            config.Env.RecordID(),
+		rehydrationTTLDays:  config.Env.RehydrationTTLDays,
+		thresholdSize:       thresholdSize,
+		metrics:             config.Metrics(),
+		progressStore:       config.IdempotencyStore(),
+		progressInterval:    DefaultProgressInterval,
+		failurePolicy:       config.Env.FailurePolicy,
+		restorer:            config.Restorer(),
+		restorePolicy:       config.Env.RestorePolicy,
+		destination:         config.Env.Destination(),
+		requestID:           config.Env.RequestID,
+		region:              config.Env.RehydrationRegion,
+		dedupPolicy:         config.Env.DedupPolicy,
+		manifestReader:      config.ManifestReader(),
+	}
+}
+
+func (dr *DatasetRehydrator) rehydrate(ctx context.Context) (*RehydrationResult, error) {
+	dataset32 := int32(dr.dataset.ID)
+	version32 := int32(dr.dataset.VersionID)
+
+	if dr.encryptionErr != nil {
+		return nil, fmt.Errorf("refusing to copy into %s: %w", dr.rehydrationBucket, dr.encryptionErr)
+	}
+	if dr.prober != nil {
+		probeCtx, probeSpan := tracing.Start(ctx, "objects.Probe", attribute.String("destination.bucket", dr.rehydrationBucket))
+		err := dr.prober.Probe(probeCtx, dr.rehydrationBucket, dr.keyPrefix)
+		tracing.End(probeSpan, err)
+		if err != nil {
+			return nil, fmt.Errorf("error validating write access to destination %s: %w", dr.externalDestination, err)
+		}
+	}
+
+	metadataCtx, metadataSpan := tracing.Start(ctx, "discover.GetDatasetMetadataByVersion")
+	datasetMetadataByVersionResponse, err := dr.pennsieveClient.Discover.GetDatasetMetadataByVersion(metadataCtx, dataset32, version32)
+	tracing.End(metadataSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving dataset metadata by version: %w", err)
+	}
+
+	// create work
+	var rehydrations []*Rehydration
+	for _, j := range datasetMetadataByVersionResponse.Files {
+		if err != nil {
+			return nil, err
+		}
+		urlEscapedPath := utils.CreateURLEscapedPath(j.Path)
+		fileCtx, fileSpan := tracing.Start(ctx, "discover.GetDatasetFileByVersion", attribute.String("file.path", j.Path))
+		datasetFileByVersionResponse, err := dr.pennsieveClient.Discover.GetDatasetFileByVersion(
+			fileCtx, dataset32, version32, urlEscapedPath)
+		tracing.End(fileSpan, err)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving dataset file %s by version: %w", j.Path, err)
+		}
+		source, err := NewSourceObject(datasetFileByVersionResponse.Uri,
+			datasetFileByVersionResponse.Size,
+			datasetFileByVersionResponse.Name,
+			datasetFileByVersionResponse.S3VersionID,
+			j.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error creating Source for file %s: %w", j.Path, err)
+		}
+		rehydrations = append(rehydrations, NewRehydration(
+			source,
+			DestinationObject{
+				Bucket: dr.rehydrationBucket,
+				Key:    dr.destinationKey(j.Path),
+			}))
+	}
+	dr.deduplicate(ctx, rehydrations)
+	progress := newProgressReporter(dr.progressStore, dr.recordID, dr.progressInterval, dr.logger, rehydrations)
+	progress.start(ctx)
+	if resume := dr.waitForRestores(ctx, rehydrations, progress); resume != nil {
+		return &RehydrationResult{Resume: resume}, nil
+	}
+	dr.logger.Info("Starting Rehydration process")
+	// Only submit rehydrations once we know there are no GetDatasetFileByVersion errors
+	fileResults := dr.copyAll(ctx, rehydrations, progress.add)
+	dr.retryFailed(ctx, fileResults, progress)
+	progress.finish(ctx)
+
+	return &RehydrationResult{
+		Location:    fmt.Sprintf("s3://%s/%s", dr.rehydrationBucket, dr.locationPrefix()),
+		FileResults: fileResults,
+	}, nil
+}
+
+// locationPrefix is the prefix in rehydrationBucket of all the objects this rehydration writes
+func (dr *DatasetRehydrator) locationPrefix() string {
+	return dr.keyPrefix + utils.DestinationKeyPrefix(dr.dataset.ID, dr.dataset.VersionID)
+}
+
+// destinationKey is the key in rehydrationBucket of the copy of the file at path
+func (dr *DatasetRehydrator) destinationKey(path string) string {
+	return dr.keyPrefix + utils.DestinationKey(dr.dataset.ID, dr.dataset.VersionID, path)
+}
+
+// copyAll copies rehydrations with a pool of workers and returns a result for each one in the same order.
+// onResult is called with each result as it arrives.
+func (dr *DatasetRehydrator) copyAll(ctx context.Context, rehydrations []*Rehydration, onResult func(context.Context, FileRehydrationResult)) []FileRehydrationResult {
+	numberOfRehydrations := len(rehydrations)
+	rehydrationCh := make(chan *Rehydration, numberOfRehydrations)
+	results := make(chan FileRehydrationResult, numberOfRehydrations)
+
+	// create workers
+	NumConcurrentWorkers := 20
+	for i := 1; i <= NumConcurrentWorkers; i++ {
+		go worker(ctx, i, rehydrationCh, results, dr.processor, dr.copyLatencyObserver())
+	}
+
+	indexes := make(map[*Rehydration]int, numberOfRehydrations)
+	for i, rehydration := range rehydrations {
+		indexes[rehydration] = i
+		rehydrationCh <- rehydration
+	}
+	close(rehydrationCh)
+
+	fileResults := make([]FileRehydrationResult, numberOfRehydrations)
+	// wait for the done signal
+	for j := 1; j <= numberOfRehydrations; j++ {
+		result := <-results
+		fileResults[indexes[result.Rehydration]] = result
+		onResult(ctx, result)
+	}
+	return fileResults
+}
+
+// retryFailed makes up to failurePolicy.RetryAttempts more passes over the failed files in fileResults, waiting
+// failurePolicy.Backoff before each one. fileResults is updated in place with the result of the latest attempt.
+// Stops early if ctx is done.
+func (dr *DatasetRehydrator) retryFailed(ctx context.Context, fileResults []FileRehydrationResult, progress *progressReporter) {
+	for attempt := 1; attempt <= dr.failurePolicy.RetryAttempts; attempt++ {
+		var failedIndexes []int
+		var failed []*Rehydration
+		for i, result := range fileResults {
+			if result.Error != nil {
+				failedIndexes = append(failedIndexes, i)
+				failed = append(failed, result.Rehydration)
+			}
+		}
+		if len(failed) == 0 {
+			return
+		}
+		backoff := dr.failurePolicy.Backoff(attempt)
+		dr.logger.Info("retrying failed files",
+			slog.Int("attempt", attempt),
+			slog.Int("fileCount", len(failed)),
+			slog.Duration("backoff", backoff))
+		select {
+		case <-ctx.Done():
+			dr.logger.Warn("not retrying failed files", slog.Any("error", ctx.Err()))
+			return
+		case <-time.After(backoff):
+		}
+		for i, result := range dr.copyAll(ctx, failed, progress.retried) {
+			result.Attempts = fileResults[failedIndexes[i]].Attempts + 1
+			fileResults[failedIndexes[i]] = result
+		}
+		dr.metrics.Put("FileCopyRetries", metrics.Count, float64(len(failed)), nil)
+	}
+}
+
+// copyLatencyObserver records the time taken by successful copies, separately for simple and multipart copies since
+// they take very different times
+func (dr *DatasetRehydrator) copyLatencyObserver() func(r *Rehydration, latency time.Duration) {
+	return func(r *Rehydration, latency time.Duration) {
+		copyType := "simple"
+		if r.Src.GetSize() >= dr.thresholdSize {
+			copyType = "multipart"
+		}
+		dr.metrics.Observe("FileCopyLatency", metrics.Milliseconds, float64(latency.Milliseconds()), metrics.Dimensions{"CopyType": copyType})
+	}
+}
+
+// processes rehydrations
+func worker(ctx context.Context, w int, rehydrations <-chan *Rehydration, results chan<- FileRehydrationResult, processor objects.Processor,
+	observeLatency func(r *Rehydration, latency time.Duration)) {
+	for r := range rehydrations {
+		result := FileRehydrationResult{
+			Worker:      w,
+			Rehydration: r,
+			Attempts:    1,
+		}
+		start := time.Now()
+		copyCtx, span := tracing.Start(ctx, "objects.Copy",
+			attribute.String("file.path", r.Src.GetPath()),
+			attribute.Int64("file.size", r.Src.GetSize()),
+			attribute.Int("worker", w))
+		err := processor.Copy(copyCtx, r.Src, r.Dest)
+		if deduped, ok := r.Src.(*DedupedSource); ok && err != nil {
+			// the other version's copy may have expired or been cleaned up, so fall back to the published object
+			span.SetAttributes(attribute.String("dedup.fallbackError", err.Error()))
+			r.Src = deduped.SourceObject
+			err = processor.Copy(copyCtx, r.Src, r.Dest)
+		}
+		tracing.End(span, err)
+		if err != nil {
+			result.Error = err
+		} else {
+			observeLatency(r, time.Since(start))
+		}
+		results <- result
+
+	}
+}
+
+type RehydrationResult struct {
+	Location    string
+	FileResults []FileRehydrationResult
+	// Resume is set, and nothing has been copied, if the task should exit and be started again once archived files
+	// are restored
+	Resume *idempotency.Resume
+}
+type FileRehydrationResult struct {
+	Worker      int
+	Rehydration *Rehydration
+	Error       error
+	// Attempts is the number of times the file was copied, counting retries
+	Attempts int
+}
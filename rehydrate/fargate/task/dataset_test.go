@@ -0,0 +1,432 @@
+package task
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/fargate/config"
+	"github.com/pennsieve/rehydration-service/fargate/objects"
+	"github.com/pennsieve/rehydration-service/fargate/utils"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/test/discovertest"
+	"github.com/pennsieve/rehydration-service/shared/test/otlptest"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRehydrate(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	publishBucket := "discover-bucket"
+	taskEnv := newTestConfigEnv()
+	dataset := taskEnv.Dataset
+
+	datasetFileCount := 50
+	testDatasetFiles := discovertest.NewTestDatasetFiles(*dataset, datasetFileCount)
+
+	for testName, testParams := range map[string]struct {
+		thresholdSize int64
+	}{
+		"simple copies":    {thresholdSize: ThresholdSize},
+		"multipart copies": {thresholdSize: 10},
+	} {
+
+		t.Run(testName, func(t *testing.T) {
+			// Set up S3 for the tests
+			s3Fixture, putObjectOutputs := test.NewS3Fixture(t, s3.NewFromConfig(awsConfig),
+				&s3.CreateBucketInput{Bucket: aws.String(publishBucket)},
+				&s3.CreateBucketInput{Bucket: aws.String(taskEnv.RehydrationBucket)},
+			).WithVersioning(publishBucket).WithObjects(testDatasetFiles.PutObjectInputs(publishBucket)...)
+			defer s3Fixture.Teardown()
+
+			// Set S3 versionIds
+			for location, putOutput := range putObjectOutputs {
+				testDatasetFiles.SetS3VersionID(t, location, aws.ToString(putOutput.VersionId))
+			}
+
+			// Create a mock Discover API server
+			mockDiscover := discovertest.NewServerFixture(t, nil,
+				discovertest.GetDatasetMetadataByVersionHandlerBuilder(*dataset, testDatasetFiles.DatasetFiles()),
+				discovertest.GetDatasetFileByVersionHandlerBuilder(*dataset, publishBucket, testDatasetFiles.ByPath),
+			)
+			defer mockDiscover.Teardown()
+
+			taskEnv.PennsieveHost = mockDiscover.Server.URL
+			taskConfig := config.NewConfig(awsConfig, taskEnv)
+			rehydrator := NewDatasetRehydrator(taskConfig, testParams.thresholdSize)
+			rehydrationResult, err := rehydrator.rehydrate(ctx)
+			require.NoError(t, err)
+
+			assert.Equal(t, utils.RehydrationLocation(taskEnv.RehydrationBucket, dataset.ID, dataset.VersionID), rehydrationResult.Location)
+			assert.Len(t, rehydrationResult.FileResults, datasetFileCount)
+			for _, fileResult := range rehydrationResult.FileResults {
+				assert.NoError(t, fileResult.Error)
+				if assert.NotNil(t, fileResult.Rehydration) {
+					sourcePath := fileResult.Rehydration.Src.GetPath()
+					require.Contains(t, testDatasetFiles.ByPath, sourcePath)
+					expectedRehydratedKey := utils.DestinationKey(dataset.ID, dataset.VersionID, sourcePath)
+					assert.Equal(t, expectedRehydratedKey, fileResult.Rehydration.Dest.GetKey())
+				}
+			}
+
+			for _, datasetFile := range testDatasetFiles.Files {
+				expectedRehydratedKey := utils.DestinationKey(dataset.ID, dataset.VersionID, datasetFile.Path)
+				s3Fixture.AssertObjectExists(taskEnv.RehydrationBucket, expectedRehydratedKey, datasetFile.Size)
+			}
+		})
+	}
+}
+
+func TestRehydrate_Tracing(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+	collector := otlptest.NewCollector(t).Setenv(t)
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	shutdown, err := tracing.Init(context.Background(), "rehydration-task-test")
+	require.NoError(t, err)
+
+	// the trace context the service Lambda passes to the task
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := tracing.ContextFromLookup(context.Background(), func(key string) (string, bool) {
+		return traceParent, key == tracing.TraceParentKey
+	})
+
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	publishBucket := "discover-bucket"
+	taskEnv := newTestConfigEnv()
+	dataset := taskEnv.Dataset
+	datasetFileCount := 3
+	testDatasetFiles := discovertest.NewTestDatasetFiles(*dataset, datasetFileCount)
+
+	s3Fixture, putObjectOutputs := test.NewS3Fixture(t, s3.NewFromConfig(awsConfig),
+		&s3.CreateBucketInput{Bucket: aws.String(publishBucket)},
+		&s3.CreateBucketInput{Bucket: aws.String(taskEnv.RehydrationBucket)},
+	).WithVersioning(publishBucket).WithObjects(testDatasetFiles.PutObjectInputs(publishBucket)...)
+	defer s3Fixture.Teardown()
+	for location, putOutput := range putObjectOutputs {
+		testDatasetFiles.SetS3VersionID(t, location, aws.ToString(putOutput.VersionId))
+	}
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetMetadataByVersionHandlerBuilder(*dataset, testDatasetFiles.DatasetFiles()),
+		discovertest.GetDatasetFileByVersionHandlerBuilder(*dataset, publishBucket, testDatasetFiles.ByPath),
+	)
+	defer mockDiscover.Teardown()
+
+	taskEnv.PennsieveHost = mockDiscover.Server.URL
+	rehydrator := NewDatasetRehydrator(config.NewConfig(awsConfig, taskEnv), ThresholdSize)
+	_, err = rehydrator.rehydrate(ctx)
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+
+	spanCounts := map[string]int{}
+	for _, span := range collector.Spans() {
+		spanCounts[span.GetName()]++
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(span.GetTraceId()))
+	}
+	assert.Equal(t, map[string]int{
+		"discover.GetDatasetMetadataByVersion": 1,
+		"discover.GetDatasetFileByVersion":     datasetFileCount,
+		"objects.Copy":                         datasetFileCount,
+		"task.RestoreArchived":                 1,
+	}, spanCounts)
+}
+
+func TestRehydrate_S3Errors(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	publishBucket := "discover-bucket"
+	taskEnv := newTestConfigEnv()
+	dataset := taskEnv.Dataset
+
+	testDatasetFileCount := 101
+	testDatasetFiles := discovertest.NewTestDatasetFiles(*dataset, testDatasetFileCount).WithFakeS3VersionsIDs()
+	var copyFailPaths []string
+	for i, file := range testDatasetFiles.Files {
+		// S3 Failure every 10th file
+		if i%10 == 0 {
+			copyFailPaths = append(copyFailPaths, file.Path)
+		}
+	}
+
+	// Set up S3 for the tests
+	s3Client := s3.NewFromConfig(awsConfig)
+	s3Fixture, putObjectOutputs := test.NewS3Fixture(t, s3Client,
+		&s3.CreateBucketInput{Bucket: aws.String(publishBucket)},
+		&s3.CreateBucketInput{Bucket: aws.String(taskEnv.RehydrationBucket)},
+	).WithVersioning(publishBucket).WithObjects(testDatasetFiles.PutObjectInputs(publishBucket)...)
+	defer s3Fixture.Teardown()
+
+	// Set S3 versionIds
+	for location, putOutput := range putObjectOutputs {
+		testDatasetFiles.SetS3VersionID(t, location, aws.ToString(putOutput.VersionId))
+	}
+
+	// Create a mock Discover API server
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetMetadataByVersionHandlerBuilder(*dataset, testDatasetFiles.DatasetFiles()),
+		discovertest.GetDatasetFileByVersionHandlerBuilder(*dataset, publishBucket, testDatasetFiles.ByPath),
+	)
+
+	defer mockDiscover.Teardown()
+
+	taskEnv.PennsieveHost = mockDiscover.Server.URL
+
+	taskConfig := config.NewConfig(awsConfig, taskEnv)
+	mockProcessor := NewMockFailingObjectProcessor(s3Client, copyFailPaths...)
+	taskConfig.SetObjectProcessor(mockProcessor)
+
+	rehydrator := NewDatasetRehydrator(taskConfig, ThresholdSize)
+
+	result, err := rehydrator.rehydrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, utils.RehydrationLocation(taskEnv.RehydrationBucket, dataset.ID, dataset.VersionID), result.Location)
+	assert.Len(t, result.FileResults, testDatasetFileCount)
+	for _, fileResult := range result.FileResults {
+		require.NotNil(t, fileResult.Rehydration)
+		sourcePath := fileResult.Rehydration.Src.GetPath()
+		if _, failed := mockProcessor.FailOnPaths[sourcePath]; failed {
+			assert.Error(t, fileResult.Error)
+		} else {
+			assert.NoError(t, fileResult.Error)
+		}
+	}
+
+}
+
+func TestRehydrate_RetryFailed(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+	taskEnv := newTestConfigEnv()
+	taskEnv.FailurePolicy = config.FailurePolicy{RetryAttempts: 2, RetryBackoff: time.Millisecond}
+	dataset := taskEnv.Dataset
+	testDatasetFiles := discovertest.NewTestDatasetFiles(*dataset, 5).WithFakeS3VersionsIDs()
+	recoveredPath := testDatasetFiles.Files[1].Path
+	missingPath := testDatasetFiles.Files[3].Path
+
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetMetadataByVersionHandlerBuilder(*dataset, testDatasetFiles.DatasetFiles()),
+		discovertest.GetDatasetFileByVersionHandlerBuilder(*dataset, "discover-bucket", testDatasetFiles.ByPath),
+	)
+	defer mockDiscover.Teardown()
+	taskEnv.PennsieveHost = mockDiscover.Server.URL
+
+	for testName, testParams := range map[string]struct {
+		cancelled        bool
+		expectedAttempts map[string]int
+		expectedFailed   []string
+	}{
+		"retries": {
+			expectedAttempts: map[string]int{recoveredPath: 2, missingPath: 3},
+			expectedFailed:   []string{missingPath},
+		},
+		"cancelled": {
+			cancelled:        true,
+			expectedAttempts: map[string]int{recoveredPath: 1, missingPath: 1},
+			expectedFailed:   []string{recoveredPath, missingPath},
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			processor := newFlakyObjectProcessor(map[string]int{recoveredPath: 1, missingPath: 10})
+			if testParams.cancelled {
+				processor.afterCopy = cancel
+			}
+			progressStore := &fakeProgressStore{}
+			taskConfig := config.NewConfig(test.NewAWSEndpoints(t).Config(ctx, false), taskEnv)
+			taskConfig.SetObjectProcessor(processor)
+			taskConfig.SetIdempotencyStore(progressStore)
+			taskConfig.SetRestorer(newFakeRestorer(nil))
+
+			result, err := NewDatasetRehydrator(taskConfig, ThresholdSize).rehydrate(ctx)
+			require.NoError(t, err)
+			require.Len(t, result.FileResults, len(testDatasetFiles.Files))
+
+			var failed []string
+			var expectedBytesDone int64
+			for i, fileResult := range result.FileResults {
+				path := fileResult.Rehydration.Src.GetPath()
+				// results are in the same order as the dataset files
+				assert.Equal(t, testDatasetFiles.Files[i].Path, path)
+				expectedAttempts, retried := testParams.expectedAttempts[path]
+				if !retried {
+					expectedAttempts = 1
+				}
+				assert.Equal(t, expectedAttempts, fileResult.Attempts, path)
+				assert.Equal(t, expectedAttempts, processor.attempts[path], path)
+				if fileResult.Error != nil {
+					failed = append(failed, path)
+				} else {
+					expectedBytesDone += fileResult.Rehydration.Src.GetSize()
+				}
+			}
+			assert.Equal(t, testParams.expectedFailed, failed)
+
+			require.NotEmpty(t, progressStore.saved)
+			finalProgress := progressStore.saved[len(progressStore.saved)-1]
+			assert.Equal(t, len(testDatasetFiles.Files), finalProgress.FilesDone)
+			assert.Equal(t, len(testParams.expectedFailed), finalProgress.FilesFailed)
+			assert.Equal(t, expectedBytesDone, finalProgress.BytesDone)
+		})
+	}
+}
+
+// flakyObjectProcessor fails the first failures[path] copies of each path without touching S3
+type flakyObjectProcessor struct {
+	mu        sync.Mutex
+	failures  map[string]int
+	attempts  map[string]int
+	afterCopy func()
+}
+
+func newFlakyObjectProcessor(failures map[string]int) *flakyObjectProcessor {
+	return &flakyObjectProcessor{failures: failures, attempts: map[string]int{}, afterCopy: func() {}}
+}
+
+func (p *flakyObjectProcessor) Copy(_ context.Context, source objects.Source, _ objects.Destination) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.afterCopy()
+	path := source.GetPath()
+	p.attempts[path]++
+	if p.attempts[path] <= p.failures[path] {
+		return fmt.Errorf("error copying %s", path)
+	}
+	return nil
+}
+
+func TestRehydrate_DiscoverErrors(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).Config(ctx, false)
+	publishBucket := "discover-bucket"
+	taskEnv := newTestConfigEnv()
+	dataset := taskEnv.Dataset
+
+	testDatasetFiles := discovertest.NewTestDatasetFiles(*dataset, 50).WithFakeS3VersionsIDs()
+	pathsToFail := map[string]bool{testDatasetFiles.Files[24].Path: true}
+
+	for testName, testParams := range map[string]struct {
+		discoverBuilders []*test.HandlerFuncBuilder
+	}{
+		"get dataset metadata error": {discoverBuilders: []*test.HandlerFuncBuilder{
+			discovertest.ErrorGetDatasetMetadataByVersionHandlerBuilder(*dataset, "internal service error", http.StatusInternalServerError)},
+		},
+		"get dataset file error": {discoverBuilders: []*test.HandlerFuncBuilder{
+			discovertest.GetDatasetMetadataByVersionHandlerBuilder(*dataset, testDatasetFiles.DatasetFiles()),
+			discovertest.ErrorGetDatasetFileByVersionHandlerBuilder(*dataset, publishBucket, testDatasetFiles.DatasetFilesByPath(), pathsToFail),
+		}},
+	} {
+
+		t.Run(testName, func(t *testing.T) {
+			// Create a mock Discover API server
+			mockDiscover := discovertest.NewServerFixture(t, nil, testParams.discoverBuilders...)
+			defer mockDiscover.Teardown()
+
+			taskEnv.PennsieveHost = mockDiscover.Server.URL
+			taskConfig := config.NewConfig(awsConfig, taskEnv)
+			// No calls should be made to S3
+			taskConfig.SetObjectProcessor(NewNoCallsObjectProcessor(t))
+
+			rehydrator := NewDatasetRehydrator(taskConfig, ThresholdSize)
+
+			_, err := rehydrator.rehydrate(ctx)
+			require.Error(t, err)
+		})
+
+	}
+}
+
+func TestRehydrate_ExternalDestination(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+	ctx := context.Background()
+	taskEnv := newTestConfigEnv()
+	taskEnv.RehydrationBucket = "requester-bucket"
+	taskEnv.RehydrationPrefix = "deliveries/"
+	taskEnv.RehydrationRoleARN = "arn:aws:iam::123456789012:role/rehydration-writer"
+	dataset := taskEnv.Dataset
+	testDatasetFiles := discovertest.NewTestDatasetFiles(*dataset, 5).WithFakeS3VersionsIDs()
+
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetMetadataByVersionHandlerBuilder(*dataset, testDatasetFiles.DatasetFiles()),
+		discovertest.GetDatasetFileByVersionHandlerBuilder(*dataset, "discover-bucket", testDatasetFiles.ByPath),
+	)
+	defer mockDiscover.Teardown()
+	taskEnv.PennsieveHost = mockDiscover.Server.URL
+
+	t.Run("probe succeeds", func(t *testing.T) {
+		prober := &fakeProber{}
+		taskConfig := config.NewConfig(test.NewAWSEndpoints(t).Config(ctx, false), taskEnv)
+		taskConfig.SetObjectProcessor(newFlakyObjectProcessor(nil))
+		taskConfig.SetIdempotencyStore(&fakeProgressStore{})
+		taskConfig.SetRestorer(newFakeRestorer(nil))
+		taskConfig.SetDestinationProber(prober)
+
+		rehydrator := NewDatasetRehydrator(taskConfig, ThresholdSize)
+		assert.Equal(t, "s3://requester-bucket/deliveries/", rehydrator.externalDestination)
+		assert.Equal(t, taskEnv.RecordID(), rehydrator.recordID)
+
+		result, err := rehydrator.rehydrate(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"requester-bucket deliveries/"}, prober.probed)
+		assert.Equal(t, fmt.Sprintf("s3://requester-bucket/deliveries/%d/%d/", dataset.ID, dataset.VersionID), result.Location)
+		require.Len(t, result.FileResults, len(testDatasetFiles.Files))
+		for _, fileResult := range result.FileResults {
+			assert.NoError(t, fileResult.Error)
+			dest := fileResult.Rehydration.Dest
+			assert.Equal(t, "requester-bucket", dest.GetBucket())
+			assert.Equal(t, "deliveries/"+utils.DestinationKey(dataset.ID, dataset.VersionID, fileResult.Rehydration.Src.GetPath()), dest.GetKey())
+		}
+	})
+
+	t.Run("probe fails", func(t *testing.T) {
+		taskConfig := config.NewConfig(test.NewAWSEndpoints(t).Config(ctx, false), taskEnv)
+		// nothing should be copied if the destination cannot be written to
+		taskConfig.SetObjectProcessor(NewNoCallsObjectProcessor(t))
+		taskConfig.SetIdempotencyStore(&fakeProgressStore{})
+		taskConfig.SetRestorer(newFakeRestorer(nil))
+		taskConfig.SetDestinationProber(&fakeProber{err: errors.New("access denied")})
+
+		_, err := NewDatasetRehydrator(taskConfig, ThresholdSize).rehydrate(ctx)
+		assert.ErrorContains(t, err, "error validating write access to destination s3://requester-bucket/deliveries/")
+		assert.ErrorContains(t, err, "access denied")
+	})
+}
+
+func TestRehydrate_RegionWithoutKMSKey(t *testing.T) {
+	ctx := context.Background()
+	taskEnv := newTestConfigEnv()
+	taskEnv.RehydrationBucket = "rehydration-eu"
+	taskEnv.RehydrationRegion = "eu-west-1"
+	taskEnv.StoragePolicy.KMSKeyID = "alias/rehydration"
+	taskConfig := config.NewConfig(test.NewAWSEndpoints(t).Config(ctx, false), taskEnv)
+	// nothing should be copied without the encryption the default bucket gets
+	taskConfig.SetObjectProcessor(NewNoCallsObjectProcessor(t))
+	taskConfig.SetIdempotencyStore(&fakeProgressStore{})
+	taskConfig.SetRestorer(newFakeRestorer(nil))
+
+	_, err := NewDatasetRehydrator(taskConfig, ThresholdSize).rehydrate(ctx)
+	assert.ErrorContains(t, err, "refusing to copy into rehydration-eu")
+	assert.ErrorContains(t, err, config.RegionKMSKeyIDsKey)
+}
+
+// fakeProber records the destinations it probes and returns err for all of them
+type fakeProber struct {
+	probed []string
+	err    error
+}
+
+func (p *fakeProber) Probe(_ context.Context, bucket string, keyPrefix string) error {
+	p.probed = append(p.probed, bucket+" "+keyPrefix)
+	return p.err
+}
@@ -0,0 +1,173 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pennsieve/rehydration-service/fargate/utils"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/manifest"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DedupedSource is a SourceObject that is copied from the copy made by a rehydration of another version of the
+// dataset, rather than from the published object. The published object is unchanged between the two versions.
+type DedupedSource struct {
+	*SourceObject
+	RehydratedBucket string
+	RehydratedKey    string
+}
+
+func (s *DedupedSource) GetCopySource() string {
+	return s.RehydratedBucket + *utils.CreateAWSEscapedPath("/" + s.RehydratedKey)
+}
+
+func (s *DedupedSource) GetBucket() string {
+	return s.RehydratedBucket
+}
+
+func (s *DedupedSource) GetKey() string {
+	return s.RehydratedKey
+}
+
+// GetVersionID returns an empty string since rehydration buckets are not versioned
+func (s *DedupedSource) GetVersionID() string {
+	return ""
+}
+
+// Location returns the S3 URI of the rehydrated copy
+func (s *DedupedSource) Location() string {
+	return fmt.Sprintf("s3://%s/%s", s.RehydratedBucket, s.RehydratedKey)
+}
+
+// sourceIdentity identifies a published object across versions of a dataset
+type sourceIdentity struct {
+	uri       string
+	versionID string
+	size      int64
+}
+
+// rehydratedCopy is a file copied by a rehydration of another version of the dataset
+type rehydratedCopy struct {
+	versionID int
+	key       string
+}
+
+// deduplicate replaces the source of each rehydration whose published object is unchanged since another rehydrated
+// version of the dataset with that version's copy. Each version that supplies files is referenced for
+// dedupPolicy.Lease so that it does not expire while this task copies from it, and files of a version that cannot be
+// referenced are copied from the published objects. Does nothing unless dedupPolicy is enabled and the destination is
+// one of ours. Any error only means that fewer files are deduplicated.
+func (dr *DatasetRehydrator) deduplicate(ctx context.Context, rehydrations []*Rehydration) {
+	if !dr.dedupPolicy.Enabled || len(dr.externalDestination) > 0 || dr.progressStore == nil || dr.manifestReader == nil {
+		return
+	}
+	ctx, span := tracing.Start(ctx, "task.Deduplicate")
+	defer span.End()
+
+	copies := dr.rehydratedCopies(ctx)
+	byVersion := map[int][]*Rehydration{}
+	for _, r := range rehydrations {
+		src, ok := r.Src.(*SourceObject)
+		if !ok || len(src.VersionId) == 0 {
+			continue
+		}
+		if c, found := copies[sourceIdentity{uri: src.DatasetUri, versionID: src.VersionId, size: src.Size}]; found {
+			r.Src = &DedupedSource{SourceObject: src, RehydratedBucket: dr.rehydrationBucket, RehydratedKey: c.key}
+			byVersion[c.versionID] = append(byVersion[c.versionID], r)
+		}
+	}
+	var deduped int
+	until := time.Now().Add(dr.dedupPolicy.Lease)
+	for versionID, versionRehydrations := range byVersion {
+		if err := dr.progressStore.Reference(ctx, idempotency.RecordID(dr.dataset.ID, versionID), until); err != nil {
+			dr.logger.Warn("error referencing other rehydrated version, copying its files from the published objects",
+				slog.Int("otherVersionId", versionID),
+				slog.Any("error", err))
+			for _, r := range versionRehydrations {
+				r.Src = r.Src.(*DedupedSource).SourceObject
+			}
+			continue
+		}
+		deduped += len(versionRehydrations)
+	}
+	span.SetAttributes(attribute.Int("dedup.files", deduped), attribute.Int("dedup.versions", len(byVersion)))
+	if deduped > 0 {
+		dr.logger.Info("copying unchanged files from other rehydrated versions", slog.Int("fileCount", deduped))
+		dr.metrics.Put("FilesDeduplicated", metrics.Count, float64(deduped), nil)
+	}
+}
+
+// rehydratedCopies returns the copies made by completed rehydrations of other versions of the dataset, by the identity
+// of the published object copied. Newer versions are preferred.
+func (dr *DatasetRehydrator) rehydratedCopies(ctx context.Context) map[sourceIdentity]rehydratedCopy {
+	manifestKeys, err := dr.manifestReader.Keys(ctx, dr.rehydrationBucket, fmt.Sprintf("%d/", dr.dataset.ID))
+	if err != nil {
+		dr.logger.Warn("error listing other rehydrated versions", slog.Any("error", err))
+		return nil
+	}
+	versions := map[int]string{}
+	var versionIDs []int
+	for _, key := range manifestKeys {
+		parts := strings.Split(key, "/")
+		if len(parts) < 2 {
+			continue
+		}
+		versionID, err := strconv.Atoi(parts[1])
+		if err != nil || versionID == dr.dataset.VersionID {
+			continue
+		}
+		versions[versionID] = key
+		versionIDs = append(versionIDs, versionID)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versionIDs)))
+
+	copies := map[sourceIdentity]rehydratedCopy{}
+	for _, versionID := range versionIDs {
+		m := dr.completedManifest(ctx, versionID, versions[versionID])
+		if m == nil {
+			continue
+		}
+		for _, f := range m.Files {
+			if len(f.Source) == 0 || len(f.SourceVersionID) == 0 {
+				continue
+			}
+			identity := sourceIdentity{uri: f.Source, versionID: f.SourceVersionID, size: f.Size}
+			if _, seen := copies[identity]; !seen {
+				copies[identity] = rehydratedCopy{versionID: versionID, key: utils.DestinationKey(dr.dataset.ID, versionID, f.Path)}
+			}
+		}
+	}
+	return copies
+}
+
+// completedManifest returns the manifest of the rehydration of versionID if it is a completed rehydration in the same
+// bucket as this one. Returns nil otherwise.
+func (dr *DatasetRehydrator) completedManifest(ctx context.Context, versionID int, manifestKey string) *manifest.Manifest {
+	logger := dr.logger.With(slog.Int("otherVersionId", versionID))
+	record, err := dr.progressStore.GetRecord(ctx, idempotency.RecordID(dr.dataset.ID, versionID))
+	if err != nil {
+		logger.Warn("error getting record of other rehydrated version", slog.Any("error", err))
+		return nil
+	}
+	if record == nil ||
+		record.Status != idempotency.Completed ||
+		record.External() ||
+		record.Region != dr.region ||
+		record.RehydrationLocation != utils.RehydrationLocation(dr.rehydrationBucket, dr.dataset.ID, versionID) {
+		return nil
+	}
+	m, err := dr.manifestReader.Read(ctx, dr.rehydrationBucket, manifestKey)
+	if err != nil {
+		logger.Warn("error reading manifest of other rehydrated version", slog.Any("error", err))
+		return nil
+	}
+	return m
+}
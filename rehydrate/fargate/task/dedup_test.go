@@ -0,0 +1,167 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/pennsieve/rehydration-service/fargate/config"
+	"github.com/pennsieve/rehydration-service/fargate/utils"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/manifest"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/test/discovertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRehydrate_Deduplicate(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+	ctx := context.Background()
+	taskEnv := newTestConfigEnv()
+	taskEnv.DedupPolicy = config.DedupPolicy{Enabled: true, Lease: time.Hour}
+	dataset := taskEnv.Dataset
+	bucket := taskEnv.RehydrationBucket
+	testDatasetFiles := discovertest.NewTestDatasetFiles(*dataset, 5).WithFakeS3VersionsIDs()
+	files := testDatasetFiles.Files
+
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetMetadataByVersionHandlerBuilder(*dataset, testDatasetFiles.DatasetFiles()),
+		discovertest.GetDatasetFileByVersionHandlerBuilder(*dataset, "discover-bucket", testDatasetFiles.ByPath),
+	)
+	defer mockDiscover.Teardown()
+	taskEnv.PennsieveHost = mockDiscover.Server.URL
+
+	publishedFile := func(i int) manifest.File {
+		return manifest.File{
+			Path:            files[i].Path,
+			Size:            files[i].Size,
+			Source:          fmt.Sprintf("s3://discover-bucket/%d/%s", dataset.ID, files[i].Path),
+			SourceVersionID: files[i].S3VersionID,
+		}
+	}
+	changedFile := publishedFile(2)
+	changedFile.SourceVersionID = "older-version"
+	removedFile := publishedFile(0)
+	removedFile.Path, removedFile.Source = "files/removed.csv", fmt.Sprintf("s3://discover-bucket/%d/files/removed.csv", dataset.ID)
+	previousVersion, expiredVersion := dataset.VersionID-1, dataset.VersionID-2
+	// unusedVersion has nothing that previousVersion does not, and the reference to unreferencedVersion fails
+	unusedVersion, unreferencedVersion := dataset.VersionID-3, dataset.VersionID+1
+	manifestKey := func(versionID int) string {
+		return utils.DestinationKey(dataset.ID, versionID, manifest.FileName)
+	}
+	reader := &fakeManifestReader{manifests: map[string]*manifest.Manifest{
+		manifestKey(previousVersion):     {Files: []manifest.File{publishedFile(0), publishedFile(1), changedFile}},
+		manifestKey(expiredVersion):      {Files: []manifest.File{publishedFile(3)}},
+		manifestKey(unusedVersion):       {Files: []manifest.File{publishedFile(0), removedFile}},
+		manifestKey(unreferencedVersion): {Files: []manifest.File{publishedFile(4)}},
+	}}
+	completedRecord := func(versionID int) *idempotency.Record {
+		return idempotency.NewRecord(idempotency.RecordID(dataset.ID, versionID), idempotency.Completed).
+			WithRehydrationLocation(utils.RehydrationLocation(bucket, dataset.ID, versionID))
+	}
+	store := &fakeDedupStore{records: map[string]*idempotency.Record{
+		idempotency.RecordID(dataset.ID, previousVersion): completedRecord(previousVersion),
+		idempotency.RecordID(dataset.ID, expiredVersion): idempotency.NewRecord(idempotency.RecordID(dataset.ID, expiredVersion), idempotency.Expired).
+			WithRehydrationLocation(utils.RehydrationLocation(bucket, dataset.ID, expiredVersion)),
+		idempotency.RecordID(dataset.ID, unusedVersion):       completedRecord(unusedVersion),
+		idempotency.RecordID(dataset.ID, unreferencedVersion): completedRecord(unreferencedVersion),
+	}, referenced: map[string]time.Time{}, referenceErrors: map[string]error{
+		idempotency.RecordID(dataset.ID, unreferencedVersion): &idempotency.ConditionFailedError{},
+	}}
+
+	// the copy from the previous version's file1 fails, so it is copied from the published object instead
+	processor := newFlakyObjectProcessor(map[string]int{files[1].Path: 1})
+	taskConfig := config.NewConfig(test.NewAWSEndpoints(t).Config(ctx, false), taskEnv)
+	taskConfig.SetObjectProcessor(processor)
+	taskConfig.SetIdempotencyStore(store)
+	taskConfig.SetRestorer(newFakeRestorer(nil))
+	taskConfig.SetManifestReader(reader)
+
+	result, err := NewDatasetRehydrator(taskConfig, ThresholdSize).rehydrate(ctx)
+	require.NoError(t, err)
+	require.Len(t, result.FileResults, len(files))
+
+	assert.Equal(t, []string{bucket + " " + fmt.Sprintf("%d/", dataset.ID)}, reader.listed)
+	// only versions that supply files are referenced
+	assert.Len(t, store.referenced, 1)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), store.referenced[idempotency.RecordID(dataset.ID, previousVersion)], time.Minute)
+
+	for i, fileResult := range result.FileResults {
+		assert.NoError(t, fileResult.Error)
+		src := fileResult.Rehydration.Src
+		if i == 0 {
+			deduped, ok := src.(*DedupedSource)
+			if assert.True(t, ok, "expected %s to be deduplicated", src.GetPath()) {
+				assert.Equal(t, bucket, deduped.GetBucket())
+				assert.Equal(t, utils.DestinationKey(dataset.ID, previousVersion, files[0].Path), deduped.GetKey())
+				assert.Empty(t, deduped.GetVersionID())
+				assert.Equal(t, fmt.Sprintf("s3://%s/%s", bucket, deduped.GetKey()), deduped.Location())
+			}
+		} else {
+			assert.IsType(t, &SourceObject{}, src, "expected %s to be copied from the published object", src.GetPath())
+		}
+		// all files are copied to this version's location
+		assert.Equal(t, utils.DestinationKey(dataset.ID, dataset.VersionID, files[i].Path), fileResult.Rehydration.Dest.GetKey())
+	}
+	assert.Equal(t, 2, processor.attempts[files[1].Path])
+}
+
+func TestManifestFile(t *testing.T) {
+	src, err := NewSourceObject("s3://discover-bucket/1234/files/data.csv", 10, "data.csv", "v1", "files/data.csv")
+	require.NoError(t, err)
+	assert.Equal(t, manifest.File{Path: "files/data.csv", Size: 10, Source: "s3://discover-bucket/1234/files/data.csv", SourceVersionID: "v1"},
+		manifestFile(src))
+
+	deduped := &DedupedSource{SourceObject: src, RehydratedBucket: "rehydration-bucket", RehydratedKey: "1234/2/files/data.csv"}
+	assert.Equal(t, manifest.File{
+		Path:            "files/data.csv",
+		Size:            10,
+		Source:          "s3://discover-bucket/1234/files/data.csv",
+		SourceVersionID: "v1",
+		CopiedFrom:      "s3://rehydration-bucket/1234/2/files/data.csv",
+	}, manifestFile(deduped))
+	assert.Equal(t, "rehydration-bucket/1234/2/files/data.csv", deduped.GetCopySource())
+}
+
+// fakeManifestReader returns manifests by key. Keys lists the keys of all the manifests it has.
+type fakeManifestReader struct {
+	manifests map[string]*manifest.Manifest
+	listed    []string
+}
+
+func (r *fakeManifestReader) Read(_ context.Context, _, key string) (*manifest.Manifest, error) {
+	return r.manifests[key], nil
+}
+
+func (r *fakeManifestReader) Keys(_ context.Context, bucket, prefix string) ([]string, error) {
+	r.listed = append(r.listed, bucket+" "+prefix)
+	var keys []string
+	for key := range r.manifests {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// fakeDedupStore implements the idempotency.Store methods used by progressReporter and deduplicate. Reference fails
+// with the error in referenceErrors, if any.
+type fakeDedupStore struct {
+	fakeProgressStore
+	records         map[string]*idempotency.Record
+	referenced      map[string]time.Time
+	referenceErrors map[string]error
+}
+
+func (s *fakeDedupStore) GetRecord(_ context.Context, recordID string) (*idempotency.Record, error) {
+	return s.records[recordID], nil
+}
+
+func (s *fakeDedupStore) Reference(_ context.Context, recordID string, until time.Time) error {
+	if err := s.referenceErrors[recordID]; err != nil {
+		return err
+	}
+	s.referenced[recordID] = until
+	return nil
+}
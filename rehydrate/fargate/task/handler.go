@@ -0,0 +1,304 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pennsieve/rehydration-service/fargate/config"
+	"github.com/pennsieve/rehydration-service/fargate/objects"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/deleteretry"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/manifest"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/notification"
+	"github.com/pennsieve/rehydration-service/shared/s3cleaner"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"go.opentelemetry.io/otel/attribute"
+	"log/slog"
+	"time"
+)
+
+const ThresholdSize = models.ThresholdSize
+
+func RehydrationTaskHandler(ctx context.Context, taskHandler *TaskHandler) (err error) {
+	rehydrator := taskHandler.DatasetRehydrator
+	taskHandler.started = time.Now()
+	ctx, span := tracing.Start(ctx, "task.Rehydrate",
+		attribute.Int("dataset.id", rehydrator.dataset.ID),
+		attribute.Int("dataset.version_id", rehydrator.dataset.VersionID),
+		attribute.String("run.id", taskHandler.runID))
+	defer func() {
+		span.SetAttributes(attribute.Int64("rehydration.bytes_copied", taskHandler.bytesCopied),
+			attribute.Int64("rehydration.files_copied", taskHandler.objectsCopied))
+		tracing.End(span, err)
+		taskHandler.emitMetrics(err)
+	}()
+
+	rehydrator.onRestoring = taskHandler.notifyRestoring
+	rehydrator.resumed = taskHandler.previousResume(ctx)
+	results, err := rehydrator.rehydrate(ctx)
+	taskHandler.countCopies(results)
+	if err != nil {
+		es := taskHandler.failed(ctx)
+		es = append(es, fmt.Errorf("error rehydrating dataset: %w", err))
+		return errors.Join(es...)
+	}
+	if results.Resume != nil {
+		return taskHandler.restoring(ctx, *results.Resume)
+	}
+
+	var errs []error
+	var missing []FileRehydrationResult
+	for _, result := range results.FileResults {
+		if result.Error != nil {
+			missing = append(missing, result)
+			errs = append(errs, fmt.Errorf("error rehydrating file %s: %w", result.Rehydration.Src.GetCopySource(), result.Error))
+		}
+	}
+
+	if len(errs) > 0 && !rehydrator.failurePolicy.AllowsPartial(len(missing)) {
+		// there are real rehydration failures. So no harm in adding any idempotency/tracking/notification errors
+		errs = append(errs, taskHandler.failed(ctx)...)
+		return errors.Join(errs...)
+	}
+	for _, missingError := range errs {
+		// the failure policy allows this many missing files, so we keep the files that were copied
+		rehydrator.logger.Warn("rehydration is partial, file is missing", slog.Any("error", missingError))
+	}
+	for _, finalizeError := range taskHandler.completed(ctx, results, missing) {
+		// there are no real rehydration failures. So we just log idempotency/tracking/notification errors if there are any
+		taskHandler.DatasetRehydrator.logger.Warn(
+			"rehydration succeeded but there were non-fatal errors",
+			slog.Any("error", finalizeError))
+	}
+	return nil
+}
+
+type TaskHandler struct {
+	DatasetRehydrator *DatasetRehydrator
+	IdempotencyStore  idempotency.Store
+	TrackingStore     tracking.Store
+	Emailer           notification.Emailer
+	Cleaner           s3cleaner.Cleaner
+	// DeleteRetryStore may be nil if objects that cannot be deleted should only be logged
+	DeleteRetryStore deleteretry.Store
+	// Audit may be nil if rehydrations are not audited
+	Audit *audit.Recorder
+	// ManifestWriter may be nil if no manifest should be written
+	ManifestWriter manifest.Writer
+	Result         *TaskResult
+	// RequestCounter counts the S3 requests made by the task. May be nil if they are not being counted.
+	RequestCounter *accounting.RequestCounter
+	// Metrics may be nil if no metrics are wanted
+	Metrics *metrics.Recorder
+	// resume is set if the task is exiting to wait for restores
+	resume        *idempotency.Resume
+	runID         string
+	started       time.Time
+	bytesCopied   int64
+	objectsCopied int64
+}
+
+func NewTaskHandler(taskConfig *config.Config, multipartCopyThresholdBytes int64) (*TaskHandler, error) {
+	emailer, err := taskConfig.Emailer()
+	if err != nil {
+		return nil, err
+	}
+	cleaner, err := taskConfig.Cleaner()
+	if err != nil {
+		return nil, err
+	}
+	return &TaskHandler{
+		DatasetRehydrator: NewDatasetRehydrator(taskConfig, multipartCopyThresholdBytes),
+		IdempotencyStore:  taskConfig.IdempotencyStore(),
+		TrackingStore:     taskConfig.TrackingStore(),
+		Emailer:           emailer,
+		Cleaner:           cleaner,
+		DeleteRetryStore:  taskConfig.DeleteRetryStore(),
+		Audit:             audit.NewRecorder(taskConfig.AuditStore(), audit.TaskSource, taskConfig.Logger),
+		ManifestWriter:    taskConfig.ManifestWriter(),
+		RequestCounter:    taskConfig.RequestCounter(),
+		Metrics:           taskConfig.Metrics(),
+		runID:             uuid.NewString(),
+	}, nil
+}
+
+// countCopies adds up the successful copies in results, which may be nil
+func (h *TaskHandler) countCopies(results *RehydrationResult) {
+	if results == nil {
+		return
+	}
+	for _, result := range results.FileResults {
+		if result.Error == nil {
+			h.bytesCopied += result.Rehydration.Src.GetSize()
+			h.objectsCopied++
+		}
+	}
+}
+
+// emitMetrics emits the totals of the run and flushes any values still held by h.Metrics. taskErr is the error, if any,
+// that RehydrationTaskHandler is returning.
+func (h *TaskHandler) emitMetrics(taskErr error) {
+	outcome := "Completed"
+	if taskErr != nil {
+		outcome = "Failed"
+	} else if h.resume != nil {
+		outcome = "Restoring"
+	} else if h.Result != nil && h.Result.Partial() {
+		outcome = "Partial"
+		h.Metrics.Put("MissingFiles", metrics.Count, float64(len(h.Result.MissingFiles)), nil)
+	}
+	h.Metrics.Put("TaskDuration", metrics.Milliseconds, float64(time.Since(h.started).Milliseconds()), metrics.Dimensions{"Outcome": outcome})
+	h.Metrics.Put("BytesCopied", metrics.Bytes, float64(h.bytesCopied), nil)
+	h.Metrics.Put("FilesCopied", metrics.Count, float64(h.objectsCopied), nil)
+	h.Metrics.Flush()
+}
+
+// usage returns what this run has used so far, so it should be called as late as possible. Requests made after it is
+// called, for example, to clean up after a failure, are not included.
+func (h *TaskHandler) usage() *accounting.Usage {
+	usage := h.RequestCounter.Usage()
+	usage.RunID = h.runID
+	usage.BytesCopied = h.bytesCopied
+	usage.ObjectsCopied = h.objectsCopied
+	usage.WallTimeMillis = time.Since(h.started).Milliseconds()
+	return &usage
+}
+
+// failed handles idempotency/notification/tracking for FAILED rehydrations
+func (h *TaskHandler) failed(ctx context.Context) []error {
+	h.Result = NewFailedResult()
+	return h.finalize(ctx)
+}
+
+// completed writes the manifest and handles idempotency/notification/tracking for COMPLETED rehydrations, or
+// PARTIAL ones if missing is not empty
+func (h *TaskHandler) completed(ctx context.Context, results *RehydrationResult, missing []FileRehydrationResult) []error {
+	if len(missing) == 0 {
+		h.Result = NewCompletedResult(results.Location)
+	} else {
+		var missingPaths []string
+		for _, result := range missing {
+			missingPaths = append(missingPaths, result.Rehydration.Src.GetPath())
+		}
+		h.Result = NewPartialResult(results.Location, missingPaths)
+	}
+	var errs []error
+	if err := h.writeManifest(ctx, results); err != nil {
+		errs = append(errs, err)
+	}
+	return append(errs, h.finalize(ctx)...)
+}
+
+// writeManifest saves a manifest of results to the rehydration location and sets h.Result.ManifestLocation
+func (h *TaskHandler) writeManifest(ctx context.Context, results *RehydrationResult) error {
+	if h.ManifestWriter == nil {
+		return nil
+	}
+	dataset := h.DatasetRehydrator.dataset
+	m := manifest.Manifest{
+		DatasetID:           dataset.ID,
+		DatasetVersionID:    dataset.VersionID,
+		RehydrationLocation: results.Location,
+		Status:              h.Result.RehydrationStatus(),
+		CreatedAt:           time.Now(),
+	}
+	for _, result := range results.FileResults {
+		src := result.Rehydration.Src
+		if result.Error == nil {
+			m.Files = append(m.Files, manifestFile(src))
+		} else {
+			m.MissingFiles = append(m.MissingFiles, manifest.MissingFile{Path: src.GetPath(), Error: result.Error.Error()})
+		}
+	}
+	bucket := h.DatasetRehydrator.rehydrationBucket
+	key := h.DatasetRehydrator.destinationKey(manifest.FileName)
+	if err := h.ManifestWriter.Write(ctx, bucket, key, m); err != nil {
+		return err
+	}
+	h.Result.ManifestLocation = manifest.Location(results.Location)
+	return nil
+}
+
+// manifestFile describes the copy of src in a manifest
+func manifestFile(src objects.Source) manifest.File {
+	file := manifest.File{Path: src.GetPath(), Size: src.GetSize()}
+	switch s := src.(type) {
+	case *SourceObject:
+		file.Source, file.SourceVersionID = s.DatasetUri, s.VersionId
+	case *DedupedSource:
+		file.Source, file.SourceVersionID = s.DatasetUri, s.VersionId
+		file.CopiedFrom = s.Location()
+	}
+	return file
+}
+
+func (h *TaskHandler) finalize(ctx context.Context) (errs []error) {
+	ctx, span := tracing.Start(ctx, "task.Finalize", attribute.String("rehydration.status", string(h.Result.RehydrationStatus())))
+	defer func() {
+		tracing.End(span, errors.Join(errs...))
+	}()
+
+	idempotencyCtx, idempotencySpan := tracing.Start(ctx, "task.FinalizeIdempotency")
+	err := h.finalizeIdempotency(idempotencyCtx)
+	tracing.End(idempotencySpan, err)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error finalizing idempotency record: %w", err))
+	}
+
+	queryCtx, querySpan := tracing.Start(ctx, "tracking.QueryDatasetVersionIndexUnhandled")
+	queryResults, err := h.TrackingStore.QueryDatasetVersionIndexUnhandled(queryCtx, *h.DatasetRehydrator.dataset, h.DatasetRehydrator.externalDestination, 20)
+	tracing.End(querySpan, err)
+	if err != nil {
+		errs = append(errs, err)
+		return errs
+	}
+
+	notifyCtx, notifySpan := tracing.Start(ctx, "task.EmailAndLog", attribute.Int("tracking.entries", len(queryResults)))
+	notifyErrs := h.emailAndLog(notifyCtx, queryResults)
+	tracing.End(notifySpan, errors.Join(notifyErrs...))
+	return append(errs, notifyErrs...)
+}
+
+type TaskResult struct {
+	RehydrationLocation string
+	// MissingFiles are the paths of the files a PARTIAL rehydration could not copy
+	MissingFiles []string
+	// ManifestLocation is empty if no manifest was written
+	ManifestLocation string
+}
+
+func NewFailedResult() *TaskResult {
+	return &TaskResult{}
+}
+
+func NewCompletedResult(rehydrationLocation string) *TaskResult {
+	return &TaskResult{RehydrationLocation: rehydrationLocation}
+}
+
+func NewPartialResult(rehydrationLocation string, missingFiles []string) *TaskResult {
+	return &TaskResult{RehydrationLocation: rehydrationLocation, MissingFiles: missingFiles}
+}
+
+func (r *TaskResult) Failed() bool {
+	return len(r.RehydrationLocation) == 0
+}
+
+func (r *TaskResult) RehydrationStatus() tracking.RehydrationStatus {
+	if r.Failed() {
+		return tracking.Failed
+	}
+	if r.Partial() {
+		return tracking.Partial
+	}
+	return tracking.Completed
+}
+
+func (r *TaskResult) Partial() bool {
+	return !r.Failed() && len(r.MissingFiles) > 0
+}
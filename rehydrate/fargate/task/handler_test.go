@@ -1,7 +1,8 @@
-package main
+package task
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -13,9 +14,12 @@ import (
 	"github.com/pennsieve/rehydration-service/shared/expiration"
 	"github.com/pennsieve/rehydration-service/shared/idempotency"
 	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/manifest"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
 	"github.com/pennsieve/rehydration-service/shared/models"
 	"github.com/pennsieve/rehydration-service/shared/test"
 	"github.com/pennsieve/rehydration-service/shared/test/discovertest"
+	"github.com/pennsieve/rehydration-service/shared/test/metricstest"
 	"github.com/pennsieve/rehydration-service/shared/tracking"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -141,6 +145,7 @@ func TestRehydrationTaskHandler(t *testing.T) {
 			defer mockDiscover.Teardown()
 
 			taskEnv.PennsieveHost = mockDiscover.Server.URL
+			metricsSink := metricstest.UseDefault(t)
 			taskConfig := config.NewConfig(awsConfig, taskEnv)
 			mockEmailer := new(MockEmailer)
 			taskConfig.SetEmailer(mockEmailer)
@@ -165,6 +170,44 @@ func TestRehydrationTaskHandler(t *testing.T) {
 			assert.LessOrEqual(t, expiration.DateFrom(beforeTask, taskEnv.RehydrationTTLDays), *updatedIdempotencyRecord.ExpirationDate)
 			assert.GreaterOrEqual(t, expiration.DateFrom(afterTask, taskEnv.RehydrationTTLDays), *updatedIdempotencyRecord.ExpirationDate)
 
+			recordUsage := updatedIdempotencyRecord.Usage
+			require.NotNil(t, recordUsage)
+			assert.NotEmpty(t, recordUsage.RunID)
+			var expectedBytes int64
+			for _, f := range testDatasetFiles.Files {
+				expectedBytes += f.Size
+			}
+			assert.Equal(t, expectedBytes, recordUsage.BytesCopied)
+			assert.Equal(t, int64(len(testDatasetFiles.Files)), recordUsage.ObjectsCopied)
+			if testParams.thresholdSize == ThresholdSize {
+				assert.Equal(t, int64(len(testDatasetFiles.Files)), recordUsage.CopyObjectRequests)
+				assert.Zero(t, recordUsage.UploadPartCopyRequests)
+			} else {
+				assert.Zero(t, recordUsage.CopyObjectRequests)
+				assert.GreaterOrEqual(t, recordUsage.UploadPartCopyRequests, int64(len(testDatasetFiles.Files)))
+			}
+			assert.Less(t, recordUsage.WallTime(), afterTask.Sub(beforeTask)+time.Millisecond)
+
+			// the final progress saved before the record was completed
+			recordProgress := updatedIdempotencyRecord.Progress
+			require.NotNil(t, recordProgress)
+			assert.Equal(t, len(testDatasetFiles.Files), recordProgress.FilesTotal)
+			assert.Equal(t, len(testDatasetFiles.Files), recordProgress.FilesDone)
+			assert.Zero(t, recordProgress.FilesFailed)
+			assert.Equal(t, expectedBytes, recordProgress.BytesDone)
+			assert.Equal(t, float64(100), recordProgress.PercentComplete())
+
+			taskMetrics := metrics.Dimensions{metrics.ComponentDimension: "task"}
+			assert.Len(t, metricsSink.Values("TaskDuration", metrics.Dimensions{"Outcome": "Completed"}), 1)
+			assert.Equal(t, float64(expectedBytes), metricsSink.Sum("BytesCopied", taskMetrics))
+			assert.Equal(t, float64(len(testDatasetFiles.Files)), metricsSink.Sum("FilesCopied", taskMetrics))
+			expectedCopyType := "multipart"
+			if testParams.thresholdSize == ThresholdSize {
+				expectedCopyType = "simple"
+			}
+			assert.Len(t, metricsSink.Values("FileCopyLatency", metrics.Dimensions{"CopyType": expectedCopyType}), len(testDatasetFiles.Files))
+			assert.Empty(t, metricsSink.Values("EmailSendFailures", nil))
+
 			trackingItems := dyDB.Scan(ctx, taskEnv.TrackingTable)
 			require.Len(t, trackingItems, len(allEntries))
 			for _, trackingItem := range trackingItems {
@@ -180,8 +223,13 @@ func TestRehydrationTaskHandler(t *testing.T) {
 					assert.False(t, beforeTask.After(*entry.EmailSentDate))
 					assert.False(t, afterTask.Before(*entry.EmailSentDate))
 					assert.Equal(t, tracking.Completed, entry.RehydrationStatus)
+					if assert.NotNil(t, entry.Usage) {
+						assert.Equal(t, recordUsage.RunID, entry.Usage.RunID)
+						assert.Equal(t, recordUsage.BytesCopied, entry.Usage.BytesCopied)
+					}
 				} else {
 					assert.Contains(t, oldEntriesByID, entry.ID)
+					assert.Nil(t, entry.Usage)
 					expected = oldEntriesByID[entry.ID]
 					// old entries should not have there emailSentDate updated
 					if expected.RehydrationStatus == tracking.Failed {
@@ -282,6 +330,7 @@ func TestRehydrationTaskHandler_S3CopyErrors(t *testing.T) {
 
 	taskEnv.PennsieveHost = mockDiscover.Server.URL
 
+	metricsSink := metricstest.UseDefault(t)
 	taskConfig := config.NewConfig(awsConfig, taskEnv)
 	mockEmailer := new(MockEmailer)
 	taskConfig.SetEmailer(mockEmailer)
@@ -299,6 +348,8 @@ func TestRehydrationTaskHandler_S3CopyErrors(t *testing.T) {
 		require.Contains(t, errs[0].Error(), copyFailPath)
 	}
 	afterEmailSent := time.Now()
+	assert.Len(t, metricsSink.Values("TaskDuration", metrics.Dimensions{"Outcome": "Failed"}), 1)
+	assert.Equal(t, float64(len(testDatasetFiles.Files)-1), metricsSink.Sum("FilesCopied", nil))
 
 	// Idempotency record should have been deleted so that another attempt can be made
 	idempotencyItems := dyDB.Scan(ctx, idempotencyTable)
@@ -329,6 +380,216 @@ func TestRehydrationTaskHandler_S3CopyErrors(t *testing.T) {
 
 }
 
+func TestRehydrationTaskHandler_Partial(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().WithMinIO().Config(ctx, false)
+	publishBucket := "discover-bucket"
+	taskEnv := newTestConfigEnv()
+	taskEnv.FailurePolicy = config.FailurePolicy{RetryAttempts: 1, RetryBackoff: time.Millisecond, MaxMissingFiles: 1}
+	idempotencyTable := taskEnv.IdempotencyTable
+	dataset := taskEnv.Dataset
+	expectedRehydrationLocation := utils.RehydrationLocation(taskEnv.RehydrationBucket, dataset.ID, dataset.VersionID)
+
+	testDatasetFiles := discovertest.NewTestDatasetFiles(*dataset, 50).WithFakeS3VersionsIDs()
+	copyFailPath := testDatasetFiles.Files[17].Path
+
+	// Set up S3 for the tests
+	s3Client := s3.NewFromConfig(awsConfig)
+	s3Fixture, putObjectOutputs := test.NewS3Fixture(t, s3Client,
+		&s3.CreateBucketInput{Bucket: aws.String(publishBucket)},
+		&s3.CreateBucketInput{Bucket: aws.String(taskEnv.RehydrationBucket)},
+	).WithVersioning(publishBucket).WithObjects(testDatasetFiles.PutObjectInputs(publishBucket)...)
+	defer s3Fixture.Teardown()
+
+	// Set S3 versionIds
+	for location, putOutput := range putObjectOutputs {
+		testDatasetFiles.SetS3VersionID(t, location, aws.ToString(putOutput.VersionId))
+	}
+
+	// Setup DynamoDB for tests
+	initialIdempotencyRecord := newInProgressRecord(*dataset)
+	initialTrackingEntry := tracking.NewEntry(
+		uuid.NewString(),
+		*dataset,
+		*taskEnv.User,
+		uuid.NewString(),
+		uuid.NewString(),
+		initialIdempotencyRecord.FargateTaskARN)
+	dyDB := test.NewDynamoDBFixture(
+		t,
+		awsConfig,
+		test.IdempotencyCreateTableInput(idempotencyTable),
+		test.TrackingCreateTableInput(taskEnv.TrackingTable)).
+		WithItems(
+			test.ItemerMapToPutItemInputs(t, map[string][]test.Itemer{
+				idempotencyTable:      {initialIdempotencyRecord},
+				taskEnv.TrackingTable: {initialTrackingEntry},
+			})...)
+	defer dyDB.Teardown()
+
+	// Create a mock Discover API server
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetMetadataByVersionHandlerBuilder(*dataset, testDatasetFiles.DatasetFiles()),
+		discovertest.GetDatasetFileByVersionHandlerBuilder(*dataset, publishBucket, testDatasetFiles.ByPath),
+	)
+	defer mockDiscover.Teardown()
+
+	taskEnv.PennsieveHost = mockDiscover.Server.URL
+
+	metricsSink := metricstest.UseDefault(t)
+	taskConfig := config.NewConfig(awsConfig, taskEnv)
+	mockEmailer := new(MockEmailer)
+	taskConfig.SetEmailer(mockEmailer)
+	taskConfig.SetObjectProcessor(NewMockFailingObjectProcessor(s3Client, copyFailPath))
+
+	taskHandler, err := NewTaskHandler(taskConfig, ThresholdSize)
+	require.NoError(t, err)
+	require.NoError(t, RehydrationTaskHandler(ctx, taskHandler))
+
+	assert.Len(t, metricsSink.Values("TaskDuration", metrics.Dimensions{"Outcome": "Partial"}), 1)
+	assert.Equal(t, float64(1), metricsSink.Sum("MissingFiles", nil))
+	assert.Equal(t, float64(1), metricsSink.Sum("FileCopyRetries", nil))
+
+	// Idempotency record should be completed, but list the missing file
+	idempotencyItems := dyDB.Scan(ctx, idempotencyTable)
+	require.Len(t, idempotencyItems, 1)
+	record, err := idempotency.FromItem(idempotencyItems[0])
+	require.NoError(t, err)
+	assert.Equal(t, idempotency.Completed, record.Status)
+	assert.Equal(t, expectedRehydrationLocation, record.RehydrationLocation)
+	assert.Equal(t, []string{copyFailPath}, record.MissingFiles)
+	assert.NotNil(t, record.ExpirationDate)
+
+	// tracking entry should be marked as partial
+	trackingItems := dyDB.Scan(ctx, taskEnv.TrackingTable)
+	require.Len(t, trackingItems, 1)
+	entry, err := tracking.FromItem(trackingItems[0])
+	require.NoError(t, err)
+	assert.Equal(t, tracking.Partial, entry.RehydrationStatus)
+	assert.NotNil(t, entry.EmailSentDate)
+
+	expectedManifestKey := utils.DestinationKey(dataset.ID, dataset.VersionID, manifest.FileName)
+	assert.Empty(t, mockEmailer.complete)
+	assert.Empty(t, mockEmailer.failed)
+	if assert.Len(t, mockEmailer.partial, 1) {
+		partialEmailCall := mockEmailer.partial[0]
+		assert.Equal(t, entry.UserEmail, partialEmailCall.user.Email)
+		assert.Equal(t, expectedRehydrationLocation, partialEmailCall.rehydrationLocation)
+		assert.Equal(t, []string{copyFailPath}, partialEmailCall.missingFiles)
+		assert.Equal(t, fmt.Sprintf("s3://%s/%s", taskEnv.RehydrationBucket, expectedManifestKey), partialEmailCall.manifestLocation)
+	}
+
+	// the copied files should have been kept, and the manifest written
+	for _, datasetFile := range testDatasetFiles.Files {
+		expectedRehydratedKey := utils.DestinationKey(dataset.ID, dataset.VersionID, datasetFile.Path)
+		if datasetFile.Path == copyFailPath {
+			assert.False(t, s3Fixture.ObjectExists(taskEnv.RehydrationBucket, expectedRehydratedKey))
+		} else {
+			s3Fixture.AssertObjectExists(taskEnv.RehydrationBucket, expectedRehydratedKey, datasetFile.Size)
+		}
+	}
+	manifestOut, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(taskEnv.RehydrationBucket), Key: aws.String(expectedManifestKey)})
+	require.NoError(t, err)
+	defer manifestOut.Body.Close()
+	var written manifest.Manifest
+	require.NoError(t, json.NewDecoder(manifestOut.Body).Decode(&written))
+	assert.Equal(t, tracking.Partial, written.Status)
+	assert.Len(t, written.Files, len(testDatasetFiles.Files)-1)
+	if assert.Len(t, written.MissingFiles, 1) {
+		assert.Equal(t, copyFailPath, written.MissingFiles[0].Path)
+		assert.Contains(t, written.MissingFiles[0].Error, "error copying")
+	}
+}
+
+func TestRehydrationTaskHandler_Restoring(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().WithMinIO().Config(ctx, false)
+	publishBucket := "discover-bucket"
+	taskEnv := newTestConfigEnv()
+	idempotencyTable := taskEnv.IdempotencyTable
+	dataset := taskEnv.Dataset
+
+	testDatasetFiles := discovertest.NewTestDatasetFiles(*dataset, 10).WithFakeS3VersionsIDs()
+	archivedPath := testDatasetFiles.Files[3].Path
+
+	s3Client := s3.NewFromConfig(awsConfig)
+	s3Fixture := test.NewS3Fixture(t, s3Client,
+		&s3.CreateBucketInput{Bucket: aws.String(taskEnv.RehydrationBucket)},
+	)
+	defer s3Fixture.Teardown()
+
+	initialIdempotencyRecord := newInProgressRecord(*dataset)
+	initialTrackingEntry := tracking.NewEntry(
+		uuid.NewString(),
+		*dataset,
+		*taskEnv.User,
+		uuid.NewString(),
+		uuid.NewString(),
+		initialIdempotencyRecord.FargateTaskARN)
+	dyDB := test.NewDynamoDBFixture(
+		t,
+		awsConfig,
+		test.IdempotencyCreateTableInput(idempotencyTable),
+		test.TrackingCreateTableInput(taskEnv.TrackingTable)).
+		WithItems(
+			test.ItemerMapToPutItemInputs(t, map[string][]test.Itemer{
+				idempotencyTable:      {initialIdempotencyRecord},
+				taskEnv.TrackingTable: {initialTrackingEntry},
+			})...)
+	defer dyDB.Teardown()
+
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetMetadataByVersionHandlerBuilder(*dataset, testDatasetFiles.DatasetFiles()),
+		discovertest.GetDatasetFileByVersionHandlerBuilder(*dataset, publishBucket, testDatasetFiles.ByPath),
+	)
+	defer mockDiscover.Teardown()
+	taskEnv.PennsieveHost = mockDiscover.Server.URL
+	// started by a service with a dispatcher
+	taskEnv.RestorePolicy.Resumable = true
+
+	metricsSink := metricstest.UseDefault(t)
+	taskConfig := config.NewConfig(awsConfig, taskEnv)
+	mockEmailer := new(MockEmailer)
+	taskConfig.SetEmailer(mockEmailer)
+	taskConfig.SetRestorer(newFakeRestorer(map[string]objects.ArchiveStatus{
+		archivedPath: {StorageClass: "GLACIER", Archived: true},
+	}))
+
+	taskHandler, err := NewTaskHandler(taskConfig, ThresholdSize)
+	require.NoError(t, err)
+	require.NoError(t, RehydrationTaskHandler(ctx, taskHandler))
+
+	assert.Len(t, metricsSink.Values("TaskDuration", metrics.Dimensions{"Outcome": "Restoring"}), 1)
+
+	// the record should be left for the dispatcher to start the task again once the restore is expected to be done
+	idempotencyItems := dyDB.Scan(ctx, idempotencyTable)
+	require.Len(t, idempotencyItems, 1)
+	record, err := idempotency.FromItem(idempotencyItems[0])
+	require.NoError(t, err)
+	assert.Equal(t, idempotency.Restoring, record.Status)
+	assert.Empty(t, record.FargateTaskARN)
+	require.NotNil(t, record.Resume)
+	assert.Equal(t, *dataset, record.Resume.Dataset)
+	assert.Equal(t, *taskEnv.User, record.Resume.User)
+	assert.True(t, record.Resume.ResumeAt.After(time.Now()))
+	assert.False(t, record.Resumable(time.Now()))
+
+	// the user is told about the restore, but the rehydration is not finished
+	trackingItems := dyDB.Scan(ctx, taskEnv.TrackingTable)
+	require.Len(t, trackingItems, 1)
+	entry, err := tracking.FromItem(trackingItems[0])
+	require.NoError(t, err)
+	assert.Equal(t, tracking.InProgress, entry.RehydrationStatus)
+	assert.Nil(t, entry.EmailSentDate)
+	assert.Len(t, mockEmailer.restoring, 1)
+	assert.Empty(t, mockEmailer.complete)
+	assert.Empty(t, mockEmailer.failed)
+}
+
 func TestRehydrationTaskHandler_DiscoverErrors(t *testing.T) {
 	ctx := context.Background()
 	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().WithMinIO().Config(ctx, false)
@@ -469,7 +730,7 @@ type MockFailingObjectProcessor struct {
 }
 
 func NewMockFailingObjectProcessor(s3Client *s3.Client, failOnPaths ...string) *MockFailingObjectProcessor {
-	realProcessor := objects.NewRehydrator(s3Client, ThresholdSize, logging.Default)
+	realProcessor := objects.NewRehydrator(s3Client, s3Client, ThresholdSize, utils.CopyOptions{}, false, logging.Default, nil)
 	mock := MockFailingObjectProcessor{FailOnPaths: map[string]bool{}, RealProcessor: realProcessor}
 	for _, p := range failOnPaths {
 		mock.FailOnPaths[p] = true
@@ -498,8 +759,10 @@ func (m *MockNoCallsObjectProcessor) Copy(_ context.Context, source objects.Sour
 }
 
 type MockEmailer struct {
-	complete []mockCompleteEmailCall
-	failed   []mockFailedEmailCall
+	complete  []mockCompleteEmailCall
+	failed    []mockFailedEmailCall
+	partial   []mockPartialEmailCall
+	restoring []mockRestoringEmailCall
 }
 
 type mockEmailCall struct {
@@ -512,6 +775,18 @@ type mockCompleteEmailCall struct {
 	rehydrationLocation string
 }
 
+type mockPartialEmailCall struct {
+	mockCompleteEmailCall
+	missingFiles     []string
+	manifestLocation string
+}
+
+type mockRestoringEmailCall struct {
+	mockEmailCall
+	fileCount       int
+	expectedReadyAt time.Time
+}
+
 type mockFailedEmailCall struct {
 	mockEmailCall
 	requestID string
@@ -532,3 +807,24 @@ func (m *MockEmailer) SendRehydrationFailed(_ context.Context, dataset models.Da
 	})
 	return nil
 }
+
+func (m *MockEmailer) SendRehydrationPartial(_ context.Context, dataset models.Dataset, user models.User, rehydrationLocation string, missingFiles []string, manifestLocation string) error {
+	m.partial = append(m.partial, mockPartialEmailCall{
+		mockCompleteEmailCall: mockCompleteEmailCall{
+			mockEmailCall:       mockEmailCall{dataset: dataset, user: user},
+			rehydrationLocation: rehydrationLocation,
+		},
+		missingFiles:     missingFiles,
+		manifestLocation: manifestLocation,
+	})
+	return nil
+}
+
+func (m *MockEmailer) SendRehydrationRestoring(_ context.Context, dataset models.Dataset, user models.User, restoringFileCount int, expectedReadyAt time.Time) error {
+	m.restoring = append(m.restoring, mockRestoringEmailCall{
+		mockEmailCall:   mockEmailCall{dataset: dataset, user: user},
+		fileCount:       restoringFileCount,
+		expectedReadyAt: expectedReadyAt,
+	})
+	return nil
+}
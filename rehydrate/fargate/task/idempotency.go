@@ -0,0 +1,145 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/deleteretry"
+	"github.com/pennsieve/rehydration-service/shared/expiration"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/s3cleaner"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+func (h *TaskHandler) finalizeIdempotency(ctx context.Context) error {
+	if h.Result == nil {
+		return fmt.Errorf("illegal state: TaskResult has not been set")
+	}
+	recordID := h.DatasetRehydrator.recordID
+	if h.Result.Failed() {
+		return h.finalizeFailedIdempotency(ctx, recordID)
+	}
+	expirationDate := expiration.DateFromNow(h.DatasetRehydrator.rehydrationTTLDays)
+	usage := h.usage()
+	record := idempotency.NewRecord(recordID, idempotency.Completed).
+		WithRehydrationLocation(h.Result.RehydrationLocation).
+		WithExpirationDate(&expirationDate).
+		WithUsage(usage).
+		WithMissingFiles(h.Result.MissingFiles)
+	if err := h.IdempotencyStore.UpdateRecord(ctx, *record); err != nil {
+		return err
+	}
+	h.Audit.Record(ctx, h.idempotencyEvent(idempotency.InProgress, idempotency.Completed).
+		WithDetail(audit.RehydrationLocationDetail, h.Result.RehydrationLocation).
+		WithDetail(audit.FilesCopiedDetail, strconv.FormatInt(usage.ObjectsCopied, 10)).
+		WithDetail(audit.BytesCopiedDetail, strconv.FormatInt(usage.BytesCopied, 10)).
+		WithDetail(audit.MissingFilesDetail, strconv.Itoa(len(h.Result.MissingFiles))))
+	return nil
+}
+
+// previousResume returns the Resume that the task was started again with after waiting for restores, or nil if this is
+// its first run. Errors are only logged, in which case any restores are waited for as if they were requested now.
+func (h *TaskHandler) previousResume(ctx context.Context) *idempotency.Resume {
+	record, err := h.IdempotencyStore.GetRecord(ctx, h.DatasetRehydrator.recordID)
+	if err != nil {
+		h.DatasetRehydrator.logger.Warn("error getting idempotency record to check for earlier restores", slog.Any("error", err))
+		return nil
+	}
+	if record == nil {
+		return nil
+	}
+	return record.Resume
+}
+
+// restoring sets the idempotency record to RESTORING so that the dispatcher starts the task again at resume.ResumeAt.
+// If it cannot be set, the rehydration fails, since nothing would start the task again.
+func (h *TaskHandler) restoring(ctx context.Context, resume idempotency.Resume) error {
+	h.resume = &resume
+	if err := h.IdempotencyStore.SaveRestoring(ctx, h.DatasetRehydrator.recordID, resume); err != nil {
+		h.resume = nil
+		errs := append(h.failed(ctx), fmt.Errorf("error saving restoring idempotency record: %w", err))
+		return errors.Join(errs...)
+	}
+	h.Audit.Record(ctx, h.idempotencyEvent(idempotency.InProgress, idempotency.Restoring).
+		WithDetail(audit.ReasonDetail, "waiting for archived files to be restored"))
+	h.DatasetRehydrator.logger.Info("exiting until archived files are restored", slog.Time("resumeAt", resume.ResumeAt))
+	return nil
+}
+
+// idempotencyEvent is an audit.Event for this task's idempotency record, on behalf of the user the task was started
+// for
+func (h *TaskHandler) idempotencyEvent(previous, status idempotency.Status) audit.Event {
+	rehydrator := h.DatasetRehydrator
+	event := audit.IdempotencyEvent(rehydrator.recordID, previous, status).
+		WithDetail(audit.DestinationDetail, rehydrator.externalDestination)
+	if rehydrator.user != nil {
+		event = event.WithUser(*rehydrator.user)
+	}
+	return event
+}
+
+// finalizeFailedIdempotency does the following to finalize the idempotency state of a failed rehydration
+//
+// * Sets the idempotency record's status to EXPIRED so that any incoming rehydration requests for this dataset version
+// fail while we clean up.
+// * Cleans the rehydration location in the S3 bucket by deleting any objects found there.
+// * Finally, deletes the idempotency record so that new rehydration requests for the dataset version can be handled in
+// the future. The idempotency record is not deleted if the clean is incomplete because of errors. Instead, the objects
+// that could not be deleted, or the whole location if the clean failed outright, are saved to DeleteRetryStore, if
+// there is one, so that the expiration lambda can finish the clean and delete the record. Objects in an external
+// destination are not saved, since the lambda cannot delete from a requester's bucket.
+func (h *TaskHandler) finalizeFailedIdempotency(ctx context.Context, recordID string) error {
+	if err := h.IdempotencyStore.ExpireRecord(ctx, recordID); err != nil {
+		return err
+	}
+	h.Audit.Record(ctx, h.idempotencyEvent(idempotency.InProgress, idempotency.Expired).
+		WithDetail(audit.ReasonDetail, "rehydration failed"))
+	rehydrationBucket := h.DatasetRehydrator.rehydrationBucket
+	rehydrationPrefix := h.DatasetRehydrator.locationPrefix()
+	rehydrationLocation := fmt.Sprintf("s3://%s/%s", rehydrationBucket, rehydrationPrefix)
+	cleanResp, err := h.Cleaner.Clean(ctx, rehydrationBucket, rehydrationPrefix, s3cleaner.CleanOptions{})
+	if err != nil {
+		// the record is already EXPIRED, so the location is saved under its prefix for the expiration lambda to clean
+		entry := deleteretry.NewEntry(recordID, rehydrationLocation, rehydrationPrefix, err.Error(), time.Now())
+		return errors.Join(err, h.saveRetries(ctx, rehydrationLocation, []deleteretry.Entry{entry}))
+	}
+	h.DatasetRehydrator.logger.Info("cleaned rehydration location",
+		slog.Group("rehydrationLocation", slog.String("bucket", rehydrationBucket), slog.String("prefix", rehydrationPrefix)),
+		slog.Int("fileCount", cleanResp.Count),
+		slog.Int("deletedCount", cleanResp.Deleted))
+	if len(cleanResp.Errors) == 0 {
+		if err := h.IdempotencyStore.DeleteRecord(ctx, recordID); err != nil {
+			return err
+		}
+		deleted := h.idempotencyEvent(idempotency.Expired, "")
+		deleted.Status = audit.Deleted
+		h.Audit.Record(ctx, deleted.WithDetail(audit.FilesDeletedDetail, strconv.Itoa(cleanResp.Deleted)))
+		return nil
+	}
+	for _, e := range cleanResp.Errors {
+		h.DatasetRehydrator.logger.Error("error deleting object",
+			slog.Group("object", slog.String("bucket", rehydrationBucket), slog.String("key", e.Key)),
+			slog.String("error", e.Message))
+	}
+	failedAt := time.Now()
+	entries := make([]deleteretry.Entry, len(cleanResp.Errors))
+	for i, e := range cleanResp.Errors {
+		entries[i] = deleteretry.NewEntry(recordID, rehydrationLocation, e.Key, e.Message, failedAt)
+	}
+	return h.saveRetries(ctx, rehydrationLocation, entries)
+}
+
+// saveRetries saves entries to DeleteRetryStore, unless there is none or the rehydration is in an external destination
+func (h *TaskHandler) saveRetries(ctx context.Context, rehydrationLocation string, entries []deleteretry.Entry) error {
+	if h.DeleteRetryStore == nil || len(h.DatasetRehydrator.externalDestination) > 0 {
+		return nil
+	}
+	if err := h.DeleteRetryStore.Put(ctx, entries); err != nil {
+		return fmt.Errorf("error saving objects to retry deleting from %s: %w", rehydrationLocation, err)
+	}
+	h.DatasetRehydrator.logger.Info("saved objects to retry deleting", slog.Int("count", len(entries)))
+	return nil
+}
@@ -0,0 +1,145 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/pennsieve/rehydration-service/fargate/config"
+	"github.com/pennsieve/rehydration-service/fargate/utils"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/deleteretry"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/s3cleaner"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFinalizeFailedIdempotency(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+	ctx := context.Background()
+
+	for name, testParams := range map[string]struct {
+		failedKeys      []string
+		cleanErr        error
+		external        bool
+		expectedDeleted bool
+		// expectedSaved are the keys of the entries saved to retry, where "" stands for the location's prefix
+		expectedSaved []string
+		// expectedAudit are the statuses of the audit events
+		expectedAudit []string
+	}{
+		"clean":                {expectedDeleted: true, expectedAudit: []string{string(idempotency.Expired), audit.Deleted}},
+		"delete errors":        {failedKeys: []string{"a.txt", "b.txt"}, expectedSaved: []string{"a.txt", "b.txt"}, expectedAudit: []string{string(idempotency.Expired)}},
+		"external destination": {failedKeys: []string{"a.txt"}, external: true, expectedAudit: []string{string(idempotency.Expired)}},
+		"clean error":          {cleanErr: errors.New("no such bucket"), expectedSaved: []string{""}, expectedAudit: []string{string(idempotency.Expired)}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			taskEnv := newTestConfigEnv()
+			if testParams.external {
+				taskEnv.RehydrationPrefix = "data/"
+				taskEnv.RehydrationRoleARN = "arn:aws:iam::123456789012:role/writer"
+			}
+			taskConfig := config.NewConfig(test.NewAWSEndpoints(t).Config(ctx, false), taskEnv)
+			rehydrator := NewDatasetRehydrator(taskConfig, ThresholdSize)
+			store := &fakeFinalizeStore{deleted: map[string]bool{}, expired: map[string]bool{}}
+			retryStore := &fakeDeleteRetryStore{}
+			cleaner := &fakeFailingCleaner{failedKeys: testParams.failedKeys, err: testParams.cleanErr}
+			auditStore := &fakeAuditStore{}
+			handler := &TaskHandler{
+				DatasetRehydrator: rehydrator,
+				IdempotencyStore:  store,
+				Cleaner:           cleaner,
+				DeleteRetryStore:  retryStore,
+				Audit:             audit.NewRecorder(auditStore, audit.TaskSource, rehydrator.logger),
+			}
+
+			recordID := taskEnv.RecordID()
+			err := handler.finalizeFailedIdempotency(ctx, recordID)
+			if testParams.cleanErr != nil {
+				require.ErrorIs(t, err, testParams.cleanErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			assert.True(t, store.expired[recordID])
+			assert.Equal(t, testParams.expectedDeleted, store.deleted[recordID])
+			require.Len(t, retryStore.entries, len(testParams.expectedSaved))
+			expectedLocation := utils.RehydrationLocation(taskEnv.RehydrationBucket, taskEnv.Dataset.ID, taskEnv.Dataset.VersionID)
+			for i, entry := range retryStore.entries {
+				expectedKey := testParams.expectedSaved[i]
+				if len(expectedKey) == 0 {
+					expectedKey = rehydrator.locationPrefix()
+				}
+				assert.Equal(t, recordID, entry.RecordID)
+				assert.Equal(t, expectedKey, entry.Key)
+				assert.Equal(t, expectedLocation, entry.RehydrationLocation)
+				assert.NotEmpty(t, entry.Error)
+			}
+
+			var auditStatuses []string
+			for _, event := range auditStore.events {
+				auditStatuses = append(auditStatuses, event.Status)
+				assert.Equal(t, recordID, event.SubjectID)
+				assert.Equal(t, taskEnv.Dataset.DatasetVersion(), event.DatasetVersion)
+				assert.Equal(t, taskEnv.User.Email, event.UserEmail)
+			}
+			assert.Equal(t, testParams.expectedAudit, auditStatuses)
+		})
+	}
+}
+
+type fakeFinalizeStore struct {
+	idempotency.Store
+	expired map[string]bool
+	deleted map[string]bool
+}
+
+func (s *fakeFinalizeStore) ExpireRecord(_ context.Context, recordID string) error {
+	s.expired[recordID] = true
+	return nil
+}
+
+func (s *fakeFinalizeStore) DeleteRecord(_ context.Context, recordID string) error {
+	s.deleted[recordID] = true
+	return nil
+}
+
+type fakeFailingCleaner struct {
+	failedKeys []string
+	err        error
+}
+
+func (c *fakeFailingCleaner) Clean(_ context.Context, _ string, keyPrefix string, _ s3cleaner.CleanOptions) (*s3cleaner.CleanResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	resp := &s3cleaner.CleanResponse{Count: 3, Deleted: 3 - len(c.failedKeys)}
+	for _, key := range c.failedKeys {
+		resp.Errors = append(resp.Errors, s3cleaner.DeleteObjectError{Key: key, Message: fmt.Sprintf("error deleting object %s%s", keyPrefix, key)})
+	}
+	return resp, nil
+}
+
+type fakeDeleteRetryStore struct {
+	deleteretry.Store
+	entries []deleteretry.Entry
+}
+
+func (s *fakeDeleteRetryStore) Put(_ context.Context, entries []deleteretry.Entry) error {
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+type fakeAuditStore struct {
+	audit.Store
+	events []audit.Event
+}
+
+func (s *fakeAuditStore) Append(_ context.Context, events []audit.Event) error {
+	s.events = append(s.events, events...)
+	return nil
+}
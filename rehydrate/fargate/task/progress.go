@@ -0,0 +1,116 @@
+package task
+
+import (
+	"context"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"log/slog"
+	"time"
+)
+
+// DefaultProgressInterval is how often the rehydration task saves its progress to the idempotency record
+const DefaultProgressInterval = 30 * time.Second
+
+// progressReporter adds up file results as they arrive and saves the progress to the idempotency record at most once
+// per interval. Failing to save progress is logged, but does not fail the rehydration.
+type progressReporter struct {
+	store    idempotency.Store
+	recordID string
+	interval time.Duration
+	logger   *slog.Logger
+	now      func() time.Time
+	progress idempotency.Progress
+	lastSave time.Time
+}
+
+func newProgressReporter(store idempotency.Store, recordID string, interval time.Duration, logger *slog.Logger, rehydrations []*Rehydration) *progressReporter {
+	r := &progressReporter{
+		store:    store,
+		recordID: recordID,
+		interval: interval,
+		logger:   logger,
+		now:      time.Now,
+	}
+	r.progress.FilesTotal = len(rehydrations)
+	for _, rehydration := range rehydrations {
+		r.progress.BytesTotal += rehydration.Src.GetSize()
+	}
+	return r
+}
+
+// start saves the initial progress so that the totals are visible before the first file is done
+func (r *progressReporter) start(ctx context.Context) {
+	r.progress.StartedAt = r.now()
+	r.save(ctx)
+}
+
+// restore records the restore of fileCount archived files, filesRestored of which have been restored
+func (r *progressReporter) restore(fileCount int, filesRestored int, tier string, requestedAt time.Time, expectedReadyAt time.Time) {
+	r.progress.Restore = &idempotency.RestoreProgress{
+		FilesTotal:      fileCount,
+		FilesRestored:   filesRestored,
+		Tier:            tier,
+		RequestedAt:     requestedAt,
+		ExpectedReadyAt: expectedReadyAt,
+	}
+}
+
+// restoring records that the task is leaving the restores recorded by restore to finish and saves progress
+func (r *progressReporter) restoring(ctx context.Context) {
+	r.progress.Phase = idempotency.RestoringPhase
+	r.save(ctx)
+}
+
+// copying records that the task has stopped waiting for the restores recorded by restore and saves progress
+func (r *progressReporter) copying(ctx context.Context) {
+	r.progress.Phase = idempotency.CopyingPhase
+	finished := r.now()
+	r.progress.Restore.FinishedAt = &finished
+	r.save(ctx)
+}
+
+// add records result and saves progress if interval has passed since the last save
+func (r *progressReporter) add(ctx context.Context, result FileRehydrationResult) {
+	r.progress.FilesDone++
+	if result.Error != nil {
+		r.progress.FilesFailed++
+	} else {
+		r.progress.BytesDone += result.Rehydration.Src.GetSize()
+	}
+	if r.now().Sub(r.lastSave) >= r.interval {
+		r.save(ctx)
+	}
+}
+
+// retried records the result of retrying a file that was counted as failed by add
+func (r *progressReporter) retried(ctx context.Context, result FileRehydrationResult) {
+	if result.Error == nil {
+		r.progress.FilesFailed--
+		r.progress.BytesDone += result.Rehydration.Src.GetSize()
+	}
+	if r.now().Sub(r.lastSave) >= r.interval {
+		r.save(ctx)
+	}
+}
+
+// finish saves the final progress
+func (r *progressReporter) finish(ctx context.Context) {
+	r.save(ctx)
+}
+
+func (r *progressReporter) save(ctx context.Context) {
+	if r.store == nil {
+		return
+	}
+	r.lastSave = r.now()
+	r.progress.UpdatedAt = r.lastSave
+	if err := r.store.SetProgress(ctx, r.recordID, *r.progress.EstimateCompletion()); err != nil {
+		r.logger.Warn("error saving rehydration progress", slog.Any("error", err))
+		return
+	}
+	r.logger.Info("rehydration progress",
+		slog.Int("filesDone", r.progress.FilesDone),
+		slog.Int("filesTotal", r.progress.FilesTotal),
+		slog.Int("filesFailed", r.progress.FilesFailed),
+		slog.Int64("bytesDone", r.progress.BytesDone),
+		slog.Int64("bytesTotal", r.progress.BytesTotal))
+}
@@ -0,0 +1,81 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestProgressReporter(t *testing.T) {
+	store := &fakeProgressStore{}
+	var rehydrations []*Rehydration
+	for i, size := range []int64{100, 300, 600} {
+		source, err := NewSourceObject("s3://discover-bucket/5065", size, "file", "version", []string{"a.txt", "b.txt", "c.txt"}[i])
+		require.NoError(t, err)
+		rehydrations = append(rehydrations, NewRehydration(source, DestinationObject{Bucket: "rehydration-bucket", Key: "key"}))
+	}
+
+	started := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := started
+	reporter := newProgressReporter(store, "5065/2/", time.Minute, logging.Default, rehydrations)
+	reporter.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	reporter.start(ctx)
+	require.Len(t, store.saved, 1)
+	assert.Equal(t, idempotency.Progress{FilesTotal: 3, BytesTotal: 1000, StartedAt: started, UpdatedAt: started}, store.saved[0])
+
+	// not saved since less than interval has passed
+	now = started.Add(30 * time.Second)
+	reporter.add(ctx, FileRehydrationResult{Rehydration: rehydrations[0]})
+	assert.Len(t, store.saved, 1)
+
+	now = started.Add(2 * time.Minute)
+	reporter.add(ctx, FileRehydrationResult{Rehydration: rehydrations[1], Error: errors.New("copy failed")})
+	require.Len(t, store.saved, 2)
+	assert.Equal(t, "5065/2/", store.recordID)
+	saved := store.saved[1]
+	assert.Equal(t, 2, saved.FilesDone)
+	assert.Equal(t, 1, saved.FilesFailed)
+	assert.Equal(t, int64(100), saved.BytesDone)
+	require.NotNil(t, saved.EstimatedCompletion)
+	assert.Equal(t, started.Add(20*time.Minute), *saved.EstimatedCompletion)
+
+	now = started.Add(150 * time.Second)
+	reporter.add(ctx, FileRehydrationResult{Rehydration: rehydrations[2]})
+	reporter.finish(ctx)
+	require.Len(t, store.saved, 3)
+	assert.Equal(t, 3, store.saved[2].FilesDone)
+	assert.Equal(t, int64(700), store.saved[2].BytesDone)
+}
+
+func TestProgressReporter_SaveErrors(t *testing.T) {
+	store := &fakeProgressStore{err: errors.New("dynamodb unavailable")}
+	reporter := newProgressReporter(store, "5065/2/", 0, logging.Default, nil)
+	assert.NotPanics(t, func() {
+		reporter.start(context.Background())
+		reporter.finish(context.Background())
+	})
+}
+
+// fakeProgressStore implements only the idempotency.Store methods used by progressReporter
+type fakeProgressStore struct {
+	idempotency.Store
+	err      error
+	recordID string
+	saved    []idempotency.Progress
+}
+
+func (s *fakeProgressStore) SetProgress(_ context.Context, recordID string, progress idempotency.Progress) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.recordID = recordID
+	s.saved = append(s.saved, progress)
+	return nil
+}
@@ -1,4 +1,4 @@
-package main
+package task
 
 import (
 	"github.com/pennsieve/rehydration-service/fargate/objects"
@@ -13,6 +13,8 @@ type SourceObject struct {
 	VersionId  string
 	Path       string
 	CopySource string
+	Bucket     string
+	Key        string
 }
 
 func NewSourceObject(datasetUri string, size int64, name string, versionId string, path string) (*SourceObject, error) {
@@ -20,7 +22,12 @@ func NewSourceObject(datasetUri string, size int64, name string, versionId strin
 	if err != nil {
 		return nil, err
 	}
-	return &SourceObject{DatasetUri: datasetUri, Size: size, Name: name, VersionId: versionId, Path: path, CopySource: copySource}, nil
+	bucket, key, err := utils.BucketAndKey(datasetUri)
+	if err != nil {
+		return nil, err
+	}
+	return &SourceObject{DatasetUri: datasetUri, Size: size, Name: name, VersionId: versionId, Path: path, CopySource: copySource,
+		Bucket: bucket, Key: key}, nil
 }
 
 func (s *SourceObject) GetSize() int64 {
@@ -39,6 +46,18 @@ func (s *SourceObject) GetCopySource() string {
 	return s.CopySource
 }
 
+func (s *SourceObject) GetBucket() string {
+	return s.Bucket
+}
+
+func (s *SourceObject) GetKey() string {
+	return s.Key
+}
+
+func (s *SourceObject) GetVersionID() string {
+	return s.VersionId
+}
+
 // DestinationObject implements Destination
 type DestinationObject struct {
 	Bucket string
@@ -0,0 +1,211 @@
+package task
+
+import (
+	"context"
+	"github.com/pennsieve/rehydration-service/fargate/objects"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+)
+
+// numConcurrentStatusChecks is how many source objects have their archive status checked at once
+const numConcurrentStatusChecks = 20
+
+// waitForRestores returns the Resume from restoreArchived if restorePolicy.Resumable, so that the task can exit until
+// the dispatcher starts it again. Without a dispatcher to do that, it checks on the restores every PollInterval until
+// restoreArchived says that copying can start, and always returns nil.
+func (dr *DatasetRehydrator) waitForRestores(ctx context.Context, rehydrations []*Rehydration, progress *progressReporter) *idempotency.Resume {
+	for {
+		resume := dr.restoreArchived(ctx, rehydrations, progress)
+		if resume == nil || dr.restorePolicy.Resumable {
+			return resume
+		}
+		checkAt := time.Now().Add(dr.restorePolicy.PollInterval)
+		if resume.ResumeAt.Before(checkAt) {
+			checkAt = resume.ResumeAt
+		}
+		select {
+		case <-ctx.Done():
+			dr.logger.Warn("not waiting for restores", slog.Any("error", ctx.Err()))
+			return nil
+		case <-time.After(time.Until(checkAt)):
+		}
+		// later checks are like those of a task started again by the dispatcher
+		dr.resumed = resume
+	}
+}
+
+// restoreArchived requests restores of any archived sources in rehydrations. If some are still being restored, and
+// restorePolicy.MaxWait has not passed since restores were first requested, it returns the Resume that the dispatcher
+// needs to start the task again to check on them, and nothing should be copied in the meantime. Otherwise it returns
+// nil, and sources whose status cannot be found, or that are still archived after MaxWait, are left to fail when they
+// are copied.
+func (dr *DatasetRehydrator) restoreArchived(ctx context.Context, rehydrations []*Rehydration, progress *progressReporter) *idempotency.Resume {
+	if dr.restorer == nil {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "task.RestoreArchived")
+	defer span.End()
+
+	candidates := dr.mayBeArchived(ctx, rehydrations)
+	var waiting, restored int
+	var expectedRestoreTime time.Duration
+	for i, status := range dr.archiveStatuses(ctx, candidates) {
+		if status == nil || !status.Archived {
+			continue
+		}
+		if status.Restored {
+			restored++
+			continue
+		}
+		src := candidates[i].Src
+		if !status.RestoreRequested {
+			if err := dr.restorer.Restore(ctx, src, *status); err != nil {
+				dr.logger.Warn("error requesting restore of archived file", objects.SourceLogGroup(src), slog.Any("error", err))
+				continue
+			}
+		}
+		waiting++
+		expectedRestoreTime = max(expectedRestoreTime, status.ExpectedRestoreTime(dr.restorePolicy.Tier))
+	}
+	span.SetAttributes(attribute.Int("restore.candidates", len(candidates)),
+		attribute.Int("restore.files", waiting),
+		attribute.Int("restore.restored", restored))
+	if waiting == 0 && restored == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	requestedAt := now
+	if dr.resumed != nil {
+		requestedAt = dr.resumed.RestoreRequestedAt
+	}
+	expectedReadyAt := requestedAt.Add(expectedRestoreTime)
+	progress.restore(waiting+restored, restored, string(dr.restorePolicy.Tier), requestedAt, expectedReadyAt)
+	if waiting == 0 {
+		progress.copying(ctx)
+		dr.metrics.Put("RestoreWait", metrics.Milliseconds, float64(now.Sub(requestedAt).Milliseconds()), nil)
+		return nil
+	}
+	deadline := requestedAt.Add(dr.restorePolicy.MaxWait)
+	if !now.Before(deadline) {
+		dr.logger.Warn("archived files were not restored in time",
+			slog.Int("fileCount", waiting),
+			slog.Duration("maxWait", dr.restorePolicy.MaxWait))
+		progress.copying(ctx)
+		return nil
+	}
+
+	// check no sooner than the restores are expected to finish, or PollInterval if they are late, and no later than
+	// the deadline
+	resumeAt := now.Add(dr.restorePolicy.PollInterval)
+	if expectedReadyAt.After(resumeAt) {
+		resumeAt = expectedReadyAt
+	}
+	if resumeAt.After(deadline) {
+		resumeAt = deadline
+	}
+	dr.logger.Info("waiting for archived files to be restored",
+		slog.Int("fileCount", waiting),
+		slog.String("tier", string(dr.restorePolicy.Tier)),
+		slog.Time("expectedReadyAt", expectedReadyAt),
+		slog.Time("resumeAt", resumeAt))
+	progress.restoring(ctx)
+	if dr.resumed == nil {
+		if dr.onRestoring != nil {
+			dr.onRestoring(ctx, waiting, expectedReadyAt)
+		}
+		dr.metrics.Put("FilesRestored", metrics.Count, float64(waiting), nil)
+	}
+	resume := &idempotency.Resume{
+		Dataset:            *dr.dataset,
+		Destination:        dr.destination,
+		RequestID:          dr.requestID,
+		RestoreRequestedAt: requestedAt,
+		ResumeAt:           resumeAt,
+	}
+	if dr.user != nil {
+		resume.User = *dr.user
+	}
+	return resume
+}
+
+// mayBeArchived returns the rehydrations whose sources may be archived, going by the storage classes listed under the
+// common prefix of the sources in each bucket. Sources that are not listed, including all those in a bucket that
+// cannot be listed, may be archived.
+func (dr *DatasetRehydrator) mayBeArchived(ctx context.Context, rehydrations []*Rehydration) []*Rehydration {
+	prefixes := map[string]string{}
+	for _, rehydration := range rehydrations {
+		bucket, key := rehydration.Src.GetBucket(), rehydration.Src.GetKey()
+		if prefix, found := prefixes[bucket]; found {
+			prefixes[bucket] = commonPrefix(prefix, key)
+		} else {
+			prefixes[bucket] = key
+		}
+	}
+	storageClasses := map[objects.ObjectVersion]string{}
+	for bucket, prefix := range prefixes {
+		listed, err := dr.restorer.StorageClasses(ctx, bucket, prefix)
+		if err != nil {
+			dr.logger.Warn("error listing storage classes, checking each file instead",
+				slog.String("bucket", bucket),
+				slog.String("prefix", prefix),
+				slog.Any("error", err))
+			continue
+		}
+		maps.Copy(storageClasses, listed)
+	}
+	var candidates []*Rehydration
+	for _, rehydration := range rehydrations {
+		if storageClass, listed := storageClasses[objects.VersionOf(rehydration.Src)]; listed && !objects.MayBeArchived(storageClass) {
+			continue
+		}
+		candidates = append(candidates, rehydration)
+	}
+	return candidates
+}
+
+// commonPrefix returns the longest prefix of a and b
+func commonPrefix(a, b string) string {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return a[:i]
+		}
+	}
+	return a[:n]
+}
+
+// archiveStatuses returns the archive status of each source in rehydrations, in the same order. The status is nil if
+// it could not be found.
+func (dr *DatasetRehydrator) archiveStatuses(ctx context.Context, rehydrations []*Rehydration) []*objects.ArchiveStatus {
+	statuses := make([]*objects.ArchiveStatus, len(rehydrations))
+	indexes := make(chan int, len(rehydrations))
+	for i := range rehydrations {
+		indexes <- i
+	}
+	close(indexes)
+	var wg sync.WaitGroup
+	for w := 0; w < min(numConcurrentStatusChecks, len(rehydrations)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				src := rehydrations[i].Src
+				status, err := dr.restorer.Status(ctx, src)
+				if err != nil {
+					dr.logger.Warn("error checking whether file is archived", objects.SourceLogGroup(src), slog.Any("error", err))
+					continue
+				}
+				statuses[i] = &status
+			}
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
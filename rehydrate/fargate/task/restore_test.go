@@ -0,0 +1,297 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pennsieve/rehydration-service/fargate/config"
+	"github.com/pennsieve/rehydration-service/fargate/objects"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/test/discovertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRehydrate_RestoreArchived(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+	taskEnv := newTestConfigEnv()
+	dataset := taskEnv.Dataset
+	testDatasetFiles := discovertest.NewTestDatasetFiles(*dataset, 5).WithFakeS3VersionsIDs()
+	glacierPath := testDatasetFiles.Files[1].Path
+	deepArchivePath := testDatasetFiles.Files[3].Path
+	unlistedPath := testDatasetFiles.Files[4].Path
+
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetMetadataByVersionHandlerBuilder(*dataset, testDatasetFiles.DatasetFiles()),
+		discovertest.GetDatasetFileByVersionHandlerBuilder(*dataset, "discover-bucket", testDatasetFiles.ByPath),
+	)
+	defer mockDiscover.Teardown()
+	taskEnv.PennsieveHost = mockDiscover.Server.URL
+	taskEnv.RestorePolicy = config.RestorePolicy{Tier: s3types.TierStandard, Days: 1, PollInterval: 15 * time.Minute, MaxWait: 60 * time.Hour, Resumable: true}
+
+	listed := map[objects.ObjectVersion]string{}
+	for _, file := range testDatasetFiles.Files {
+		storageClass := string(s3types.StorageClassStandard)
+		switch file.Path {
+		case glacierPath:
+			storageClass = string(s3types.StorageClassGlacier)
+		case deepArchivePath:
+			storageClass = string(s3types.StorageClassDeepArchive)
+		case unlistedPath:
+			continue
+		}
+		key := objects.ObjectVersion{Bucket: "discover-bucket", Key: fmt.Sprintf("%d/%s", dataset.ID, file.Path), VersionID: file.S3VersionID}
+		listed[key] = storageClass
+	}
+	glacier := objects.ArchiveStatus{StorageClass: string(s3types.StorageClassGlacier), Archived: true}
+	deepArchive := objects.ArchiveStatus{StorageClass: string(s3types.StorageClassDeepArchive), Archived: true, RestoreRequested: true}
+	restored := func(status objects.ArchiveStatus) objects.ArchiveStatus {
+		status.RestoreRequested, status.Restored = true, true
+		return status
+	}
+	now := time.Now()
+
+	for testName, testParams := range map[string]struct {
+		statuses map[string]objects.ArchiveStatus
+		// resumedAfter is how long ago restores were first requested, if the task is being started again
+		resumedAfter            time.Duration
+		expectedRestoreRequests []string
+		expectedNotifiedCount   int
+		// expectedResumeAt is zero if the task should copy the files
+		expectedResumeAt      time.Time
+		expectedPhases        []idempotency.Phase
+		expectedFilesRestored int
+	}{
+		"first run": {
+			statuses:                map[string]objects.ArchiveStatus{glacierPath: glacier, deepArchivePath: deepArchive},
+			expectedRestoreRequests: []string{glacierPath},
+			expectedNotifiedCount:   2,
+			// the slowest restore is from Deep Archive
+			expectedResumeAt: now.Add(12 * time.Hour),
+			expectedPhases:   []idempotency.Phase{"", idempotency.RestoringPhase},
+		},
+		"resumed, restored": {
+			statuses:              map[string]objects.ArchiveStatus{glacierPath: restored(glacier), deepArchivePath: restored(deepArchive)},
+			resumedAfter:          13 * time.Hour,
+			expectedPhases:        []idempotency.Phase{"", idempotency.CopyingPhase, idempotency.CopyingPhase},
+			expectedFilesRestored: 2,
+		},
+		"resumed, still restoring": {
+			statuses:              map[string]objects.ArchiveStatus{glacierPath: restored(glacier), deepArchivePath: deepArchive},
+			resumedAfter:          13 * time.Hour,
+			expectedResumeAt:      now.Add(15 * time.Minute),
+			expectedPhases:        []idempotency.Phase{"", idempotency.RestoringPhase},
+			expectedFilesRestored: 1,
+		},
+		"resumed, not restored in time": {
+			statuses:              map[string]objects.ArchiveStatus{glacierPath: restored(glacier), deepArchivePath: deepArchive},
+			resumedAfter:          61 * time.Hour,
+			expectedPhases:        []idempotency.Phase{"", idempotency.CopyingPhase, idempotency.CopyingPhase},
+			expectedFilesRestored: 1,
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			ctx := context.Background()
+			restorer := newFakeRestorer(testParams.statuses).withListed(listed)
+			progressStore := &fakeProgressStore{}
+			taskConfig := config.NewConfig(test.NewAWSEndpoints(t).Config(ctx, false), taskEnv)
+			taskConfig.SetObjectProcessor(newFlakyObjectProcessor(nil))
+			taskConfig.SetIdempotencyStore(progressStore)
+			taskConfig.SetRestorer(restorer)
+
+			rehydrator := NewDatasetRehydrator(taskConfig, ThresholdSize)
+			var restoreRequestedAt time.Time
+			if testParams.resumedAfter > 0 {
+				restoreRequestedAt = now.Add(-testParams.resumedAfter)
+				rehydrator.resumed = &idempotency.Resume{RestoreRequestedAt: restoreRequestedAt}
+			}
+			var notifiedCount int
+			rehydrator.onRestoring = func(_ context.Context, fileCount int, _ time.Time) {
+				notifiedCount = fileCount
+			}
+			result, err := rehydrator.rehydrate(ctx)
+			require.NoError(t, err)
+
+			// only files that were not listed, or were listed in an archive storage class, are checked
+			assert.ElementsMatch(t, []string{glacierPath, deepArchivePath, unlistedPath}, restorer.statusChecks)
+			assert.Equal(t, testParams.expectedRestoreRequests, restorer.restoreRequests)
+			assert.Equal(t, testParams.expectedNotifiedCount, notifiedCount)
+			if testParams.expectedResumeAt.IsZero() {
+				assert.Nil(t, result.Resume)
+				assert.Len(t, result.FileResults, len(testDatasetFiles.Files))
+			} else {
+				require.NotNil(t, result.Resume)
+				assert.Empty(t, result.FileResults)
+				assert.WithinDuration(t, testParams.expectedResumeAt, result.Resume.ResumeAt, time.Minute)
+				assert.Equal(t, *dataset, result.Resume.Dataset)
+				assert.Equal(t, *taskEnv.User, result.Resume.User)
+				if testParams.resumedAfter > 0 {
+					assert.Equal(t, restoreRequestedAt, result.Resume.RestoreRequestedAt)
+				} else {
+					assert.WithinDuration(t, now, result.Resume.RestoreRequestedAt, time.Minute)
+				}
+			}
+
+			var phases []idempotency.Phase
+			for _, saved := range progressStore.saved {
+				phases = append(phases, saved.Phase)
+			}
+			assert.Equal(t, testParams.expectedPhases, phases)
+			finalProgress := progressStore.saved[len(progressStore.saved)-1]
+			require.NotNil(t, finalProgress.Restore)
+			assert.Equal(t, 2, finalProgress.Restore.FilesTotal)
+			assert.Equal(t, testParams.expectedFilesRestored, finalProgress.Restore.FilesRestored)
+			assert.Equal(t, string(s3types.TierStandard), finalProgress.Restore.Tier)
+			assert.Equal(t, testParams.expectedResumeAt.IsZero(), finalProgress.Restore.FinishedAt != nil)
+		})
+	}
+}
+
+func TestRehydrate_RestoreArchived_NotResumable(t *testing.T) {
+	test.SetLogLevel(t, slog.LevelError)
+	taskEnv := newTestConfigEnv()
+	dataset := taskEnv.Dataset
+	testDatasetFiles := discovertest.NewTestDatasetFiles(*dataset, 2).WithFakeS3VersionsIDs()
+	glacierPath := testDatasetFiles.Files[1].Path
+
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetMetadataByVersionHandlerBuilder(*dataset, testDatasetFiles.DatasetFiles()),
+		discovertest.GetDatasetFileByVersionHandlerBuilder(*dataset, "discover-bucket", testDatasetFiles.ByPath),
+	)
+	defer mockDiscover.Teardown()
+	taskEnv.PennsieveHost = mockDiscover.Server.URL
+
+	for testName, testParams := range map[string]struct {
+		maxWait time.Duration
+		// restoredAfter is the number of status checks of the archived file after which it is restored
+		restoredAfter         int
+		expectedStatusChecks  int
+		expectedFilesRestored int
+	}{
+		"restored while waiting": {maxWait: time.Hour, restoredAfter: 3, expectedStatusChecks: 4, expectedFilesRestored: 1},
+		"not restored in time":   {maxWait: 100 * time.Millisecond, restoredAfter: 1000},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			ctx := context.Background()
+			taskEnv.RestorePolicy = config.RestorePolicy{Tier: s3types.TierStandard, Days: 1, PollInterval: 10 * time.Millisecond, MaxWait: testParams.maxWait}
+			restorer := newFakeRestorer(map[string]objects.ArchiveStatus{
+				glacierPath: {StorageClass: string(s3types.StorageClassGlacier), Archived: true},
+			})
+			restorer.restoredAfter = testParams.restoredAfter
+			progressStore := &fakeProgressStore{}
+			taskConfig := config.NewConfig(test.NewAWSEndpoints(t).Config(ctx, false), taskEnv)
+			taskConfig.SetObjectProcessor(newFlakyObjectProcessor(nil))
+			taskConfig.SetIdempotencyStore(progressStore)
+			taskConfig.SetRestorer(restorer)
+
+			rehydrator := NewDatasetRehydrator(taskConfig, ThresholdSize)
+			var notifiedCount int
+			rehydrator.onRestoring = func(_ context.Context, _ int, _ time.Time) {
+				notifiedCount++
+			}
+			result, err := rehydrator.rehydrate(ctx)
+			require.NoError(t, err)
+
+			// without a dispatcher to start it again, the task copies in the same run
+			assert.Nil(t, result.Resume)
+			assert.Len(t, result.FileResults, len(testDatasetFiles.Files))
+			assert.Equal(t, []string{glacierPath}, restorer.restoreRequests)
+			assert.Equal(t, 1, notifiedCount)
+			if testParams.expectedStatusChecks > 0 {
+				assert.Len(t, restorer.statusChecks, len(testDatasetFiles.Files)*testParams.expectedStatusChecks)
+			}
+			finalProgress := progressStore.saved[len(progressStore.saved)-1]
+			assert.Equal(t, idempotency.CopyingPhase, finalProgress.Phase)
+			require.NotNil(t, finalProgress.Restore)
+			assert.Equal(t, testParams.expectedFilesRestored, finalProgress.Restore.FilesRestored)
+		})
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	assert.Equal(t, "13/files/", commonPrefix("13/files/a.csv", "13/files/b.csv"))
+	assert.Equal(t, "13/", commonPrefix("13/files/a.csv", "13/other.csv"))
+	assert.Equal(t, "13/a.csv", commonPrefix("13/a.csv", "13/a.csv.gz"))
+	// S3 prefixes need not end at a '/'
+	assert.Equal(t, "1", commonPrefix("13/a.csv", "14/a.csv"))
+	assert.Equal(t, "", commonPrefix("13/a.csv", "23/a.csv"))
+}
+
+func TestArchiveStatus_ExpectedRestoreTime(t *testing.T) {
+	glacier := objects.ArchiveStatus{StorageClass: string(s3types.StorageClassGlacier), Archived: true}
+	deepArchive := objects.ArchiveStatus{StorageClass: string(s3types.StorageClassDeepArchive), Archived: true}
+
+	assert.Equal(t, 5*time.Minute, glacier.ExpectedRestoreTime(s3types.TierExpedited))
+	assert.Equal(t, 5*time.Hour, glacier.ExpectedRestoreTime(s3types.TierStandard))
+	assert.Equal(t, 12*time.Hour, glacier.ExpectedRestoreTime(s3types.TierBulk))
+	// Expedited is not available for Deep Archive
+	assert.Equal(t, s3types.TierStandard, deepArchive.Tier(s3types.TierExpedited))
+	assert.Equal(t, 12*time.Hour, deepArchive.ExpectedRestoreTime(s3types.TierExpedited))
+	assert.Equal(t, 48*time.Hour, deepArchive.ExpectedRestoreTime(s3types.TierBulk))
+}
+
+// fakeRestorer reports the statuses it is given by path. Paths without a status are not archived. Versions missing
+// from listed are not listed by StorageClasses.
+type fakeRestorer struct {
+	mu              sync.Mutex
+	listed          map[objects.ObjectVersion]string
+	statuses        map[string]objects.ArchiveStatus
+	statusChecks    []string
+	restoreRequests []string
+	// restoredAfter is the number of status checks of a path after which a requested restore is finished. Zero if
+	// restores never finish.
+	restoredAfter int
+	checkCounts   map[string]int
+}
+
+func newFakeRestorer(statuses map[string]objects.ArchiveStatus) *fakeRestorer {
+	return &fakeRestorer{statuses: statuses}
+}
+
+func (r *fakeRestorer) withListed(listed map[objects.ObjectVersion]string) *fakeRestorer {
+	r.listed = listed
+	return r
+}
+
+func (r *fakeRestorer) StorageClasses(_ context.Context, bucket string, prefix string) (map[objects.ObjectVersion]string, error) {
+	storageClasses := map[objects.ObjectVersion]string{}
+	for version, storageClass := range r.listed {
+		if version.Bucket == bucket && strings.HasPrefix(version.Key, prefix) {
+			storageClasses[version] = storageClass
+		}
+	}
+	return storageClasses, nil
+}
+
+func (r *fakeRestorer) Status(_ context.Context, src objects.Source) (objects.ArchiveStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statusChecks = append(r.statusChecks, src.GetPath())
+	status := r.statuses[src.GetPath()]
+	if r.checkCounts == nil {
+		r.checkCounts = map[string]int{}
+	}
+	r.checkCounts[src.GetPath()]++
+	if r.restoredAfter > 0 && status.RestoreRequested && r.checkCounts[src.GetPath()] > r.restoredAfter {
+		status.Restored = true
+	}
+	return status, nil
+}
+
+func (r *fakeRestorer) Restore(_ context.Context, src objects.Source, status objects.ArchiveStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !status.Archived {
+		return fmt.Errorf("%s is not archived", src.GetPath())
+	}
+	r.restoreRequests = append(r.restoreRequests, src.GetPath())
+	status.RestoreRequested = true
+	r.statuses[src.GetPath()] = status
+	return nil
+}
@@ -1,11 +1,14 @@
-package main
+package task
 
 import (
 	"context"
 	"fmt"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
 	"github.com/pennsieve/rehydration-service/shared/models"
 	"github.com/pennsieve/rehydration-service/shared/tracking"
 	"log/slog"
+	"strconv"
 	"time"
 )
 
@@ -16,6 +19,11 @@ func (h *TaskHandler) emailAndLog(ctx context.Context, indexEntries []tracking.D
 		return errs
 	}
 	rehydrationStatus := h.Result.RehydrationStatus()
+	// after any clean up of a failed rehydration, so that its requests are included
+	usage := h.usage()
+
+	var auditEvents []audit.Event
+	defer func() { h.Audit.Record(ctx, auditEvents...) }()
 
 	// If a user clicked rehydrate more than once, try to only send one email per address
 	emailedAddresses := map[string]*time.Time{}
@@ -31,6 +39,7 @@ func (h *TaskHandler) emailAndLog(ctx context.Context, indexEntries []tracking.D
 					slog.String("address", qr.UserEmail),
 					slog.String("addressee", qr.UserName))
 			} else {
+				h.Metrics.Put("EmailSendFailures", metrics.Count, 1, metrics.Dimensions{"RehydrationStatus": string(rehydrationStatus)})
 				errs = append(errs, fmt.Errorf("error sending %s email to %s (%s): %w",
 					rehydrationStatus,
 					qr.UserName,
@@ -41,9 +50,14 @@ func (h *TaskHandler) emailAndLog(ctx context.Context, indexEntries []tracking.D
 				emailSentDate = nil
 			}
 		}
-		if err := h.TrackingStore.EmailSent(ctx, qr.ID, emailSentDate, rehydrationStatus); err != nil {
+		if err := h.TrackingStore.EmailSent(ctx, qr.ID, emailSentDate, rehydrationStatus, usage); err != nil {
 			errs = append(errs, fmt.Errorf("error updating tracking entry: status to %s email to %s: %w", rehydrationStatus, qr.UserEmail, err))
+			continue
 		}
+		// the entries were found by querying for IN_PROGRESS ones
+		auditEvents = append(auditEvents, audit.TrackingEvent(qr, tracking.InProgress, rehydrationStatus).
+			WithDetail(audit.RehydrationLocationDetail, h.Result.RehydrationLocation).
+			WithDetail(audit.EmailSentDetail, strconv.FormatBool(emailSentDate != nil)))
 	}
 	return errs
 }
@@ -66,6 +80,10 @@ func (h *TaskHandler) sendEmail(ctx context.Context, index tracking.DatasetVersi
 		if err := h.Emailer.SendRehydrationFailed(ctx, *taskDataset, user, index.ID); err != nil {
 			return nil, err
 		}
+	} else if h.Result.Partial() {
+		if err := h.Emailer.SendRehydrationPartial(ctx, *taskDataset, user, h.Result.RehydrationLocation, h.Result.MissingFiles, h.Result.ManifestLocation); err != nil {
+			return nil, err
+		}
 	} else {
 		if err := h.Emailer.SendRehydrationComplete(ctx, *taskDataset, user, h.Result.RehydrationLocation); err != nil {
 			return nil, err
@@ -74,3 +92,26 @@ func (h *TaskHandler) sendEmail(ctx context.Context, index tracking.DatasetVersi
 	sentDate := time.Now()
 	return &sentDate, nil
 }
+
+// notifyRestoring tells the users waiting for the rehydration that it is delayed by the restore of fileCount archived
+// files. Errors are only logged since the rehydration carries on regardless.
+func (h *TaskHandler) notifyRestoring(ctx context.Context, fileCount int, expectedReadyAt time.Time) {
+	rehydrator := h.DatasetRehydrator
+	indexEntries, err := h.TrackingStore.QueryDatasetVersionIndexUnhandled(ctx, *rehydrator.dataset, rehydrator.externalDestination, 20)
+	if err != nil {
+		rehydrator.logger.Warn("error finding users to tell about restore", slog.Any("error", err))
+		return
+	}
+	emailedAddresses := map[string]bool{}
+	for _, qr := range indexEntries {
+		if emailedAddresses[qr.UserEmail] {
+			continue
+		}
+		emailedAddresses[qr.UserEmail] = true
+		user := models.User{Name: qr.UserName, Email: qr.UserEmail}
+		if err := h.Emailer.SendRehydrationRestoring(ctx, *rehydrator.dataset, user, fileCount, expectedReadyAt); err != nil {
+			h.Metrics.Put("EmailSendFailures", metrics.Count, 1, metrics.Dimensions{"RehydrationStatus": "RESTORING"})
+			rehydrator.logger.Warn("error sending restoring email", slog.String("address", qr.UserEmail), slog.Any("error", err))
+		}
+	}
+}
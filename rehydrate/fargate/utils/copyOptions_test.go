@@ -0,0 +1,58 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pennsieve/rehydration-service/fargate/utils"
+)
+
+func TestCopyOptions_Apply(t *testing.T) {
+	options := utils.CopyOptions{
+		StorageClass: s3types.StorageClassIntelligentTiering,
+		KMSKeyID:     "arn:aws:kms:us-east-1:123456789012:key/1234abcd",
+		Tags: map[string]string{
+			"dataset-version-id": "2",
+			"dataset-id":         "5065",
+			"request-id":         "a b&c",
+		},
+	}
+	expectedTagging := "dataset-id=5065&dataset-version-id=2&request-id=a+b%26c"
+
+	copyInput := s3.CopyObjectInput{}
+	options.ApplyToCopy(&copyInput)
+	assert.Equal(t, s3types.StorageClassIntelligentTiering, copyInput.StorageClass)
+	assert.Equal(t, s3types.ServerSideEncryptionAwsKms, copyInput.ServerSideEncryption)
+	assert.Equal(t, options.KMSKeyID, aws.ToString(copyInput.SSEKMSKeyId))
+	assert.Equal(t, expectedTagging, aws.ToString(copyInput.Tagging))
+	// otherwise the copy keeps the source's tags
+	assert.Equal(t, s3types.TaggingDirectiveReplace, copyInput.TaggingDirective)
+
+	multipartInput := s3.CreateMultipartUploadInput{}
+	options.ApplyToMultipart(&multipartInput)
+	assert.Equal(t, s3types.StorageClassIntelligentTiering, multipartInput.StorageClass)
+	assert.Equal(t, s3types.ServerSideEncryptionAwsKms, multipartInput.ServerSideEncryption)
+	assert.Equal(t, options.KMSKeyID, aws.ToString(multipartInput.SSEKMSKeyId))
+	assert.Equal(t, expectedTagging, aws.ToString(multipartInput.Tagging))
+
+	putInput := s3.PutObjectInput{}
+	options.ApplyToPut(&putInput)
+	assert.Equal(t, s3types.StorageClassIntelligentTiering, putInput.StorageClass)
+	assert.Equal(t, s3types.ServerSideEncryptionAwsKms, putInput.ServerSideEncryption)
+	assert.Equal(t, options.KMSKeyID, aws.ToString(putInput.SSEKMSKeyId))
+	assert.Equal(t, expectedTagging, aws.ToString(putInput.Tagging))
+}
+
+func TestCopyOptions_Apply_Zero(t *testing.T) {
+	copyInput := s3.CopyObjectInput{}
+	utils.CopyOptions{}.ApplyToCopy(&copyInput)
+	assert.Equal(t, s3.CopyObjectInput{}, copyInput)
+
+	multipartInput := s3.CreateMultipartUploadInput{}
+	utils.CopyOptions{}.ApplyToMultipart(&multipartInput)
+	assert.Equal(t, s3.CreateMultipartUploadInput{}, multipartInput)
+}
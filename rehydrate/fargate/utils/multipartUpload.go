@@ -13,6 +13,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
 )
 
 // maxPartSize constant for number of bits in 50 megabyte chunk
@@ -25,8 +26,22 @@ var partSize int64 = maxPartSize
 // nrCopyWorkers number of threads for multipart uploader
 const nrCopyWorkers = 10
 
-// MultiPartCopy function that starts, perform each part upload, and completes the copy
-func MultiPartCopy(ctx context.Context, svc *s3.Client, fileSize int64, copySource string, destBucket string, destKey string, logger *slog.Logger) error {
+// VersionedSource is the version of an object that MultiPartCopy copies
+type VersionedSource struct {
+	Bucket string
+	// Key is not escaped
+	Key       string
+	VersionID string
+	// CopySource is the escaped form of the source used in UploadPartCopy requests
+	CopySource string
+}
+
+// MultiPartCopy function that starts, perform each part upload, and completes the copy.
+// The copy gets the content headers and user metadata of source, as it would from a simple CopyObject. They are read
+// with sourceSvc, a client for the source's region, since svc is for the destination's region, which may differ.
+// Part failures are counted in recorder, which may be nil.
+func MultiPartCopy(ctx context.Context, svc *s3.Client, sourceSvc *s3.Client, fileSize int64, source VersionedSource, destBucket string, destKey string, options CopyOptions, logger *slog.Logger, recorder *metrics.Recorder) error {
+	copySource := source.CopySource
 
 	partWalker := make(chan s3.UploadPartCopyInput, nrCopyWorkers)
 	results := make(chan s3types.CompletedPart, nrCopyWorkers)
@@ -42,6 +57,18 @@ func MultiPartCopy(ctx context.Context, svc *s3.Client, fileSize int64, copySour
 		Key:          aws.String(destKey),
 		RequestPayer: s3types.RequestPayerRequester,
 	}
+	// unlike CopyObject, CreateMultipartUpload does not take anything from the source
+	headOutput, err := sourceSvc.HeadObject(childCtx, &s3.HeadObjectInput{
+		Bucket:       aws.String(source.Bucket),
+		Key:          aws.String(source.Key),
+		VersionId:    versionID(source.VersionID),
+		RequestPayer: s3types.RequestPayerRequester,
+	})
+	if err != nil {
+		return fmt.Errorf("error getting headers of source: %w", err)
+	}
+	copySourceHeaders(headOutput, &startInput)
+	options.ApplyToMultipart(&startInput)
 
 	//send command to start copy and get the upload id as it is needed later
 	var uploadId string
@@ -69,7 +96,7 @@ func MultiPartCopy(ctx context.Context, svc *s3.Client, fileSize int64, copySour
 	go aggregateResult(done, &parts, results)
 
 	// Wait until all processors are completed.
-	createWorkerPool(childCtx, svc, nrCopyWorkers, uploadId, partWalker, results, logger, destBucket, destKey)
+	createWorkerPool(childCtx, svc, nrCopyWorkers, uploadId, partWalker, results, logger, recorder, destBucket, destKey)
 
 	// Wait until done channel has a value
 	<-done
@@ -102,6 +129,26 @@ func MultiPartCopy(ctx context.Context, svc *s3.Client, fileSize int64, copySour
 	return nil
 }
 
+// copySourceHeaders sets the headers and user metadata that CopyObject copies from its source on input
+func copySourceHeaders(source *s3.HeadObjectOutput, input *s3.CreateMultipartUploadInput) {
+	input.CacheControl = source.CacheControl
+	input.ContentDisposition = source.ContentDisposition
+	input.ContentEncoding = source.ContentEncoding
+	input.ContentLanguage = source.ContentLanguage
+	input.ContentType = source.ContentType
+	input.Expires = source.Expires
+	input.WebsiteRedirectLocation = source.WebsiteRedirectLocation
+	input.Metadata = source.Metadata
+}
+
+// versionID returns nil for an empty ID so that the latest version is used
+func versionID(id string) *string {
+	if len(id) == 0 {
+		return nil
+	}
+	return aws.String(id)
+}
+
 // buildCopySourceRange helper function to build the string for the range of bits to copy
 func buildCopySourceRange(start int64, objectSize int64) string {
 	end := start + partSize - 1
@@ -138,7 +185,7 @@ func allocate(uploadId string, fileSize int64, copySource string, destBucket str
 
 // createWorkerPool creates a worker pool for uploading parts
 func createWorkerPool(ctx context.Context, svc *s3.Client, nrWorkers int, uploadId string,
-	partWalker chan s3.UploadPartCopyInput, results chan s3types.CompletedPart, logger *slog.Logger, destBucket, destKey string) {
+	partWalker chan s3.UploadPartCopyInput, results chan s3types.CompletedPart, logger *slog.Logger, recorder *metrics.Recorder, destBucket, destKey string) {
 
 	defer func() {
 		close(results)
@@ -154,6 +201,7 @@ func createWorkerPool(ctx context.Context, svc *s3.Client, nrWorkers int, upload
 			err := worker(ctx, svc, &copyWg, w, partWalker, results, logger)
 			if err != nil {
 				logger.Error("upload-part worker failed", "worker", w, "error", err)
+				recorder.Put("MultipartPartFailures", metrics.Count, 1, nil)
 				workerFailed = true
 			}
 		}()
@@ -4,12 +4,15 @@ import (
 	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
 	"github.com/pennsieve/rehydration-service/shared/logging"
 	"github.com/pennsieve/rehydration-service/shared/test"
 	"github.com/stretchr/testify/require"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -52,16 +55,48 @@ func TestMultiPartCopy(t *testing.T) {
 	require.True(t, ok)
 	require.NotNil(t, putObjectOut.VersionId)
 
-	copySource := fmt.Sprintf("%s/%s?versionId%s", sourceBucket, sourceKey, aws.ToString(putObjectOut.VersionId))
-	require.NoError(t,
-		MultiPartCopy(ctx, s3Fixture.Client,
-			testFileSize,
-			copySource,
-			targetBucket,
-			targetKey,
-			logging.Default))
+	source := VersionedSource{
+		Bucket:     sourceBucket,
+		Key:        sourceKey,
+		VersionID:  aws.ToString(putObjectOut.VersionId),
+		CopySource: fmt.Sprintf("%s/%s?versionId=%s", sourceBucket, sourceKey, aws.ToString(putObjectOut.VersionId)),
+	}
+	for name, destinationClient := range map[string]*s3.Client{
+		"same region": s3Fixture.Client,
+		"cross region": s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+			o.Region = "eu-west-1"
+			// S3 redirects requests about the source that are sent to the wrong region
+			o.APIOptions = append(o.APIOptions, rejectOperation("HeadObject"))
+		}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			destKey := fmt.Sprintf("%s/%s", name, targetKey)
+			require.NoError(t,
+				MultiPartCopy(ctx, destinationClient, s3Fixture.Client,
+					testFileSize,
+					source,
+					targetBucket,
+					destKey,
+					CopyOptions{},
+					logging.Default,
+					nil))
 
-	s3Fixture.AssertObjectExists(targetBucket, targetKey, testFileSize)
+			s3Fixture.AssertObjectExists(targetBucket, destKey, testFileSize)
+		})
+	}
+}
+
+// rejectOperation returns an APIOptions function that fails requests for the named operations before they are sent
+func rejectOperation(operationNames ...string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("RejectOperation",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				if name := awsmiddleware.GetOperationName(ctx); slices.Contains(operationNames, name) {
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("%s sent to the wrong region", name)
+				}
+				return next.HandleInitialize(ctx, in)
+			}), middleware.After)
+	}
 }
 
 func openTestFile(t *testing.T, name string) *os.File {
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
+)
+
+// streamPartTimeout bounds the read and upload of each part. There is no timeout for the whole stream, since that
+// would have to grow with the file. It is a variable to allow for testing.
+var streamPartTimeout = 15 * time.Minute
+
+// unsignedPayload lets a request body be streamed from a GetObject response, which cannot be read twice to sign it
+var unsignedPayload = s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware)
+
+// StreamCopy copies source by reading it with sourceSvc and writing it with svc, for when no one set of credentials
+// can both read the source and write the destination, so that CopyObject cannot be used.
+// The copy gets the content headers and user metadata of source, as it would from a simple CopyObject.
+func StreamCopy(ctx context.Context, svc *s3.Client, sourceSvc *s3.Client, source VersionedSource, destBucket string, destKey string, options CopyOptions) error {
+	getOutput, err := sourceSvc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(source.Bucket),
+		Key:          aws.String(source.Key),
+		VersionId:    versionID(source.VersionID),
+		RequestPayer: s3types.RequestPayerRequester,
+	})
+	if err != nil {
+		return fmt.Errorf("error reading source: %w", err)
+	}
+	defer getOutput.Body.Close()
+
+	putInput := s3.PutObjectInput{
+		Bucket:                  aws.String(destBucket),
+		Key:                     aws.String(destKey),
+		Body:                    getOutput.Body,
+		ContentLength:           getOutput.ContentLength,
+		CacheControl:            getOutput.CacheControl,
+		ContentDisposition:      getOutput.ContentDisposition,
+		ContentEncoding:         getOutput.ContentEncoding,
+		ContentLanguage:         getOutput.ContentLanguage,
+		ContentType:             getOutput.ContentType,
+		Expires:                 getOutput.Expires,
+		WebsiteRedirectLocation: getOutput.WebsiteRedirectLocation,
+		Metadata:                getOutput.Metadata,
+		RequestPayer:            s3types.RequestPayerRequester,
+	}
+	options.ApplyToPut(&putInput)
+	if _, err := svc.PutObject(ctx, &putInput, unsignedPayload); err != nil {
+		return fmt.Errorf("error writing destination: %w", err)
+	}
+	return nil
+}
+
+// MultiPartStream is MultiPartCopy for when no one set of credentials can both read the source and write the
+// destination. Each part is read from source with sourceSvc and uploaded with svc, rather than copied with
+// UploadPartCopy. The upload is aborted if any part fails, including by taking longer than streamPartTimeout.
+func MultiPartStream(ctx context.Context, svc *s3.Client, sourceSvc *s3.Client, fileSize int64, source VersionedSource, destBucket string, destKey string, options CopyOptions, logger *slog.Logger, recorder *metrics.Recorder) error {
+	startInput := s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(destBucket),
+		Key:          aws.String(destKey),
+		RequestPayer: s3types.RequestPayerRequester,
+	}
+	headOutput, err := sourceSvc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(source.Bucket),
+		Key:          aws.String(source.Key),
+		VersionId:    versionID(source.VersionID),
+		RequestPayer: s3types.RequestPayerRequester,
+	})
+	if err != nil {
+		return fmt.Errorf("error getting headers of source: %w", err)
+	}
+	copySourceHeaders(headOutput, &startInput)
+	options.ApplyToMultipart(&startInput)
+
+	createOutput, err := svc.CreateMultipartUpload(ctx, &startInput)
+	if err != nil {
+		return err
+	}
+	uploadId := aws.ToString(createOutput.UploadId)
+	if uploadId == "" {
+		return errors.New("no upload id found in start upload request")
+	}
+
+	parts, err := streamParts(ctx, svc, sourceSvc, fileSize, source, destBucket, destKey, uploadId, logger)
+	if err != nil {
+		recorder.Put("MultipartPartFailures", metrics.Count, 1, nil)
+		logger.Info("attempting to abort upload")
+		if _, abortErr := svc.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:       aws.String(destBucket),
+			Key:          aws.String(destKey),
+			UploadId:     aws.String(uploadId),
+			RequestPayer: s3types.RequestPayerRequester,
+		}); abortErr != nil {
+			logger.Error("error aborting failed upload session", "error", abortErr)
+		}
+		return err
+	}
+
+	_, err = svc.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(destBucket),
+		Key:             aws.String(destKey),
+		UploadId:        aws.String(uploadId),
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+		RequestPayer:    s3types.RequestPayerRequester,
+	})
+	if err != nil {
+		return fmt.Errorf("error completing upload: %w", err)
+	}
+	logger.Info("multipart stream complete")
+	return nil
+}
+
+// streamParts uploads the parts of source with nrCopyWorkers workers and returns them sorted by part number, or the
+// first error encountered
+func streamParts(ctx context.Context, svc *s3.Client, sourceSvc *s3.Client, fileSize int64, source VersionedSource, destBucket string, destKey string, uploadId string, logger *slog.Logger) ([]s3types.CompletedPart, error) {
+	ctx, cancelFn := context.WithCancel(ctx)
+	defer cancelFn()
+
+	partStarts := make(chan int64, nrCopyWorkers)
+	go func() {
+		defer close(partStarts)
+		for start := int64(0); start < fileSize; start += partSize {
+			select {
+			case partStarts <- start:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		parts    []s3types.CompletedPart
+		firstErr error
+	)
+	for w := 1; w <= nrCopyWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range partStarts {
+				part, err := streamPart(ctx, svc, sourceSvc, fileSize, start, source, destBucket, destKey, uploadId)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancelFn()
+				} else if err == nil {
+					parts = append(parts, part)
+				}
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+				logger.Debug("successfully uploaded part", "partNumber", aws.ToInt32(part.PartNumber), "uploadId", uploadId)
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return *(parts[i].PartNumber) < *(parts[j].PartNumber)
+	})
+	return parts, nil
+}
+
+// streamPart reads the part of source beginning at start and uploads it within streamPartTimeout
+func streamPart(ctx context.Context, svc *s3.Client, sourceSvc *s3.Client, fileSize int64, start int64, source VersionedSource, destBucket string, destKey string, uploadId string) (s3types.CompletedPart, error) {
+	ctx, cancelFn := context.WithTimeout(ctx, streamPartTimeout)
+	defer cancelFn()
+	partNumber := aws.Int32(int32(start/partSize) + 1)
+	getOutput, err := sourceSvc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(source.Bucket),
+		Key:          aws.String(source.Key),
+		VersionId:    versionID(source.VersionID),
+		Range:        aws.String(buildCopySourceRange(start, fileSize)),
+		RequestPayer: s3types.RequestPayerRequester,
+	})
+	if err != nil {
+		return s3types.CompletedPart{}, fmt.Errorf("error reading part %d of source: %w", *partNumber, err)
+	}
+	defer getOutput.Body.Close()
+	partOutput, err := svc.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(destBucket),
+		Key:           aws.String(destKey),
+		UploadId:      aws.String(uploadId),
+		PartNumber:    partNumber,
+		Body:          getOutput.Body,
+		ContentLength: getOutput.ContentLength,
+		RequestPayer:  s3types.RequestPayerRequester,
+	}, unsignedPayload)
+	if err != nil {
+		return s3types.CompletedPart{}, fmt.Errorf("error uploading part %d: %w", *partNumber, err)
+	}
+	return s3types.CompletedPart{ETag: partOutput.ETag, PartNumber: partNumber}, nil
+}
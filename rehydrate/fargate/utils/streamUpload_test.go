@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestStreamCopy(t *testing.T) {
+	// set a lower partSize for the test. This is 5 MiB, the minimum allowed part size
+	partSize = 5242880
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	sourceBucket := "test-stream-source-bucket"
+	sourceKey := "13/files/test-file.dat"
+	targetBucket := "test-stream-target-bucket"
+
+	testFile := openTestFile(t, "multipart-upload-test.dat")
+	defer func() {
+		require.NoError(t, testFile.Close())
+	}()
+	testFileInfo, err := testFile.Stat()
+	require.NoError(t, err)
+	testFileSize := testFileInfo.Size()
+
+	s3Fixture, putObjectOuts := test.NewS3Fixture(t,
+		s3.NewFromConfig(awsConfig),
+		&s3.CreateBucketInput{Bucket: aws.String(sourceBucket)},
+		&s3.CreateBucketInput{Bucket: aws.String(targetBucket)}).
+		WithVersioning(sourceBucket).
+		WithObjects(&s3.PutObjectInput{
+			Bucket:        aws.String(sourceBucket),
+			Key:           aws.String(sourceKey),
+			Body:          testFile,
+			ContentLength: aws.Int64(testFileSize),
+			ContentType:   aws.String("application/octet-stream"),
+			Metadata:      map[string]string{"origin": "pennsieve"},
+		})
+	defer s3Fixture.Teardown()
+
+	putObjectOut := putObjectOuts[test.S3Location{Bucket: sourceBucket, Key: sourceKey}]
+	require.NotNil(t, putObjectOut)
+	source := VersionedSource{
+		Bucket:     sourceBucket,
+		Key:        sourceKey,
+		VersionID:  aws.ToString(putObjectOut.VersionId),
+		CopySource: fmt.Sprintf("%s/%s?versionId=%s", sourceBucket, sourceKey, aws.ToString(putObjectOut.VersionId)),
+	}
+	// stands in for a client with the requester's role, which cannot read sources
+	destinationClient := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, rejectOperation("GetObject", "HeadObject", "CopyObject", "UploadPartCopy"))
+	})
+
+	for name, streamFn := range map[string]func(destKey string) error{
+		"simple": func(destKey string) error {
+			return StreamCopy(ctx, destinationClient, s3Fixture.Client, source, targetBucket, destKey, CopyOptions{})
+		},
+		"multipart": func(destKey string) error {
+			return MultiPartStream(ctx, destinationClient, s3Fixture.Client, testFileSize, source, targetBucket, destKey, CopyOptions{}, logging.Default, nil)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			destKey := fmt.Sprintf("13/2/%s/test-file.dat", name)
+			require.NoError(t, streamFn(destKey))
+
+			s3Fixture.AssertObjectExists(targetBucket, destKey, testFileSize)
+			head, err := s3Fixture.Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(targetBucket), Key: aws.String(destKey)})
+			require.NoError(t, err)
+			assert.Equal(t, "application/octet-stream", aws.ToString(head.ContentType))
+			assert.Equal(t, map[string]string{"origin": "pennsieve"}, head.Metadata)
+		})
+	}
+
+	t.Run("part timeout", func(t *testing.T) {
+		defer func(timeout time.Duration) { streamPartTimeout = timeout }(streamPartTimeout)
+		streamPartTimeout = time.Nanosecond
+		destKey := "13/2/timeout/test-file.dat"
+		err := MultiPartStream(ctx, destinationClient, s3Fixture.Client, testFileSize, source, targetBucket, destKey, CopyOptions{}, logging.Default, nil)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		s3Fixture.AssertPrefixEmpty(targetBucket, "13/2/timeout/")
+	})
+}
@@ -2,8 +2,10 @@ package utils
 
 import (
 	"fmt"
+	"github.com/pennsieve/rehydration-service/shared/discover"
 	"net/url"
 	"path"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/smithy-go/encoding/httpbinding"
@@ -19,6 +21,15 @@ func DestinationKey(datasetId int, versionId int, filePath string) string {
 	return path.Join(DestinationKeyPrefix(datasetId, versionId), filePath)
 }
 
+// BucketAndKey returns the bucket and unescaped key of an S3 URI such as s3://bucket/path/to/key
+func BucketAndKey(uri string) (string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", fmt.Errorf("error parsing S3 URI %s: %w", uri, err)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
 func VersionedCopySource(uri string, version string) (string, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
@@ -33,11 +44,7 @@ func VersionedCopySource(uri string, version string) (string, error) {
 }
 
 func GetApiHost(env string) string {
-	if env == "prod" {
-		return "https://api.pennsieve.io"
-
-	}
-	return "https://api.pennsieve.net"
+	return discover.APIHost(env)
 }
 
 func CreateAWSEscapedPath(s string) *string {
@@ -71,3 +71,10 @@ func TestCreateAWSEscapedPath(t *testing.T) {
 	result = utils.CreateAWSEscapedPath(path)
 	assert.Equal(t, *result, "files/primary/sub-P786/P786EmbeddingSchematic.pptx")
 }
+
+func TestBucketAndKey(t *testing.T) {
+	bucket, key, err := utils.BucketAndKey("s3://dev-discover50-use1/85/files/primary/sub-P333/P777 Embedding Schematic.pptx")
+	require.NoError(t, err)
+	assert.Equal(t, "dev-discover50-use1", bucket)
+	assert.Equal(t, "85/files/primary/sub-P333/P777 Embedding Schematic.pptx", key)
+}
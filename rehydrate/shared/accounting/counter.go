@@ -0,0 +1,68 @@
+package accounting
+
+import (
+	"context"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"strings"
+	"sync/atomic"
+)
+
+const counterMiddlewareID = "RehydrationRequestCounter"
+
+// RequestCounter counts the requests made by AWS clients it has been added to. It is safe to share among goroutines.
+//
+// Use it by adding AddToStack to a client's APIOptions:
+//
+//	s3.NewFromConfig(awsConfig, func(o *s3.Options) { o.APIOptions = append(o.APIOptions, counter.AddToStack) })
+type RequestCounter struct {
+	copyObject     atomic.Int64
+	uploadPartCopy atomic.Int64
+	list           atomic.Int64
+	deleteRequests atomic.Int64
+	other          atomic.Int64
+}
+
+func NewRequestCounter() *RequestCounter {
+	return &RequestCounter{}
+}
+
+// AddToStack adds the counting middleware after the retry middleware so that every attempt is counted, since every
+// attempt is billed.
+func (c *RequestCounter) AddToStack(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc(counterMiddlewareID,
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			c.Count(awsmiddleware.GetOperationName(ctx))
+			return next.HandleFinalize(ctx, in)
+		}), middleware.After)
+}
+
+// Count counts one request for the named operation
+func (c *RequestCounter) Count(operationName string) {
+	switch {
+	case operationName == "CopyObject":
+		c.copyObject.Add(1)
+	case operationName == "UploadPartCopy":
+		c.uploadPartCopy.Add(1)
+	case strings.HasPrefix(operationName, "List"):
+		c.list.Add(1)
+	case strings.HasPrefix(operationName, "Delete"):
+		c.deleteRequests.Add(1)
+	default:
+		c.other.Add(1)
+	}
+}
+
+// Usage returns a Usage with only the request counts set. Returns a zero Usage if c is nil.
+func (c *RequestCounter) Usage() Usage {
+	if c == nil {
+		return Usage{}
+	}
+	return Usage{
+		CopyObjectRequests:     c.copyObject.Load(),
+		UploadPartCopyRequests: c.uploadPartCopy.Load(),
+		ListRequests:           c.list.Load(),
+		DeleteRequests:         c.deleteRequests.Load(),
+		OtherRequests:          c.other.Load(),
+	}
+}
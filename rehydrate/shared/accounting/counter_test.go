@@ -0,0 +1,68 @@
+package accounting_test
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRequestCounter(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	counter := accounting.NewRequestCounter()
+	s3Client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, counter.AddToStack)
+	})
+
+	bucket := "test-accounting-bucket"
+	s3Fixture, _ := test.NewS3Fixture(t, s3Client, &s3.CreateBucketInput{Bucket: aws.String(bucket)}).
+		WithObjects(test.GeneratePutObjectInputs(bucket, "source/", 2)...)
+	defer s3Fixture.Teardown()
+	// CreateBucket, HeadBucket from the waiter, and the PutObjects
+	setupRequests := counter.Usage().OtherRequests
+
+	_, err := s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String("dest/copy.txt"),
+		CopySource: aws.String(bucket + "/source/file0.txt"),
+	})
+	require.NoError(t, err)
+	_, err = s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket)})
+	require.NoError(t, err)
+	_, err = s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &types.Delete{Objects: []types.ObjectIdentifier{{Key: aws.String("dest/copy.txt")}}},
+	})
+	require.NoError(t, err)
+
+	usage := counter.Usage()
+	assert.Equal(t, int64(1), usage.CopyObjectRequests)
+	assert.Zero(t, usage.UploadPartCopyRequests)
+	assert.Equal(t, int64(1), usage.ListRequests)
+	assert.Equal(t, int64(1), usage.DeleteRequests)
+	assert.Equal(t, setupRequests, usage.OtherRequests)
+}
+
+func TestRequestCounter_Count(t *testing.T) {
+	counter := accounting.NewRequestCounter()
+	for _, operation := range []string{"CopyObject", "UploadPartCopy", "UploadPartCopy", "ListObjectVersions", "ListParts",
+		"DeleteObject", "CreateMultipartUpload", "CompleteMultipartUpload"} {
+		counter.Count(operation)
+	}
+	assert.Equal(t, accounting.Usage{
+		CopyObjectRequests:     1,
+		UploadPartCopyRequests: 2,
+		ListRequests:           2,
+		DeleteRequests:         1,
+		OtherRequests:          2,
+	}, counter.Usage())
+
+	var nilCounter *accounting.RequestCounter
+	assert.Zero(t, nilCounter.Usage())
+}
@@ -0,0 +1,38 @@
+package accounting
+
+import "time"
+
+// UsageAttrName is the name of the attribute holding a Usage on idempotency records and tracking entries
+const UsageAttrName = "usage"
+
+// Usage is what one run of the rehydration task used. It is saved on the idempotency record and the tracking entries
+// the run handled so that the cost of rehydrations can be reported.
+type Usage struct {
+	// RunID identifies the task run, since one run can handle the requests of several users
+	RunID                  string `dynamodbav:"runId"`
+	BytesCopied            int64  `dynamodbav:"bytesCopied"`
+	ObjectsCopied          int64  `dynamodbav:"objectsCopied"`
+	CopyObjectRequests     int64  `dynamodbav:"copyObjectRequests"`
+	UploadPartCopyRequests int64  `dynamodbav:"uploadPartCopyRequests"`
+	ListRequests           int64  `dynamodbav:"listRequests"`
+	DeleteRequests         int64  `dynamodbav:"deleteRequests"`
+	// OtherRequests are S3 requests that are not one of the above, for example CreateMultipartUpload
+	OtherRequests  int64 `dynamodbav:"otherRequests"`
+	WallTimeMillis int64 `dynamodbav:"wallTimeMillis"`
+}
+
+// Add adds the counts of other to u. RunID is not changed.
+func (u *Usage) Add(other Usage) {
+	u.BytesCopied += other.BytesCopied
+	u.ObjectsCopied += other.ObjectsCopied
+	u.CopyObjectRequests += other.CopyObjectRequests
+	u.UploadPartCopyRequests += other.UploadPartCopyRequests
+	u.ListRequests += other.ListRequests
+	u.DeleteRequests += other.DeleteRequests
+	u.OtherRequests += other.OtherRequests
+	u.WallTimeMillis += other.WallTimeMillis
+}
+
+func (u *Usage) WallTime() time.Duration {
+	return time.Duration(u.WallTimeMillis) * time.Millisecond
+}
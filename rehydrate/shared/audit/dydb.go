@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+)
+
+// TableNameKey is optional. Set it, or BucketKey, to audit rehydrations.
+const TableNameKey = "AUDIT_DYNAMODB_TABLE_NAME"
+const UserEmailIndexName = "UserEmailIndex"
+
+// DyDBStore keeps events in a table keyed by dataset version and event key, with an index on user email. Events
+// without a user email are not in the index.
+type DyDBStore struct {
+	client *dynamodb.Client
+	table  string
+	logger *slog.Logger
+}
+
+func NewStore(client *dynamodb.Client, logger *slog.Logger, tableName string) Store {
+	return &DyDBStore{
+		client: client,
+		table:  tableName,
+		logger: logger,
+	}
+}
+
+func (s *DyDBStore) Append(ctx context.Context, events []Event) error {
+	// the condition keeps an event from replacing an existing one
+	putCondition, err := expression.NewBuilder().
+		WithCondition(expression.AttributeNotExists(expression.Name(EventKeyAttrName))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("error building Append condition: %w", err)
+	}
+	var errs []error
+	for _, event := range events {
+		item, err := event.Item()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+			Item:                     item,
+			TableName:                aws.String(s.table),
+			ConditionExpression:      putCondition.Condition(),
+			ExpressionAttributeNames: putCondition.Names(),
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("error appending audit event %s for %s to %s: %w", event.ID, event.SubjectID, s.table, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *DyDBStore) QueryByDatasetVersion(ctx context.Context, dataset models.Dataset) ([]Event, error) {
+	keyCondition := expression.Key(DatasetVersionAttrName).Equal(expression.Value(dataset.DatasetVersion()))
+	return s.query(ctx, keyCondition, nil)
+}
+
+func (s *DyDBStore) QueryByUserEmail(ctx context.Context, email string) ([]Event, error) {
+	keyCondition := expression.Key(UserEmailAttrName).Equal(expression.Value(email))
+	return s.query(ctx, keyCondition, aws.String(UserEmailIndexName))
+}
+
+// query returns every event matching keyCondition in the table, or in indexName if it is not nil
+func (s *DyDBStore) query(ctx context.Context, keyCondition expression.KeyConditionBuilder, indexName *string) ([]Event, error) {
+	var events []Event
+	var errs []error
+	queryExpression, err := expression.NewBuilder().WithKeyCondition(keyCondition).Build()
+	if err != nil {
+		return nil, fmt.Errorf("error building audit query expression: %w", err)
+	}
+	queryIn := &dynamodb.QueryInput{
+		TableName:                 aws.String(s.table),
+		IndexName:                 indexName,
+		ExpressionAttributeNames:  queryExpression.Names(),
+		ExpressionAttributeValues: queryExpression.Values(),
+		KeyConditionExpression:    queryExpression.KeyCondition(),
+	}
+	var lastEvaluatedKey map[string]types.AttributeValue
+	for runQuery := true; runQuery; runQuery = len(lastEvaluatedKey) != 0 {
+		queryIn.ExclusiveStartKey = lastEvaluatedKey
+		queryOut, err := s.client.Query(ctx, queryIn)
+		if err != nil {
+			return nil, fmt.Errorf("error querying audit events: %w", err)
+		}
+		lastEvaluatedKey = queryOut.LastEvaluatedKey
+		for _, i := range queryOut.Items {
+			event, err := FromItem(i)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			events = append(events, *event)
+		}
+	}
+	return events, errors.Join(errs...)
+}
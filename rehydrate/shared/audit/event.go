@@ -0,0 +1,149 @@
+// Package audit keeps an append-only record of the lifecycle of rehydrations: who requested them, what was copied,
+// when they expired, and who was emailed. An Event is written for every change of status of an idempotency record or
+// tracking entry, and the events can be queried by dataset version or by user email.
+package audit
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pennsieve/rehydration-service/shared/dydbutils"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"regexp"
+	"time"
+)
+
+// Source is the component that wrote an Event
+type Source string
+
+const (
+	ServiceSource    Source = "service"
+	DispatcherSource Source = "dispatcher"
+	TaskSource       Source = "task"
+	ExpirationSource Source = "expiration"
+)
+
+// Subject is the kind of item whose status changed
+type Subject string
+
+const (
+	IdempotencySubject Subject = "idempotency"
+	TrackingSubject    Subject = "tracking"
+)
+
+// Deleted is the Status of an Event for an idempotency record that was deleted
+const Deleted = "DELETED"
+
+// Keys of Event.Detail
+const (
+	RehydrationLocationDetail = "rehydrationLocation"
+	DestinationDetail         = "destination"
+	TaskARNDetail             = "fargateTaskARN"
+	FilesCopiedDetail         = "filesCopied"
+	BytesCopiedDetail         = "bytesCopied"
+	MissingFilesDetail        = "missingFileCount"
+	FilesDeletedDetail        = "filesDeleted"
+	EmailSentDetail           = "emailSent"
+	ReasonDetail              = "reason"
+)
+
+// DatasetVersionAttrName, EventKeyAttrName, and UserEmailAttrName should match the dynamodbav struct tags in Event
+const DatasetVersionAttrName = "datasetVersion"
+const EventKeyAttrName = "eventKey"
+const UserEmailAttrName = "userEmail"
+
+// eventKeyTimeLayout has a fixed number of fractional digits so that event keys sort by time
+const eventKeyTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// Event is a change in the status of an idempotency record or tracking entry
+type Event struct {
+	DatasetVersion string `dynamodbav:"datasetVersion" json:"datasetVersion"`
+	// EventKey orders the events of a dataset version or user by Time. It is set by Item.
+	EventKey  string    `dynamodbav:"eventKey" json:"-"`
+	ID        string    `dynamodbav:"id" json:"id"`
+	Time      time.Time `dynamodbav:"time" json:"time"`
+	Source    Source    `dynamodbav:"source" json:"source"`
+	Subject   Subject   `dynamodbav:"subject" json:"subject"`
+	SubjectID string    `dynamodbav:"subjectId" json:"subjectId"`
+	// PreviousStatus is empty if the idempotency record or tracking entry is new
+	PreviousStatus string `dynamodbav:"previousStatus,omitempty" json:"previousStatus,omitempty"`
+	Status         string `dynamodbav:"status" json:"status"`
+	// UserName and UserEmail are empty for events that are not on behalf of a particular user, like expirations
+	UserName  string            `dynamodbav:"userName,omitempty" json:"userName,omitempty"`
+	UserEmail string            `dynamodbav:"userEmail,omitempty" json:"userEmail,omitempty"`
+	Detail    map[string]string `dynamodbav:"detail,omitempty" json:"detail,omitempty"`
+}
+
+var recordIDDatasetVersion = regexp.MustCompile(`^[0-9]+/[0-9]+/`)
+
+// IdempotencyEvent returns an Event for the idempotency record recordID changing from previous to status. previous is
+// empty for a new record.
+func IdempotencyEvent(recordID string, previous, status idempotency.Status) Event {
+	datasetVersion := recordID
+	// the IDs of records for external destinations have the destination after the dataset version
+	if match := recordIDDatasetVersion.FindString(recordID); len(match) > 0 {
+		datasetVersion = match
+	}
+	return Event{
+		DatasetVersion: datasetVersion,
+		Subject:        IdempotencySubject,
+		SubjectID:      recordID,
+		PreviousStatus: string(previous),
+		Status:         string(status),
+	}
+}
+
+// IdempotencyDeletedEvent returns an Event for the deletion of the idempotency record recordID, which had status
+// previous
+func IdempotencyDeletedEvent(recordID string, previous idempotency.Status) Event {
+	event := IdempotencyEvent(recordID, previous, "")
+	event.Status = Deleted
+	return event
+}
+
+// TrackingEvent returns an Event for the tracking entry of index changing from previous to status. previous is empty
+// for a new entry.
+func TrackingEvent(index tracking.DatasetVersionIndex, previous, status tracking.RehydrationStatus) Event {
+	return Event{
+		DatasetVersion: index.DatasetVersion,
+		Subject:        TrackingSubject,
+		SubjectID:      index.ID,
+		PreviousStatus: string(previous),
+		Status:         string(status),
+		UserName:       index.UserName,
+		UserEmail:      index.UserEmail,
+	}
+}
+
+// WithUser returns a copy of e for user
+func (e Event) WithUser(user models.User) Event {
+	e.UserName = user.Name
+	e.UserEmail = user.Email
+	return e
+}
+
+// WithDetail returns a copy of e with detail key set to value. Empty values are left out.
+func (e Event) WithDetail(key, value string) Event {
+	if len(value) == 0 {
+		return e
+	}
+	detail := make(map[string]string, len(e.Detail)+1)
+	for k, v := range e.Detail {
+		detail[k] = v
+	}
+	detail[key] = value
+	e.Detail = detail
+	return e
+}
+
+func (e *Event) eventKey() string {
+	return fmt.Sprintf("%s#%s", e.Time.UTC().Format(eventKeyTimeLayout), e.ID)
+}
+
+func (e *Event) Item() (map[string]types.AttributeValue, error) {
+	e.EventKey = e.eventKey()
+	return dydbutils.ItemImpl(e)
+}
+
+var FromItem = dydbutils.FromItem[Event]
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyEvent(t *testing.T) {
+	for name, tst := range map[string]struct {
+		recordID               string
+		expectedDatasetVersion string
+	}{
+		"default destination":  {"1234/5/", "1234/5/"},
+		"external destination": {"1234/5/s3://requester-bucket/prefix/", "1234/5/"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			event := IdempotencyEvent(tst.recordID, idempotency.InProgress, idempotency.Completed)
+			assert.Equal(t, tst.expectedDatasetVersion, event.DatasetVersion)
+			assert.Equal(t, IdempotencySubject, event.Subject)
+			assert.Equal(t, tst.recordID, event.SubjectID)
+			assert.Equal(t, string(idempotency.InProgress), event.PreviousStatus)
+			assert.Equal(t, string(idempotency.Completed), event.Status)
+
+			deleted := IdempotencyDeletedEvent(tst.recordID, idempotency.Expired)
+			assert.Equal(t, tst.expectedDatasetVersion, deleted.DatasetVersion)
+			assert.Equal(t, string(idempotency.Expired), deleted.PreviousStatus)
+			assert.Equal(t, Deleted, deleted.Status)
+		})
+	}
+}
+
+func TestTrackingEvent(t *testing.T) {
+	index := tracking.DatasetVersionIndex{
+		ID:             "tracking-id",
+		DatasetVersion: "1234/5/",
+		UserName:       "First Last",
+		UserEmail:      "first@example.com",
+	}
+	event := TrackingEvent(index, tracking.InProgress, tracking.Completed).
+		WithDetail(EmailSentDetail, "true").
+		WithDetail(ReasonDetail, "")
+	assert.Equal(t, "1234/5/", event.DatasetVersion)
+	assert.Equal(t, TrackingSubject, event.Subject)
+	assert.Equal(t, "tracking-id", event.SubjectID)
+	assert.Equal(t, string(tracking.InProgress), event.PreviousStatus)
+	assert.Equal(t, string(tracking.Completed), event.Status)
+	assert.Equal(t, "First Last", event.UserName)
+	assert.Equal(t, "first@example.com", event.UserEmail)
+	// empty details are left out
+	assert.Equal(t, map[string]string{EmailSentDetail: "true"}, event.Detail)
+}
+
+func TestEvent_WithDetail(t *testing.T) {
+	original := IdempotencyEvent("1/2/", "", idempotency.InProgress).WithDetail(TaskARNDetail, "arn")
+	withLocation := original.WithDetail(RehydrationLocationDetail, "s3://bucket/1/2/")
+	// the original's detail is not changed
+	assert.Equal(t, map[string]string{TaskARNDetail: "arn"}, original.Detail)
+	assert.Equal(t, map[string]string{TaskARNDetail: "arn", RehydrationLocationDetail: "s3://bucket/1/2/"}, withLocation.Detail)
+
+	withUser := original.WithUser(models.User{Name: "First Last", Email: "first@example.com"})
+	assert.Equal(t, "first@example.com", withUser.UserEmail)
+	assert.Empty(t, original.UserEmail)
+}
+
+func TestEvent_Item(t *testing.T) {
+	event := IdempotencyEvent("1/2/", idempotency.Expired, "")
+	event.ID = "event-id"
+	event.Time = time.Date(2026, 10, 1, 12, 0, 0, 5, time.FixedZone("EDT", -4*60*60))
+	event.Source = ExpirationSource
+	item, err := event.Item()
+	require.NoError(t, err)
+	// no user, so not in the user email index
+	assert.NotContains(t, item, UserEmailAttrName)
+
+	fromItem, err := FromItem(item)
+	require.NoError(t, err)
+	assert.Equal(t, "2026-10-01T16:00:00.000000005Z#event-id", fromItem.EventKey)
+	assert.True(t, event.Time.Equal(fromItem.Time))
+	assert.Equal(t, event.SubjectID, fromItem.SubjectID)
+	assert.Equal(t, ExpirationSource, fromItem.Source)
+}
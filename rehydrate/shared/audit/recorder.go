@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"log/slog"
+	"time"
+)
+
+// Recorder appends events from one Source to a Store. A nil *Recorder records nothing, so components can be used
+// without auditing.
+type Recorder struct {
+	store  Store
+	source Source
+	logger *slog.Logger
+}
+
+// NewRecorder returns nil if store is nil
+func NewRecorder(store Store, source Source, logger *slog.Logger) *Recorder {
+	if store == nil {
+		return nil
+	}
+	return &Recorder{
+		store:  store,
+		source: source,
+		logger: logger,
+	}
+}
+
+// WithLogger returns a Recorder like r that logs to logger
+func (r *Recorder) WithLogger(logger *slog.Logger) *Recorder {
+	if r == nil {
+		return nil
+	}
+	return &Recorder{store: r.store, source: r.source, logger: logger}
+}
+
+// Record sets the ID, Time, and Source of events and appends them. Errors are only logged, since a rehydration should
+// not fail because it could not be audited.
+func (r *Recorder) Record(ctx context.Context, events ...Event) {
+	if r == nil || len(events) == 0 {
+		return
+	}
+	now := time.Now()
+	for i := range events {
+		if len(events[i].ID) == 0 {
+			events[i].ID = uuid.NewString()
+		}
+		if events[i].Time.IsZero() {
+			events[i].Time = now
+		}
+		events[i].Source = r.source
+	}
+	if err := r.store.Append(ctx, events); err != nil {
+		r.logger.Error("error appending audit events", slog.Int("count", len(events)), slog.Any("error", err))
+	}
+}
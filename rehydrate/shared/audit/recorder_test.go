@@ -0,0 +1,61 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	Store
+	appended  []Event
+	appendErr error
+}
+
+func (s *fakeStore) Append(_ context.Context, events []Event) error {
+	s.appended = append(s.appended, events...)
+	return s.appendErr
+}
+
+func TestRecorder_Record(t *testing.T) {
+	store := &fakeStore{}
+	recorder := NewRecorder(store, TaskSource, logging.Default)
+	earlier := time.Now().Add(-time.Minute)
+	withTime := IdempotencyEvent("1/2/", idempotency.InProgress, idempotency.Completed)
+	withTime.Time = earlier
+	recorder.Record(context.Background(), IdempotencyEvent("1/2/", idempotency.Completed, idempotency.Expired), withTime)
+
+	require.Len(t, store.appended, 2)
+	for _, event := range store.appended {
+		assert.NotEmpty(t, event.ID)
+		assert.False(t, event.Time.IsZero())
+		assert.Equal(t, TaskSource, event.Source)
+	}
+	assert.NotEqual(t, store.appended[0].ID, store.appended[1].ID)
+	assert.True(t, earlier.Equal(store.appended[1].Time))
+}
+
+func TestRecorder_Record_Error(t *testing.T) {
+	var logs bytes.Buffer
+	store := &fakeStore{appendErr: errors.New("throttled")}
+	recorder := NewRecorder(store, ServiceSource, slog.New(slog.NewJSONHandler(&logs, nil)))
+	recorder.Record(context.Background(), IdempotencyEvent("1/2/", "", idempotency.Queued))
+	assert.Len(t, store.appended, 1)
+	assert.Contains(t, logs.String(), "throttled")
+}
+
+func TestRecorder_Nil(t *testing.T) {
+	recorder := NewRecorder(nil, ServiceSource, logging.Default)
+	assert.Nil(t, recorder)
+	assert.Nil(t, recorder.WithLogger(logging.Default))
+	assert.NotPanics(t, func() {
+		recorder.Record(context.Background(), IdempotencyEvent("1/2/", "", idempotency.Queued))
+	})
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+	"net/url"
+	"sort"
+)
+
+// BucketKey is optional. Set it, or TableNameKey, to audit rehydrations.
+const BucketKey = "AUDIT_S3_BUCKET"
+
+const DatasetsPrefix = "datasets/"
+const UsersPrefix = "users/"
+
+const jsonLinesContentType = "application/x-ndjson"
+
+// S3Store keeps events as JSON Lines objects. Each Append writes new objects, one under DatasetsPrefix for each
+// dataset version and one under UsersPrefix for each user email, so that either query is a listing of a single prefix.
+// Objects are never overwritten since their names are unique.
+type S3Store struct {
+	client *s3.Client
+	bucket string
+	logger *slog.Logger
+}
+
+func NewS3Store(client *s3.Client, logger *slog.Logger, bucket string) Store {
+	return &S3Store{
+		client: client,
+		bucket: bucket,
+		logger: logger,
+	}
+}
+
+// DatasetVersionPrefix is where the events of a dataset version are kept
+func DatasetVersionPrefix(datasetVersion string) string {
+	return DatasetsPrefix + datasetVersion
+}
+
+// UserEmailPrefix is where the events on behalf of a user are kept
+func UserEmailPrefix(email string) string {
+	return UsersPrefix + url.PathEscape(email) + "/"
+}
+
+func (s *S3Store) Append(ctx context.Context, events []Event) error {
+	var prefixes []string
+	byPrefix := map[string][]Event{}
+	add := func(prefix string, event Event) {
+		if _, seen := byPrefix[prefix]; !seen {
+			prefixes = append(prefixes, prefix)
+		}
+		byPrefix[prefix] = append(byPrefix[prefix], event)
+	}
+	for _, event := range events {
+		add(DatasetVersionPrefix(event.DatasetVersion), event)
+		if len(event.UserEmail) > 0 {
+			add(UserEmailPrefix(event.UserEmail), event)
+		}
+	}
+	var errs []error
+	for _, prefix := range prefixes {
+		if err := s.put(ctx, prefix, byPrefix[prefix]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *S3Store) put(ctx context.Context, prefix string, events []Event) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("error marshalling audit event %s for %s: %w", event.ID, event.SubjectID, err)
+		}
+	}
+	// starting with the time of the first event lists the objects of a prefix roughly in order
+	key := fmt.Sprintf("%s%s-%s.jsonl", prefix, events[0].Time.UTC().Format(eventKeyTimeLayout), uuid.NewString())
+	if _, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buffer.Bytes()),
+		ContentType: aws.String(jsonLinesContentType),
+	}); err != nil {
+		return fmt.Errorf("error appending audit events to s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) QueryByDatasetVersion(ctx context.Context, dataset models.Dataset) ([]Event, error) {
+	return s.query(ctx, DatasetVersionPrefix(dataset.DatasetVersion()))
+}
+
+func (s *S3Store) QueryByUserEmail(ctx context.Context, email string) ([]Event, error) {
+	return s.query(ctx, UserEmailPrefix(email))
+}
+
+// query reads every object under prefix. Objects that cannot be read are skipped, and their errors returned along
+// with the events that could be read.
+func (s *S3Store) query(ctx context.Context, prefix string) ([]Event, error) {
+	var events []Event
+	var errs []error
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing s3://%s/%s: %w", s.bucket, prefix, err)
+		}
+		for _, object := range page.Contents {
+			objectEvents, err := s.read(ctx, aws.ToString(object.Key))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			events = append(events, objectEvents...)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].eventKey() < events[j].eventKey()
+	})
+	return events, errors.Join(errs...)
+}
+
+func (s *S3Store) read(ctx context.Context, key string) ([]Event, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading audit events s3://%s/%s: %w", s.bucket, key, err)
+	}
+	defer out.Body.Close()
+	var events []Event
+	decoder := json.NewDecoder(out.Body)
+	for decoder.More() {
+		var event Event
+		if err := decoder.Decode(&event); err != nil {
+			return nil, fmt.Errorf("error unmarshalling audit events s3://%s/%s: %w", s.bucket, key, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"log/slog"
+)
+
+// Store is append-only. Events are never updated or deleted.
+type Store interface {
+	// Append saves events, which must have their ID and Time set
+	Append(ctx context.Context, events []Event) error
+	// QueryByDatasetVersion returns the events of dataset, oldest first
+	QueryByDatasetVersion(ctx context.Context, dataset models.Dataset) ([]Event, error)
+	// QueryByUserEmail returns the events on behalf of the user with email, oldest first
+	QueryByUserEmail(ctx context.Context, email string) ([]Event, error)
+}
+
+// Location is where events are kept. At most one of Table and Bucket is set, and neither is if rehydrations are not
+// audited.
+type Location struct {
+	Table  string
+	Bucket string
+}
+
+// LocationFromLookup returns the Location set by TableNameKey or BucketKey. It is an error to set both.
+func LocationFromLookup(lookup shared.LookupFunc) (Location, error) {
+	table, _ := lookup(TableNameKey)
+	bucket, _ := lookup(BucketKey)
+	if len(table) > 0 && len(bucket) > 0 {
+		return Location{}, fmt.Errorf("only one of %s and %s can be set", TableNameKey, BucketKey)
+	}
+	return Location{Table: table, Bucket: bucket}, nil
+}
+
+// StoreFromLookup returns a DyDBStore if lookup finds TableNameKey, or an S3Store if it finds BucketKey. Returns nil
+// with no error if neither is set, since auditing is optional.
+func StoreFromLookup(lookup shared.LookupFunc, awsConfig aws.Config, logger *slog.Logger) (Store, error) {
+	location, err := LocationFromLookup(lookup)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(location.Table) > 0:
+		return NewStore(dynamodb.NewFromConfig(awsConfig), logger, location.Table), nil
+	case len(location.Bucket) > 0:
+		return NewS3Store(s3.NewFromConfig(awsConfig), logger, location.Bucket), nil
+	default:
+		return nil, nil
+	}
+}
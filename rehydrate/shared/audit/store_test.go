@@ -0,0 +1,132 @@
+package audit_test
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var testTableName = "test-audit-table"
+var testBucket = "test-audit-bucket"
+
+func TestDyDBStore(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	dyDB := test.NewDynamoDBFixture(t, awsConfig, test.AuditCreateTableInput(testTableName))
+	defer dyDB.Teardown()
+
+	testStore(t, audit.NewStore(dynamodb.NewFromConfig(awsConfig), logging.Default, testTableName))
+}
+
+func TestS3Store(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	s3Client := s3.NewFromConfig(awsConfig)
+	s3Fixture := test.NewS3Fixture(t, s3Client, &s3.CreateBucketInput{Bucket: aws.String(testBucket)})
+	defer s3Fixture.Teardown()
+
+	testStore(t, audit.NewS3Store(s3Client, logging.Default, testBucket))
+}
+
+// testStore appends the events of a rehydration requested by two users and of another dataset version, then checks
+// that each query returns the expected events in order
+func testStore(t *testing.T, store audit.Store) {
+	ctx := context.Background()
+	dataset := models.Dataset{ID: 1234, VersionID: 5}
+	otherDataset := models.Dataset{ID: 1234, VersionID: 6}
+	user := models.User{Name: "First User", Email: "first+user@example.com"}
+	otherUser := models.User{Name: "Second User", Email: "second@example.com"}
+	recordID := idempotency.RecordID(dataset.ID, dataset.VersionID)
+	start := time.Now().Add(-time.Hour)
+
+	var events []audit.Event
+	add := func(event audit.Event) {
+		event.ID = string(rune('a' + len(events)))
+		event.Time = start.Add(time.Duration(len(events)) * time.Minute)
+		event.Source = audit.ServiceSource
+		events = append(events, event)
+	}
+	requested := tracking.DatasetVersionIndex{ID: "request-1", DatasetVersion: dataset.DatasetVersion(), UserName: user.Name, UserEmail: user.Email}
+	otherRequested := tracking.DatasetVersionIndex{ID: "request-2", DatasetVersion: dataset.DatasetVersion(), UserName: otherUser.Name, UserEmail: otherUser.Email}
+	add(audit.IdempotencyEvent(recordID, "", idempotency.InProgress).WithUser(user))
+	add(audit.TrackingEvent(requested, "", tracking.InProgress))
+	add(audit.TrackingEvent(otherRequested, "", tracking.InProgress))
+	add(audit.IdempotencyEvent(recordID, idempotency.InProgress, idempotency.Completed).WithDetail(audit.FilesCopiedDetail, "10"))
+	add(audit.TrackingEvent(requested, tracking.InProgress, tracking.Completed).WithDetail(audit.EmailSentDetail, "true"))
+	add(audit.TrackingEvent(otherRequested, tracking.InProgress, tracking.Completed).WithDetail(audit.EmailSentDetail, "true"))
+	add(audit.IdempotencyEvent(idempotency.RecordID(otherDataset.ID, otherDataset.VersionID), "", idempotency.Queued).WithUser(user))
+
+	// appended in two calls and out of order to check that queries sort by time
+	require.NoError(t, store.Append(ctx, []audit.Event{events[6], events[3], events[4], events[5]}))
+	require.NoError(t, store.Append(ctx, events[:3]))
+
+	byDataset, err := store.QueryByDatasetVersion(ctx, dataset)
+	require.NoError(t, err)
+	assertEventIDs(t, []string{"a", "b", "c", "d", "e", "f"}, byDataset)
+	assert.Equal(t, "10", byDataset[3].Detail[audit.FilesCopiedDetail])
+	assert.True(t, events[0].Time.Equal(byDataset[0].Time))
+
+	byUser, err := store.QueryByUserEmail(ctx, user.Email)
+	require.NoError(t, err)
+	assertEventIDs(t, []string{"a", "b", "e", "g"}, byUser)
+
+	byOtherUser, err := store.QueryByUserEmail(ctx, otherUser.Email)
+	require.NoError(t, err)
+	assertEventIDs(t, []string{"c", "f"}, byOtherUser)
+
+	none, err := store.QueryByUserEmail(ctx, "nobody@example.com")
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}
+
+func assertEventIDs(t *testing.T, expected []string, actual []audit.Event) {
+	var actualIDs []string
+	for _, event := range actual {
+		actualIDs = append(actualIDs, event.ID)
+	}
+	assert.Equal(t, expected, actualIDs)
+}
+
+func TestStoreFromLookup(t *testing.T) {
+	awsConfig := aws.Config{Region: "us-east-1"}
+	for name, tst := range map[string]struct {
+		env           map[string]string
+		expectedStore audit.Store
+		expectError   bool
+	}{
+		"not set":    {env: map[string]string{}},
+		"table":      {env: map[string]string{audit.TableNameKey: testTableName}, expectedStore: &audit.DyDBStore{}},
+		"bucket":     {env: map[string]string{audit.BucketKey: testBucket}, expectedStore: &audit.S3Store{}},
+		"both":       {env: map[string]string{audit.TableNameKey: testTableName, audit.BucketKey: testBucket}, expectError: true},
+		"empty both": {env: map[string]string{audit.TableNameKey: "", audit.BucketKey: ""}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			lookup := func(key string) (string, bool) {
+				value, set := tst.env[key]
+				return value, set
+			}
+			store, err := audit.StoreFromLookup(lookup, awsConfig, logging.Default)
+			if tst.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tst.expectedStore == nil {
+				assert.Nil(t, store)
+			} else {
+				assert.IsType(t, tst.expectedStore, store)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package deleteretry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pennsieve/rehydration-service/shared/dydbutils"
+	"log/slog"
+)
+
+// TableNameKey is optional. If it is not set, objects that cannot be deleted are only logged.
+const TableNameKey = "DELETE_RETRY_DYNAMODB_TABLE_NAME"
+
+type DyDBStore struct {
+	client *dynamodb.Client
+	table  string
+	logger *slog.Logger
+}
+
+func NewStore(client *dynamodb.Client, logger *slog.Logger, tableName string) Store {
+	return &DyDBStore{
+		client: client,
+		table:  tableName,
+		logger: logger,
+	}
+}
+
+func (s *DyDBStore) Put(ctx context.Context, entries []Entry) error {
+	var errs []error
+	for _, entry := range entries {
+		item, err := entry.Item()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+			Item:      item,
+			TableName: aws.String(s.table),
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("error putting delete retry entry for %s %s to %s: %w", entry.RecordID, entry.Key, s.table, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *DyDBStore) Scan(ctx context.Context) ([]Entry, error) {
+	var entries []Entry
+	var errs []error
+	scanIn := &dynamodb.ScanInput{
+		TableName:      aws.String(s.table),
+		ConsistentRead: aws.Bool(true),
+	}
+	var lastEvaluatedKey map[string]types.AttributeValue
+	for runScan := true; runScan; runScan = len(lastEvaluatedKey) != 0 {
+		scanIn.ExclusiveStartKey = lastEvaluatedKey
+		scanOut, err := s.client.Scan(ctx, scanIn)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning delete retry entries: %w", err)
+		}
+		lastEvaluatedKey = scanOut.LastEvaluatedKey
+		for _, i := range scanOut.Items {
+			entry, err := FromItem(i)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, errors.Join(errs...)
+}
+
+func (s *DyDBStore) Delete(ctx context.Context, entries []Entry) error {
+	var errs []error
+	for _, entry := range entries {
+		if _, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			Key: map[string]types.AttributeValue{
+				RecordIDAttrName: dydbutils.StringAttributeValue(entry.RecordID),
+				KeyAttrName:      dydbutils.StringAttributeValue(entry.Key),
+			},
+			TableName: aws.String(s.table),
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("error deleting delete retry entry for %s %s from %s: %w", entry.RecordID, entry.Key, s.table, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,53 @@
+package deleteretry_test
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/pennsieve/rehydration-service/shared/deleteretry"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var testTableName = "test-delete-retry-table"
+
+func TestDyDBStore(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	dyDBClient := dynamodb.NewFromConfig(awsConfig)
+	store := deleteretry.NewStore(dyDBClient, logging.Default, testTableName)
+
+	dyDB := test.NewDynamoDBFixture(t, awsConfig, test.DeleteRetryCreateTableInput(testTableName))
+	defer dyDB.Teardown()
+
+	failedAt := time.Now()
+	entry1 := deleteretry.NewEntry("1/1/", "s3://rehydrated/1/1/", "1/1/a.txt", "AccessDenied", failedAt)
+	entry2 := deleteretry.NewEntry("1/1/", "s3://rehydrated/1/1/", "1/1/b.txt", "AccessDenied", failedAt)
+	entry3 := deleteretry.NewEntry("2/1/", "s3://rehydrated/2/1/", "2/1/a.txt", "InternalError", failedAt)
+	require.NoError(t, store.Put(ctx, []deleteretry.Entry{entry1, entry2, entry3}))
+
+	// Putting the same object again replaces its entry
+	entry1.Error = "SlowDown"
+	require.NoError(t, store.Put(ctx, []deleteretry.Entry{entry1}))
+
+	entries, err := store.Scan(ctx)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+	_, byRecordID := deleteretry.ByRecordID(entries)
+	require.Len(t, byRecordID["1/1/"], 2)
+	for _, e := range byRecordID["1/1/"] {
+		if e.Key == entry1.Key {
+			assert.Equal(t, "SlowDown", e.Error)
+		}
+	}
+
+	require.NoError(t, store.Delete(ctx, []deleteretry.Entry{entry1, entry2}))
+	entries, err = store.Scan(ctx)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, entry3.Key, entries[0].Key)
+	}
+}
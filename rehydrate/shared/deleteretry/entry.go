@@ -0,0 +1,55 @@
+// Package deleteretry keeps the objects that could not be deleted when a rehydration location was cleaned, so that a
+// later expiration run can finish cleaning the location and delete its idempotency record.
+package deleteretry
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pennsieve/rehydration-service/shared/dydbutils"
+	"time"
+)
+
+// RecordIDAttrName and KeyAttrName should match the dynamodbav struct tags in Entry
+const RecordIDAttrName = "recordId"
+const KeyAttrName = "key"
+
+// Entry is an object that could not be deleted from a rehydration location
+type Entry struct {
+	// RecordID is the ID of the idempotency record of the rehydration. It is left EXPIRED until the location is clean.
+	RecordID string `dynamodbav:"recordId"`
+	// Key is the object's key, or the prefix of the rehydration location if the location could not be cleaned at all
+	Key string `dynamodbav:"key"`
+	// RehydrationLocation is the S3 URI of the location the object is in
+	RehydrationLocation string `dynamodbav:"rehydrationLocation"`
+	// Error is the error from the latest attempt to delete the object
+	Error    string    `dynamodbav:"error"`
+	FailedAt time.Time `dynamodbav:"failedAt"`
+}
+
+// NewEntry returns an Entry for an object that could not be deleted
+func NewEntry(recordID, rehydrationLocation, key, message string, failedAt time.Time) Entry {
+	return Entry{
+		RecordID:            recordID,
+		Key:                 key,
+		RehydrationLocation: rehydrationLocation,
+		Error:               message,
+		FailedAt:            failedAt,
+	}
+}
+
+func (e *Entry) Item() (map[string]types.AttributeValue, error) {
+	return dydbutils.ItemImpl(e)
+}
+
+var FromItem = dydbutils.FromItem[Entry]
+
+// ByRecordID groups entries by RecordID, keeping the order in which each RecordID first appears
+func ByRecordID(entries []Entry) (recordIDs []string, byRecordID map[string][]Entry) {
+	byRecordID = map[string][]Entry{}
+	for _, entry := range entries {
+		if _, seen := byRecordID[entry.RecordID]; !seen {
+			recordIDs = append(recordIDs, entry.RecordID)
+		}
+		byRecordID[entry.RecordID] = append(byRecordID[entry.RecordID], entry)
+	}
+	return
+}
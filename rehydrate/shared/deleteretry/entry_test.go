@@ -0,0 +1,36 @@
+package deleteretry_test
+
+import (
+	"github.com/pennsieve/rehydration-service/shared/deleteretry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestEntry_ItemRoundTrip(t *testing.T) {
+	entry := deleteretry.NewEntry("1234/5/", "s3://rehydrated/1234/5/", "1234/5/files/a.txt", "AccessDenied: Access Denied", time.Now())
+
+	item, err := entry.Item()
+	require.NoError(t, err)
+
+	unmarshalled, err := deleteretry.FromItem(item)
+	require.NoError(t, err)
+	assert.Equal(t, entry.RecordID, unmarshalled.RecordID)
+	assert.Equal(t, entry.Key, unmarshalled.Key)
+	assert.Equal(t, entry.RehydrationLocation, unmarshalled.RehydrationLocation)
+	assert.Equal(t, entry.Error, unmarshalled.Error)
+	assert.True(t, entry.FailedAt.Equal(unmarshalled.FailedAt))
+}
+
+func TestByRecordID(t *testing.T) {
+	failedAt := time.Now()
+	a1 := deleteretry.NewEntry("1/1/", "s3://rehydrated/1/1/", "1/1/a.txt", "error", failedAt)
+	b1 := deleteretry.NewEntry("2/1/", "s3://rehydrated/2/1/", "2/1/b.txt", "error", failedAt)
+	a2 := deleteretry.NewEntry("1/1/", "s3://rehydrated/1/1/", "1/1/c.txt", "error", failedAt)
+
+	recordIDs, byRecordID := deleteretry.ByRecordID([]deleteretry.Entry{a1, b1, a2})
+	assert.Equal(t, []string{"1/1/", "2/1/"}, recordIDs)
+	assert.Equal(t, []deleteretry.Entry{a1, a2}, byRecordID["1/1/"])
+	assert.Equal(t, []deleteretry.Entry{b1}, byRecordID["2/1/"])
+}
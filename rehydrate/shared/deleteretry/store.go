@@ -0,0 +1,13 @@
+package deleteretry
+
+import "context"
+
+type Store interface {
+	// Put saves entries, replacing any already saved for the same objects
+	Put(ctx context.Context, entries []Entry) error
+	// Scan returns every saved entry. The table is expected to be small, since it only holds objects that could not be
+	// deleted even after retries.
+	Scan(ctx context.Context) ([]Entry, error)
+	// Delete removes entries
+	Delete(ctx context.Context, entries []Entry) error
+}
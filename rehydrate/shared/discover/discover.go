@@ -0,0 +1,30 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+	"github.com/pennsieve/rehydration-service/shared/models"
+)
+
+// APIHost returns the Pennsieve API host for the given environment name
+func APIHost(env string) string {
+	if env == "prod" {
+		return "https://api.pennsieve.io"
+
+	}
+	return "https://api.pennsieve.net"
+}
+
+// DatasetSize returns the total size in bytes of the files in the given dataset version according to Discover
+func DatasetSize(ctx context.Context, client *pennsieve.Client, dataset models.Dataset) (int64, error) {
+	metadata, err := client.Discover.GetDatasetMetadataByVersion(ctx, int32(dataset.ID), int32(dataset.VersionID))
+	if err != nil {
+		return 0, fmt.Errorf("error getting metadata for dataset %d version %d: %w", dataset.ID, dataset.VersionID, err)
+	}
+	var size int64
+	for _, f := range metadata.Files {
+		size += f.Size
+	}
+	return size, nil
+}
@@ -0,0 +1,82 @@
+package discover_test
+
+import (
+	"context"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+	"github.com/pennsieve/rehydration-service/shared/discover"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/test/discovertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestDatasetSize(t *testing.T) {
+	dataset := models.Dataset{ID: 1234, VersionID: 3}
+	testFiles := discovertest.NewTestDatasetFiles(dataset, 10)
+	var expectedSize int64
+	for _, f := range testFiles.DatasetFiles() {
+		expectedSize += f.Size
+	}
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.GetDatasetMetadataByVersionHandlerBuilder(dataset, testFiles.DatasetFiles()))
+	defer mockDiscover.Teardown()
+
+	client := pennsieve.NewClient(pennsieve.APIParams{ApiHost: mockDiscover.Server.URL})
+	size, err := discover.DatasetSize(context.Background(), client, dataset)
+	require.NoError(t, err)
+	assert.Equal(t, expectedSize, size)
+}
+
+func TestDatasetSize_Error(t *testing.T) {
+	dataset := models.Dataset{ID: 1234, VersionID: 3}
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		discovertest.ErrorGetDatasetMetadataByVersionHandlerBuilder(dataset, "dataset not found", http.StatusNotFound))
+	defer mockDiscover.Teardown()
+
+	client := pennsieve.NewClient(pennsieve.APIParams{ApiHost: mockDiscover.Server.URL})
+	_, err := discover.DatasetSize(context.Background(), client, dataset)
+	assert.ErrorContains(t, err, "dataset not found")
+}
+
+func TestGetDatasetVersion(t *testing.T) {
+	dataset := models.Dataset{ID: 1234, VersionID: 3}
+	authorization := "Bearer test-token"
+	expectedVersion := discover.DatasetVersion{
+		ID:            dataset.ID,
+		Version:       dataset.VersionID,
+		Status:        discover.EmbargoSucceeded,
+		Embargo:       true,
+		EmbargoAccess: discover.EmbargoAccessGranted,
+	}
+	versionBuilder := test.NewHandlerFuncBuilder(discovertest.GetDatasetVersionPath(dataset)).
+		WithSelectorFunc(func(r *http.Request) (int, any) {
+			assert.Equal(t, authorization, r.Header.Get("Authorization"))
+			return http.StatusOK, expectedVersion
+		})
+	mockDiscover := discovertest.NewServerFixture(t, nil, versionBuilder)
+	defer mockDiscover.Teardown()
+
+	client := pennsieve.NewClient(pennsieve.APIParams{ApiHost: mockDiscover.Server.URL})
+	version, err := discover.GetDatasetVersion(context.Background(), client, dataset, authorization)
+	require.NoError(t, err)
+	assert.Equal(t, &expectedVersion, version)
+}
+
+func TestGetDatasetVersion_Error(t *testing.T) {
+	dataset := models.Dataset{ID: 1234, VersionID: 3}
+	mockDiscover := discovertest.NewServerFixture(t, nil,
+		test.NewHandlerFuncBuilder(discovertest.GetDatasetVersionPath(dataset)).
+			WithStatusCode(http.StatusNotFound).
+			WithModel(discovertest.ErrorResponse("dataset not found", http.StatusNotFound)))
+	defer mockDiscover.Teardown()
+
+	client := pennsieve.NewClient(pennsieve.APIParams{ApiHost: mockDiscover.Server.URL})
+	_, err := discover.GetDatasetVersion(context.Background(), client, dataset, "")
+	var statusError *discover.StatusError
+	require.ErrorAs(t, err, &statusError)
+	assert.Equal(t, http.StatusNotFound, statusError.StatusCode)
+	assert.Contains(t, statusError.Body, "dataset not found")
+}
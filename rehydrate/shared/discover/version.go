@@ -0,0 +1,72 @@
+package discover
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pennsieve/pennsieve-go/pkg/pennsieve"
+	"github.com/pennsieve/rehydration-service/shared/models"
+	"io"
+	"net/http"
+)
+
+// Publish statuses of a dataset version in Discover that mean the version's files exist
+const (
+	PublishSucceeded = "PUBLISH_SUCCEEDED"
+	EmbargoSucceeded = "EMBARGO_SUCCEEDED"
+)
+
+// EmbargoAccessGranted is the embargoAccess value for a user who can see an embargoed dataset
+const EmbargoAccessGranted = "Granted"
+
+// DatasetVersion is the part of Discover's dataset version response needed to decide if a version can be rehydrated.
+// The pennsieve-go model for this response does not include these fields.
+type DatasetVersion struct {
+	ID      int    `json:"id"`
+	Version int    `json:"version"`
+	Status  string `json:"status"`
+	Embargo bool   `json:"embargo"`
+	// EmbargoAccess is only set if the request was made with a user's token
+	EmbargoAccess string `json:"embargoAccess,omitempty"`
+}
+
+// StatusError is returned by GetDatasetVersion for non-200 responses from Discover
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("discover responded with status %d: %s", e.StatusCode, e.Body)
+}
+
+// GetDatasetVersion looks up the given dataset version in Discover. If authorization is not empty it is sent as the
+// Authorization header so that Discover includes the caller's embargo access in the response.
+//
+// This makes the request itself with the client's HTTP client, since pennsieve-go's DiscoverService does not return
+// the status code of failed requests.
+func GetDatasetVersion(ctx context.Context, client *pennsieve.Client, dataset models.Dataset, authorization string) (*DatasetVersion, error) {
+	endpoint := fmt.Sprintf("%s/discover/datasets/%d/versions/%d", client.GetAPIParams().ApiHost, dataset.ID, dataset.VersionID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request for dataset %d version %d: %w", dataset.ID, dataset.VersionID, err)
+	}
+	req.Header.Set("Accept", "application/json; charset=utf-8")
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+	res, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting dataset %d version %d: %w", dataset.ID, dataset.VersionID, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return nil, &StatusError{StatusCode: res.StatusCode, Body: string(body)}
+	}
+	var version DatasetVersion
+	if err := json.NewDecoder(res.Body).Decode(&version); err != nil {
+		return nil, fmt.Errorf("error decoding dataset %d version %d: %w", dataset.ID, dataset.VersionID, err)
+	}
+	return &version, nil
+}
@@ -9,10 +9,27 @@ import (
 const AWSRegionKey = "REGION"
 const RehydrationBucketKey = "REHYDRATION_BUCKET"
 
+// LookupFunc has the same signature as os.LookupEnv so that configuration can be read from something other than
+// the process environment.
+type LookupFunc func(key string) (string, bool)
+
 // NonEmptyFromEnvVar looks up value of env var with the given key and returns an error if the value is not set or
 // is empty. Otherwise, returns the value.
 func NonEmptyFromEnvVar(key string) (string, error) {
-	if value, set := os.LookupEnv(key); !set {
+	return NonEmptyFromLookup(os.LookupEnv, key)
+}
+
+func IntFromEnvVar(key string) (int, error) {
+	return IntFromLookup(os.LookupEnv, key)
+}
+
+func Int64FromEnvVar(key string) (int64, error) {
+	return Int64FromLookup(os.LookupEnv, key)
+}
+
+// NonEmptyFromLookup is NonEmptyFromEnvVar, but with values coming from lookup instead of os.LookupEnv
+func NonEmptyFromLookup(lookup LookupFunc, key string) (string, error) {
+	if value, set := lookup(key); !set {
 		return "", fmt.Errorf("required environment variable %s is not set", key)
 	} else if len(value) == 0 {
 		return "", fmt.Errorf("empty value set for environment variable %s", key)
@@ -21,8 +38,9 @@ func NonEmptyFromEnvVar(key string) (string, error) {
 	}
 }
 
-func IntFromEnvVar(key string) (int, error) {
-	strVal, err := NonEmptyFromEnvVar(key)
+// IntFromLookup is IntFromEnvVar, but with values coming from lookup instead of os.LookupEnv
+func IntFromLookup(lookup LookupFunc, key string) (int, error) {
+	strVal, err := NonEmptyFromLookup(lookup, key)
 	if err != nil {
 		return 0, err
 	}
@@ -35,3 +53,19 @@ func IntFromEnvVar(key string) (int, error) {
 	}
 	return value, nil
 }
+
+// Int64FromLookup is Int64FromEnvVar, but with values coming from lookup instead of os.LookupEnv
+func Int64FromLookup(lookup LookupFunc, key string) (int64, error) {
+	strVal, err := NonEmptyFromLookup(lookup, key)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseInt(strVal, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error converting value %s of %s to int64: %w",
+			strVal,
+			key,
+			err)
+	}
+	return value, nil
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/deleteretry"
 	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
 	"github.com/pennsieve/rehydration-service/shared/s3cleaner"
 	"log/slog"
 	"net/url"
@@ -14,43 +17,67 @@ import (
 
 type Handler struct {
 	idempotencyStore idempotency.Store
+	retryStore       deleteretry.Store
 	cleaner          s3cleaner.Cleaner
 	logger           *slog.Logger
+	metrics          *metrics.Recorder
+	audit            *audit.Recorder
 }
 
-func NewHandler(store idempotency.Store, cleaner s3cleaner.Cleaner, logger *slog.Logger) *Handler {
+// NewHandler creates a Handler. recorder may be nil if no metrics are wanted, and auditRecorder may be nil if
+// expirations are not audited. retryStore may be nil, in which case objects that cannot be deleted are only logged and
+// their idempotency records are left EXPIRED.
+func NewHandler(store idempotency.Store, retryStore deleteretry.Store, cleaner s3cleaner.Cleaner, logger *slog.Logger, recorder *metrics.Recorder, auditRecorder *audit.Recorder) *Handler {
 	return &Handler{
 		idempotencyStore: store,
+		retryStore:       retryStore,
 		cleaner:          cleaner,
 		logger:           logger,
+		metrics:          recorder,
+		audit:            auditRecorder,
 	}
 }
 
 func (h *Handler) Handle(ctx context.Context) error {
 	now := time.Now()
 	h.logger.Info("starting expiration check", slog.Time("time", now))
+	errs := h.retryDeletes(ctx)
 	toExpire, err := h.idempotencyStore.QueryExpirationIndex(ctx, now, 100)
 	if err != nil {
-		return err
+		return errors.Join(append(errs, err)...)
 	}
+	h.metrics.Put("RehydrationsToExpire", metrics.Count, float64(len(toExpire)), nil)
 	if len(toExpire) == 0 {
 		h.logger.Info("no rehydrations to expire")
-		return nil
+		return errors.Join(errs...)
 	}
 	h.logger.Info("expiring rehydrations", slog.Int("countToExpire", len(toExpire)))
 
-	var errs []error
+	var expired, deferred, failed int
 	for _, expIndex := range toExpire {
 		logger := h.logger.With(slog.String("id", expIndex.ID), slog.String("rehydrationLocation", expIndex.RehydrationLocation))
-		errs = append(errs, h.expireByIndex(ctx, logger, expIndex)...)
+		isDeferred, expireErrs := h.expireByIndex(ctx, logger, expIndex)
+		if len(expireErrs) > 0 {
+			errs = append(errs, expireErrs...)
+			failed++
+		} else if isDeferred {
+			deferred++
+		} else {
+			expired++
+		}
 	}
+	h.metrics.Put("RehydrationsExpired", metrics.Count, float64(expired), nil)
+	h.metrics.Put("ExpirationsDeferred", metrics.Count, float64(deferred), nil)
+	h.metrics.Put("ExpirationFailures", metrics.Count, float64(failed), nil)
 	if len(errs) > 0 {
 		return errors.Join(errs...)
 	}
 	return nil
 }
 
-func (h *Handler) expireByIndex(ctx context.Context, logger *slog.Logger, expirationIndex idempotency.ExpirationIndex) (errs []error) {
+// expireByIndex returns deferred true if the rehydration was left for a later run because a rehydration of another
+// version may still be copying its files
+func (h *Handler) expireByIndex(ctx context.Context, logger *slog.Logger, expirationIndex idempotency.ExpirationIndex) (deferred bool, errs []error) {
 	parsed, err := parseRehydrationLocation(expirationIndex.RehydrationLocation)
 	if err != nil {
 		errs = append(errs, err)
@@ -60,33 +87,181 @@ func (h *Handler) expireByIndex(ctx context.Context, logger *slog.Logger, expira
 		slog.Time("expirationDate", *expirationIndex.ExpirationDate))
 	record, err := h.idempotencyStore.ExpireByIndex(ctx, expirationIndex)
 	if err != nil {
+		var referencedError *idempotency.ReferencedError
+		if errors.As(err, &referencedError) {
+			logger.Info("not expiring rehydration while it is referenced by another",
+				slog.Time("referencedUntil", referencedError.ReferencedUntil))
+			deferred = true
+			return
+		}
 		errs = append(errs, fmt.Errorf("error expiring idempotency record %s: %w", expirationIndex.ID, err))
 		return
 	}
+	h.audit.Record(ctx, audit.IdempotencyEvent(record.ID, expirationIndex.Status, idempotency.Expired).
+		WithDetail(audit.RehydrationLocationDetail, record.RehydrationLocation).
+		WithDetail(audit.DestinationDetail, record.ExternalDestination))
+
+	if record.External() {
+		// the files were delivered to the requester's own bucket, so they are the requester's to delete
+		logger.Info("not deleting files of external rehydration", slog.String("externalDestination", record.ExternalDestination))
+		errs = h.deleteRecord(ctx, logger, record)
+		return
+	}
 
 	logger.Info("deleting files for idempotency record")
-	resp, err := h.cleaner.Clean(ctx, parsed.bucket, parsed.prefix)
+	resp, err := h.cleaner.Clean(ctx, parsed.bucket, parsed.prefix, s3cleaner.CleanOptions{MaxObjects: cleanLimit(record)})
 	if err != nil {
 		errs = append(errs, fmt.Errorf("error cleaning rehydration location %s: %w", expirationIndex.RehydrationLocation, err))
+		// the record is already EXPIRED, so without an entry nothing would clean the location or delete the record
+		if err := h.saveLocationRetry(ctx, logger, record.ID, expirationIndex.RehydrationLocation, parsed.prefix, err); err != nil {
+			errs = append(errs, err)
+		}
 		return
 	}
 	logger.Info("deleted files for idempotency record",
 		slog.Int("fileCount", resp.Count),
 		slog.Int("deletedCount", resp.Deleted))
+	h.metrics.Put("ExpiredFilesDeleted", metrics.Count, float64(resp.Deleted), nil)
 	for _, e := range resp.Errors {
 		errs = append(errs, fmt.Errorf("error deleting file from rehydration location %s: %s", expirationIndex.RehydrationLocation, e.Message))
 	}
 	if len(errs) > 0 {
+		if err := h.saveRetries(ctx, logger, record.ID, expirationIndex.RehydrationLocation, resp.Errors); err != nil {
+			errs = append(errs, err)
+		}
 		return
 	}
-	if err := h.idempotencyStore.DeleteRecord(ctx, expirationIndex.ID); err != nil {
-		errs = append(errs, err)
-		return
+	errs = h.deleteRecord(ctx, logger, record)
+	return
+}
+
+// saveRetries saves the objects that could not be deleted so that retryDeletes can finish the job on a later run
+func (h *Handler) saveRetries(ctx context.Context, logger *slog.Logger, recordID, rehydrationLocation string, deleteErrors []s3cleaner.DeleteObjectError) error {
+	failedAt := time.Now()
+	entries := make([]deleteretry.Entry, len(deleteErrors))
+	for i, e := range deleteErrors {
+		entries[i] = deleteretry.NewEntry(recordID, rehydrationLocation, e.Key, e.Message, failedAt)
+	}
+	return h.putRetries(ctx, logger, rehydrationLocation, entries)
+}
+
+// saveLocationRetry saves a rehydration location whose Clean failed outright, with no objects to say were left, so that
+// retryDeletes cleans it on a later run. The entry's key is the location's prefix.
+func (h *Handler) saveLocationRetry(ctx context.Context, logger *slog.Logger, recordID, rehydrationLocation, keyPrefix string, cleanErr error) error {
+	entry := deleteretry.NewEntry(recordID, rehydrationLocation, keyPrefix, cleanErr.Error(), time.Now())
+	return h.putRetries(ctx, logger, rehydrationLocation, []deleteretry.Entry{entry})
+}
+
+func (h *Handler) putRetries(ctx context.Context, logger *slog.Logger, rehydrationLocation string, entries []deleteretry.Entry) error {
+	if h.retryStore == nil {
+		return nil
+	}
+	if err := h.retryStore.Put(ctx, entries); err != nil {
+		return fmt.Errorf("error saving objects to retry deleting from rehydration location %s: %w", rehydrationLocation, err)
+	}
+	logger.Info("saved objects to retry deleting", slog.Int("count", len(entries)))
+	h.metrics.Put("DeletesSavedForRetry", metrics.Count, float64(len(entries)), nil)
+	return nil
+}
+
+// retryDeletes cleans again the rehydration locations which previous runs or failed rehydrations could not clean
+// completely. Once a location is clean, its idempotency record is deleted so that the dataset version can be
+// requested again, and then its entries are removed from the retry store.
+func (h *Handler) retryDeletes(ctx context.Context) []error {
+	if h.retryStore == nil {
+		return nil
+	}
+	entries, err := h.retryStore.Scan(ctx)
+	if err != nil {
+		return []error{err}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	recordIDs, byRecordID := deleteretry.ByRecordID(entries)
+	h.logger.Info("retrying deletes", slog.Int("objectCount", len(entries)), slog.Int("rehydrationCount", len(recordIDs)))
+	var errs []error
+	var cleaned int
+	for _, recordID := range recordIDs {
+		recordEntries := byRecordID[recordID]
+		rehydrationLocation := recordEntries[0].RehydrationLocation
+		logger := h.logger.With(slog.String("id", recordID), slog.String("rehydrationLocation", rehydrationLocation))
+		if retryErrs := h.retryDelete(ctx, logger, recordID, rehydrationLocation, recordEntries); len(retryErrs) > 0 {
+			errs = append(errs, retryErrs...)
+			continue
+		}
+		cleaned++
+	}
+	h.metrics.Put("RetriedRehydrationsCleaned", metrics.Count, float64(cleaned), nil)
+	return errs
+}
+
+func (h *Handler) retryDelete(ctx context.Context, logger *slog.Logger, recordID, rehydrationLocation string, entries []deleteretry.Entry) []error {
+	record, err := h.idempotencyStore.GetRecord(ctx, recordID)
+	if err != nil {
+		return []error{err}
+	}
+	// The record is only deleted once its location is clean, so if it is gone or has been replaced by a new
+	// rehydration, an earlier retry finished cleaning and only failed to remove the entries.
+	if record == nil || record.Status != idempotency.Expired {
+		logger.Info("rehydration location already cleaned; removing retry entries")
+		return h.deleteRetries(ctx, entries)
+	}
+	parsed, err := parseRehydrationLocation(rehydrationLocation)
+	if err != nil {
+		return []error{err}
+	}
+	// the whole location is cleaned rather than just the saved keys, in case the failed clean did not list everything
+	resp, err := h.cleaner.Clean(ctx, parsed.bucket, parsed.prefix, s3cleaner.CleanOptions{MaxObjects: cleanLimit(record)})
+	if err != nil {
+		return []error{fmt.Errorf("error retrying clean of rehydration location %s: %w", rehydrationLocation, err)}
+	}
+	logger.Info("retried deleting files for idempotency record",
+		slog.Int("fileCount", resp.Count),
+		slog.Int("deletedCount", resp.Deleted))
+	if len(resp.Errors) > 0 {
+		var errs []error
+		for _, e := range resp.Errors {
+			errs = append(errs, fmt.Errorf("error retrying delete of file from rehydration location %s: %s", rehydrationLocation, e.Message))
+		}
+		if err := h.saveRetries(ctx, logger, recordID, rehydrationLocation, resp.Errors); err != nil {
+			errs = append(errs, err)
+		}
+		return errs
+	}
+	if errs := h.deleteRecord(ctx, logger, record); len(errs) > 0 {
+		return errs
+	}
+	return h.deleteRetries(ctx, entries)
+}
+
+func (h *Handler) deleteRetries(ctx context.Context, entries []deleteretry.Entry) []error {
+	if err := h.retryStore.Delete(ctx, entries); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+func (h *Handler) deleteRecord(ctx context.Context, logger *slog.Logger, record *idempotency.Record) []error {
+	if err := h.idempotencyStore.DeleteRecord(ctx, record.ID); err != nil {
+		return []error{err}
 	}
 	logger.Info("deleted idempotency record",
 		slog.String("fargateTaskARN", record.FargateTaskARN))
+	h.audit.Record(ctx, audit.IdempotencyDeletedEvent(record.ID, record.Status).
+		WithDetail(audit.RehydrationLocationDetail, record.RehydrationLocation).
+		WithDetail(audit.DestinationDetail, record.ExternalDestination))
+	return nil
+}
 
-	return
+// cleanLimit returns the most keys there should be in record's rehydration location: the files its task copied and
+// the manifest. In a versioned bucket the cleaner counts each key once, however many times it was written. Returns 0, for the cleaner's own limit, if the record does not say how many files were copied, as for
+// a failed rehydration.
+func cleanLimit(record *idempotency.Record) int {
+	if record.Usage == nil || record.Usage.ObjectsCopied == 0 {
+		return 0
+	}
+	return int(record.Usage.ObjectsCopied) + 1
 }
 
 func DateFromNow(rehydrationTTLDays int) time.Time {
@@ -107,6 +282,10 @@ func parseRehydrationLocation(rehydrationLocation string) (parsed struct {
 			rehydrationLocation, err)
 		return
 	}
+	if parsedUrl.Scheme != "s3" || len(parsedUrl.Host) == 0 || len(parsedUrl.RawQuery) > 0 || len(parsedUrl.Fragment) > 0 {
+		err = fmt.Errorf("error parsing rehydration location %s: expected s3://<bucket>/<prefix>", rehydrationLocation)
+		return
+	}
 	parsed.bucket = parsedUrl.Host
 	parsed.prefix = strings.TrimPrefix(parsedUrl.Path, "/")
 	return
@@ -7,10 +7,13 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
 	"github.com/pennsieve/rehydration-service/shared/idempotency"
 	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
 	"github.com/pennsieve/rehydration-service/shared/s3cleaner"
 	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/test/metricstest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -20,8 +23,10 @@ import (
 func TestHandler_Handle(t *testing.T) {
 	idempotencyTable := "idempotency-test-table"
 	bucket := "rehydration-test-bucket"
+	externalBucket := "external-test-bucket"
 	prefixToExpire := "43/1/"
 	prefixToKeep := "43/11/"
+	referencedPrefix := "43/2/"
 	ctx := context.Background()
 	awsConfig := test.NewAWSEndpoints(t).WithMinIO().WithDynamoDB().Config(ctx, false)
 	s3Client := s3.NewFromConfig(awsConfig)
@@ -29,11 +34,18 @@ func TestHandler_Handle(t *testing.T) {
 
 	objectsToExpire := test.GeneratePutObjectInputs(bucket, prefixToExpire, 101)
 	objectsToKeep := test.GeneratePutObjectInputs(bucket, prefixToKeep, 10)
+	// a rehydration of another version may be copying these
+	objectsToKeep = append(objectsToKeep, test.GeneratePutObjectInputs(bucket, referencedPrefix, 5)...)
+	// an expired external rehydration's files belong to the requester and should be kept
+	externalObjects := test.GeneratePutObjectInputs(externalBucket, "data/"+prefixToExpire, 5)
+	objectsToKeep = append(objectsToKeep, externalObjects...)
 
 	putObjectInputs := append(objectsToExpire, objectsToKeep...)
 
 	s3Fixture, _ := test.NewS3Fixture(t, s3Client, &s3.CreateBucketInput{
 		Bucket: aws.String(bucket),
+	}, &s3.CreateBucketInput{
+		Bucket: aws.String(externalBucket),
 	}).WithObjects(putObjectInputs...)
 	defer s3Fixture.Teardown()
 
@@ -45,6 +57,13 @@ func TestHandler_Handle(t *testing.T) {
 		WithRehydrationLocation(fmt.Sprintf("s3://%s/%s", bucket, prefixToExpire)).
 		WithExpirationDate(&toExpireExpirationDate)
 
+	externalDestination := fmt.Sprintf("s3://%s/data/", externalBucket)
+	externalRecord := idempotency.NewRecord(prefixToExpire+externalDestination, idempotency.Completed).
+		WithFargateTaskARN(uuid.NewString()).
+		WithRehydrationLocation(externalDestination + prefixToExpire).
+		WithExternalDestination(externalDestination).
+		WithExpirationDate(&toExpireExpirationDate)
+
 	expectedRecordsPostExpiration := map[string]*idempotency.Record{}
 	toKeepExpirationDate := now.Add(time.Hour * time.Duration(24*2))
 	toKeepRecord := idempotency.NewRecord(prefixToKeep, idempotency.Completed).
@@ -53,24 +72,35 @@ func TestHandler_Handle(t *testing.T) {
 		WithExpirationDate(&toKeepExpirationDate)
 	expectedRecordsPostExpiration[toKeepRecord.ID] = toKeepRecord
 
+	referencedUntil := now.Add(time.Hour)
+	referencedRecord := idempotency.NewRecord(referencedPrefix, idempotency.Completed).
+		WithFargateTaskARN(uuid.NewString()).
+		WithRehydrationLocation(fmt.Sprintf("s3://%s/%s", bucket, referencedPrefix)).
+		WithExpirationDate(&toExpireExpirationDate).
+		WithReferencedUntil(&referencedUntil)
+	expectedRecordsPostExpiration[referencedRecord.ID] = referencedRecord
+
 	inProgressRecord := idempotency.NewRecord("43/17/", idempotency.InProgress).WithFargateTaskARN(uuid.NewString())
 	expectedRecordsPostExpiration[inProgressRecord.ID] = inProgressRecord
 
 	dyDBFixture := test.NewDynamoDBFixture(t, awsConfig, test.IdempotencyCreateTableInput(idempotencyTable)).
-		WithItems(test.ItemersToPutItemInputs(t, idempotencyTable, toKeepRecord, toExpireRecord, inProgressRecord)...)
+		WithItems(test.ItemersToPutItemInputs(t, idempotencyTable, toKeepRecord, toExpireRecord, externalRecord, referencedRecord, inProgressRecord)...)
 	defer dyDBFixture.Teardown()
 
 	logger := logging.Default
 	cleaner, err := s3cleaner.NewCleaner(s3Client, s3cleaner.MaxCleanBatch)
 	require.NoError(t, err)
-	handler := Handler{
-		idempotencyStore: idempotency.NewStore(dyDBClient, logger, idempotencyTable),
-		cleaner:          cleaner,
-		logger:           logger,
-	}
+	metricsSink := metricstest.NewSink()
+	handler := NewHandler(idempotency.NewStore(dyDBClient, logger, idempotencyTable), nil, cleaner, logger, metrics.New(metricsSink, "Test"), nil)
 	err = handler.Handle(ctx)
 	require.NoError(t, err)
 
+	assert.Equal(t, []float64{3}, metricsSink.Values("RehydrationsToExpire", nil))
+	assert.Equal(t, []float64{2}, metricsSink.Values("RehydrationsExpired", nil))
+	assert.Equal(t, []float64{1}, metricsSink.Values("ExpirationsDeferred", nil))
+	assert.Equal(t, []float64{0}, metricsSink.Values("ExpirationFailures", nil))
+	assert.Equal(t, []float64{float64(len(objectsToExpire))}, metricsSink.Values("ExpiredFilesDeleted", nil))
+
 	s3Fixture.AssertPrefixEmpty(bucket, prefixToExpire)
 	for _, expectedKept := range objectsToKeep {
 		key := aws.ToString(expectedKept.Key)
@@ -100,6 +130,18 @@ func TestHandler_Handle(t *testing.T) {
 
 }
 
+func TestCleanLimit(t *testing.T) {
+	record := idempotency.NewRecord("1/2/", idempotency.Expired)
+	assert.Zero(t, cleanLimit(record))
+
+	record.WithUsage(&accounting.Usage{})
+	assert.Zero(t, cleanLimit(record))
+
+	// the copied files and the manifest
+	record.WithUsage(&accounting.Usage{ObjectsCopied: 40})
+	assert.Equal(t, 41, cleanLimit(record))
+}
+
 func TestParseRehydrationLocation(t *testing.T) {
 	expectedBucket := "test-rehydration-bucket"
 	expectedPrefix := "14/7/"
@@ -108,4 +150,14 @@ func TestParseRehydrationLocation(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, expectedBucket, parsed.bucket)
 	assert.Equal(t, expectedPrefix, parsed.prefix)
+
+	for _, invalid := range []string{
+		"https://test-rehydration-bucket.s3.amazonaws.com/14/7/",
+		"s3:///14/7/",
+		"test-rehydration-bucket/14/7/",
+		"s3://test-rehydration-bucket/14/7/?versionId=1",
+	} {
+		_, err := parseRehydrationLocation(invalid)
+		assert.Error(t, err, invalid)
+	}
 }
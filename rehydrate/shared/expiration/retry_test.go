@@ -0,0 +1,205 @@
+package expiration
+
+import (
+	"context"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+	"github.com/pennsieve/rehydration-service/shared/deleteretry"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
+	"github.com/pennsieve/rehydration-service/shared/s3cleaner"
+	"github.com/pennsieve/rehydration-service/shared/test/metricstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type fakeRetryIdempotencyStore struct {
+	idempotency.Store
+	records  map[string]*idempotency.Record
+	toExpire []idempotency.ExpirationIndex
+}
+
+func (s *fakeRetryIdempotencyStore) GetRecord(_ context.Context, recordID string) (*idempotency.Record, error) {
+	return s.records[recordID], nil
+}
+
+func (s *fakeRetryIdempotencyStore) DeleteRecord(_ context.Context, recordID string) error {
+	delete(s.records, recordID)
+	return nil
+}
+
+func (s *fakeRetryIdempotencyStore) QueryExpirationIndex(context.Context, time.Time, int32) ([]idempotency.ExpirationIndex, error) {
+	return s.toExpire, nil
+}
+
+func (s *fakeRetryIdempotencyStore) ExpireByIndex(_ context.Context, index idempotency.ExpirationIndex) (*idempotency.Record, error) {
+	record := s.records[index.ID]
+	record.Status = idempotency.Expired
+	return record, nil
+}
+
+type fakeRetryStore struct {
+	entries map[string]deleteretry.Entry
+}
+
+func (s *fakeRetryStore) Put(_ context.Context, entries []deleteretry.Entry) error {
+	for _, e := range entries {
+		s.entries[e.RecordID+e.Key] = e
+	}
+	return nil
+}
+
+func (s *fakeRetryStore) Scan(context.Context) ([]deleteretry.Entry, error) {
+	var entries []deleteretry.Entry
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s *fakeRetryStore) Delete(_ context.Context, entries []deleteretry.Entry) error {
+	for _, e := range entries {
+		delete(s.entries, e.RecordID+e.Key)
+	}
+	return nil
+}
+
+type fakeAuditStore struct {
+	audit.Store
+	events []audit.Event
+}
+
+func (s *fakeAuditStore) Append(_ context.Context, events []audit.Event) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
+// fakeRetryCleaner fails to delete the keys in failing, fails outright for the prefixes in errs, and records the
+// prefixes it was asked to clean
+type fakeRetryCleaner struct {
+	failing  map[string][]string
+	errs     map[string]error
+	prefixes []string
+}
+
+func (c *fakeRetryCleaner) Clean(_ context.Context, _ string, keyPrefix string, _ s3cleaner.CleanOptions) (*s3cleaner.CleanResponse, error) {
+	c.prefixes = append(c.prefixes, keyPrefix)
+	if err := c.errs[keyPrefix]; err != nil {
+		return nil, err
+	}
+	resp := &s3cleaner.CleanResponse{Count: 5, Deleted: 5}
+	for _, key := range c.failing[keyPrefix] {
+		resp.Deleted--
+		resp.Errors = append(resp.Errors, s3cleaner.DeleteObjectError{Key: key, Message: "AccessDenied"})
+	}
+	return resp, nil
+}
+
+func TestHandler_RetryDeletes(t *testing.T) {
+	failedAt := time.Now().Add(-time.Hour)
+	cleanedNow := deleteretry.NewEntry("1/1/", "s3://rehydrated/1/1/", "1/1/a.txt", "AccessDenied", failedAt)
+	stillFailing := deleteretry.NewEntry("2/1/", "s3://rehydrated/2/1/", "2/1/a.txt", "AccessDenied", failedAt)
+	alreadyCleaned := deleteretry.NewEntry("3/1/", "s3://rehydrated/3/1/", "3/1/a.txt", "AccessDenied", failedAt)
+	replaced := deleteretry.NewEntry("4/1/", "s3://rehydrated/4/1/", "4/1/a.txt", "AccessDenied", failedAt)
+	retryStore := &fakeRetryStore{entries: map[string]deleteretry.Entry{}}
+	require.NoError(t, retryStore.Put(context.Background(), []deleteretry.Entry{cleanedNow, stillFailing, alreadyCleaned, replaced}))
+
+	expirationDate := time.Now().Add(-time.Hour * 24)
+	failingExpiration := idempotency.NewRecord("5/1/", idempotency.Completed).
+		WithRehydrationLocation("s3://rehydrated/5/1/").
+		WithExpirationDate(&expirationDate)
+	idempotencyStore := &fakeRetryIdempotencyStore{
+		records: map[string]*idempotency.Record{
+			cleanedNow.RecordID:   idempotency.NewRecord(cleanedNow.RecordID, idempotency.Expired),
+			stillFailing.RecordID: idempotency.NewRecord(stillFailing.RecordID, idempotency.Expired),
+			// a new rehydration of the version whose location was cleaned by an earlier retry
+			replaced.RecordID:    idempotency.NewRecord(replaced.RecordID, idempotency.InProgress),
+			failingExpiration.ID: failingExpiration,
+		},
+		toExpire: []idempotency.ExpirationIndex{failingExpiration.ExpirationIndex},
+	}
+	cleaner := &fakeRetryCleaner{failing: map[string][]string{
+		"2/1/": {stillFailing.Key},
+		"5/1/": {"5/1/b.txt", "5/1/c.txt"},
+	}}
+	metricsSink := metricstest.NewSink()
+	auditStore := &fakeAuditStore{}
+
+	handler := NewHandler(idempotencyStore, retryStore, cleaner, logging.Default, metrics.New(metricsSink, "Test"),
+		audit.NewRecorder(auditStore, audit.ExpirationSource, logging.Default))
+	assert.Error(t, handler.Handle(context.Background()))
+
+	assert.ElementsMatch(t, []string{"1/1/", "2/1/", "5/1/"}, cleaner.prefixes)
+
+	assert.NotContains(t, idempotencyStore.records, cleanedNow.RecordID)
+	assert.Contains(t, idempotencyStore.records, stillFailing.RecordID)
+	assert.Contains(t, idempotencyStore.records, replaced.RecordID)
+	if assert.Contains(t, idempotencyStore.records, failingExpiration.ID) {
+		assert.Equal(t, idempotency.Expired, idempotencyStore.records[failingExpiration.ID].Status)
+	}
+
+	remaining, err := retryStore.Scan(context.Background())
+	require.NoError(t, err)
+	var remainingKeys []string
+	for _, e := range remaining {
+		remainingKeys = append(remainingKeys, e.Key)
+		if e.Key == stillFailing.Key {
+			assert.True(t, e.FailedAt.After(failedAt))
+		}
+	}
+	assert.ElementsMatch(t, []string{stillFailing.Key, "5/1/b.txt", "5/1/c.txt"}, remainingKeys)
+
+	assert.Equal(t, []float64{3}, metricsSink.Values("RetriedRehydrationsCleaned", nil))
+	assert.ElementsMatch(t, []float64{1, 2}, metricsSink.Values("DeletesSavedForRetry", nil))
+	assert.Equal(t, []float64{1}, metricsSink.Values("ExpirationFailures", nil))
+
+	// the retried location's record is deleted, and the failing one is expired but not deleted
+	var auditEvents []string
+	for _, event := range auditStore.events {
+		assert.Equal(t, audit.ExpirationSource, event.Source)
+		auditEvents = append(auditEvents, event.SubjectID+" "+event.PreviousStatus+" -> "+event.Status)
+	}
+	assert.Equal(t, []string{"1/1/ EXPIRED -> DELETED", "5/1/ COMPLETED -> EXPIRED"}, auditEvents)
+}
+
+func TestHandler_CleanFails(t *testing.T) {
+	expirationDate := time.Now().Add(-time.Hour * 24)
+	record := idempotency.NewRecord("6/1/", idempotency.Completed).
+		WithRehydrationLocation("s3://rehydrated/6/1/").
+		WithExpirationDate(&expirationDate)
+	idempotencyStore := &fakeRetryIdempotencyStore{
+		records:  map[string]*idempotency.Record{record.ID: record},
+		toExpire: []idempotency.ExpirationIndex{record.ExpirationIndex},
+	}
+	retryStore := &fakeRetryStore{entries: map[string]deleteretry.Entry{}}
+	cleaner := &fakeRetryCleaner{errs: map[string]error{
+		"6/1/": &s3cleaner.GuardError{Bucket: "rehydrated", KeyPrefix: "6/1/", Reason: "more than 1 objects under prefix"},
+	}}
+	metricsSink := metricstest.NewSink()
+	handler := NewHandler(idempotencyStore, retryStore, cleaner, logging.Default, metrics.New(metricsSink, "Test"),
+		audit.NewRecorder(&fakeAuditStore{}, audit.ExpirationSource, logging.Default))
+
+	assert.ErrorContains(t, handler.Handle(context.Background()), "more than 1 objects")
+	if assert.Contains(t, idempotencyStore.records, record.ID) {
+		assert.Equal(t, idempotency.Expired, idempotencyStore.records[record.ID].Status)
+	}
+	// the whole location is saved, since the cleaner could not say which objects were left
+	entries, err := retryStore.Scan(context.Background())
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, record.ID, entries[0].RecordID)
+	assert.Equal(t, "6/1/", entries[0].Key)
+	assert.Equal(t, record.RehydrationLocation, entries[0].RehydrationLocation)
+	assert.Contains(t, entries[0].Error, "more than 1 objects")
+	assert.Equal(t, []float64{1}, metricsSink.Values("DeletesSavedForRetry", nil))
+
+	// the next run cleans the location from the entry and deletes the record
+	idempotencyStore.toExpire = nil
+	delete(cleaner.errs, "6/1/")
+	require.NoError(t, handler.Handle(context.Background()))
+	assert.Equal(t, []string{"6/1/", "6/1/"}, cleaner.prefixes)
+	assert.NotContains(t, idempotencyStore.records, record.ID)
+	assert.Empty(t, retryStore.entries)
+}
@@ -0,0 +1,293 @@
+// Package gc removes files from rehydration buckets that no idempotency record accounts for, such as those left behind
+// by a failed cleanup or a manually deleted record, and aborts multipart uploads that were never completed.
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
+	"github.com/pennsieve/rehydration-service/shared/s3cleaner"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GracePeriodHoursKey is how old the newest file under an orphaned location, or an incomplete multipart upload, must
+// be before it is removed. It is optional.
+const GracePeriodHoursKey = "GC_GRACE_PERIOD_HOURS"
+
+// DefaultGracePeriod is long enough that no task can still be writing to a location or upload this old
+const DefaultGracePeriod = 7 * 24 * time.Hour
+
+// now can be replaced in tests
+var now = time.Now
+
+// GracePeriodFromLookup returns DefaultGracePeriod if lookup does not find GracePeriodHoursKey
+func GracePeriodFromLookup(lookup shared.LookupFunc) (time.Duration, error) {
+	if _, set := lookup(GracePeriodHoursKey); !set {
+		return DefaultGracePeriod, nil
+	}
+	hours, err := shared.IntFromLookup(lookup, GracePeriodHoursKey)
+	if err != nil {
+		return 0, err
+	}
+	if hours < 1 {
+		return 0, fmt.Errorf("%s must be positive", GracePeriodHoursKey)
+	}
+	return time.Duration(hours) * time.Hour, nil
+}
+
+type Collector struct {
+	idempotencyStore idempotency.Store
+	cleaner          s3cleaner.Cleaner
+	gracePeriod      time.Duration
+	logger           *slog.Logger
+	metrics          *metrics.Recorder
+}
+
+// NewCollector creates a Collector. recorder may be nil if no metrics are wanted.
+func NewCollector(store idempotency.Store, cleaner s3cleaner.Cleaner, gracePeriod time.Duration, logger *slog.Logger, recorder *metrics.Recorder) *Collector {
+	return &Collector{
+		idempotencyStore: store,
+		cleaner:          cleaner,
+		gracePeriod:      gracePeriod,
+		logger:           logger,
+		metrics:          recorder,
+	}
+}
+
+// Result is what Collect removed from a bucket
+type Result struct {
+	// Orphans are the locations whose files were deleted
+	Orphans        []string
+	FilesDeleted   int
+	UploadsAborted int
+}
+
+// Collect deletes the files under each <dataset>/<version>/ prefix of bucket that has no idempotency record pointing
+// at it and has not been written to for the grace period, then aborts multipart uploads in bucket started before the
+// grace period. client must be for bucket's region. Errors with one location do not stop the others from being
+// collected; they are all returned together with the Result.
+func (c *Collector) Collect(ctx context.Context, client *s3.Client, bucket string) (*Result, error) {
+	cutoff := now().Add(-c.gracePeriod)
+	logger := c.logger.With(slog.String("bucket", bucket))
+	logger.Info("starting garbage collection", slog.Time("cutoff", cutoff))
+
+	result := &Result{}
+	var errs []error
+	locations, err := versionPrefixes(ctx, client, bucket)
+	if err != nil {
+		return result, err
+	}
+	for _, location := range locations {
+		orphan, err := c.orphaned(ctx, client, bucket, location, cutoff)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !orphan {
+			continue
+		}
+		deleted, err := c.deleteOrphan(ctx, logger, bucket, location, cutoff)
+		result.FilesDeleted += deleted
+		if errors.Is(err, errClaimed) {
+			logger.Warn("stopped deleting orphaned rehydration that an idempotency record now accounts for",
+				slog.String("prefix", location.prefix))
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		result.Orphans = append(result.Orphans, location.uri(bucket))
+	}
+
+	aborted, err := c.abortStaleUploads(ctx, logger, client, bucket, cutoff)
+	result.UploadsAborted = aborted
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	logger.Info("finished garbage collection",
+		slog.Int("orphanCount", len(result.Orphans)),
+		slog.Int("filesDeleted", result.FilesDeleted),
+		slog.Int("uploadsAborted", result.UploadsAborted),
+		slog.Int("errorCount", len(errs)))
+	dimensions := metrics.Dimensions{"Bucket": bucket}
+	c.metrics.Put("OrphanedRehydrationsDeleted", metrics.Count, float64(len(result.Orphans)), dimensions)
+	c.metrics.Put("OrphanedFilesDeleted", metrics.Count, float64(result.FilesDeleted), dimensions)
+	c.metrics.Put("StaleUploadsAborted", metrics.Count, float64(result.UploadsAborted), dimensions)
+	c.metrics.Put("GCFailures", metrics.Count, float64(len(errs)), dimensions)
+	return result, errors.Join(errs...)
+}
+
+// location is a <dataset>/<version>/ prefix of a rehydration bucket
+type location struct {
+	datasetID, datasetVersionID int
+	prefix                      string
+}
+
+func (l location) uri(bucket string) string {
+	return fmt.Sprintf("s3://%s/%s", bucket, l.prefix)
+}
+
+// versionPrefixes lists the <dataset>/<version>/ prefixes of bucket. Prefixes that are not made of numbers are left
+// out, since they were not written by a rehydration.
+func versionPrefixes(ctx context.Context, client *s3.Client, bucket string) ([]location, error) {
+	datasetPrefixes, err := commonPrefixes(ctx, client, bucket, "")
+	if err != nil {
+		return nil, err
+	}
+	var locations []location
+	for _, datasetPrefix := range datasetPrefixes {
+		datasetID, err := strconv.Atoi(strings.TrimSuffix(datasetPrefix, "/"))
+		if err != nil {
+			continue
+		}
+		versionPrefixes, err := commonPrefixes(ctx, client, bucket, datasetPrefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, versionPrefix := range versionPrefixes {
+			datasetVersionID, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(versionPrefix, datasetPrefix), "/"))
+			if err != nil {
+				continue
+			}
+			locations = append(locations, location{datasetID: datasetID, datasetVersionID: datasetVersionID, prefix: versionPrefix})
+		}
+	}
+	return locations, nil
+}
+
+func commonPrefixes(ctx context.Context, client *s3.Client, bucket, prefix string) ([]string, error) {
+	var prefixes []string
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			prefixes = append(prefixes, aws.ToString(commonPrefix.Prefix))
+		}
+	}
+	return prefixes, nil
+}
+
+// errClaimed is returned by deleteOrphan if an idempotency record came to account for the location while it was
+// being deleted
+var errClaimed = errors.New("location is accounted for by an idempotency record")
+
+// orphaned returns true if no idempotency record accounts for l and nothing under it was written after cutoff.
+func (c *Collector) orphaned(ctx context.Context, client *s3.Client, bucket string, l location, cutoff time.Time) (bool, error) {
+	if claimed, err := c.claimed(ctx, bucket, l); err != nil || claimed {
+		return false, err
+	}
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(l.prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return false, fmt.Errorf("error listing %s: %w", l.uri(bucket), err)
+		}
+		for _, object := range page.Contents {
+			if aws.ToTime(object.LastModified).After(cutoff) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// claimed returns true if an idempotency record accounts for l. A record without a rehydration location is for a
+// rehydration that is still running, so it accounts for l.
+func (c *Collector) claimed(ctx context.Context, bucket string, l location) (bool, error) {
+	recordID := idempotency.RecordID(l.datasetID, l.datasetVersionID)
+	record, err := c.idempotencyStore.GetRecord(ctx, recordID)
+	if err != nil {
+		return false, fmt.Errorf("error getting idempotency record %s: %w", recordID, err)
+	}
+	return record != nil && (len(record.RehydrationLocation) == 0 || record.RehydrationLocation == l.uri(bucket)), nil
+}
+
+// deleteOrphan deletes the files under l that were last modified before cutoff. A request for the dataset version may
+// have started since orphaned looked, so files written since then are left alone, and the record is looked up again
+// before each batch is deleted. Returns an error wrapping errClaimed if a record has appeared.
+func (c *Collector) deleteOrphan(ctx context.Context, logger *slog.Logger, bucket string, l location, cutoff time.Time) (int, error) {
+	prefix := l.prefix
+	logger = logger.With(slog.String("prefix", prefix))
+	logger.Info("deleting files of orphaned rehydration")
+	// there is no record to say how many objects there should be, so the cleaner's own limit applies
+	resp, err := c.cleaner.Clean(ctx, bucket, prefix, s3cleaner.CleanOptions{
+		ModifiedBefore: cutoff,
+		BeforeBatch: func(ctx context.Context) error {
+			claimed, err := c.claimed(ctx, bucket, l)
+			if err == nil && claimed {
+				err = errClaimed
+			}
+			return err
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error cleaning orphaned location s3://%s/%s: %w", bucket, prefix, err)
+	}
+	logger.Info("deleted files of orphaned rehydration",
+		slog.Int("fileCount", resp.Count),
+		slog.Int("deletedCount", resp.Deleted))
+	var errs []error
+	for _, e := range resp.Errors {
+		errs = append(errs, fmt.Errorf("error deleting file from orphaned location s3://%s/%s: %s", bucket, prefix, e.Message))
+	}
+	return resp.Deleted, errors.Join(errs...)
+}
+
+// abortStaleUploads aborts the multipart uploads to bucket that were started before cutoff and returns how many
+// were aborted
+func (c *Collector) abortStaleUploads(ctx context.Context, logger *slog.Logger, client *s3.Client, bucket string, cutoff time.Time) (int, error) {
+	var errs []error
+	aborted := 0
+	input := &s3.ListMultipartUploadsInput{Bucket: aws.String(bucket)}
+	for {
+		page, err := client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error listing multipart uploads to %s: %w", bucket, err))
+			break
+		}
+		for _, upload := range page.Uploads {
+			if !aws.ToTime(upload.Initiated).Before(cutoff) {
+				continue
+			}
+			if _, err := client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			}); err != nil {
+				errs = append(errs, fmt.Errorf("error aborting multipart upload %s to s3://%s/%s: %w",
+					aws.ToString(upload.UploadId), bucket, aws.ToString(upload.Key), err))
+				continue
+			}
+			logger.Info("aborted stale multipart upload",
+				slog.String("key", aws.ToString(upload.Key)),
+				slog.Time("initiated", aws.ToTime(upload.Initiated)))
+			aborted++
+		}
+		if !aws.ToBool(page.IsTruncated) {
+			break
+		}
+		input.KeyMarker = page.NextKeyMarker
+		input.UploadIdMarker = page.NextUploadIdMarker
+	}
+	return aborted, errors.Join(errs...)
+}
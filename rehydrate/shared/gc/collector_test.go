@@ -0,0 +1,151 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared/idempotency"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/s3cleaner"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector_Collect(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	bucket := "test-gc-bucket"
+	location := func(prefix string) string {
+		return fmt.Sprintf("s3://%s/%s", bucket, prefix)
+	}
+
+	completedPrefix := "100/1/"
+	orphanPrefix := "100/2/"
+	inProgressPrefix := "100/3/"
+	otherBucketPrefix := "101/1/"
+	notRehydrationPrefix := "exports/1/"
+	var putObjectInputs []*s3.PutObjectInput
+	for _, prefix := range []string{completedPrefix, orphanPrefix, inProgressPrefix, otherBucketPrefix, notRehydrationPrefix} {
+		putObjectInputs = append(putObjectInputs, test.GeneratePutObjectInputs(bucket, prefix, 3)...)
+	}
+	s3Fixture, _ := test.NewS3Fixture(t, s3.NewFromConfig(awsConfig), &s3.CreateBucketInput{Bucket: aws.String(bucket)}).
+		WithObjects(putObjectInputs...)
+	defer s3Fixture.Teardown()
+	upload, err := s3Fixture.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(completedPrefix + "large.bin"),
+	})
+	require.NoError(t, err)
+
+	store := &fakeRecordStore{records: map[string]*idempotency.Record{
+		idempotency.RecordID(100, 1): idempotency.NewRecord(idempotency.RecordID(100, 1), idempotency.Completed).
+			WithRehydrationLocation(location(completedPrefix)),
+		idempotency.RecordID(100, 3): idempotency.NewRecord(idempotency.RecordID(100, 3), idempotency.InProgress),
+		idempotency.RecordID(101, 1): idempotency.NewRecord(idempotency.RecordID(101, 1), idempotency.Completed).
+			WithRehydrationLocation("s3://another-region-bucket/" + otherBucketPrefix),
+	}}
+	cleaner, err := s3cleaner.NewCleaner(s3Fixture.Client, s3cleaner.MaxCleanBatch)
+	require.NoError(t, err)
+	collector := NewCollector(store, cleaner, time.Hour, logging.Default, nil)
+
+	// everything is too new to be collected
+	result, err := collector.Collect(ctx, s3Fixture.Client, bucket)
+	require.NoError(t, err)
+	assert.Empty(t, result.Orphans)
+	assert.Zero(t, result.FilesDeleted)
+	assert.Zero(t, result.UploadsAborted)
+
+	now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	defer func() { now = time.Now }()
+	result, err = collector.Collect(ctx, s3Fixture.Client, bucket)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{location(orphanPrefix), location(otherBucketPrefix)}, result.Orphans)
+	assert.Equal(t, 6, result.FilesDeleted)
+	assert.Equal(t, 1, result.UploadsAborted)
+
+	s3Fixture.AssertPrefixEmpty(bucket, orphanPrefix)
+	s3Fixture.AssertPrefixEmpty(bucket, otherBucketPrefix)
+	for _, prefix := range []string{completedPrefix, inProgressPrefix, notRehydrationPrefix} {
+		assert.True(t, s3Fixture.ObjectExists(bucket, prefix+"file0.txt"), "expected files under %s to be kept", prefix)
+	}
+	uploads, err := s3Fixture.Client.ListMultipartUploads(ctx, &s3.ListMultipartUploadsInput{Bucket: aws.String(bucket)})
+	require.NoError(t, err)
+	for _, remaining := range uploads.Uploads {
+		assert.NotEqual(t, aws.ToString(upload.UploadId), aws.ToString(remaining.UploadId))
+	}
+}
+
+func TestCollector_Collect_RecordAppears(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	bucket := "test-gc-appears-bucket"
+	orphanPrefix := "100/2/"
+	putObjectInputs := test.GeneratePutObjectInputs(bucket, orphanPrefix, 3)
+	s3Fixture, _ := test.NewS3Fixture(t, s3.NewFromConfig(awsConfig), &s3.CreateBucketInput{Bucket: aws.String(bucket)}).
+		WithObjects(putObjectInputs...)
+	defer s3Fixture.Teardown()
+
+	// a request starts after the location was found to be orphaned, but before its files are deleted
+	recordID := idempotency.RecordID(100, 2)
+	store := &fakeRecordStore{
+		appearing: map[string]*idempotency.Record{recordID: idempotency.NewRecord(recordID, idempotency.InProgress)},
+		looked:    map[string]bool{},
+	}
+	cleaner, err := s3cleaner.NewCleaner(s3Fixture.Client, s3cleaner.MaxCleanBatch)
+	require.NoError(t, err)
+	collector := NewCollector(store, cleaner, time.Hour, logging.Default, nil)
+
+	now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	defer func() { now = time.Now }()
+	result, err := collector.Collect(ctx, s3Fixture.Client, bucket)
+	require.NoError(t, err)
+	assert.Empty(t, result.Orphans)
+	assert.Zero(t, result.FilesDeleted)
+	for _, kept := range putObjectInputs {
+		assert.True(t, s3Fixture.ObjectExists(bucket, aws.ToString(kept.Key)))
+	}
+}
+
+func TestGracePeriodFromLookup(t *testing.T) {
+	lookup := func(values map[string]string) func(string) (string, bool) {
+		return func(key string) (string, bool) {
+			value, ok := values[key]
+			return value, ok
+		}
+	}
+	gracePeriod, err := GracePeriodFromLookup(lookup(map[string]string{}))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultGracePeriod, gracePeriod)
+
+	gracePeriod, err = GracePeriodFromLookup(lookup(map[string]string{GracePeriodHoursKey: "48"}))
+	require.NoError(t, err)
+	assert.Equal(t, 48*time.Hour, gracePeriod)
+
+	_, err = GracePeriodFromLookup(lookup(map[string]string{GracePeriodHoursKey: "0"}))
+	assert.ErrorContains(t, err, GracePeriodHoursKey)
+}
+
+// fakeRecordStore implements only the idempotency.Store method used by Collector
+type fakeRecordStore struct {
+	idempotency.Store
+	records map[string]*idempotency.Record
+	// appearing records are only found once their ID has been looked up before
+	appearing map[string]*idempotency.Record
+	looked    map[string]bool
+}
+
+func (s *fakeRecordStore) GetRecord(_ context.Context, recordID string) (*idempotency.Record, error) {
+	if record, ok := s.appearing[recordID]; ok {
+		if s.looked[recordID] {
+			return record, nil
+		}
+		s.looked[recordID] = true
+		return nil, nil
+	}
+	return s.records[recordID], nil
+}
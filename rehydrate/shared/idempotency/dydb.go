@@ -36,6 +36,12 @@ func (s *DyDBStore) SaveInProgress(ctx context.Context, datasetID, datasetVersio
 	return s.PutRecord(ctx, *record)
 }
 
+func (s *DyDBStore) SaveQueued(ctx context.Context, datasetID, datasetVersionID int) error {
+	recordID := RecordID(datasetID, datasetVersionID)
+	record := NewRecord(recordID, Queued)
+	return s.PutRecord(ctx, *record)
+}
+
 func (s *DyDBStore) GetRecord(ctx context.Context, recordID string) (*Record, error) {
 	key := itemKeyFromRecordID(recordID)
 	in := dynamodb.GetItemInput{
@@ -98,6 +104,14 @@ func (s *DyDBStore) UpdateRecord(ctx context.Context, record Record) error {
 	).Set(
 		expression.Name(ExpirationDateAttrName),
 		expression.Value(record.ExpirationDate))
+	if record.Usage != nil {
+		updateBuilder = updateBuilder.Set(expression.Name(UsageAttrName), expression.Value(record.Usage))
+	}
+	if len(record.MissingFiles) > 0 {
+		updateBuilder = updateBuilder.Set(expression.Name(MissingFilesAttrName), expression.Value(record.MissingFiles))
+	}
+	// left over if the rehydration had to wait for restores
+	updateBuilder = updateBuilder.Remove(expression.Name(ResumeAttrName))
 	updateRecordExpression, err := expression.NewBuilder().WithUpdate(updateBuilder).Build()
 	if err != nil {
 		return fmt.Errorf("error building UpdateRecord expression: %w", err)
@@ -134,6 +148,183 @@ func (s *DyDBStore) SetTaskARN(ctx context.Context, recordID string, taskARN str
 	return nil
 }
 
+// SetProgress sets the progress of the record only if the record exists and its status is IN_PROGRESS, so that a late
+// update cannot land on a record that has been finalized or deleted.
+func (s *DyDBStore) SetProgress(ctx context.Context, recordID string, progress Progress) error {
+	updateBuilder := expression.Set(expression.Name(ProgressAttrName), expression.Value(progress))
+	conditionBuilder := expression.And(
+		expression.AttributeExists(expression.Name(KeyAttrName)),
+		expression.Equal(expression.Name(StatusAttrName), expression.Value(InProgress)),
+	)
+	setProgressExpression, err := expression.NewBuilder().WithUpdate(updateBuilder).WithCondition(conditionBuilder).Build()
+	if err != nil {
+		return fmt.Errorf("error building SetProgress expression: %w", err)
+	}
+	in := &dynamodb.UpdateItemInput{
+		Key:                                 itemKeyFromRecordID(recordID),
+		TableName:                           aws.String(s.table),
+		ExpressionAttributeNames:            setProgressExpression.Names(),
+		ExpressionAttributeValues:           setProgressExpression.Values(),
+		UpdateExpression:                    setProgressExpression.Update(),
+		ConditionExpression:                 setProgressExpression.Condition(),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	if _, err := s.client.UpdateItem(ctx, in); err != nil {
+		var conditionFailedError *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailedError) {
+			if len(conditionFailedError.Item) == 0 {
+				return &RecordDoesNotExistsError{RecordID: recordID}
+			}
+			return &ConditionFailedError{fmt.Sprintf("unable to set progress of record %s: status is not %s", recordID, InProgress)}
+		}
+		return fmt.Errorf("error setting progress of record %s: %w", recordID, err)
+	}
+	return nil
+}
+
+// UpdateStatus sets the status of the record to status only if the record exists and its current status is expected.
+func (s *DyDBStore) UpdateStatus(ctx context.Context, recordID string, expected Status, status Status) error {
+	updateBuilder := expression.Set(expression.Name(StatusAttrName), expression.Value(status))
+	conditionBuilder := expression.And(
+		expression.AttributeExists(expression.Name(KeyAttrName)),
+		expression.Equal(expression.Name(StatusAttrName), expression.Value(expected)),
+	)
+	updateStatusExpression, err := expression.NewBuilder().WithUpdate(updateBuilder).WithCondition(conditionBuilder).Build()
+	if err != nil {
+		return fmt.Errorf("error building UpdateStatus expression: %w", err)
+	}
+	in := &dynamodb.UpdateItemInput{
+		Key:                                 itemKeyFromRecordID(recordID),
+		TableName:                           aws.String(s.table),
+		ExpressionAttributeNames:            updateStatusExpression.Names(),
+		ExpressionAttributeValues:           updateStatusExpression.Values(),
+		UpdateExpression:                    updateStatusExpression.Update(),
+		ConditionExpression:                 updateStatusExpression.Condition(),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	if _, err := s.client.UpdateItem(ctx, in); err != nil {
+		var conditionFailedError *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailedError) {
+			if len(conditionFailedError.Item) == 0 {
+				return &RecordDoesNotExistsError{RecordID: recordID}
+			}
+			actualStatus := conditionFailedError.Item[StatusAttrName].(*types.AttributeValueMemberS).Value
+			return &ConditionFailedError{fmt.Sprintf("unable to set record %s status to %s: expected current status %s, actual status: %s",
+				recordID,
+				status,
+				expected,
+				actualStatus)}
+		}
+		return fmt.Errorf("error setting status of record %s to %s: %w", recordID, status, err)
+	}
+	return nil
+}
+
+// CountByStatus queries StatusIndex, which is eventually consistent, so a very recent status change may not be counted yet.
+func (s *DyDBStore) CountByStatus(ctx context.Context, status Status) (int, error) {
+	queryIn, err := s.statusIndexQueryInput(status)
+	if err != nil {
+		return 0, fmt.Errorf("error building CountByStatus expression: %w", err)
+	}
+	queryIn.Select = types.SelectCount
+	var count int
+	var lastEvaluatedKey map[string]types.AttributeValue
+	for runQuery := true; runQuery; runQuery = len(lastEvaluatedKey) != 0 {
+		queryIn.ExclusiveStartKey = lastEvaluatedKey
+		queryOut, err := s.client.Query(ctx, queryIn)
+		if err != nil {
+			return 0, fmt.Errorf("error counting records with status %s: %w", status, err)
+		}
+		lastEvaluatedKey = queryOut.LastEvaluatedKey
+		count += int(queryOut.Count)
+	}
+	return count, nil
+}
+
+// QueryByStatus finds the IDs of records with status in StatusIndex and then reads each record consistently. Since the
+// index is eventually consistent, records whose status has since changed are left out.
+func (s *DyDBStore) QueryByStatus(ctx context.Context, status Status) ([]Record, error) {
+	queryIn, err := s.statusIndexQueryInput(status)
+	if err != nil {
+		return nil, fmt.Errorf("error building QueryByStatus expression: %w", err)
+	}
+	var records []Record
+	var errs []error
+	var lastEvaluatedKey map[string]types.AttributeValue
+	for runQuery := true; runQuery; runQuery = len(lastEvaluatedKey) != 0 {
+		queryIn.ExclusiveStartKey = lastEvaluatedKey
+		queryOut, err := s.client.Query(ctx, queryIn)
+		if err != nil {
+			return nil, fmt.Errorf("error querying for records with status %s: %w", status, err)
+		}
+		lastEvaluatedKey = queryOut.LastEvaluatedKey
+		for _, item := range queryOut.Items {
+			indexEntry, err := StatusIndexFromItem(item)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			record, err := s.GetRecord(ctx, indexEntry.ID)
+			if err != nil {
+				errs = append(errs, err)
+			} else if record != nil && record.Status == status {
+				records = append(records, *record)
+			}
+		}
+	}
+	return records, errors.Join(errs...)
+}
+
+func (s *DyDBStore) statusIndexQueryInput(status Status) (*dynamodb.QueryInput, error) {
+	keyConditionBuilder := expression.Key(StatusAttrName).Equal(expression.Value(status))
+	queryExpression, err := expression.NewBuilder().WithKeyCondition(keyConditionBuilder).Build()
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.QueryInput{
+		TableName:                 aws.String(s.table),
+		IndexName:                 aws.String(StatusIndexName),
+		ExpressionAttributeNames:  queryExpression.Names(),
+		ExpressionAttributeValues: queryExpression.Values(),
+		KeyConditionExpression:    queryExpression.KeyCondition(),
+	}, nil
+}
+
+// SaveRestoring also removes the task ARN, since the task exits once the record is saved.
+func (s *DyDBStore) SaveRestoring(ctx context.Context, recordID string, resume Resume) error {
+	updateBuilder := expression.Set(expression.Name(StatusAttrName), expression.Value(Restoring)).
+		Set(expression.Name(ResumeAttrName), expression.Value(resume)).
+		Remove(expression.Name(TaskARNAttrName))
+	conditionBuilder := expression.And(
+		expression.AttributeExists(expression.Name(KeyAttrName)),
+		expression.Equal(expression.Name(StatusAttrName), expression.Value(InProgress)),
+	)
+	saveRestoringExpression, err := expression.NewBuilder().WithUpdate(updateBuilder).WithCondition(conditionBuilder).Build()
+	if err != nil {
+		return fmt.Errorf("error building SaveRestoring expression: %w", err)
+	}
+	in := &dynamodb.UpdateItemInput{
+		Key:                                 itemKeyFromRecordID(recordID),
+		TableName:                           aws.String(s.table),
+		ExpressionAttributeNames:            saveRestoringExpression.Names(),
+		ExpressionAttributeValues:           saveRestoringExpression.Values(),
+		UpdateExpression:                    saveRestoringExpression.Update(),
+		ConditionExpression:                 saveRestoringExpression.Condition(),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	if _, err := s.client.UpdateItem(ctx, in); err != nil {
+		var conditionFailedError *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailedError) {
+			if len(conditionFailedError.Item) == 0 {
+				return &RecordDoesNotExistsError{RecordID: recordID}
+			}
+			return &ConditionFailedError{fmt.Sprintf("unable to set record %s status to %s: status is not %s", recordID, Restoring, InProgress)}
+		}
+		return fmt.Errorf("error setting status of record %s to %s: %w", recordID, Restoring, err)
+	}
+	return nil
+}
+
 func (s *DyDBStore) DeleteRecord(ctx context.Context, recordID string) error {
 	in := &dynamodb.DeleteItemInput{
 		Key:       itemKeyFromRecordID(recordID),
@@ -260,11 +451,15 @@ func (s *DyDBStore) QueryExpirationIndex(ctx context.Context, now time.Time, lim
 }
 
 func (s *DyDBStore) ExpireByIndex(ctx context.Context, index ExpirationIndex) (*Record, error) {
+	now := time.Now()
 	updateBuilder := expression.Set(expression.Name(StatusAttrName), expression.Value(Expired))
 	conditionBuilder := expression.And(
 		expression.AttributeExists(expression.Name(KeyAttrName)),
 		expression.Name(StatusAttrName).Equal(expression.Value(index.Status)),
 		expression.Name(ExpirationDateAttrName).Equal(expression.Value(index.ExpirationDate)),
+		expression.Or(
+			expression.AttributeNotExists(expression.Name(ReferencedUntilAttrName)),
+			expression.LessThanEqual(expression.Name(ReferencedUntilAttrName), expression.Value(now.Unix()))),
 	)
 
 	expireByIndexExpression, err := expression.NewBuilder().WithUpdate(updateBuilder).WithCondition(conditionBuilder).Build()
@@ -289,6 +484,9 @@ func (s *DyDBStore) ExpireByIndex(ctx context.Context, index ExpirationIndex) (*
 			if len(conditionFailedError.Item) == 0 {
 				return nil, &RecordDoesNotExistsError{RecordID: index.ID}
 			}
+			if existing, err := FromItem(conditionFailedError.Item); err == nil && existing.Referenced(now) {
+				return nil, &ReferencedError{RecordID: index.ID, ReferencedUntil: *existing.ReferencedUntil}
+			}
 			actualStatus := conditionFailedError.Item[StatusAttrName].(*types.AttributeValueMemberS).Value
 			actualExpirationDate := conditionFailedError.Item[ExpirationDateAttrName].(*types.AttributeValueMemberS).Value
 			return nil,
@@ -308,6 +506,50 @@ func (s *DyDBStore) ExpireByIndex(ctx context.Context, index ExpirationIndex) (*
 	return record, nil
 }
 
+func (s *DyDBStore) Reference(ctx context.Context, recordID string, until time.Time) error {
+	updateBuilder := expression.Set(expression.Name(ReferencedUntilAttrName), expression.Value(until.Unix()))
+	// only a COMPLETED rehydration has files to copy, and an existing reference may already run past until
+	conditionBuilder := expression.And(
+		expression.AttributeExists(expression.Name(KeyAttrName)),
+		expression.Equal(expression.Name(StatusAttrName), expression.Value(Completed)),
+		expression.Or(
+			expression.AttributeNotExists(expression.Name(ReferencedUntilAttrName)),
+			expression.LessThan(expression.Name(ReferencedUntilAttrName), expression.Value(until.Unix()))),
+	)
+	referenceExpression, err := expression.NewBuilder().WithUpdate(updateBuilder).WithCondition(conditionBuilder).Build()
+	if err != nil {
+		return fmt.Errorf("error building Reference expression: %w", err)
+	}
+	in := &dynamodb.UpdateItemInput{
+		Key:                                 itemKeyFromRecordID(recordID),
+		TableName:                           aws.String(s.table),
+		ExpressionAttributeNames:            referenceExpression.Names(),
+		ExpressionAttributeValues:           referenceExpression.Values(),
+		UpdateExpression:                    referenceExpression.Update(),
+		ConditionExpression:                 referenceExpression.Condition(),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	if _, err := s.client.UpdateItem(ctx, in); err != nil {
+		var conditionFailedError *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailedError) {
+			if len(conditionFailedError.Item) == 0 {
+				return &RecordDoesNotExistsError{RecordID: recordID}
+			}
+			existing, err := FromItem(conditionFailedError.Item)
+			if err != nil {
+				return fmt.Errorf("error unmarshalling record %s: %w", recordID, err)
+			}
+			if existing.Status == Completed {
+				// already referenced for at least as long
+				return nil
+			}
+			return &ConditionFailedError{message: fmt.Sprintf("unable to reference record %s: current status: %s", recordID, existing.Status)}
+		}
+		return fmt.Errorf("error referencing record %s: %w", recordID, err)
+	}
+	return nil
+}
+
 type RecordAlreadyExistsError struct {
 	Existing           *Record
 	UnmarshallingError error
@@ -336,6 +578,17 @@ func (e *ConditionFailedError) Error() string {
 	return e.message
 }
 
+// ReferencedError is returned when a record cannot be expired because another rehydration may still be copying files
+// from it
+type ReferencedError struct {
+	RecordID        string
+	ReferencedUntil time.Time
+}
+
+func (e *ReferencedError) Error() string {
+	return fmt.Sprintf("record %s is referenced until %s", e.RecordID, e.ReferencedUntil.Format(time.RFC3339))
+}
+
 func itemKeyFromRecordID(recordID string) map[string]types.AttributeValue {
 	return map[string]types.AttributeValue{KeyAttrName: dydbutils.StringAttributeValue(recordID)}
 }
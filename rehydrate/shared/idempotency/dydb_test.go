@@ -4,8 +4,10 @@ import (
 	"context"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/google/uuid"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
 	"github.com/pennsieve/rehydration-service/shared/idempotency"
 	"github.com/pennsieve/rehydration-service/shared/logging"
+	"github.com/pennsieve/rehydration-service/shared/models"
 	"github.com/pennsieve/rehydration-service/shared/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -75,6 +77,10 @@ func TestStore_UpdateRecord(t *testing.T) {
 	record.RehydrationLocation = updatedLocation
 	record.Status = updatedStatus
 	record.ExpirationDate = &updatedExpirationDate
+	updatedUsage := &accounting.Usage{RunID: "test-run", BytesCopied: 1024, ObjectsCopied: 1, CopyObjectRequests: 1}
+	record.Usage = updatedUsage
+	updatedMissingFiles := []string{"files/missing.txt"}
+	record.MissingFiles = updatedMissingFiles
 
 	err := store.UpdateRecord(ctx, *record)
 	require.NoError(t, err)
@@ -87,6 +93,9 @@ func TestStore_UpdateRecord(t *testing.T) {
 	assert.Equal(t, updatedLocation, scanned.RehydrationLocation)
 	assert.Equal(t, updatedStatus, scanned.Status)
 	assert.True(t, updatedExpirationDate.Equal(*scanned.ExpirationDate))
+	assert.Equal(t, updatedUsage, scanned.Usage)
+	assert.Equal(t, updatedMissingFiles, scanned.MissingFiles)
+	assert.True(t, scanned.Partial())
 }
 
 func TestStore_SetTaskARN(t *testing.T) {
@@ -114,6 +123,45 @@ func TestStore_SetTaskARN(t *testing.T) {
 	require.Equal(t, taskARN, scanned.FargateTaskARN)
 }
 
+func TestStore_SetProgress(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	dyDBClient := dynamodb.NewFromConfig(awsConfig)
+	store := idempotency.NewStore(dyDBClient, logging.Default, testIdempotencyTableName)
+
+	inProgress := idempotency.NewRecord("1/2/", idempotency.InProgress)
+	completed := idempotency.NewRecord("3/4/", idempotency.Completed).WithRehydrationLocation("bucket/3/4/")
+
+	dyDB := test.NewDynamoDBFixture(t, awsConfig, createIdempotencyTableInput(testIdempotencyTableName)).WithItems(test.ItemersToPutItemInputs(t, testIdempotencyTableName, inProgress, completed)...)
+	defer dyDB.Teardown()
+
+	started := time.Now().UTC().Truncate(time.Second)
+	progress := idempotency.Progress{
+		FilesTotal:  10,
+		FilesDone:   4,
+		FilesFailed: 1,
+		BytesTotal:  1000,
+		BytesDone:   250,
+		StartedAt:   started,
+		UpdatedAt:   started.Add(time.Minute),
+	}
+	require.NoError(t, store.SetProgress(ctx, inProgress.ID, *progress.EstimateCompletion()))
+
+	scanned, err := store.GetRecord(ctx, inProgress.ID)
+	require.NoError(t, err)
+	require.NotNil(t, scanned.Progress)
+	assert.Equal(t, progress.FilesDone, scanned.Progress.FilesDone)
+	assert.Equal(t, progress.BytesDone, scanned.Progress.BytesDone)
+	require.NotNil(t, scanned.Progress.EstimatedCompletion)
+	assert.True(t, started.Add(4*time.Minute).Equal(*scanned.Progress.EstimatedCompletion))
+	assert.Equal(t, idempotency.InProgress, scanned.Status)
+
+	var conditionFailedError *idempotency.ConditionFailedError
+	assert.ErrorAs(t, store.SetProgress(ctx, completed.ID, progress), &conditionFailedError)
+	var doesNotExistError *idempotency.RecordDoesNotExistsError
+	assert.ErrorAs(t, store.SetProgress(ctx, "5/6/", progress), &doesNotExistError)
+}
+
 func TestStore_DeleteRecord(t *testing.T) {
 	ctx := context.Background()
 	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
@@ -171,6 +219,135 @@ func TestStore_ExpireRecord(t *testing.T) {
 	}
 }
 
+func TestStore_UpdateStatus(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	dyDBClient := dynamodb.NewFromConfig(awsConfig)
+	store := idempotency.NewStore(dyDBClient, logging.Default, testIdempotencyTableName)
+
+	queued := idempotency.NewRecord("1/2/", idempotency.Queued)
+
+	dyDB := test.NewDynamoDBFixture(t, awsConfig, createIdempotencyTableInput(testIdempotencyTableName)).WithItems(test.ItemersToPutItemInputs(t, testIdempotencyTableName, queued)...)
+	defer dyDB.Teardown()
+
+	err := store.UpdateStatus(ctx, queued.ID, idempotency.Queued, idempotency.InProgress)
+	require.NoError(t, err)
+
+	updated, err := store.GetRecord(ctx, queued.ID)
+	require.NoError(t, err)
+	assert.Equal(t, idempotency.InProgress, updated.Status)
+
+	// status is no longer QUEUED, so a second update should fail
+	err = store.UpdateStatus(ctx, queued.ID, idempotency.Queued, idempotency.InProgress)
+	var conditionFailedError *idempotency.ConditionFailedError
+	if assert.ErrorAs(t, err, &conditionFailedError) {
+		assert.Contains(t, conditionFailedError.Error(), string(idempotency.InProgress))
+	}
+
+	nonExistentRecordID := "999/9/"
+	err = store.UpdateStatus(ctx, nonExistentRecordID, idempotency.Queued, idempotency.InProgress)
+	var recordNotFound *idempotency.RecordDoesNotExistsError
+	if assert.ErrorAs(t, err, &recordNotFound) {
+		assert.Equal(t, nonExistentRecordID, recordNotFound.RecordID)
+	}
+}
+
+func TestStore_CountByStatus(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	dyDBClient := dynamodb.NewFromConfig(awsConfig)
+	store := idempotency.NewStore(dyDBClient, logging.Default, testIdempotencyTableName)
+
+	dyDB := test.NewDynamoDBFixture(t, awsConfig, createIdempotencyTableInput(testIdempotencyTableName)).WithItems(test.ItemersToPutItemInputs(t, testIdempotencyTableName,
+		idempotency.NewRecord("1/2/", idempotency.InProgress),
+		idempotency.NewRecord("3/1/", idempotency.InProgress),
+		idempotency.NewRecord("4/1/", idempotency.Queued),
+		idempotency.NewRecord("5/7/", idempotency.Completed),
+	)...)
+	defer dyDB.Teardown()
+
+	for status, expected := range map[idempotency.Status]int{
+		idempotency.InProgress: 2,
+		idempotency.Queued:     1,
+		idempotency.Completed:  1,
+		idempotency.Expired:    0,
+	} {
+		count, err := store.CountByStatus(ctx, status)
+		require.NoError(t, err)
+		assert.Equal(t, expected, count, status)
+	}
+}
+
+func TestStore_QueryByStatus(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	dyDBClient := dynamodb.NewFromConfig(awsConfig)
+	store := idempotency.NewStore(dyDBClient, logging.Default, testIdempotencyTableName)
+
+	restoring := idempotency.NewRecord("4/1/", idempotency.Restoring)
+	restoring.Resume = &idempotency.Resume{
+		Dataset:            models.Dataset{ID: 4, VersionID: 1},
+		User:               models.User{Name: "First Last", Email: "last@example.com"},
+		RestoreRequestedAt: time.Now().UTC().Add(-time.Hour).Truncate(time.Second),
+		ResumeAt:           time.Now().UTC().Truncate(time.Second),
+	}
+	dyDB := test.NewDynamoDBFixture(t, awsConfig, createIdempotencyTableInput(testIdempotencyTableName)).WithItems(test.ItemersToPutItemInputs(t, testIdempotencyTableName,
+		idempotency.NewRecord("1/2/", idempotency.InProgress),
+		restoring,
+		idempotency.NewRecord("5/7/", idempotency.Completed),
+	)...)
+	defer dyDB.Teardown()
+
+	records, err := store.QueryByStatus(ctx, idempotency.Restoring)
+	require.NoError(t, err)
+	assert.Equal(t, []idempotency.Record{*restoring}, records)
+
+	records, err = store.QueryByStatus(ctx, idempotency.Expired)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestStore_SaveRestoring(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	dyDBClient := dynamodb.NewFromConfig(awsConfig)
+	store := idempotency.NewStore(dyDBClient, logging.Default, testIdempotencyTableName)
+
+	inProgress := idempotency.NewRecord("1/2/", idempotency.InProgress).WithFargateTaskARN("arn:aws:ecs:test:test:test")
+	completed := idempotency.NewRecord("5/7/", idempotency.Completed)
+	dyDB := test.NewDynamoDBFixture(t, awsConfig, createIdempotencyTableInput(testIdempotencyTableName)).WithItems(test.ItemersToPutItemInputs(t, testIdempotencyTableName,
+		inProgress, completed)...)
+	defer dyDB.Teardown()
+
+	resume := idempotency.Resume{
+		Dataset:            models.Dataset{ID: 1, VersionID: 2},
+		User:               models.User{Name: "First Last", Email: "last@example.com"},
+		RequestID:          uuid.NewString(),
+		RestoreRequestedAt: time.Now().UTC().Truncate(time.Second),
+		ResumeAt:           time.Now().UTC().Add(5 * time.Hour).Truncate(time.Second),
+	}
+	require.NoError(t, store.SaveRestoring(ctx, inProgress.ID, resume))
+
+	saved, err := store.GetRecord(ctx, inProgress.ID)
+	require.NoError(t, err)
+	assert.Equal(t, idempotency.Restoring, saved.Status)
+	assert.Equal(t, &resume, saved.Resume)
+	// the task that was running has exited
+	assert.Empty(t, saved.FargateTaskARN)
+
+	// completing the rehydration after it is resumed removes the resume
+	require.NoError(t, store.UpdateStatus(ctx, inProgress.ID, idempotency.Restoring, idempotency.InProgress))
+	require.NoError(t, store.UpdateRecord(ctx, *idempotency.NewRecord(inProgress.ID, idempotency.Completed).WithRehydrationLocation("bucket/1/2/")))
+	saved, err = store.GetRecord(ctx, inProgress.ID)
+	require.NoError(t, err)
+	assert.Nil(t, saved.Resume)
+
+	var conditionFailedError *idempotency.ConditionFailedError
+	assert.ErrorAs(t, store.SaveRestoring(ctx, completed.ID, resume), &conditionFailedError)
+	var recordNotFound *idempotency.RecordDoesNotExistsError
+	assert.ErrorAs(t, store.SaveRestoring(ctx, "999/9/", resume), &recordNotFound)
+}
+
 func TestDyDBStore_SetExpirationDate_ConditionErrors(t *testing.T) {
 	ctx := context.Background()
 	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
@@ -405,3 +582,65 @@ func TestDyDBStore_ExpireByIndex_ConditionCheckFailure(t *testing.T) {
 func createIdempotencyTableInput(tableName string) *dynamodb.CreateTableInput {
 	return test.IdempotencyCreateTableInput(tableName)
 }
+
+func TestDyDBStore_ExpireByIndex_Referenced(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	dyDBClient := dynamodb.NewFromConfig(awsConfig)
+	store := idempotency.NewStore(dyDBClient, logging.Default, testIdempotencyTableName)
+	now := time.Now()
+
+	toExpireExpDate := now.Add(-time.Hour * 24)
+	referencedUntil := now.Add(time.Hour)
+	record := idempotency.NewRecord("12/1/", idempotency.Completed).
+		WithRehydrationLocation("s3://bucket/12/1/").
+		WithExpirationDate(&toExpireExpDate).
+		WithReferencedUntil(&referencedUntil)
+
+	dyBFixture := test.NewDynamoDBFixture(t, awsConfig, test.IdempotencyCreateTableInput(testIdempotencyTableName)).
+		WithItems(test.ItemersToPutItemInputs(t, testIdempotencyTableName, record)...)
+	defer dyBFixture.Teardown()
+
+	_, err := store.ExpireByIndex(ctx, record.ExpirationIndex)
+	var referencedError *idempotency.ReferencedError
+	if assert.ErrorAs(t, err, &referencedError) {
+		assert.Equal(t, record.ID, referencedError.RecordID)
+		assert.True(t, referencedUntil.Truncate(time.Second).Equal(referencedError.ReferencedUntil))
+	}
+
+	actual, err := store.GetRecord(ctx, record.ID)
+	require.NoError(t, err)
+	assert.Equal(t, idempotency.Completed, actual.Status)
+}
+
+func TestDyDBStore_Reference(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	dyDBClient := dynamodb.NewFromConfig(awsConfig)
+	store := idempotency.NewStore(dyDBClient, logging.Default, testIdempotencyTableName)
+	now := time.Now()
+
+	completed := idempotency.NewRecord("12/1/", idempotency.Completed).WithRehydrationLocation("s3://bucket/12/1/")
+	inProgress := idempotency.NewRecord("12/2/", idempotency.InProgress)
+
+	dyBFixture := test.NewDynamoDBFixture(t, awsConfig, test.IdempotencyCreateTableInput(testIdempotencyTableName)).
+		WithItems(test.ItemersToPutItemInputs(t, testIdempotencyTableName, completed, inProgress)...)
+	defer dyBFixture.Teardown()
+
+	later := now.Add(2 * time.Hour)
+	require.NoError(t, store.Reference(ctx, completed.ID, later))
+	// an earlier reference does not shorten the existing one, even if it is in a time zone ahead of the existing one's
+	require.NoError(t, store.Reference(ctx, completed.ID, now.Add(time.Hour)))
+	require.NoError(t, store.Reference(ctx, completed.ID, now.Add(time.Hour).In(time.FixedZone("UTC+10", 10*60*60))))
+	actual, err := store.GetRecord(ctx, completed.ID)
+	require.NoError(t, err)
+	require.NotNil(t, actual.ReferencedUntil)
+	assert.True(t, later.Truncate(time.Second).Equal(*actual.ReferencedUntil))
+	assert.True(t, actual.Referenced(now))
+
+	var conditionFailedError *idempotency.ConditionFailedError
+	assert.ErrorAs(t, store.Reference(ctx, inProgress.ID, later), &conditionFailedError)
+
+	var doesNotExistError *idempotency.RecordDoesNotExistsError
+	assert.ErrorAs(t, store.Reference(ctx, "12/3/", later), &doesNotExistError)
+}
@@ -0,0 +1,75 @@
+package idempotency
+
+import "time"
+
+const ProgressAttrName = "progress"
+
+// Phase is what the rehydration task is doing
+type Phase string
+
+const (
+	// RestoringPhase is waiting for archived source objects to be restored before they can be copied
+	RestoringPhase Phase = "RESTORING"
+	CopyingPhase   Phase = "COPYING"
+)
+
+// RestoreProgress is how far along the restore of archived source objects is
+type RestoreProgress struct {
+	// FilesTotal is the number of source objects that had to be restored
+	FilesTotal    int       `dynamodbav:"filesTotal" json:"filesTotal"`
+	FilesRestored int       `dynamodbav:"filesRestored" json:"filesRestored"`
+	Tier          string    `dynamodbav:"tier" json:"tier"`
+	RequestedAt   time.Time `dynamodbav:"requestedAt" json:"requestedAt"`
+	// ExpectedReadyAt is when S3 expects the slowest of the restores to finish
+	ExpectedReadyAt time.Time `dynamodbav:"expectedReadyAt" json:"expectedReadyAt"`
+	// FinishedAt is nil until the task stops waiting for restores
+	FinishedAt *time.Time `dynamodbav:"finishedAt,omitempty" json:"finishedAt,omitempty"`
+}
+
+// Progress is how far along the rehydration task is. The task saves it on the idempotency record while the record is
+// IN_PROGRESS.
+type Progress struct {
+	// Phase is empty if the task has not started copying yet and nothing needed restoring
+	Phase      Phase `dynamodbav:"phase,omitempty" json:"phase,omitempty"`
+	FilesTotal int   `dynamodbav:"filesTotal" json:"filesTotal"`
+	// FilesDone includes FilesFailed
+	FilesDone   int       `dynamodbav:"filesDone" json:"filesDone"`
+	FilesFailed int       `dynamodbav:"filesFailed" json:"filesFailed"`
+	BytesTotal  int64     `dynamodbav:"bytesTotal" json:"bytesTotal"`
+	BytesDone   int64     `dynamodbav:"bytesDone" json:"bytesDone"`
+	StartedAt   time.Time `dynamodbav:"startedAt" json:"startedAt"`
+	UpdatedAt   time.Time `dynamodbav:"updatedAt" json:"updatedAt"`
+	// EstimatedCompletion is nil until enough has been copied to estimate from
+	EstimatedCompletion *time.Time `dynamodbav:"estimatedCompletion,omitempty" json:"estimatedCompletion,omitempty"`
+	// Restore is nil unless some source objects had to be restored from an archive before they could be copied
+	Restore *RestoreProgress `dynamodbav:"restore,omitempty" json:"restore,omitempty"`
+}
+
+// PercentComplete is by bytes, or by files if the dataset is empty or has only empty files
+func (p *Progress) PercentComplete() float64 {
+	if p.BytesTotal > 0 {
+		return 100 * float64(p.BytesDone) / float64(p.BytesTotal)
+	}
+	if p.FilesTotal > 0 {
+		return 100 * float64(p.FilesDone) / float64(p.FilesTotal)
+	}
+	return 100
+}
+
+// EstimateCompletion sets EstimatedCompletion assuming the remaining bytes are copied at the same rate as BytesDone
+// were between StartedAt, or the end of any restore, and UpdatedAt
+func (p *Progress) EstimateCompletion() *Progress {
+	copyStarted := p.StartedAt
+	if p.Restore != nil && p.Restore.FinishedAt != nil {
+		copyStarted = *p.Restore.FinishedAt
+	}
+	elapsed := p.UpdatedAt.Sub(copyStarted)
+	if p.BytesDone <= 0 || elapsed <= 0 {
+		p.EstimatedCompletion = nil
+		return p
+	}
+	remaining := time.Duration(float64(elapsed) * float64(p.BytesTotal-p.BytesDone) / float64(p.BytesDone))
+	estimate := p.UpdatedAt.Add(remaining)
+	p.EstimatedCompletion = &estimate
+	return p
+}
@@ -0,0 +1,42 @@
+package idempotency
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestProgress_PercentComplete(t *testing.T) {
+	assert.Equal(t, float64(25), (&Progress{FilesTotal: 4, FilesDone: 3, BytesTotal: 400, BytesDone: 100}).PercentComplete())
+	assert.Equal(t, float64(75), (&Progress{FilesTotal: 4, FilesDone: 3}).PercentComplete())
+	assert.Equal(t, float64(100), (&Progress{}).PercentComplete())
+}
+
+func TestProgress_EstimateCompletion(t *testing.T) {
+	started := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	progress := &Progress{BytesTotal: 2000, StartedAt: started, UpdatedAt: started.Add(10 * time.Minute)}
+	assert.Nil(t, progress.EstimateCompletion().EstimatedCompletion, "nothing copied yet")
+
+	progress.BytesDone = 500
+	require.NotNil(t, progress.EstimateCompletion().EstimatedCompletion)
+	assert.Equal(t, started.Add(40*time.Minute), *progress.EstimatedCompletion)
+
+	progress.BytesDone = 2000
+	assert.Equal(t, progress.UpdatedAt, *progress.EstimateCompletion().EstimatedCompletion)
+}
+
+func TestProgress_EstimateCompletion_AfterRestore(t *testing.T) {
+	started := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	restored := started.Add(5 * time.Hour)
+	progress := &Progress{
+		BytesTotal: 2000,
+		BytesDone:  500,
+		StartedAt:  started,
+		UpdatedAt:  restored.Add(10 * time.Minute),
+		Restore:    &RestoreProgress{FinishedAt: &restored},
+	}
+	// the copy rate is measured from the end of the restore, not the start of the task
+	require.NotNil(t, progress.EstimateCompletion().EstimatedCompletion)
+	assert.Equal(t, restored.Add(40*time.Minute), *progress.EstimatedCompletion)
+}
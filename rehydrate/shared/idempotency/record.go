@@ -3,6 +3,7 @@ package idempotency
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
 	"github.com/pennsieve/rehydration-service/shared/dydbutils"
 	"github.com/pennsieve/rehydration-service/shared/models"
 	"strings"
@@ -12,15 +13,23 @@ import (
 type Status string
 
 const (
+	Queued     Status = "QUEUED"
 	InProgress Status = "IN_PROGRESS"
-	Completed  Status = "COMPLETED"
-	Expired    Status = "EXPIRED"
+	// Restoring is waiting, without a task, for archived source objects to be restored. The dispatcher starts the task
+	// again at Record.Resume.ResumeAt.
+	Restoring Status = "RESTORING"
+	Completed Status = "COMPLETED"
+	Expired   Status = "EXPIRED"
 )
 
 func StatusFromString(s string) (Status, error) {
 	switch strings.ToUpper(s) {
+	case string(Queued):
+		return Queued, nil
 	case string(InProgress):
 		return InProgress, nil
+	case string(Restoring):
+		return Restoring, nil
 	case string(Completed):
 		return Completed, nil
 	case string(Expired):
@@ -37,9 +46,24 @@ const RehydrationLocationAttrName = "rehydrationLocation"
 const StatusAttrName = "status"
 const TaskARNAttrName = "fargateTaskARN"
 const ExpirationDateAttrName = "expirationDate"
+const UsageAttrName = accounting.UsageAttrName
+const MissingFilesAttrName = "missingFiles"
+const RegionAttrName = "region"
+const ExternalDestinationAttrName = "externalDestination"
+const ReferencedUntilAttrName = "referencedUntil"
+const ResumeAttrName = "resume"
 
 const ExpirationIndexName = "ExpirationIndex"
 
+// StatusIndexName is a keys only index on status, so that records with a given status can be found without scanning
+// the table
+const StatusIndexName = "StatusIndex"
+
+type StatusIndex struct {
+	ID     string `dynamodbav:"id"`
+	Status Status `dynamodbav:"status"`
+}
+
 type ExpirationIndex struct {
 	ID                  string `dynamodbav:"id"`
 	RehydrationLocation string `dynamodbav:"rehydrationLocation,omitempty"`
@@ -53,6 +77,43 @@ type ExpirationIndex struct {
 type Record struct {
 	ExpirationIndex
 	FargateTaskARN string `dynamodbav:"fargateTaskARN,omitempty"`
+	// Usage is set once the rehydration task completes
+	Usage *accounting.Usage `dynamodbav:"usage,omitempty"`
+	// Progress is updated periodically by the rehydration task
+	Progress *Progress `dynamodbav:"progress,omitempty"`
+	// MissingFiles lists the paths of any files a COMPLETED rehydration could not copy. Empty unless the task's failure
+	// policy allowed a partial rehydration.
+	MissingFiles []string `dynamodbav:"missingFiles,omitempty"`
+	// Region is the region of the rehydration bucket if the request chose one other than the default. Empty for the
+	// default region.
+	Region string `dynamodbav:"region,omitempty"`
+	// ExternalDestination is the location of the requester-owned bucket and prefix for an external rehydration. Empty
+	// for rehydrations into our own buckets.
+	ExternalDestination string `dynamodbav:"externalDestination,omitempty"`
+	// ReferencedUntil is set while a rehydration of another version of the dataset may be copying files from this one.
+	// The record is not expired before then. It is stored as epoch seconds so that conditions compare it as a number.
+	ReferencedUntil *time.Time `dynamodbav:"referencedUntil,omitempty,unixtime"`
+	// Resume is set while the rehydration is RESTORING
+	Resume *Resume `dynamodbav:"resume,omitempty"`
+}
+
+// Resume is what the dispatcher needs to start the task of a RESTORING rehydration again
+type Resume struct {
+	Dataset models.Dataset `dynamodbav:"dataset"`
+	User    models.User    `dynamodbav:"user"`
+	// Destination is nil if the dataset is being rehydrated into the default region
+	Destination *models.Destination `dynamodbav:"destination,omitempty"`
+	RequestID   string              `dynamodbav:"requestId,omitempty"`
+	// RestoreRequestedAt is when the first restores were requested. The task stops waiting for restores once its
+	// restore policy's MaxWait has passed since then.
+	RestoreRequestedAt time.Time `dynamodbav:"restoreRequestedAt"`
+	// ResumeAt is when the task should be started again to check on the restores
+	ResumeAt time.Time `dynamodbav:"resumeAt"`
+}
+
+// Resumable returns true if the task of a RESTORING rehydration should be started again at now
+func (r *Record) Resumable(now time.Time) bool {
+	return r.Status == Restoring && r.Resume != nil && !r.Resume.ResumeAt.After(now)
 }
 
 func NewRecord(id string, status Status) *Record {
@@ -73,6 +134,47 @@ func (r *Record) WithFargateTaskARN(taskARN string) *Record {
 	return r
 }
 
+func (r *Record) WithUsage(usage *accounting.Usage) *Record {
+	r.Usage = usage
+	return r
+}
+
+func (r *Record) WithMissingFiles(missingFiles []string) *Record {
+	r.MissingFiles = missingFiles
+	return r
+}
+
+func (r *Record) WithRegion(region string) *Record {
+	r.Region = region
+	return r
+}
+
+func (r *Record) WithExternalDestination(location string) *Record {
+	r.ExternalDestination = location
+	return r
+}
+
+// External returns true if this is a rehydration into a requester-owned bucket. The files of an external rehydration
+// belong to the requester, so they are not deleted when it expires.
+func (r *Record) External() bool {
+	return len(r.ExternalDestination) > 0
+}
+
+// Partial returns true if this is a rehydration that completed without some files
+func (r *Record) Partial() bool {
+	return r.Status == Completed && len(r.MissingFiles) > 0
+}
+
+func (r *Record) WithReferencedUntil(referencedUntil *time.Time) *Record {
+	r.ReferencedUntil = referencedUntil
+	return r
+}
+
+// Referenced returns true if another rehydration may still be copying files from this one at now
+func (r *Record) Referenced(now time.Time) bool {
+	return r.ReferencedUntil != nil && r.ReferencedUntil.After(now)
+}
+
 func (r *Record) WithExpirationDate(expirationDate *time.Time) *Record {
 	r.ExpirationDate = expirationDate
 	return r
@@ -90,6 +192,18 @@ var FromItem = dydbutils.FromItem[Record]
 
 var ExpirationIndexFromItem = dydbutils.FromItem[ExpirationIndex]
 
+var StatusIndexFromItem = dydbutils.FromItem[StatusIndex]
+
 func RecordID(datasetID, datasetVersionID int) string {
 	return models.DatasetVersion(datasetID, datasetVersionID)
 }
+
+// DestinationRecordID is RecordID, except that an external destination gets a record of its own, so that it does not
+// share a rehydration with other requesters. destination may be nil.
+func DestinationRecordID(datasetID, datasetVersionID int, destination *models.Destination) string {
+	recordID := RecordID(datasetID, datasetVersionID)
+	if destination.External() {
+		return recordID + destination.Location()
+	}
+	return recordID
+}
@@ -3,8 +3,10 @@ package idempotency
 import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/google/uuid"
+	"github.com/pennsieve/rehydration-service/shared/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -49,6 +51,50 @@ func TestRecord_ItemRoundTrip_ExpirationDate(t *testing.T) {
 	assert.True(t, record.ExpirationDate.Equal(*unmarshalled.ExpirationDate))
 }
 
+func TestRecord_ItemRoundTrip_MissingFiles(t *testing.T) {
+	record := NewRecord("1/2/", Completed).
+		WithRehydrationLocation("bucket/1/2/").
+		WithMissingFiles([]string{"files/a.txt", "files/b.txt"})
+
+	item, err := record.Item()
+	require.NoError(t, err)
+	assert.Contains(t, item, MissingFilesAttrName)
+
+	unmarshalled, err := FromItem(item)
+	require.NoError(t, err)
+	assert.Equal(t, record, unmarshalled)
+	assert.True(t, unmarshalled.Partial())
+
+	complete := NewRecord("1/2/", Completed).WithRehydrationLocation("bucket/1/2/")
+	completeItem, err := complete.Item()
+	require.NoError(t, err)
+	assert.NotContains(t, completeItem, MissingFilesAttrName)
+	assert.False(t, complete.Partial())
+}
+
+func TestRecord_ItemRoundTrip_Region(t *testing.T) {
+	record := NewRecord("1/2/", InProgress).WithRegion("eu-west-1")
+
+	item, err := record.Item()
+	require.NoError(t, err)
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "eu-west-1"}, item[RegionAttrName])
+
+	unmarshalled, err := FromItem(item)
+	require.NoError(t, err)
+	assert.Equal(t, record, unmarshalled)
+
+	defaultRegionItem, err := NewRecord("1/2/", InProgress).Item()
+	require.NoError(t, err)
+	assert.NotContains(t, defaultRegionItem, RegionAttrName)
+}
+
+func TestDestinationRecordID(t *testing.T) {
+	assert.Equal(t, "5065/2/", DestinationRecordID(5065, 2, nil))
+	assert.Equal(t, "5065/2/", DestinationRecordID(5065, 2, &models.Destination{Region: "eu-west-1", Bucket: "rehydration-eu"}))
+	assert.Equal(t, "5065/2/s3://their-bucket/data/",
+		DestinationRecordID(5065, 2, &models.Destination{Bucket: "their-bucket", Prefix: "data/", RoleARN: "arn:aws:iam::123456789012:role/rehydration"}))
+}
+
 func TestStatusFromString(t *testing.T) {
 	_, err := StatusFromString("NotAStatus")
 	require.Error(t, err)
@@ -58,4 +104,57 @@ func TestStatusFromString(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, Completed, complete)
 
+	queued, err := StatusFromString("Queued")
+	require.NoError(t, err)
+	require.Equal(t, Queued, queued)
+
+	restoring, err := StatusFromString("restoring")
+	require.NoError(t, err)
+	require.Equal(t, Restoring, restoring)
+
+}
+
+func TestRecord_Referenced(t *testing.T) {
+	now := time.Now()
+	record := NewRecord("1/2/", Completed)
+	assert.False(t, record.Referenced(now))
+
+	later := now.Add(time.Hour)
+	record.WithReferencedUntil(&later)
+	assert.True(t, record.Referenced(now))
+	assert.False(t, record.Referenced(later.Add(time.Second)))
+
+	item, err := record.Item()
+	require.NoError(t, err)
+	// stored as a number so that it is compared the same way whatever the time zone of the writer
+	if assert.IsType(t, &types.AttributeValueMemberN{}, item[ReferencedUntilAttrName]) {
+		assert.Equal(t, strconv.FormatInt(later.Unix(), 10), item[ReferencedUntilAttrName].(*types.AttributeValueMemberN).Value)
+	}
+}
+
+func TestRecord_Resumable(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	record := NewRecord("1/2/", Restoring)
+	assert.False(t, record.Resumable(now))
+
+	record.Resume = &Resume{
+		Dataset:            models.Dataset{ID: 1, VersionID: 2},
+		User:               models.User{Name: "First Last", Email: "last@example.com"},
+		RequestID:          uuid.NewString(),
+		RestoreRequestedAt: now.Add(-time.Hour),
+		ResumeAt:           now,
+	}
+	assert.True(t, record.Resumable(now))
+	assert.False(t, record.Resumable(now.Add(-time.Second)))
+
+	item, err := record.Item()
+	require.NoError(t, err)
+	assert.Contains(t, item, ResumeAttrName)
+	unmarshalled, err := FromItem(item)
+	require.NoError(t, err)
+	assert.Equal(t, record, unmarshalled)
+
+	// the task has been started again
+	record.Status = InProgress
+	assert.False(t, record.Resumable(now))
 }
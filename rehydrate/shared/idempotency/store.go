@@ -7,13 +7,24 @@ import (
 
 type Store interface {
 	SaveInProgress(ctx context.Context, datasetID, datasetVersionID int) error
+	SaveQueued(ctx context.Context, datasetID, datasetVersionID int) error
 	GetRecord(ctx context.Context, recordID string) (*Record, error)
 	PutRecord(ctx context.Context, record Record) error
 	UpdateRecord(ctx context.Context, record Record) error
 	SetTaskARN(ctx context.Context, recordID string, taskARN string) error
+	SetProgress(ctx context.Context, recordID string, progress Progress) error
+	UpdateStatus(ctx context.Context, recordID string, expected Status, status Status) error
+	CountByStatus(ctx context.Context, status Status) (int, error)
+	QueryByStatus(ctx context.Context, status Status) ([]Record, error)
+	// SaveRestoring sets the IN_PROGRESS record with recordID to RESTORING until its task is started again with resume
+	SaveRestoring(ctx context.Context, recordID string, resume Resume) error
 	DeleteRecord(ctx context.Context, recordID string) error
 	ExpireRecord(ctx context.Context, recordID string) error
 	SetExpirationDate(ctx context.Context, recordID string, expirationDate time.Time) error
 	QueryExpirationIndex(ctx context.Context, now time.Time, limit int32) ([]ExpirationIndex, error)
+	// ExpireByIndex returns a *ReferencedError if the record cannot be expired yet because it is referenced
 	ExpireByIndex(ctx context.Context, index ExpirationIndex) (*Record, error)
+	// Reference keeps the COMPLETED rehydration with recordID from expiring before until, so that another rehydration
+	// can copy files from it
+	Reference(ctx context.Context, recordID string, until time.Time) error
 }
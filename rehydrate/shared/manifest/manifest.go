@@ -0,0 +1,137 @@
+// Package manifest describes the files in a rehydration location, including any that could not be copied.
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"time"
+)
+
+// FileName is the name of the manifest object at the top of the rehydration location. It is distinct from the
+// manifest.json that Pennsieve publishes with each dataset version.
+const FileName = "rehydration-manifest.json"
+
+const contentType = "application/json"
+
+// Location returns the S3 URI of the manifest in the given rehydration location
+func Location(rehydrationLocation string) string {
+	return rehydrationLocation + FileName
+}
+
+type File struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Source and SourceVersionID identify the published object that was rehydrated. Source is an S3 URI.
+	Source          string `json:"source,omitempty"`
+	SourceVersionID string `json:"sourceVersionId,omitempty"`
+	// CopiedFrom is the S3 URI of the copy of Source, made by a rehydration of another version of the dataset, that
+	// this file was copied from. Empty if the file was copied from Source.
+	CopiedFrom string `json:"copiedFrom,omitempty"`
+}
+
+type MissingFile struct {
+	Path string `json:"path"`
+	// Error is the error from the last attempt to copy the file
+	Error string `json:"error"`
+}
+
+type Manifest struct {
+	DatasetID           int                        `json:"datasetId"`
+	DatasetVersionID    int                        `json:"datasetVersionId"`
+	RehydrationLocation string                     `json:"rehydrationLocation"`
+	Status              tracking.RehydrationStatus `json:"status"`
+	CreatedAt           time.Time                  `json:"createdAt"`
+	Files               []File                     `json:"files"`
+	MissingFiles        []MissingFile              `json:"missingFiles,omitempty"`
+}
+
+type Writer interface {
+	// Write saves m as a JSON object at the given bucket and key
+	Write(ctx context.Context, bucket, key string, m Manifest) error
+}
+
+type Reader interface {
+	// Read returns the manifest saved at the given bucket and key, or nil if there is none
+	Read(ctx context.Context, bucket, key string) (*Manifest, error)
+	// Keys returns the keys that a manifest would have in each location directly under prefix. For example, with
+	// prefix 5065/, the manifest keys of every rehydrated version of dataset 5065. There may be no manifest at a key.
+	Keys(ctx context.Context, bucket, prefix string) ([]string, error)
+}
+
+type S3Writer struct {
+	client *s3.Client
+}
+
+func NewS3Writer(client *s3.Client) *S3Writer {
+	return &S3Writer{client: client}
+}
+
+func (w *S3Writer) Write(ctx context.Context, bucket, key string, m Manifest) error {
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling manifest: %w", err)
+	}
+	if _, err := w.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	}); err != nil {
+		return fmt.Errorf("error writing manifest to s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+type S3Reader struct {
+	client *s3.Client
+}
+
+func NewS3Reader(client *s3.Client) *S3Reader {
+	return &S3Reader{client: client}
+}
+
+func (r *S3Reader) Read(ctx context.Context, bucket, key string) (*Manifest, error) {
+	out, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading manifest s3://%s/%s: %w", bucket, key, err)
+	}
+	defer out.Body.Close()
+	var m Manifest
+	if err := json.NewDecoder(out.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("error unmarshalling manifest s3://%s/%s: %w", bucket, key, err)
+	}
+	return &m, nil
+}
+
+func (r *S3Reader) Keys(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(r.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			keys = append(keys, aws.ToString(commonPrefix.Prefix)+FileName)
+		}
+	}
+	return keys, nil
+}
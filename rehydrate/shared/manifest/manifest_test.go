@@ -0,0 +1,83 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/pennsieve/rehydration-service/shared/tracking"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestS3Writer_Write(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	bucket := "test-rehydration-bucket"
+	key := "5065/2/" + FileName
+
+	s3Fixture, _ := test.NewS3Fixture(t, s3.NewFromConfig(awsConfig), &s3.CreateBucketInput{Bucket: aws.String(bucket)}).WithObjects()
+	defer s3Fixture.Teardown()
+
+	expected := Manifest{
+		DatasetID:           5065,
+		DatasetVersionID:    2,
+		RehydrationLocation: "s3://test-rehydration-bucket/5065/2/",
+		Status:              tracking.Partial,
+		CreatedAt:           time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Files:               []File{{Path: "files/a.txt", Size: 100}},
+		MissingFiles:        []MissingFile{{Path: "files/b.txt", Error: "access denied"}},
+	}
+	require.NoError(t, NewS3Writer(s3Fixture.Client).Write(ctx, bucket, key, expected))
+
+	out, err := s3Fixture.Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	require.NoError(t, err)
+	defer out.Body.Close()
+	assert.Equal(t, contentType, aws.ToString(out.ContentType))
+
+	var actual Manifest
+	require.NoError(t, json.NewDecoder(out.Body).Decode(&actual))
+	assert.Equal(t, expected, actual)
+}
+
+func TestS3Reader(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	bucket := "test-rehydration-bucket"
+
+	s3Fixture, _ := test.NewS3Fixture(t, s3.NewFromConfig(awsConfig), &s3.CreateBucketInput{Bucket: aws.String(bucket)}).
+		WithObjects(&s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String("5065/3/files/a.txt"), Body: strings.NewReader("a")})
+	defer s3Fixture.Teardown()
+
+	expected := Manifest{
+		DatasetID:           5065,
+		DatasetVersionID:    2,
+		RehydrationLocation: "s3://test-rehydration-bucket/5065/2/",
+		Status:              tracking.Completed,
+		CreatedAt:           time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Files:               []File{{Path: "files/a.txt", Size: 1, Source: "s3://publish-bucket/5065/files/a.txt", SourceVersionID: "v1"}},
+	}
+	require.NoError(t, NewS3Writer(s3Fixture.Client).Write(ctx, bucket, "5065/2/"+FileName, expected))
+
+	reader := NewS3Reader(s3Fixture.Client)
+	keys, err := reader.Keys(ctx, bucket, "5065/")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"5065/2/" + FileName, "5065/3/" + FileName}, keys)
+
+	actual, err := reader.Read(ctx, bucket, "5065/2/"+FileName)
+	require.NoError(t, err)
+	assert.Equal(t, &expected, actual)
+
+	// version 3 was not finished, so it has no manifest
+	missing, err := reader.Read(ctx, bucket, "5065/3/"+FileName)
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestLocation(t *testing.T) {
+	assert.Equal(t, "s3://test-rehydration-bucket/5065/2/rehydration-manifest.json", Location("s3://test-rehydration-bucket/5065/2/"))
+}
@@ -0,0 +1,252 @@
+// Package metrics emits CloudWatch metrics as Embedded Metric Format (EMF) records.
+//
+// EMF records are JSON log lines that CloudWatch Logs turns into metrics, so nothing here calls the CloudWatch API.
+// See https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+package metrics
+
+import (
+	"encoding/json"
+	"github.com/pennsieve/rehydration-service/shared/logging"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NamespaceKey is the environment variable that sets the CloudWatch namespace of Default
+const NamespaceKey = "METRICS_NAMESPACE"
+
+const DefaultNamespace = "Pennsieve/Rehydration"
+
+// ComponentDimension is the dimension that tells which part of the service emitted a metric
+const ComponentDimension = "Component"
+
+// MaxValuesPerRecord is the most values EMF allows for one metric in a record
+const MaxValuesPerRecord = 100
+
+type Unit string
+
+const (
+	Count        Unit = "Count"
+	Bytes        Unit = "Bytes"
+	Milliseconds Unit = "Milliseconds"
+)
+
+// Dimensions maps dimension names to values. May be nil.
+type Dimensions map[string]string
+
+// Sink receives EMF records. Each record is one JSON object without a trailing newline.
+type Sink interface {
+	Write(record []byte) error
+}
+
+// WriterSink writes each record on its own line to an io.Writer. It is safe to share among goroutines.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(append(record, '\n'))
+	return err
+}
+
+// Default writes to stdout where Lambda and the awslogs driver of the Fargate task pick it up. Its namespace is
+// the value of NamespaceKey or DefaultNamespace if that is not set.
+var Default = New(NewWriterSink(os.Stdout), namespaceFromEnv())
+
+func namespaceFromEnv() string {
+	if namespace, set := os.LookupEnv(NamespaceKey); set && len(namespace) > 0 {
+		return namespace
+	}
+	return DefaultNamespace
+}
+
+// Recorder emits metrics to a Sink. It is safe to share among goroutines.
+//
+// A nil *Recorder is valid and emits nothing, so that code under test does not need one.
+type Recorder struct {
+	namespace  string
+	dimensions Dimensions
+	shared     *shared
+}
+
+// shared is the state common to a Recorder and the ones created from it by With
+type shared struct {
+	mu            sync.Mutex
+	sink          Sink
+	distributions map[string]*distribution
+}
+
+type distribution struct {
+	namespace  string
+	name       string
+	unit       Unit
+	dimensions Dimensions
+	values     []float64
+}
+
+func New(sink Sink, namespace string) *Recorder {
+	return &Recorder{
+		namespace: namespace,
+		shared:    &shared{sink: sink, distributions: map[string]*distribution{}},
+	}
+}
+
+// With returns a Recorder that adds dimensions to every metric. It shares its Sink and unflushed values with r.
+func (r *Recorder) With(dimensions Dimensions) *Recorder {
+	if r == nil {
+		return nil
+	}
+	return &Recorder{namespace: r.namespace, dimensions: merge(r.dimensions, dimensions), shared: r.shared}
+}
+
+// SetSink replaces the Sink of r and every Recorder sharing it. Meant for tests.
+func (r *Recorder) SetSink(sink Sink) {
+	r.shared.mu.Lock()
+	defer r.shared.mu.Unlock()
+	r.shared.sink = sink
+}
+
+// Sink returns the current Sink of r
+func (r *Recorder) Sink() Sink {
+	r.shared.mu.Lock()
+	defer r.shared.mu.Unlock()
+	return r.shared.sink
+}
+
+// Put emits a record with a single value right away
+func (r *Recorder) Put(name string, unit Unit, value float64, dimensions Dimensions) {
+	if r == nil {
+		return
+	}
+	r.write(r.namespace, name, unit, merge(r.dimensions, dimensions), value)
+}
+
+// Observe saves value to be emitted with other values of the same metric and dimensions, so that CloudWatch can
+// compute percentiles without a record per value. Values are emitted once there are MaxValuesPerRecord of them, or
+// when Flush is called.
+func (r *Recorder) Observe(name string, unit Unit, value float64, dimensions Dimensions) {
+	if r == nil {
+		return
+	}
+	allDimensions := merge(r.dimensions, dimensions)
+	key := distributionKey(r.namespace, name, allDimensions)
+
+	r.shared.mu.Lock()
+	d, ok := r.shared.distributions[key]
+	if !ok {
+		d = &distribution{namespace: r.namespace, name: name, unit: unit, dimensions: allDimensions}
+		r.shared.distributions[key] = d
+	}
+	d.values = append(d.values, value)
+	var full []float64
+	if len(d.values) == MaxValuesPerRecord {
+		full, d.values = d.values, nil
+	}
+	r.shared.mu.Unlock()
+
+	if full != nil {
+		r.write(d.namespace, d.name, d.unit, d.dimensions, full)
+	}
+}
+
+// Flush emits any values saved by Observe on r or a Recorder sharing its state
+func (r *Recorder) Flush() {
+	if r == nil {
+		return
+	}
+	r.shared.mu.Lock()
+	var pending []distribution
+	for _, d := range r.shared.distributions {
+		if len(d.values) > 0 {
+			pending = append(pending, *d)
+			d.values = nil
+		}
+	}
+	r.shared.mu.Unlock()
+
+	for _, d := range pending {
+		r.write(d.namespace, d.name, d.unit, d.dimensions, d.values)
+	}
+}
+
+// write does not return errors since failing to emit a metric should never fail the caller
+func (r *Recorder) write(namespace, name string, unit Unit, dimensions Dimensions, value any) {
+	record, err := NewRecord(namespace, name, unit, dimensions, value)
+	if err == nil {
+		err = r.Sink().Write(record)
+	}
+	if err != nil {
+		logging.Default.Warn("error emitting metric", slog.String("metric", name), slog.Any("error", err))
+	}
+}
+
+type metricDefinition struct {
+	Name string `json:"Name"`
+	Unit Unit   `json:"Unit"`
+}
+
+type metricDirective struct {
+	Namespace  string             `json:"Namespace"`
+	Dimensions [][]string         `json:"Dimensions"`
+	Metrics    []metricDefinition `json:"Metrics"`
+}
+
+type metadata struct {
+	Timestamp         int64             `json:"Timestamp"`
+	CloudWatchMetrics []metricDirective `json:"CloudWatchMetrics"`
+}
+
+// NewRecord returns an EMF record for a single metric. value is a float64 or a []float64.
+func NewRecord(namespace, name string, unit Unit, dimensions Dimensions, value any) ([]byte, error) {
+	dimensionNames := make([]string, 0, len(dimensions))
+	record := map[string]any{}
+	for dimensionName, dimensionValue := range dimensions {
+		dimensionNames = append(dimensionNames, dimensionName)
+		record[dimensionName] = dimensionValue
+	}
+	sort.Strings(dimensionNames)
+	record["_aws"] = metadata{
+		Timestamp: time.Now().UnixMilli(),
+		CloudWatchMetrics: []metricDirective{{
+			Namespace:  namespace,
+			Dimensions: [][]string{dimensionNames},
+			Metrics:    []metricDefinition{{Name: name, Unit: unit}},
+		}},
+	}
+	record[name] = value
+	return json.Marshal(record)
+}
+
+func merge(base, extra Dimensions) Dimensions {
+	if len(extra) == 0 {
+		return base
+	}
+	merged := make(Dimensions, len(base)+len(extra))
+	for name, value := range base {
+		merged[name] = value
+	}
+	for name, value := range extra {
+		merged[name] = value
+	}
+	return merged
+}
+
+func distributionKey(namespace, name string, dimensions Dimensions) string {
+	parts := make([]string, 0, len(dimensions))
+	for dimensionName, dimensionValue := range dimensions {
+		parts = append(parts, dimensionName+"="+dimensionValue)
+	}
+	sort.Strings(parts)
+	return namespace + "|" + name + "|" + strings.Join(parts, ",")
+}
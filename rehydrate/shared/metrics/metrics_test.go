@@ -0,0 +1,72 @@
+package metrics_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
+	"github.com/pennsieve/rehydration-service/shared/test/metricstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestRecorder_Put(t *testing.T) {
+	var out bytes.Buffer
+	recorder := metrics.New(metrics.NewWriterSink(&out), "Test/Namespace").
+		With(metrics.Dimensions{metrics.ComponentDimension: "test"})
+
+	recorder.Put("Requests", metrics.Count, 1, metrics.Dimensions{"Outcome": "Accepted"})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 1)
+	var record map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "test", record[metrics.ComponentDimension])
+	assert.Equal(t, "Accepted", record["Outcome"])
+	assert.Equal(t, float64(1), record["Requests"])
+
+	aws := record["_aws"].(map[string]any)
+	assert.NotZero(t, aws["Timestamp"])
+	directives := aws["CloudWatchMetrics"].([]any)
+	require.Len(t, directives, 1)
+	assert.Equal(t, map[string]any{
+		"Namespace":  "Test/Namespace",
+		"Dimensions": []any{[]any{metrics.ComponentDimension, "Outcome"}},
+		"Metrics":    []any{map[string]any{"Name": "Requests", "Unit": "Count"}},
+	}, directives[0])
+}
+
+func TestRecorder_Observe(t *testing.T) {
+	sink := metricstest.NewSink()
+	recorder := metrics.New(sink, "Test/Namespace")
+	simple := metrics.Dimensions{"CopyType": "simple"}
+	multipart := metrics.Dimensions{"CopyType": "multipart"}
+
+	for i := 0; i < metrics.MaxValuesPerRecord+1; i++ {
+		recorder.Observe("FileCopyLatency", metrics.Milliseconds, float64(i), simple)
+	}
+	recorder.With(nil).Observe("FileCopyLatency", metrics.Milliseconds, 1000, multipart)
+
+	// a full record is emitted right away
+	require.Len(t, sink.Metrics("FileCopyLatency", nil), 1)
+	assert.Len(t, sink.Values("FileCopyLatency", simple), metrics.MaxValuesPerRecord)
+
+	recorder.Flush()
+	assert.Len(t, sink.Metrics("FileCopyLatency", nil), 3)
+	assert.Len(t, sink.Values("FileCopyLatency", simple), metrics.MaxValuesPerRecord+1)
+	assert.Equal(t, []float64{1000}, sink.Values("FileCopyLatency", multipart))
+
+	// nothing left to flush
+	recorder.Flush()
+	assert.Len(t, sink.Metrics("FileCopyLatency", nil), 3)
+}
+
+func TestRecorder_Nil(t *testing.T) {
+	var recorder *metrics.Recorder
+	assert.NotPanics(t, func() {
+		recorder.With(metrics.Dimensions{"a": "b"}).Put("Requests", metrics.Count, 1, nil)
+		recorder.Observe("FileCopyLatency", metrics.Milliseconds, 1, nil)
+		recorder.Flush()
+	})
+}
@@ -0,0 +1,32 @@
+package models
+
+import "strings"
+
+// Backend identifies where a rehydration task was started
+type Backend string
+
+const (
+	ECSBackend        Backend = "ecs"
+	BatchBackend      Backend = "batch"
+	KubernetesBackend Backend = "k8s"
+)
+
+const jobIDSeparator = "::"
+
+// QualifiedJobID returns the identifier stored in the idempotency record and tracking table for a task or job started
+// on the given backend. ECS task ARNs are returned unchanged so that existing records continue to parse as ECS.
+// Other backends are returned as <backend>::<id>.
+func QualifiedJobID(backend Backend, id string) string {
+	if backend == ECSBackend {
+		return id
+	}
+	return string(backend) + jobIDSeparator + id
+}
+
+// ParseJobID is the inverse of QualifiedJobID. A jobID without a backend prefix is assumed to be an ECS task ARN.
+func ParseJobID(jobID string) (Backend, string) {
+	if backend, id, found := strings.Cut(jobID, jobIDSeparator); found {
+		return Backend(backend), id
+	}
+	return ECSBackend, jobID
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestQualifiedJobID(t *testing.T) {
+	for name, params := range map[string]struct {
+		backend     Backend
+		id          string
+		expectedJob string
+	}{
+		"ecs":        {ECSBackend, "arn:aws:ecs:us-east-1:123456789012:task/cluster/abc", "arn:aws:ecs:us-east-1:123456789012:task/cluster/abc"},
+		"batch":      {BatchBackend, "4c8c5a52-7d4c-4b1a-9a67-0d6a3c2e3b10", "batch::4c8c5a52-7d4c-4b1a-9a67-0d6a3c2e3b10"},
+		"kubernetes": {KubernetesBackend, "rehydration/rehydrate-x7k2p", "k8s::rehydration/rehydrate-x7k2p"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			jobID := QualifiedJobID(params.backend, params.id)
+			assert.Equal(t, params.expectedJob, jobID)
+			backend, id := ParseJobID(jobID)
+			assert.Equal(t, params.backend, backend)
+			assert.Equal(t, params.id, id)
+		})
+	}
+}
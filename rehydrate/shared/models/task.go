@@ -1,8 +1,51 @@
 package models
 
+import "fmt"
+
 const ECSTaskDatasetIDKey = "DATASET_ID"
 
 const ECSTaskDatasetVersionIDKey = "DATASET_VERSION_ID"
 const ECSTaskUserNameKey = "USER_NAME"
 const ECSTaskUserEmailKey = "USER_EMAIL"
 const ECSTaskEnvKey = "ENV"
+
+// ThresholdSize is the file size in bytes at or above which the rehydration task copies a file with a multipart copy
+const ThresholdSize = int64(100 * 1024 * 1024)
+
+// ECSTaskRehydrationRegionKey is the region of the task's rehydration bucket. Only set if the request chose a
+// Destination, in which case the task's REHYDRATION_BUCKET is overridden with Destination.Bucket.
+const ECSTaskRehydrationRegionKey = "REHYDRATION_REGION"
+
+// ECSTaskRehydrationPrefixKey and ECSTaskRehydrationRoleARNKey are only set for an external Destination
+const ECSTaskRehydrationPrefixKey = "REHYDRATION_PREFIX"
+const ECSTaskRehydrationRoleARNKey = "REHYDRATION_ROLE_ARN"
+
+// ECSTaskRequestIDKey is the ID of the request that started the task. Other requests for the same rehydration may
+// be waiting on it too.
+const ECSTaskRequestIDKey = "REQUEST_ID"
+
+// ECSTaskResumeRestoresKey is set to true when a dispatcher will start the task again after it exits to wait for
+// restores of archived files. Otherwise the task waits for them itself.
+const ECSTaskResumeRestoresKey = "RESUME_RESTORES"
+
+// Destination is a rehydration bucket other than the default one that a request chose. It is either one of our
+// buckets in another region, or, if RoleARN is set, an external bucket owned by the requester.
+type Destination struct {
+	// Region is the region of Bucket. May be empty for an external bucket in the default region.
+	Region string `json:"region,omitempty"`
+	Bucket string `json:"bucket"`
+	// Prefix is prepended to the keys of an external rehydration. Empty or ends in '/'.
+	Prefix string `json:"prefix,omitempty"`
+	// RoleARN is the role the task assumes to write to an external bucket
+	RoleARN string `json:"roleArn,omitempty"`
+}
+
+// External returns true if d is a bucket owned by the requester rather than one of ours. False if d is nil.
+func (d *Destination) External() bool {
+	return d != nil && len(d.RoleARN) > 0
+}
+
+// Location returns d as an S3 URL, for example s3://bucket/prefix/
+func (d *Destination) Location() string {
+	return fmt.Sprintf("s3://%s/%s", d.Bucket, d.Prefix)
+}
@@ -3,9 +3,16 @@ package notification
 import (
 	"context"
 	"github.com/pennsieve/rehydration-service/shared/models"
+	"time"
 )
 
 type Emailer interface {
 	SendRehydrationComplete(ctx context.Context, dataset models.Dataset, user models.User, rehydrationLocation string) error
 	SendRehydrationFailed(ctx context.Context, dataset models.Dataset, user models.User, requestID string) error
+	// SendRehydrationPartial is for rehydrations that completed without some files. manifestLocation is the S3 URI of
+	// the manifest listing all the copied and missing files.
+	SendRehydrationPartial(ctx context.Context, dataset models.Dataset, user models.User, rehydrationLocation string, missingFiles []string, manifestLocation string) error
+	// SendRehydrationRestoring tells the user that the rehydration is waiting for restoringFileCount archived files to
+	// be restored, which should be done by expectedReadyAt
+	SendRehydrationRestoring(ctx context.Context, dataset models.Dataset, user models.User, restoringFileCount int, expectedReadyAt time.Time) error
 }
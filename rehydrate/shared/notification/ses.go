@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ses"
 	"github.com/aws/aws-sdk-go-v2/service/ses/types"
 	"github.com/pennsieve/rehydration-service/shared/models"
+	"time"
 )
 
 const PennsieveDomainKey = "PENNSIEVE_DOMAIN"
@@ -61,6 +62,30 @@ func (e *SESEmailer) SendRehydrationFailed(ctx context.Context, dataset models.D
 	})
 }
 
+func (e *SESEmailer) SendRehydrationPartial(ctx context.Context, dataset models.Dataset, user models.User, rehydrationLocation string, missingFiles []string, manifestLocation string) error {
+	body, err := RehydrationPartialEmailBody(dataset.ID, dataset.VersionID, rehydrationLocation, e.awsRegion, missingFiles, manifestLocation)
+	if err != nil {
+		return err
+	}
+	return e.sendEmail(ctx, htmlEmail{
+		Recipient: user.Email,
+		Subject:   "Dataset Rehydration Partially Complete",
+		Body:      body,
+	})
+}
+
+func (e *SESEmailer) SendRehydrationRestoring(ctx context.Context, dataset models.Dataset, user models.User, restoringFileCount int, expectedReadyAt time.Time) error {
+	body, err := RehydrationRestoringEmailBody(dataset.ID, dataset.VersionID, restoringFileCount, time.Until(expectedReadyAt), expectedReadyAt)
+	if err != nil {
+		return err
+	}
+	return e.sendEmail(ctx, htmlEmail{
+		Recipient: user.Email,
+		Subject:   "Dataset Rehydration Waiting for Archived Files",
+		Body:      body,
+	})
+}
+
 func (e *SESEmailer) sendEmail(ctx context.Context, email htmlEmail) error {
 	sendInput := &ses.SendEmailInput{
 		Destination: &types.Destination{
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"strings"
+	"time"
 )
 
 // The HTML email templates in the html directory of this package
@@ -20,6 +21,11 @@ import (
 var rehydrationEmailTemplatesFS embed.FS
 var rehydrationCompleteTemplate *template.Template
 var rehydrationFailedTemplate *template.Template
+var rehydrationPartialTemplate *template.Template
+var rehydrationRestoringTemplate *template.Template
+
+// MaxListedMissingFiles is the most missing files listed in a partial rehydration email. The rest are only counted.
+const MaxListedMissingFiles = 20
 
 type rehydrationCompleteData struct {
 	DatasetID           int
@@ -35,6 +41,22 @@ type rehydrationFailedData struct {
 	SupportEmailAddress string
 }
 
+type rehydrationRestoringData struct {
+	DatasetID          int
+	DatasetVersionID   int
+	RestoringFileCount int
+	ExpectedDelay      string
+	ExpectedReadyAt    string
+}
+
+type rehydrationPartialData struct {
+	rehydrationCompleteData
+	MissingFileCount int
+	MissingFiles     []string
+	MoreMissingFiles int
+	ManifestLocation string
+}
+
 func parseTemplate(pattern string) (*template.Template, error) {
 	emailTemplate, err := template.ParseFS(rehydrationEmailTemplatesFS, pattern)
 	if err != nil {
@@ -49,6 +71,14 @@ func LoadTemplates() (err error) {
 		return
 	}
 	rehydrationFailedTemplate, err = parseTemplate("html/rehydration-failed.html")
+	if err != nil {
+		return
+	}
+	rehydrationPartialTemplate, err = parseTemplate("html/rehydration-partial.html")
+	if err != nil {
+		return
+	}
+	rehydrationRestoringTemplate, err = parseTemplate("html/rehydration-restoring.html")
 	return
 }
 
@@ -70,6 +100,53 @@ func RehydrationFailedEmailBody(datasetID, datasetVersionID int, requestID strin
 	})
 }
 
+func RehydrationPartialEmailBody(datasetID, datasetVersionID int, rehydrationLocation, awsRegion string, missingFiles []string, manifestLocation string) (string, error) {
+	listed := missingFiles
+	if len(listed) > MaxListedMissingFiles {
+		listed = listed[:MaxListedMissingFiles]
+	}
+	return executeTemplate(rehydrationPartialTemplate, rehydrationPartialData{
+		rehydrationCompleteData: rehydrationCompleteData{
+			DatasetID:           datasetID,
+			DatasetVersionID:    datasetVersionID,
+			RehydrationLocation: rehydrationLocation,
+			AWSRegion:           awsRegion,
+		},
+		MissingFileCount: len(missingFiles),
+		MissingFiles:     listed,
+		MoreMissingFiles: len(missingFiles) - len(listed),
+		ManifestLocation: manifestLocation,
+	})
+}
+
+// RehydrationRestoringEmailBody is for rehydrations waiting for restoringFileCount archived files to be restored.
+// expectedReadyAt is shown in UTC.
+func RehydrationRestoringEmailBody(datasetID, datasetVersionID int, restoringFileCount int, expectedDelay time.Duration, expectedReadyAt time.Time) (string, error) {
+	return executeTemplate(rehydrationRestoringTemplate, rehydrationRestoringData{
+		DatasetID:          datasetID,
+		DatasetVersionID:   datasetVersionID,
+		RestoringFileCount: restoringFileCount,
+		ExpectedDelay:      formatDelay(expectedDelay),
+		ExpectedReadyAt:    expectedReadyAt.UTC().Format("Jan 2, 2006 15:04 MST"),
+	})
+}
+
+// formatDelay rounds d to minutes if it is under an hour, and to hours otherwise
+func formatDelay(d time.Duration) string {
+	if d < time.Hour {
+		minutes := int(d.Round(time.Minute).Minutes())
+		if minutes <= 1 {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	hours := int(d.Round(time.Hour).Hours())
+	if hours == 1 {
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d hours", hours)
+}
+
 func executeTemplate(emailTemplate *template.Template, data any) (string, error) {
 	if emailTemplate == nil {
 		return "", fmt.Errorf("email templates are not initialized. Need to call notification.LoadTemplates()")
@@ -6,12 +6,15 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func TestLoadTemplates(t *testing.T) {
 	require.NoError(t, LoadTemplates())
 	assert.NotNil(t, rehydrationCompleteTemplate)
 	assert.NotNil(t, rehydrationFailedTemplate)
+	assert.NotNil(t, rehydrationPartialTemplate)
+	assert.NotNil(t, rehydrationRestoringTemplate)
 }
 
 func TestRehydrationCompleteEmailBody(t *testing.T) {
@@ -45,3 +48,68 @@ func TestRehydrationFailedEmailBody(t *testing.T) {
 	assert.Contains(t, body, fmt.Sprintf("mailto:%s", supportEmail))
 	assert.Contains(t, body, fmt.Sprintf("subject=Rehydration%%20request%%20%s", requestID))
 }
+
+func TestRehydrationPartialEmailBody(t *testing.T) {
+	require.NoError(t, LoadTemplates())
+	datasetID := 5065
+	datasetVersionID := 3
+	rehydrationLocation := fmt.Sprintf("s3://bucket/%d/%d/", datasetID, datasetVersionID)
+	manifestLocation := rehydrationLocation + "rehydration-manifest.json"
+	awsRegion := "us-east-1"
+
+	t.Run("few missing", func(t *testing.T) {
+		missingFiles := []string{"files/a.txt", "files/b&c.txt"}
+		body, err := RehydrationPartialEmailBody(datasetID, datasetVersionID, rehydrationLocation, awsRegion, missingFiles, manifestLocation)
+		require.NoError(t, err)
+		assert.Contains(t, body, "Rehydration Partially Complete")
+		assert.Contains(t, body, fmt.Sprintf("Dataset %d version %d is complete, except for 2 files", datasetID, datasetVersionID))
+		assert.Contains(t, body, rehydrationLocation)
+		assert.Contains(t, body, manifestLocation)
+		assert.Contains(t, body, awsRegion)
+		assert.Contains(t, body, "<code>files/a.txt</code>")
+		assert.Contains(t, body, "<code>files/b&amp;c.txt</code>")
+		assert.NotContains(t, body, "<br>and ")
+	})
+
+	t.Run("many missing", func(t *testing.T) {
+		var missingFiles []string
+		for i := 0; i < MaxListedMissingFiles+5; i++ {
+			missingFiles = append(missingFiles, fmt.Sprintf("files/%d.txt", i))
+		}
+		body, err := RehydrationPartialEmailBody(datasetID, datasetVersionID, rehydrationLocation, awsRegion, missingFiles, manifestLocation)
+		require.NoError(t, err)
+		assert.Contains(t, body, fmt.Sprintf("except for %d files", len(missingFiles)))
+		assert.Contains(t, body, fmt.Sprintf("<code>files/%d.txt</code>", MaxListedMissingFiles-1))
+		assert.NotContains(t, body, fmt.Sprintf("<code>files/%d.txt</code>", MaxListedMissingFiles))
+		assert.Contains(t, body, "and 5 more")
+	})
+
+	t.Run("no manifest", func(t *testing.T) {
+		body, err := RehydrationPartialEmailBody(datasetID, datasetVersionID, rehydrationLocation, awsRegion, []string{"files/a.txt"}, "")
+		require.NoError(t, err)
+		assert.Contains(t, body, "except for 1 files")
+		assert.NotContains(t, body, "Manifest:")
+	})
+}
+
+func TestRehydrationRestoringEmailBody(t *testing.T) {
+	require.NoError(t, LoadTemplates())
+	datasetID := 5065
+	datasetVersionID := 3
+	expectedReadyAt := time.Date(2024, 3, 1, 17, 30, 0, 0, time.UTC)
+
+	body, err := RehydrationRestoringEmailBody(datasetID, datasetVersionID, 12, 5*time.Hour+10*time.Minute, expectedReadyAt)
+	require.NoError(t, err)
+	assert.Contains(t, body, "Rehydration Waiting for Archived Files")
+	assert.Contains(t, body, fmt.Sprintf("Dataset %d version %d", datasetID, datasetVersionID))
+	assert.Contains(t, body, "12 of its files are archived")
+	assert.Contains(t, body, "about 5 hours")
+	assert.Contains(t, body, "Mar 1, 2024 17:30 UTC")
+}
+
+func TestFormatDelay(t *testing.T) {
+	assert.Equal(t, "1 minute", formatDelay(20*time.Second))
+	assert.Equal(t, "5 minutes", formatDelay(5*time.Minute))
+	assert.Equal(t, "1 hour", formatDelay(70*time.Minute))
+	assert.Equal(t, "48 hours", formatDelay(48*time.Hour))
+}
@@ -0,0 +1,94 @@
+// Package regions maps the AWS regions that rehydrations can be sent to onto the rehydration bucket in each one.
+package regions
+
+import (
+	"fmt"
+	"github.com/pennsieve/rehydration-service/shared"
+	"sort"
+	"strings"
+)
+
+// BucketsKey is the environment variable that lists the rehydration buckets outside the default region as
+// comma-separated region=bucket pairs, for example "eu-west-1=rehydration-eu,ap-southeast-2=rehydration-ap".
+// It is optional. If it is not set, only the default region is available.
+const BucketsKey = "REHYDRATION_REGION_BUCKETS"
+
+// Buckets is the rehydration bucket for each region other than the default one
+type Buckets struct {
+	// DefaultRegion is the region of the default rehydration bucket
+	DefaultRegion string
+	byRegion      map[string]string
+}
+
+// NewBuckets returns Buckets for the given region to bucket map, which should not include defaultRegion
+func NewBuckets(defaultRegion string, byRegion map[string]string) *Buckets {
+	buckets := &Buckets{DefaultRegion: defaultRegion, byRegion: map[string]string{}}
+	for region, bucket := range byRegion {
+		buckets.byRegion[region] = bucket
+	}
+	return buckets
+}
+
+// FromLookup reads the default region from shared.AWSRegionKey and the other regions from BucketsKey
+func FromLookup(lookup shared.LookupFunc) (*Buckets, error) {
+	defaultRegion, err := shared.NonEmptyFromLookup(lookup, shared.AWSRegionKey)
+	if err != nil {
+		return nil, err
+	}
+	byRegion := map[string]string{}
+	value, set := lookup(BucketsKey)
+	if !set || len(strings.TrimSpace(value)) == 0 {
+		return NewBuckets(defaultRegion, byRegion), nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		region, bucket, found := strings.Cut(strings.TrimSpace(pair), "=")
+		region, bucket = strings.TrimSpace(region), strings.TrimSpace(bucket)
+		if !found || len(region) == 0 || len(bucket) == 0 {
+			return nil, fmt.Errorf("invalid entry %q in %s: expected region=bucket", pair, BucketsKey)
+		}
+		if region == defaultRegion {
+			return nil, fmt.Errorf("invalid entry %q in %s: %s is the default region", pair, BucketsKey, region)
+		}
+		if _, duplicate := byRegion[region]; duplicate {
+			return nil, fmt.Errorf("invalid value for %s: region %s appears more than once", BucketsKey, region)
+		}
+		byRegion[region] = bucket
+	}
+	return NewBuckets(defaultRegion, byRegion), nil
+}
+
+// Bucket returns the rehydration bucket in region. Returns false if region is the default region or is not configured.
+func (b *Buckets) Bucket(region string) (string, bool) {
+	bucket, ok := b.byRegion[region]
+	return bucket, ok
+}
+
+// Region returns the region of bucket, or DefaultRegion if bucket is not one of the configured buckets
+func (b *Buckets) Region(bucket string) string {
+	for region, regionBucket := range b.byRegion {
+		if regionBucket == bucket {
+			return region
+		}
+	}
+	return b.DefaultRegion
+}
+
+// Regions returns the configured regions other than the default one, sorted
+func (b *Buckets) Regions() []string {
+	var regions []string
+	for region := range b.byRegion {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// All returns defaultBucket, which is the rehydration bucket in DefaultRegion, followed by the bucket of each of
+// Regions
+func (b *Buckets) All(defaultBucket string) []string {
+	all := []string{defaultBucket}
+	for _, region := range b.Regions() {
+		all = append(all, b.byRegion[region])
+	}
+	return all
+}
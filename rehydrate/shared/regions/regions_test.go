@@ -0,0 +1,62 @@
+package regions
+
+import (
+	"github.com/pennsieve/rehydration-service/shared"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func lookupFrom(env map[string]string) shared.LookupFunc {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestFromLookup(t *testing.T) {
+	buckets, err := FromLookup(lookupFrom(map[string]string{
+		shared.AWSRegionKey: "us-east-1",
+		BucketsKey:          "eu-west-1=rehydration-eu, ap-southeast-2 = rehydration-ap",
+	}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "us-east-1", buckets.DefaultRegion)
+	assert.Equal(t, []string{"ap-southeast-2", "eu-west-1"}, buckets.Regions())
+
+	bucket, ok := buckets.Bucket("eu-west-1")
+	assert.True(t, ok)
+	assert.Equal(t, "rehydration-eu", bucket)
+
+	_, ok = buckets.Bucket("us-east-1")
+	assert.False(t, ok)
+	_, ok = buckets.Bucket("us-west-2")
+	assert.False(t, ok)
+
+	assert.Equal(t, "ap-southeast-2", buckets.Region("rehydration-ap"))
+	assert.Equal(t, "us-east-1", buckets.Region("rehydration-default"))
+
+	assert.Equal(t, []string{"rehydration-default", "rehydration-ap", "rehydration-eu"}, buckets.All("rehydration-default"))
+}
+
+func TestFromLookup_NotSet(t *testing.T) {
+	buckets, err := FromLookup(lookupFrom(map[string]string{shared.AWSRegionKey: "us-east-1"}))
+	require.NoError(t, err)
+	assert.Empty(t, buckets.Regions())
+	assert.Equal(t, "us-east-1", buckets.Region("any-bucket"))
+	assert.Equal(t, []string{"rehydration-default"}, buckets.All("rehydration-default"))
+}
+
+func TestFromLookup_Invalid(t *testing.T) {
+	for name, value := range map[string]string{
+		"missing bucket": "eu-west-1=",
+		"missing equals": "eu-west-1",
+		"default region": "us-east-1=rehydration-east",
+		"duplicate":      "eu-west-1=rehydration-eu,eu-west-1=rehydration-eu-2",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := FromLookup(lookupFrom(map[string]string{shared.AWSRegionKey: "us-east-1", BucketsKey: value}))
+			assert.ErrorContains(t, err, BucketsKey)
+		})
+	}
+}
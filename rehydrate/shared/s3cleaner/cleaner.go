@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"time"
 )
 
 // MaxCleanBatch is the maximum number of keys that can be sent to DeleteObjects in one call.
@@ -20,9 +21,29 @@ type Cleaner interface {
 	//
 	// It is an error if the keyPrefix does not end in '/'
 	//
+	// options may narrow down what is deleted. The zero CleanOptions deletes everything under keyPrefix, up to any
+	// limit the Cleaner was configured with.
+	//
 	// Callers should check CleanResponse for DeleteObjectErrors which correspond to the non-error errors
 	// DeleteObject returns.
-	Clean(ctx context.Context, bucket string, keyPrefix string) (*CleanResponse, error)
+	Clean(ctx context.Context, bucket string, keyPrefix string, options CleanOptions) (*CleanResponse, error)
+}
+
+type CleanOptions struct {
+	// MaxObjects, if positive, is the most objects the call may delete, in place of any limit the Cleaner was
+	// configured with. 0 keeps the configured limit.
+	MaxObjects int
+	// ModifiedBefore, if set, limits the call to objects last modified before it. Newer objects are not deleted or
+	// counted.
+	ModifiedBefore time.Time
+	// BeforeBatch, if set, is called before each batch of objects is deleted. The call stops with its error, without
+	// deleting the batch, if it returns one.
+	BeforeBatch func(ctx context.Context) error
+}
+
+// modified returns false if lastModified is not before ModifiedBefore
+func (o CleanOptions) modified(lastModified *time.Time) bool {
+	return o.ModifiedBefore.IsZero() || aws.ToTime(lastModified).Before(o.ModifiedBefore)
 }
 
 type CleanResponse struct {
@@ -37,8 +58,10 @@ type CleanResponse struct {
 // DeleteObjectError corresponds to the AWS types.Error type returned by DeleteObject. These are not actually Go errors and are
 // passed in the normal response, so we mimic that here.
 type DeleteObjectError struct {
-	Key     string
-	Message string
+	Key string
+	// VersionID is the version that could not be deleted. Empty unless the bucket is versioned.
+	VersionID string
+	Message   string
 }
 
 func fromAWSError(awsError types.Error) DeleteObjectError {
@@ -48,8 +71,9 @@ func fromAWSError(awsError types.Error) DeleteObjectError {
 		aws.ToString(awsError.Message),
 		aws.ToString(awsError.Code))
 	return DeleteObjectError{
-		Key:     key,
-		Message: message,
+		Key:       key,
+		VersionID: aws.ToString(awsError.VersionId),
+		Message:   message,
 	}
 }
 
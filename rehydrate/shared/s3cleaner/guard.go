@@ -0,0 +1,99 @@
+package s3cleaner
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pennsieve/rehydration-service/shared"
+	"log/slog"
+	"regexp"
+)
+
+// MaxObjectsKey optionally overrides DefaultMaxObjects. A value of 0 turns the limit off.
+const MaxObjectsKey = "CLEANER_MAX_OBJECTS"
+
+// DefaultMaxObjects is the most objects a guarded Clean will delete. It is above the file count of the largest
+// published datasets, so a Clean over it is more likely to be pointed at the wrong place than at a real rehydration.
+const DefaultMaxObjects = 5_000_000
+
+// MaxObjectsFromLookup returns DefaultMaxObjects if lookup does not find MaxObjectsKey
+func MaxObjectsFromLookup(lookup shared.LookupFunc) (int, error) {
+	if _, set := lookup(MaxObjectsKey); !set {
+		return DefaultMaxObjects, nil
+	}
+	maxObjects, err := shared.IntFromLookup(lookup, MaxObjectsKey)
+	if err != nil {
+		return 0, err
+	}
+	if maxObjects < 0 {
+		return 0, fmt.Errorf("%s cannot be negative", MaxObjectsKey)
+	}
+	return maxObjects, nil
+}
+
+// Guard keeps an S3Cleaner to deleting rehydrations. A guarded Clean is refused unless the bucket is one of the
+// Guard's buckets and the prefix is a rehydration location, <keyPrefix><datasetId>/<versionId>/, and is refused before
+// anything is deleted if there are more than maxObjects keys under it, or the limit passed to Clean. Every batch a guarded Clean deletes is
+// logged as an audit event.
+type Guard struct {
+	buckets    map[string]bool
+	prefix     *regexp.Regexp
+	maxObjects int
+	logger     *slog.Logger
+}
+
+// NewGuard returns a Guard for rehydrations in buckets whose keys start with keyPrefix, which is empty unless the
+// rehydrations are in a requester-owned bucket. maxObjects of 0 means no limit.
+func NewGuard(buckets []string, keyPrefix string, maxObjects int, logger *slog.Logger) *Guard {
+	allowed := map[string]bool{}
+	for _, bucket := range buckets {
+		allowed[bucket] = true
+	}
+	return &Guard{
+		buckets:    allowed,
+		prefix:     regexp.MustCompile("^" + regexp.QuoteMeta(keyPrefix) + `[0-9]+/[0-9]+/$`),
+		maxObjects: maxObjects,
+		logger:     logger,
+	}
+}
+
+// GuardError is returned by a Clean that was refused. Nothing has been deleted.
+type GuardError struct {
+	Bucket    string
+	KeyPrefix string
+	Reason    string
+}
+
+func (e *GuardError) Error() string {
+	return fmt.Sprintf("refusing to clean bucket %s under prefix %s: %s", e.Bucket, e.KeyPrefix, e.Reason)
+}
+
+// Check returns a *GuardError if keyPrefix in bucket is not a rehydration location this Guard allows
+func (g *Guard) Check(bucket string, keyPrefix string) error {
+	if !g.buckets[bucket] {
+		return &GuardError{Bucket: bucket, KeyPrefix: keyPrefix, Reason: "bucket is not a rehydration bucket"}
+	}
+	if !g.prefix.MatchString(keyPrefix) {
+		return &GuardError{Bucket: bucket, KeyPrefix: keyPrefix, Reason: fmt.Sprintf("prefix does not match %s", g.prefix)}
+	}
+	return nil
+}
+
+// audit logs the outcome of deleting batch. batchErrors are the objects that could not be deleted, and err is set if
+// the batch could not be deleted at all.
+func (g *Guard) audit(bucket string, keyPrefix string, batch []types.ObjectIdentifier, batchErrors []types.Error, err error) {
+	attrs := []any{
+		slog.String("auditEvent", "DeleteObjects"),
+		slog.String("bucket", bucket),
+		slog.String("prefix", keyPrefix),
+		slog.Int("requested", len(batch)),
+		slog.String("firstKey", aws.ToString(batch[0].Key)),
+		slog.String("lastKey", aws.ToString(batch[len(batch)-1].Key)),
+	}
+	if err != nil {
+		g.logger.Error("audit: delete batch failed", append(attrs, slog.Any("error", err))...)
+		return
+	}
+	g.logger.Info("audit: deleted batch",
+		append(attrs, slog.Int("deleted", len(batch)-len(batchErrors)), slog.Int("failed", len(batchErrors)))...)
+}
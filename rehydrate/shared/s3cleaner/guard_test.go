@@ -0,0 +1,177 @@
+package s3cleaner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pennsieve/rehydration-service/shared/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestGuard_Check(t *testing.T) {
+	guard := NewGuard([]string{"rehydration-bucket", "rehydration-bucket-eu"}, "", 0, slog.Default())
+	externalGuard := NewGuard([]string{"lab-bucket"}, "pennsieve/data/", 0, slog.Default())
+	for _, tst := range []struct {
+		name      string
+		guard     *Guard
+		bucket    string
+		keyPrefix string
+		allowed   bool
+	}{
+		{"rehydration location", guard, "rehydration-bucket", "1234/5/", true},
+		{"other rehydration bucket", guard, "rehydration-bucket-eu", "1234/5/", true},
+		{"unknown bucket", guard, "discover-bucket", "1234/5/", false},
+		{"dataset prefix", guard, "rehydration-bucket", "1234/", false},
+		{"nested prefix", guard, "rehydration-bucket", "1234/5/files/", false},
+		{"non-numeric prefix", guard, "rehydration-bucket", "reports/2026/", false},
+		{"external location", externalGuard, "lab-bucket", "pennsieve/data/1234/5/", true},
+		{"external location without key prefix", externalGuard, "lab-bucket", "1234/5/", false},
+		{"external key prefix only", externalGuard, "lab-bucket", "pennsieve/data/", false},
+	} {
+		t.Run(tst.name, func(t *testing.T) {
+			err := tst.guard.Check(tst.bucket, tst.keyPrefix)
+			if tst.allowed {
+				assert.NoError(t, err)
+			} else {
+				var guardError *GuardError
+				assert.ErrorAs(t, err, &guardError)
+			}
+		})
+	}
+}
+
+func TestMaxObjectsFromLookup(t *testing.T) {
+	lookup := func(env map[string]string) func(string) (string, bool) {
+		return func(key string) (string, bool) {
+			value, set := env[key]
+			return value, set
+		}
+	}
+	maxObjects, err := MaxObjectsFromLookup(lookup(map[string]string{}))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultMaxObjects, maxObjects)
+
+	maxObjects, err = MaxObjectsFromLookup(lookup(map[string]string{MaxObjectsKey: "0"}))
+	require.NoError(t, err)
+	assert.Zero(t, maxObjects)
+
+	_, err = MaxObjectsFromLookup(lookup(map[string]string{MaxObjectsKey: "-1"}))
+	assert.Error(t, err)
+	_, err = MaxObjectsFromLookup(lookup(map[string]string{MaxObjectsKey: "many"}))
+	assert.Error(t, err)
+}
+
+func TestS3Cleaner_Clean_Guarded(t *testing.T) {
+	bucket := "cleaner-guard-test-bucket"
+	prefix := "43/1/"
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	s3Client := s3.NewFromConfig(awsConfig)
+	objects := test.GeneratePutObjectInputs(bucket, prefix, 25)
+
+	for _, tst := range []struct {
+		name       string
+		maxObjects int
+		// callMaxObjects is passed to Clean
+		callMaxObjects int
+		expectedError  bool
+	}{
+		{name: "under the limit", maxObjects: 25},
+		{name: "no limit", maxObjects: 0},
+		{name: "over the limit", maxObjects: 24, expectedError: true},
+		{name: "over the limit of the call", maxObjects: 100, callMaxObjects: 24, expectedError: true},
+		{name: "under the limit of the call", maxObjects: 24, callMaxObjects: 25},
+	} {
+		t.Run(tst.name, func(t *testing.T) {
+			s3Fixture, _ := test.NewS3Fixture(t, s3Client, &s3.CreateBucketInput{
+				Bucket: aws.String(bucket),
+			}).WithObjects(test.GeneratePutObjectInputs(bucket, prefix, len(objects))...)
+			defer s3Fixture.Teardown()
+
+			var auditLog bytes.Buffer
+			cleaner, err := NewCleaner(s3Client, 10)
+			require.NoError(t, err)
+			cleaner.SetGuard(NewGuard([]string{bucket}, "", tst.maxObjects, slog.New(slog.NewJSONHandler(&auditLog, nil))))
+
+			resp, err := cleaner.Clean(ctx, bucket, prefix, CleanOptions{MaxObjects: tst.callMaxObjects})
+			if tst.expectedError {
+				var guardError *GuardError
+				require.ErrorAs(t, err, &guardError)
+				assert.Contains(t, guardError.Reason, "more than 24 objects")
+				// the count is checked before anything is deleted
+				for _, object := range objects {
+					assert.True(t, s3Fixture.ObjectExists(bucket, aws.ToString(object.Key)))
+				}
+				assert.Empty(t, auditLog.String())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, len(objects), resp.Deleted)
+			s3Fixture.AssertPrefixEmpty(bucket, prefix)
+
+			auditEvents := strings.Split(strings.TrimSpace(auditLog.String()), "\n")
+			require.Len(t, auditEvents, 3)
+			deleted := 0
+			for _, line := range auditEvents {
+				var event struct {
+					AuditEvent string `json:"auditEvent"`
+					Bucket     string `json:"bucket"`
+					Prefix     string `json:"prefix"`
+					Deleted    int    `json:"deleted"`
+				}
+				require.NoError(t, json.Unmarshal([]byte(line), &event))
+				assert.Equal(t, "DeleteObjects", event.AuditEvent)
+				assert.Equal(t, bucket, event.Bucket)
+				assert.Equal(t, prefix, event.Prefix)
+				deleted += event.Deleted
+			}
+			assert.Equal(t, len(objects), deleted)
+		})
+	}
+
+	t.Run("versioned", func(t *testing.T) {
+		versionedBucket := "cleaner-guard-versioned-test-bucket"
+		s3Fixture := test.NewS3Fixture(t, s3Client, &s3.CreateBucketInput{
+			Bucket: aws.String(versionedBucket),
+		}).WithVersioning(versionedBucket)
+		defer s3Fixture.Teardown()
+		// three versions of each key, and a delete marker on top of some of them, are still 25 keys
+		for i := 0; i < 3; i++ {
+			s3Fixture.WithObjects(test.GeneratePutObjectInputs(versionedBucket, prefix, len(objects))...)
+		}
+		for _, input := range test.GeneratePutObjectInputs(versionedBucket, prefix, 5) {
+			_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: input.Bucket, Key: input.Key})
+			require.NoError(t, err)
+		}
+		cleaner, err := NewCleaner(s3Client, 10)
+		require.NoError(t, err)
+		cleaner.SetGuard(NewGuard([]string{versionedBucket}, "", 0, slog.Default()))
+
+		_, err = cleaner.Clean(ctx, versionedBucket, prefix, CleanOptions{MaxObjects: len(objects) - 1})
+		var guardError *GuardError
+		require.ErrorAs(t, err, &guardError)
+		assert.Len(t, s3Fixture.ListObjectVersions(versionedBucket, aws.String(prefix)).Versions, 3*len(objects))
+
+		resp, err := cleaner.Clean(ctx, versionedBucket, prefix, CleanOptions{MaxObjects: len(objects)})
+		require.NoError(t, err)
+		assert.Equal(t, 3*len(objects)+5, resp.Deleted)
+		s3Fixture.AssertPrefixEmpty(versionedBucket, prefix)
+	})
+
+	t.Run("refused location", func(t *testing.T) {
+		cleaner, err := NewCleaner(s3Client, 10)
+		require.NoError(t, err)
+		cleaner.SetGuard(NewGuard([]string{bucket}, "", 0, slog.Default()))
+		for _, location := range [][2]string{{"some-other-bucket", prefix}, {bucket, "43/"}} {
+			_, err := cleaner.Clean(ctx, location[0], location[1], CleanOptions{})
+			var guardError *GuardError
+			assert.ErrorAs(t, err, &guardError)
+		}
+	})
+}
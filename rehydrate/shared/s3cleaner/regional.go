@@ -0,0 +1,50 @@
+package s3cleaner
+
+import (
+	"context"
+	"fmt"
+	"github.com/pennsieve/rehydration-service/shared/regions"
+	"sync"
+)
+
+// RegionalCleaner is a Cleaner for rehydration buckets in more than one region. It cleans each bucket with a Cleaner
+// created for that bucket's region, so that requests go to the right regional endpoint.
+type RegionalCleaner struct {
+	buckets    *regions.Buckets
+	newCleaner func(region string) (Cleaner, error)
+	mu         sync.Mutex
+	cleaners   map[string]Cleaner
+}
+
+// NewRegionalCleaner returns a RegionalCleaner that looks up the region of a bucket in buckets and calls newCleaner
+// the first time it needs a Cleaner for that region. Buckets that are not in buckets are assumed to be in the default
+// region.
+func NewRegionalCleaner(buckets *regions.Buckets, newCleaner func(region string) (Cleaner, error)) *RegionalCleaner {
+	return &RegionalCleaner{
+		buckets:    buckets,
+		newCleaner: newCleaner,
+		cleaners:   map[string]Cleaner{},
+	}
+}
+
+func (c *RegionalCleaner) Clean(ctx context.Context, bucket string, keyPrefix string, options CleanOptions) (*CleanResponse, error) {
+	cleaner, err := c.cleaner(c.buckets.Region(bucket))
+	if err != nil {
+		return nil, err
+	}
+	return cleaner.Clean(ctx, bucket, keyPrefix, options)
+}
+
+func (c *RegionalCleaner) cleaner(region string) (Cleaner, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cleaner, ok := c.cleaners[region]; ok {
+		return cleaner, nil
+	}
+	cleaner, err := c.newCleaner(region)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cleaner for region %s: %w", region, err)
+	}
+	c.cleaners[region] = cleaner
+	return cleaner, nil
+}
@@ -0,0 +1,54 @@
+package s3cleaner
+
+import (
+	"context"
+	"errors"
+	"github.com/pennsieve/rehydration-service/shared/regions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type regionRecordingCleaner struct {
+	region  string
+	cleaned *[]string
+}
+
+func (c regionRecordingCleaner) Clean(_ context.Context, bucket string, keyPrefix string, _ CleanOptions) (*CleanResponse, error) {
+	*c.cleaned = append(*c.cleaned, c.region+":"+bucket+"/"+keyPrefix)
+	return &CleanResponse{}, nil
+}
+
+func TestRegionalCleaner_Clean(t *testing.T) {
+	buckets := regions.NewBuckets("us-east-1", map[string]string{"eu-west-1": "rehydration-eu"})
+	var cleaned []string
+	created := map[string]int{}
+	cleaner := NewRegionalCleaner(buckets, func(region string) (Cleaner, error) {
+		created[region]++
+		return regionRecordingCleaner{region: region, cleaned: &cleaned}, nil
+	})
+
+	ctx := context.Background()
+	for _, bucket := range []string{"rehydration-eu", "rehydration-default", "rehydration-eu"} {
+		_, err := cleaner.Clean(ctx, bucket, "1/2/", CleanOptions{})
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{
+		"eu-west-1:rehydration-eu/1/2/",
+		"us-east-1:rehydration-default/1/2/",
+		"eu-west-1:rehydration-eu/1/2/",
+	}, cleaned)
+	// one Cleaner per region
+	assert.Equal(t, map[string]int{"eu-west-1": 1, "us-east-1": 1}, created)
+}
+
+func TestRegionalCleaner_CleanerError(t *testing.T) {
+	buckets := regions.NewBuckets("us-east-1", nil)
+	cleaner := NewRegionalCleaner(buckets, func(region string) (Cleaner, error) {
+		return nil, errors.New("no credentials")
+	})
+	_, err := cleaner.Clean(context.Background(), "rehydration-default", "1/2/", CleanOptions{})
+	assert.ErrorContains(t, err, "us-east-1")
+	assert.ErrorContains(t, err, "no credentials")
+}
@@ -2,31 +2,100 @@ package s3cleaner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"strings"
+	"sync"
+	"time"
 )
 
+// RetryPolicy decides how S3Cleaner retries the keys that DeleteObjects reports it could not delete
+type RetryPolicy struct {
+	Attempts int
+	// Backoff is the wait before the first retry. The wait doubles before each later retry.
+	Backoff time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, Backoff: time.Second}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	return p.Backoff * time.Duration(1<<(attempt-1))
+}
+
+// DefaultDeleteConcurrency is the number of DeleteObjects calls S3Cleaner has in flight at once
+const DefaultDeleteConcurrency = 4
+
+// CleanProgress is how far a Clean has got. Listed and Deleted only grow, and Listed is final once Listing is false.
+type CleanProgress struct {
+	Bucket    string
+	KeyPrefix string
+	Listing   bool
+	Listed    int
+	Deleted   int
+	Failed    int
+}
+
+// ProgressFunc is called by S3Cleaner after each batch of deletes, and once more when a Clean finishes without error.
+// Calls are never concurrent.
+type ProgressFunc func(progress CleanProgress)
+
 type S3Cleaner struct {
-	client    *s3.Client
-	batchSize int32
+	client      *s3.Client
+	batchSize   int32
+	concurrency int
+	retryPolicy RetryPolicy
+	progress    ProgressFunc
+	// guard may be nil if Clean should delete wherever it is told to
+	guard *Guard
+	// versionedBuckets caches whether each bucket Clean has seen is versioned
+	versionedBuckets sync.Map
 }
 
 // NewCleaner creates a new Cleaner to delete "folders" in the given bucket.
 // Deletes are done in batches of the given batchSize. It is an error if batchSize <= 0 or > MaxCleanBatch
-func NewCleaner(client *s3.Client, batchSize int32) (Cleaner, error) {
+// Keys that fail to delete are retried according to DefaultRetryPolicy.
+func NewCleaner(client *s3.Client, batchSize int32) (*S3Cleaner, error) {
 	if batchSize <= 0 || batchSize > MaxCleanBatch {
 		return nil, fmt.Errorf("illegal argument: batchSize %d is out of range (0, %d]", batchSize, MaxCleanBatch)
 	}
 	return &S3Cleaner{
-		client:    client,
-		batchSize: batchSize,
+		client:      client,
+		batchSize:   batchSize,
+		concurrency: DefaultDeleteConcurrency,
+		retryPolicy: DefaultRetryPolicy,
 	}, nil
 }
 
-func (c *S3Cleaner) Clean(ctx context.Context, bucket string, keyPrefix string) (*CleanResponse, error) {
+// SetProgress sets a function to be told how each Clean is going. progress may be nil.
+func (c *S3Cleaner) SetProgress(progress ProgressFunc) {
+	c.progress = progress
+}
+
+// SetGuard makes Clean refuse to delete anything guard does not allow, and audit every batch it deletes. guard may be
+// nil.
+func (c *S3Cleaner) SetGuard(guard *Guard) {
+	c.guard = guard
+}
+
+// Clean deletes each page of keys as soon as it is listed, so that no more than a few pages are held in memory however
+// many objects are under keyPrefix. Up to concurrency pages are deleted at once while listing continues. Deleting
+// listed objects does not disturb the continuation token of the listing.
+//
+// The limit is options.MaxObjects if it is positive, or else that of the guard, if any. If there is a limit, Clean first lists
+// keyPrefix without deleting anything, and returns a *GuardError with everything still in place if it finds more keys
+// than the limit. The limit counts keys rather than versions, so the versions and delete markers of a key in a
+// versioned bucket count once between them.
+//
+// If the bucket has versioning enabled or suspended, deleting a key would only add a delete marker and keep the data,
+// so Clean lists and permanently deletes every object version and delete marker under keyPrefix instead. Count and
+// Deleted are then numbers of versions and delete markers. Clean fails without deleting anything if it cannot read
+// the bucket's versioning. options.ModifiedBefore is compared with the LastModified of each version.
+//
+// options.BeforeBatch is called by the delete workers, so it may be called concurrently.
+func (c *S3Cleaner) Clean(ctx context.Context, bucket string, keyPrefix string, options CleanOptions) (*CleanResponse, error) {
 	if len(bucket) == 0 {
 		return nil, fmt.Errorf("illegal argument: bucket cannot be empty")
 	}
@@ -36,66 +105,302 @@ func (c *S3Cleaner) Clean(ctx context.Context, bucket string, keyPrefix string)
 	if !strings.HasSuffix(keyPrefix, "/") {
 		return nil, fmt.Errorf("illegal argument: keyPrefix must end in '/': %s", keyPrefix)
 	}
-	var batchedObjectIdentifiers [][]types.ObjectIdentifier
-	bucketParam := aws.String(bucket)
+	if c.guard != nil {
+		if err := c.guard.Check(bucket, keyPrefix); err != nil {
+			return nil, err
+		}
+	}
+	versioned, err := c.versioned(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	var list lister = c.listObjects
+	if versioned {
+		list = c.listVersions
+	}
+	maxObjects := options.MaxObjects
+	if maxObjects <= 0 && c.guard != nil {
+		maxObjects = c.guard.maxObjects
+	}
+	if maxObjects > 0 {
+		if err := c.checkCount(ctx, bucket, keyPrefix, list, options, maxObjects); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	state := &cleanState{
+		progress: c.progress,
+		current:  CleanProgress{Bucket: bucket, KeyPrefix: keyPrefix, Listing: true},
+	}
+	batches := make(chan []types.ObjectIdentifier, c.concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < c.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				// after a failed delete the rest of the batches are drained without being deleted
+				if ctx.Err() != nil {
+					continue
+				}
+				if options.BeforeBatch != nil {
+					if err := options.BeforeBatch(ctx); err != nil {
+						state.deleteFailed(fmt.Errorf("stopped before deleting batch: %w", err))
+						cancel()
+						continue
+					}
+				}
+				batchErrors, err := c.deleteWithRetries(ctx, bucket, batch)
+				if c.guard != nil {
+					c.guard.audit(bucket, keyPrefix, batch, batchErrors, err)
+				}
+				if err != nil {
+					state.deleteFailed(err)
+					cancel()
+					continue
+				}
+				state.batchDeleted(len(batch), batchErrors)
+			}
+		}()
+	}
+	listErr := list(ctx, bucket, keyPrefix, options, func(page []types.ObjectIdentifier, _ int, more bool) error {
+		state.listed(len(page), more)
+		if len(page) == 0 {
+			return nil
+		}
+		select {
+		case batches <- page:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(batches)
+	wg.Wait()
+
+	if state.deleteErr != nil {
+		msg := fmt.Sprintf("error deleting objects from bucket %s under prefix %s", bucket, keyPrefix)
+		if state.current.Deleted > 0 {
+			msg = fmt.Sprintf("%s (%d objects already deleted)", msg, state.current.Deleted)
+		}
+		return nil, fmt.Errorf("%s: %w", msg, state.deleteErr)
+	}
+	if listErr != nil {
+		return nil, listErr
+	}
+	state.report()
+	return &CleanResponse{
+		Count:   state.current.Listed,
+		Deleted: state.current.Deleted,
+		Errors:  state.errors,
+	}, nil
+}
+
+// errCountOver stops the listing of a Clean that has found more keys than its limit
+var errCountOver = errors.New("count is over the limit")
+
+// checkCount lists keyPrefix with list and returns a *GuardError if there are more than maxObjects keys under it
+func (c *S3Cleaner) checkCount(ctx context.Context, bucket string, keyPrefix string, list lister, options CleanOptions, maxObjects int) error {
+	count := 0
+	err := list(ctx, bucket, keyPrefix, options, func(_ []types.ObjectIdentifier, keys int, _ bool) error {
+		if count += keys; count > maxObjects {
+			return errCountOver
+		}
+		return nil
+	})
+	if errors.Is(err, errCountOver) {
+		return &GuardError{
+			Bucket:    bucket,
+			KeyPrefix: keyPrefix,
+			Reason:    fmt.Sprintf("more than %d objects under prefix", maxObjects),
+		}
+	}
+	return err
+}
+
+// versioned returns true if bucket's versioning is enabled or suspended. It is an error if the versioning
+// configuration cannot be read, for example because a requester's role lacks s3:GetBucketVersioning, since deleting
+// keys from a versioned bucket would keep their data. Only answers that were read are cached.
+func (c *S3Cleaner) versioned(ctx context.Context, bucket string) (bool, error) {
+	if versioned, ok := c.versionedBuckets.Load(bucket); ok {
+		return versioned.(bool), nil
+	}
+	out, err := c.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return false, fmt.Errorf("error getting versioning of bucket %s: %w", bucket, err)
+	}
+	// a bucket that has never had versioning enabled has no status
+	versioned := len(out.Status) > 0
+	c.versionedBuckets.Store(bucket, versioned)
+	return versioned, nil
+}
+
+// pageFunc is called by a lister with each page of objects it lists, which may be empty, the number of keys in the page
+// that were not in an earlier page, and whether more pages follow. Listing stops if it returns an error.
+type pageFunc func(page []types.ObjectIdentifier, keys int, more bool) error
 
+// lister lists the objects under keyPrefix that options allows
+type lister func(ctx context.Context, bucket string, keyPrefix string, options CleanOptions, onPage pageFunc) error
+
+// listObjects passes each page of keys under keyPrefix to onPage
+func (c *S3Cleaner) listObjects(ctx context.Context, bucket string, keyPrefix string, options CleanOptions, onPage pageFunc) error {
 	listInput := &s3.ListObjectsV2Input{
-		Bucket:       bucketParam,
+		Bucket:       aws.String(bucket),
 		Prefix:       aws.String(keyPrefix),
 		MaxKeys:      aws.Int32(c.batchSize),
 		RequestPayer: types.RequestPayerRequester,
 	}
-	count := 0
 	var continuationToken *string
 	for hasNextPage := true; hasNextPage; hasNextPage = continuationToken != nil {
 		listInput.ContinuationToken = continuationToken
 		listOut, err := c.client.ListObjectsV2(ctx, listInput)
 		if err != nil {
-			return nil, fmt.Errorf("error listing objects from bucket %s under prefix %s: %w", bucket, keyPrefix, err)
+			return fmt.Errorf("error listing objects from bucket %s under prefix %s: %w", bucket, keyPrefix, err)
 		}
 		continuationToken = listOut.NextContinuationToken
-		countInPage := len(listOut.Contents)
-		count += countInPage
-		if countInPage > 0 {
-			batch := make([]types.ObjectIdentifier, countInPage)
-			for i := 0; i < countInPage; i++ {
-				batch[i] = types.ObjectIdentifier{
-					Key: listOut.Contents[i].Key,
-				}
+		page := make([]types.ObjectIdentifier, 0, len(listOut.Contents))
+		for _, object := range listOut.Contents {
+			if options.modified(object.LastModified) {
+				page = append(page, types.ObjectIdentifier{Key: object.Key})
 			}
-			batchedObjectIdentifiers = append(batchedObjectIdentifiers, batch)
 		}
-		// Can we call deleteObjects in this loop to avoid holding onto the full list of ObjectIdentifiers?
-		// Concern, is that deleting objects under the prefix mid-listing will somehow invalidate the continuationToken
-		// for the next call to ListObjectsV2. Couldn't find mention of the issue one way or the other. But may be worth
-		// experimenting with if there are memory issues.
-	}
-	response := &CleanResponse{
-		Count: count,
+		if err := onPage(page, len(page), continuationToken != nil); err != nil {
+			return err
+		}
 	}
-	deleteIn := &s3.DeleteObjectsInput{
-		Bucket:       bucketParam,
+	return nil
+}
+
+// listVersions passes each page of object versions and delete markers under keyPrefix to onPage
+func (c *S3Cleaner) listVersions(ctx context.Context, bucket string, keyPrefix string, options CleanOptions, onPage pageFunc) error {
+	listInput := &s3.ListObjectVersionsInput{
+		Bucket:       aws.String(bucket),
+		Prefix:       aws.String(keyPrefix),
+		MaxKeys:      aws.Int32(c.batchSize),
 		RequestPayer: types.RequestPayerRequester,
 	}
-	deleted := 0
-	for _, batch := range batchedObjectIdentifiers {
-		deleteIn.Delete = &types.Delete{
-			Objects: batch,
-			Quiet:   aws.Bool(true),
-		}
-		deleteOut, err := c.client.DeleteObjects(ctx, deleteIn)
+	// versions are listed in key order, so a key can only carry over from one page to the next as the page's last key
+	var lastKey string
+	for isTruncated := true; isTruncated; {
+		listOut, err := c.client.ListObjectVersions(ctx, listInput)
 		if err != nil {
-			msg := fmt.Sprintf("error deleting objects from bucket %s under prefix %s", bucket, keyPrefix)
-			if deleted > 0 {
-				msg = fmt.Sprintf("%s (%d objects already deleted)", msg, deleted)
+			return fmt.Errorf("error listing object versions from bucket %s under prefix %s: %w", bucket, keyPrefix, err)
+		}
+		isTruncated = aws.ToBool(listOut.IsTruncated)
+		listInput.KeyMarker = listOut.NextKeyMarker
+		listInput.VersionIdMarker = listOut.NextVersionIdMarker
+		page := make([]types.ObjectIdentifier, 0, len(listOut.Versions)+len(listOut.DeleteMarkers))
+		for _, version := range listOut.Versions {
+			if options.modified(version.LastModified) {
+				page = append(page, types.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
 			}
-			return nil, fmt.Errorf("%s: %w", msg, err)
 		}
-		batchErrors := deleteOut.Errors
-		deleted += len(batch) - len(batchErrors)
-		response.Errors = append(response.Errors, fromAWSErrors(batchErrors)...)
+		for _, deleteMarker := range listOut.DeleteMarkers {
+			if options.modified(deleteMarker.LastModified) {
+				page = append(page, types.ObjectIdentifier{Key: deleteMarker.Key, VersionId: deleteMarker.VersionId})
+			}
+		}
+		keys := map[string]bool{}
+		pageLastKey := lastKey
+		for _, object := range page {
+			key := aws.ToString(object.Key)
+			keys[key] = true
+			pageLastKey = max(pageLastKey, key)
+		}
+		delete(keys, lastKey)
+		lastKey = pageLastKey
+		if err := onPage(page, len(keys), isTruncated); err != nil {
+			return err
+		}
 	}
-	response.Deleted = deleted
+	return nil
+}
 
-	return response, nil
+// cleanState is shared by the lister and delete workers of a Clean
+type cleanState struct {
+	mu        sync.Mutex
+	progress  ProgressFunc
+	current   CleanProgress
+	errors    []DeleteObjectError
+	deleteErr error
+}
+
+func (s *cleanState) listed(count int, more bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current.Listed += count
+	s.current.Listing = more
+}
+
+func (s *cleanState) batchDeleted(batchSize int, batchErrors []types.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current.Deleted += batchSize - len(batchErrors)
+	s.current.Failed += len(batchErrors)
+	s.errors = append(s.errors, fromAWSErrors(batchErrors)...)
+	s.reportLocked()
+}
+
+func (s *cleanState) report() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reportLocked()
+}
+
+func (s *cleanState) reportLocked() {
+	if s.progress != nil {
+		s.progress(s.current)
+	}
+}
+
+func (s *cleanState) deleteFailed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.deleteErr == nil {
+		s.deleteErr = err
+	}
+}
+
+// deleteWithRetries deletes batch, then retries the keys that could not be deleted according to retryPolicy. Returns
+// the errors for the keys that were still not deleted. A retry that fails outright, or ctx being done, ends the retries
+// early.
+func (c *S3Cleaner) deleteWithRetries(ctx context.Context, bucket string, batch []types.ObjectIdentifier) ([]types.Error, error) {
+	batchErrors, err := c.deleteObjects(ctx, bucket, batch)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; len(batchErrors) > 0 && attempt <= c.retryPolicy.Attempts; attempt++ {
+		select {
+		case <-ctx.Done():
+			return batchErrors, nil
+		case <-time.After(c.retryPolicy.backoff(attempt)):
+		}
+		failed := make([]types.ObjectIdentifier, len(batchErrors))
+		for i, batchError := range batchErrors {
+			failed[i] = types.ObjectIdentifier{Key: batchError.Key, VersionId: batchError.VersionId}
+		}
+		retryErrors, err := c.deleteObjects(ctx, bucket, failed)
+		if err != nil {
+			return batchErrors, nil
+		}
+		batchErrors = retryErrors
+	}
+	return batchErrors, nil
+}
+
+func (c *S3Cleaner) deleteObjects(ctx context.Context, bucket string, objects []types.ObjectIdentifier) ([]types.Error, error) {
+	deleteOut, err := c.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &types.Delete{
+			Objects: objects,
+			Quiet:   aws.Bool(true),
+		},
+		RequestPayer: types.RequestPayerRequester,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleteOut.Errors, nil
 }
@@ -2,12 +2,19 @@ package s3cleaner
 
 import (
 	"context"
+	"errors"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
 	"github.com/pennsieve/rehydration-service/shared/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestS3Cleaner_Clean(t *testing.T) {
@@ -44,7 +51,7 @@ func TestS3Cleaner_Clean(t *testing.T) {
 
 			cleaner, err := NewCleaner(s3Client, int32(cleanBatchSize))
 			require.NoError(t, err)
-			resp, err := cleaner.Clean(ctx, bucket, prefixToClean)
+			resp, err := cleaner.Clean(ctx, bucket, prefixToClean, CleanOptions{})
 			require.NoError(t, err)
 			assert.Empty(t, resp.Errors)
 			assert.Equal(t, len(objectsToClean), resp.Deleted)
@@ -96,8 +103,333 @@ func TestS3Cleaner_Clean_IllegalArgs(t *testing.T) {
 		{"prefix does not end in slash", testBucketName, "12/23", MaxCleanBatch, "'/'"},
 	} {
 		t.Run(tst.name, func(t *testing.T) {
-			_, err := cleaner.Clean(ctx, tst.bucket, tst.keyPrefix)
+			_, err := cleaner.Clean(ctx, tst.bucket, tst.keyPrefix, CleanOptions{})
 			assert.ErrorContains(t, err, tst.expectedInErr)
 		})
 	}
 }
+
+func TestS3Cleaner_Clean_Retries(t *testing.T) {
+	bucket := "cleaner-retry-test-bucket"
+	prefix := "43/1/"
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	objects := test.GeneratePutObjectInputs(bucket, prefix, 5)
+	flakyKey := aws.ToString(objects[1].Key)
+	brokenKey := aws.ToString(objects[3].Key)
+
+	for _, tst := range []struct {
+		name            string
+		failures        map[string]int
+		expectedDeleted int
+		expectedErrKeys []string
+	}{
+		{name: "recovers", failures: map[string]int{flakyKey: 2}, expectedDeleted: 5},
+		{name: "gives up", failures: map[string]int{flakyKey: 1, brokenKey: 10}, expectedDeleted: 4, expectedErrKeys: []string{brokenKey}},
+	} {
+		t.Run(tst.name, func(t *testing.T) {
+			s3Fixture, _ := test.NewS3Fixture(t, s3.NewFromConfig(awsConfig), &s3.CreateBucketInput{
+				Bucket: aws.String(bucket),
+			}).WithObjects(objects...)
+			defer s3Fixture.Teardown()
+
+			flakyClient := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+				o.APIOptions = append(o.APIOptions, failDeletes(tst.failures))
+			})
+			cleaner, err := NewCleaner(flakyClient, MaxCleanBatch)
+			require.NoError(t, err)
+			cleaner.retryPolicy = RetryPolicy{Attempts: 2, Backoff: time.Millisecond}
+
+			resp, err := cleaner.Clean(ctx, bucket, prefix, CleanOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, len(objects), resp.Count)
+			assert.Equal(t, tst.expectedDeleted, resp.Deleted)
+			var errKeys []string
+			for _, deleteErr := range resp.Errors {
+				errKeys = append(errKeys, deleteErr.Key)
+			}
+			assert.Equal(t, tst.expectedErrKeys, errKeys)
+		})
+	}
+}
+
+func TestS3Cleaner_Clean_Streaming(t *testing.T) {
+	bucket := "cleaner-streaming-test-bucket"
+	prefixToClean := "43/1/"
+	prefixToKeep := "43/11/"
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	objectsToClean := test.GeneratePutObjectInputs(bucket, prefixToClean, 250)
+	objectsToKeep := test.GeneratePutObjectInputs(bucket, prefixToKeep, 10)
+
+	s3Fixture, _ := test.NewS3Fixture(t, s3.NewFromConfig(awsConfig), &s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	}).WithObjects(append(objectsToClean, objectsToKeep...)...)
+	defer s3Fixture.Teardown()
+
+	calls := &operationLog{}
+	loggingClient := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, calls.record)
+	})
+	// 25 pages are more than the lister can get ahead of the delete workers, so it must list while pages are deleted
+	cleaner, err := NewCleaner(loggingClient, 10)
+	require.NoError(t, err)
+	var progress []CleanProgress
+	cleaner.SetProgress(func(p CleanProgress) {
+		progress = append(progress, p)
+	})
+
+	resp, err := cleaner.Clean(ctx, bucket, prefixToClean, CleanOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Errors)
+	assert.Equal(t, len(objectsToClean), resp.Count)
+	assert.Equal(t, len(objectsToClean), resp.Deleted)
+
+	s3Fixture.AssertPrefixEmpty(bucket, prefixToClean)
+	for _, expectedKept := range objectsToKeep {
+		assert.True(t, s3Fixture.ObjectExists(bucket, aws.ToString(expectedKept.Key)))
+	}
+
+	firstDelete, lastList := calls.first("DeleteObjects"), calls.last("ListObjectsV2")
+	assert.GreaterOrEqual(t, calls.count("ListObjectsV2"), 25)
+	assert.Less(t, firstDelete, lastList, "expected deletes to start before listing finished")
+
+	// a report for each page and a final one
+	require.Len(t, progress, 26)
+	for i, p := range progress[:25] {
+		assert.Equal(t, bucket, p.Bucket)
+		assert.Equal(t, prefixToClean, p.KeyPrefix)
+		assert.Equal(t, (i+1)*10, p.Deleted)
+		assert.GreaterOrEqual(t, p.Listed, p.Deleted)
+		assert.Zero(t, p.Failed)
+	}
+	final := progress[25]
+	assert.False(t, final.Listing)
+	assert.Equal(t, len(objectsToClean), final.Listed)
+	assert.Equal(t, len(objectsToClean), final.Deleted)
+}
+
+func TestS3Cleaner_Clean_Versioned(t *testing.T) {
+	bucket := "cleaner-versioned-test-bucket"
+	prefixToClean := "43/1/"
+	prefixToKeep := "43/11/"
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	s3Client := s3.NewFromConfig(awsConfig)
+
+	s3Fixture := test.NewS3Fixture(t, s3Client, &s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	}).WithVersioning(bucket)
+	defer s3Fixture.Teardown()
+	// two versions of each object to clean, then a delete marker on top of some of them
+	s3Fixture.WithObjects(test.GeneratePutObjectInputs(bucket, prefixToClean, 20)...)
+	s3Fixture.WithObjects(test.GeneratePutObjectInputs(bucket, prefixToClean, 20)...)
+	objectsToKeep := test.GeneratePutObjectInputs(bucket, prefixToKeep, 10)
+	s3Fixture.WithObjects(objectsToKeep...)
+	deleteMarkerCount := 5
+	for _, input := range test.GeneratePutObjectInputs(bucket, prefixToClean, deleteMarkerCount) {
+		_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: input.Bucket, Key: input.Key})
+		require.NoError(t, err)
+	}
+	expectedCount := 2*20 + deleteMarkerCount
+
+	// a small batch size so that versions of the same key are split across pages
+	cleaner, err := NewCleaner(s3Client, 7)
+	require.NoError(t, err)
+	resp, err := cleaner.Clean(ctx, bucket, prefixToClean, CleanOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Errors)
+	assert.Equal(t, expectedCount, resp.Count)
+	assert.Equal(t, expectedCount, resp.Deleted)
+
+	// no versions or delete markers should be left
+	s3Fixture.AssertPrefixEmpty(bucket, prefixToClean)
+	for _, expectedKept := range objectsToKeep {
+		assert.True(t, s3Fixture.ObjectExists(bucket, aws.ToString(expectedKept.Key)))
+	}
+	keptVersions := s3Fixture.ListObjectVersions(bucket, aws.String(prefixToKeep))
+	assert.Len(t, keptVersions.Versions, len(objectsToKeep))
+	assert.Empty(t, keptVersions.DeleteMarkers)
+}
+
+func TestS3Cleaner_Clean_VersioningUnreadable(t *testing.T) {
+	bucket := "cleaner-versioning-unreadable-test-bucket"
+	prefix := "43/1/"
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	objects := test.GeneratePutObjectInputs(bucket, prefix, 5)
+
+	s3Fixture, _ := test.NewS3Fixture(t, s3.NewFromConfig(awsConfig), &s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	}).WithObjects(objects...)
+	defer s3Fixture.Teardown()
+
+	calls := &operationLog{}
+	failures := 1
+	failingClient := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, calls.record, failGetBucketVersioning(&failures))
+	})
+	cleaner, err := NewCleaner(failingClient, MaxCleanBatch)
+	require.NoError(t, err)
+
+	_, err = cleaner.Clean(ctx, bucket, prefix, CleanOptions{})
+	require.ErrorContains(t, err, "AccessDenied")
+	assert.Zero(t, calls.count("ListObjectsV2"))
+	assert.Zero(t, calls.count("DeleteObjects"))
+	for _, object := range objects {
+		assert.True(t, s3Fixture.ObjectExists(bucket, aws.ToString(object.Key)))
+	}
+
+	// the failure was not cached, so the next Clean reads the versioning again
+	resp, err := cleaner.Clean(ctx, bucket, prefix, CleanOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, len(objects), resp.Deleted)
+	// the failed call never reached the log
+	assert.Equal(t, 1, calls.count("GetBucketVersioning"))
+	s3Fixture.AssertPrefixEmpty(bucket, prefix)
+}
+
+func TestS3Cleaner_Clean_Options(t *testing.T) {
+	bucket := "cleaner-options-test-bucket"
+	prefix := "43/1/"
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithMinIO().Config(ctx, false)
+	s3Client := s3.NewFromConfig(awsConfig)
+	objects := test.GeneratePutObjectInputs(bucket, prefix, 25)
+
+	for _, tst := range []struct {
+		name            string
+		options         CleanOptions
+		expectedErr     error
+		expectedDeleted int
+	}{
+		{name: "modified before", options: CleanOptions{ModifiedBefore: time.Now().Add(time.Hour)}, expectedDeleted: 25},
+		{name: "modified after", options: CleanOptions{ModifiedBefore: time.Now().Add(-time.Hour)}},
+		{name: "stopped before batch", options: CleanOptions{BeforeBatch: func(context.Context) error {
+			return errStopped
+		}}, expectedErr: errStopped},
+	} {
+		t.Run(tst.name, func(t *testing.T) {
+			s3Fixture, _ := test.NewS3Fixture(t, s3Client, &s3.CreateBucketInput{
+				Bucket: aws.String(bucket),
+			}).WithObjects(objects...)
+			defer s3Fixture.Teardown()
+
+			cleaner, err := NewCleaner(s3Client, 10)
+			require.NoError(t, err)
+			resp, err := cleaner.Clean(ctx, bucket, prefix, tst.options)
+			if tst.expectedErr != nil {
+				require.ErrorIs(t, err, tst.expectedErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tst.expectedDeleted, resp.Count)
+				assert.Equal(t, tst.expectedDeleted, resp.Deleted)
+			}
+			remaining := 0
+			for _, object := range objects {
+				if s3Fixture.ObjectExists(bucket, aws.ToString(object.Key)) {
+					remaining++
+				}
+			}
+			assert.Equal(t, len(objects)-tst.expectedDeleted, remaining)
+		})
+	}
+}
+
+var errStopped = errors.New("stopped")
+
+// operationLog records the names of the S3 operations a client calls, in order
+type operationLog struct {
+	mu         sync.Mutex
+	operations []string
+}
+
+func (l *operationLog) record(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("operationLog",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.operations = append(l.operations, awsmiddleware.GetOperationName(ctx))
+			return out, metadata, err
+		}), middleware.After)
+}
+
+func (l *operationLog) first(operation string) int {
+	for i, o := range l.operations {
+		if o == operation {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *operationLog) last(operation string) int {
+	for i := len(l.operations) - 1; i >= 0; i-- {
+		if l.operations[i] == operation {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *operationLog) count(operation string) int {
+	count := 0
+	for _, o := range l.operations {
+		if o == operation {
+			count++
+		}
+	}
+	return count
+}
+
+// failDeletes makes DeleteObjects report an error for each key in failures, without deleting it, for the given number
+// of calls
+func failDeletes(failures map[string]int) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("failDeletes",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				deleteIn, ok := in.Parameters.(*s3.DeleteObjectsInput)
+				if !ok {
+					return next.HandleInitialize(ctx, in)
+				}
+				var toDelete []types.ObjectIdentifier
+				var failed []types.Error
+				for _, object := range deleteIn.Delete.Objects {
+					key := aws.ToString(object.Key)
+					if failures[key] > 0 {
+						failures[key]--
+						failed = append(failed, types.Error{Key: object.Key, Code: aws.String("InternalError"), Message: aws.String("please try again")})
+					} else {
+						toDelete = append(toDelete, object)
+					}
+				}
+				out := middleware.InitializeOutput{Result: &s3.DeleteObjectsOutput{}}
+				var metadata middleware.Metadata
+				if len(toDelete) > 0 {
+					deleteIn.Delete.Objects = toDelete
+					var err error
+					if out, metadata, err = next.HandleInitialize(ctx, in); err != nil {
+						return out, metadata, err
+					}
+				}
+				deleteOut := out.Result.(*s3.DeleteObjectsOutput)
+				deleteOut.Errors = append(deleteOut.Errors, failed...)
+				return out, metadata, nil
+			}), middleware.Before)
+	}
+}
+
+// failGetBucketVersioning makes GetBucketVersioning fail with AccessDenied until failures reaches 0
+func failGetBucketVersioning(failures *int) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("failGetBucketVersioning",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				if _, ok := in.Parameters.(*s3.GetBucketVersioningInput); !ok || *failures <= 0 {
+					return next.HandleInitialize(ctx, in)
+				}
+				*failures--
+				return middleware.InitializeOutput{}, middleware.Metadata{}, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}
+			}), middleware.Before)
+	}
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pennsieve/rehydration-service/shared/audit"
+)
+
+func AuditCreateTableInput(tableName string) *dynamodb.CreateTableInput {
+	return &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String(audit.DatasetVersionAttrName),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String(audit.EventKeyAttrName),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String(audit.UserEmailAttrName),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String(audit.DatasetVersionAttrName),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String(audit.EventKeyAttrName),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName: aws.String(audit.UserEmailIndexName),
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String(audit.UserEmailAttrName), KeyType: types.KeyTypeHash},
+				{AttributeName: aws.String(audit.EventKeyAttrName), KeyType: types.KeyTypeRange},
+			},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/authentication"
 	"github.com/pennsieve/pennsieve-go/pkg/pennsieve/models/discover"
+	shareddiscover "github.com/pennsieve/rehydration-service/shared/discover"
 	"github.com/pennsieve/rehydration-service/shared/models"
 	"github.com/pennsieve/rehydration-service/shared/test"
 	"github.com/stretchr/testify/require"
@@ -59,6 +60,10 @@ func GetDatasetFileByVersionPath(dataset models.Dataset) string {
 	return fmt.Sprintf("/discover/datasets/%d/versions/%d/files", dataset.ID, dataset.VersionID)
 }
 
+func GetDatasetVersionPath(dataset models.Dataset) string {
+	return fmt.Sprintf("/discover/datasets/%d/versions/%d", dataset.ID, dataset.VersionID)
+}
+
 func GetDatasetMetadataByVersionPath(dataset models.Dataset) string {
 	return fmt.Sprintf("/discover/datasets/%d/versions/%d/metadata", dataset.ID, dataset.VersionID)
 }
@@ -76,6 +81,21 @@ func GetDatasetMetadataByVersionHandlerBuilder(dataset models.Dataset, expectedD
 	return test.NewHandlerFuncBuilder(pattern).WithModel(respModel)
 }
 
+// GetDatasetVersionHandlerBuilder returns a published version if status is empty.
+func GetDatasetVersionHandlerBuilder(dataset models.Dataset, status string, embargoAccess string) *test.HandlerFuncBuilder {
+	if len(status) == 0 {
+		status = shareddiscover.PublishSucceeded
+	}
+	respModel := shareddiscover.DatasetVersion{
+		ID:            dataset.ID,
+		Version:       dataset.VersionID,
+		Status:        status,
+		Embargo:       status == shareddiscover.EmbargoSucceeded,
+		EmbargoAccess: embargoAccess,
+	}
+	return test.NewHandlerFuncBuilder(GetDatasetVersionPath(dataset)).WithModel(respModel)
+}
+
 func GetDatasetFileByVersionHandlerBuilder(dataset models.Dataset, expectedBucket string, expectedDatasetFileByPath map[string]*TestDatasetFile) *test.HandlerFuncBuilder {
 	pattern := GetDatasetFileByVersionPath(dataset)
 	selectorFunc := func(r *http.Request) (int, any) {
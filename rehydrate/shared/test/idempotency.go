@@ -21,6 +21,15 @@ func IdempotencyCreateTableInput(tableName string) *dynamodb.CreateTableInput {
 			},
 			ProjectionType: types.ProjectionTypeInclude,
 		},
+	}, {
+		IndexName: aws.String(idempotency.StatusIndexName),
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(idempotency.StatusAttrName), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(idempotency.KeyAttrName), KeyType: types.KeyTypeRange},
+		},
+		Projection: &types.Projection{
+			ProjectionType: types.ProjectionTypeKeysOnly,
+		},
 	}}
 	return &dynamodb.CreateTableInput{
 		TableName: aws.String(tableName),
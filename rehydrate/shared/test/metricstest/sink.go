@@ -0,0 +1,153 @@
+package metricstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pennsieve/rehydration-service/shared/metrics"
+	"sync"
+	"testing"
+)
+
+// Metric is one metric parsed from an EMF record
+type Metric struct {
+	Namespace  string
+	Name       string
+	Unit       metrics.Unit
+	Dimensions metrics.Dimensions
+	Values     []float64
+}
+
+// Sink is a metrics.Sink that parses and keeps the EMF records written to it so that tests can assert on them.
+// Write returns an error for records that are not valid EMF.
+type Sink struct {
+	mu      sync.Mutex
+	metrics []Metric
+}
+
+func NewSink() *Sink {
+	return &Sink{}
+}
+
+// UseDefault sets a new Sink on metrics.Default for the duration of the test
+func UseDefault(t *testing.T) *Sink {
+	sink := NewSink()
+	previous := metrics.Default.Sink()
+	metrics.Default.SetSink(sink)
+	t.Cleanup(func() {
+		metrics.Default.SetSink(previous)
+	})
+	return sink
+}
+
+type record struct {
+	AWS *struct {
+		Timestamp         int64 `json:"Timestamp"`
+		CloudWatchMetrics []struct {
+			Namespace  string     `json:"Namespace"`
+			Dimensions [][]string `json:"Dimensions"`
+			Metrics    []struct {
+				Name string       `json:"Name"`
+				Unit metrics.Unit `json:"Unit"`
+			} `json:"Metrics"`
+		} `json:"CloudWatchMetrics"`
+	} `json:"_aws"`
+}
+
+func (s *Sink) Write(rawRecord []byte) error {
+	var parsed record
+	if err := json.Unmarshal(rawRecord, &parsed); err != nil {
+		return err
+	}
+	if parsed.AWS == nil || parsed.AWS.Timestamp == 0 || len(parsed.AWS.CloudWatchMetrics) == 0 {
+		return fmt.Errorf("missing EMF metadata: %s", rawRecord)
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(rawRecord, &members); err != nil {
+		return err
+	}
+	var parsedMetrics []Metric
+	for _, directive := range parsed.AWS.CloudWatchMetrics {
+		dimensions := metrics.Dimensions{}
+		for _, dimensionSet := range directive.Dimensions {
+			for _, dimensionName := range dimensionSet {
+				var value string
+				if err := json.Unmarshal(members[dimensionName], &value); err != nil {
+					return fmt.Errorf("dimension %s is not a string: %w", dimensionName, err)
+				}
+				dimensions[dimensionName] = value
+			}
+		}
+		for _, definition := range directive.Metrics {
+			values, err := parseValues(members[definition.Name])
+			if err != nil {
+				return fmt.Errorf("metric %s has no valid value: %w", definition.Name, err)
+			}
+			parsedMetrics = append(parsedMetrics, Metric{
+				Namespace:  directive.Namespace,
+				Name:       definition.Name,
+				Unit:       definition.Unit,
+				Dimensions: dimensions,
+				Values:     values,
+			})
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = append(s.metrics, parsedMetrics...)
+	return nil
+}
+
+func parseValues(raw json.RawMessage) ([]float64, error) {
+	var value float64
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return []float64{value}, nil
+	}
+	var values []float64
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, err
+	}
+	if len(values) > metrics.MaxValuesPerRecord {
+		return nil, fmt.Errorf("%d values is more than the maximum of %d", len(values), metrics.MaxValuesPerRecord)
+	}
+	return values, nil
+}
+
+// Metrics returns the metrics named name that have at least the given dimensions
+func (s *Sink) Metrics(name string, dimensions metrics.Dimensions) []Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matching []Metric
+	for _, metric := range s.metrics {
+		if metric.Name == name && hasDimensions(metric, dimensions) {
+			matching = append(matching, metric)
+		}
+	}
+	return matching
+}
+
+// Values returns all the values of the metrics named name that have at least the given dimensions
+func (s *Sink) Values(name string, dimensions metrics.Dimensions) []float64 {
+	var values []float64
+	for _, metric := range s.Metrics(name, dimensions) {
+		values = append(values, metric.Values...)
+	}
+	return values
+}
+
+// Sum adds up Values
+func (s *Sink) Sum(name string, dimensions metrics.Dimensions) float64 {
+	var sum float64
+	for _, value := range s.Values(name, dimensions) {
+		sum += value
+	}
+	return sum
+}
+
+func hasDimensions(metric Metric, dimensions metrics.Dimensions) bool {
+	for name, value := range dimensions {
+		if actual, ok := metric.Dimensions[name]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package otlptest
+
+import (
+	"compress/gzip"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Collector is a local OTLP/HTTP trace collector that keeps the spans exported to it so that tests can assert on them.
+type Collector struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	spans  []*tracev1.Span
+}
+
+// NewCollector starts a Collector that is closed when the test ends
+func NewCollector(t *testing.T) *Collector {
+	c := &Collector{}
+	c.server = httptest.NewServer(http.HandlerFunc(c.export))
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+// Setenv points the OTLP exporter at c for the duration of the test
+func (c *Collector) Setenv(t *testing.T) *Collector {
+	t.Setenv(tracing.EndpointKey, c.server.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_INSECURE", "true")
+	return c
+}
+
+func (c *Collector) export(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+	body := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	}
+	rawRequest, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request collectortrace.ExportTraceServiceRequest
+	if err := proto.Unmarshal(rawRequest, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	for _, resourceSpans := range request.GetResourceSpans() {
+		for _, scopeSpans := range resourceSpans.GetScopeSpans() {
+			c.spans = append(c.spans, scopeSpans.GetSpans()...)
+		}
+	}
+	c.mu.Unlock()
+
+	response, err := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(response)
+}
+
+// Spans returns the spans exported so far
+func (c *Collector) Spans() []*tracev1.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*tracev1.Span(nil), c.spans...)
+}
+
+// SpanNames returns the names of the spans exported so far
+func (c *Collector) SpanNames() []string {
+	var names []string
+	for _, span := range c.Spans() {
+		names = append(names, span.GetName())
+	}
+	return names
+}
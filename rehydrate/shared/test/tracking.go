@@ -23,6 +23,7 @@ func TrackingCreateTableInput(tableName string) *dynamodb.CreateTableInput {
 				tracking.UserNameAttrName,
 				tracking.UserEmailAttrName,
 				tracking.EmailSentDateAttrName,
+				tracking.DestinationAttrName,
 			},
 			ProjectionType: types.ProjectionTypeInclude,
 		},
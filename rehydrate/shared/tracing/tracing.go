@@ -0,0 +1,113 @@
+// Package tracing sets up OpenTelemetry tracing and carries trace context from the service Lambda to the rehydration
+// task through its environment.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/pennsieve/rehydration-service/shared"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+)
+
+// TracerName is the instrumentation scope of the spans created with Start
+const TracerName = "github.com/pennsieve/rehydration-service"
+
+// TraceParentKey and TraceStateKey are the environment variables that carry the W3C trace context headers of the
+// same names to the rehydration task
+const TraceParentKey = "TRACEPARENT"
+const TraceStateKey = "TRACESTATE"
+
+// EndpointKey and TracesEndpointKey are the standard OTLP exporter environment variables. If neither is set, spans
+// are not exported. Other OTEL_EXPORTER_OTLP_* variables, for example OTEL_EXPORTER_OTLP_HEADERS, are also read.
+const EndpointKey = "OTEL_EXPORTER_OTLP_ENDPOINT"
+const TracesEndpointKey = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+
+var propagator = propagation.TraceContext{}
+
+// Init sets the global TracerProvider and propagator. If an OTLP endpoint is configured, spans are exported to it over
+// HTTP. The returned shutdown function flushes any spans not yet exported and must be called before the process exits.
+func Init(ctx context.Context, serviceName string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagator)
+	if !exporterConfigured() {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error creating OTLP trace exporter: %w", err)
+	}
+	serviceResource, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("error creating trace resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(serviceResource))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func exporterConfigured() bool {
+	for _, key := range []string{EndpointKey, TracesEndpointKey} {
+		if value, set := os.LookupEnv(key); set && len(value) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush exports any finished spans still held by the global TracerProvider. A Lambda should call it before returning
+// since the environment may be frozen until the next invocation.
+func Flush(ctx context.Context) error {
+	if flusher, ok := otel.GetTracerProvider().(interface{ ForceFlush(context.Context) error }); ok {
+		return flusher.ForceFlush(ctx)
+	}
+	return nil
+}
+
+// Start starts a span using the global TracerProvider
+func Start(ctx context.Context, spanName string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, spanName, trace.WithAttributes(attributes...))
+}
+
+// End ends span, first recording err on it if err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Environment returns TraceParentKey and, if there is any trace state, TraceStateKey with the trace context of the span
+// in ctx. Returns an empty map if ctx has no valid span.
+func Environment(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	env := map[string]string{}
+	for header, key := range map[string]string{"traceparent": TraceParentKey, "tracestate": TraceStateKey} {
+		if value := carrier.Get(header); len(value) > 0 {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// ContextFromLookup returns a copy of ctx with the remote span context in TraceParentKey and TraceStateKey, if
+// lookup finds them. Spans started from the returned context are part of the trace that started the task.
+func ContextFromLookup(ctx context.Context, lookup shared.LookupFunc) context.Context {
+	carrier := propagation.MapCarrier{}
+	for header, key := range map[string]string{"traceparent": TraceParentKey, "tracestate": TraceStateKey} {
+		if value, ok := lookup(key); ok {
+			carrier.Set(header, value)
+		}
+	}
+	return propagator.Extract(ctx, carrier)
+}
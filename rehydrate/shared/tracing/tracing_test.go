@@ -0,0 +1,83 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"github.com/pennsieve/rehydration-service/shared/test/otlptest"
+	"github.com/pennsieve/rehydration-service/shared/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func TestInit_ExportsToCollector(t *testing.T) {
+	collector := otlptest.NewCollector(t).Setenv(t)
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	ctx := context.Background()
+	shutdown, err := tracing.Init(ctx, "tracing-test")
+	require.NoError(t, err)
+
+	parentCtx, parent := tracing.Start(ctx, "parent")
+	_, child := tracing.Start(parentCtx, "child")
+	tracing.End(child, errors.New("child failed"))
+	tracing.End(parent, nil)
+
+	require.NoError(t, tracing.Flush(ctx))
+	assert.ElementsMatch(t, []string{"parent", "child"}, collector.SpanNames())
+	require.NoError(t, shutdown(ctx))
+
+	for _, span := range collector.Spans() {
+		assert.Equal(t, parent.SpanContext().TraceID().String(), hex.EncodeToString(span.GetTraceId()))
+	}
+}
+
+func TestInit_NoEndpoint(t *testing.T) {
+	t.Setenv(tracing.EndpointKey, "")
+	t.Setenv(tracing.TracesEndpointKey, "")
+	shutdown, err := tracing.Init(context.Background(), "tracing-test")
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}
+
+func TestEnvironment_RoundTrip(t *testing.T) {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	traceState, err := trace.ParseTraceState("vendor=value")
+	require.NoError(t, err)
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		TraceState: traceState,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+
+	env := tracing.Environment(ctx)
+	assert.Equal(t, map[string]string{
+		tracing.TraceParentKey: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		tracing.TraceStateKey:  "vendor=value",
+	}, env)
+
+	lookup := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	remote := trace.SpanContextFromContext(tracing.ContextFromLookup(context.Background(), lookup))
+	assert.True(t, remote.IsRemote())
+	assert.Equal(t, traceID, remote.TraceID())
+	assert.Equal(t, spanID, remote.SpanID())
+	assert.Equal(t, "vendor=value", remote.TraceState().String())
+}
+
+func TestEnvironment_NoSpan(t *testing.T) {
+	assert.Empty(t, tracing.Environment(context.Background()))
+	noTrace := func(string) (string, bool) { return "", false }
+	assert.False(t, trace.SpanContextFromContext(tracing.ContextFromLookup(context.Background(), noTrace)).IsValid())
+}
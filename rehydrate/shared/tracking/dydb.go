@@ -8,6 +8,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
 	"github.com/pennsieve/rehydration-service/shared/models"
 	"log/slog"
 	"time"
@@ -31,7 +32,7 @@ func NewStore(client *dynamodb.Client, logger *slog.Logger, tableName string) St
 	}
 }
 
-func (s *DyDBStore) EmailSent(ctx context.Context, id string, emailSentDate *time.Time, status RehydrationStatus) error {
+func (s *DyDBStore) EmailSent(ctx context.Context, id string, emailSentDate *time.Time, status RehydrationStatus, usage *accounting.Usage) error {
 	updateBuilder := expression.Set(
 		expression.Name(EmailSentDateAttrName),
 		expression.Value(emailSentDate),
@@ -39,6 +40,9 @@ func (s *DyDBStore) EmailSent(ctx context.Context, id string, emailSentDate *tim
 		expression.Name(RehydrationStatusAttrName),
 		expression.Value(status),
 	)
+	if usage != nil {
+		updateBuilder = updateBuilder.Set(expression.Name(UsageAttrName), expression.Value(usage))
+	}
 	conditionBuilder := expression.AttributeNotExists(expression.Name(EmailSentDateAttrName))
 	emailSentExpression, err := expression.NewBuilder().WithUpdate(updateBuilder).WithCondition(conditionBuilder).Build()
 	if err != nil {
@@ -69,7 +73,7 @@ func (s *DyDBStore) EmailSent(ctx context.Context, id string, emailSentDate *tim
 	return nil
 }
 
-func (s *DyDBStore) QueryDatasetVersionIndexUnhandled(ctx context.Context, dataset models.Dataset, limit int32) ([]DatasetVersionIndex, error) {
+func (s *DyDBStore) QueryDatasetVersionIndexUnhandled(ctx context.Context, dataset models.Dataset, destination string, limit int32) ([]DatasetVersionIndex, error) {
 	var indexEntries []DatasetVersionIndex
 	var errs []error
 
@@ -77,7 +81,11 @@ func (s *DyDBStore) QueryDatasetVersionIndexUnhandled(ctx context.Context, datas
 		expression.Key(DatasetVersionAttrName).Equal(expression.Value(dataset.DatasetVersion())),
 		expression.Key(RehydrationStatusAttrName).Equal(expression.Value(InProgress)),
 	)
-	filterBuilder := expression.AttributeNotExists(expression.Name(EmailSentDateAttrName))
+	destinationFilter := expression.AttributeNotExists(expression.Name(DestinationAttrName))
+	if len(destination) > 0 {
+		destinationFilter = expression.Equal(expression.Name(DestinationAttrName), expression.Value(destination))
+	}
+	filterBuilder := expression.And(expression.AttributeNotExists(expression.Name(EmailSentDateAttrName)), destinationFilter)
 	queryExpression, err := expression.NewBuilder().WithKeyCondition(keyConditionBuilder).WithFilter(filterBuilder).Build()
 	if err != nil {
 		return nil, fmt.Errorf("error building QueryDatasetVersionIndexUnhandled expression: %w", err)
@@ -111,6 +119,45 @@ func (s *DyDBStore) QueryDatasetVersionIndexUnhandled(ctx context.Context, datas
 	return indexEntries, errors.Join(errs...)
 }
 
+// ScanUsage scans the whole table since there is no index on request date. It is only meant for infrequent reporting.
+// The date range is checked after unmarshalling because request dates are not stored in a sortable format.
+func (s *DyDBStore) ScanUsage(ctx context.Context, since, until time.Time) ([]Entry, error) {
+	var entries []Entry
+	var errs []error
+
+	filterBuilder := expression.AttributeExists(expression.Name(UsageAttrName))
+	scanExpression, err := expression.NewBuilder().WithFilter(filterBuilder).Build()
+	if err != nil {
+		return nil, fmt.Errorf("error building ScanUsage expression: %w", err)
+	}
+	scanIn := &dynamodb.ScanInput{
+		TableName:                 aws.String(s.table),
+		ExpressionAttributeNames:  scanExpression.Names(),
+		ExpressionAttributeValues: scanExpression.Values(),
+		FilterExpression:          scanExpression.Filter(),
+	}
+	var lastEvaluatedKey map[string]types.AttributeValue
+	for runScan := true; runScan; runScan = len(lastEvaluatedKey) != 0 {
+		scanIn.ExclusiveStartKey = lastEvaluatedKey
+		scanOut, err := s.client.Scan(ctx, scanIn)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning for usage: %w", err)
+		}
+		lastEvaluatedKey = scanOut.LastEvaluatedKey
+		for _, i := range scanOut.Items {
+			entry, err := FromItem(i)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if !entry.RequestDate.Before(since) && entry.RequestDate.Before(until) {
+				entries = append(entries, *entry)
+			}
+		}
+	}
+	return entries, errors.Join(errs...)
+}
+
 func (s *DyDBStore) PutEntry(ctx context.Context, entry *Entry) error {
 	item, err := entry.Item()
 	if err != nil {
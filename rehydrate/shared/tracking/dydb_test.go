@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/google/uuid"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
 	"github.com/pennsieve/rehydration-service/shared/logging"
 	"github.com/pennsieve/rehydration-service/shared/models"
 	"github.com/pennsieve/rehydration-service/shared/test"
@@ -85,7 +86,8 @@ func TestDyDBStore_EmailSent(t *testing.T) {
 
 	emailSentDate := time.Now().Add(time.Hour * 7)
 	expectedStatus := tracking.Completed
-	require.NoError(t, store.EmailSent(ctx, expectedID, &emailSentDate, expectedStatus))
+	expectedUsage := &accounting.Usage{RunID: uuid.NewString(), BytesCopied: 2048, ObjectsCopied: 2, CopyObjectRequests: 2, WallTimeMillis: 1500}
+	require.NoError(t, store.EmailSent(ctx, expectedID, &emailSentDate, expectedStatus, expectedUsage))
 
 	items := dyDB.Scan(ctx, testTableName)
 	require.Len(t, items, 1)
@@ -102,9 +104,12 @@ func TestDyDBStore_EmailSent(t *testing.T) {
 	AssertEqualAttributeValueString(t, expectedAWSRequestID, updatedItem[tracking.AWSRequestIDAttrName])
 	AssertEqualAttributeValueString(t, expectedFargateTaskARN, updatedItem[tracking.FargateTaskARNAttrName])
 	AssertEqualAttributeValueString(t, origEntry.RequestDate.Format(time.RFC3339Nano), updatedItem[tracking.RequestDateAttrName])
+	updatedEntry, err := tracking.FromItem(updatedItem)
+	require.NoError(t, err)
+	assert.Equal(t, expectedUsage, updatedEntry.Usage)
 
 	// A second try should fail
-	err := store.EmailSent(ctx, expectedID, &emailSentDate, expectedStatus)
+	err = store.EmailSent(ctx, expectedID, &emailSentDate, expectedStatus, nil)
 	var alreadyExistsError *tracking.EntryAlreadyExistsError
 	if assert.ErrorAs(t, err, &alreadyExistsError) {
 		assert.NoError(t, alreadyExistsError.UnmarshallingError)
@@ -177,15 +182,59 @@ func TestDyDBStore_QueryDatasetVersionIndex(t *testing.T) {
 		asEntry := e.(*tracking.Entry)
 		unhandledEntryIndicesByID[asEntry.ID] = asEntry.DatasetVersionIndex
 	}
-	allEntries := append(unhandledEntries, oldCompletedEntry, oldFailedEntry)
+	// An unhandled entry for an external rehydration of the same dataset. It belongs to a different task.
+	externalEntry := tracking.NewEntry(uuid.NewString(), dataset, user2, uuid.NewString(), uuid.NewString(), uuid.NewString())
+	externalEntry.Destination = "s3://their-bucket/data/"
+	allEntries := append(unhandledEntries, oldCompletedEntry, oldFailedEntry, externalEntry)
 	dyDB := test.NewDynamoDBFixture(t, awsConfig, test.TrackingCreateTableInput(testTableName)).WithItems(test.ItemersToPutItemInputs(t, testTableName, allEntries...)...)
 	defer dyDB.Teardown()
 
-	indexItems, err := store.QueryDatasetVersionIndexUnhandled(ctx, dataset, 2)
+	indexItems, err := store.QueryDatasetVersionIndexUnhandled(ctx, dataset, "", 2)
 	require.NoError(t, err)
 	require.Len(t, indexItems, len(unhandledEntries))
 	for _, i := range indexItems {
 		require.Contains(t, unhandledEntryIndicesByID, i.ID)
 		assert.Equal(t, unhandledEntryIndicesByID[i.ID], i)
 	}
+
+	externalItems, err := store.QueryDatasetVersionIndexUnhandled(ctx, dataset, externalEntry.Destination, 2)
+	require.NoError(t, err)
+	require.Len(t, externalItems, 1)
+	assert.Equal(t, externalEntry.DatasetVersionIndex, externalItems[0])
+}
+
+func TestDyDBStore_ScanUsage(t *testing.T) {
+	ctx := context.Background()
+	awsConfig := test.NewAWSEndpoints(t).WithDynamoDB().Config(ctx, false)
+	dyDBClient := dynamodb.NewFromConfig(awsConfig)
+	store := tracking.NewStore(dyDBClient, logging.Default, testTableName)
+
+	since := time.Date(2026, time.September, 1, 0, 0, 0, 0, time.UTC)
+	until := since.AddDate(0, 1, 0)
+	user := models.User{Name: "First Last", Email: "last@example.com"}
+	newEntry := func(requestDate time.Time, usage *accounting.Usage) *tracking.Entry {
+		entry := test.NewTestEntry(models.Dataset{ID: 898, VersionID: 7}, user)
+		entry.RequestDate = requestDate
+		entry.Usage = usage
+		return entry
+	}
+	usage := &accounting.Usage{RunID: uuid.NewString(), BytesCopied: 100}
+	inMonth := newEntry(since.Add(time.Hour), usage)
+	lastInstant := newEntry(until.Add(-time.Nanosecond), usage)
+	noUsage := newEntry(since.Add(time.Hour), nil)
+	before := newEntry(since.Add(-time.Nanosecond), usage)
+	after := newEntry(until, usage)
+
+	dyDB := test.NewDynamoDBFixture(t, awsConfig, test.TrackingCreateTableInput(testTableName)).
+		WithItems(test.ItemersToPutItemInputs(t, testTableName, inMonth, lastInstant, noUsage, before, after)...)
+	defer dyDB.Teardown()
+
+	entries, err := store.ScanUsage(ctx, since, until)
+	require.NoError(t, err)
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+		assert.Equal(t, usage, entry.Usage)
+	}
+	assert.ElementsMatch(t, []string{inMonth.ID, lastInstant.ID}, ids)
 }
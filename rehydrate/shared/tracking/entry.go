@@ -3,6 +3,7 @@ package tracking
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
 	"github.com/pennsieve/rehydration-service/shared/dydbutils"
 	"github.com/pennsieve/rehydration-service/shared/models"
 	"strings"
@@ -20,6 +21,8 @@ const (
 	Completed  RehydrationStatus = "COMPLETED"
 	Expired    RehydrationStatus = "EXPIRED"
 	Failed     RehydrationStatus = "FAILED"
+	// Partial is for rehydrations that completed without some files because the task's failure policy allowed it
+	Partial RehydrationStatus = "PARTIAL"
 )
 
 func RehydrationStatusFromString(s string) (RehydrationStatus, error) {
@@ -30,6 +33,8 @@ func RehydrationStatusFromString(s string) (RehydrationStatus, error) {
 		return Completed, nil
 	case string(Failed):
 		return Failed, nil
+	case string(Partial):
+		return Partial, nil
 	default:
 		return "", fmt.Errorf("unknown rehydration status: [%s]", s)
 	}
@@ -47,6 +52,9 @@ const RequestDateAttrName = "requestDate"
 const RehydrationStatusAttrName = "rehydrationStatus"
 const EmailSentDateAttrName = "emailSentDate"
 const FargateTaskARNAttrName = "fargateTaskARN"
+const PrincipalAttrName = "principal"
+const DestinationAttrName = "destination"
+const UsageAttrName = accounting.UsageAttrName
 
 // DatasetVersionIndex represents a Global Secondary Index to the Entry table.
 // The partition key of this index is DatasetVersion so that when a rehydration Fargate
@@ -65,6 +73,9 @@ type DatasetVersionIndex struct {
 	// This is the cleanest way to ensure that entries that haven't had their email sent date result in table items
 	// with no email sent date field attribute instead of having the attribute set to the time.Time zero value 0001-01-01T00:00:00Z
 	EmailSentDate *time.Time `dynamodbav:"emailSentDate,omitempty"`
+	// Destination is the location of the requester-owned bucket for an external rehydration. Empty for rehydrations
+	// into our own buckets.
+	Destination string `dynamodbav:"destination,omitempty"`
 }
 type Entry struct {
 	DatasetVersionIndex
@@ -72,6 +83,10 @@ type Entry struct {
 	AWSRequestID    string    `dynamodbav:"awsRequestId"`
 	RequestDate     time.Time `dynamodbav:"requestDate"`
 	FargateTaskARN  string    `dynamodbav:"fargateTaskARN,omitempty"`
+	// Principal is the authenticated caller that made the request, which is not the user for service accounts
+	Principal string `dynamodbav:"principal,omitempty"`
+	// Usage is what the rehydration task run that handled this request used. Several entries can share one run.
+	Usage *accounting.Usage `dynamodbav:"usage,omitempty"`
 }
 
 func NewEntry(id string, dataset models.Dataset, user models.User, lambdaLogStream, awsRequestID, fargateTaskARN string) *Entry {
@@ -27,6 +27,7 @@ func TestEntry_ItemRoundTrip(t *testing.T) {
 		AWSRequestID:    "REQUEST-1234",
 		RequestDate:     requestDate,
 		FargateTaskARN:  "arn:ecs:test:test:test",
+		Principal:       "user:7f1c2a9e-0000-4000-8000-000000000000",
 	}
 
 	item, err := entry.Item()
@@ -45,6 +46,7 @@ func TestEntry_ItemRoundTrip(t *testing.T) {
 	assert.Equal(t, entry.AWSRequestID, unmarshalled.AWSRequestID)
 	assert.Equal(t, entry.RehydrationStatus, unmarshalled.RehydrationStatus)
 	assert.Equal(t, entry.FargateTaskARN, unmarshalled.FargateTaskARN)
+	assert.Equal(t, entry.Principal, unmarshalled.Principal)
 
 	assert.Equal(t, entry.RequestDate.Format(time.RFC3339Nano), unmarshalled.RequestDate.Format(time.RFC3339Nano))
 	assert.Equal(t, entry.EmailSentDate.Format(time.RFC3339Nano), entry.EmailSentDate.Format(time.RFC3339Nano))
@@ -60,6 +62,10 @@ func TestStatusFromString(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, tracking.Completed, complete)
 
+	partial, err := tracking.RehydrationStatusFromString("PARTIAL")
+	require.NoError(t, err)
+	require.Equal(t, tracking.Partial, partial)
+
 }
 
 func AssertEqualAttributeValueString(t *testing.T, expectedValue string, attrValue types.AttributeValue) bool {
@@ -79,6 +85,12 @@ func AssertEqualEntryItem(t *testing.T, entry tracking.Entry, item map[string]ty
 	} else {
 		result = result && AssertEqualAttributeValueString(t, entry.FargateTaskARN, item[tracking.FargateTaskARNAttrName])
 	}
+	if len(entry.Principal) == 0 {
+		// testing omitempty
+		result = result && assert.NotContains(t, item, tracking.PrincipalAttrName)
+	} else {
+		result = result && AssertEqualAttributeValueString(t, entry.Principal, item[tracking.PrincipalAttrName])
+	}
 	result = result && AssertEqualAttributeValueString(t, entry.RequestDate.Format(time.RFC3339Nano), item[tracking.RequestDateAttrName])
 	if entry.EmailSentDate == nil {
 		// testing omitempty
@@ -86,5 +98,13 @@ func AssertEqualEntryItem(t *testing.T, entry tracking.Entry, item map[string]ty
 	} else {
 		result = result && AssertEqualAttributeValueString(t, entry.EmailSentDate.Format(time.RFC3339Nano), item[tracking.EmailSentDateAttrName])
 	}
+	if entry.Usage == nil {
+		// testing omitempty
+		result = result && assert.NotContains(t, item, tracking.UsageAttrName)
+	} else if itemEntry, err := tracking.FromItem(item); assert.NoError(t, err) {
+		result = result && assert.Equal(t, entry.Usage, itemEntry.Usage)
+	} else {
+		result = false
+	}
 	return result
 }
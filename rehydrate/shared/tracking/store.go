@@ -2,14 +2,20 @@ package tracking
 
 import (
 	"context"
+	"github.com/pennsieve/rehydration-service/shared/accounting"
 	"github.com/pennsieve/rehydration-service/shared/models"
 	"time"
 )
 
 type Store interface {
 	PutEntry(ctx context.Context, entry *Entry) error
-	EmailSent(ctx context.Context, id string, emailSentDate *time.Time, status RehydrationStatus) error
+	// EmailSent also saves usage on the entry if it is not nil
+	EmailSent(ctx context.Context, id string, emailSentDate *time.Time, status RehydrationStatus, usage *accounting.Usage) error
 	// QueryDatasetVersionIndexUnhandled looks up DatasetVersionIndex entries for the give dataset where no emailSentDate has been set.
+	// Only entries for the given external destination are returned, or, if destination is empty, only entries for
+	// rehydrations into our own buckets.
 	// limit is a page size, but this method does the pagination and returns all matching entries in one call.
-	QueryDatasetVersionIndexUnhandled(ctx context.Context, dataset models.Dataset, limit int32) ([]DatasetVersionIndex, error)
+	QueryDatasetVersionIndexUnhandled(ctx context.Context, dataset models.Dataset, destination string, limit int32) ([]DatasetVersionIndex, error)
+	// ScanUsage returns the entries requested in [since, until) that have a Usage
+	ScanUsage(ctx context.Context, since, until time.Time) ([]Entry, error)
 }
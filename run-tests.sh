@@ -24,8 +24,24 @@ echo "RUNNING lambda/expiration TESTS"
 cd "$root_dir/lambda/expiration"
 go test -v ./...; exit_status=$((exit_status || $? ))
 
+echo "RUNNING lambda/dispatcher TESTS"
+cd "$root_dir/lambda/dispatcher"
+go test -v ./...; exit_status=$((exit_status || $? ))
+
+echo "RUNNING lambda/report TESTS"
+cd "$root_dir/lambda/report"
+go test -v ./...; exit_status=$((exit_status || $? ))
+
+echo "RUNNING lambda/gc TESTS"
+cd "$root_dir/lambda/gc"
+go test -v ./...; exit_status=$((exit_status || $? ))
+
 echo "RUNNING rehydrate/fargate TESTS"
 cd "$root_dir/rehydrate/fargate"
 go test -v ./...; exit_status=$((exit_status || $? ))
 
+echo "RUNNING local TESTS"
+cd "$root_dir/local"
+go test -v ./...; exit_status=$((exit_status || $? ))
+
 exit $exit_status
@@ -57,4 +57,91 @@ resource "aws_cloudwatch_event_target" "expiration_cloudwatch_event_target" {
   rule      = aws_cloudwatch_event_rule.expiration_cloudwatch_event_rule.name
   target_id = "${var.environment_name}-rehydration-expiration-lambda-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
   arn       = aws_lambda_function.expiration_lambda.arn
-}
\ No newline at end of file
+}
+
+// CREATE DISPATCHER LAMBDA CLOUDWATCH LOG GROUP
+resource "aws_cloudwatch_log_group" "dispatcher_lambda_cloudwatch_log_group" {
+  name              = "/aws/lambda/${aws_lambda_function.dispatcher_lambda.function_name}"
+  retention_in_days = 14
+
+  tags = local.common_tags
+}
+
+resource "aws_cloudwatch_log_subscription_filter" "dispatcher_lambda_datadog_subscription" {
+  name            = "${aws_cloudwatch_log_group.dispatcher_lambda_cloudwatch_log_group.name}-subscription"
+  log_group_name  = aws_cloudwatch_log_group.dispatcher_lambda_cloudwatch_log_group.name
+  filter_pattern  = ""
+  destination_arn = data.terraform_remote_state.region.outputs.datadog_delivery_stream_arn
+  role_arn        = data.terraform_remote_state.region.outputs.cw_logs_to_datadog_logs_firehose_role_arn
+}
+
+// CREATE DISPATCHER EVENT RULE
+resource "aws_cloudwatch_event_rule" "dispatcher_cloudwatch_event_rule" {
+  name                = "${var.environment_name}-rehydration-dispatcher-cloudwatch-event-rule-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  description         = "Trigger for starting queued rehydrations"
+  schedule_expression = "rate(1 minute)"
+}
+
+resource "aws_cloudwatch_event_target" "dispatcher_cloudwatch_event_target" {
+  rule      = aws_cloudwatch_event_rule.dispatcher_cloudwatch_event_rule.name
+  target_id = "${var.environment_name}-rehydration-dispatcher-lambda-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  arn       = aws_lambda_function.dispatcher_lambda.arn
+}
+
+// CREATE REPORT LAMBDA CLOUDWATCH LOG GROUP
+resource "aws_cloudwatch_log_group" "report_lambda_cloudwatch_log_group" {
+  name              = "/aws/lambda/${aws_lambda_function.report_lambda.function_name}"
+  retention_in_days = 14
+
+  tags = local.common_tags
+}
+
+resource "aws_cloudwatch_log_subscription_filter" "report_lambda_datadog_subscription" {
+  name            = "${aws_cloudwatch_log_group.report_lambda_cloudwatch_log_group.name}-subscription"
+  log_group_name  = aws_cloudwatch_log_group.report_lambda_cloudwatch_log_group.name
+  filter_pattern  = ""
+  destination_arn = data.terraform_remote_state.region.outputs.datadog_delivery_stream_arn
+  role_arn        = data.terraform_remote_state.region.outputs.cw_logs_to_datadog_logs_firehose_role_arn
+}
+
+// CREATE REPORT EVENT RULE
+resource "aws_cloudwatch_event_rule" "report_cloudwatch_event_rule" {
+  name                = "${var.environment_name}-rehydration-report-cloudwatch-event-rule-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  description         = "Monthly trigger for the rehydration usage report of the previous month"
+  schedule_expression = "cron(0 6 1 * ? *)"
+}
+
+resource "aws_cloudwatch_event_target" "report_cloudwatch_event_target" {
+  rule      = aws_cloudwatch_event_rule.report_cloudwatch_event_rule.name
+  target_id = "${var.environment_name}-rehydration-report-lambda-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  arn       = aws_lambda_function.report_lambda.arn
+}
+
+// CREATE GC LAMBDA CLOUDWATCH LOG GROUP
+resource "aws_cloudwatch_log_group" "gc_lambda_cloudwatch_log_group" {
+  name              = "/aws/lambda/${aws_lambda_function.gc_lambda.function_name}"
+  retention_in_days = 14
+
+  tags = local.common_tags
+}
+
+resource "aws_cloudwatch_log_subscription_filter" "gc_lambda_datadog_subscription" {
+  name            = "${aws_cloudwatch_log_group.gc_lambda_cloudwatch_log_group.name}-subscription"
+  log_group_name  = aws_cloudwatch_log_group.gc_lambda_cloudwatch_log_group.name
+  filter_pattern  = ""
+  destination_arn = data.terraform_remote_state.region.outputs.datadog_delivery_stream_arn
+  role_arn        = data.terraform_remote_state.region.outputs.cw_logs_to_datadog_logs_firehose_role_arn
+}
+
+// CREATE GC EVENT RULE
+resource "aws_cloudwatch_event_rule" "gc_cloudwatch_event_rule" {
+  name                = "${var.environment_name}-rehydration-gc-cloudwatch-event-rule-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  description         = "Daily trigger for deleting orphaned rehydration files"
+  schedule_expression = "cron(0 5 * * ? *)"
+}
+
+resource "aws_cloudwatch_event_target" "gc_cloudwatch_event_target" {
+  rule      = aws_cloudwatch_event_rule.gc_cloudwatch_event_rule.name
+  target_id = "${var.environment_name}-rehydration-gc-lambda-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  arn       = aws_lambda_function.gc_lambda.arn
+}
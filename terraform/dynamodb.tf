@@ -26,6 +26,13 @@ resource "aws_dynamodb_table" "idempotency_table" {
     non_key_attributes = ["id", "rehydrationLocation"]
   }
 
+  global_secondary_index {
+    name            = "StatusIndex"
+    hash_key        = "status"
+    range_key       = "id"
+    projection_type = "KEYS_ONLY"
+  }
+
   point_in_time_recovery {
     enabled = true
   }
@@ -69,7 +76,7 @@ resource "aws_dynamodb_table" "tracking_table" {
     hash_key           = "datasetVersion"
     range_key          = "rehydrationStatus"
     projection_type    = "INCLUDE"
-    non_key_attributes = ["id", "userName", "userEmail", "emailSentDate"]
+    non_key_attributes = ["id", "userName", "userEmail", "emailSentDate", "destination"]
   }
 
   point_in_time_recovery {
@@ -89,3 +96,118 @@ resource "aws_dynamodb_table" "tracking_table" {
     },
   )
 }
+
+resource "aws_dynamodb_table" "quota_table" {
+  name         = "${var.environment_name}-rehydration-quota-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "userEmail"
+  range_key    = "window"
+
+  attribute {
+    name = "userEmail"
+    type = "S"
+  }
+
+  attribute {
+    name = "window"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "expiresAt"
+    enabled        = true
+  }
+
+  server_side_encryption {
+    enabled = true
+  }
+
+  tags = merge(
+    local.common_tags,
+    {
+      "Name"         = "${var.environment_name}-rehydration-quota-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+      "name"         = "${var.environment_name}-rehydration-quota-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+      "service_name" = var.service_name
+    },
+  )
+}
+
+resource "aws_dynamodb_table" "delete_retry_table" {
+  name         = "${var.environment_name}-rehydration-delete-retry-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "recordId"
+  range_key    = "key"
+
+  attribute {
+    name = "recordId"
+    type = "S"
+  }
+
+  attribute {
+    name = "key"
+    type = "S"
+  }
+
+  point_in_time_recovery {
+    enabled = true
+  }
+
+  server_side_encryption {
+    enabled = true
+  }
+
+  tags = merge(
+    local.common_tags,
+    {
+      "Name"         = "${var.environment_name}-rehydration-delete-retry-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+      "name"         = "${var.environment_name}-rehydration-delete-retry-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+      "service_name" = var.service_name
+    },
+  )
+}
+
+resource "aws_dynamodb_table" "audit_table" {
+  name         = "${var.environment_name}-rehydration-audit-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "datasetVersion"
+  range_key    = "eventKey"
+
+  attribute {
+    name = "datasetVersion"
+    type = "S"
+  }
+
+  attribute {
+    name = "eventKey"
+    type = "S"
+  }
+
+  attribute {
+    name = "userEmail"
+    type = "S"
+  }
+
+  global_secondary_index {
+    name            = "UserEmailIndex"
+    hash_key        = "userEmail"
+    range_key       = "eventKey"
+    projection_type = "ALL"
+  }
+
+  point_in_time_recovery {
+    enabled = true
+  }
+
+  server_side_encryption {
+    enabled = true
+  }
+
+  tags = merge(
+    local.common_tags,
+    {
+      "Name"         = "${var.environment_name}-rehydration-audit-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+      "name"         = "${var.environment_name}-rehydration-audit-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+      "service_name" = var.service_name
+    },
+  )
+}
@@ -15,6 +15,12 @@ data "template_file" "rehydration_task_definition" {
     tier                   = var.tier
     rehydration_bucket     = aws_s3_bucket.rehydration_s3_bucket.id
     rehydration_ttl_days   = local.rehydration_ttl_days
+    delete_retry_table     = aws_dynamodb_table.delete_retry_table.name
+    audit_table            = aws_dynamodb_table.audit_table.name
+
+    rehydration_storage_class       = var.rehydration_storage_class
+    rehydration_kms_key_arn         = var.rehydration_kms_key_arn
+    rehydration_region_kms_key_arns = local.rehydration_region_kms_key_arns_env
   }
 }
 
@@ -51,8 +51,10 @@ data "aws_iam_policy_document" "rehydration_fargate_iam_policy_document" {
     sid    = "TaskS3PublishBucketsReadOnly"
     effect = "Allow"
 
+    // RestoreObject makes a temporary copy of an archived version without changing it
     actions = [
       "s3:Get*",
+      "s3:RestoreObject",
     ]
 
     resources = [
@@ -66,21 +68,79 @@ data "aws_iam_policy_document" "rehydration_fargate_iam_policy_document" {
     ]
   }
 
+  statement {
+    sid    = "TaskS3PublishBucketsListVersions"
+    effect = "Allow"
+
+    // listing the versions of a dataset's files gives their storage classes, so that only archived ones are checked
+    actions = [
+      "s3:ListBucketVersions",
+    ]
+
+    resources = [
+      data.terraform_remote_state.platform_infrastructure.outputs.sparc_publish50_bucket_arn,
+      data.terraform_remote_state.platform_infrastructure.outputs.discover_publish50_bucket_arn,
+      data.terraform_remote_state.platform_infrastructure.outputs.rejoin_publish50_bucket_arn,
+      data.terraform_remote_state.platform_infrastructure.outputs.precision_publish50_bucket_arn,
+      data.terraform_remote_state.africa_south_region.outputs.af_south_s3_discover_bucket_arn,
+      data.terraform_remote_state.platform_infrastructure.outputs.awsod_edots_publish50_bucket_arn,
+      data.terraform_remote_state.platform_infrastructure.outputs.awsod_sparc_publish50_bucket_arn,
+    ]
+  }
+
   statement {
     sid    = "TaskS3RehydrationBuckets"
     effect = "Allow"
 
+    // GetObject is needed to copy unchanged files from other versions' rehydrations
     actions = [
+      "s3:GetObject",
       "s3:PutObject",
+      "s3:PutObjectTagging",
       "s3:DeleteObject",
+      "s3:DeleteObjectVersion",
       "s3:ListBucket",
+      "s3:ListBucketVersions",
+      "s3:GetBucketVersioning",
       "s3:AbortMultipartUpload"
     ]
 
-    resources = [
+    resources = concat([
       aws_s3_bucket.rehydration_s3_bucket.arn,
       "${aws_s3_bucket.rehydration_s3_bucket.arn}/*",
-    ]
+    ], local.rehydration_region_bucket_arns)
+  }
+
+  dynamic "statement" {
+    for_each = length(local.rehydration_kms_key_arns) > 0 ? [local.rehydration_kms_key_arns] : []
+
+    content {
+      sid    = "TaskRehydrationKMSKey"
+      effect = "Allow"
+
+      // Decrypt is needed to complete multipart copies
+      actions = [
+        "kms:GenerateDataKey",
+        "kms:Decrypt",
+      ]
+
+      resources = statement.value
+    }
+  }
+
+  dynamic "statement" {
+    for_each = length(local.external_destination_role_arns) > 0 ? [local.external_destination_role_arns] : []
+
+    content {
+      sid    = "TaskAssumeExternalDestinationRoles"
+      effect = "Allow"
+
+      actions = [
+        "sts:AssumeRole",
+      ]
+
+      resources = statement.value
+    }
   }
 
   statement {
@@ -104,6 +164,35 @@ data "aws_iam_policy_document" "rehydration_fargate_iam_policy_document" {
 
   }
 
+  statement {
+    sid    = "RehydrationFargateDeleteRetryPermissions"
+    effect = "Allow"
+
+    actions = [
+      "dynamodb:PutItem",
+    ]
+
+    resources = [
+      aws_dynamodb_table.delete_retry_table.arn,
+    ]
+
+  }
+
+  statement {
+    sid    = "RehydrationFargateAuditPermissions"
+    effect = "Allow"
+
+    // audit events are only ever appended
+    actions = [
+      "dynamodb:PutItem",
+    ]
+
+    resources = [
+      aws_dynamodb_table.audit_table.arn,
+    ]
+
+  }
+
   statement {
     sid     = "RehydrationFargateSESPermissions"
     effect  = "Allow"
@@ -243,6 +332,8 @@ data "aws_iam_policy_document" "rehydration_iam_policy_document" {
       "dynamodb:GetItem",
       "dynamodb:PutItem",
       "dynamodb:DeleteItem",
+      "dynamodb:Scan",
+      "dynamodb:Query",
     ]
 
     resources = [
@@ -254,6 +345,50 @@ data "aws_iam_policy_document" "rehydration_iam_policy_document" {
 
   }
 
+  statement {
+    sid    = "RehydrationLambdaQuotaPermissions"
+    effect = "Allow"
+
+    actions = [
+      "dynamodb:UpdateItem",
+      "dynamodb:Query",
+    ]
+
+    resources = [
+      aws_dynamodb_table.quota_table.arn,
+    ]
+  }
+
+  statement {
+    sid    = "RehydrationLambdaAuditPermissions"
+    effect = "Allow"
+
+    // audit events are only ever appended
+    actions = [
+      "dynamodb:PutItem",
+    ]
+
+    resources = [
+      aws_dynamodb_table.audit_table.arn,
+    ]
+
+  }
+
+  statement {
+    sid    = "RehydrationLambdaSQSPermissions"
+    effect = "Allow"
+
+    actions = [
+      "sqs:SendMessage",
+      "sqs:ReceiveMessage",
+      "sqs:DeleteMessage",
+      "sqs:ChangeMessageVisibility",
+      "sqs:GetQueueAttributes",
+    ]
+
+    resources = [for queue in aws_sqs_queue.rehydration_queue : queue.arn]
+  }
+
   statement {
     sid     = "RehydrationLambdaSESPermissions"
     effect  = "Allow"
@@ -332,6 +467,7 @@ data "aws_iam_policy_document" "expiration_iam_policy_document" {
 
     actions = [
       "dynamodb:UpdateItem",
+      "dynamodb:GetItem",
       "dynamodb:DeleteItem",
       "dynamodb:Query",
     ]
@@ -343,21 +479,255 @@ data "aws_iam_policy_document" "expiration_iam_policy_document" {
 
   }
 
+  statement {
+    sid    = "ExpirationLambdaDeleteRetryPermissions"
+    effect = "Allow"
+
+    actions = [
+      "dynamodb:PutItem",
+      "dynamodb:DeleteItem",
+      "dynamodb:Scan",
+    ]
+
+    resources = [
+      aws_dynamodb_table.delete_retry_table.arn,
+    ]
+
+  }
+
+  statement {
+    sid    = "ExpirationLambdaAuditPermissions"
+    effect = "Allow"
+
+    // audit events are only ever appended
+    actions = [
+      "dynamodb:PutItem",
+    ]
+
+    resources = [
+      aws_dynamodb_table.audit_table.arn,
+    ]
+
+  }
+
   statement {
     sid    = "ExpirationLambdaS3RehydrationBuckets"
     effect = "Allow"
 
+    // the version permissions let the cleaner remove every version from a versioned bucket
     actions = [
       "s3:DeleteObject",
+      "s3:DeleteObjectVersion",
       "s3:ListBucket",
+      "s3:ListBucketVersions",
+      "s3:GetBucketVersioning",
     ]
 
-    resources = [
+    resources = concat([
       aws_s3_bucket.rehydration_s3_bucket.arn,
       "${aws_s3_bucket.rehydration_s3_bucket.arn}/*",
+    ], local.rehydration_region_bucket_arns)
+  }
+
+}
+
+# REPORT LAMBDA #
+#################
+resource "aws_iam_role" "report_lambda_role" {
+  name = "${var.environment_name}-rehydration-report-lambda-role-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+
+  assume_role_policy = <<EOF
+{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Action": "sts:AssumeRole",
+      "Principal": {
+        "Service": "lambda.amazonaws.com"
+      },
+      "Effect": "Allow",
+      "Sid": "RehydrationReportLambdaAssumeRole"
+    }
+  ]
+}
+EOF
+}
+
+resource "aws_iam_role_policy_attachment" "report_lambda_iam_policy_attachment" {
+  role       = aws_iam_role.report_lambda_role.name
+  policy_arn = aws_iam_policy.report_lambda_iam_policy.arn
+}
+
+resource "aws_iam_policy" "report_lambda_iam_policy" {
+  name   = "${var.environment_name}-rehydration-report-lambda-iam-policy-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  path   = "/"
+  policy = data.aws_iam_policy_document.report_iam_policy_document.json
+}
+
+data "aws_iam_policy_document" "report_iam_policy_document" {
+
+  statement {
+    sid     = "ReportLambdaLogsPermissions"
+    effect  = "Allow"
+    actions = [
+      "logs:CreateLogGroup",
+      "logs:CreateLogStream",
+      "logs:PutDestination",
+      "logs:PutLogEvents",
+      "logs:DescribeLogStreams"
+    ]
+    resources = ["*"]
+  }
+
+  statement {
+    sid     = "ReportLambdaEC2Permissions"
+    effect  = "Allow"
+    actions = [
+      "ec2:CreateNetworkInterface",
+      "ec2:DescribeNetworkInterfaces",
+      "ec2:DeleteNetworkInterface",
+      "ec2:AssignPrivateIpAddresses",
+      "ec2:UnassignPrivateIpAddresses"
+    ]
+    resources = ["*"]
+  }
+
+  statement {
+    sid    = "ReportLambdaDynamoDBPermissions"
+    effect = "Allow"
+
+    actions = [
+      "dynamodb:Scan",
+    ]
+
+    resources = [
+      aws_dynamodb_table.tracking_table.arn,
     ]
   }
 
+  statement {
+    sid    = "ReportLambdaAuditPermissions"
+    effect = "Allow"
+
+    actions = [
+      "dynamodb:Query",
+    ]
+
+    resources = [
+      aws_dynamodb_table.audit_table.arn,
+      "${aws_dynamodb_table.audit_table.arn}/index/UserEmailIndex",
+    ]
+  }
+
+  statement {
+    sid    = "ReportLambdaS3ReportBucket"
+    effect = "Allow"
+
+    actions = [
+      "s3:PutObject",
+    ]
+
+    resources = [
+      "${aws_s3_bucket.usage_report_s3_bucket.arn}/*",
+    ]
+  }
+
+}
+
+# GC LAMBDA #
+#############
+resource "aws_iam_role" "gc_lambda_role" {
+  name = "${var.environment_name}-rehydration-gc-lambda-role-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+
+  assume_role_policy = <<EOF
+{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Action": "sts:AssumeRole",
+      "Principal": {
+        "Service": "lambda.amazonaws.com"
+      },
+      "Effect": "Allow",
+      "Sid": "RehydrationGCLambdaAssumeRole"
+    }
+  ]
+}
+EOF
+}
+
+resource "aws_iam_role_policy_attachment" "gc_lambda_iam_policy_attachment" {
+  role       = aws_iam_role.gc_lambda_role.name
+  policy_arn = aws_iam_policy.gc_lambda_iam_policy.arn
+}
+
+resource "aws_iam_policy" "gc_lambda_iam_policy" {
+  name   = "${var.environment_name}-rehydration-gc-lambda-iam-policy-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  path   = "/"
+  policy = data.aws_iam_policy_document.gc_iam_policy_document.json
+}
+
+data "aws_iam_policy_document" "gc_iam_policy_document" {
+
+  statement {
+    sid     = "GCLambdaLogsPermissions"
+    effect  = "Allow"
+    actions = [
+      "logs:CreateLogGroup",
+      "logs:CreateLogStream",
+      "logs:PutDestination",
+      "logs:PutLogEvents",
+      "logs:DescribeLogStreams"
+    ]
+    resources = ["*"]
+  }
+
+  statement {
+    sid     = "GCLambdaEC2Permissions"
+    effect  = "Allow"
+    actions = [
+      "ec2:CreateNetworkInterface",
+      "ec2:DescribeNetworkInterfaces",
+      "ec2:DeleteNetworkInterface",
+      "ec2:AssignPrivateIpAddresses",
+      "ec2:UnassignPrivateIpAddresses"
+    ]
+    resources = ["*"]
+  }
+
+  statement {
+    sid    = "GCLambdaDynamoDBPermissions"
+    effect = "Allow"
+
+    actions = [
+      "dynamodb:GetItem",
+    ]
+
+    resources = [
+      aws_dynamodb_table.idempotency_table.arn,
+    ]
+  }
+
+  statement {
+    sid    = "GCLambdaS3RehydrationBuckets"
+    effect = "Allow"
+
+    actions = [
+      "s3:DeleteObject",
+      "s3:DeleteObjectVersion",
+      "s3:ListBucket",
+      "s3:ListBucketVersions",
+      "s3:GetBucketVersioning",
+      "s3:ListBucketMultipartUploads",
+      "s3:AbortMultipartUpload",
+    ]
+
+    resources = concat([
+      aws_s3_bucket.rehydration_s3_bucket.arn,
+      "${aws_s3_bucket.rehydration_s3_bucket.arn}/*",
+    ], local.rehydration_region_bucket_arns)
+  }
+
 }
 
 # Create Rehydration S3 Bucket Policy #
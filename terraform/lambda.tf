@@ -21,7 +21,7 @@ resource "aws_lambda_function" "rehydration_fargate_trigger_lambda" {
   }
 
   environment {
-    variables = {
+    variables = merge({
       ENV                                    = var.environment_name
       TASK_DEF_ARN                           = aws_ecs_task_definition.rehydration_ecs_task_definition.arn,
       CLUSTER_ARN                            = data.terraform_remote_state.fargate.outputs.ecs_cluster_arn,
@@ -34,7 +34,14 @@ resource "aws_lambda_function" "rehydration_fargate_trigger_lambda" {
       FARGATE_IDEMPOTENT_DYNAMODB_TABLE_NAME = aws_dynamodb_table.idempotency_table.name,
       REQUEST_TRACKING_DYNAMODB_TABLE_NAME   = aws_dynamodb_table.tracking_table.name,
       REHYDRATION_TTL_DAYS                   = local.rehydration_ttl_days,
-    }
+      REHYDRATION_MAX_CONCURRENT             = local.rehydration_max_concurrent,
+      SERVICE_ACCOUNT_ARNS                   = join(",", var.service_account_arns),
+      SERVICE_ACCOUNT_CLIENT_IDS             = join(",", var.service_account_client_ids),
+      SERVICE_ACCOUNT_SCOPES                 = join(",", var.service_account_scopes),
+      EXTERNAL_DESTINATION_ROLES             = jsonencode(var.external_destination_roles),
+      REHYDRATION_REGION_BUCKETS             = local.rehydration_region_buckets_env,
+      AUDIT_DYNAMODB_TABLE_NAME              = aws_dynamodb_table.audit_table.name,
+    }, local.rehydration_queue_env, local.rehydration_quota_env)
   }
 }
 
@@ -61,6 +68,10 @@ resource "aws_lambda_function" "expiration_lambda" {
       PENNSIEVE_DOMAIN                       = data.terraform_remote_state.account.outputs.domain_name,
       REGION                                 = var.aws_region,
       FARGATE_IDEMPOTENT_DYNAMODB_TABLE_NAME = aws_dynamodb_table.idempotency_table.name,
+      DELETE_RETRY_DYNAMODB_TABLE_NAME       = aws_dynamodb_table.delete_retry_table.name,
+      AUDIT_DYNAMODB_TABLE_NAME              = aws_dynamodb_table.audit_table.name,
+      REHYDRATION_BUCKET                     = aws_s3_bucket.rehydration_s3_bucket.id,
+      REHYDRATION_REGION_BUCKETS             = local.rehydration_region_buckets_env,
     }
   }
 }
@@ -71,4 +82,128 @@ resource "aws_lambda_permission" "expiration_rule_permission" {
   function_name = aws_lambda_function.expiration_lambda.function_name
   principal     = "events.amazonaws.com"
   source_arn    = aws_cloudwatch_event_rule.expiration_cloudwatch_event_rule.arn
-}
\ No newline at end of file
+}
+
+resource "aws_lambda_function" "dispatcher_lambda" {
+  description   = "A function to run periodically to start queued Rehydrations when there is room for them"
+  function_name = "${var.environment_name}-rehydration-dispatcher-lambda-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  // only one dispatcher at a time so that the number of in progress rehydrations is not overcounted
+  reserved_concurrent_executions = 1
+  handler                        = "bootstrap"
+  runtime                        = "provided.al2"
+  architectures                  = ["arm64"]
+  role                           = aws_iam_role.rehydration_lambda_role.arn
+  timeout                        = 60
+  memory_size                    = 128
+  s3_bucket                      = var.lambda_bucket
+  s3_key                         = "${var.service_name}/dispatcher/rehydration-dispatcher-${var.image_tag}.zip"
+
+  vpc_config {
+    subnet_ids         = tolist(data.terraform_remote_state.vpc.outputs.private_subnet_ids)
+    security_group_ids = [
+      data.terraform_remote_state.platform_infrastructure.outputs.rehydration_service_security_group_id
+    ]
+  }
+
+  environment {
+    variables = merge({
+      ENV                                    = var.environment_name
+      TASK_DEF_ARN                           = aws_ecs_task_definition.rehydration_ecs_task_definition.arn,
+      CLUSTER_ARN                            = data.terraform_remote_state.fargate.outputs.ecs_cluster_arn,
+      SUBNET_IDS                             = join(",", data.terraform_remote_state.vpc.outputs.private_subnet_ids),
+      SECURITY_GROUP                         = data.terraform_remote_state.platform_infrastructure.outputs.rehydration_fargate_security_group_id,
+      REGION                                 = var.aws_region,
+      LOG_LEVEL                              = "info",
+      TASK_DEF_CONTAINER_NAME                = var.tier,
+      PENNSIEVE_DOMAIN                       = data.terraform_remote_state.account.outputs.domain_name
+      FARGATE_IDEMPOTENT_DYNAMODB_TABLE_NAME = aws_dynamodb_table.idempotency_table.name,
+      REQUEST_TRACKING_DYNAMODB_TABLE_NAME   = aws_dynamodb_table.tracking_table.name,
+      REHYDRATION_TTL_DAYS                   = local.rehydration_ttl_days,
+      REHYDRATION_MAX_CONCURRENT             = local.rehydration_max_concurrent,
+      SERVICE_ACCOUNT_ARNS                   = join(",", var.service_account_arns),
+      SERVICE_ACCOUNT_CLIENT_IDS             = join(",", var.service_account_client_ids),
+      SERVICE_ACCOUNT_SCOPES                 = join(",", var.service_account_scopes),
+      AUDIT_DYNAMODB_TABLE_NAME              = aws_dynamodb_table.audit_table.name,
+    }, local.rehydration_queue_env)
+  }
+}
+
+resource "aws_lambda_permission" "dispatcher_rule_permission" {
+  statement_id  = "AllowExecutionFromCloudWatch"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.dispatcher_lambda.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.dispatcher_cloudwatch_event_rule.arn
+}
+
+resource "aws_lambda_function" "report_lambda" {
+  description   = "A function to run monthly to write rehydration usage reports for the previous month, and to query the audit events of a dataset version or user"
+  function_name = "${var.environment_name}-rehydration-report-lambda-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  handler       = "bootstrap"
+  runtime       = "provided.al2"
+  architectures = ["arm64"]
+  role          = aws_iam_role.report_lambda_role.arn
+  timeout       = 300
+  memory_size   = 256
+  s3_bucket     = var.lambda_bucket
+  s3_key        = "${var.service_name}/report/rehydration-report-${var.image_tag}.zip"
+
+  vpc_config {
+    subnet_ids         = tolist(data.terraform_remote_state.vpc.outputs.private_subnet_ids)
+    security_group_ids = [data.terraform_remote_state.platform_infrastructure.outputs.upload_v2_security_group_id]
+  }
+
+  environment {
+    variables = {
+      ENV                                  = var.environment_name
+      REGION                               = var.aws_region,
+      REQUEST_TRACKING_DYNAMODB_TABLE_NAME = aws_dynamodb_table.tracking_table.name,
+      REPORT_BUCKET                        = aws_s3_bucket.usage_report_s3_bucket.bucket,
+      AUDIT_DYNAMODB_TABLE_NAME            = aws_dynamodb_table.audit_table.name,
+    }
+  }
+}
+
+resource "aws_lambda_permission" "report_rule_permission" {
+  statement_id  = "AllowExecutionFromCloudWatch"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.report_lambda.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.report_cloudwatch_event_rule.arn
+}
+
+resource "aws_lambda_function" "gc_lambda" {
+  description   = "A function to run daily to delete orphaned rehydration files and abort stale multipart uploads"
+  function_name = "${var.environment_name}-rehydration-gc-lambda-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  handler       = "bootstrap"
+  runtime       = "provided.al2"
+  architectures = ["arm64"]
+  role          = aws_iam_role.gc_lambda_role.arn
+  timeout       = 900
+  memory_size   = 128
+  s3_bucket     = var.lambda_bucket
+  s3_key        = "${var.service_name}/gc/rehydration-gc-${var.image_tag}.zip"
+
+  vpc_config {
+    subnet_ids         = tolist(data.terraform_remote_state.vpc.outputs.private_subnet_ids)
+    security_group_ids = [data.terraform_remote_state.platform_infrastructure.outputs.upload_v2_security_group_id]
+  }
+
+  environment {
+    variables = {
+      ENV                                    = var.environment_name
+      REGION                                 = var.aws_region,
+      FARGATE_IDEMPOTENT_DYNAMODB_TABLE_NAME = aws_dynamodb_table.idempotency_table.name,
+      REHYDRATION_BUCKET                     = aws_s3_bucket.rehydration_s3_bucket.id,
+      REHYDRATION_REGION_BUCKETS             = local.rehydration_region_buckets_env,
+    }
+  }
+}
+
+resource "aws_lambda_permission" "gc_rule_permission" {
+  statement_id  = "AllowExecutionFromCloudWatch"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.gc_lambda.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.gc_cloudwatch_event_rule.arn
+}
@@ -75,4 +75,42 @@ resource "aws_s3_bucket_lifecycle_configuration" "rehydration_bucket_lifecycle"
     }
     status = "Enabled"
   }
-}
\ No newline at end of file
+}
+
+## Usage Report S3 Bucket ##
+resource "aws_s3_bucket" "usage_report_s3_bucket" {
+  bucket = local.usage_report_bucket_name
+
+  lifecycle {
+    prevent_destroy = true
+  }
+
+  tags = merge(
+    local.common_tags,
+    {
+      "Name"         = local.usage_report_bucket_name
+      "name"         = local.usage_report_bucket_name
+      "service_name" = "rehydration"
+      "tier"         = "s3"
+    },
+  )
+}
+
+resource "aws_s3_bucket_public_access_block" "usage_report_s3_bucket_public_access_block" {
+  bucket = aws_s3_bucket.usage_report_s3_bucket.id
+
+  block_public_acls       = true
+  block_public_policy     = true
+  ignore_public_acls      = true
+  restrict_public_buckets = true
+}
+
+resource "aws_s3_bucket_server_side_encryption_configuration" "usage_report_s3_bucket_encryption" {
+  bucket = aws_s3_bucket.usage_report_s3_bucket.bucket
+
+  rule {
+    apply_server_side_encryption_by_default {
+      sse_algorithm = "AES256"
+    }
+  }
+}
@@ -0,0 +1,11 @@
+// Rehydration requests wait in one of these queues until the dispatcher starts them.
+// One queue per priority since SQS does not support message priorities.
+resource "aws_sqs_queue" "rehydration_queue" {
+  for_each = toset(local.rehydration_queue_priorities)
+
+  name                       = "${var.environment_name}-rehydration-${each.key}-queue-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
+  message_retention_seconds  = 1209600 // 14 days, the maximum
+  visibility_timeout_seconds = 300
+
+  tags = local.common_tags
+}
@@ -13,7 +13,14 @@
       { "name" : "ENV", "value": "${environment_name}" },
       { "name" : "REGION", "value": "${aws_region}" },
       { "name" : "REHYDRATION_BUCKET", "value": "${rehydration_bucket}" },
-      { "name" : "REHYDRATION_TTL_DAYS", "value": "${rehydration_ttl_days}" }
+      { "name" : "REHYDRATION_TTL_DAYS", "value": "${rehydration_ttl_days}" },
+      { "name" : "DESTINATION_STORAGE_CLASS", "value": "${rehydration_storage_class}" },
+      { "name" : "DESTINATION_KMS_KEY_ID", "value": "${rehydration_kms_key_arn}" },
+      { "name" : "DESTINATION_REGION_KMS_KEY_IDS", "value": "${rehydration_region_kms_key_arns}" },
+      { "name" : "DESTINATION_TAG_OBJECTS", "value": "true" },
+      { "name" : "DEDUP_ACROSS_VERSIONS", "value": "true" },
+      { "name" : "DELETE_RETRY_DYNAMODB_TABLE_NAME", "value": "${delete_retry_table}" },
+      { "name" : "AUDIT_DYNAMODB_TABLE_NAME", "value": "${audit_table}" }
     ],
     "name": "${tier}",
     "image": "${image_url}:${image_tag}",
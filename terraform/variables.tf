@@ -41,15 +41,97 @@ variable "lambda_bucket" {
   default = "pennsieve-cc-lambda-functions-use1"
 }
 
+// IAM ARNs and Cognito app client IDs of internal callers that can request rehydrations on behalf of any user
+variable "service_account_arns" {
+  type    = list(string)
+  default = []
+}
+
+variable "service_account_client_ids" {
+  type    = list(string)
+  default = []
+}
+
+// OAuth scopes that make any token granted one of them a service account
+variable "service_account_scopes" {
+  type    = list(string)
+  default = []
+}
+
+// Email addresses, or domains starting with '@', that are not subject to rehydration quotas
+variable "quota_exempt_emails" {
+  type    = list(string)
+  default = []
+}
+
+// Rehydration buckets in regions other than aws_region that requests can choose as a destination, keyed by region.
+// The buckets are not managed here.
+variable "rehydration_region_buckets" {
+  type    = map(string)
+  default = {}
+}
+
+// Roles in requester accounts that the task may assume to write into a requester-owned bucket, keyed by the principal
+// allowed to ask for them: a user's email, or an entry of service_account_arns or service_account_client_ids. Each
+// role's trust policy must allow the task role to assume it.
+variable "external_destination_roles" {
+  type    = map(list(string))
+  default = {}
+}
+
+// Storage class of rehydrated files, for example INTELLIGENT_TIERING. Empty for the bucket's default.
+variable "rehydration_storage_class" {
+  type    = string
+  default = ""
+}
+
+// KMS key used to encrypt files rehydrated into the default bucket. Empty for the bucket's default encryption.
+variable "rehydration_kms_key_arn" {
+  type    = string
+  default = ""
+}
+
+// KMS keys used to encrypt files rehydrated into the buckets of rehydration_region_buckets, keyed by region. If
+// rehydration_kms_key_arn is set, the task refuses to rehydrate into a region without a key here.
+variable "rehydration_region_kms_key_arns" {
+  type    = map(string)
+  default = {}
+}
+
 locals {
   domain_name = data.terraform_remote_state.account.outputs.domain_name
   hosted_zone = data.terraform_remote_state.account.outputs.public_hosted_zone_id
 
   rehydration_bucket_name        = "pennsieve-${var.environment_name}-rehydration-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
   rehydration_logs_target_prefix = "${var.environment_name}/rehydration/s3/"
+  usage_report_bucket_name       = "pennsieve-${var.environment_name}-rehydration-usage-reports-${data.terraform_remote_state.region.outputs.aws_region_shortname}"
 
   rehydration_ttl_days = 14
 
+  rehydration_max_concurrent   = 10
+  rehydration_queue_priorities = ["high", "normal", "low"]
+  rehydration_queue_env = {
+    for priority in local.rehydration_queue_priorities :
+    "REHYDRATION_QUEUE_URL_${upper(priority)}" => aws_sqs_queue.rehydration_queue[priority].url
+  }
+
+  rehydration_quota_env = {
+    QUOTA_DYNAMODB_TABLE_NAME     = aws_dynamodb_table.quota_table.name
+    QUOTA_USER_MAX_REQUESTS       = 20
+    QUOTA_USER_MAX_BYTES          = 10 * 1024 * 1024 * 1024 * 1024 // 10 TiB
+    QUOTA_MAX_ACTIVE_REHYDRATIONS = 100
+    QUOTA_EXEMPT_EMAILS           = join(",", var.quota_exempt_emails)
+  }
+
+  rehydration_region_buckets_env = join(",", [for region, bucket in var.rehydration_region_buckets : "${region}=${bucket}"])
+  rehydration_region_bucket_arns = flatten([
+    for bucket in values(var.rehydration_region_buckets) : ["arn:aws:s3:::${bucket}", "arn:aws:s3:::${bucket}/*"]
+  ])
+  rehydration_region_kms_key_arns_env = join(",", [for region, key in var.rehydration_region_kms_key_arns : "${region}=${key}"])
+  rehydration_kms_key_arns            = compact(concat([var.rehydration_kms_key_arn], values(var.rehydration_region_kms_key_arns)))
+
+  external_destination_role_arns = distinct(flatten(values(var.external_destination_roles)))
+
   common_tags = {
     aws_account      = var.aws_account
     aws_region       = data.aws_region.current_region.name